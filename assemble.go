@@ -0,0 +1,65 @@
+package m68k
+
+import (
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Model is a member of the 68000 family.
+type Model = cpu.Model
+
+// The models the assembler and the machine support.
+const (
+	MC68000 = cpu.MC68000
+	MC68010 = cpu.MC68010
+	MC68020 = cpu.MC68020
+	MC68040 = cpu.MC68040
+	MC68060 = cpu.MC68060
+)
+
+// ParseModel returns the model named by "68000", "68010", "68020", "68040"
+// or "68060".
+func ParseModel(name string) (Model, error) {
+	return cpu.ParseModel(name)
+}
+
+// AssembleOptions controls Assemble. The zero value assembles for a 68000.
+type AssembleOptions struct {
+	// Target is the CPU the source is written for, until a MACHINE
+	// directive changes it. Zero is the 68000.
+	Target Model
+	// FPU accepts the FPU instructions for a 68020 target, until an FPU
+	// directive changes it.
+	FPU bool
+	// IncludeDirs are searched, in order, for files named by INCLUDE.
+	IncludeDirs []string
+}
+
+// Program is the output of Assemble.
+type Program struct {
+	// Code is the assembled image, to be loaded at Origin.
+	Code []byte
+	// Origin is the address of the first ORG, or 0.
+	Origin uint32
+	// Entry is the address of the label named by END, or Origin.
+	Entry uint32
+	// Labels holds the address of every label.
+	Labels map[string]uint32
+}
+
+// Assemble assembles source in Motorola syntax.
+func Assemble(src string, opts AssembleOptions) (*Program, error) {
+	asm := assembler.New()
+	asm.Target = opts.Target
+	asm.FPU = opts.FPU
+	asm.IncludeDirs = opts.IncludeDirs
+	code, err := asm.Assemble(src, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{Code: code, Origin: asm.Origin(), Entry: asm.Origin(), Labels: asm.Labels()}
+	if entry, ok := asm.Entry(); ok {
+		p.Entry = entry
+	}
+	return p, nil
+}
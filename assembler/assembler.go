@@ -10,8 +10,17 @@ import (
 const (
 	// RegLabel is a placeholder register value indicating a label to be resolved.
 	RegLabel = 0xFE
+	// RegList is a placeholder register value indicating a MOVEM register list.
+	RegList = 0xFD
 	// RegStatus is a placeholder register value indicating a status register (SR/CCR/USP).
 	RegStatus = 0xFFFF
+	// RegFPList is a placeholder register value indicating FPU registers: one
+	// or a list of data registers (FPn) or control registers (FPCR/FPSR/FPIAR).
+	RegFPList = 0xFC
+	// RegPair is a placeholder register value indicating a register pair:
+	// Dh:Dl or Dr:Dq for the 68020's long multiply and divide, and the
+	// pairs of CAS2.
+	RegPair = 0xFB
 )
 
 // Assembler holds the state for the assembly process.
@@ -20,7 +29,76 @@ type Assembler struct {
 	labels      map[string]uint32
 	outputPos   uint32
 	baseAddress uint32
-	opSize      int // Current operation size in bytes
+	origin      uint32 // Address of the first byte of output
+	entry       string // Label named by END
+	output      []byte // Main output of the last assembly
+	opSize      int    // Current operation size in bytes
+	directives  map[string]DirectiveHandler
+	defLines    map[string]int   // Line each symbol or label was (last) defined on
+	refLines    map[string][]int // Lines referring to each identifier
+
+	// WarnSizing enables a warning for every forward reference that was sized
+	// for the worst case but would have fit a shorter form.
+	WarnSizing bool
+	// LabelMode controls how bare label operands are encoded.
+	LabelMode LabelAddressing
+	// IncludeDirs are searched, in order, for files named by INCLUDE.
+	IncludeDirs []string
+	// Target is the CPU the source is written for, until a MACHINE directive
+	// changes it. Instructions a 68000 lacks are only accepted for a target
+	// that has them.
+	Target cpu.Model
+	target cpu.Model // Target in effect for the line being parsed
+	// FPU accepts the FPU instructions for a 68020 target, as with a 68881
+	// or 68882 attached, until an FPU directive changes it. The 68040 and
+	// 68060 targets always accept them.
+	FPU bool
+	fpu bool // FPU in effect for the line being parsed
+	// MaxSize is the largest output, in bytes, that Assemble accepts.
+	// Zero leaves it to the MAXSIZE directive, if any.
+	MaxSize     uint32
+	declaredMax uint32 // Set by MAXSIZE
+	// PatchPad reserves a slide of this many NOPs at the entry of every
+	// routine called with BSR or JSR, as if it started with PATCH PatchPad.
+	PatchPad   int
+	patches    []PatchPoint
+	sections   []Section
+	banks      []Bank
+	window     bankWindow     // Banks being sized
+	windows    []bankWindow   // Every closed group of banks
+	labelBanks map[string]int // Bank of each label, -1 for the main output
+	warnings   []Warning
+	missed     SizingStats
+	encodings  []Encoding
+}
+
+// LabelAddressing selects the addressing mode used for bare label operands.
+// A label written as label(pc), label.w or label.l always keeps that mode.
+type LabelAddressing int
+
+const (
+	// LabelsAuto uses (d16,PC) for references already known to be in range,
+	// and absolute long for everything else, including forward references.
+	LabelsAuto LabelAddressing = iota
+	// LabelsPCRelative uses (d16,PC) wherever the operand allows it.
+	// Forward references that end up out of range are an error.
+	LabelsPCRelative
+	// LabelsAbsolute always uses absolute long.
+	LabelsAbsolute
+)
+
+// ParseLabelAddressing converts "auto", "pc" or "abs" to a LabelAddressing.
+func ParseLabelAddressing(s string) (LabelAddressing, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return LabelsAuto, nil
+	case "pc", "pcrel":
+		return LabelsPCRelative, nil
+	case "abs", "absolute":
+		return LabelsAbsolute, nil
+	default:
+		return LabelsAuto, fmt.Errorf("unknown label addressing mode: %s", s)
+	}
 }
 
 // BaseAddress returns the base address configured for code to load and start at.
@@ -28,45 +106,84 @@ func (asm *Assembler) BaseAddress() uint32 {
 	return asm.baseAddress
 }
 
+// Origin returns the address the output should be loaded at: the base
+// address, or the address of an ORG that comes before any code or data.
+func (asm *Assembler) Origin() uint32 {
+	return asm.origin
+}
+
+// Entry returns the address of the label named by the END directive, if the
+// source has one, as the place to start the program.
+func (asm *Assembler) Entry() (uint32, bool) {
+	if asm.entry == "" {
+		return 0, false
+	}
+	addr, ok := asm.labels[asm.entry]
+	return addr, ok
+}
+
 // New creates a new Assembler instance.
 func New() *Assembler {
 	return &Assembler{
-		symbols: make(map[string]int64),
-		labels:  make(map[string]uint32),
+		symbols:    make(map[string]int64),
+		labels:     make(map[string]uint32),
+		directives: make(map[string]DirectiveHandler),
 	}
 }
 
 // Assemble takes M68k assembly code and returns the machine code.
 func (asm *Assembler) Assemble(src string, baseAddress uint32) ([]byte, error) {
 	asm.baseAddress = baseAddress
+	asm.origin = baseAddress
+	asm.entry = ""
+	asm.output = nil
+	asm.labels = make(map[string]uint32)
+	asm.warnings = nil
+	asm.missed = SizingStats{}
+	asm.encodings = nil
+	asm.defLines = make(map[string]int)
+	asm.refLines = make(map[string][]int)
+	asm.declaredMax = 0
+	asm.sections = nil
+	asm.banks = nil
+	asm.window = bankWindow{}
+	asm.windows = nil
+	asm.labelBanks = make(map[string]int)
+	asm.patches = nil
+	asm.target = asm.Target
+	asm.fpu = asm.FPU
 	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
 	nodes, err := asm.parseLines(lines)
 	if err != nil {
 		return nil, fmt.Errorf("parsing error: %w", err)
 	}
+	nodes = asm.padFunctions(nodes)
 
-	for pass := 0; ; pass++ {
-		changed, err := asm.runSizingPass(nodes)
-		if err != nil {
-			return nil, fmt.Errorf("pass %d failed: %w", pass+1, err)
-		}
-		if !changed {
-			break
-		}
-		if pass > 10 {
-			return nil, fmt.Errorf("failed to stabilize label addresses after 10 passes")
-		}
+	// Sizing happens exactly once. Forward references are sized for the worst case
+	// and the final pass backpatches their values without changing any sizes.
+	if err := asm.runSizingPass(nodes); err != nil {
+		return nil, fmt.Errorf("sizing failed: %w", err)
 	}
 
 	// Final Code Generation Pass
 	var out []byte
+	dst := &out // Main output or the current bank
 	pc := baseAddress
 	asm.outputPos = 0
+	bank, group := 0, 0
+	var label string // Label at pc, if any
 
 	for _, n := range nodes {
 		if n.Type == NodeLabel {
+			asm.startSection(n.Label, pc)
+			label = n.Label
 			continue
 		}
+		at := label
+		label = ""
+		if n.Size > 0 {
+			asm.addToSection(pc, n.Size)
+		}
 
 		if n.Type == NodeDirective {
 			// Handle directives that affect PC, emit padding, or generate raw bytes.
@@ -75,23 +192,53 @@ func (asm *Assembler) Assemble(src string, baseAddress uint32) ([]byte, error) {
 			case "org":
 				addr, _ := asm.parseConstant(n.Parts[1])
 				pc = uint32(addr)
+				if dst == &out && len(out) == 0 {
+					asm.origin = pc
+				}
 				asm.outputPos = pc - baseAddress
 				continue // ORG emits no code itself
 			case "even":
-				if asm.outputPos%2 != 0 {
-					out = append(out, 0x00)
+				if pc%2 != 0 {
+					*dst = append(*dst, 0x00)
 					asm.outputPos++
 					pc++
 				}
 				continue // EVEN emits at most one byte
+			case "assert":
+				if err := asm.checkAssert(n, pc); err != nil {
+					return nil, fmt.Errorf("line %d: %w", n.Line, err)
+				}
+				continue
+			case "bank":
+				b := &asm.banks[bank]
+				bank++
+				dst = &b.Data
+				pc = b.Start
+				continue
+			case "endbank":
+				w := asm.windows[group]
+				group++
+				dst = &out
+				pc = w.resume
+				if w.inline {
+					// The main output skips over the window the banks occupy.
+					out = append(out, make([]byte, w.end-pc)...)
+					pc = w.end
+				}
+				asm.outputPos = pc - baseAddress
+				continue
 			default:
 				// For data-emitting directives, generate bytes directly.
-				bytes, err := asm.generateDirectiveCode(n)
+				bytes, err := asm.generateDirectiveCode(n, pc)
 				if err != nil {
 					return nil, fmt.Errorf("final generation failed for '%v': %w", n.Parts, err)
 				}
+				if dirName == "patch" {
+					asm.patches = append(asm.patches, PatchPoint{Address: pc, Size: uint32(len(bytes)), Label: at, Line: n.Line})
+				}
 				if len(bytes) > 0 {
-					out = append(out, bytes...)
+					asm.recordDirective(n, pc, uint32(len(bytes)))
+					*dst = append(*dst, bytes...)
 					asm.outputPos += uint32(len(bytes))
 					pc += uint32(len(bytes))
 				}
@@ -103,52 +250,80 @@ func (asm *Assembler) Assemble(src string, baseAddress uint32) ([]byte, error) {
 				return nil, fmt.Errorf("final generation failed for '%v': %w", n.Parts, err)
 			}
 
+			if uint32(len(words)*2) != n.Size {
+				return nil, fmt.Errorf("final generation failed for '%v': size changed from %d to %d bytes after sizing", n.Parts, n.Size, len(words)*2)
+			}
+			asm.recordInstruction(n, pc, words)
+			if n.HasLabels {
+				asm.checkSizing(n, pc)
+			}
+
 			if len(words) > 0 {
 				bytes := cpu.WordsToBytes(words)
-				out = append(out, bytes...)
+				*dst = append(*dst, bytes...)
 				asm.outputPos += uint32(len(bytes))
 				pc += uint32(len(bytes))
 			}
 		}
 	}
 
+	if _, ok := asm.labels[asm.entry]; asm.entry != "" && !ok {
+		return nil, fmt.Errorf("END names an undefined label: %s", asm.entry)
+	}
+	asm.output = out
+	if err := asm.checkMaxSize(uint32(len(out))); err != nil {
+		return out, err
+	}
 	return out, nil
 }
 
-// runSizingPass executes one sizing/label resolution pass and returns true if anything changed.
-func (asm *Assembler) runSizingPass(nodes []*Node) (bool, error) {
+// runSizingPass assigns an address to every label and a size to every node in a single walk.
+// Anything that refers to a label not yet seen is sized for the worst case, and the
+// addressing form chosen for each label operand is recorded on the node so the final
+// pass can only fill in values.
+func (asm *Assembler) runSizingPass(nodes []*Node) error {
 	pc := asm.baseAddress
-	changed := false
 
 	for _, n := range nodes {
 		if n.Type == NodeLabel {
-			if addr, ok := asm.labels[n.Label]; !ok || addr != pc {
-				asm.labels[n.Label] = pc
-				changed = true
+			if _, ok := asm.labels[n.Label]; ok {
+				return fmt.Errorf("duplicate label: %s", n.Label)
 			}
+			asm.labels[n.Label] = pc
+			asm.labelBanks[n.Label] = asm.currentBank()
 			continue
 		}
 
-		oldSize := n.Size
 		var size uint32
-
 		if n.Type == NodeDirective {
 			dirName := strings.TrimPrefix(strings.ToLower(n.Parts[0]), ".")
 			switch dirName {
 			case "org":
 				addr, err := asm.parseConstant(n.Parts[1])
 				if err != nil {
-					return false, err
+					return err
 				}
 				pc = uint32(addr)
 				continue
-			case "equ":
+			case "equ", "assert":
+				continue
+			case "bank":
+				var err error
+				if pc, err = asm.enterBank(n, pc); err != nil {
+					return err
+				}
+				continue
+			case "endbank":
+				var err error
+				if pc, err = asm.leaveBank(pc); err != nil {
+					return err
+				}
 				continue
 			}
 			// For all other directives, get their size.
 			dirSize, err := asm.getDirectiveSize(n, pc)
 			if err != nil {
-				return false, err
+				return err
 			}
 			size = dirSize
 		} else { // NodeInstruction
@@ -162,25 +337,76 @@ func (asm *Assembler) runSizingPass(nodes []*Node) (bool, error) {
 			}
 		}
 
-		if oldSize != size {
-			n.Size = size
-			changed = true
-		}
+		n.Size = size
 		pc += size
 	}
-	return changed, nil
+	if asm.window.open {
+		return fmt.Errorf("bank %d is missing ENDBANK", asm.window.bank)
+	}
+	return nil
 }
 
 // generateInstructionCode is the single source of truth for instruction binary generation.
+// Instructions that don't refer to labels are encoded once while sizing, and the
+// final pass reuses those words instead of encoding them again.
 func (asm *Assembler) generateInstructionCode(n *Node, pc uint32, finalPass bool) ([]uint16, error) {
+	if finalPass && n.Code != nil {
+		return n.Code, nil
+	}
+
+	operands := n.Operands
+	if n.HasLabels {
+		var err error
+		operands, err = asm.resolveLabelOperands(n, pc, finalPass)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	words, err := asm.encodeInstruction(n, operands, pc)
+	if err == nil && !finalPass && !n.HasLabels {
+		n.Code = words
+	}
+	return words, err
+}
+
+// resolveLabelOperands returns a copy of the node's operands with label references
+// replaced by concrete addressing modes and extension words. Only these fields change
+// between passes, so the parsed operands themselves are never touched.
+func (asm *Assembler) resolveLabelOperands(n *Node, pc uint32, finalPass bool) ([]Operand, error) {
 	operands := make([]Operand, len(n.Operands))
 	copy(operands, n.Operands)
 
+	if !finalPass {
+		n.LabelModes = make([]uint16, len(operands))
+	}
+
 	for i := range operands {
 		op := &operands[i]
 		isBareLabel := op.Mode == cpu.ModeOther && op.Register == RegLabel
 		// Check if the parser explicitly identified this as PC-relative with a label
 		isExplicitPCRel := op.Mode == cpu.ModeOther && op.Register == cpu.ModePCRelative && op.Label != ""
+		// label.w and label.l force absolute addressing.
+		isExplicitAbs := op.Mode == cpu.ModeOther && (op.Register == cpu.RegAbsShort || op.Register == cpu.RegAbsLong) && op.Label != ""
+
+		if isExplicitAbs {
+			target, ok := asm.labels[op.Label]
+			if !ok {
+				if finalPass {
+					return nil, fmt.Errorf("undefined label: %s", op.Label)
+				}
+				continue // The parser already left placeholder words of the right size.
+			}
+			if op.Register == cpu.RegAbsShort {
+				if target > 0x7FFF && target < 0xFFFF8000 {
+					return nil, fmt.Errorf("absolute short reference to '%s' is out of range", op.Label)
+				}
+				op.ExtensionWords = []uint16{uint16(target)}
+			} else {
+				op.ExtensionWords = []uint16{uint16(target >> 16), uint16(target)}
+			}
+			continue
+		}
 
 		if isBareLabel || isExplicitPCRel {
 			target, ok := asm.labels[op.Label]
@@ -188,9 +414,19 @@ func (asm *Assembler) generateInstructionCode(n *Node, pc uint32, finalPass bool
 				if finalPass {
 					return nil, fmt.Errorf("undefined label: %s", op.Label)
 				}
-				// Sizing pass: assume worst-case (absolute long) for forward refs.
-				op.Register = cpu.ModeAbsLong
-				op.ExtensionWords = []uint16{0, 0}
+				// Sizing pass: forward refs get the worst case (absolute long)
+				// unless PC-relative addressing was asked for.
+				if isExplicitPCRel || asm.LabelMode == LabelsPCRelative && asm.labelPCRelative(n, i) {
+					if isBareLabel {
+						n.LabelModes[i] = cpu.ModePCRelative
+					}
+					op.Register = cpu.ModePCRelative
+					op.ExtensionWords = []uint16{0}
+				} else {
+					n.LabelModes[i] = cpu.ModeAbsLong
+					op.Register = cpu.ModeAbsLong
+					op.ExtensionWords = []uint16{0, 0}
+				}
 				continue
 			}
 
@@ -214,8 +450,22 @@ func (asm *Assembler) generateInstructionCode(n *Node, pc uint32, finalPass bool
 				continue
 			}
 
-			// For bare labels, the assembler chooses the best mode.
-			if canBePCRelative(n.Mnemonic) && offset >= -32768 && offset <= 32767 {
+			// For bare labels, the assembler chooses the best mode while sizing.
+			// The final pass sticks to that choice so no addresses move.
+			mode := cpu.ModeAbsLong
+			if finalPass && len(n.LabelModes) > i && n.LabelModes[i] != 0 {
+				mode = n.LabelModes[i]
+			} else if asm.labelPCRelative(n, i) && offset >= -32768 && offset <= 32767 {
+				mode = cpu.ModePCRelative
+			}
+			if !finalPass {
+				n.LabelModes[i] = mode
+			}
+
+			if mode == cpu.ModePCRelative {
+				if offset < -32768 || offset > 32767 {
+					return nil, fmt.Errorf("pc-relative reference to '%s' is out of range (use %s.l)", op.Label, op.Label)
+				}
 				op.Register = cpu.ModePCRelative
 				op.ExtensionWords = []uint16{uint16(int16(offset))}
 			} else {
@@ -225,6 +475,17 @@ func (asm *Assembler) generateInstructionCode(n *Node, pc uint32, finalPass bool
 		}
 	}
 
+	return operands, nil
+}
+
+// encodeInstruction dispatches a node with resolved operands to its encoder.
+func (asm *Assembler) encodeInstruction(n *Node, operands []Operand, pc uint32) ([]uint16, error) {
+	if n.Mnemonic.Value == "movec" {
+		return assembleMovec(operands)
+	}
+	if is68020Instruction(n.Mnemonic) {
+		return asm.assemble68020(n.Mnemonic, operands)
+	}
 	if len(operands) > 0 {
 		for i := range operands {
 			raw := strings.ToLower(strings.TrimSpace(operands[i].Raw))
@@ -249,42 +510,61 @@ func (asm *Assembler) generateInstructionCode(n *Node, pc uint32, finalPass bool
 		return asm.assembleAddressMode(n.Mnemonic, operands, pc)
 	case "link", "unlk":
 		return asm.assembleStack(n.Mnemonic, operands)
-	case "cmp", "cmpa", "cmpi", "tst", "chk":
+	case "cmp", "cmpa", "cmpi", "cmpm", "tst", "chk":
 		return asm.assembleCompare(n.Mnemonic, operands)
 	case "abcd", "sbcd", "nbcd":
 		return asm.assembleBcd(n.Mnemonic, operands)
 	case "clr", "neg", "negx", "swap", "ext", "tas", "exg", "reset", "stop", "nop", "illegal":
 		return asm.assembleMisc(n.Mnemonic, operands)
-	case "btst", "bset", "bclr", "bchg", "lsl", "lsr", "asl", "asr", "rol", "ror":
+	case "btst", "bset", "bclr", "bchg", "lsl", "lsr", "asl", "asr", "rol", "ror", "roxl", "roxr":
 		return asm.assembleBitwise(n.Mnemonic, operands)
-	case "trap", "trapv":
+	case "trap", "trapv", "simhalt":
 		return asm.assembleTrap(n.Mnemonic, operands)
+	case "move16":
+		return asm.assembleMove16(operands)
+	case "moves":
+		return asm.assembleMoves(n.Mnemonic, operands)
+	case "rtd":
+		return asm.assembleRtd(operands)
 	case "rte", "rtr", "rts", "jmp", "jsr", "bra", "bsr", "bhi", "bls", "bcc", "bcs", "bne", "beq", "bvc", "bvs", "bpl", "bmi", "bge", "blt", "bgt", "ble":
 		return asm.assembleFlow(n.Mnemonic, operands, asm.labels, pc, n.Size)
 	default:
+		if isFPUMnemonic(n.Mnemonic.Value) {
+			return asm.assembleFPU(n.Mnemonic, operands, pc)
+		}
 		if strings.HasPrefix(n.Mnemonic.Value, "s") {
 			return asm.assembleScc(n.Mnemonic, operands)
 		}
 		if strings.HasPrefix(n.Mnemonic.Value, "db") {
-			return asm.assembleDbcc(n.Mnemonic, operands, asm.labels, pc)
-		}
-
-		if !finalPass {
-			// Sizing pass: assume a worst-case size for unknown instructions.
-			return []uint16{0, 0, 0}, nil
+			return asm.assembleDbcc(n.Mnemonic, operands, pc)
 		}
 		return nil, fmt.Errorf("unknown instruction: %s", n.Mnemonic.Value)
 	}
 }
 
-// canBePCRelative checks if an instruction's EA can be PC-relative.
-func canBePCRelative(mn Mnemonic) bool {
+// canBePCRelative checks if operand index of an instruction with count operands
+// may use a PC-relative EA. Only source operands and the single operand of
+// control instructions qualify; destinations must be alterable.
+func canBePCRelative(mn Mnemonic, index, count int) bool {
 	switch mn.Value {
-	case "jmp", "jsr":
-		return false
+	case "jmp", "jsr", "pea":
+		return count == 1
 	default:
-		return true
+		return count == 2 && index == 0
+	}
+}
+
+// labelPCRelative reports whether bare label operand i of n may be encoded
+// PC-relative under the current LabelMode.
+func (asm *Assembler) labelPCRelative(n *Node, i int) bool {
+	if asm.LabelMode == LabelsAbsolute || !canBePCRelative(n.Mnemonic, i, len(n.Operands)) {
+		return false
 	}
+	// Automatic mode keeps JMP and JSR absolute, as most assemblers do.
+	if asm.LabelMode == LabelsAuto && (n.Mnemonic.Value == "jmp" || n.Mnemonic.Value == "jsr") {
+		return false
+	}
+	return true
 }
 
 // isBranchMnemonic checks if an instruction is a form of branch.
@@ -298,7 +578,16 @@ func isBranchMnemonic(val string) bool {
 }
 
 func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
+	return asm.parseFile(lines, 0)
+}
+
+// parseFile parses the lines of one source file. depth counts the INCLUDE
+// directives that led to it.
+func (asm *Assembler) parseFile(lines []string, depth int) ([]*Node, error) {
 	var nodes []*Node
+	// Operands repeat a lot in real code (registers, common immediates),
+	// so each distinct operand string is only parsed once.
+	interned := make(map[string]Operand)
 	for i, line := range lines {
 		if commentIndex := strings.IndexRune(line, ';'); commentIndex != -1 {
 			line = line[:commentIndex]
@@ -314,7 +603,8 @@ func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
 			parsedLabel := strings.TrimSpace(parts[0])
 			if !strings.ContainsAny(parsedLabel, " \t") {
 				label = strings.ToLower(parsedLabel)
-				nodes = append(nodes, &Node{Type: NodeLabel, Label: label, Parts: []string{label + ":"}})
+				asm.defLines[label] = i + 1
+				nodes = append(nodes, &Node{Type: NodeLabel, Label: label, Parts: []string{label + ":"}, Line: i + 1})
 				line = strings.TrimSpace(parts[1])
 			}
 		}
@@ -330,6 +620,7 @@ func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
 			mnemonic = line[:firstSpace]
 			operandStr = strings.TrimSpace(line[firstSpace:])
 		}
+		asm.addReferences(operandStr, i+1)
 
 		opFields := strings.Fields(operandStr)
 		if len(opFields) > 0 && strings.EqualFold(opFields[0], "equ") {
@@ -342,6 +633,9 @@ func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
 				return nil, fmt.Errorf("line %d: invalid equ value for %s: %v", i+1, mnemonic, err)
 			}
 			asm.symbols[strings.ToLower(mnemonic)] = val
+			asm.defLines[strings.ToLower(mnemonic)] = i + 1
+			// A (re)defined symbol can change how earlier operand strings parse.
+			clear(interned)
 			continue
 		}
 
@@ -352,8 +646,50 @@ func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
 
 		directiveCheck := strings.ToLower(strings.TrimPrefix(mnemonic, "."))
 		switch directiveCheck {
-		case "dc.b", "dc.w", "dc.l", "ds.b", "ds.w", "ds.l", "org", "even":
-			nodes = append(nodes, &Node{Type: NodeDirective, Parts: nodeParts})
+		case "include":
+			included, err := asm.parseInclude(operandStr, depth)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			nodes = append(nodes, included...)
+			// The included file may have defined symbols.
+			clear(interned)
+			continue
+		case "dc.b", "dc.w", "dc.l", "ds.b", "ds.w", "ds.l", "org", "even", "bank", "endbank", "assert", "patch":
+			nodes = append(nodes, &Node{Type: NodeDirective, Parts: nodeParts, Line: i + 1})
+			continue
+		case "end":
+			// Easy68K and most Motorola assemblers ignore everything after END,
+			// whose operand names the entry point.
+			asm.entry = strings.ToLower(operandStr)
+			return nodes, nil
+		case "machine":
+			m, err := cpu.ParseModel(operandStr)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			asm.target = m
+			continue
+		case "fpu":
+			switch strings.ToLower(operandStr) {
+			case "", "on", "68881", "68882":
+				asm.fpu = true
+			case "off":
+				asm.fpu = false
+			default:
+				return nil, fmt.Errorf("line %d: FPU takes ON, OFF, 68881 or 68882, not '%s'", i+1, operandStr)
+			}
+			continue
+		case "maxsize":
+			size, err := asm.parseConstant(operandStr)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("line %d: maxsize requires a positive size", i+1)
+			}
+			asm.declaredMax = uint32(size)
+			continue
+		}
+		if _, ok := asm.directives[directiveCheck]; ok {
+			nodes = append(nodes, &Node{Type: NodeDirective, Parts: nodeParts, Line: i + 1})
 			continue
 		}
 
@@ -361,7 +697,6 @@ func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
 		if err != nil {
 			return nil, fmt.Errorf("line %d: %w", i+1, err)
 		}
-
 		var operands []Operand
 		if operandStr != "" {
 			for _, s := range splitOperands(operandStr) {
@@ -369,15 +704,29 @@ func (asm *Assembler) parseLines(lines []string) ([]*Node, error) {
 				if s == "" {
 					continue
 				}
-				op, err := asm.parseOperand(s)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: error parsing operand '%s': %w", i+1, s, err)
+				op, ok := interned[s]
+				if !ok {
+					op, err = asm.parseOperand(s)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: error parsing operand '%s': %w", i+1, s, err)
+					}
+					interned[s] = op
 				}
 				operands = append(operands, op)
 			}
 		}
+		if err := asm.checkTarget(mn, operands); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
 
-		nodes = append(nodes, &Node{Type: NodeInstruction, Mnemonic: mn, Operands: operands, Parts: nodeParts})
+		n := &Node{Type: NodeInstruction, Mnemonic: mn, Operands: operands, Parts: nodeParts, Line: i + 1}
+		n.HasLabels = isBranchMnemonic(mn.Value)
+		for _, op := range operands {
+			if op.Label != "" {
+				n.HasLabels = true
+			}
+		}
+		nodes = append(nodes, n)
 	}
 	return nodes, nil
 }
@@ -0,0 +1,143 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Bank is one overlay: code assembled for an address window that other banks share.
+type Bank struct {
+	// Number is the bank number given to BANK.
+	Number int
+	// Start is the address of the window the bank is assembled for.
+	Start uint32
+	// Data is the bank's code and data.
+	Data []byte
+}
+
+// bankWindow tracks the shared address range of a group of banks.
+type bankWindow struct {
+	open   bool   // Inside a group of banks
+	inline bool   // The window follows the main output, which skips over it
+	base   uint32 // Start address shared by the banks
+	end    uint32 // End of the longest bank so far
+	resume uint32 // Where the main output continues after ENDBANK
+	bank   int    // Current bank number
+}
+
+// Banks returns the overlays from the last assembly, in source order.
+func (asm *Assembler) Banks() []Bank {
+	return asm.banks
+}
+
+// parseBank reads the operands of "BANK n[,address]".
+func (asm *Assembler) parseBank(n *Node) (int, uint32, bool, error) {
+	if len(n.Parts) < 2 {
+		return 0, 0, false, fmt.Errorf("bank requires a bank number")
+	}
+	args := strings.Split(n.Parts[1], ",")
+	num, err := asm.parseConstant(args[0])
+	if err != nil || num < 0 {
+		return 0, 0, false, fmt.Errorf("invalid bank number: %s", args[0])
+	}
+	if len(args) == 1 {
+		return int(num), 0, false, nil
+	}
+	addr, err := asm.parseConstant(args[1])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid bank address: %s", args[1])
+	}
+	return int(num), uint32(addr), true, nil
+}
+
+// enterBank switches to bank num during sizing and returns the new PC.
+func (asm *Assembler) enterBank(n *Node, pc uint32) (uint32, error) {
+	num, addr, explicit, err := asm.parseBank(n)
+	if err != nil {
+		return pc, err
+	}
+	for _, b := range asm.banks {
+		if b.Number == num {
+			return pc, fmt.Errorf("duplicate bank %d", num)
+		}
+	}
+
+	w := &asm.window
+	if !w.open {
+		*w = bankWindow{open: true, inline: !explicit, base: pc, resume: pc}
+		if explicit {
+			w.base = addr
+		}
+		w.end = w.base
+	} else {
+		w.end = max(w.end, pc)
+		if explicit && addr != w.base {
+			return pc, fmt.Errorf("bank %d at $%X is outside the window at $%X; use ENDBANK first", num, addr, w.base)
+		}
+	}
+	w.bank = num
+	asm.banks = append(asm.banks, Bank{Number: num, Start: w.base})
+	return w.base, nil
+}
+
+// leaveBank closes the current group of banks during sizing and returns the new PC.
+func (asm *Assembler) leaveBank(pc uint32) (uint32, error) {
+	w := &asm.window
+	if !w.open {
+		return pc, fmt.Errorf("endbank without bank")
+	}
+	w.end = max(w.end, pc)
+	w.open = false
+	asm.windows = append(asm.windows, *w)
+	if w.inline {
+		return w.end, nil
+	}
+	return w.resume, nil
+}
+
+// currentBank returns the bank being assembled, or -1 for the main output.
+func (asm *Assembler) currentBank() int {
+	if asm.window.open {
+		return asm.window.bank
+	}
+	return -1
+}
+
+// WriteBankTable writes the routing table for the last assembly: every bank
+// with its window and size, then every label in a bank.
+func (asm *Assembler) WriteBankTable(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "; Bank   Start     Bytes\n"); err != nil {
+		return err
+	}
+	for _, b := range asm.banks {
+		if _, err := fmt.Fprintf(w, "  %-6d %08X  %d\n", b.Number, b.Start, len(b.Data)); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name, bank := range asm.labelBanks {
+		if bank >= 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		bi, bj := asm.labelBanks[names[i]], asm.labelBanks[names[j]]
+		if bi != bj {
+			return bi < bj
+		}
+		return asm.labels[names[i]] < asm.labels[names[j]]
+	})
+
+	if _, err := fmt.Fprintf(w, "\n; Label                  Bank   Address\n"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "  %-22s %-6d %08X\n", name, asm.labelBanks[name], asm.labels[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -16,7 +16,7 @@ var ShiftRotateType = map[string]uint16{
 	"asr": 0x0000, "asl": 0x0100,
 	"lsr": 0x0008, "lsl": 0x0108,
 	"ror": 0x0018, "rol": 0x0118,
-	"roxr": 0x0010, "roxl": 0x0110, // Add these
+	"roxr": 0x0010, "roxl": 0x0110,
 }
 
 // BitwiseSize contains size bits for shift/rotate register forms.
@@ -33,7 +33,7 @@ var BitwiseSize = map[cpu.Size]uint16{
 // assembleBitwise handles all shift, rotate, and bit manipulation instructions.
 func (asm *Assembler) assembleBitwise(mn Mnemonic, operands []Operand) ([]uint16, error) {
 	switch strings.ToLower(mn.Value) {
-	case "asl", "asr", "lsl", "lsr", "rol", "ror":
+	case "asl", "asr", "lsl", "lsr", "rol", "ror", "roxl", "roxr":
 		return asm.assembleShiftRotate(mn, operands)
 	case "btst", "bset", "bclr", "bchg":
 		return asm.assembleBitManipulation(mn, operands)
@@ -61,6 +61,9 @@ func (asm *Assembler) assembleShiftRotate(mn Mnemonic, operands []Operand) ([]ui
 		if mn.Size != cpu.SizeWord && mn.Size != 0 {
 			return nil, fmt.Errorf("%s on memory must be word-sized", mn.Value)
 		}
+		// The memory form keeps the kind in bits 10-9, since bits 5-0 hold the EA.
+		kind := ShiftRotateType[mn.Value]
+		opword = uint16(cpu.OPShiftRotateBase) | kind&0x0100 | (kind&0x0018)<<6
 		opword |= 0x00C0 // Set memory form bits
 		dst := operands[0]
 
@@ -7,10 +7,10 @@ import (
 	"github.com/Urethramancer/m68k/cpu"
 )
 
-// assembleCompare handles CMP, CMPA, CMPI, TST, and CHK instructions.
+// assembleCompare handles CMP, CMPA, CMPI, CMPM, TST, and CHK instructions.
 func (asm *Assembler) assembleCompare(mn Mnemonic, operands []Operand) ([]uint16, error) {
 	switch strings.ToLower(mn.Value) {
-	case "cmp", "cmpa", "cmpi":
+	case "cmp", "cmpa", "cmpi", "cmpm":
 		return asm.assembleCmpFamily(mn, operands)
 	case "tst":
 		return asm.assembleTst(mn, operands)
@@ -38,6 +38,8 @@ func (asm *Assembler) assembleCmpFamily(mn Mnemonic, operands []Operand) ([]uint
 		return asm.assembleCmpa(mn, src, dst)
 	case "cmpi":
 		return asm.assembleCmpi(mn, src, dst)
+	case "cmpm":
+		return asm.assembleCmpm(mn, src, dst)
 	default:
 		return nil, fmt.Errorf("unhandled compare type: %s", name)
 	}
@@ -89,6 +91,22 @@ func (asm *Assembler) assembleCmpa(mn Mnemonic, src, dst Operand) ([]uint16, err
 	return append([]uint16{opword}, ext...), nil
 }
 
+// CMPM: 1011 Ax 1 Sz 001 Ay
+func (asm *Assembler) assembleCmpm(mn Mnemonic, src, dst Operand) ([]uint16, error) {
+	if src.Mode != cpu.ModeAddrPostInc || dst.Mode != cpu.ModeAddrPostInc {
+		return nil, fmt.Errorf("CMPM operands must both be (An)+")
+	}
+
+	opword := uint16(cpu.OPCMPM)
+	opword, err := setOpwordSize(opword, mn.Size, SizeBits)
+	if err != nil {
+		return nil, err
+	}
+	opword |= dst.Register<<9 | src.Register
+
+	return []uint16{opword}, nil
+}
+
 // CMPI: 0000 1100 Sz <ea>
 func (asm *Assembler) assembleCmpi(mn Mnemonic, src, dst Operand) ([]uint16, error) {
 	if !src.IsImmediate() {
@@ -101,6 +119,12 @@ func (asm *Assembler) assembleCmpi(mn Mnemonic, src, dst Operand) ([]uint16, err
 		return nil, err
 	}
 
+	// The immediate takes one word for byte and word sizes and two for long.
+	_, imm, err := asm.encodeEA(src, mn.Size)
+	if err != nil {
+		return nil, err
+	}
+
 	eaBits, eaExt, err := asm.encodeEA(dst, mn.Size)
 	if err != nil {
 		return nil, err
@@ -109,7 +133,7 @@ func (asm *Assembler) assembleCmpi(mn Mnemonic, src, dst Operand) ([]uint16, err
 
 	// Combine: opcode + immediate + EA extensions
 	words := []uint16{opword}
-	words = append(words, src.ExtensionWords...)
+	words = append(words, imm...)
 	words = append(words, eaExt...)
 
 	return words, nil
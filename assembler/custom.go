@@ -0,0 +1,91 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DirectiveHandler generates the data for a custom directive.
+// It is called once while sizing and once for the final output, and must return
+// the same number of bytes both times. Odd-sized output is padded like dc.b.
+type DirectiveHandler func(ctx *DirectiveContext) ([]byte, error)
+
+// DirectiveContext describes one use of a custom directive.
+type DirectiveContext struct {
+	// Name is the directive as written, lowercased and without a leading dot.
+	Name string
+	// Args are the comma-separated arguments, trimmed but otherwise untouched.
+	Args []string
+	// PC is the address the generated data will be placed at.
+	PC uint32
+	// Line is the source line number, starting at 1.
+	Line int
+	// Final is false during sizing, when forward labels are not known yet.
+	Final bool
+
+	asm *Assembler
+}
+
+// Value evaluates a number, EQU symbol or label. Labels not yet defined
+// evaluate to 0 while sizing and are an error in the final pass.
+func (ctx *DirectiveContext) Value(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if addr, ok := ctx.asm.labels[strings.ToLower(s)]; ok {
+		return int64(addr), nil
+	}
+	val, err := ctx.asm.parseConstant(s)
+	if err != nil {
+		if !ctx.Final && reLabel.MatchString(s) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return val, nil
+}
+
+// RegisterDirective makes name available as a directive in assembly source.
+// The name is case-insensitive and may be written with or without a leading dot.
+// Custom directives take precedence over instruction mnemonics, but the
+// built-in directives cannot be replaced.
+func (asm *Assembler) RegisterDirective(name string, h DirectiveHandler) error {
+	name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" || strings.ContainsAny(name, " \t:;") {
+		return fmt.Errorf("invalid directive name: %q", name)
+	}
+	if h == nil {
+		return fmt.Errorf("no handler for directive %s", name)
+	}
+	switch name {
+	case "dc.b", "dc.w", "dc.l", "ds.b", "ds.w", "ds.l", "org", "even", "equ", "include", "maxsize", "end", "bank", "endbank", "assert", "patch":
+		return fmt.Errorf("cannot replace built-in directive %s", name)
+	}
+
+	if asm.directives == nil {
+		asm.directives = make(map[string]DirectiveHandler)
+	}
+	asm.directives[name] = h
+	return nil
+}
+
+// runCustomDirective calls the handler for n and pads its output to an even length.
+func (asm *Assembler) runCustomDirective(h DirectiveHandler, n *Node, pc uint32, final bool) ([]byte, error) {
+	ctx := &DirectiveContext{
+		Name:  strings.TrimPrefix(strings.ToLower(n.Parts[0]), "."),
+		PC:    pc,
+		Line:  n.Line,
+		Final: final,
+		asm:   asm,
+	}
+	if len(n.Parts) > 1 {
+		ctx.Args = splitOperands(n.Parts[1])
+	}
+
+	data, err := h(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ctx.Name, err)
+	}
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+	return data, nil
+}
@@ -1,6 +1,7 @@
 package assembler
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -42,14 +43,21 @@ func (asm *Assembler) getDirectiveSize(n *Node, pc uint32) (uint32, error) {
 		elementSize := getElementSize(dir)
 		return uint32(count) * elementSize, nil
 
+	case "patch":
+		return asm.patchSize(n)
+
 	default:
+		if h, ok := asm.directives[dir]; ok {
+			data, err := asm.runCustomDirective(h, n, pc, false)
+			return uint32(len(data)), err
+		}
 		return 0, fmt.Errorf("unknown directive: %s", n.Parts[0])
 	}
 }
 
 // generateDirectiveCode generates the binary data for assembler directives.
 // Returns a byte slice, as directives like DC.B are not always word-aligned.
-func (asm *Assembler) generateDirectiveCode(n *Node) ([]byte, error) {
+func (asm *Assembler) generateDirectiveCode(n *Node, pc uint32) ([]byte, error) {
 	// Normalize directive name once: lowercase, no leading dot.
 	raw := strings.ToLower(n.Parts[0])
 	dir := strings.TrimPrefix(raw, ".")
@@ -82,7 +90,20 @@ func (asm *Assembler) generateDirectiveCode(n *Node) ([]byte, error) {
 		byteSize := uint32(count) * elementSize
 		return make([]byte, byteSize), nil
 
+	case "patch":
+		return asm.assemblePatch(n, pc)
+
 	default:
+		if h, ok := asm.directives[dir]; ok {
+			data, err := asm.runCustomDirective(h, n, pc, true)
+			if err != nil {
+				return nil, err
+			}
+			if uint32(len(data)) != n.Size {
+				return nil, fmt.Errorf("%s produced %d bytes after sizing to %d", dir, len(data), n.Size)
+			}
+			return data, nil
+		}
 		return nil, fmt.Errorf("unknown directive: %s", n.Parts[0])
 	}
 }
@@ -102,6 +123,10 @@ func (asm *Assembler) calculateDcSize(directive, values string) (uint32, error)
 		}
 	}
 
+	// Byte data is padded to an even length to keep following code aligned.
+	if size%2 != 0 {
+		size++
+	}
 	return size, nil
 }
 
@@ -122,7 +147,12 @@ func (asm *Assembler) assembleDc(directive, values string) ([]byte, error) {
 
 		val, err := asm.parseConstant(tok.Value)
 		if err != nil {
-			return nil, fmt.Errorf("invalid constant '%s': %v", tok.Value, err)
+			// Labels are all known by the time data is generated.
+			addr, ok := asm.labels[strings.ToLower(tok.Value)]
+			if !ok {
+				return nil, fmt.Errorf("invalid constant '%s': %v", tok.Value, err)
+			}
+			val = int64(addr)
 		}
 
 		switch elementSize {
@@ -137,6 +167,9 @@ func (asm *Assembler) assembleDc(directive, values string) ([]byte, error) {
 		}
 	}
 
+	if len(bytesBuf)%2 != 0 {
+		bytesBuf = append(bytesBuf, 0)
+	}
 	return bytesBuf, nil
 }
 
@@ -197,3 +230,34 @@ func getElementSize(directive string) uint32 {
 		return 1
 	}
 }
+
+// ErrAssert is returned when an ASSERT directive's expression is false.
+var ErrAssert = errors.New("assertion failed")
+
+// checkAssert evaluates "ASSERT expression[,"message"]" at pc. The message,
+// or the expression itself, is reported if the expression is zero.
+func (asm *Assembler) checkAssert(n *Node, pc uint32) error {
+	if len(n.Parts) < 2 {
+		return fmt.Errorf("assert requires an expression")
+	}
+	expr, msg := n.Parts[1], ""
+	if last := len(expr) - 1; last > 0 && (expr[last] == '"' || expr[last] == '\'') {
+		open := strings.LastIndexByte(expr[:last], expr[last])
+		if comma := strings.LastIndexByte(expr[:max(open, 0)], ','); open > 0 && comma >= 0 {
+			msg = expr[open+1 : last]
+			expr = strings.TrimSpace(expr[:comma])
+		}
+	}
+
+	v, err := asm.evalExpr(expr, pc)
+	if err != nil {
+		return fmt.Errorf("assert %s: %w", expr, err)
+	}
+	if v != 0 {
+		return nil
+	}
+	if msg == "" {
+		msg = expr
+	}
+	return fmt.Errorf("%w: %s", ErrAssert, msg)
+}
@@ -0,0 +1,171 @@
+package assembler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Encoding records how the last assembly encoded one source line that emits
+// code or data, so audit tools and teaching material can see exactly what
+// the assembler chose.
+type Encoding struct {
+	Line    int    `json:"line"`
+	Address uint32 `json:"address"`
+	Source  string `json:"source"`
+	// Size is the number of bytes emitted.
+	Size uint32 `json:"size"`
+	// Opcode is the first word of an instruction, and Words all of them.
+	// Directives leave both empty.
+	Opcode uint16   `json:"opcode,omitempty"`
+	Words  []uint16 `json:"words,omitempty"`
+	// Hex is Words as hex, e.g. "41F9 0000 0016", for reading.
+	Hex string `json:"hex,omitempty"`
+	// Modes names the addressing mode of each operand, e.g. "(d16,PC)".
+	Modes []string `json:"modes,omitempty"`
+	// Branch is "short" or "word" for the displacement of a branch.
+	Branch string `json:"branch,omitempty"`
+	// Optimizations lists the shorter forms picked over the one written:
+	// "moveq", "addq", "subq", "short branch" and "pc-relative label".
+	Optimizations []string `json:"optimizations,omitempty"`
+	// Missed describes shorter forms that would have fit, but weren't
+	// picked because a forward reference was sized for the worst case.
+	Missed []string `json:"missed,omitempty"`
+}
+
+// Encodings returns the encoding of every line that emitted code or data in
+// the last assembly, in output order.
+func (asm *Assembler) Encodings() []Encoding {
+	return asm.encodings
+}
+
+// WriteEncodings writes the encodings of the last assembly to w as JSON.
+func (asm *Assembler) WriteEncodings(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	list := asm.encodings
+	if list == nil {
+		list = []Encoding{}
+	}
+	return enc.Encode(list)
+}
+
+// recordDirective records the bytes a directive emitted at pc.
+func (asm *Assembler) recordDirective(n *Node, pc, size uint32) {
+	asm.encodings = append(asm.encodings, Encoding{
+		Line:    n.Line,
+		Address: pc,
+		Source:  strings.Join(n.Parts, " "),
+		Size:    size,
+	})
+}
+
+// recordInstruction records the words an instruction was encoded as at pc.
+func (asm *Assembler) recordInstruction(n *Node, pc uint32, words []uint16) {
+	e := Encoding{
+		Line:    n.Line,
+		Address: pc,
+		Source:  strings.Join(n.Parts, " "),
+		Size:    uint32(len(words) * 2),
+		Words:   words,
+	}
+	if len(words) > 0 {
+		e.Opcode = words[0]
+		hex := make([]string, len(words))
+		for i, w := range words {
+			hex[i] = fmt.Sprintf("%04X", w)
+		}
+		e.Hex = strings.Join(hex, " ")
+	}
+
+	mn := n.Mnemonic.Value
+	branch := isBranchMnemonic(mn)
+	for i, op := range n.Operands {
+		mode := operandModeName(op)
+		if op.Mode == cpu.ModeOther && op.Register == RegLabel && !branch && i < len(n.LabelModes) {
+			if n.LabelModes[i] == cpu.ModePCRelative {
+				mode = "(d16,PC)"
+				e.Optimizations = append(e.Optimizations, "pc-relative label")
+			} else {
+				mode = "abs.l"
+			}
+		}
+		e.Modes = append(e.Modes, mode)
+	}
+
+	switch {
+	case strings.HasPrefix(mn, "db"):
+		e.Branch = "word"
+	case branch:
+		e.Branch = "word"
+		if n.Size == 2 {
+			e.Branch = "short"
+			if n.Mnemonic.Size == cpu.SizeInvalid {
+				e.Optimizations = append(e.Optimizations, "short branch")
+			}
+		}
+	case mn == "move" && e.Opcode&0xF100 == cpu.OPMOVEQ:
+		e.Optimizations = append(e.Optimizations, "moveq")
+	case (mn == "add" || mn == "sub") && e.Opcode&0xF000 == cpu.OPADDQ:
+		e.Optimizations = append(e.Optimizations, mn+"q")
+	}
+	asm.encodings = append(asm.encodings, e)
+}
+
+// operandModeName names the addressing mode of a parsed operand.
+func operandModeName(op Operand) string {
+	switch op.Mode {
+	case cpu.ModeData:
+		return "Dn"
+	case cpu.ModeAddr:
+		return "An"
+	case cpu.ModeAddrInd:
+		return "(An)"
+	case cpu.ModeAddrPostInc:
+		return "(An)+"
+	case cpu.ModeAddrPreDec:
+		return "-(An)"
+	case cpu.ModeAddrDisp:
+		return "(d16,An)"
+	case cpu.ModeAddrIndex:
+		return indexModeName("An", op)
+	}
+	switch op.Register {
+	case cpu.RegAbsShort:
+		return "abs.w"
+	case cpu.RegAbsLong:
+		return "abs.l"
+	case cpu.ModePCRelative:
+		return "(d16,PC)"
+	case cpu.RegPCIndex:
+		return indexModeName("PC", op)
+	case cpu.RegImmediate:
+		return "#imm"
+	case RegLabel:
+		return "label"
+	case RegList:
+		return "register list"
+	case RegFPList:
+		return "fp registers"
+	}
+	return strings.ToLower(strings.TrimSpace(op.Raw))
+}
+
+// indexModeName names the format of an indexed mode on base: the brief
+// (d8,An,Xn), or the 68020's (bd,An,Xn), ([bd,An],Xn,od) post-indexed or
+// ([bd,An,Xn],od) pre-indexed.
+func indexModeName(base string, op Operand) string {
+	if len(op.ExtensionWords) == 0 || op.ExtensionWords[0]&0x0100 == 0 {
+		return "(d8," + base + ",Xn)"
+	}
+	switch iis := op.ExtensionWords[0] & 7; {
+	case iis == 0:
+		return "(bd," + base + ",Xn)"
+	case iis > 4:
+		return "([bd," + base + "],Xn,od)"
+	}
+	return "([bd," + base + ",Xn],od)"
+}
@@ -0,0 +1,252 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprParser evaluates assembly-time expressions such as "*-start <= 512".
+//
+// Operands are numbers ($hex, 0xhex, %binary, decimal), character literals,
+// EQU symbols, labels and "*" for the current location. The operators, from
+// lowest to highest precedence, are:
+//
+//	||  &&  |  ^  &  == != (= <>)  < <= > >=  << >>  + -  * / %
+//
+// with unary -, ~ and ! binding tightest. Comparisons yield 1 or 0.
+type exprParser struct {
+	asm *Assembler
+	s   string
+	pos int
+	pc  uint32
+}
+
+// binaryLevels lists the binary operators by precedence, lowest first.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!=", "<>", "="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// evalExpr evaluates s with "*" standing for pc.
+func (asm *Assembler) evalExpr(s string, pc uint32) (int64, error) {
+	p := &exprParser{asm: asm, s: s, pc: pc}
+	v, err := p.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return 0, fmt.Errorf("unexpected %q in expression", p.s[p.pos:])
+	}
+	return v, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// operator consumes and returns the operator at the cursor if it is one of ops.
+// The longest operator wins, so "<" never matches the start of "<<" or "<=".
+func (p *exprParser) operator(ops []string) string {
+	p.skipSpace()
+	rest := p.s[p.pos:]
+	longest := ""
+	for _, level := range binaryLevels {
+		for _, op := range level {
+			if len(op) > len(longest) && strings.HasPrefix(rest, op) {
+				longest = op
+			}
+		}
+	}
+	for _, op := range ops {
+		if op == longest {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// binary parses the operators at precedence level and above.
+func (p *exprParser) binary(level int) (int64, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.operator(binaryLevels[level])
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		if left, err = apply(op, left, right); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// apply performs a binary operation.
+func apply(op string, a, b int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolValue(a != 0 || b != 0), nil
+	case "&&":
+		return boolValue(a != 0 && b != 0), nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "&":
+		return a & b, nil
+	case "==", "=":
+		return boolValue(a == b), nil
+	case "!=", "<>":
+		return boolValue(a != b), nil
+	case "<":
+		return boolValue(a < b), nil
+	case "<=":
+		return boolValue(a <= b), nil
+	case ">":
+		return boolValue(a > b), nil
+	case ">=":
+		return boolValue(a >= b), nil
+	case "<<":
+		return a << uint64(b&63), nil
+	case ">>":
+		return a >> uint64(b&63), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero in expression")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unary parses a unary operator, a parenthesised expression or an operand.
+func (p *exprParser) unary() (int64, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return 0, fmt.Errorf("missing operand in expression")
+	}
+
+	switch c := p.s[p.pos]; c {
+	case '-', '~', '!', '+':
+		p.pos++
+		v, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case '-':
+			return -v, nil
+		case '~':
+			return ^v, nil
+		case '!':
+			return boolValue(v == 0), nil
+		}
+		return v, nil
+
+	case '(':
+		p.pos++
+		v, err := p.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		p.skipSpace()
+		if p.pos == len(p.s) || p.s[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in expression")
+		}
+		p.pos++
+		return v, nil
+
+	case '*':
+		p.pos++
+		return int64(p.pc), nil
+
+	case '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end != 1 {
+			return 0, fmt.Errorf("invalid character literal in expression")
+		}
+		v := int64(p.s[p.pos+1])
+		p.pos += 3
+		return v, nil
+	}
+
+	return p.operand()
+}
+
+// operand parses a number or a name.
+func (p *exprParser) operand() (int64, error) {
+	start := p.pos
+	if c := p.s[p.pos]; c == '$' || c == '%' {
+		p.pos++
+	}
+	for p.pos < len(p.s) && (isIdentChar(p.s[p.pos]) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+	if tok == "" {
+		return 0, fmt.Errorf("unexpected %q in expression", p.s[p.pos:])
+	}
+
+	if c := tok[0]; isDigit(c) || c == '$' || c == '%' {
+		base := 10
+		switch {
+		case c == '$':
+			tok, base = tok[1:], 16
+		case c == '%':
+			tok, base = tok[1:], 2
+		case strings.HasPrefix(strings.ToLower(tok), "0x"):
+			tok, base = tok[2:], 16
+		}
+		v, err := strconv.ParseInt(tok, base, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number in expression: %s", p.s[start:p.pos])
+		}
+		return v, nil
+	}
+
+	name := strings.ToLower(tok)
+	if v, ok := p.asm.symbols[name]; ok {
+		return v, nil
+	}
+	if v, ok := p.asm.labels[name]; ok {
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("undefined symbol in expression: %s", tok)
+}
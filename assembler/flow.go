@@ -11,7 +11,7 @@ import (
 func (asm *Assembler) assembleFlow(mn Mnemonic, operands []Operand, labels map[string]uint32, pc uint32, size uint32) ([]uint16, error) {
 	switch mn.Value {
 	case "jmp", "jsr":
-		return asm.assembleJmpJsr(mn, operands, labels)
+		return asm.assembleJmpJsr(mn, operands)
 	case "rts":
 		return assembleRts()
 	case "rtr":
@@ -19,7 +19,7 @@ func (asm *Assembler) assembleFlow(mn Mnemonic, operands []Operand, labels map[s
 	case "rte":
 		return assembleRte()
 	case "bra", "bsr", "bhi", "bls", "bcc", "bcs", "bne", "beq", "bvc", "bvs", "bpl", "bmi", "bge", "blt", "bgt", "ble":
-		return assembleBra(mn, operands, labels, pc, size)
+		return asm.assembleBra(mn, operands, pc, size)
 	}
 	return nil, fmt.Errorf("unknown flow instruction: %s", mn.Value)
 }
@@ -39,23 +39,37 @@ func (asm *Assembler) getSizeBra(n *Node, pc uint32) uint32 {
 		return 2
 	}
 
-	label := strings.ToLower(strings.TrimSpace(n.Operands[0].Raw))
-	target, ok := asm.labels[label]
+	target, ok := asm.branchTarget(n.Operands[0])
 	if !ok {
 		// Forward reference: assume long branch (worst case) to be safe.
 		return 4
 	}
 
 	offset := int32(target) - int32(pc+2)
-	if offset >= -128 && offset <= 127 {
+	// A displacement of 0 would read as the word form's marker.
+	if offset >= -128 && offset <= 127 && offset != 0 {
 		return 2 // Fits in a short branch.
 	}
 	return 4 // Requires a long branch.
 }
 
+// branchTarget resolves the target of a branch: a label, or an address
+// given as a number or EQU symbol, as the disassembler prints them.
+func (asm *Assembler) branchTarget(op Operand) (uint32, bool) {
+	name := strings.ToLower(strings.TrimSpace(op.Raw))
+	if target, ok := asm.labels[name]; ok {
+		return target, true
+	}
+	v, err := asm.parseConstant(name)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
 // JMP / JSR
 
-func (asm *Assembler) assembleJmpJsr(mn Mnemonic, operands []Operand, labels map[string]uint32) ([]uint16, error) {
+func (asm *Assembler) assembleJmpJsr(mn Mnemonic, operands []Operand) ([]uint16, error) {
 	if len(operands) != 1 {
 		return nil, fmt.Errorf("%s requires 1 operand", strings.ToUpper(mn.Value))
 	}
@@ -66,15 +80,7 @@ func (asm *Assembler) assembleJmpJsr(mn Mnemonic, operands []Operand, labels map
 		opword = cpu.OPJMP
 	}
 
-	// Label as absolute long
-	if target, ok := labels[strings.ToLower(src.Raw)]; ok {
-		if mn.Value == "jmp" {
-			return []uint16{0x4EF9, uint16(target >> 16), uint16(target)}, nil
-		}
-		return []uint16{0x4EB9, uint16(target >> 16), uint16(target)}, nil
-	}
-
-	// Otherwise encode EA
+	// Labels have already been resolved to absolute long or (d16,PC).
 	eaBits, eaExt, err := asm.encodeEA(src, cpu.SizeLong)
 	if err != nil {
 		return nil, err
@@ -86,7 +92,7 @@ func (asm *Assembler) assembleJmpJsr(mn Mnemonic, operands []Operand, labels map
 
 // Branches (BRA/BSR/Bcc)
 
-func assembleBra(mn Mnemonic, operands []Operand, labels map[string]uint32, pc uint32, size uint32) ([]uint16, error) {
+func (asm *Assembler) assembleBra(mn Mnemonic, operands []Operand, pc uint32, size uint32) ([]uint16, error) {
 	if len(operands) != 1 {
 		return nil, fmt.Errorf("branch instruction requires 1 operand")
 	}
@@ -97,7 +103,7 @@ func assembleBra(mn Mnemonic, operands []Operand, labels map[string]uint32, pc u
 		return nil, fmt.Errorf("unknown branch type: %s", mn.Value)
 	}
 
-	target, ok := labels[label]
+	target, ok := asm.branchTarget(operands[0])
 	if !ok {
 		return nil, fmt.Errorf("undefined label: %s", label)
 	}
@@ -107,6 +113,9 @@ func assembleBra(mn Mnemonic, operands []Operand, labels map[string]uint32, pc u
 		if offset < -128 || offset > 127 {
 			return nil, fmt.Errorf("short branch to '%s' out of range (%d)", label, offset)
 		}
+		if offset == 0 {
+			return nil, fmt.Errorf("short branch to '%s' can't reach the next instruction (use .w)", label)
+		}
 		baseOpcode |= uint16(offset & 0xFF)
 		return []uint16{baseOpcode}, nil
 	}
@@ -144,7 +153,7 @@ func (asm *Assembler) assembleScc(mn Mnemonic, operands []Operand) ([]uint16, er
 
 // DBcc (Decrement & Branch Conditional)
 
-func (asm *Assembler) assembleDbcc(mn Mnemonic, operands []Operand, labels map[string]uint32, pc uint32) ([]uint16, error) {
+func (asm *Assembler) assembleDbcc(mn Mnemonic, operands []Operand, pc uint32) ([]uint16, error) {
 	if len(operands) != 2 {
 		return nil, fmt.Errorf("DBcc requires 2 operands (Dn, label)")
 	}
@@ -169,7 +178,7 @@ func (asm *Assembler) assembleDbcc(mn Mnemonic, operands []Operand, labels map[s
 	opword |= src.Register
 
 	labelName := strings.ToLower(strings.TrimSpace(dst.Raw))
-	target, ok := labels[labelName]
+	target, ok := asm.branchTarget(dst)
 	if !ok {
 		return nil, fmt.Errorf("undefined label '%s'", labelName)
 	}
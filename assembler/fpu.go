@@ -0,0 +1,516 @@
+package assembler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// isFPUMnemonic checks if an instruction belongs to the FPU subset the
+// assembler knows: the instructions the 68040 and 68060 implement in
+// hardware, which a 68881 or 68882 has too, apart from the FS and FD forms.
+func isFPUMnemonic(val string) bool {
+	if _, ok := cpu.FPUOpmodes[val]; ok {
+		return true
+	}
+	switch val {
+	case "fmovem", "fnop", "fsave", "frestore":
+		return true
+	}
+	cond, ok := strings.CutPrefix(val, "fb")
+	if ok {
+		_, ok = cpu.FPUConditions[cond]
+	}
+	return ok
+}
+
+// checkTarget rejects instructions the current target CPU doesn't have.
+func (asm *Assembler) checkTarget(mn Mnemonic, operands []Operand) error {
+	if isFPUMnemonic(mn.Value) && asm.target < cpu.MC68040 {
+		opmode, arithmetic := cpu.FPUOpmodes[mn.Value]
+		switch {
+		case arithmetic && opmode >= 0x40:
+			return fmt.Errorf("%s needs a 68040 or 68060 target (use MACHINE 68040)", strings.ToUpper(mn.Value))
+		case asm.target < cpu.MC68020 || !asm.fpu:
+			return fmt.Errorf("%s needs an FPU: a 68040 or 68060 target, or a 68020 with FPU on (use MACHINE 68020 and FPU)", strings.ToUpper(mn.Value))
+		}
+	}
+	if mn.Value == "move16" && asm.target < cpu.MC68040 {
+		return fmt.Errorf("%s needs a 68040 or 68060 target (use MACHINE 68040)", strings.ToUpper(mn.Value))
+	}
+	if is68010Instruction(mn, operands) && asm.target < cpu.MC68010 {
+		if mn.Value == "move" {
+			return fmt.Errorf("MOVE from CCR needs a 68010 or later target (use MACHINE 68010)")
+		}
+		return fmt.Errorf("%s needs a 68010 or later target (use MACHINE 68010)", strings.ToUpper(mn.Value))
+	}
+	if asm.target < cpu.MC68020 {
+		if is68020Instruction(mn) {
+			name := mn.Value
+			if mn.Suffix != "" {
+				name += "." + mn.Suffix
+			}
+			return fmt.Errorf("%s needs a 68020 or later target (use MACHINE 68020)", strings.ToUpper(name))
+		}
+		for _, op := range operands {
+			if usesFullIndex(op) {
+				return fmt.Errorf("%s: scaled indexes and memory indirection need a 68020 or later target (use MACHINE 68020)", op.Raw)
+			}
+		}
+	}
+	return nil
+}
+
+// assembleMove16 assembles the MOVE16 instruction.
+// Syntax:
+//
+//	MOVE16 (Ax)+,(Ay)+
+//	MOVE16 (Ay)+,xxx   ; and xxx,(Ay)+
+//	MOVE16 (Ay),xxx    ; and xxx,(Ay)
+//
+// xxx is an absolute address or a label, always encoded as a long.
+func (asm *Assembler) assembleMove16(operands []Operand) ([]uint16, error) {
+	if len(operands) != 2 {
+		return nil, fmt.Errorf("MOVE16 requires 2 operands")
+	}
+	src, dst := operands[0], operands[1]
+	if src.Mode == cpu.ModeAddrPostInc && dst.Mode == cpu.ModeAddrPostInc {
+		return []uint16{cpu.OPMOVE16PostInc | src.Register, 0x8000 | dst.Register<<12}, nil
+	}
+
+	var opmode, reg uint16
+	var abs Operand
+	switch {
+	case src.Mode == cpu.ModeAddrPostInc && isAbsoluteOperand(dst):
+		opmode, reg, abs = 0, src.Register, dst
+	case isAbsoluteOperand(src) && dst.Mode == cpu.ModeAddrPostInc:
+		opmode, reg, abs = 1, dst.Register, src
+	case src.Mode == cpu.ModeAddrInd && isAbsoluteOperand(dst):
+		opmode, reg, abs = 2, src.Register, dst
+	case isAbsoluteOperand(src) && dst.Mode == cpu.ModeAddrInd:
+		opmode, reg, abs = 3, dst.Register, src
+	default:
+		return nil, fmt.Errorf("invalid operand combination for MOVE16")
+	}
+	addr, err := asm.absoluteAddress(abs)
+	if err != nil {
+		return nil, err
+	}
+	return []uint16{cpu.OPMOVE16 | opmode<<3 | reg, uint16(addr >> 16), uint16(addr)}, nil
+}
+
+// isAbsoluteOperand reports whether op is an absolute address or a label.
+func isAbsoluteOperand(op Operand) bool {
+	return op.Mode == cpu.ModeOther && (op.Label != "" || op.Register == cpu.RegAbsShort || op.Register == cpu.RegAbsLong)
+}
+
+// absoluteAddress returns the address an absolute operand or label refers
+// to. Labels not defined yet give 0 while sizing.
+func (asm *Assembler) absoluteAddress(op Operand) (uint32, error) {
+	if op.Label != "" {
+		return asm.labels[op.Label], nil
+	}
+	switch {
+	case op.Register == cpu.RegAbsShort && len(op.ExtensionWords) == 1:
+		return uint32(int32(int16(op.ExtensionWords[0]))), nil
+	case op.Register == cpu.RegAbsLong && len(op.ExtensionWords) == 2:
+		return uint32(op.ExtensionWords[0])<<16 | uint32(op.ExtensionWords[1]), nil
+	}
+	return 0, fmt.Errorf("expected an address, got '%s'", op.Raw)
+}
+
+// assembleFPU assembles the FPU instructions of the 68040 and 68060, which
+// the 68881 and 68882 share.
+// Syntax:
+//
+//	FADD.fmt <ea>,FPn    ; and FSUB, FMUL, FDIV, FCMP and the FS/FD forms
+//	FADD.X FPm,FPn
+//	FABS.fmt <ea>,FPn    ; and FNEG, FSQRT, FINT, FINTRZ, also FABS FPn
+//	FTST.fmt <ea>
+//	FMOVE.fmt <ea>,FPn   ; and FPn,<ea>
+//	FMOVE.P FPn,<ea>{#k} ; or {Dn}, with the k-factor (0 if left out)
+//	FMOVE.L <ea>,FPcr    ; and FPcr,<ea>, with FPCR, FPSR or FPIAR
+//	FMOVEM.X list,<ea>   ; and <ea>,list, with FPn registers
+//	FMOVEM.L list,<ea>   ; and <ea>,list, with control registers
+//	FBcc label           ; .W (default) or .L displacement
+//	FNOP, FSAVE <ea>, FRESTORE <ea>
+//
+// fmt is one of B, W, L, S (single), D (double), X (extended) or P (packed),
+// X if left out.
+func (asm *Assembler) assembleFPU(mn Mnemonic, operands []Operand, pc uint32) ([]uint16, error) {
+	switch mn.Value {
+	case "fnop":
+		return []uint16{cpu.OPFBcc, 0}, nil
+	case "fsave", "frestore":
+		if len(operands) != 1 {
+			return nil, fmt.Errorf("%s requires 1 operand", strings.ToUpper(mn.Value))
+		}
+		op := uint16(cpu.OPFSAVE)
+		if mn.Value == "frestore" {
+			op = cpu.OPFRESTORE
+		}
+		ea, exts, err := asm.encodeEA(operands[0], cpu.SizeLong)
+		if err != nil {
+			return nil, err
+		}
+		return append([]uint16{op | ea}, exts...), nil
+	case "fmovem":
+		return asm.assembleFmovem(mn, operands)
+	}
+	if cond, ok := strings.CutPrefix(mn.Value, "fb"); ok {
+		return asm.assembleFBcc(mn, cpu.FPUConditions[cond], operands, pc)
+	}
+
+	opmode := cpu.FPUOpmodes[mn.Value]
+	if mn.Value == "fmove" && len(operands) == 2 {
+		if isFPControl(operands[0]) || isFPControl(operands[1]) {
+			return asm.assembleFPUControl(mn, operands)
+		}
+		if isFPData(operands[0]) && !isFPData(operands[1]) {
+			// FMOVE FPn,<ea>
+			f, err := fpFormat(mn)
+			if err != nil {
+				return nil, err
+			}
+			src, err := fpRegister(operands[0])
+			if err != nil {
+				return nil, err
+			}
+			format, k := f, uint16(0)
+			if field := operands[1].BitField; field != "" {
+				if f != 3 {
+					return nil, fmt.Errorf("only FMOVE.P takes a k-factor")
+				}
+				if format, k, err = asm.kFactor(field); err != nil {
+					return nil, err
+				}
+			}
+			ea, exts, err := asm.fpEA(operands[1], f, true)
+			if err != nil {
+				return nil, err
+			}
+			return append([]uint16{cpu.OPFPU | ea, 0x6000 | format<<10 | src<<7 | k}, exts...), nil
+		}
+	}
+
+	switch {
+	case len(operands) == 1 && mn.Value == "ftst" && !isFPData(operands[0]):
+		// FTST <ea> has no destination.
+		return asm.fpSource(mn, opmode, 0, operands[0])
+	case len(operands) == 1:
+		// FABS FPn and FTST FPn
+		if _, monadic := fpMonadic[mn.Value]; !monadic {
+			return nil, fmt.Errorf("%s requires 2 operands", strings.ToUpper(mn.Value))
+		}
+		n, err := fpRegister(operands[0])
+		if err != nil {
+			return nil, err
+		}
+		dst := n
+		if mn.Value == "ftst" {
+			dst = 0
+		}
+		return []uint16{cpu.OPFPU, n<<10 | dst<<7 | opmode}, nil
+	case len(operands) == 2 && mn.Value != "ftst":
+		dst, err := fpRegister(operands[1])
+		if err != nil {
+			return nil, err
+		}
+		if isFPData(operands[0]) {
+			src, err := fpRegister(operands[0])
+			if err != nil {
+				return nil, err
+			}
+			return []uint16{cpu.OPFPU, src<<10 | dst<<7 | opmode}, nil
+		}
+		return asm.fpSource(mn, opmode, dst, operands[0])
+	}
+	return nil, fmt.Errorf("invalid operands for %s", strings.ToUpper(mn.Value))
+}
+
+// fpMonadic lists the instructions with a form taking just FPn.
+var fpMonadic = map[string]bool{
+	"fint": true, "fintrz": true, "fsqrt": true, "fabs": true, "fneg": true, "ftst": true,
+	"fssqrt": true, "fdsqrt": true, "fsabs": true, "fdabs": true, "fsneg": true, "fdneg": true,
+}
+
+// fpSource encodes an FPU operation with a source in memory or a data
+// register.
+func (asm *Assembler) fpSource(mn Mnemonic, opmode, dst uint16, src Operand) ([]uint16, error) {
+	f, err := fpFormat(mn)
+	if err != nil {
+		return nil, err
+	}
+	ea, exts, err := asm.fpEA(src, f, false)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16{cpu.OPFPU | ea, 0x4000 | f<<10 | dst<<7 | opmode}, exts...), nil
+}
+
+// fpEA encodes the effective address of an FPU operand in format f. Data
+// registers hold only the formats that fit in 32 bits, and immediates are
+// converted to the format.
+func (asm *Assembler) fpEA(op Operand, f uint16, write bool) (uint16, []uint16, error) {
+	switch {
+	case op.Mode == cpu.ModeAddr || op.Mode == cpu.ModeOther && (op.Register == RegFPList || op.Register == RegList):
+		return 0, nil, fmt.Errorf("invalid FPU operand '%s'", op.Raw)
+	case op.Mode == cpu.ModeData && f != 0 && f != 1 && f != 4 && f != 6:
+		return 0, nil, fmt.Errorf("a data register can't hold a %s operand", fpFormatNames[f])
+	case op.IsImmediate() && write:
+		return 0, nil, fmt.Errorf("invalid destination '%s'", op.Raw)
+	case op.IsImmediate() && (f == 1 || f == 2 || f == 5):
+		v, err := asm.fpImmediate(op)
+		if err != nil {
+			return 0, nil, err
+		}
+		ea := uint16(cpu.ModeOther<<3 | cpu.ModeImmediate)
+		switch f {
+		case 1:
+			b := math.Float32bits(float32(v))
+			return ea, []uint16{uint16(b >> 16), uint16(b)}, nil
+		case 5:
+			b := math.Float64bits(v)
+			return ea, []uint16{uint16(b >> 48), uint16(b >> 32), uint16(b >> 16), uint16(b)}, nil
+		default:
+			w := cpu.ExtendedWords(v)
+			return ea, w[:], nil
+		}
+	case op.IsImmediate() && f == 3:
+		return 0, nil, fmt.Errorf("packed immediates are not supported")
+	}
+	size := cpu.SizeLong
+	switch f {
+	case 4:
+		size = cpu.SizeWord
+	case 6:
+		size = cpu.SizeByte
+	}
+	return asm.encodeEA(op, size)
+}
+
+// kFactor parses the k-factor of FMOVE.P to memory: #k, from -64 to 17, or
+// a data register holding it. It returns the destination format, static or
+// dynamic, and the field for the command word.
+func (asm *Assembler) kFactor(s string) (uint16, uint16, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 2 && s[0] == 'd' && s[1] >= '0' && s[1] <= '7' {
+		return 7, uint16(s[1]-'0') << 4, nil
+	}
+	v, err := asm.parseConstant(strings.TrimPrefix(s, "#"))
+	if err != nil || !strings.HasPrefix(s, "#") {
+		return 0, 0, fmt.Errorf("invalid k-factor '{%s}' (use {#k} or {Dn})", s)
+	}
+	if v < -64 || v > 17 {
+		return 0, 0, fmt.Errorf("k-factor out of range (-64 to 17): %d", v)
+	}
+	return 3, uint16(v) & 0x7F, nil
+}
+
+// fpImmediate returns the value of an immediate real. Integers, hex numbers
+// and EQU symbols are converted.
+func (asm *Assembler) fpImmediate(op Operand) (float64, error) {
+	s := strings.TrimPrefix(op.Raw, "#")
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+	v, err := asm.parseConstant(s)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse real value '%s': %w", op.Raw, err)
+	}
+	return float64(v), nil
+}
+
+// fpFormatNames names the source formats for errors.
+var fpFormatNames = []string{"long", "single", "extended", "packed", "word", "double", "byte"}
+
+// fpFormat returns the format field for an instruction's size suffix.
+func fpFormat(mn Mnemonic) (uint16, error) {
+	if mn.Suffix == "" {
+		return cpu.FPUFormats["x"], nil
+	}
+	f, ok := cpu.FPUFormats[mn.Suffix]
+	if !ok {
+		return 0, fmt.Errorf("invalid FPU size suffix: %s", mn.Suffix)
+	}
+	return f, nil
+}
+
+// assembleFPUControl assembles FMOVE and FMOVEM between control registers
+// and an effective address. The registers are always moved as longs.
+func (asm *Assembler) assembleFPUControl(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	if mn.Suffix != "" && mn.Suffix != "l" {
+		return nil, fmt.Errorf("FPU control registers are moved as longs")
+	}
+	dir, list, other := uint16(0x8000), operands[1], operands[0]
+	if isFPControl(operands[0]) {
+		dir, list, other = 0xA000, operands[0], operands[1]
+	}
+	data, control, err := fpRegisters(list.Raw)
+	if err != nil {
+		return nil, err
+	}
+	if data != 0 || control == 0 {
+		return nil, fmt.Errorf("can't mix FPU data and control registers in '%s'", list.Raw)
+	}
+	if mn.Value == "fmove" && control&(control-1) != 0 {
+		return nil, fmt.Errorf("FMOVE moves one control register (use FMOVEM)")
+	}
+	ea, exts, err := asm.fpEA(other, 0, dir == 0xA000)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16{cpu.OPFPU | ea, dir | control<<10}, exts...), nil
+}
+
+// assembleFmovem assembles FMOVEM. Lists of data registers are moved as
+// extended reals, to memory or from it; the predecrement mode stores them in
+// reverse, so its mask has FPn in bit n and the others FP0 in bit 7.
+func (asm *Assembler) assembleFmovem(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	if len(operands) != 2 {
+		return nil, fmt.Errorf("FMOVEM requires 2 operands")
+	}
+	if isFPControl(operands[0]) || isFPControl(operands[1]) {
+		return asm.assembleFPUControl(mn, operands)
+	}
+	if mn.Suffix != "" && mn.Suffix != "x" {
+		return nil, fmt.Errorf("FPU data registers are moved as extended reals")
+	}
+	var dir uint16
+	list, other := operands[1], operands[0]
+	if isFPData(operands[0]) {
+		dir, list, other = 1, operands[0], operands[1]
+	}
+	if !isFPData(list) {
+		return nil, fmt.Errorf("FMOVEM needs a register list")
+	}
+	data, _, err := fpRegisters(list.Raw)
+	if err != nil {
+		return nil, err
+	}
+	var mode, mask uint16
+	switch {
+	case other.Mode == cpu.ModeAddrPreDec && dir == 1:
+		mode, mask = 0, uint16(data)
+	case other.Mode == cpu.ModeAddrPreDec, other.Mode == cpu.ModeAddrPostInc && dir == 1:
+		return nil, fmt.Errorf("invalid addressing mode for FMOVEM: '%s'", other.Raw)
+	default:
+		mode = 2
+		for n := range 8 {
+			if data&(1<<n) != 0 {
+				mask |= 0x80 >> n
+			}
+		}
+	}
+	if other.Mode <= cpu.ModeAddr {
+		return nil, fmt.Errorf("invalid addressing mode for FMOVEM: '%s'", other.Raw)
+	}
+	ea, exts, err := asm.fpEA(other, 2, dir == 1)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16{cpu.OPFPU | ea, 0xC000 | dir<<13 | mode<<11 | mask}, exts...), nil
+}
+
+// assembleFBcc assembles an FPU conditional branch, with a word displacement
+// unless the .L form is asked for. The target is a label or an address.
+func (asm *Assembler) assembleFBcc(mn Mnemonic, cond uint16, operands []Operand, pc uint32) ([]uint16, error) {
+	if len(operands) != 1 {
+		return nil, fmt.Errorf("%s requires a target", strings.ToUpper(mn.Value))
+	}
+	op := operands[0]
+	var target uint32
+	known := true
+	if op.Label != "" {
+		target, known = asm.labels[op.Label]
+	} else {
+		var err error
+		if target, err = asm.absoluteAddress(op); err != nil {
+			return nil, err
+		}
+	}
+	disp := int64(target) - int64(pc+2)
+	if !known {
+		disp = 0 // Sized now, filled in by the final pass.
+	}
+
+	switch mn.Suffix {
+	case "l":
+		return []uint16{cpu.OPFBccLong | cond, uint16(disp >> 16), uint16(disp)}, nil
+	case "", "w":
+		if disp < -32768 || disp > 32767 {
+			return nil, fmt.Errorf("branch target out of range for %s (use %s.l)", mn.Value, mn.Value)
+		}
+		return []uint16{cpu.OPFBcc | cond, uint16(disp)}, nil
+	}
+	return nil, fmt.Errorf("invalid size for %s: .%s", mn.Value, mn.Suffix)
+}
+
+// isFPData reports whether op names FPU data registers.
+func isFPData(op Operand) bool {
+	if op.Mode != cpu.ModeOther || op.Register != RegFPList {
+		return false
+	}
+	data, _, err := fpRegisters(op.Raw)
+	return err == nil && data != 0
+}
+
+// isFPControl reports whether op names FPU control registers.
+func isFPControl(op Operand) bool {
+	if op.Mode != cpu.ModeOther || op.Register != RegFPList {
+		return false
+	}
+	_, control, err := fpRegisters(op.Raw)
+	return err == nil && control != 0
+}
+
+// fpRegister returns the number of the single FPU data register op names.
+func fpRegister(op Operand) (uint16, error) {
+	if op.Mode == cpu.ModeOther && op.Register == RegFPList {
+		data, control, err := fpRegisters(op.Raw)
+		if err == nil && control == 0 && data&(data-1) == 0 {
+			for n := range 8 {
+				if data == 1<<n {
+					return uint16(n), nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("expected an FPU register, got '%s'", op.Raw)
+}
+
+// fpRegisters parses a list of FPU registers such as "fp0-fp3/fp7" or
+// "fpcr/fpsr". data has bit n set for FPn and control has the select bits
+// of the control registers.
+func fpRegisters(s string) (data uint8, control uint16, err error) {
+	for part := range strings.SplitSeq(strings.ToLower(s), "/") {
+		if sel, ok := cpu.FPUControlRegisters[part]; ok {
+			control |= sel
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		lo, err1 := parseFPRegisterName(first)
+		hi, err2 := parseFPRegisterName(last)
+		if err1 != nil || err2 != nil || lo > hi {
+			return 0, 0, fmt.Errorf("invalid FPU register list '%s'", s)
+		}
+		for n := lo; n <= hi; n++ {
+			data |= 1 << n
+		}
+	}
+	return data, control, nil
+}
+
+// parseFPRegisterName returns n for "fpn".
+func parseFPRegisterName(s string) (int, error) {
+	if len(s) != 3 || !strings.HasPrefix(s, "fp") || s[2] < '0' || s[2] > '7' {
+		return 0, fmt.Errorf("invalid FPU register '%s'", s)
+	}
+	return int(s[2] - '0'), nil
+}
@@ -124,12 +124,8 @@ func (asm *Assembler) encodeEA(op Operand, size cpu.Size) (uint16, []uint16, err
 	return word, exts, nil
 }
 
-// reverseMovemMask reverses MOVEM bit ordering correctly.
-// Each 8-bit register group (D0–D7, A0–A7) is reversed independently.
+// reverseMovemMask converts a register mask to the predecrement order,
+// which runs from A7 in bit 0 to D0 in bit 15.
 func reverseMovemMask(mask uint16) uint16 {
-	d := uint8(mask & 0xFF)
-	a := uint8((mask >> 8) & 0xFF)
-	d = bits.Reverse8(d)
-	a = bits.Reverse8(a)
-	return uint16(a)<<8 | uint16(d)
+	return bits.Reverse16(mask)
 }
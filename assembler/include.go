@@ -0,0 +1,77 @@
+package assembler
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth stops runaway recursion from files including each other.
+const maxIncludeDepth = 16
+
+// library holds the standard include files built into the assembler:
+// vectors.i, exceptions.i and system.i.
+//
+//go:embed lib/*.i
+var library embed.FS
+
+// Library returns the built-in include files, so tools can list or extract them.
+func Library() fs.FS {
+	lib, _ := fs.Sub(library, "lib")
+	return lib
+}
+
+// readInclude loads the file named by an INCLUDE directive. Relative names are
+// looked up in IncludeDirs (or the working directory if none are set), then in
+// the built-in library.
+func (asm *Assembler) readInclude(name string) ([]byte, error) {
+	if filepath.IsAbs(name) {
+		return os.ReadFile(name)
+	}
+
+	dirs := asm.IncludeDirs
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	data, err := fs.ReadFile(library, path.Join("lib", filepath.ToSlash(name)))
+	if err != nil {
+		return nil, fmt.Errorf("include file not found: %s", name)
+	}
+	return data, nil
+}
+
+// parseInclude parses an included file into nodes.
+func (asm *Assembler) parseInclude(operand string, depth int) ([]*Node, error) {
+	name := strings.Trim(strings.TrimSpace(operand), `"'<>`)
+	if name == "" {
+		return nil, fmt.Errorf("include requires a file name")
+	}
+	if depth >= maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+	}
+
+	data, err := asm.readInclude(name)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	nodes, err := asm.parseFile(lines, depth+1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return nodes, nil
+}
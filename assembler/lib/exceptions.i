@@ -0,0 +1,149 @@
+; exceptions.i - default exception handlers for vectors.i.
+;
+; Each handler loads its vector number into D7 and halts the VM with TRAP #15,
+; so an unexpected exception stops the program with the cause in a register.
+; To handle an exception yourself, copy this file and replace its stub.
+
+exc_bus_error:
+	moveq	#2,d7
+	trap	#15
+
+exc_address_error:
+	moveq	#3,d7
+	trap	#15
+
+exc_illegal:
+	moveq	#4,d7
+	trap	#15
+
+exc_zero_divide:
+	moveq	#5,d7
+	trap	#15
+
+exc_chk:
+	moveq	#6,d7
+	trap	#15
+
+exc_trapv:
+	moveq	#7,d7
+	trap	#15
+
+exc_privilege:
+	moveq	#8,d7
+	trap	#15
+
+exc_trace:
+	moveq	#9,d7
+	trap	#15
+
+exc_line_a:
+	moveq	#10,d7
+	trap	#15
+
+exc_line_f:
+	moveq	#11,d7
+	trap	#15
+
+exc_uninitialised:
+	moveq	#15,d7
+	trap	#15
+
+exc_spurious:
+	moveq	#24,d7
+	trap	#15
+
+exc_level1:
+	moveq	#25,d7
+	trap	#15
+
+exc_level2:
+	moveq	#26,d7
+	trap	#15
+
+exc_level3:
+	moveq	#27,d7
+	trap	#15
+
+exc_level4:
+	moveq	#28,d7
+	trap	#15
+
+exc_level5:
+	moveq	#29,d7
+	trap	#15
+
+exc_level6:
+	moveq	#30,d7
+	trap	#15
+
+exc_level7:
+	moveq	#31,d7
+	trap	#15
+
+exc_trap0:
+	moveq	#32,d7
+	trap	#15
+
+exc_trap1:
+	moveq	#33,d7
+	trap	#15
+
+exc_trap2:
+	moveq	#34,d7
+	trap	#15
+
+exc_trap3:
+	moveq	#35,d7
+	trap	#15
+
+exc_trap4:
+	moveq	#36,d7
+	trap	#15
+
+exc_trap5:
+	moveq	#37,d7
+	trap	#15
+
+exc_trap6:
+	moveq	#38,d7
+	trap	#15
+
+exc_trap7:
+	moveq	#39,d7
+	trap	#15
+
+exc_trap8:
+	moveq	#40,d7
+	trap	#15
+
+exc_trap9:
+	moveq	#41,d7
+	trap	#15
+
+exc_trap10:
+	moveq	#42,d7
+	trap	#15
+
+exc_trap11:
+	moveq	#43,d7
+	trap	#15
+
+exc_trap12:
+	moveq	#44,d7
+	trap	#15
+
+exc_trap13:
+	moveq	#45,d7
+	trap	#15
+
+exc_trap14:
+	moveq	#46,d7
+	trap	#15
+
+exc_trap15:
+	moveq	#47,d7
+	trap	#15
+
+exc_reserved:
+	moveq	#-1,d7
+	trap	#15
@@ -0,0 +1,99 @@
+; runtime.i - common runtime routines.
+;
+; Arguments are passed in D0-D1/A0-A1, which the routines may change.
+; Every other register is preserved. Call them with BSR or JSR.
+
+; memcpy copies D0.L bytes from (A1) to (A0).
+; On return A0 and A1 point past the copied bytes.
+memcpy:
+	tst.l	d0
+	beq	memcpy_done
+memcpy_loop:
+	move.b	(a1)+,(a0)+
+	subq.l	#1,d0
+	bne	memcpy_loop
+memcpy_done:
+	rts
+
+; memset fills D1.L bytes at (A0) with the byte in D0.
+; On return A0 points past the filled bytes.
+memset:
+	tst.l	d1
+	beq	memset_done
+memset_loop:
+	move.b	d0,(a0)+
+	subq.l	#1,d1
+	bne	memset_loop
+memset_done:
+	rts
+
+; strcmp compares the NUL-terminated strings at (A0) and (A1) as unsigned bytes.
+; D0.L is -1, 0 or 1 when the first string sorts before, equal to or after
+; the second.
+strcmp:
+	move.b	(a0)+,d0
+	move.b	(a1)+,d1
+	cmp.b	d1,d0
+	bne	strcmp_differ
+	tst.b	d0
+	bne	strcmp
+	moveq	#0,d0
+	rts
+strcmp_differ:
+	bcs	strcmp_less
+	moveq	#1,d0
+	rts
+strcmp_less:
+	moveq	#-1,d0
+	rts
+
+; divmod32 divides D0.L by D1.L, unsigned. The quotient is returned in D0
+; and the remainder in D1. Dividing by zero returns $FFFFFFFF and the dividend.
+divmod32:
+	move.l	d2,-(a7)
+	move.l	d3,-(a7)
+	moveq	#0,d2			; Remainder
+	moveq	#31,d3			; One pass per dividend bit
+divmod32_loop:
+	add.l	d0,d0			; Next dividend bit into X
+	roxl.l	#1,d2			; and from X into the remainder
+	cmp.l	d1,d2
+	bcs	divmod32_next
+	sub.l	d1,d2
+	addq.l	#1,d0			; Quotient bit
+divmod32_next:
+	dbra	d3,divmod32_loop
+	move.l	d2,d1
+	move.l	(a7)+,d3
+	move.l	(a7)+,d2
+	rts
+
+; itoa writes D0.L as a signed decimal number to (A0), followed by a NUL.
+; On return A0 points at the NUL.
+itoa:
+	tst.l	d0
+	bpl	utoa
+	move.b	#'-',(a0)+
+	neg.l	d0
+
+; utoa writes D0.L as an unsigned decimal number to (A0), followed by a NUL.
+; On return A0 points at the NUL.
+utoa:
+	move.l	d2,-(a7)
+	moveq	#0,d2			; Digit count
+utoa_divide:
+	moveq	#10,d1
+	bsr	divmod32
+	or.b	#'0',d1
+	move.w	d1,-(a7)		; Digits come out last first
+	addq.w	#1,d2
+	tst.l	d0
+	bne	utoa_divide
+utoa_store:
+	move.w	(a7)+,d1
+	move.b	d1,(a0)+
+	subq.w	#1,d2
+	bne	utoa_store
+	clr.b	(a0)
+	move.l	(a7)+,d2
+	rts
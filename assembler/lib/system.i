@@ -0,0 +1,166 @@
+; system.i - run68 conventions and system call wrappers.
+;
+; The VM reserves TRAP #15 to halt. Everything here is position independent,
+; so it can be included anywhere in the code.
+
+TRAP_EXIT	equ	15		; Halt the virtual machine
+
+; Performance counter registers, as offsets from the address given to
+; run68 -perf. Each is a long; the counters are refreshed before every
+; instruction.
+PERF_CYCLES_LO	equ	$0
+PERF_INSNS_LO	equ	$4
+PERF_CYCLES_HI	equ	$8
+PERF_INSNS_HI	equ	$C
+
+; Random number device registers, as offsets from the address given to
+; run68 -random. RANDOM_VALUE holds a new number before every instruction;
+; writing RANDOM_SEED restarts the sequence.
+RANDOM_VALUE	equ	$0
+RANDOM_SEED	equ	$4
+
+; UART registers, as byte offsets from the address given to run68 -uart,
+; and the bits of UART_STATUS and UART_CONTROL. Reading UART_DATA takes the
+; received character and writing it sends one. With UART_RX_INT set the
+; UART interrupts at the level given to run68 -uartlevel, using its
+; autovector, while RX_READY or EOF is set.
+UART_DATA	equ	$0
+UART_STATUS	equ	$1		; Read-only
+UART_CONTROL	equ	$2
+UART_RX_READY	equ	$01
+UART_TX_EMPTY	equ	$02
+UART_EOF	equ	$04
+UART_RX_INT	equ	$80
+
+; Timer registers, as byte offsets from the address given to run68 -timer,
+; and the bits of TIMER_CONTROL and TIMER_STATUS. The timer counts down
+; TIMER_PERIOD clock cycles and sets TIMER_EXPIRED, reloading the period
+; unless TIMER_ONE_SHOT is set. With TIMER_INT set it interrupts at
+; TIMER_LEVEL, through TIMER_VECTOR or the autovector if that is 0, until
+; the handler writes TIMER_EXPIRED to TIMER_STATUS.
+TIMER_CONTROL	equ	$0
+TIMER_STATUS	equ	$1
+TIMER_LEVEL	equ	$2
+TIMER_VECTOR	equ	$3
+TIMER_PERIOD	equ	$4		; Long
+TIMER_COUNT	equ	$8		; Long, read-only
+TIMER_ENABLE	equ	$01
+TIMER_ONE_SHOT	equ	$02
+TIMER_INT	equ	$80
+TIMER_EXPIRED	equ	$01
+
+; Block device registers, as byte offsets from the address given to run68
+; -disk. Fill in BLOCK_SECTOR, BLOCK_ADDRESS and BLOCK_COUNT, then write
+; BLOCK_READ or BLOCK_WRITE to BLOCK_COMMAND; the sectors have moved by the
+; next instruction, and BLOCK_STATUS says how it went. Sectors are 512
+; bytes. With BLOCK_INT set the device interrupts at the level given to
+; run68 -disklevel until the handler writes the status bits back.
+BLOCK_COMMAND	equ	$0
+BLOCK_STATUS	equ	$1
+BLOCK_CONTROL	equ	$2
+BLOCK_SECTOR	equ	$4		; Long
+BLOCK_ADDRESS	equ	$8		; Long
+BLOCK_COUNT	equ	$C		; Word
+BLOCK_SECTORS	equ	$10		; Long, read-only
+BLOCK_READ	equ	1
+BLOCK_WRITE	equ	2
+BLOCK_DONE	equ	$01
+BLOCK_ERROR	equ	$02
+BLOCK_INT	equ	$80
+
+; Keyboard registers, as byte offsets from the address given to run68
+; -keyboard, and the bits of KEYBOARD_STATUS, KEYBOARD_CONTROL and the event
+; flags. Events queue up while KEYBOARD_READY is set; reading KEYBOARD_CODE
+; takes the oldest, so a word read of KEYBOARD_FLAGS gets its flags and code
+; together. With KEYBOARD_INT set the keyboard interrupts at the level given
+; to run68 -keyboardlevel, using its autovector, while an event is queued.
+KEYBOARD_STATUS	equ	$0
+KEYBOARD_CONTROL	equ	$1
+KEYBOARD_COUNT	equ	$2		; Read-only
+KEYBOARD_FLAGS	equ	$4		; Read-only
+KEYBOARD_CODE	equ	$5		; Read-only
+KEYBOARD_READY	equ	$01
+KEYBOARD_OVERFLOW	equ	$02		; Write it back to clear it
+KEYBOARD_EOF	equ	$04
+KEYBOARD_INT	equ	$80
+KEY_SHIFT	equ	$01
+KEY_CONTROL	equ	$02
+KEY_ALT	equ	$04
+KEY_META	equ	$08
+KEY_RELEASED	equ	$80
+
+; Audio registers, as byte offsets from the address given to run68 -audio,
+; and the bits of AUDIO_CONTROL and AUDIO_STATUS. Samples written to
+; AUDIO_DATA, signed bytes or with AUDIO_16BIT signed words, queue up and
+; play at AUDIO_RATE frames a second, paced by the CPU clock. With AUDIO_INT
+; set the device interrupts at the level given to run68 -audiolevel, using
+; its autovector, while AUDIO_HALF_EMPTY is set.
+AUDIO_CONTROL	equ	$0
+AUDIO_STATUS	equ	$1
+AUDIO_RATE	equ	$4		; Long
+AUDIO_DATA	equ	$8		; Write-only
+AUDIO_FREE	equ	$C		; Word, read-only
+AUDIO_ENABLE	equ	$01
+AUDIO_STEREO	equ	$02
+AUDIO_16BIT	equ	$04
+AUDIO_INT	equ	$80
+AUDIO_HALF_EMPTY	equ	$01
+AUDIO_OVERRUN	equ	$02		; Write it back to clear it
+AUDIO_UNDERRUN	equ	$04		; Write it back to clear it
+
+; Real-time clock registers, as byte offsets from the address given to run68
+; -rtc. All are read-only, and every byte one instruction reads comes from the
+; same moment, so movem.l (a0),d0-d4 takes a consistent copy of the lot.
+RTC_YEAR	equ	$0		; Word
+RTC_MONTH	equ	$2		; 1-12
+RTC_DAY	equ	$3		; 1-31
+RTC_HOUR	equ	$4
+RTC_MINUTE	equ	$5
+RTC_SECOND	equ	$6
+RTC_WEEKDAY	equ	$7		; 0 is Sunday
+RTC_UPTIME_HI	equ	$8		; Long: clock cycles, bits 63-32
+RTC_UPTIME_LO	equ	$C		; Long: clock cycles, bits 31-0
+RTC_SECONDS	equ	$10		; Long: seconds since 1970, UTC
+
+; sys_exit stops the VM. Registers are left as they are for inspection.
+sys_exit:
+	trap	#TRAP_EXIT
+
+; sys_cycles returns the low 32 bits of the cycle counter in D0.
+; A0 must hold the address of the performance counters.
+sys_cycles:
+	move.l	(a0),d0			; PERF_CYCLES_LO
+	rts
+
+; sys_instructions returns the low 32 bits of the instruction counter in D0.
+; A0 must hold the address of the performance counters.
+sys_instructions:
+	move.l	4(a0),d0		; PERF_INSNS_LO
+	rts
+
+; sys_random returns a random long in D0.
+; A0 must hold the address of the random number device.
+sys_random:
+	move.l	(a0),d0			; RANDOM_VALUE
+	rts
+
+; sys_block_read reads D1.W sectors from sector D0 into memory at A1, and
+; sys_block_write writes them. D0 is 0 on success or -1 on failure, and
+; D2 is changed.
+; A0 must hold the address of the block device.
+sys_block_read:
+	moveq	#1,d2			; BLOCK_READ
+	bra.s	sys_block
+sys_block_write:
+	moveq	#2,d2			; BLOCK_WRITE
+sys_block:
+	move.l	d0,4(a0)		; BLOCK_SECTOR
+	move.l	a1,8(a0)		; BLOCK_ADDRESS
+	move.w	d1,12(a0)		; BLOCK_COUNT
+	move.b	d2,(a0)			; BLOCK_COMMAND
+	moveq	#0,d0
+	btst	#1,1(a0)		; BLOCK_STATUS, BLOCK_ERROR
+	beq.s	sys_block_ok
+	moveq	#-1,d0
+sys_block_ok:
+	rts
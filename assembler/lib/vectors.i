@@ -0,0 +1,74 @@
+; vectors.i - 68000 exception vector table skeleton.
+;
+; Include this at address 0. It fills the 64 processor vectors ($000-$0FF).
+; The program must define:
+;   STACK_TOP  equ  initial supervisor stack pointer
+;   start:          reset entry point
+; Every other vector points at a handler from exceptions.i, so include that
+; too, or define the exc_* labels yourself.
+
+vectors:
+	dc.l	STACK_TOP		; 0  Reset: initial SSP
+	dc.l	start			; 1  Reset: initial PC
+	dc.l	exc_bus_error		; 2  Bus error
+	dc.l	exc_address_error	; 3  Address error
+	dc.l	exc_illegal		; 4  Illegal instruction
+	dc.l	exc_zero_divide		; 5  Zero divide
+	dc.l	exc_chk			; 6  CHK
+	dc.l	exc_trapv		; 7  TRAPV
+	dc.l	exc_privilege		; 8  Privilege violation
+	dc.l	exc_trace		; 9  Trace
+	dc.l	exc_line_a		; 10 Line 1010 emulator
+	dc.l	exc_line_f		; 11 Line 1111 emulator
+	dc.l	exc_reserved		; 12 Reserved
+	dc.l	exc_reserved		; 13 Reserved
+	dc.l	exc_reserved		; 14 Reserved
+	dc.l	exc_uninitialised	; 15 Uninitialised interrupt
+	dc.l	exc_reserved		; 16 Reserved
+	dc.l	exc_reserved		; 17 Reserved
+	dc.l	exc_reserved		; 18 Reserved
+	dc.l	exc_reserved		; 19 Reserved
+	dc.l	exc_reserved		; 20 Reserved
+	dc.l	exc_reserved		; 21 Reserved
+	dc.l	exc_reserved		; 22 Reserved
+	dc.l	exc_reserved		; 23 Reserved
+	dc.l	exc_spurious		; 24 Spurious interrupt
+	dc.l	exc_level1		; 25 Level 1 autovector
+	dc.l	exc_level2		; 26 Level 2 autovector
+	dc.l	exc_level3		; 27 Level 3 autovector
+	dc.l	exc_level4		; 28 Level 4 autovector
+	dc.l	exc_level5		; 29 Level 5 autovector
+	dc.l	exc_level6		; 30 Level 6 autovector
+	dc.l	exc_level7		; 31 Level 7 autovector
+	dc.l	exc_trap0		; 32 TRAP #0
+	dc.l	exc_trap1		; 33 TRAP #1
+	dc.l	exc_trap2		; 34 TRAP #2
+	dc.l	exc_trap3		; 35 TRAP #3
+	dc.l	exc_trap4		; 36 TRAP #4
+	dc.l	exc_trap5		; 37 TRAP #5
+	dc.l	exc_trap6		; 38 TRAP #6
+	dc.l	exc_trap7		; 39 TRAP #7
+	dc.l	exc_trap8		; 40 TRAP #8
+	dc.l	exc_trap9		; 41 TRAP #9
+	dc.l	exc_trap10		; 42 TRAP #10
+	dc.l	exc_trap11		; 43 TRAP #11
+	dc.l	exc_trap12		; 44 TRAP #12
+	dc.l	exc_trap13		; 45 TRAP #13
+	dc.l	exc_trap14		; 46 TRAP #14
+	dc.l	exc_trap15		; 47 TRAP #15
+	dc.l	exc_reserved		; 48 Reserved
+	dc.l	exc_reserved		; 49 Reserved
+	dc.l	exc_reserved		; 50 Reserved
+	dc.l	exc_reserved		; 51 Reserved
+	dc.l	exc_reserved		; 52 Reserved
+	dc.l	exc_reserved		; 53 Reserved
+	dc.l	exc_reserved		; 54 Reserved
+	dc.l	exc_reserved		; 55 Reserved
+	dc.l	exc_reserved		; 56 Reserved
+	dc.l	exc_reserved		; 57 Reserved
+	dc.l	exc_reserved		; 58 Reserved
+	dc.l	exc_reserved		; 59 Reserved
+	dc.l	exc_reserved		; 60 Reserved
+	dc.l	exc_reserved		; 61 Reserved
+	dc.l	exc_reserved		; 62 Reserved
+	dc.l	exc_reserved		; 63 Reserved
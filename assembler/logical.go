@@ -29,16 +29,18 @@ func (asm *Assembler) assembleLogicalImmediate(baseOpcode uint16, mn Mnemonic, s
 		return nil, err
 	}
 
+	_, imm, err := asm.encodeEA(src, mn.Size)
+	if err != nil {
+		return nil, err
+	}
+
 	eaBits, eaExt, err := asm.encodeEA(dst, mn.Size)
 	if err != nil {
 		return nil, err
 	}
 	opword |= eaBits
 
-	words := []uint16{opword}
-	if len(src.ExtensionWords) > 0 {
-		words = append(words, src.ExtensionWords...)
-	}
+	words := append([]uint16{opword}, imm...)
 	if len(eaExt) > 0 {
 		words = append(words, eaExt...)
 	}
@@ -0,0 +1,118 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// controlRegisters maps the control register names MOVEC accepts to their
+// numbers in the register word.
+var controlRegisters = map[string]uint16{
+	"sfc": cpu.ControlSFC,
+	"dfc": cpu.ControlDFC,
+	"usp": cpu.ControlUSP,
+	"vbr": cpu.ControlVBR,
+}
+
+// is68010Instruction checks if an instruction is one the 68010 added. MOVE
+// from CCR is told from the 68000's MOVE by its source operand.
+func is68010Instruction(mn Mnemonic, operands []Operand) bool {
+	switch mn.Value {
+	case "movec", "moves", "rtd":
+		return true
+	case "move":
+		return len(operands) > 0 && strings.EqualFold(strings.TrimSpace(operands[0].Raw), "ccr")
+	}
+	return false
+}
+
+// generalRegister returns the register word bits for Dn or An: the
+// register number in bits 14-12 and bit 15 set for an address register.
+func generalRegister(op Operand) (uint16, bool) {
+	switch op.Mode {
+	case cpu.ModeData:
+		return op.Register << 12, true
+	case cpu.ModeAddr:
+		return 0x8000 | op.Register<<12, true
+	}
+	return 0, false
+}
+
+// assembleMovec assembles MOVEC Rc,Rn and MOVEC Rn,Rc.
+func assembleMovec(operands []Operand) ([]uint16, error) {
+	if len(operands) != 2 {
+		return nil, fmt.Errorf("MOVEC requires 2 operands")
+	}
+	src, dst := operands[0], operands[1]
+	if rc, ok := controlRegisters[strings.ToLower(strings.TrimSpace(src.Raw))]; ok {
+		rn, ok := generalRegister(dst)
+		if !ok {
+			return nil, fmt.Errorf("destination for MOVEC must be a data or address register")
+		}
+		return []uint16{cpu.OPMOVEC, rn | rc}, nil
+	}
+	if rc, ok := controlRegisters[strings.ToLower(strings.TrimSpace(dst.Raw))]; ok {
+		rn, ok := generalRegister(src)
+		if !ok {
+			return nil, fmt.Errorf("source for MOVEC must be a data or address register")
+		}
+		return []uint16{cpu.OPMOVEC | 1, rn | rc}, nil
+	}
+	return nil, fmt.Errorf("MOVEC needs a control register: SFC, DFC, USP or VBR")
+}
+
+// assembleMoves assembles MOVES Rn,<ea> and MOVES <ea>,Rn. The memory
+// operand must be alterable and not a register.
+func (asm *Assembler) assembleMoves(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	if len(operands) != 2 {
+		return nil, fmt.Errorf("MOVES requires 2 operands")
+	}
+	src, dst := operands[0], operands[1]
+	var rn, dr uint16
+	var mem Operand
+	if r, ok := generalRegister(src); ok {
+		rn, dr, mem = r, 0x0800, dst
+	} else if r, ok := generalRegister(dst); ok {
+		rn, mem = r, src
+	} else {
+		return nil, fmt.Errorf("MOVES needs a data or address register")
+	}
+	if _, ok := generalRegister(mem); ok || !isAlterableMemory(mem) {
+		return nil, fmt.Errorf("MOVES needs an alterable memory operand")
+	}
+	opword, err := setOpwordSize(cpu.OPMOVES, mn.Size, SizeBits)
+	if err != nil {
+		return nil, fmt.Errorf("MOVES: %w", err)
+	}
+	eaBits, eaExt, err := asm.encodeEA(mem, mn.Size)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16{opword | eaBits, rn | dr}, eaExt...), nil
+}
+
+// isAlterableMemory checks if an operand is a memory operand that can be
+// written: not PC-relative and not immediate.
+func isAlterableMemory(op Operand) bool {
+	if op.Mode != cpu.ModeOther {
+		return op.Mode != cpu.ModeData && op.Mode != cpu.ModeAddr
+	}
+	return op.Register == cpu.ModeAbsShort || op.Register == cpu.ModeAbsLong
+}
+
+// assembleRtd assembles RTD #d16.
+func (asm *Assembler) assembleRtd(operands []Operand) ([]uint16, error) {
+	if len(operands) != 1 || !operands[0].IsImmediate() {
+		return nil, fmt.Errorf("RTD requires one immediate operand")
+	}
+	disp, err := asm.parseConstant(strings.TrimPrefix(strings.TrimSpace(operands[0].Raw), "#"))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse RTD displacement '%s': %w", operands[0].Raw, err)
+	}
+	if disp < -32768 || disp > 32767 {
+		return nil, fmt.Errorf("RTD displacement %d is out of range (-32768 to 32767)", disp)
+	}
+	return []uint16{cpu.OPRTD, uint16(disp)}, nil
+}
@@ -0,0 +1,570 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// reIndexRegister matches an index register with an optional size and scale,
+// e.g. d1, a2.l or d3.w*4.
+var reIndexRegister = regexp.MustCompile(`(?i)^([da])([0-7])(?:\.(w|l))?(?:\*([1248]))?$`)
+
+// indexBits returns the index fields of an extension word for an index
+// register matched by reIndexRegister or the index regexes: D/A, register,
+// W/L and scale.
+func indexBits(kind, num, size, scale string) uint16 {
+	n, _ := strconv.Atoi(num)
+	ext := uint16(n) << 12
+	if strings.EqualFold(kind, "a") {
+		ext |= 0x8000
+	}
+	if strings.EqualFold(size, "l") {
+		ext |= 0x0800
+	}
+	switch scale {
+	case "2":
+		ext |= 1 << 9
+	case "4":
+		ext |= 2 << 9
+	case "8":
+		ext |= 3 << 9
+	}
+	return ext
+}
+
+// usesFullIndex checks if an operand needs the 68020's indexed addressing:
+// a scaled index or a full extension word.
+func usesFullIndex(op Operand) bool {
+	indexed := op.Mode == cpu.ModeAddrIndex || op.Mode == cpu.ModeOther && op.Register == cpu.RegPCIndex
+	return indexed && len(op.ExtensionWords) > 0 && op.ExtensionWords[0]&0x0700 != 0
+}
+
+// displacementSize returns the size field of a full extension word for a
+// displacement and its words: 1 for none, 2 for a word and 3 for a long.
+// A .w or .l suffix forces the size, and otherwise the shortest that fits
+// is used.
+func (asm *Assembler) displacementSize(s string) (uint16, []uint16, error) {
+	if s == "" {
+		return 1, nil, nil
+	}
+	size := ""
+	if i := len(s) - 2; i > 0 && s[i] == '.' {
+		size = strings.ToLower(s[i+1:])
+		if size == "w" || size == "l" {
+			s = s[:i]
+		} else {
+			size = ""
+		}
+	}
+	v, err := asm.parseConstant(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch {
+	case size == "w":
+		return 2, []uint16{uint16(v)}, nil
+	case size == "l":
+		return 3, []uint16{uint16(v >> 16), uint16(v)}, nil
+	case v == 0:
+		return 1, nil, nil
+	case v >= -32768 && v <= 32767:
+		return 2, []uint16{uint16(v)}, nil
+	}
+	return 3, []uint16{uint16(v >> 16), uint16(v)}, nil
+}
+
+// indexParts holds the components of a parenthesised indexed operand.
+type indexParts struct {
+	bd, od     string
+	base       string // "a0"-"a7", "pc", or "" when suppressed
+	suppressed bool   // Written as zan or zpc
+	index      string // Index register, or ""
+	indexPost  bool   // The index follows the memory indirection
+	memory     bool   // The operand has a [...] part
+}
+
+// splitIndexParts breaks the inside of an indexed operand into its parts. It
+// reports false for text that isn't a list of displacements and registers.
+func splitIndexParts(inner string) (indexParts, bool) {
+	var p indexParts
+	var outer []string
+	pre := inner
+	if strings.HasPrefix(inner, "[") {
+		end := strings.IndexByte(inner, ']')
+		if end < 0 {
+			return p, false
+		}
+		p.memory = true
+		pre = inner[1:end]
+		rest := strings.TrimSpace(inner[end+1:])
+		if rest != "" {
+			after, ok := strings.CutPrefix(rest, ",")
+			if !ok {
+				return p, false
+			}
+			outer = strings.Split(after, ",")
+		}
+	} else if strings.ContainsAny(inner, "[]") {
+		return p, false
+	}
+
+	for i, part := range strings.Split(pre, ",") {
+		part = strings.TrimSpace(part)
+		lower := strings.ToLower(part)
+		if lower == "sp" {
+			lower = "a7"
+		}
+		switch {
+		case part == "":
+			if p.memory && len(pre) == 0 {
+				continue
+			}
+			return p, false
+		case p.base == "" && !p.suppressed && p.index == "" && (lower == "pc" || reAddressRegister.MatchString(lower)):
+			p.base = lower
+		case p.base == "" && !p.suppressed && p.index == "" && (lower == "zpc" || len(lower) == 3 && lower[0] == 'z' && reAddressRegister.MatchString(lower[1:])):
+			p.base, p.suppressed = lower[1:], true
+		case p.index == "" && reIndexRegister.MatchString(lower):
+			p.index = lower
+		case i == 0:
+			p.bd = part
+		default:
+			return p, false
+		}
+	}
+	for i, part := range outer {
+		part = strings.TrimSpace(part)
+		lower := strings.ToLower(part)
+		switch {
+		case i == 0 && p.index == "" && reIndexRegister.MatchString(lower):
+			p.index, p.indexPost = lower, true
+		case p.od == "" && part != "":
+			p.od = part
+		default:
+			return p, false
+		}
+	}
+	return p, true
+}
+
+// tryParseIndexed020 handles the 68020's indexed forms in the parenthesised
+// syntax: (bd,An,Xn.s*scale) and its PC variant, ([bd,An],Xn,od) post-indexed
+// and ([bd,An,Xn],od) pre-indexed. Any part may be left out, and zan or zpc
+// names a suppressed base. A form that fits the brief extension word uses
+// it, and (d16,An) is the plain displacement mode.
+func (asm *Assembler) tryParseIndexed020(s string) (Operand, bool, error) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return Operand{}, false, nil
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	// A lone index register, as in (d0.w*4), has a suppressed base.
+	lone := reIndexRegister.MatchString(inner) && !reAddressRegister.MatchString(inner)
+	if !strings.ContainsAny(inner, ",[") && !lone {
+		return Operand{}, false, nil
+	}
+	p, ok := splitIndexParts(inner)
+	if !ok {
+		return Operand{}, false, nil
+	}
+
+	op := Operand{Raw: s, Mode: cpu.ModeAddrIndex}
+	if p.base == "pc" {
+		op.Mode, op.Register = cpu.ModeOther, cpu.RegPCIndex
+	} else if p.base != "" {
+		n, _ := strconv.Atoi(p.base[1:])
+		op.Register = uint16(n)
+	}
+
+	var ext uint16
+	if p.index != "" {
+		m := reIndexRegister.FindStringSubmatch(p.index)
+		ext = indexBits(m[1], m[2], m[3], m[4])
+	}
+
+	bdSize, bd, err := asm.displacementSize(p.bd)
+	if err != nil {
+		if p.index == "" && !p.memory {
+			return Operand{}, false, nil // (d16,PC) and the like, with a label
+		}
+		return op, true, err
+	}
+
+	// Without an index, (d16,An) is the displacement mode, and (d16,PC) is
+	// left to the PC-relative parser.
+	if !p.memory && p.index == "" && p.base != "" && !p.suppressed && bdSize != 3 {
+		if p.base == "pc" {
+			return Operand{}, false, nil
+		}
+		op.Mode = cpu.ModeAddrDisp
+		op.ExtensionWords = bd
+		if bd == nil {
+			op.ExtensionWords = []uint16{0}
+		}
+		return op, true, nil
+	}
+
+	if !p.memory && p.index != "" && p.base != "" && !p.suppressed && !strings.ContainsRune(p.bd, '.') {
+		v := int64(0)
+		if len(bd) == 1 {
+			v = int64(int16(bd[0]))
+		}
+		if len(bd) < 2 && v >= -128 && v <= 127 {
+			op.ExtensionWords = []uint16{ext | uint16(uint8(int8(v)))}
+			return op, true, nil
+		}
+	}
+
+	odSize, od, err := asm.displacementSize(p.od)
+	if err != nil {
+		return op, true, err
+	}
+	ext |= 0x0100 | bdSize<<4
+	if p.base == "" || p.suppressed {
+		ext |= 0x0080
+	}
+	if p.index == "" {
+		ext |= 0x0040
+	}
+	if p.memory {
+		ext |= odSize
+		if p.indexPost {
+			ext |= 0x0004
+		}
+	} else if p.od != "" {
+		return op, true, fmt.Errorf("an outer displacement needs memory indirection: %s", s)
+	}
+	op.ExtensionWords = append(append([]uint16{ext}, bd...), od...)
+	return op, true, nil
+}
+
+// Operands of the 68020's instructions: register pairs for the 64-bit
+// multiply and divide (d1:d0) and CAS2 (d0:d1 and (a0):(a1)), and the
+// {offset:width} after a bit field operand.
+var (
+	reRegisterPair = regexp.MustCompile(`(?i)^([da][0-7]):([da][0-7])$`)
+	reIndirectPair = regexp.MustCompile(`(?i)^\(([da][0-7])\):\(([da][0-7])\)$`)
+	reBitField     = regexp.MustCompile(`^(.+)\{([^{}]*)\}$`)
+)
+
+// bitFieldOps maps the bit field mnemonics to bits 10-8 of their opcode.
+var bitFieldOps = map[string]uint16{
+	"bftst":  0,
+	"bfextu": 1,
+	"bfchg":  2,
+	"bfexts": 3,
+	"bfclr":  4,
+	"bfffo":  5,
+	"bfset":  6,
+	"bfins":  7,
+}
+
+// is68020Instruction checks if an instruction is one the 68020 added. The
+// long multiplies and divides are told from the 68000's by their size.
+func is68020Instruction(mn Mnemonic) bool {
+	switch mn.Value {
+	case "extb", "divsl", "divul", "cas", "cas2", "pack", "unpk":
+		return true
+	case "mulu", "muls", "divu", "divs":
+		return mn.Size == cpu.SizeLong
+	}
+	if _, ok := bitFieldOps[mn.Value]; ok {
+		return true
+	}
+	_, ok := trapCondition(mn.Value)
+	return ok
+}
+
+// trapCondition returns the condition of a TRAPcc mnemonic.
+func trapCondition(mn string) (uint16, bool) {
+	if !strings.HasPrefix(mn, "trap") {
+		return 0, false
+	}
+	cond, ok := cpu.ConditionCodes[mn[4:]]
+	return cond, ok
+}
+
+// assemble68020 assembles the instructions is68020Instruction accepts.
+func (asm *Assembler) assemble68020(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	switch mn.Value {
+	case "extb":
+		if len(operands) != 1 || operands[0].Mode != cpu.ModeData {
+			return nil, fmt.Errorf("EXTB requires a data register")
+		}
+		if mn.Size != cpu.SizeInvalid && mn.Size != cpu.SizeLong {
+			return nil, fmt.Errorf("EXTB is long only")
+		}
+		return []uint16{cpu.OPEXTB | operands[0].Register}, nil
+	case "mulu", "muls":
+		return asm.assembleMulDivLong(mn, cpu.OPMULL, operands)
+	case "divu", "divs", "divul", "divsl":
+		return asm.assembleMulDivLong(mn, cpu.OPDIVL, operands)
+	case "cas":
+		return asm.assembleCas(mn, operands)
+	case "cas2":
+		return assembleCas2(mn, operands)
+	case "pack", "unpk":
+		return asm.assemblePack(mn, operands)
+	}
+	if op, ok := bitFieldOps[mn.Value]; ok {
+		return asm.assembleBitField(mn, op, operands)
+	}
+	cond, _ := trapCondition(mn.Value)
+	return asm.assembleTrapcc(mn, cond, operands)
+}
+
+// registerPair returns the two registers of a pair operand such as d1:d0 or
+// (a0):(a1), as register word bits: the number in bits 14-12 and bit 15 set
+// for an address register.
+func registerPair(op Operand) (uint16, uint16, bool) {
+	m := reRegisterPair.FindStringSubmatch(op.Raw)
+	if m == nil {
+		m = reIndirectPair.FindStringSubmatch(op.Raw)
+	}
+	if op.Register != RegPair || m == nil {
+		return 0, 0, false
+	}
+	bits := func(s string) uint16 {
+		r := uint16(s[1]-'0') << 12
+		if s[0] == 'a' || s[0] == 'A' {
+			r |= 0x8000
+		}
+		return r
+	}
+	return bits(m[1]), bits(m[2]), true
+}
+
+// dataPair returns the numbers of the two data registers of a pair.
+func dataPair(op Operand) (uint16, uint16, bool) {
+	a, b, ok := registerPair(op)
+	if !ok || a&0x8000 != 0 || b&0x8000 != 0 {
+		return 0, 0, false
+	}
+	return a >> 12, b >> 12, true
+}
+
+// assembleMulDivLong assembles MULU.L, MULS.L, DIVU.L, DIVS.L, DIVUL.L and
+// DIVSL.L. Syntax:
+//
+//	MULU.L <ea>,Dl      ; and <ea>,Dh:Dl for a 64-bit product
+//	DIVU.L <ea>,Dq      ; and <ea>,Dr:Dq for a 64-bit dividend
+//	DIVUL.L <ea>,Dr:Dq  ; 32-bit dividend, keeping the remainder
+func (asm *Assembler) assembleMulDivLong(mn Mnemonic, opword uint16, operands []Operand) ([]uint16, error) {
+	name := strings.ToUpper(mn.Value) + ".L"
+	if len(operands) != 2 {
+		return nil, fmt.Errorf("%s requires 2 operands", name)
+	}
+	if mn.Size != cpu.SizeInvalid && mn.Size != cpu.SizeLong {
+		return nil, fmt.Errorf("%s is long only", strings.ToUpper(mn.Value))
+	}
+	src, dst := operands[0], operands[1]
+	if src.Mode == cpu.ModeAddr {
+		return nil, fmt.Errorf("%s can't take an address register", name)
+	}
+
+	var ext uint16
+	if strings.HasPrefix(mn.Value, "muls") || strings.HasPrefix(mn.Value, "divs") {
+		ext |= 0x0800
+	}
+	switch {
+	case dst.Mode == cpu.ModeData:
+		ext |= dst.Register << 12
+		if opword == cpu.OPDIVL {
+			// Dq doubles as Dr, so the remainder is dropped.
+			ext |= dst.Register
+		}
+	case dst.Register == RegPair:
+		hi, lo, ok := dataPair(dst)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a pair of data registers", name)
+		}
+		ext |= lo<<12 | hi
+		if mn.Value != "divul" && mn.Value != "divsl" {
+			ext |= 0x0400
+		}
+	default:
+		return nil, fmt.Errorf("destination for %s must be a data register or pair", name)
+	}
+
+	ea, eaExt, err := asm.encodeEA(src, cpu.SizeLong)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16{opword | ea, ext}, eaExt...), nil
+}
+
+// assembleCas assembles CAS Dc,Du,<ea>. The size defaults to word.
+func (asm *Assembler) assembleCas(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	if len(operands) != 3 || operands[0].Mode != cpu.ModeData || operands[1].Mode != cpu.ModeData {
+		return nil, fmt.Errorf("CAS requires Dc,Du,<ea>")
+	}
+	dst := operands[2]
+	if !isAlterableMemory(dst) {
+		return nil, fmt.Errorf("destination for CAS must be alterable memory")
+	}
+	var size uint16
+	switch mn.Size {
+	case cpu.SizeByte:
+		size = 1
+	case cpu.SizeWord, cpu.SizeInvalid:
+		size = 2
+	default:
+		size = 3
+	}
+	ea, eaExt, err := asm.encodeEA(dst, mn.Size)
+	if err != nil {
+		return nil, err
+	}
+	ext := operands[1].Register<<6 | operands[0].Register
+	return append([]uint16{cpu.OPCAS | size<<9 | ea, ext}, eaExt...), nil
+}
+
+// assembleCas2 assembles CAS2 Dc1:Dc2,Du1:Du2,(Rn1):(Rn2), word or long.
+func assembleCas2(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	if len(operands) != 3 {
+		return nil, fmt.Errorf("CAS2 requires Dc1:Dc2,Du1:Du2,(Rn1):(Rn2)")
+	}
+	dc1, dc2, ok1 := dataPair(operands[0])
+	du1, du2, ok2 := dataPair(operands[1])
+	rn1, rn2, ok3 := registerPair(operands[2])
+	if !ok1 || !ok2 || !ok3 || !reIndirectPair.MatchString(operands[2].Raw) {
+		return nil, fmt.Errorf("CAS2 requires Dc1:Dc2,Du1:Du2,(Rn1):(Rn2)")
+	}
+	opword := uint16(cpu.OPCAS2)
+	switch mn.Size {
+	case cpu.SizeWord, cpu.SizeInvalid:
+	case cpu.SizeLong:
+		opword |= 0x0200
+	default:
+		return nil, fmt.Errorf("CAS2 is word or long only")
+	}
+	return []uint16{opword, rn1 | du1<<6 | dc1, rn2 | du2<<6 | dc2}, nil
+}
+
+// assemblePack assembles PACK and UNPK, Dx,Dy,#adj or -(Ax),-(Ay),#adj.
+func (asm *Assembler) assemblePack(mn Mnemonic, operands []Operand) ([]uint16, error) {
+	name := strings.ToUpper(mn.Value)
+	if len(operands) != 3 || !operands[2].IsImmediate() {
+		return nil, fmt.Errorf("%s requires a source, a destination and an adjustment", name)
+	}
+	src, dst := operands[0], operands[1]
+	opword := uint16(cpu.OPPACK)
+	if mn.Value == "unpk" {
+		opword = cpu.OPUNPK
+	}
+	switch {
+	case src.Mode == cpu.ModeData && dst.Mode == cpu.ModeData:
+	case src.Mode == cpu.ModeAddrPreDec && dst.Mode == cpu.ModeAddrPreDec:
+		opword |= 0x0008
+	default:
+		return nil, fmt.Errorf("%s works on two data registers or two -(An)", name)
+	}
+	adj, err := asm.parseConstant(operands[2].Raw)
+	if err != nil {
+		return nil, err
+	}
+	if adj < -32768 || adj > 0xFFFF {
+		return nil, fmt.Errorf("%s adjustment out of range: %d", name, adj)
+	}
+	return []uint16{opword | dst.Register<<9 | src.Register, uint16(adj)}, nil
+}
+
+// assembleTrapcc assembles TRAPcc, TRAPcc.W #d16 and TRAPcc.L #d32.
+func (asm *Assembler) assembleTrapcc(mn Mnemonic, cond uint16, operands []Operand) ([]uint16, error) {
+	opword := cpu.OPTRAPcc | cond<<8
+	if len(operands) == 0 {
+		if mn.Size != cpu.SizeInvalid {
+			return nil, fmt.Errorf("%s without an operand takes no size", strings.ToUpper(mn.Value))
+		}
+		return []uint16{opword | 4}, nil
+	}
+	if len(operands) != 1 || !operands[0].IsImmediate() {
+		return nil, fmt.Errorf("%s takes one immediate operand", strings.ToUpper(mn.Value))
+	}
+	v, err := asm.parseConstant(operands[0].Raw)
+	if err != nil {
+		return nil, err
+	}
+	switch mn.Size {
+	case cpu.SizeWord:
+		return []uint16{opword | 2, uint16(v)}, nil
+	case cpu.SizeLong:
+		return []uint16{opword | 3, uint16(v >> 16), uint16(v)}, nil
+	}
+	return nil, fmt.Errorf("%s with an operand needs a .w or .l size", strings.ToUpper(mn.Value))
+}
+
+// assembleBitField assembles the bit field instructions. Syntax:
+//
+//	BFTST <ea>{offset:width}         ; and BFCHG, BFCLR, BFSET
+//	BFEXTU <ea>{offset:width},Dn     ; and BFEXTS, BFFFO
+//	BFINS Dn,<ea>{offset:width}
+//
+// The offset is 0 to 31 or a data register, and the width 1 to 32 or a
+// data register.
+func (asm *Assembler) assembleBitField(mn Mnemonic, op uint16, operands []Operand) ([]uint16, error) {
+	name := strings.ToUpper(mn.Value)
+	var field Operand
+	var ext uint16
+	switch {
+	case mn.Value == "bfins" && len(operands) == 2 && operands[0].Mode == cpu.ModeData:
+		field, ext = operands[1], operands[0].Register<<12
+	case (mn.Value == "bfextu" || mn.Value == "bfexts" || mn.Value == "bfffo") && len(operands) == 2 && operands[1].Mode == cpu.ModeData:
+		field, ext = operands[0], operands[1].Register<<12
+	case (op == 0 || op == 2 || op == 4 || op == 6) && len(operands) == 1:
+		field = operands[0]
+	default:
+		return nil, fmt.Errorf("wrong operands for %s", name)
+	}
+
+	offset, width, ok := strings.Cut(field.BitField, ":")
+	if field.BitField == "" || !ok {
+		return nil, fmt.Errorf("%s needs a {offset:width} after its operand", name)
+	}
+	bits, err := asm.bitFieldPart(offset, 0, 31)
+	if err != nil {
+		return nil, fmt.Errorf("%s offset: %w", name, err)
+	}
+	ext |= bits << 6
+	bits, err = asm.bitFieldPart(width, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s width: %w", name, err)
+	}
+	ext |= bits
+
+	control := field.Mode == cpu.ModeAddrInd || field.Mode == cpu.ModeAddrDisp || field.Mode == cpu.ModeAddrIndex ||
+		field.Mode == cpu.ModeOther && field.Register != cpu.RegImmediate
+	writes := op == 2 || op == 4 || op == 6 || op == 7
+	switch {
+	case field.Mode == cpu.ModeData:
+	case !control:
+		return nil, fmt.Errorf("%s needs a data register or a control addressing mode", name)
+	case writes && field.Mode == cpu.ModeOther && (field.Register == cpu.ModePCRelative || field.Register == cpu.RegPCIndex):
+		return nil, fmt.Errorf("%s can't write a PC-relative operand", name)
+	}
+	ea, eaExt, err := asm.encodeEA(field, cpu.SizeLong)
+	if err != nil {
+		return nil, err
+	}
+	return append([]uint16{cpu.OPBitField | op<<8 | ea, ext}, eaExt...), nil
+}
+
+// bitFieldPart returns the 6 bits of a bit field offset or width: a data
+// register with bit 5 set, or a number from lo to hi, where 32 encodes as 0.
+func (asm *Assembler) bitFieldPart(s string, lo, hi int64) (uint16, error) {
+	s = strings.TrimSpace(s)
+	if m := reDataRegister.FindStringSubmatch(s); m != nil {
+		return 0x20 | uint16(m[1][0]-'0'), nil
+	}
+	v, err := asm.parseConstant(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < lo || v > hi {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, lo, hi)
+	}
+	return uint16(v) & 31, nil
+}
@@ -0,0 +1,72 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WriteMap writes a plain-text map of the last assembly: label addresses,
+// EQU symbols, the NOP slides reserved for patching and the sizing totals.
+func (asm *Assembler) WriteMap(w io.Writer) error {
+	type entry struct {
+		name  string
+		value int64
+	}
+
+	var labels []entry
+	for name, addr := range asm.labels {
+		labels = append(labels, entry{name, int64(addr)})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].value == labels[j].value {
+			return labels[i].name < labels[j].name
+		}
+		return labels[i].value < labels[j].value
+	})
+
+	var symbols []entry
+	for name, val := range asm.symbols {
+		symbols = append(symbols, entry{name, val})
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].name < symbols[j].name })
+
+	if _, err := fmt.Fprintf(w, "; Labels\n"); err != nil {
+		return err
+	}
+	for _, l := range labels {
+		if _, err := fmt.Fprintf(w, "%08X  %s\n", l.value, l.name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "\n; Symbols\n"); err != nil {
+		return err
+	}
+	for _, s := range symbols {
+		if _, err := fmt.Fprintf(w, "%08X  %s\n", uint32(s.value), s.name); err != nil {
+			return err
+		}
+	}
+
+	if len(asm.patches) > 0 {
+		if _, err := fmt.Fprintf(w, "\n; Patch points\n"); err != nil {
+			return err
+		}
+		for _, p := range asm.patches {
+			if _, err := fmt.Fprintf(w, "%08X  %d bytes  %s\n", p.Address, p.Size, p.Label); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "\n; Missed size optimizations: %d (%d bytes)\n", asm.missed.Count, asm.missed.Bytes); err != nil {
+		return err
+	}
+	for _, warn := range asm.warnings {
+		if _, err := fmt.Fprintf(w, ";   %s\n", warn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -101,11 +101,15 @@ func (asm *Assembler) assembleMove(mn Mnemonic, operands []Operand, pc uint32) (
 
 // CanBeMoveq checks if the instruction can be encoded as MOVEQ.
 // MOVEQ encodes an immediate signed 8-bit constant (-128..127) into a data register.
+// MOVE.B and MOVE.W are left alone, since MOVEQ would also change the upper bits.
 func (asm *Assembler) CanBeMoveq(mn Mnemonic, src Operand, dst Operand) bool {
 	name := strings.ToLower(mn.Value)
 	if name != "move" && name != "moveq" {
 		return false
 	}
+	if name == "move" && (mn.Size == cpu.SizeByte || mn.Size == cpu.SizeWord) {
+		return false
+	}
 
 	if dst.Mode == cpu.ModeData && src.IsImmediate() {
 		val, err := asm.parseConstant(src.Raw)
@@ -24,18 +24,24 @@ func (asm *Assembler) assembleMovem(mn Mnemonic, operands []Operand) ([]uint16,
 	}
 
 	// MOVEM <reglist>, <ea> — store
-	if strings.Contains(src.Raw, "/") || strings.Contains(src.Raw, "-") {
+	if isRegisterList(src) {
 		return asm.assembleMovemStore(src, dst, sz)
 	}
 
 	// MOVEM <ea>, <reglist> — load
-	if strings.Contains(dst.Raw, "/") || strings.Contains(dst.Raw, "-") {
+	if isRegisterList(dst) {
 		return asm.assembleMovemLoad(src, dst, sz)
 	}
 
 	return nil, fmt.Errorf("invalid MOVEM syntax: must include register list")
 }
 
+// isRegisterList reports whether op is a MOVEM register list. A single
+// register is a list of one.
+func isRegisterList(op Operand) bool {
+	return op.Mode == cpu.ModeData || op.Mode == cpu.ModeAddr || op.Mode == cpu.ModeOther && op.Register == RegList
+}
+
 // Store form: MOVEM <reglist>, <ea>
 func (asm *Assembler) assembleMovemStore(src Operand, dst Operand, sz cpu.Size) ([]uint16, error) {
 	regmask, err := parseMovemList(src.Raw)
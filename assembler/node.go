@@ -19,5 +19,14 @@ type Node struct {
 	Mnemonic Mnemonic
 	Operands []Operand
 	Parts    []string
-	Size     uint32 // Still used to track size between passes
+	Line     int    // Source line number, starting at 1
+	Size     uint32 // Decided once by the sizing pass
+	// LabelModes holds the addressing sub-mode (ModeAbsLong or ModePCRelative)
+	// chosen for each bare label operand during sizing.
+	LabelModes []uint16
+	// HasLabels is set when any operand refers to a label, so the encoding
+	// can only be finished once label addresses are known.
+	HasLabels bool
+	// Code caches the encoded words of instructions without label references.
+	Code []uint16
 }
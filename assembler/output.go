@@ -0,0 +1,105 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Program is the result of an assembly, as handed to an OutputFormat.
+type Program struct {
+	// Code is the main output, to be loaded at Origin.
+	Code []byte
+	// Origin is the address of the first byte of Code.
+	Origin uint32
+	// Entry is the address to start at, valid if HasEntry is set: the label
+	// named by END, if the source has one.
+	Entry    uint32
+	HasEntry bool
+	// Labels holds the address of every label.
+	Labels map[string]uint32
+	// Banks are the overlays, in source order.
+	Banks []Bank
+}
+
+// Program returns the result of the last assembly.
+func (asm *Assembler) Program() *Program {
+	p := &Program{
+		Code:   asm.output,
+		Origin: asm.origin,
+		Labels: asm.Labels(),
+		Banks:  asm.banks,
+	}
+	p.Entry, p.HasEntry = asm.Entry()
+	return p
+}
+
+// OutputFormat writes an assembled program in a file format, such as a raw
+// binary, a hex format for EPROM programmers or an executable for an
+// operating system.
+type OutputFormat interface {
+	Write(program *Program, w io.Writer) error
+}
+
+// OutputFormatFunc adapts a function to an OutputFormat.
+type OutputFormatFunc func(program *Program, w io.Writer) error
+
+// Write calls f.
+func (f OutputFormatFunc) Write(program *Program, w io.Writer) error {
+	return f(program, w)
+}
+
+// rawFormat writes the code as a flat binary.
+type rawFormat struct{}
+
+// Write writes the code as it is to be loaded.
+func (rawFormat) Write(program *Program, w io.Writer) error {
+	_, err := w.Write(program.Code)
+	return err
+}
+
+var (
+	formatsMu     sync.RWMutex
+	outputFormats = map[string]OutputFormat{"raw": rawFormat{}}
+)
+
+// RegisterOutputFormat makes f available under name, which is
+// case-insensitive. Each name can only be registered once.
+func RegisterOutputFormat(name string, f OutputFormat) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.ContainsAny(name, " \t,") {
+		return fmt.Errorf("invalid output format name: %q", name)
+	}
+	if f == nil {
+		return fmt.Errorf("no writer for output format %s", name)
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, ok := outputFormats[name]; ok {
+		return fmt.Errorf("output format %s is already registered", name)
+	}
+	outputFormats[name] = f
+	return nil
+}
+
+// LookupOutputFormat returns the output format registered under name.
+func LookupOutputFormat(name string) (OutputFormat, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := outputFormats[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format: %s", name)
+	}
+	return f, nil
+}
+
+// OutputFormats returns the names of the registered output formats, sorted.
+func OutputFormats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return slices.Sorted(maps.Keys(outputFormats))
+}
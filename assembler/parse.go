@@ -13,6 +13,9 @@ import (
 type Mnemonic struct {
 	Value string
 	Size  cpu.Size
+	// Suffix is the size suffix as written, which FPU instructions need to
+	// tell .s (single) from .b.
+	Suffix string
 }
 
 // Operand represents a parsed instruction operand.
@@ -22,6 +25,8 @@ type Operand struct {
 	ExtensionWords []uint16
 	Raw            string
 	Label          string
+	// BitField is the offset:width in braces after a bit field operand.
+	BitField string
 }
 
 // IsImmediate returns true if this operand is an immediate constant.
@@ -39,12 +44,15 @@ var (
 	reAbsoluteParenShort = regexp.MustCompile(`(?i)^\(([a-fA-F0-9\$\-%]+)\)\.w$`)
 	reAbsoluteParenLong  = regexp.MustCompile(`(?i)^\(([a-fA-F0-9\$\-%]+)\)\.l$`)
 	reAbsoluteDollarSize = regexp.MustCompile(`(?i)^\$([a-fA-F0-9]+)\.(w|l)$`)
-	reAddressIndex       = regexp.MustCompile(`(?i)^([a-fA-F0-9\$\-%]*)\(a([0-7]),(d|a)([0-7])\.(w|l)\)$`)
+	reAbsoluteLabelSize  = regexp.MustCompile(`(?i)^(?:\(([a-z_][a-z0-9_]*)\)|([a-z_][a-z0-9_]*))\.(w|l)$`)
+	reAddressIndex       = regexp.MustCompile(`(?i)^([a-fA-F0-9\$\-%]*)\(a([0-7]),(d|a)([0-7])\.(w|l)(?:\*([1248]))?\)$`)
 	rePCRelDispParen     = regexp.MustCompile(`(?i)^\(([a-fA-F0-9\$\-%]+),\s*pc\)$`)
 	rePCRelDisp          = regexp.MustCompile(`(?i)^([a-zA-Z0-9_\$\-%]+)\(pc\)$`)
-	rePCRelIndex         = regexp.MustCompile(`(?i)^([a-fA-F0-9\$\-%]*)\(pc,(d|a)([0-7])\.(w|l)\)$`)
+	rePCRelIndex         = regexp.MustCompile(`(?i)^([a-fA-F0-9\$\-%]*)\(pc,(d|a)([0-7])\.(w|l)(?:\*([1248]))?\)$`)
 	reAbsoluteSimple     = regexp.MustCompile(`(?i)^\$[a-fA-F0-9]+$`)
 	reLabel              = regexp.MustCompile(`(?i)^[a-z_][a-z0-9_]*$`)
+	reRegisterList       = regexp.MustCompile(`(?i)^[ad][0-7](-[ad][0-7])?(/[ad][0-7](-[ad][0-7])?)*$`)
+	reFPRegisterList     = regexp.MustCompile(`(?i)^(fp[0-7](-fp[0-7])?|fpcr|fpsr|fpiar)(/(fp[0-7](-fp[0-7])?|fpcr|fpsr|fpiar))*$`)
 )
 
 // ParseMnemonic splits an instruction like "MOVE.W" → ("move", SizeWord).
@@ -52,6 +60,7 @@ func ParseMnemonic(s string) (Mnemonic, error) {
 	parts := strings.Split(strings.ToLower(s), ".")
 	mn := Mnemonic{Value: parts[0], Size: cpu.SizeInvalid}
 	if len(parts) > 1 {
+		mn.Suffix = parts[1]
 		switch parts[1] {
 		case "b", "s":
 			mn.Size = cpu.SizeByte
@@ -59,6 +68,11 @@ func ParseMnemonic(s string) (Mnemonic, error) {
 			mn.Size = cpu.SizeWord
 		case "l":
 			mn.Size = cpu.SizeLong
+		case "x", "d", "p":
+			// Extended, double and packed real, for the FPU only.
+			if !isFPUMnemonic(mn.Value) {
+				return mn, fmt.Errorf("invalid size suffix: %s", parts[1])
+			}
 		default:
 			return mn, fmt.Errorf("invalid size suffix: %s", parts[1])
 		}
@@ -71,11 +85,25 @@ func ParseMnemonic(s string) (Mnemonic, error) {
 func (asm *Assembler) parseOperand(s string) (Operand, error) {
 	s = strings.TrimSpace(s)
 
+	// The 68020's bit fields follow an ordinary operand.
+	if m := reBitField.FindStringSubmatch(s); m != nil {
+		op, err := asm.parseOperand(m[1])
+		op.BitField = m[2]
+		return op, err
+	}
+	if reRegisterPair.MatchString(s) || reIndirectPair.MatchString(s) {
+		return Operand{Raw: s, Mode: cpu.ModeOther, Register: RegPair}, nil
+	}
+
 	// Handle special registers first
 	if op, ok, err := tryParseStatusReg(s); ok || err != nil {
 		return op, err
 	}
 
+	if reFPRegisterList.MatchString(s) {
+		return Operand{Raw: s, Mode: cpu.ModeOther, Register: RegFPList}, nil
+	}
+
 	// Try each group of modes in a specific order to avoid ambiguity.
 	// More complex/specific patterns should be tried before more general ones.
 	if op, ok, err := asm.tryParseIndexedModes(s); ok || err != nil {
@@ -84,6 +112,9 @@ func (asm *Assembler) parseOperand(s string) (Operand, error) {
 	if op, ok, err := asm.tryParseRegisterModes(s); ok || err != nil {
 		return op, err
 	}
+	if reRegisterList.MatchString(s) {
+		return Operand{Raw: s, Mode: cpu.ModeOther, Register: RegList}, nil
+	}
 	if op, ok, err := asm.tryParsePCModes(s); ok || err != nil {
 		return op, err
 	}
@@ -104,17 +135,19 @@ func (asm *Assembler) parseOperand(s string) (Operand, error) {
 
 // Helper Functions for Parsing Operand Groups
 
-// tryParseStatusReg handles sr, ccr, and usp.
+// tryParseStatusReg handles sr, ccr, and the control registers usp, sfc, dfc
+// and vbr.
 func tryParseStatusReg(s string) (Operand, bool, error) {
 	lcs := strings.ToLower(s)
-	if lcs == "sr" || lcs == "ccr" || lcs == "usp" {
+	if _, ok := controlRegisters[lcs]; ok || lcs == "sr" || lcs == "ccr" {
 		op := Operand{Raw: s, Mode: cpu.ModeOther, Register: RegStatus}
 		return op, true, nil
 	}
 	return Operand{}, false, nil
 }
 
-// tryParseIndexedModes handles (d8,An,Xn) and (d8,PC,Xn).
+// tryParseIndexedModes handles d8(An,Xn), d8(PC,Xn) and the parenthesised
+// forms, including the 68020's.
 func (asm *Assembler) tryParseIndexedModes(s string) (Operand, bool, error) {
 	if m := reAddressIndex.FindStringSubmatch(s); m != nil {
 		op, err := asm.parseAddressIndex(m)
@@ -124,6 +157,9 @@ func (asm *Assembler) tryParseIndexedModes(s string) (Operand, bool, error) {
 		op, err := asm.parsePCRelIndex(m)
 		return op, true, err
 	}
+	if op, ok, err := asm.tryParseIndexed020(s); ok || err != nil {
+		return op, true, err
+	}
 	return Operand{}, false, nil
 }
 
@@ -205,6 +241,23 @@ func (asm *Assembler) tryParsePCModes(s string) (Operand, bool, error) {
 // tryParseAbsoluteModes handles all absolute addressing forms.
 func (asm *Assembler) tryParseAbsoluteModes(s string) (Operand, bool, error) {
 	op := Operand{Raw: s}
+	// label.w, label.l, (label).w and (label).l force absolute addressing for a label.
+	// EQU symbols fall through to the numeric forms below.
+	if m := reAbsoluteLabelSize.FindStringSubmatch(s); m != nil {
+		name := strings.ToLower(m[1] + m[2])
+		if _, ok := asm.symbols[name]; !ok {
+			op.Mode = cpu.ModeOther
+			op.Label = name
+			if strings.ToLower(m[3]) == "w" {
+				op.Register = cpu.RegAbsShort
+				op.ExtensionWords = []uint16{0}
+			} else {
+				op.Register = cpu.RegAbsLong
+				op.ExtensionWords = []uint16{0, 0}
+			}
+			return op, true, nil
+		}
+	}
 	if m := reAbsoluteParenShort.FindStringSubmatch(s); m != nil {
 		val, err := asm.parseConstant(m[1])
 		if err != nil {
@@ -270,6 +323,12 @@ func (asm *Assembler) tryParseImmediateMode(s string) (Operand, bool, error) {
 	op := Operand{Raw: s}
 	val, err := asm.parseConstant(s[1:]) // Parse the string after the '#'
 	if err != nil {
+		if _, ferr := strconv.ParseFloat(s[1:], 64); ferr == nil {
+			// A real, for the FPU, which encodes it once it knows the format.
+			op.Mode = cpu.ModeOther
+			op.Register = cpu.RegImmediate
+			return op, true, nil
+		}
 		return op, false, err
 	}
 
@@ -315,17 +374,7 @@ func (asm *Assembler) parseAddressIndex(m []string) (Operand, error) {
 
 	an, _ := strconv.Atoi(m[2])
 	op.Register = uint16(an)
-
-	xnType := strings.ToLower(m[3])
-	xn, _ := strconv.Atoi(m[4])
-	ext |= (uint16(xn) << 12)
-	if xnType == "a" {
-		ext |= 0x8000
-	}
-	if strings.ToLower(m[5]) == "l" {
-		ext |= 0x0800
-	}
-
+	ext |= indexBits(m[3], m[4], m[5], m[6])
 	op.ExtensionWords = []uint16{ext}
 	return op, nil
 }
@@ -344,16 +393,7 @@ func (asm *Assembler) parsePCRelIndex(m []string) (Operand, error) {
 		}
 	}
 	ext |= uint16(uint8(int8(disp)))
-
-	xnType := strings.ToLower(m[2])
-	xn, _ := strconv.Atoi(m[3])
-	ext |= (uint16(xn) << 12)
-	if xnType == "a" {
-		ext |= 0x8000
-	}
-	if strings.ToLower(m[4]) == "l" {
-		ext |= 0x0800
-	}
+	ext |= indexBits(m[2], m[3], m[4], m[5])
 
 	op.ExtensionWords = []uint16{ext}
 	return op, nil
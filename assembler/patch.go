@@ -0,0 +1,113 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// DefaultPatchNops is the length of a PATCH slide without a count: room for a
+// JMP or JSR to an absolute long address.
+const DefaultPatchNops = 3
+
+// PatchPoint is a slide of NOPs reserved so a binary patch tool can later
+// overwrite it, typically with a jump to new code.
+type PatchPoint struct {
+	// Address is where the slide starts.
+	Address uint32
+	// Size is the length of the slide in bytes.
+	Size uint32
+	// Label names the routine the slide starts, if a label is at its address.
+	Label string
+	// Line is the source line of the PATCH directive, or of the label for
+	// slides added by PatchPad.
+	Line int
+}
+
+// PatchPoints returns the NOP slides reserved by the last assembly, in the
+// order they were emitted.
+func (asm *Assembler) PatchPoints() []PatchPoint {
+	return asm.patches
+}
+
+// patchSize returns the size in bytes of a PATCH directive: PATCH [count]
+// reserves count NOPs.
+func (asm *Assembler) patchSize(n *Node) (uint32, error) {
+	count := int64(DefaultPatchNops)
+	if len(n.Parts) > 1 {
+		var err error
+		count, err = asm.parseConstant(n.Parts[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid count for patch: %v", err)
+		}
+		if count < 1 || count > 0x8000 {
+			return 0, fmt.Errorf("patch count %d out of range", count)
+		}
+	}
+	return uint32(count) * 2, nil
+}
+
+// assemblePatch emits the NOP slide for a PATCH directive.
+func (asm *Assembler) assemblePatch(n *Node, pc uint32) ([]byte, error) {
+	if pc%2 != 0 {
+		return nil, fmt.Errorf("patch at odd address $%08X", pc)
+	}
+	size, err := asm.patchSize(n)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]uint16, size/2)
+	for i := range words {
+		words[i] = cpu.OPNOP
+	}
+	return cpu.WordsToBytes(words), nil
+}
+
+// padFunctions inserts a PATCH slide of PatchPad NOPs at the entry of every
+// routine called with BSR or JSR, unless the source already reserves one there.
+func (asm *Assembler) padFunctions(nodes []*Node) []*Node {
+	if asm.PatchPad <= 0 {
+		return nodes
+	}
+
+	called := make(map[string]bool)
+	for _, n := range nodes {
+		if n.Type != NodeInstruction || (n.Mnemonic.Value != "bsr" && n.Mnemonic.Value != "jsr") {
+			continue
+		}
+		for _, op := range n.Operands {
+			if op.Label != "" {
+				called[op.Label] = true
+			}
+		}
+	}
+
+	padded := make([]*Node, 0, len(nodes)+len(called))
+	var entry *Node
+	for _, n := range nodes {
+		if n.Type == NodeLabel {
+			if called[n.Label] {
+				entry = n
+			}
+			padded = append(padded, n)
+			continue
+		}
+		if entry != nil && !isPatch(n) {
+			padded = append(padded, &Node{
+				Type:  NodeDirective,
+				Parts: []string{"patch", strconv.Itoa(asm.PatchPad)},
+				Line:  entry.Line,
+			})
+		}
+		entry = nil
+		padded = append(padded, n)
+	}
+	return padded
+}
+
+// isPatch reports whether n is a PATCH directive.
+func isPatch(n *Node) bool {
+	return n.Type == NodeDirective && strings.TrimPrefix(strings.ToLower(n.Parts[0]), ".") == "patch"
+}
@@ -0,0 +1,104 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrTooLarge is returned, wrapped, when the output exceeds MaxSize or MAXSIZE.
+var ErrTooLarge = errors.New("output exceeds the maximum size")
+
+// Section is the output from one label up to the next.
+type Section struct {
+	// Name is the label starting the section, or "" for output before the first label.
+	Name string
+	// Start is the address of the section.
+	Start uint32
+	// Size is the number of bytes emitted in the section.
+	Size uint32
+}
+
+// Sections returns the sections of the last assembly in source order.
+func (asm *Assembler) Sections() []Section {
+	return asm.sections
+}
+
+// startSection begins a new section at a label.
+func (asm *Assembler) startSection(name string, pc uint32) {
+	asm.sections = append(asm.sections, Section{Name: name, Start: pc})
+}
+
+// addToSection counts size bytes at pc towards the current section.
+func (asm *Assembler) addToSection(pc, size uint32) {
+	if len(asm.sections) == 0 {
+		asm.sections = append(asm.sections, Section{Start: pc})
+	}
+	asm.sections[len(asm.sections)-1].Size += size
+}
+
+// maxSize returns the size limit in effect, or 0 for none.
+func (asm *Assembler) maxSize() uint32 {
+	if asm.MaxSize != 0 {
+		return asm.MaxSize
+	}
+	return asm.declaredMax
+}
+
+// checkMaxSize fails if size is over the limit, naming the largest sections.
+func (asm *Assembler) checkMaxSize(size uint32) error {
+	limit := asm.maxSize()
+	if limit == 0 || size <= limit {
+		return nil
+	}
+
+	largest := make([]Section, len(asm.sections))
+	copy(largest, asm.sections)
+	sort.SliceStable(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+	if len(largest) > 3 {
+		largest = largest[:3]
+	}
+	msg := ""
+	for i, s := range largest {
+		if i > 0 {
+			msg += ", "
+		}
+		msg += fmt.Sprintf("%s %d", sectionName(s), s.Size)
+	}
+	return fmt.Errorf("%w: %d bytes is %d over the limit of %d (largest sections: %s)", ErrTooLarge, size, size-limit, limit, msg)
+}
+
+// WriteSections writes the size of every section of the last assembly, largest first,
+// with its share of the limit when one is set.
+func (asm *Assembler) WriteSections(w io.Writer) error {
+	list := make([]Section, len(asm.sections))
+	copy(list, asm.sections)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Size > list[j].Size })
+
+	limit := asm.maxSize()
+	if _, err := fmt.Fprintf(w, "; Section                Start     Bytes\n"); err != nil {
+		return err
+	}
+	var total uint32
+	for _, s := range list {
+		total += s.Size
+		if _, err := fmt.Fprintf(w, "  %-22s %08X  %d\n", sectionName(s), s.Start, s.Size); err != nil {
+			return err
+		}
+	}
+	if limit == 0 {
+		_, err := fmt.Fprintf(w, "; Total %d bytes\n", total)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "; Total %d of %d bytes (%.1f%%)\n", total, limit, float64(total)*100/float64(limit))
+	return err
+}
+
+// sectionName names a section for reports.
+func sectionName(s Section) string {
+	if s.Name == "" {
+		return "(start)"
+	}
+	return s.Name
+}
@@ -0,0 +1,102 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"sort"
+	"strings"
+)
+
+// addReferences records every identifier in an operand field as used on line.
+// Numbers ($1F, %101, 0x10, 12) and quoted strings are skipped.
+func (asm *Assembler) addReferences(s string, line int) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end == -1 {
+				return
+			}
+			i += end + 2
+
+		case c == '$' || c == '%' || c == '.' || isDigit(c):
+			// Skip the whole token so hex digits and size suffixes aren't taken as names.
+			i++
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+
+		case isIdentChar(c):
+			start := i
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			name := strings.ToLower(s[start:i])
+			refs := asm.refLines[name]
+			if len(refs) == 0 || refs[len(refs)-1] != line {
+				asm.refLines[name] = append(refs, line)
+			}
+
+		default:
+			i++
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c|0x20) >= 'a' && (c|0x20) <= 'z'
+}
+
+// Labels returns the address of every label from the last assembly.
+func (asm *Assembler) Labels() map[string]uint32 {
+	return maps.Clone(asm.labels)
+}
+
+// WriteSymbolReport writes every EQU symbol and label from the last assembly
+// with its final value, the line it was defined on and the lines that used it.
+func (asm *Assembler) WriteSymbolReport(w io.Writer) error {
+	type entry struct {
+		name  string
+		kind  string
+		value uint32
+	}
+
+	var entries []entry
+	for name, val := range asm.symbols {
+		entries = append(entries, entry{name, "equ", uint32(val)})
+	}
+	for name, addr := range asm.labels {
+		entries = append(entries, entry{name, "label", addr})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	if _, err := fmt.Fprintf(w, "; %-22s %-8s  %-5s  %-7s  %s\n", "Name", "Value", "Kind", "Defined", "Used on lines"); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		def := "-"
+		if line, ok := asm.defLines[e.name]; ok {
+			def = fmt.Sprint(line)
+		}
+
+		used := "-"
+		if refs := asm.refLines[e.name]; len(refs) > 0 {
+			s := make([]string, len(refs))
+			for i, l := range refs {
+				s[i] = fmt.Sprint(l)
+			}
+			used = strings.Join(s, ", ")
+		}
+
+		if _, err := fmt.Fprintf(w, "  %-22s %08X  %-5s  %-7s  %s\n", e.name, e.value, e.kind, def, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,13 +7,15 @@ import (
 	"github.com/Urethramancer/m68k/cpu"
 )
 
-// assembleTrap handles TRAP and TRAPV instructions.
+// assembleTrap handles TRAP and TRAPV instructions, and Easy68K's SIMHALT.
 func (asm *Assembler) assembleTrap(mn Mnemonic, operands []Operand) ([]uint16, error) {
 	switch strings.ToLower(mn.Value) {
 	case "trap":
 		return asm.assembleTrapImmediate(operands)
 	case "trapv":
 		return assembleTrapv(operands)
+	case "simhalt":
+		return assembleSimhalt(operands)
 	default:
 		return nil, fmt.Errorf("unknown trap instruction: %s", mn.Value)
 	}
@@ -50,3 +52,13 @@ func assembleTrapv(operands []Operand) ([]uint16, error) {
 	}
 	return []uint16{cpu.OPTRAPV}, nil
 }
+
+// assembleSimhalt assembles SIMHALT, which stops the Easy68K simulator, as
+// MOVEQ #9,D0 and TRAP #15. That halts run68 whether or not it takes TRAP #15
+// as Easy68K system calls, and is as long as Easy68K's own encoding.
+func assembleSimhalt(operands []Operand) ([]uint16, error) {
+	if len(operands) != 0 {
+		return nil, fmt.Errorf("SIMHALT takes no operands")
+	}
+	return []uint16{cpu.OPMOVEQ | 9, cpu.OPTRAP | 15}, nil
+}
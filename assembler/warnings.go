@@ -0,0 +1,94 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Warning is a non-fatal message tied to a source line.
+type Warning struct {
+	Line    int
+	Message string
+}
+
+// String formats the warning as "line N: message".
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+}
+
+// SizingStats totals the forward references that were sized for the worst case
+// but could have used a shorter encoding.
+type SizingStats struct {
+	// Count is the number of instructions that could have been shorter.
+	Count int
+	// Bytes is the total number of bytes that could have been saved.
+	Bytes uint32
+}
+
+// Warnings returns the warnings collected by the last call to Assemble.
+func (asm *Assembler) Warnings() []Warning {
+	return asm.warnings
+}
+
+// SizingStats returns the missed size optimizations from the last call to Assemble.
+// The totals are collected whether or not WarnSizing is set.
+func (asm *Assembler) SizingStats() SizingStats {
+	return asm.missed
+}
+
+// checkSizing compares the size picked for a node during sizing with what its
+// final label addresses would have allowed.
+func (asm *Assembler) checkSizing(n *Node, pc uint32) {
+	if isBranchMnemonic(n.Mnemonic.Value) {
+		// DBcc only has a word form, and explicit sizes are the user's call.
+		if strings.HasPrefix(n.Mnemonic.Value, "db") || n.Mnemonic.Size != cpu.SizeInvalid || n.Size != 4 || len(n.Operands) == 0 {
+			return
+		}
+
+		label := strings.ToLower(strings.TrimSpace(n.Operands[0].Raw))
+		target, ok := asm.labels[label]
+		if !ok {
+			return
+		}
+
+		offset := int32(target) - int32(pc+2)
+		if offset != 0 && offset >= -128 && offset <= 127 {
+			asm.missedSizing(n, 2, fmt.Sprintf("forward branch to '%s' sized as %s.w, but %s.s would fit", label, n.Mnemonic.Value, n.Mnemonic.Value))
+		}
+		return
+	}
+
+	for i, op := range n.Operands {
+		if op.Mode != cpu.ModeOther || op.Register != RegLabel || i >= len(n.LabelModes) {
+			continue
+		}
+		if n.LabelModes[i] != cpu.ModeAbsLong || !asm.labelPCRelative(n, i) {
+			continue
+		}
+
+		target, ok := asm.labels[op.Label]
+		if !ok {
+			continue
+		}
+
+		offset := int32(target) - int32(pc+2)
+		if offset >= -32768 && offset <= 32767 {
+			asm.missedSizing(n, 2, fmt.Sprintf("forward reference to '%s' encoded as absolute long, but %s(pc) would fit", op.Label, op.Label))
+		}
+	}
+}
+
+// missedSizing adds to the totals and the node's encoding record, which
+// has just been made, and, if enabled, records a warning.
+func (asm *Assembler) missedSizing(n *Node, saved uint32, msg string) {
+	asm.missed.Count++
+	asm.missed.Bytes += saved
+	if last := len(asm.encodings) - 1; last >= 0 {
+		asm.encodings[last].Missed = append(asm.encodings[last].Missed, msg)
+	}
+	if asm.WarnSizing {
+		asm.warnings = append(asm.warnings, Warning{Line: n.Line, Message: msg})
+	}
+}
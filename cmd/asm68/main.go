@@ -1,11 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
 	"github.com/Urethramancer/m68k/disassembler"
+	"github.com/Urethramancer/m68k/internal/verify"
 	"github.com/grimdork/climate/arg"
 	"github.com/grimdork/climate/str"
 )
@@ -25,6 +32,76 @@ func main() {
 		os.Exit(1)
 	}
 
+	var formats []any
+	for _, name := range assembler.OutputFormats() {
+		formats = append(formats, name)
+	}
+	err = opt.SetOption(arg.GroupDefault, "f", "format", "Output file format", "raw", false, arg.VarString, formats)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "m", "map", "Write a map file with label addresses and sizing totals", "", false, arg.VarString, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "s", "symbols", "Write a report of every symbol, its value and the lines using it", "", false, arg.VarString, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetFlag(arg.GroupDefault, "w", "warn-size", "Warn about forward references that could use a shorter form")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "e", "encodings", "Write a JSON record of how every line was encoded: words, addressing modes, branch sizes and optimizations", "", false, arg.VarString, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetFlag(arg.GroupDefault, "v", "verify", "Disassemble every instruction and check that it assembles back to the same words")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "l", "labels", "Addressing for bare labels: auto, pc or abs", "auto", false, arg.VarString, []any{"auto", "pc", "abs"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "x", "max-size", "Fail if the output is larger than this many bytes (e.g. 65536, $10000 or 64k)", "", false, arg.VarString, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "p", "patch-pad", "Reserve this many NOPs at the entry of every routine called with BSR or JSR, for later patching", 0, false, arg.VarInt, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetOption(arg.GroupDefault, "c", "cpu", "Target CPU until a MACHINE directive: 68000, 68010, 68020, 68040 or 68060", "68000", false, arg.VarString, []any{"68000", "68010", "68020", "68040", "68060"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = opt.SetFlag(arg.GroupDefault, "u", "fpu", "Accept the FPU instructions for a 68020 target, as with a 68881 or 68882, until an FPU directive")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting option: %v\n", err)
+		os.Exit(1)
+	}
+
 	err = opt.Parse(os.Args[1:])
 	if err != nil {
 		if err == arg.ErrNoArgs {
@@ -70,21 +147,200 @@ func main() {
 
 	fmt.Printf("Read %d bytes of source code.\n", count)
 	asm := assembler.New()
+	// INCLUDE looks next to the source files first.
+	for _, fn := range files {
+		dir := filepath.Dir(fn)
+		if !slices.Contains(asm.IncludeDirs, dir) {
+			asm.IncludeDirs = append(asm.IncludeDirs, dir)
+		}
+	}
+	asm.WarnSizing = opt.GetBool("warn-size")
+	asm.FPU = opt.GetBool("fpu")
+	asm.PatchPad = opt.GetInt("patch-pad")
+	asm.LabelMode, err = assembler.ParseLabelAddressing(opt.GetString("labels"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	asm.Target, err = cpu.ParseModel(opt.GetString("cpu"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if ms := opt.GetString("max-size"); ms != "" {
+		asm.MaxSize, err = parseSize(ms)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	code, err := asm.Assemble(string(src.String()), 0)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Assembly error: %v\n", err)
+		if errors.Is(err, assembler.ErrTooLarge) {
+			asm.WriteSections(os.Stderr)
+		}
 		os.Exit(1)
 	}
 
+	for _, w := range asm.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	if opt.GetBool("verify") {
+		mismatches := verify.Check(asm.Encodings())
+		for _, m := range mismatches {
+			fmt.Fprintf(os.Stderr, "Verify: %s\n", m)
+		}
+		if len(mismatches) > 0 {
+			fmt.Fprintf(os.Stderr, "%d instructions failed verification.\n", len(mismatches))
+			os.Exit(1)
+		}
+
+		var count int
+		for _, e := range asm.Encodings() {
+			if len(e.Words) > 0 {
+				count++
+			}
+		}
+		fmt.Printf("Verified %d instructions.\n", count)
+	}
+
+	if mapfn := opt.GetString("map"); mapfn != "" {
+		f, err := os.Create(mapfn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating map file: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = asm.WriteMap(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing map file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if symfn := opt.GetString("symbols"); symfn != "" {
+		f, err := os.Create(symfn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating symbol report: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = asm.WriteSymbolReport(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing symbol report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if encfn := opt.GetString("encodings"); encfn != "" {
+		f, err := os.Create(encfn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating encoding record: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = asm.WriteEncodings(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing encoding record: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	fn := opt.GetString("out")
 	if fn != "" {
-		if err := os.WriteFile(fn, code, 0644); err != nil {
+		format := opt.GetString("format")
+		if err := writeOutput(asm, format, fn); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Assembled binary written in M68K big-endian format to %s\n", fn)
+		if format == "raw" {
+			fmt.Printf("Assembled binary written in M68K big-endian format to %s\n", fn)
+		} else {
+			fmt.Printf("Assembled program written in %s format to %s\n", format, fn)
+		}
+		if err := writeBanks(asm, fn); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing banks: %v\n", err)
+			os.Exit(1)
+		}
 		return
 	}
 
 	disassembler.Hexdump(code)
+	for _, b := range asm.Banks() {
+		fmt.Printf("\nBank %d at $%08X:\n", b.Number, b.Start)
+		disassembler.Hexdump(b.Data)
+	}
+}
+
+// writeOutput writes the assembled program to fn in the named output format.
+func writeOutput(asm *assembler.Assembler, format, fn string) error {
+	of, err := assembler.LookupOutputFormat(format)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if err := of.Write(asm.Program(), f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeBanks writes each bank next to the main output as name.bankN.ext, and the
+// routing table as name.banks.
+func writeBanks(asm *assembler.Assembler, fn string) error {
+	banks := asm.Banks()
+	if len(banks) == 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(fn)
+	base := strings.TrimSuffix(fn, ext)
+	for _, b := range banks {
+		name := fmt.Sprintf("%s.bank%d%s", base, b.Number, ext)
+		if err := os.WriteFile(name, b.Data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Bank %d written to %s\n", b.Number, name)
+	}
+
+	f, err := os.Create(base + ".banks")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return asm.WriteBankTable(f)
+}
+
+// parseSize parses a byte count in decimal, $hex or 0xhex, with an optional k or m suffix.
+func parseSize(s string) (uint32, error) {
+	mult := uint64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult = 1024
+		s = s[:len(s)-1]
+	case "m":
+		mult = 1024 * 1024
+		s = s[:len(s)-1]
+	}
+	if strings.HasPrefix(s, "$") {
+		s = "0x" + s[1:]
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil || n == 0 || n*mult > 1<<32-1 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return uint32(n * mult), nil
 }
@@ -1,45 +1,227 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/Urethramancer/m68k/cpu"
 	"github.com/Urethramancer/m68k/disassembler"
+	"github.com/Urethramancer/m68k/patch"
+	"github.com/Urethramancer/m68k/radix"
+)
+
+var (
+	stringsMode = flag.Bool("strings", false, "List printable strings outside the code instead of disassembling.")
+	minLength   = flag.Int("min", 4, "Minimum string length for -strings.")
+	withLabels  = flag.Bool("labels", false, "Emit -strings output as labelled dc.b directives.")
+	regionsMode = flag.Bool("regions", false, "Print an entropy and region classification summary instead of disassembling.")
+	annotate    = flag.Bool("annotate", false, "Mark region boundaries in the disassembly.")
+	profile     = flag.String("profile", "", "Hardware profile for annotations ("+strings.Join(disassembler.Profiles(), ", ")+").")
+	blockSize   = flag.Int("block", disassembler.DefaultBlockSize, "Block size in bytes for region classification.")
+	patchFile   = flag.String("patch", "", "Apply this IPS or BPS patch in memory before disassembling.")
+	symbolFile  = flag.String("symbols", "", "Name addresses from a symbol list (nm output, vlink or IDA map, or equates).")
+	symbolBase  = flag.Uint64("symbase", 0, "Address the image is loaded at, subtracted from -symbols addresses.")
+	numbers     = flag.String("numbers", "", "Number style: \"$\" or \"0x\", \"upper\" or \"lower\" and \"dec=N\" for decimal immediates below N, e.g. \"0x,upper\".")
+	naming      = flag.String("naming", "default", "How to name generated labels ("+strings.Join(disassembler.LabelSchemes(), ", ")+").")
+	labelSeed   = flag.Uint64("labelseed", 0, "Seed for -naming hash; the same seed always gives the same names.")
+	macMode     = flag.Bool("mac", false, "Read a classic Mac resource fork (raw, MacBinary, AppleSingle or AppleDouble) and disassemble its CODE resources.")
+	palmMode    = flag.Bool("palm", false, "Read a Palm OS PRC file and disassemble its code resources.")
+	unknown     = flag.String("unknown", "dc.w", "How to render reachable words that do not decode ("+strings.Join(disassembler.UnknownPolicies(), ", ")+").")
+	cpuModel    = flag.String("cpu", "68000", "CPU model the code is for: 68000, 68010, 68020, 68040 or 68060. Instructions only later models have are left as data.")
+	sigFiles    = flag.String("sigs", "", "Label known routines using these comma-separated signature files (\"builtin\" for the built-in set).")
 )
 
 func main() {
-	if len(os.Args) < 2 || len(os.Args) > 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <inputfile> [outputfile]\n", os.Args[0])
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <inputfile> [outputfile]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	var fn string
-	if len(os.Args) == 3 {
-		fn = os.Args[2]
-	}
+	fn := flag.Arg(1)
 
 	// Read the binary file directly. Do NOT modify it.
-	code, err := os.ReadFile(os.Args[1])
+	code, err := os.ReadFile(flag.Arg(0))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
 		os.Exit(1)
 	}
+	if *patchFile != "" {
+		p, err := os.ReadFile(*patchFile)
+		if err == nil {
+			code, err = patch.ApplyFile(code, p)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error applying patch: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	text, err := disassembler.Disassemble(code)
+	var syms disassembler.Symbols
+	if *symbolFile != "" {
+		f, err := os.Open(*symbolFile)
+		if err == nil {
+			syms, err = disassembler.ParseSymbols(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading symbols: %v\n", err)
+			os.Exit(1)
+		}
+		syms = syms.Relocate(uint32(*symbolBase))
+	}
+
+	style, err := radix.Parse(*numbers)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Disassembly error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	scheme, err := disassembler.ParseLabelScheme(*naming)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	policy, err := disassembler.ParseUnknownPolicy(*unknown)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	model, err := cpu.ParseModel(*cpuModel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	var sigs []disassembler.Signature
+	if *sigFiles != "" {
+		sigs, err = loadSignatures(*sigFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading signatures: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var text string
+	switch {
+	case *stringsMode:
+		text = disassembler.FormatStrings(disassembler.Strings(code, *minLength), *withLabels)
+	case *regionsMode:
+		text = disassembler.FormatRegions(disassembler.ClassifyRegions(code, *blockSize))
+	default:
+		opts := disassembler.Options{
+			Regions:    *annotate,
+			BlockSize:  *blockSize,
+			Profile:    *profile,
+			Symbols:    syms,
+			Signatures: sigs,
+			Labels:     scheme,
+			LabelSeed:  *labelSeed,
+			Unknown:    policy,
+			Model:      model,
+		}
+		switch {
+		case *macMode:
+			text, err = disassembleMac(code, opts)
+		case *palmMode:
+			text, err = disassemblePalm(code, opts)
+		default:
+			text, err = disassembler.DisassembleWithOptions(code, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Disassembly error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	text = style.Apply(text)
+
 	// If an output file is specified, run the disassembler and write to it.
 	if fn != "" {
 		if err := os.WriteFile(fn, []byte(text), 0644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Disassembly written to %s\n", fn)
+		fmt.Printf("Output written to %s\n", fn)
 		return
 	}
 
 	println(text)
 }
+
+// loadSignatures reads the named signature files, where "builtin" stands for
+// the disassembler's own set.
+func loadSignatures(list string) ([]disassembler.Signature, error) {
+	var sigs []disassembler.Signature
+	for name := range strings.SplitSeq(list, ",") {
+		if name == "builtin" {
+			sigs = append(sigs, disassembler.BuiltinSignatures()...)
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		more, err := disassembler.ParseSignatures(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sigs = append(sigs, more...)
+	}
+	return sigs, nil
+}
+
+// disassembleMac lists the CODE resources in the resource fork in file, after
+// a header describing the segments and the jump table.
+func disassembleMac(file []byte, opts disassembler.Options) (string, error) {
+	res, err := disassembler.ParseResourceFork(file)
+	if err != nil {
+		return "", err
+	}
+	app, err := disassembler.LoadMacCode(res)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, s := range app.Segments {
+		fmt.Fprintf(&sb, "; CODE %d %q at $%x, %d bytes\n", s.ID, s.Name, s.Address, s.Size)
+	}
+	fmt.Fprintf(&sb, "; %d jump table entries, %d bytes above A5 and %d below\n", len(app.JumpTable), app.AboveA5, app.BelowA5)
+	text, err := app.Disassemble(opts)
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(text)
+	return sb.String(), nil
+}
+
+// disassemblePalm lists the code resources in the PRC file, after a header
+// describing the database and its segments.
+func disassemblePalm(file []byte, opts disassembler.Options) (string, error) {
+	db, err := disassembler.ParsePRC(file)
+	if err != nil {
+		return "", err
+	}
+	app, err := disassembler.LoadPalmCode(db)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "; %q, type %q, creator %q, version %d\n", db.Name, db.Type, db.Creator, db.Version)
+	for _, s := range app.Segments {
+		fmt.Fprintf(&sb, "; code %d at $%x, %d bytes\n", uint16(s.ID), s.Address, s.Size)
+	}
+	fmt.Fprintf(&sb, "; %d bytes above A5 and %d below\n", app.AboveA5, app.BelowA5)
+	text, err := app.Disassemble(opts)
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(text)
+	return sb.String(), nil
+}
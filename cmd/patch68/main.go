@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/patch"
+)
+
+var (
+	baseAddress = flag.String("base", "0", "Address the image is loaded at (hex), which patch addresses are relative to.")
+	outFile     = flag.String("o", "", "Write the patched image to this file.")
+	ipsFile     = flag.String("ips", "", "Write an IPS patch to this file.")
+	bpsFile     = flag.String("bps", "", "Write a BPS patch to this file.")
+	list        = flag.Bool("list", false, "List each change with the old and new code disassembled.")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: patch68 [options] <patch.txt> <image.bin>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	base, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(*baseAddress, "$"), "0x"), 16, 32)
+	if err != nil {
+		log.Fatalf("Invalid base address %s", *baseAddress)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error reading patch: %v", err)
+	}
+	changes, err := patch.Parse(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Error in %s: %v", flag.Arg(0), err)
+	}
+
+	image, err := os.ReadFile(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Error reading image: %v", err)
+	}
+	patched, err := patch.Apply(image, uint32(base), changes)
+	if err != nil {
+		log.Fatalf("Patch does not apply to %s: %v", flag.Arg(1), err)
+	}
+
+	if *list {
+		for _, c := range changes {
+			fmt.Printf("$%06X  %s\n      -> %s\n", c.Address, patch.Describe(c.Old, c.Address), patch.Describe(c.New, c.Address))
+		}
+	}
+
+	if *outFile != "" {
+		if err := os.WriteFile(*outFile, patched, 0o644); err != nil {
+			log.Fatalf("Error writing image: %v", err)
+		}
+	}
+	writePatch(*ipsFile, patch.WriteIPS, image, patched)
+	writePatch(*bpsFile, patch.WriteBPS, image, patched)
+	log.Printf("%d changes apply cleanly to %s", len(changes), flag.Arg(1))
+}
+
+// writePatch writes a patch file in one format, if a name was given.
+func writePatch(name string, write func(w io.Writer, original, patched []byte) error, original, patched []byte) {
+	if name == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := write(&buf, original, patched); err != nil {
+		log.Fatalf("Error creating %s: %v", name, err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		log.Fatalf("Error writing %s: %v", name, err)
+	}
+}
@@ -0,0 +1,278 @@
+// Command refgen renders cpu.InstructionSet as a Markdown or HTML reference.
+// Each example is run through the assembler, disassembler and CPU decoder,
+// so the support columns show what the code actually handles. An example
+// counts as running only if it decodes to its own instruction's handler.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+var (
+	format = flag.String("format", "md", "Output format: md or html.")
+	output = flag.String("o", "", "Output file (default: stdout).")
+)
+
+// entry is an instruction with the results of trying its example.
+type entry struct {
+	cpu.InstructionInfo
+	// Opcode is the assembled example, or empty if it didn't assemble.
+	Opcode string
+	// Diagram is the encoding as bit positions above field letters.
+	Diagram string
+	// Assembles, Disassembles and Executes report support in each package.
+	Assembles, Disassembles, Executes bool
+	// Mismatch is set when the assembled example doesn't fit the encoding.
+	Mismatch bool
+}
+
+// field is a legend entry.
+type field struct {
+	Letter, Meaning string
+}
+
+func main() {
+	flag.Parse()
+
+	var entries []entry
+	for _, info := range cpu.InstructionSet {
+		entries = append(entries, check(info))
+	}
+
+	var legend []field
+	for k, v := range cpu.EncodingFields {
+		legend = append(legend, field{string(k), v})
+	}
+	slices.SortFunc(legend, func(a, b field) int {
+		return strings.Compare(strings.ToLower(a.Letter)+a.Letter, strings.ToLower(b.Letter)+b.Letter)
+	})
+
+	data := struct {
+		Entries []entry
+		Legend  []field
+	}{entries, legend}
+
+	var buf bytes.Buffer
+	var err error
+	switch *format {
+	case "md":
+		err = template.Must(template.New("md").Funcs(funcs).Parse(markdownTemplate)).Execute(&buf, data)
+	case "html":
+		err = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(htmlTemplate)).Execute(&buf, data)
+	default:
+		err = fmt.Errorf("unknown format: %s", *format)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+var funcs = map[string]any{
+	"mark": func(ok bool) string {
+		if ok {
+			return "yes"
+		}
+		return ""
+	},
+	"anchor": func(s string) string {
+		return strings.ToLower(strings.NewReplacer(" ", "-", ",", "").Replace(s))
+	},
+}
+
+// check assembles, disassembles and decodes an instruction's example.
+func check(info cpu.InstructionInfo) entry {
+	e := entry{InstructionInfo: info, Diagram: diagram(info.Encoding)}
+
+	src := "\t" + info.Example + "\n\tnop\nlabel:\n"
+	code, err := assembler.New().Assemble(src, 0)
+	if err != nil || len(code) < 4 {
+		return e
+	}
+	code = code[:len(code)-2] // Drop the nop.
+	e.Assembles = true
+	e.Opcode = fmt.Sprintf("% X", code)
+
+	opcode := uint16(code[0])<<8 | uint16(code[1])
+	e.Mismatch = !fits(info.Encoding, opcode)
+
+	if text, err := disassembler.Disassemble(code); err == nil {
+		e.Disassembles = firstMnemonic(text) == mnemonicBase(info.Example)
+	}
+
+	c := cpu.New(0x100, 0)
+	if inst, err := c.Decode(opcode); err == nil && inst.Handler != nil {
+		e.Executes = runs(handlerName(inst.Handler), info.Mnemonic)
+	}
+	return e
+}
+
+// sharedHandlers lists the handlers that run more than one instruction, with
+// the instructions each runs.
+var sharedHandlers = map[string][]string{
+	"opBit":           {"BCHG", "BCLR", "BSET", "BTST"},
+	"opBcc":           {"Bcc", "BRA"},
+	"opLogicalSR":     {"ANDI to CCR", "ANDI to SR", "EORI to CCR", "EORI to SR", "ORI to CCR", "ORI to SR"},
+	"opShiftRegister": {"ASL, ASR", "LSL, LSR", "ROL, ROR", "ROXL, ROXR"},
+}
+
+// runs reports whether the handler an example decodes to is the instruction's
+// own: one named after it, such as opMOVEtoSR for MOVE to SR, or a shared
+// handler that lists it. Decoding isn't enough, since an opcode can reach a
+// neighbour's handler and run as the wrong instruction.
+func runs(handler, mnemonic string) bool {
+	if handler == "op"+strings.ReplaceAll(mnemonic, " ", "") {
+		return true
+	}
+	return slices.Contains(sharedHandlers[handler], mnemonic)
+}
+
+// handlerName returns the name of a handler method, such as "opADD".
+func handlerName(h func(*cpu.CPU, *cpu.DecodedInstruction) error) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	return name[strings.LastIndexByte(name, '.')+1:]
+}
+
+// fits reports whether opcode matches the fixed bits of an encoding.
+func fits(encoding string, opcode uint16) bool {
+	for i := 0; i < 16; i++ {
+		bit := (opcode >> (15 - i)) & 1
+		switch encoding[i] {
+		case '0':
+			if bit != 0 {
+				return false
+			}
+		case '1':
+			if bit != 1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// diagram lays out an encoding under its bit numbers.
+func diagram(encoding string) string {
+	var top, bottom strings.Builder
+	for i := 0; i < 16; i++ {
+		fmt.Fprintf(&top, "%3d", 15-i)
+		fmt.Fprintf(&bottom, "%3c", encoding[i])
+	}
+	return top.String() + "\n" + bottom.String()
+}
+
+// firstMnemonic returns the mnemonic of the first instruction in a listing, without its size.
+func firstMnemonic(listing string) string {
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		return mnemonicBase(fields[0])
+	}
+	return ""
+}
+
+// mnemonicBase lowercases a mnemonic and strips its size suffix.
+func mnemonicBase(s string) string {
+	s = strings.ToLower(strings.Fields(s)[0])
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	if s == "dbra" {
+		return "dbf" // Same instruction, and the disassembler's choice of name.
+	}
+	return s
+}
+
+const markdownTemplate = `# MC68000 instruction reference
+
+This file is generated from cpu.InstructionSet by cmd/refgen. Do not edit it; run ` + "`go generate ./cpu`" + ` instead.
+
+The asm, dis and run columns show whether the assembler, disassembler and CPU handle the example; run means the CPU decodes it to that instruction's own handler.
+Flags are listed as XNZVC: * set by the result, - unaffected, 0 cleared, 1 set, U undefined.
+Cycles are for the fastest form, per size; n is a shift or register count.
+
+| Instruction | Name | Sizes | XNZVC | Cycles | asm | dis | run |
+|---|---|---|---|---|---|---|---|
+{{range .Entries}}| [{{.Mnemonic}}](#{{anchor .Mnemonic}}) | {{.Name}}{{if .Privileged}} (privileged){{end}} | {{.Sizes}} | ` + "`{{.Flags}}`" + ` | {{.Cycles}} | {{mark .Assembles}}{{if .Mismatch}} (encoding differs){{end}} | {{mark .Disassembles}} | {{mark .Executes}} |
+{{end}}
+## Encoding fields
+
+| Letter | Field |
+|---|---|
+{{range .Legend}}| {{.Letter}} | {{.Meaning}} |
+{{end}}{{range .Entries}}
+## {{.Mnemonic}}
+
+{{.Name}}{{if .Privileged}}. Privileged.{{end}}
+
+` + "```" + `
+{{.Diagram}}
+` + "```" + `
+
+Example: ` + "`{{.Example}}`" + `{{if .Opcode}} assembles to ` + "`{{.Opcode}}`" + `{{end}}.
+{{end}}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>MC68000 instruction reference</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 2px 6px; }
+pre { background: #f4f4f4; padding: 4px; }
+</style>
+</head>
+<body>
+<h1>MC68000 instruction reference</h1>
+<p>This file is generated from cpu.InstructionSet by cmd/refgen. Do not edit it; run <code>go generate ./cpu</code> instead.</p>
+<p>The asm, dis and run columns show whether the assembler, disassembler and CPU handle the example; run means the CPU decodes it to that instruction's own handler.
+Flags are listed as XNZVC: * set by the result, - unaffected, 0 cleared, 1 set, U undefined.
+Cycles are for the fastest form, per size; n is a shift or register count.</p>
+<table>
+<tr><th>Instruction</th><th>Name</th><th>Sizes</th><th>XNZVC</th><th>Cycles</th><th>asm</th><th>dis</th><th>run</th></tr>
+{{range .Entries}}<tr><td><a href="#{{anchor .Mnemonic}}">{{.Mnemonic}}</a></td><td>{{.Name}}{{if .Privileged}} (privileged){{end}}</td><td>{{.Sizes}}</td><td><code>{{.Flags}}</code></td><td>{{.Cycles}}</td><td>{{mark .Assembles}}{{if .Mismatch}} (encoding differs){{end}}</td><td>{{mark .Disassembles}}</td><td>{{mark .Executes}}</td></tr>
+{{end}}</table>
+<h2>Encoding fields</h2>
+<table>
+<tr><th>Letter</th><th>Field</th></tr>
+{{range .Legend}}<tr><td>{{.Letter}}</td><td>{{.Meaning}}</td></tr>
+{{end}}</table>
+{{range .Entries}}
+<h2 id="{{anchor .Mnemonic}}">{{.Mnemonic}}</h2>
+<p>{{.Name}}{{if .Privileged}}. Privileged.{{end}}</p>
+<pre>{{.Diagram}}</pre>
+<p>Example: <code>{{.Example}}</code>{{if .Opcode}} assembles to <code>{{.Opcode}}</code>{{end}}.</p>
+{{end}}</body>
+</html>
+`
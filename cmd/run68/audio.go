@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+
+	"github.com/Urethramancer/m68k/vm"
+)
+
+// audioSamples collects what the audio device plays for -audiodump.
+var audioSamples []int16
+
+// recordAudio keeps the samples the audio device plays.
+func recordAudio(a *vm.Audio) {
+	a.SetCallback(func(samples []int16) {
+		audioSamples = append(audioSamples, samples...)
+	})
+}
+
+// dumpAudio writes the samples played during the run to fn as a 16-bit PCM
+// WAV file, at the rate and channel count the guest last set.
+func dumpAudio(v *vm.VM, fn string) error {
+	a := v.Audio()
+	a.Flush()
+	rate, channels := a.Format()
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	size := uint32(len(audioSamples) * 2)
+	w.WriteString("RIFF")
+	binary.Write(w, binary.LittleEndian, 36+size)
+	w.WriteString("WAVEfmt ")
+	binary.Write(w, binary.LittleEndian, struct {
+		Size                 uint32
+		Format, Channels     uint16
+		Rate, ByteRate       uint32
+		Align, BitsPerSample uint16
+	}{16, 1, uint16(channels), uint32(rate), uint32(rate * channels * 2), uint16(channels * 2), 16})
+	w.WriteString("data")
+	binary.Write(w, binary.LittleEndian, size)
+	binary.Write(w, binary.LittleEndian, audioSamples)
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
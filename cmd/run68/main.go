@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+	"github.com/Urethramancer/m68k/patch"
+	"github.com/Urethramancer/m68k/radix"
 	"github.com/Urethramancer/m68k/vm"
 )
 
@@ -17,14 +27,91 @@ var (
 	// Configuration flags
 	loadAddress = flag.Uint64("load", 0x0000, "Load address for binary files (hex).")
 	pcAddress   = flag.Uint64("pc", 0, "Initial program counter (hex), defaults to load address.")
-	maxCycles   = flag.Int("cycles", 1000000, "Maximum number of instructions to execute.")
+	strict      = flag.Bool("strict", false, "Raise address errors for word and long accesses at odd addresses, as a real 68000 does.")
+	illegal     = flag.Bool("illegal", false, "Take the illegal instruction exception for opcodes the emulator doesn't decode, instead of stopping with an error.")
+	fpu         = flag.Bool("fpu", false, "Emulate a 68881 FPU with a 68020, or the FPU of a 68040 or 68060, instead of taking the line 1111 exception for its instructions.")
+	addr32      = flag.Bool("addr32", false, "Use 32-bit addresses instead of wrapping at 16 MiB. The default follows -cpu: 24 bits for a 68000 or 68010 and 32 for a 68020 or later, and -addr32=false gives a 68020 24 bits, as on a 68EC020.")
+	coreList    = flag.String("cores", "", "Comma-separated start addresses and stacks (hex or labels, pc:sp) of extra CPUs sharing memory with the first.")
+	cpuModel    = flag.String("cpu", "68000", "CPU model to emulate: 68000, 68010, 68020, 68040 or 68060. Source files are assembled for it too.")
+	reset       = flag.Bool("reset", false, "Start from the reset vectors: SSP from address 0 and PC from address 4.")
+	maxCycles   = flag.Uint64("cycles", 8000000, "Maximum number of clock cycles to run (a second at 8 MHz by default).")
+	clockRate   = flag.Uint64("clock", 0, "Throttle execution to this many clock cycles per second, e.g. 8000000 for 8 MHz (0 runs flat out).")
+	cacheSize   = flag.Int("cache", 1024, "Number of decoded instructions to cache (0 disables the cache).")
+	cacheStats  = flag.Bool("cachestats", false, "Print instruction cache statistics after execution.")
+	perfAddress = flag.Uint64("perf", 0, "Map guest-readable cycle and instruction counters at this address (0 disables).")
+	randAddress = flag.Uint64("random", 0, "Map the random number device at this address (0 disables).")
+	randSeed    = flag.Uint64("seed", 1, "Seed for the random number device.")
+	uartAddress = flag.Uint64("uart", 0, "Map a UART, reading stdin and writing stdout, at this address (0 disables).")
+	uartLevel   = flag.Int("uartlevel", 4, "Interrupt level the UART raises (1-7).")
+	keyAddress  = flag.Uint64("keyboard", 0, "Map the keyboard device, taking keys from the terminal on stdin, at this address (0 disables).")
+	keyLevel    = flag.Int("keyboardlevel", 5, "Interrupt level the keyboard raises (1-7).")
+	timerAddr   = flag.Uint64("timer", 0, "Map the programmable timer at this address (0 disables).")
+	diskAddress = flag.Uint64("disk", 0, "Map the block device at this address, backed by -diskimage (0 disables).")
+	diskImage   = flag.String("diskimage", "", "Image file for the block device; opened read-only if it can't be written.")
+	diskLevel   = flag.Int("disklevel", 3, "Interrupt level the block device raises (1-7).")
+	rtcAddress  = flag.Uint64("rtc", 0, "Map the real-time clock at this address (0 disables).")
+	rtcStart    = flag.String("rtcstart", "", "Start the real-time clock at this time (RFC 3339) and advance it with the CPU's cycles at 8 MHz, instead of showing the host's time.")
+	audioAddr   = flag.Uint64("audio", 0, "Map the PCM audio device at this address (0 disables).")
+	audioLevel  = flag.Int("audiolevel", 6, "Interrupt level the audio device raises when its buffer is half empty (1-7).")
+	audioDump   = flag.String("audiodump", "", "Write the audio played during the run to this file as a WAV.")
+	fbAddress   = flag.Uint64("fb", 0, "Use the RAM at this address as a framebuffer (0 disables).")
+	fbMode      = flag.String("fbmode", "320x200x8", "Framebuffer width, height and bits per pixel (1, 2, 4, 8, 16 or 32).")
+	fbDump      = flag.String("fbdump", "", "Write the final framebuffer picture to this file, as PPM if it ends in .ppm and PNG otherwise.")
+	palmTrace   = flag.Bool("palmtrace", false, "Log each Palm OS system call a .prc application makes to its stubs.")
+	easy68k     = flag.Bool("easy68k", false, "Take TRAP #15 as the Easy68K simulator's system calls, with task 9 to halt.")
+	breakList   = flag.String("break", "", "Comma-separated breakpoint addresses (hex) or labels; hitting one starts the monitor.")
+	gdbAddr     = flag.String("gdb", "", "Wait for gdb to attach on this address (e.g. :1234) instead of running.")
+	numbers     = flag.String("numbers", "", "Number style: \"$\" or \"0x\", \"upper\" or \"lower\" and \"dec=N\" for decimal immediates below N, e.g. \"0x,upper\".")
+	stateFormat = flag.String("state", "monitor", "Register dump format: monitor, compact or json.")
+	recordSnap  = flag.String("record", "", "Write a snapshot of the final machine state to this file.")
+	verifySnap  = flag.String("verify", "", "Compare the final machine state with a snapshot written by -record.")
+	saveFile    = flag.String("savestate", "", "Save the whole machine to this file when the run ends, to resume later with -loadstate.")
+	loadFile    = flag.String("loadstate", "", "Resume a machine saved by -savestate instead of starting the program afresh.")
+	sandbox     = flag.Bool("sandbox", false, "Run untrusted code: stop after -cycles clock cycles, block TRAPs other than #15 and print a JSON report.")
+	memQuota    = flag.Int("quota", 0, "With -sandbox, the most bytes of memory the program may write (0 for no quota).")
+	timeout     = flag.Duration("timeout", 0, "With -sandbox, the longest the program may run (e.g. 2s).")
+	taintSpec   = flag.String("taint", "", "Log every instruction that reads or propagates these comma-separated registers or addresses (e.g. d0,$1000.l).")
+	romFile     = flag.String("rom", "", "Load this image read-only at -romaddr, alongside the writable RAM.")
+	romAddress  = flag.Uint64("romaddr", 0, "Address of the -rom image (hex).")
+	romIgnore   = flag.Bool("romignore", false, "Ignore writes to the -rom image instead of raising a bus error.")
+	symbolFile  = flag.String("symbols", "", "Load a symbol list (nm output, vlink or IDA map, or equates) for the monitor.")
+	patchFile   = flag.String("patch", "", "Apply this IPS or BPS patch to the program before running it.")
+	traceFile   = flag.String("tracelog", "", "Log every executed instruction with the registers it changed to this file.")
+	traceFormat = flag.String("traceformat", "text", "Trace log format: text, or binary for long traces (read with trace68 -dump).")
+	traceRanges = flag.String("tracerange", "", "Comma-separated address ranges (hex start-end, end excluded) to limit the trace log to.")
+	metricsAddr = flag.String("metrics", "", "Serve Prometheus metrics at /metrics and expvar at /debug/vars on this address while running.")
 
 	// Register value flags
 	regD [8]string
 	regA [8]string
+
+	monitor monitorFlag
 )
 
+// monitorFlag is the -monitor flag: where the monitor runs, if anywhere.
+// Given alone it is "stdio", or "uart" for the UART's line.
+type monitorFlag string
+
+func (m *monitorFlag) String() string { return string(*m) }
+
+func (m *monitorFlag) Set(s string) error {
+	switch s {
+	case "true", "stdio":
+		*m = "stdio"
+	case "false":
+		*m = ""
+	case "uart":
+		*m = "uart"
+	default:
+		return fmt.Errorf("want stdio or uart, got %q", s)
+	}
+	return nil
+}
+
+func (m *monitorFlag) IsBoolFlag() bool { return true }
+
 func init() {
+	flag.Var(&monitor, "monitor", "Start the machine monitor instead of running, on stdin and stdout, or with -monitor=uart on the UART's line, sharing it with the program.")
 	// Dynamically create flags for all 16 general-purpose registers
 	for i := 0; i < 8; i++ {
 		flag.StringVar(&regD[i], fmt.Sprintf("d%d", i), "", "Set initial value for data register D (hex).")
@@ -44,17 +131,45 @@ func main() {
 	}
 	filename := flag.Arg(0)
 
-	v := vm.New(16*1024*1024, 1024) // 16MB RAM
+	format, err := vm.ParseStateFormat(*stateFormat)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if *sandbox && (*easy68k || *uartAddress != 0 || *keyAddress != 0 || *diskAddress != 0 || *audioAddr != 0) {
+		log.Fatal("Error: -sandbox can't be combined with -easy68k, -uart, -keyboard, -disk or -audio, which reach the host")
+	}
+	if monitor == "uart" && *uartAddress == 0 {
+		log.Fatal("Error: -monitor=uart needs -uart")
+	}
+
+	model, err := cpu.ParseModel(*cpuModel)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	v := vm.New(16*1024*1024, *cacheSize) // 16MB RAM
+	v.CPU.SetModel(model)
+	if *fpu {
+		if model < cpu.MC68020 {
+			log.Fatalf("Error: -fpu needs -cpu 68020 or later")
+		}
+		v.CPU.SetCoprocessor(1, cpu.NewFPU())
+	}
+	v.Numbers, err = radix.Parse(*numbers)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
 
 	// Set registers from command-line flags
-	err := setRegisters(v)
+	err = setRegisters(v)
 	if err != nil {
 		log.Fatalf("Error setting registers: %v", err)
 	}
 
 	// Load code based on file extension
 	var code []byte
-	var startAddress uint32
+	var loadAt, startAddress uint32
 	ext := strings.ToLower(filepath.Ext(filename))
 
 	switch ext {
@@ -65,15 +180,25 @@ func main() {
 			log.Fatalf("Couldn't read source file: %v", err)
 		}
 		asm := assembler.New()
+		asm.Target = model
+		asm.FPU = *fpu
 		// For assembly, the ORG directive determines the load address.
 		// We pass 0 and let the assembler figure it out.
 		code, err = asm.Assemble(string(sourceBytes), 0)
 		if err != nil {
 			log.Fatalf("Assembly failed: %v", err)
 		}
-		// The assembler sets the PC to the ORG address.
-		startAddress = asm.BaseAddress()
-		v.LoadCode(startAddress, code)
+		// The code loads at its first ORG and starts there, or at the
+		// label named by END.
+		loadAt = asm.Origin()
+		startAddress = loadAt
+		if entry, ok := asm.Entry(); ok {
+			startAddress = entry
+		}
+		v.Symbols = make(disassembler.Symbols)
+		for name, addr := range asm.Labels() {
+			v.Symbols[addr] = name
+		}
 
 	case ".bin", ".m68":
 		log.Printf("Loading binary %s...", filename)
@@ -81,48 +206,539 @@ func main() {
 		if err != nil {
 			log.Fatalf("Couldn't read binary file: %v", err)
 		}
-		startAddress = uint32(*loadAddress)
-		v.LoadCode(startAddress, code)
+		loadAt = uint32(*loadAddress)
+		startAddress = loadAt
+
+	case ".prc":
+		log.Printf("Loading Palm OS application %s...", filename)
+		file, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Couldn't read PRC file: %v", err)
+		}
+		db, err := disassembler.ParsePRC(file)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		palm, err := v.LoadPalm(db)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if *palmTrace {
+			palm.Trace = log.Writer()
+		}
+		startAddress = v.CPU.PC
 
 	default:
-		log.Fatalf("Unknown file extension: %s. Use .asm, .s, .bin, or .m68", ext)
+		log.Fatalf("Unknown file extension: %s. Use .asm, .s, .bin, .m68 or .prc", ext)
+	}
+
+	if *patchFile != "" {
+		code, err = applyPatch(code, *patchFile)
+		if err != nil {
+			log.Fatalf("Error applying %s: %v", *patchFile, err)
+		}
+		log.Printf("Applied patch %s", *patchFile)
+	}
+	v.LoadCode(loadAt, code)
+
+	if *romFile != "" {
+		rom, err := os.ReadFile(*romFile)
+		if err != nil {
+			log.Fatalf("Couldn't read ROM image: %v", err)
+		}
+		if err := v.LoadROM(uint32(*romAddress), rom, filepath.Base(*romFile)); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		v.MemoryMap().IgnoreROMWrites = *romIgnore
+		log.Printf("Loaded %d bytes of ROM at 0x%08X", len(rom), *romAddress)
 	}
 
 	// Set program counter, overriding assembler ORG if specified
-	if *pcAddress != 0 {
+	v.CPU.StrictAlignment = *strict
+	if *illegal {
+		v.CPU.Unimplemented = cpu.UnimplementedException
+	}
+	if flagSet("addr32") {
+		v.CPU.AddressMask = cpu.Address24
+		if *addr32 {
+			v.CPU.AddressMask = cpu.Address32
+		}
+	}
+	if *reset {
+		if err := v.CPU.Reset(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else if *pcAddress != 0 {
 		v.CPU.PC = uint32(*pcAddress)
 	} else {
 		v.CPU.PC = startAddress
 	}
 
+	if *perfAddress != 0 {
+		if err := v.EnablePerfCounters(uint32(*perfAddress)); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *randAddress != 0 {
+		if err := v.EnableRandom(uint32(*randAddress), uint32(*randSeed)); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *uartAddress != 0 && *keyAddress != 0 {
+		log.Fatal("Error: only one of -uart and -keyboard can read stdin")
+	}
+	if *uartAddress != 0 {
+		if err := v.EnableUART(uint32(*uartAddress), *uartLevel, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *keyAddress != 0 {
+		if err := v.EnableKeyboard(uint32(*keyAddress), *keyLevel, os.Stdin); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *timerAddr != 0 {
+		if err := v.EnableTimer(uint32(*timerAddr)); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *diskAddress != 0 {
+		if err := attachDisk(v, uint32(*diskAddress), *diskImage); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else if *diskImage != "" {
+		log.Fatal("Error: -diskimage needs -disk")
+	}
+
+	if *rtcAddress != 0 {
+		if err := enableRTC(v, uint32(*rtcAddress), *rtcStart); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else if *rtcStart != "" {
+		log.Fatal("Error: -rtcstart needs -rtc")
+	}
+
+	if *audioAddr != 0 {
+		a, err := v.EnableAudio(vm.AudioConfig{Base: uint32(*audioAddr), Level: *audioLevel})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if *audioDump != "" {
+			recordAudio(a)
+		}
+	} else if *audioDump != "" {
+		log.Fatal("Error: -audiodump needs -audio")
+	}
+
+	if *fbAddress != 0 {
+		cfg := vm.FramebufferConfig{Base: uint32(*fbAddress)}
+		if _, err := fmt.Sscanf(*fbMode, "%dx%dx%d", &cfg.Width, &cfg.Height, &cfg.BPP); err != nil {
+			log.Fatalf("Error: invalid -fbmode %q, want e.g. 320x200x8", *fbMode)
+		}
+		if _, err := v.EnableFramebuffer(cfg); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else if *fbDump != "" {
+		log.Fatal("Error: -fbdump needs -fb")
+	}
+
+	if *easy68k {
+		v.EnableEasy68K(os.Stdin, os.Stdout)
+	}
+
+	if *coreList != "" {
+		for spec := range strings.SplitSeq(*coreList, ",") {
+			pc, sp, _ := strings.Cut(spec, ":")
+			start, ok1 := lookupAddress(v, pc)
+			stack, ok2 := lookupAddress(v, sp)
+			if !ok1 || !ok2 {
+				log.Fatalf("Error: invalid CPU %s, want pc:sp", spec)
+			}
+			c := v.AddCPU(*cacheSize)
+			c.PC, c.A[7], c.Running = start, stack, true
+		}
+	}
+
+	if *loadFile != "" {
+		if err := loadState(v, *loadFile); err != nil {
+			log.Fatalf("Error loading state: %v", err)
+		}
+		log.Printf("Resumed from %s", *loadFile)
+	}
+
+	if *taintSpec != "" {
+		v.EnableTaint(os.Stderr)
+		for spec := range strings.SplitSeq(*taintSpec, ",") {
+			if err := v.Taint(spec); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+	}
+
+	if *traceFile != "" {
+		if err := startTraceLog(v); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		defer finishTraceLog(v)
+	}
+
+	if *symbolFile != "" {
+		f, err := os.Open(*symbolFile)
+		if err != nil {
+			log.Fatalf("Couldn't read symbols: %v", err)
+		}
+		v.Symbols, err = disassembler.ParseSymbols(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error in %s: %v", *symbolFile, err)
+		}
+	}
+
+	if *metricsAddr != "" {
+		serveMetrics(v, *metricsAddr)
+	}
+
+	if *breakList != "" {
+		for name := range strings.SplitSeq(*breakList, ",") {
+			addr, ok := lookupAddress(v, name)
+			if !ok {
+				log.Fatalf("Unknown breakpoint %s", name)
+			}
+			v.CPU.AddBreakpoint(addr)
+		}
+	}
+
+	// Statistics wrap the bus, so they are enabled once it is in place.
+	v.EnableRunStats()
+
 	log.Printf("Loaded %d bytes. Execution starts at 0x%08X", len(code), v.CPU.PC)
+	switch monitor {
+	case "stdio":
+		if err := v.NewMonitor(os.Stdin, os.Stdout).Run(); err != nil {
+			log.Fatalf("Monitor failed: %v", err)
+		}
+		return
+	case "uart":
+		m, err := v.NewUARTMonitor()
+		if err == nil {
+			err = m.Run()
+		}
+		if err != nil {
+			log.Fatalf("Monitor failed: %v", err)
+		}
+		return
+	}
+
+	if *gdbAddr != "" {
+		log.Printf("Waiting for gdb on %s", *gdbAddr)
+		if err := v.NewGDBServer().ListenAndServe(*gdbAddr); err != nil {
+			log.Fatalf("GDB server failed: %v", err)
+		}
+		return
+	}
+
+	if *sandbox {
+		runSandboxed(v)
+		return
+	}
+
 	log.Println("\n--- CPU State Before Execution ---")
-	v.DumpRegisters()
+	writeState(v, format, 0)
+
+	restoreTerminal := func() {}
+	if *keyAddress != 0 {
+		restoreTerminal = keyTerminal()
+	}
+	// Ctrl-C cancels the run rather than killing run68, so this restores the
+	// terminal on the way out too. The paths that exit restore it first.
+	defer restoreTerminal()
 
 	// --- Execution Loop ---
-	v.CPU.Running = true
-	var executedCycles int
-	for executedCycles = 0; executedCycles < *maxCycles; executedCycles++ {
-		if !v.CPU.Running {
-			break
-		}
-		err := v.CPU.Execute()
-		if err != nil {
-			log.Printf("\n--- CPU State at Failure ---")
-			v.DumpRegisters()
-			log.Fatalf("\nCPU execution failed after %d instructions: %s at 0x%08X",
-				executedCycles+1, err, v.CPU.PC-2)
+	// Ctrl-C ends the run cleanly, with the final state dumped as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	reason, err := v.Run(ctx, vm.RunOptions{MaxCycles: *maxCycles, ClockRate: *clockRate})
+	stop()
+	switch reason {
+	case vm.RunBreakpoint:
+		restoreTerminal()
+		log.Printf("\n--- Breakpoint at 0x%08X after %d instructions ---", v.CPU.PC, v.CPU.Instructions)
+		if err := v.NewMonitor(os.Stdin, os.Stdout).Run(); err != nil {
+			log.Fatalf("Monitor failed: %v", err)
 		}
+		return
+	case vm.RunFault:
+		restoreTerminal()
+		finishTraceLog(v)
+		log.Printf("\n--- CPU State at Failure ---")
+		writeState(v, format, 0)
+		log.Fatalf("\nCPU execution failed after %d instructions: %v",
+			v.CPU.Instructions+1, err)
 	}
 
+	restoreTerminal()
+	finishTraceLog(v)
+
 	log.Println("\n--- CPU State After Execution ---")
-	v.DumpRegisters()
+	writeState(v, format, 0)
+	for n := 1; n < len(v.CPUs()); n++ {
+		log.Printf("\n--- CPU %d State After Execution ---", n)
+		writeState(v, format, n)
+	}
+	if *cacheStats {
+		v.DumpCacheStats()
+	}
+	log.Printf("\n--- Run Statistics ---\n%s", v.RunStats())
 
-	if executedCycles >= *maxCycles {
+	switch reason {
+	case vm.RunStopped:
+		log.Printf("\nExecution stopped by STOP after %d instructions, with no interrupt to resume it.", v.CPU.Instructions)
+	case vm.RunCycleLimit:
 		log.Printf("\nExecution finished: Maximum cycle count (%d) reached.", *maxCycles)
+	case vm.RunCancelled:
+		log.Printf("\nExecution interrupted after %d instructions (%d cycles).", v.CPU.Instructions, v.CPU.Cycles)
+	default:
+		log.Printf("\nExecution finished successfully after %d instructions (%d cycles).", v.CPU.Instructions, v.CPU.Cycles)
+	}
+
+	if *fbDump != "" {
+		if err := dumpFramebuffer(v, *fbDump); err != nil {
+			log.Fatalf("Error writing the framebuffer: %v", err)
+		}
+		log.Printf("Framebuffer written to %s", *fbDump)
+	}
+
+	if *audioDump != "" {
+		if err := dumpAudio(v, *audioDump); err != nil {
+			log.Fatalf("Error writing the audio: %v", err)
+		}
+		log.Printf("Audio written to %s", *audioDump)
+	}
+
+	if *saveFile != "" {
+		if err := saveState(v, *saveFile); err != nil {
+			log.Fatalf("Error saving state: %v", err)
+		}
+		log.Printf("Machine saved to %s", *saveFile)
+	}
+
+	if *recordSnap != "" {
+		if err := recordSnapshot(v, *recordSnap); err != nil {
+			log.Fatalf("Error recording snapshot: %v", err)
+		}
+		log.Printf("Snapshot written to %s", *recordSnap)
+	}
+	if *verifySnap != "" {
+		diffs, err := verifySnapshot(v, *verifySnap)
+		if err != nil {
+			log.Fatalf("Error verifying snapshot: %v", err)
+		}
+		if len(diffs) > 0 {
+			log.Printf("Snapshot %s does not match:", *verifySnap)
+			for _, d := range diffs {
+				log.Printf("  %s", d)
+			}
+			os.Exit(1)
+		}
+		log.Printf("Snapshot %s matches.", *verifySnap)
+	}
+}
+
+// attachDisk maps the block device at addr, backed by the image file fn. The
+// file stays open until run68 exits.
+func attachDisk(v *vm.VM, addr uint32, fn string) error {
+	if fn == "" {
+		return errors.New("-disk needs -diskimage")
+	}
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		f, err = os.Open(fn)
+	}
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return v.EnableBlockDevice(addr, *diskLevel, f, st.Size())
+}
+
+// dumpFramebuffer writes the framebuffer's picture to the file fn.
+func dumpFramebuffer(v *vm.VM, fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	fb := v.Framebuffer()
+	if strings.EqualFold(filepath.Ext(fn), ".ppm") {
+		err = fb.WritePPM(f)
 	} else {
-		log.Printf("\nExecution finished successfully after %d instructions.", executedCycles)
+		err = fb.WritePNG(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lookupAddress returns the address of a label, or of a hex number with an
+// optional $ or 0x prefix.
+func lookupAddress(v *vm.VM, name string) (uint32, bool) {
+	if addr, ok := v.Symbols.Lookup(name); ok {
+		return addr, true
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(name, "$"), "0x"), 16, 32)
+	return uint32(n), err == nil
+}
+
+// enableRTC maps the real-time clock at base. With a start time, the clock
+// runs from it at the emulated speed of 8 MHz, so runs are repeatable.
+func enableRTC(v *vm.VM, base uint32, start string) error {
+	if start == "" {
+		return v.EnableRTC(base, nil)
+	}
+	t, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return fmt.Errorf("invalid -rtcstart: %w", err)
 	}
+	return v.EnableRTC(base, func() time.Time {
+		return t.Add(time.Duration(v.CPU.Cycles) * 125 * time.Nanosecond) // 8 MHz
+	})
+}
+
+// traceOut is the -tracelog file.
+var traceOut *os.File
+
+// startTraceLog opens the -tracelog file and starts logging to it.
+func startTraceLog(v *vm.VM) error {
+	format, err := vm.ParseTraceFormat(*traceFormat)
+	if err != nil {
+		return err
+	}
+	opts := vm.TraceOptions{Format: format}
+	if *traceRanges != "" {
+		for spec := range strings.SplitSeq(*traceRanges, ",") {
+			start, end, ok := strings.Cut(spec, "-")
+			a, err1 := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(start, "$"), "0x"), 16, 32)
+			b, err2 := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(end, "$"), "0x"), 16, 32)
+			if !ok || err1 != nil || err2 != nil || b <= a {
+				return fmt.Errorf("invalid trace range %s", spec)
+			}
+			opts.Ranges = append(opts.Ranges, vm.TraceRange{Start: uint32(a), End: uint32(b)})
+		}
+	}
+	traceOut, err = os.Create(*traceFile)
+	if err != nil {
+		return err
+	}
+	return v.EnableTraceLog(traceOut, opts)
+}
+
+// finishTraceLog flushes and closes the -tracelog file.
+func finishTraceLog(v *vm.VM) {
+	if traceOut == nil {
+		return
+	}
+	err := v.DisableTraceLog()
+	if cerr := traceOut.Close(); err == nil {
+		err = cerr
+	}
+	traceOut = nil
+	if err != nil {
+		log.Printf("Error writing trace log: %v", err)
+	}
+}
+
+// writeState dumps CPU n's registers to stderr, reporting a failed write.
+func writeState(v *vm.VM, format vm.StateFormat, n int) {
+	if err := v.WriteCPUState(os.Stderr, format, n); err != nil {
+		log.Printf("Error writing CPU state: %v", err)
+	}
+}
+
+// serveMetrics publishes the VM's counters over HTTP in the background.
+func serveMetrics(v *vm.VM, addr string) {
+	v.PublishMetrics("run68")
+	http.Handle("/metrics", v.MetricsHandler("run68_"))
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Fatalf("Metrics server failed: %v", err)
+		}
+	}()
+	log.Printf("Serving metrics on %s", addr)
+}
+
+// runSandboxed runs the program under the sandbox limits and prints the report
+// to stdout. The exit status is 1 unless the program halted normally.
+func runSandboxed(v *vm.VM) {
+	r := v.RunSandboxed(vm.SandboxConfig{
+		MaxCycles: *maxCycles,
+		MaxMemory: *memQuota,
+		Timeout:   *timeout,
+	})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+	if r.Reason != vm.SandboxHalted {
+		os.Exit(1)
+	}
+}
+
+// saveState writes the whole machine to fn for -savestate.
+func saveState(v *vm.VM, fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	err = v.SaveState(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loadState restores the machine saved by -savestate in fn.
+func loadState(v *vm.VM, fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.LoadState(f)
+}
+
+// recordSnapshot writes the final machine state to fn.
+func recordSnapshot(v *vm.VM, fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	err = vm.WriteSnapshot(f, v.Snapshot())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// verifySnapshot compares the final machine state with the snapshot in fn.
+func verifySnapshot(v *vm.VM, fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	want, err := vm.ReadSnapshot(f)
+	if err != nil {
+		return nil, err
+	}
+	return v.Snapshot().Diff(want), nil
 }
 
 // setRegisters parses the string flags and sets CPU registers.
@@ -145,3 +761,23 @@ func setRegisters(v *vm.VM) error {
 	}
 	return nil
 }
+
+// applyPatch applies an IPS or BPS patch file to the program image.
+func applyPatch(code []byte, name string) ([]byte, error) {
+	p, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return patch.ApplyFile(code, p)
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// keyTerminal puts the terminal on stdin into character mode for the
+// keyboard device: keys are delivered as they are typed, without echo, while
+// Ctrl-C still interrupts run68. It returns a function that restores the
+// previous settings. Nothing changes if stdin isn't a terminal or stty fails.
+func keyTerminal() func() {
+	st, err := os.Stdin.Stat()
+	if err != nil || st.Mode()&os.ModeCharDevice == 0 {
+		return func() {}
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return func() {}
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}
+}
+
+// stty runs stty on stdin's terminal and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
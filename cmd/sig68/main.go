@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+var (
+	output     = flag.String("o", "", "Write the signatures to this file instead of standard output.")
+	minFixed   = flag.Int("min", 8, "Skip routines with fewer fixed bytes than this, which would match too often.")
+	maxLength  = flag.Int("max", 32, "Longest signature in bytes (0 for whole routines).")
+	symbolFile = flag.String("symbols", "", "Symbol list naming the routines in binary libraries.")
+	symbolBase = flag.Uint64("symbase", 0, "Address binary libraries are loaded at, subtracted from -symbols addresses.")
+)
+
+// routine is a named entry point in a library image.
+type routine struct {
+	name string
+	addr uint32
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sig68 [options] <library.asm|library.bin>...")
+		fmt.Fprintln(os.Stderr, "Writes a signature for every routine in the libraries, for dis68 -sigs.")
+		fmt.Fprintln(os.Stderr, "Sources are assembled and split at their labels, except local ones (starting")
+		fmt.Fprintln(os.Stderr, "with \".\" or the previous routine's name and \"_\"). Binaries need -symbols.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var sigs []disassembler.Signature
+	for _, fn := range flag.Args() {
+		code, base, routines, err := load(fn)
+		if err != nil {
+			log.Fatalf("Error in %s: %v", fn, err)
+		}
+		for i, r := range routines {
+			end := base + uint32(len(code))
+			if i+1 < len(routines) {
+				end = routines[i+1].addr
+			}
+			sig := disassembler.NewSignature(r.name, code[r.addr-base:end-base], r.addr, *maxLength)
+			if sig.Fixed() < *minFixed {
+				log.Printf("Skipping %s: only %d fixed bytes", r.name, sig.Fixed())
+				continue
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	fmt.Fprintf(w, "# Generated by sig68 from %s\n", strings.Join(flag.Args(), ", "))
+	if err := disassembler.WriteSignatures(w, sigs); err != nil {
+		log.Fatalf("Error writing signatures: %v", err)
+	}
+}
+
+// load reads a library, assembling sources, and returns its image, the address
+// it is linked at and its routines in address order.
+func load(fn string) ([]byte, uint32, []routine, error) {
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".asm", ".s", ".i":
+		src, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		asm := assembler.New()
+		asm.IncludeDirs = []string{filepath.Dir(fn)}
+		code, err := asm.Assemble(string(src), 0)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		base := asm.BaseAddress()
+		var labels []routine
+		for name, addr := range asm.Labels() {
+			labels = append(labels, routine{name, addr})
+		}
+		return code, base, routines(labels, base, len(code)), nil
+
+	default:
+		if *symbolFile == "" {
+			return nil, 0, nil, fmt.Errorf("binary libraries need -symbols")
+		}
+		code, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		f, err := os.Open(*symbolFile)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		defer f.Close()
+		syms, err := disassembler.ParseSymbols(f)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		var labels []routine
+		for addr, name := range syms.Relocate(uint32(*symbolBase)) {
+			labels = append(labels, routine{name, addr})
+		}
+		return code, 0, routines(labels, 0, len(code)), nil
+	}
+}
+
+// routines sorts the labels inside the image and drops the local ones.
+func routines(labels []routine, base uint32, size int) []routine {
+	slices.SortFunc(labels, func(a, b routine) int {
+		return cmp.Or(cmp.Compare(a.addr, b.addr), cmp.Compare(a.name, b.name))
+	})
+	var list []routine
+	for _, l := range labels {
+		if l.addr < base || l.addr >= base+uint32(size) || strings.HasPrefix(l.name, ".") {
+			continue
+		}
+		if n := len(list); n > 0 {
+			last := list[n-1]
+			if l.addr == last.addr || strings.HasPrefix(l.name, last.name+"_") {
+				continue
+			}
+		}
+		list = append(list, l)
+	}
+	return list
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/vm"
+)
+
+var (
+	verbose  = flag.Bool("v", false, "Print every test as it runs, not just failures.")
+	runMatch = flag.String("run", "", "Only run tests whose names match this regular expression.")
+	maxSteps = flag.Int("steps", 1000000, "Maximum number of instructions each test may execute.")
+	memSize  = flag.Int("mem", 1024*1024, "Memory size in bytes for each test's VM.")
+)
+
+// testPrefix marks the labels that are run as tests.
+const testPrefix = "test_"
+
+// test is a routine to run and its outcome.
+type test struct {
+	name    string
+	addr    uint32
+	failure string
+	elapsed time.Duration
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: test68 [options] <module.asm> [harness.asm...]")
+		fmt.Fprintln(os.Stderr, "Each test_* routine runs in a fresh VM and passes if it returns with D0=0.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var filter *regexp.Regexp
+	if *runMatch != "" {
+		var err error
+		filter, err = regexp.Compile(*runMatch)
+		if err != nil {
+			log.Fatalf("Invalid -run pattern: %v", err)
+		}
+	}
+
+	start := time.Now()
+	asm := assembler.New()
+	var src strings.Builder
+	for _, fn := range flag.Args() {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			log.Fatalf("Couldn't read source file: %v", err)
+		}
+		src.Write(data)
+		src.WriteString("\n")
+		asm.IncludeDirs = append(asm.IncludeDirs, filepath.Dir(fn))
+	}
+
+	code, err := asm.Assemble(src.String(), 0)
+	if err != nil {
+		log.Fatalf("Assembly failed: %v", err)
+	}
+
+	tests := findTests(asm.Labels(), filter)
+	if len(tests) == 0 {
+		fmt.Printf("testing: warning: no tests to run\n")
+	}
+
+	failed := false
+	for i := range tests {
+		t := &tests[i]
+		if *verbose {
+			fmt.Printf("=== RUN   %s\n", t.name)
+		}
+		runTest(t, asm.BaseAddress(), code)
+		switch {
+		case t.failure != "":
+			failed = true
+			fmt.Printf("--- FAIL: %s (%.2fs)\n    %s\n", t.name, t.elapsed.Seconds(), t.failure)
+		case *verbose:
+			fmt.Printf("--- PASS: %s (%.2fs)\n", t.name, t.elapsed.Seconds())
+		}
+	}
+
+	name := flag.Arg(0)
+	if failed {
+		fmt.Println("FAIL")
+		fmt.Printf("FAIL\t%s\t%.3fs\n", name, time.Since(start).Seconds())
+		os.Exit(1)
+	}
+	if *verbose {
+		fmt.Println("PASS")
+	}
+	fmt.Printf("ok  \t%s\t%.3fs\n", name, time.Since(start).Seconds())
+}
+
+// findTests returns the test_* labels matching filter, in address order.
+func findTests(labels map[string]uint32, filter *regexp.Regexp) []test {
+	var tests []test
+	for name, addr := range labels {
+		if !strings.HasPrefix(strings.ToLower(name), testPrefix) {
+			continue
+		}
+		if filter != nil && !filter.MatchString(name) {
+			continue
+		}
+		tests = append(tests, test{name: name, addr: addr})
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		if tests[i].addr != tests[j].addr {
+			return tests[i].addr < tests[j].addr
+		}
+		return tests[i].name < tests[j].name
+	})
+	return tests
+}
+
+// runTest calls t's routine in a fresh VM and records why it failed, if it did.
+// D0 starts out non-zero, so a test must clear it to pass.
+func runTest(t *test, base uint32, code []byte) {
+	v := vm.New(*memSize, 0)
+	v.LoadCode(base, code)
+	v.CPU.A[7] = uint32(*memSize)
+	v.CPU.D[0] = 0xFFFFFFFF
+
+	start := time.Now()
+	steps, err := v.Call(t.addr, *maxSteps)
+	t.elapsed = time.Since(start)
+
+	switch {
+	case errors.Is(err, vm.ErrStepLimit):
+		t.failure = fmt.Sprintf("did not return within %d instructions", steps)
+	case err != nil:
+		t.failure = err.Error()
+	case v.CPU.D[0] != 0:
+		t.failure = fmt.Sprintf("D0=$%08X after %d instructions", v.CPU.D[0], steps)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/radix"
+	"github.com/Urethramancer/m68k/vm"
+)
+
+var (
+	configA  = flag.String("a", "", "Options for the first run, e.g. \"-strict -cache 0\".")
+	configB  = flag.String("b", "", "Options for the second run.")
+	maxSteps = flag.Int("steps", 1000000, "Maximum number of instructions to trace.")
+	context  = flag.Int("context", 8, "Number of steps to show before the divergence.")
+	output   = flag.String("o", "", "Write the first run's trace to this file instead of comparing.")
+	dump     = flag.String("dump", "", "Print a binary trace log written by run68 -tracelog as text and exit.")
+	numbers  = flag.String("numbers", "", "Number style: \"$\" or \"0x\", \"upper\" or \"lower\" and \"dec=N\" for decimal immediates below N.")
+)
+
+// style is the number style of the report.
+var style radix.Style
+
+// config describes how one run is set up.
+type config struct {
+	load   uint64
+	pc     uint64
+	cache  int
+	strict bool
+	reset  bool
+}
+
+// parseConfig reads the options for one run.
+func parseConfig(name, opts string) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Uint64Var(&cfg.load, "load", 0, "Load address for binaries.")
+	fs.Uint64Var(&cfg.pc, "pc", 0, "Initial program counter, defaults to the load address.")
+	fs.IntVar(&cfg.cache, "cache", 1024, "Decoded instruction cache size.")
+	fs.BoolVar(&cfg.strict, "strict", false, "Raise address errors for odd word and long accesses.")
+	fs.BoolVar(&cfg.reset, "reset", false, "Start from the reset vectors.")
+	if err := fs.Parse(strings.Fields(opts)); err != nil {
+		return config{}, err
+	}
+	if fs.NArg() > 0 {
+		return config{}, fmt.Errorf("unexpected argument %q in -%s", fs.Arg(0), name)
+	}
+	return cfg, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: trace68 [options] <program> [other.trace]")
+		fmt.Fprintln(os.Stderr, "Runs the program with the -a and -b options, or once against a trace from")
+		fmt.Fprintln(os.Stderr, "another emulator, and reports the first step where the CPU state differs.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	var err error
+	style, err = radix.Parse(*numbers)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *dump != "" {
+		if err := dumpLog(*dump); err != nil {
+			log.Fatalf("Error in %s: %v", *dump, err)
+		}
+		return
+	}
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	a, traceA, err := run("a", *configA, flag.Arg(0))
+	if err != nil {
+		log.Printf("Run a stopped: %v", err)
+	}
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Error creating trace: %v", err)
+		}
+		defer f.Close()
+		if err := vm.WriteTrace(f, traceA); err != nil {
+			log.Fatalf("Error writing trace: %v", err)
+		}
+		log.Printf("Wrote %d steps to %s", len(traceA), *output)
+		return
+	}
+
+	var traceB []vm.State
+	nameB := "b"
+	if flag.NArg() == 2 {
+		nameB = flag.Arg(1)
+		f, err := os.Open(nameB)
+		if err != nil {
+			log.Fatalf("Error reading trace: %v", err)
+		}
+		traceB, err = vm.ReadTrace(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error in %s: %v", nameB, err)
+		}
+	} else {
+		_, traceB, err = run("b", *configB, flag.Arg(0))
+		if err != nil {
+			log.Printf("Run b stopped: %v", err)
+		}
+	}
+
+	d := vm.CompareTraces(traceA, traceB)
+	if d == nil {
+		fmt.Printf("Traces agree for %d steps.\n", len(traceA))
+		return
+	}
+
+	fmt.Printf("First divergence (a / %s) at %s\n\n", nameB, d)
+	for i := max(d.Step-*context, 0); i < d.Step; i++ {
+		fmt.Printf("%8d  %-36s %s\n", i, instruction(a, traceA[i].PC), shortState(traceA[i]))
+	}
+	fmt.Println()
+	if d.Step < len(traceA) {
+		fmt.Printf("%8d  a: PC=%s %s\n", d.Step, style.Field(traceA[d.Step].PC, 8), shortState(traceA[d.Step]))
+	}
+	if d.Step < len(traceB) {
+		fmt.Printf("%8d  %s: PC=%s %s\n", d.Step, nameB, style.Field(traceB[d.Step].PC, 8), shortState(traceB[d.Step]))
+	}
+	os.Exit(1)
+}
+
+// dumpLog prints a binary trace log as text.
+func dumpLog(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := vm.NewTraceLogReader(f)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, style.Apply(rec.String()))
+	}
+}
+
+// run loads the program into a fresh VM configured by opts and traces it.
+func run(name, opts, filename string) (*vm.VM, []vm.State, error) {
+	cfg, err := parseConfig(name, opts)
+	if err != nil {
+		log.Fatalf("Invalid options for run %s: %v", name, err)
+	}
+
+	v := vm.New(16*1024*1024, cfg.cache)
+	start := uint32(cfg.load)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".asm", ".s":
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Couldn't read source file: %v", err)
+		}
+		asm := assembler.New()
+		code, err := asm.Assemble(string(src), 0)
+		if err != nil {
+			log.Fatalf("Assembly failed: %v", err)
+		}
+		start = asm.BaseAddress()
+		v.LoadCode(start, code)
+	default:
+		code, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Couldn't read binary file: %v", err)
+		}
+		v.LoadCode(start, code)
+	}
+
+	v.CPU.StrictAlignment = cfg.strict
+	switch {
+	case cfg.reset:
+		if err := v.CPU.Reset(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	case cfg.pc != 0:
+		v.CPU.PC = uint32(cfg.pc)
+	default:
+		v.CPU.PC = start
+	}
+
+	trace, err := v.RecordTrace(*maxSteps)
+	return v, trace, err
+}
+
+// instruction disassembles the instruction at addr from the first run's memory.
+func instruction(v *vm.VM, addr uint32) string {
+	list := v.Disassemble(addr, 1)
+	if len(list) == 0 {
+		return fmt.Sprintf("%s  ???", style.Field(addr, 8))
+	}
+	return fmt.Sprintf("%s  %-8s %s", style.Field(addr, 8), list[0].Mnemonic, style.Apply(list[0].Operands))
+}
+
+// shortState formats a state like the compact register dump, without the PC.
+func shortState(s vm.State) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "SR=%s %s D=", style.Field(uint32(s.SR), 4), s.SR.FlagString())
+	for i, r := range s.D {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(style.Field(r, 8))
+	}
+	sb.WriteString(" A=")
+	for i, r := range s.A {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(style.Field(r, 8))
+	}
+	return sb.String()
+}
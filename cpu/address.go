@@ -4,264 +4,190 @@ import "fmt"
 
 // GetOperand fetches a value using the specified addressing mode.
 // This is the core of resolving the "source" part of an instruction.
+// Instructions that also write the operand use ResolveOperand instead.
 func (c *CPU) GetOperand(mode, reg uint16, size Size) (uint32, error) {
-	switch mode {
-	case ModeData: // Data Register Direct
-		val := c.D[reg]
-		switch size {
-		case SizeByte:
-			return val & 0xFF, nil
-		case SizeWord:
-			return val & 0xFFFF, nil
-		case SizeLong:
-			return val, nil
-		}
-	case ModeAddr: // Address Register Direct
-		val := c.A[reg]
-		switch size {
-		case SizeByte:
-			return val & 0xFF, nil
-		case SizeWord:
-			return val & 0xFFFF, nil
-		case SizeLong:
-			return val, nil
-		}
-	case ModeAddrInd: // Address Register Indirect
-		addr := c.A[reg]
-		switch size {
-		case SizeByte:
-			return uint32(c.Mem[addr]), nil
-		case SizeWord:
-			return uint32(c.ReadU16(addr)), nil
-		case SizeLong:
-			return c.ReadU32(addr), nil
-		}
-	case ModeAddrPostInc: // Address Register Indirect with Postincrement
-		addr := c.A[reg]
-		increment := uint32(size.Bytes())
-		// Byte operations on address registers (except A7) increment by 2
-		if size == SizeByte && reg != 7 {
-			increment = 2
-		}
-		c.A[reg] += increment
+	o, err := c.ResolveOperand(mode, reg, size)
+	if err != nil {
+		return 0, err
+	}
+	return c.ReadOperand(o), nil
+}
 
-		switch size {
-		case SizeByte:
-			return uint32(c.Mem[addr]), nil
-		case SizeWord:
-			return uint32(c.ReadU16(addr)), nil
-		case SizeLong:
-			return c.ReadU32(addr), nil
-		}
-	case ModeAddrPreDec: // Address Register Indirect with Predecrement
-		increment := uint32(size.Bytes())
-		// Byte operations on address registers (except A7) increment by 2
-		if size == SizeByte && reg != 7 {
-			increment = 2
-		}
-		c.A[reg] -= increment
-		addr := c.A[reg]
+// PutOperand writes a value using the specified addressing mode.
+// This is the core of resolving the "destination" part of an instruction.
+func (c *CPU) PutOperand(mode, reg uint16, size Size, value uint32) error {
+	if mode == ModeOther && reg != RegAbsShort && reg != RegAbsLong {
+		return fmt.Errorf("invalid destination addressing sub-mode %d for mode %d", reg, mode)
+	}
+	o, err := c.ResolveOperand(mode, reg, size)
+	if err != nil {
+		return err
+	}
+	return c.WriteOperand(o, value)
+}
 
-		switch size {
-		case SizeByte:
-			return uint32(c.Mem[addr]), nil
-		case SizeWord:
-			return uint32(c.ReadU16(addr)), nil
-		case SizeLong:
-			return c.ReadU32(addr), nil
-		}
-	case ModeAddrDisp: // Address Register Indirect with Displacement
+// signExtend16 correctly sign-extends a 16-bit value to 32 bits.
+func signExtend16(v uint16) int32 {
+	return int32(int16(v))
+}
+
+// effectiveAddress calculates the address of a memory operand without accessing
+// it, consuming any extension words. It covers the control addressing modes used
+// by JMP, JSR, LEA and PEA, plus the register indirect modes.
+func (c *CPU) effectiveAddress(mode, reg uint16) (uint32, error) {
+	switch mode {
+	case ModeAddrInd:
+		return c.A[reg], nil
+	case ModeAddrDisp:
 		displacement := signExtend16(c.ReadU16(c.PC))
 		c.PC += 2
-		addr := uint32(int32(c.A[reg]) + displacement)
-		switch size {
-		case SizeByte:
-			return uint32(c.Mem[addr]), nil
-		case SizeWord:
-			return uint32(c.ReadU16(addr)), nil
-		case SizeLong:
-			return c.ReadU32(addr), nil
-		}
-	case ModeOther: // Miscellaneous modes
+		return uint32(int32(c.A[reg]) + displacement), nil
+	case ModeAddrIndex:
+		return c.indexedAddress(c.A[reg])
+	case ModeOther:
 		switch reg {
-		case RegAbsShort: // Absolute Short
+		case RegAbsShort:
 			addr := uint32(signExtend16(c.ReadU16(c.PC)))
 			c.PC += 2
-			switch size {
-			case SizeByte:
-				return uint32(c.Mem[addr]), nil
-			case SizeWord:
-				return uint32(c.ReadU16(addr)), nil
-			case SizeLong:
-				return c.ReadU32(addr), nil
-			}
-		case RegAbsLong: // Absolute Long
+			return addr, nil
+		case RegAbsLong:
 			addr := c.ReadU32(c.PC)
 			c.PC += 4
-			switch size {
-			case SizeByte:
-				return uint32(c.Mem[addr]), nil
-			case SizeWord:
-				return uint32(c.ReadU16(addr)), nil
-			case SizeLong:
-				return c.ReadU32(addr), nil
-			}
-		case RegImmediate: // Immediate
-			var val uint32
-			switch size {
-			case SizeByte:
-				// Byte immediates are stored as a word, high byte is ignored
-				val = uint32(c.ReadU16(c.PC) & 0xFF)
-				c.PC += 2
-			case SizeWord:
-				val = uint32(c.ReadU16(c.PC))
-				c.PC += 2
-			case SizeLong:
-				val = c.ReadU32(c.PC)
-				c.PC += 4
-			}
-			return val, nil
-		default:
-			return 0, fmt.Errorf("unimplemented source addressing sub-mode %d for mode %d", reg, mode)
+			return addr, nil
+		case RegPCDisp:
+			// The displacement is relative to the extension word itself.
+			base := c.PC
+			displacement := signExtend16(c.ReadU16(c.PC))
+			c.PC += 2
+			return uint32(int32(base) + displacement), nil
+		case RegPCIndex:
+			return c.indexedAddress(c.PC)
 		}
-	default:
-		return 0, fmt.Errorf("unimplemented source addressing mode %d", mode)
 	}
-	return 0, fmt.Errorf("invalid size for get operand")
+	return 0, fmt.Errorf("addressing mode %d/%d has no effective address", mode, reg)
 }
 
-// PutOperand writes a value using the specified addressing mode.
-// This is the core of resolving the "destination" part of an instruction.
-func (c *CPU) PutOperand(mode, reg uint16, size Size, value uint32) error {
-	switch mode {
-	case ModeData: // Data Register Direct
-		switch size {
-		case SizeByte:
-			c.D[reg] = (c.D[reg] & 0xFFFFFF00) | (value & 0xFF)
-		case SizeWord:
-			c.D[reg] = (c.D[reg] & 0xFFFF0000) | (value & 0xFFFF)
-		case SizeLong:
-			c.D[reg] = value
-		default:
-			return fmt.Errorf("invalid size for put operand to D%d", reg)
-		}
-		return nil
-	case ModeAddr: // Address Register Direct
-		switch size {
-		case SizeByte:
-			return fmt.Errorf("invalid size .B for put operand to A%d", reg)
-		case SizeWord:
-			c.A[reg] = uint32(signExtend16(uint16(value)))
-		case SizeLong:
-			c.A[reg] = value
-		default:
-			return fmt.Errorf("invalid size for put operand to A%d", reg)
-		}
-		return nil
-	case ModeAddrInd: // Address Register Indirect
-		addr := c.A[reg]
-		switch size {
-		case SizeByte:
-			c.Mem[addr] = byte(value & 0xFF)
-		case SizeWord:
-			c.WriteU16(addr, uint16(value&0xFFFF))
-		case SizeLong:
-			c.WriteU32(addr, value)
-		default:
-			return fmt.Errorf("invalid size for put operand to (A%d)", reg)
-		}
-		return nil
-	case ModeAddrPostInc: // Address Register Indirect with Postincrement
-		addr := c.A[reg]
-		increment := uint32(size.Bytes())
-		if size == SizeByte && reg != 7 {
-			increment = 2
-		}
-		c.A[reg] += increment
+// indexedAddress reads an index extension word and returns the address it
+// selects. The 68000 only has the brief format, base + d8 + Xn:
+// D/A <reg> W/L 000 <8-bit displacement>
+// The 68020 and later scale the index by 1, 2, 4 or 8 (bits 10-9), and take
+// the full format when bit 8 is set.
+func (c *CPU) indexedAddress(base uint32) (uint32, error) {
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	if c.Model < MC68020 {
+		return base + uint32(int32(int8(ext))) + c.indexValue(ext&^0x0600), nil
+	}
+	if ext&0x0100 != 0 {
+		return c.fullIndexedAddress(base, ext)
+	}
+	return base + uint32(int32(int8(ext))) + c.indexValue(ext), nil
+}
 
-		switch size {
-		case SizeByte:
-			c.Mem[addr] = byte(value & 0xFF)
-		case SizeWord:
-			c.WriteU16(addr, uint16(value&0xFFFF))
-		case SizeLong:
-			c.WriteU32(addr, value)
-		default:
-			return fmt.Errorf("invalid size for put operand to (A%d)+", reg)
-		}
-		return nil
-	case ModeAddrPreDec: // Address Register Indirect with Predecrement
-		increment := uint32(size.Bytes())
-		if size == SizeByte && reg != 7 {
-			increment = 2
-		}
-		c.A[reg] -= increment
-		addr := c.A[reg]
-		switch size {
-		case SizeByte:
-			c.Mem[addr] = byte(value & 0xFF)
-		case SizeWord:
-			c.WriteU16(addr, uint16(value&0xFFFF))
-		case SizeLong:
-			c.WriteU32(addr, value)
-		default:
-			return fmt.Errorf("invalid size for put operand to -(A%d)", reg)
-		}
-		return nil
-	case ModeAddrDisp: // Address Register Indirect with Displacement
-		// FIX: Do not advance PC here. It is handled by GetOperand.
-		displacement := signExtend16(c.ReadU16(c.PC))
-		addr := uint32(int32(c.A[reg]) + displacement)
-		switch size {
-		case SizeByte:
-			c.Mem[addr] = byte(value & 0xFF)
-		case SizeWord:
-			c.WriteU16(addr, uint16(value&0xFFFF))
-		case SizeLong:
-			c.WriteU32(addr, value)
-		default:
-			return fmt.Errorf("invalid size for put operand to (d16,A%d)", reg)
-		}
-		return nil
-	case ModeOther: // Miscellaneous modes
-		switch reg {
-		case RegAbsShort: // Absolute Short
-			// FIX: Do not advance PC here.
-			addr := uint32(signExtend16(c.ReadU16(c.PC)))
-			switch size {
-			case SizeByte:
-				c.Mem[addr] = byte(value & 0xFF)
-			case SizeWord:
-				c.WriteU16(addr, uint16(value&0xFFFF))
-			case SizeLong:
-				c.WriteU32(addr, value)
-			default:
-				return fmt.Errorf("invalid size for put operand to (xxx).W")
-			}
-			return nil
-		case RegAbsLong: // Absolute Long
-			// FIX: Do not advance PC here.
-			addr := c.ReadU32(c.PC)
-			switch size {
-			case SizeByte:
-				c.Mem[addr] = byte(value & 0xFF)
-			case SizeWord:
-				c.WriteU16(addr, uint16(value&0xFFFF))
-			case SizeLong:
-				c.WriteU32(addr, value)
-			default:
-				return fmt.Errorf("invalid size for put operand to (xxx).L")
-			}
-			return nil
-		default:
-			return fmt.Errorf("invalid destination addressing sub-mode %d for mode %d", reg, mode)
-		}
+// indexValue returns the index register an extension word names, as a long
+// or a sign-extended word, multiplied by its scale.
+func (c *CPU) indexValue(ext uint16) uint32 {
+	reg := (ext >> 12) & 0x7
+	index := c.D[reg]
+	if ext&0x8000 != 0 {
+		index = c.A[reg]
+	}
+	if ext&0x0800 == 0 { // Word index, sign-extended
+		index = uint32(signExtend16(uint16(index)))
+	}
+	return index << ((ext >> 9) & 3)
+}
+
+// fullIndexedAddress finishes an address with a full extension word:
+// D/A <reg> W/L <scale> 1 BS IS <bd size> 0 <I/IS>
+// followed by the base displacement and the outer displacement. BS and IS
+// suppress the base and index registers. I/IS selects no memory indirection,
+// pre-indexed indirection, where the index is added before the pointer is
+// read, or post-indexed, where it is added after.
+func (c *CPU) fullIndexedAddress(base uint32, ext uint16) (uint32, error) {
+	if ext&0x0008 != 0 {
+		return 0, fmt.Errorf("reserved full extension word $%04X", ext)
+	}
+	if ext&0x0080 != 0 {
+		base = 0
+	}
+	var index uint32
+	if ext&0x0040 == 0 {
+		index = c.indexValue(ext)
+	}
+	bd, ok := c.displacement((ext >> 4) & 3)
+	if !ok {
+		return 0, fmt.Errorf("reserved base displacement size in full extension word $%04X", ext)
+	}
+
+	iis := ext & 7
+	if ext&0x0040 != 0 && iis > 3 || iis == 4 {
+		return 0, fmt.Errorf("reserved memory indirection in full extension word $%04X", ext)
+	}
+	if iis == 0 {
+		return base + bd + index, nil
+	}
+	od, _ := c.displacement(iis & 3)
+	if iis < 4 {
+		return c.ReadU32(base+bd+index) + od, nil
+	}
+	return c.ReadU32(base+bd) + index + od, nil
+}
+
+// displacement reads a displacement of a full extension word by its size
+// field: 1 for none, 2 for a sign-extended word and 3 for a long. Size 0 is
+// reserved.
+func (c *CPU) displacement(size uint16) (uint32, bool) {
+	switch size {
+	case 1:
+		return 0, true
+	case 2:
+		d := uint32(signExtend16(c.ReadU16(c.PC)))
+		c.PC += 2
+		return d, true
+	case 3:
+		d := c.ReadU32(c.PC)
+		c.PC += 4
+		return d, true
+	}
+	return 0, false
+}
+
+// memoryAddress resolves a memory <ea> once, for instructions that read and
+// write the same operand. (An)+ and -(An) step by the operand size, and by 2
+// for bytes on A7.
+func (c *CPU) memoryAddress(mode, reg uint16, size Size) (uint32, error) {
+	step := uint32(size.Bytes())
+	if size == SizeByte && reg == 7 {
+		step = 2
+	}
+
+	var addr uint32
+	switch mode {
+	case ModeAddrPostInc:
+		addr = c.A[reg]
+		c.A[reg] += step
+	case ModeAddrPreDec:
+		c.A[reg] -= step
+		addr = c.A[reg]
 	default:
-		return fmt.Errorf("unimplemented destination addressing mode %d", mode)
+		var err error
+		addr, err = c.effectiveAddress(mode, reg)
+		if err != nil {
+			return 0, err
+		}
 	}
+	return addr, nil
 }
 
-// signExtend16 correctly sign-extends a 16-bit value to 32 bits.
-func signExtend16(v uint16) int32 {
-	return int32(int16(v))
+// modifyOperand replaces a data register or memory operand with fn's result and
+// returns it. The <ea> is resolved once, so its extension words and any (An)+
+// or -(An) step are only consumed a single time.
+func (c *CPU) modifyOperand(mode, reg uint16, size Size, fn func(uint32) uint32) (uint32, error) {
+	o, err := c.ResolveOperand(mode, reg, size)
+	if err != nil {
+		return 0, err
+	}
+	result := fn(c.ReadOperand(o))
+	return result, c.WriteOperand(o, result)
 }
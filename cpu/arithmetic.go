@@ -5,68 +5,284 @@ import "fmt"
 // opADD handles the ADD instruction.
 // This function calculates the result and then calls a helper to set the flags.
 func (c *CPU) opADD(inst *DecodedInstruction) error {
-	// Determine the direction of the operation from the opcode.
-	// Bit 8 (opmode bit) determines direction:
+	// Bit 2 of the opmode determines direction:
 	// 0: Dn = Dn + <ea>
 	// 1: <ea> = <ea> + Dn
-	var src, dst uint32
-	var err error
-
-	// Fetch operands based on direction
-	if inst.OpMode&0x100 == 0 { // Direction is to Dn
-		dst, err = c.GetOperand(ModeData, inst.DstReg, inst.Size)
-		if err != nil {
-			return fmt.Errorf("ADD failed to get destination operand: %w", err)
-		}
-		src, err = c.GetOperand(inst.SrcMode, inst.SrcReg, inst.Size)
-		if err != nil {
-			return fmt.Errorf("ADD failed to get source operand: %w", err)
-		}
-	} else { // Direction is to <ea>
-		dst, err = c.GetOperand(inst.SrcMode, inst.SrcReg, inst.Size)
-		if err != nil {
-			return fmt.Errorf("ADD failed to get destination operand: %w", err)
-		}
-		src, err = c.GetOperand(ModeData, inst.DstReg, inst.Size)
-		if err != nil {
-			return fmt.Errorf("ADD failed to get source operand: %w", err)
-		}
-	}
-
-	// Perform the addition and set flags
+	// The <ea> is resolved once, so it is read and written at the same place.
+	ea, err := c.ResolveOperand(inst.SrcMode, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("ADD failed to get <ea> operand: %w", err)
+	}
+	from, to := ea, Operand{Mode: ModeData, Reg: inst.DstReg, Size: inst.Size}
+	if inst.OpMode&0b100 != 0 {
+		from, to = to, ea
+	}
+	src, dst := c.ReadOperand(from), c.ReadOperand(to)
+
 	result := dst + src
 	c.setFlagsArith(src, dst, result, inst.Size)
 
-	// Write the result back to the correct destination
-	if inst.OpMode&0x100 == 0 { // Direction is to Dn
-		err = c.PutOperand(ModeData, inst.DstReg, inst.Size, result)
-	} else { // Direction is to <ea>
-		err = c.PutOperand(inst.SrcMode, inst.SrcReg, inst.Size, result)
+	if err := c.WriteOperand(to, result); err != nil {
+		return fmt.Errorf("ADD failed to put result: %w", err)
 	}
+	return nil
+}
+
+// opADDX handles ADDX, which adds the source and X to the destination, for
+// arithmetic wider than a long. Z is only ever cleared, so after a chain of
+// ADDX it shows whether the whole result is zero.
+// Format: 1101 <Rx> 1 <size> 00 <rm> <Ry>
+func (c *CPU) opADDX(inst *DecodedInstruction) error {
+	from, to, err := c.extendOperands(inst)
 	if err != nil {
-		return fmt.Errorf("ADD failed to put result: %w", err)
+		return fmt.Errorf("ADDX failed to get operands: %w", err)
+	}
+	src, dst := c.ReadOperand(from), c.ReadOperand(to)
+
+	z := c.SR & SRZ
+	result := dst + src
+	if c.SR&SRX != 0 {
+		result++
+	}
+	c.setFlagsArith(src, dst, result, inst.Size)
+	if c.SR&SRZ != 0 {
+		c.SR = c.SR&^SRZ | z
 	}
 
+	if err := c.WriteOperand(to, result); err != nil {
+		return fmt.Errorf("ADDX failed to put result: %w", err)
+	}
 	return nil
 }
 
+// extendOperands resolves the operands of ADDX and SUBX: Dy and Dx, or -(Ay)
+// and -(Ax) when SrcMode is ModeAddr, with the source decremented first.
+func (c *CPU) extendOperands(inst *DecodedInstruction) (from, to Operand, err error) {
+	mode := uint16(ModeData)
+	if inst.SrcMode == ModeAddr {
+		mode = ModeAddrPreDec
+	}
+	if from, err = c.ResolveOperand(mode, inst.SrcReg, inst.Size); err != nil {
+		return from, to, err
+	}
+	to, err = c.ResolveOperand(mode, inst.DstReg, inst.Size)
+	return from, to, err
+}
+
 // opADDQ handles the ADDQ (Add Quick) instruction.
 // Format: 0101 <data> 0 <size> <ea>
 func (c *CPU) opADDQ(inst *DecodedInstruction) error {
 	// The immediate value (1-8) was stored in SrcReg by the decoder.
 	src := uint32(inst.SrcReg)
 
-	dst, err := c.GetOperand(inst.DstMode, inst.DstReg, inst.Size)
+	// Address registers are always changed as a whole, without affecting flags.
+	if inst.DstMode == ModeAddr {
+		c.A[inst.DstReg] += src
+		return nil
+	}
+
+	ea, err := c.ResolveOperand(inst.DstMode, inst.DstReg, inst.Size)
 	if err != nil {
 		return fmt.Errorf("ADDQ failed to get destination operand: %w", err)
 	}
+	dst := c.ReadOperand(ea)
 
 	result := dst + src
 	c.setFlagsArith(src, dst, result, inst.Size)
 
-	err = c.PutOperand(inst.DstMode, inst.DstReg, inst.Size, result)
+	err = c.WriteOperand(ea, result)
 	if err != nil {
 		return fmt.Errorf("ADDQ failed to put result: %w", err)
 	}
 	return nil
 }
+
+// opSUB handles the SUB instruction.
+// Format: 1001 <Dn> <opmode> <ea>
+func (c *CPU) opSUB(inst *DecodedInstruction) error {
+	// Bit 2 of the opmode determines direction:
+	// 0: Dn = Dn - <ea>
+	// 1: <ea> = <ea> - Dn
+	// The <ea> is resolved once, so it is read and written at the same place.
+	ea, err := c.ResolveOperand(inst.SrcMode, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("SUB failed to get <ea> operand: %w", err)
+	}
+	from, to := ea, Operand{Mode: ModeData, Reg: inst.DstReg, Size: inst.Size}
+	if inst.OpMode&0b100 != 0 {
+		from, to = to, ea
+	}
+	src, dst := c.ReadOperand(from), c.ReadOperand(to)
+
+	result := dst - src
+	c.setFlagsSub(src, dst, result, inst.Size)
+
+	if err := c.WriteOperand(to, result); err != nil {
+		return fmt.Errorf("SUB failed to put result: %w", err)
+	}
+	return nil
+}
+
+// opSUBX handles SUBX, which subtracts the source and X from the destination,
+// for arithmetic wider than a long. As with ADDX, Z is only ever cleared.
+// Format: 1001 <Rx> 1 <size> 00 <rm> <Ry>
+func (c *CPU) opSUBX(inst *DecodedInstruction) error {
+	from, to, err := c.extendOperands(inst)
+	if err != nil {
+		return fmt.Errorf("SUBX failed to get operands: %w", err)
+	}
+	src, dst := c.ReadOperand(from), c.ReadOperand(to)
+
+	z := c.SR & SRZ
+	result := dst - src
+	if c.SR&SRX != 0 {
+		result--
+	}
+	c.setFlagsSub(src, dst, result, inst.Size)
+	if c.SR&SRZ != 0 {
+		c.SR = c.SR&^SRZ | z
+	}
+
+	if err := c.WriteOperand(to, result); err != nil {
+		return fmt.Errorf("SUBX failed to put result: %w", err)
+	}
+	return nil
+}
+
+// opSUBA handles the SUBA instruction. A word source is sign-extended and the
+// whole address register is used. Condition codes are not affected.
+// Format: 1001 <An> <opmode> <ea>
+func (c *CPU) opSUBA(inst *DecodedInstruction) error {
+	src, err := c.GetOperand(inst.SrcMode, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("SUBA failed to get source operand: %w", err)
+	}
+	if inst.Size == SizeWord {
+		src = uint32(signExtend16(uint16(src)))
+	}
+
+	c.A[inst.DstReg] -= src
+	return nil
+}
+
+// opSUBQ handles the SUBQ (Subtract Quick) instruction.
+// Format: 0101 <data> 1 <size> <ea>
+func (c *CPU) opSUBQ(inst *DecodedInstruction) error {
+	// The immediate value (1-8) was stored in SrcReg by the decoder.
+	src := uint32(inst.SrcReg)
+
+	// Address registers are always changed as a whole, without affecting flags.
+	if inst.DstMode == ModeAddr {
+		c.A[inst.DstReg] -= src
+		return nil
+	}
+
+	ea, err := c.ResolveOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("SUBQ failed to get destination operand: %w", err)
+	}
+	dst := c.ReadOperand(ea)
+
+	result := dst - src
+	c.setFlagsSub(src, dst, result, inst.Size)
+
+	err = c.WriteOperand(ea, result)
+	if err != nil {
+		return fmt.Errorf("SUBQ failed to put result: %w", err)
+	}
+	return nil
+}
+
+// opSUBI handles the SUBI (Subtract Immediate) instruction.
+// Format: 0000 0100 <size> <ea> <immediate>
+func (c *CPU) opSUBI(inst *DecodedInstruction) error {
+	// The immediate comes first, before any extension words of the destination.
+	src, err := c.GetOperand(ModeOther, RegImmediate, inst.Size)
+	if err != nil {
+		return fmt.Errorf("SUBI failed to get immediate: %w", err)
+	}
+	ea, err := c.ResolveOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("SUBI failed to get destination operand: %w", err)
+	}
+	dst := c.ReadOperand(ea)
+
+	result := dst - src
+	c.setFlagsSub(src, dst, result, inst.Size)
+
+	err = c.WriteOperand(ea, result)
+	if err != nil {
+		return fmt.Errorf("SUBI failed to put result: %w", err)
+	}
+	return nil
+}
+
+// decodeDivide handles DIVU and DIVS.
+// Format: 1000 <Dn> <s>11 <ea>, where s is 1 for DIVS
+func (c *CPU) decodeDivide(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	inst.Handler = (*CPU).opDIVU
+	if opcode&0x0100 != 0 {
+		inst.Handler = (*CPU).opDIVS
+	}
+	inst.Size = SizeWord
+	inst.SrcMode = (opcode >> 3) & 0x7
+	inst.SrcReg = opcode & 0x7
+	inst.DstReg = (opcode >> 9) & 0x7
+	if inst.SrcMode == ModeAddr {
+		return nil, fmt.Errorf("address register is not a valid divisor in opcode %04X", opcode)
+	}
+	return inst, nil
+}
+
+// opDIVU handles DIVU <ea>,Dn: Dn.L / <ea>.W, unsigned, leaving the remainder in
+// the high word and the quotient in the low word. A zero divisor takes the zero
+// divide exception. If the quotient does not fit in a word, V is set and Dn is
+// left alone.
+func (c *CPU) opDIVU(inst *DecodedInstruction) error {
+	divisor, err := c.GetOperand(inst.SrcMode, inst.SrcReg, SizeWord)
+	if err != nil {
+		return fmt.Errorf("DIVU failed to get divisor: %w", err)
+	}
+	if divisor == 0 {
+		c.SR &^= SRC
+		return c.exception(VectorZeroDivide, c.PC)
+	}
+
+	dividend := c.D[inst.DstReg]
+	c.Cycles += uint64(divuCycles(dividend, uint16(divisor)))
+	q, r := dividend/divisor, dividend%divisor
+	c.setDivideResult(inst.DstReg, q > 0xFFFF, q, r)
+	return nil
+}
+
+// opDIVS handles DIVS <ea>,Dn, the signed form of DIVU. The remainder has the
+// sign of the dividend.
+func (c *CPU) opDIVS(inst *DecodedInstruction) error {
+	divisor, err := c.GetOperand(inst.SrcMode, inst.SrcReg, SizeWord)
+	if err != nil {
+		return fmt.Errorf("DIVS failed to get divisor: %w", err)
+	}
+	if divisor == 0 {
+		c.SR &^= SRC
+		return c.exception(VectorZeroDivide, c.PC)
+	}
+
+	c.Cycles += uint64(divsCycles(int32(c.D[inst.DstReg]), int16(divisor)))
+	dividend := int64(int32(c.D[inst.DstReg]))
+	q, r := dividend/int64(int16(divisor)), dividend%int64(int16(divisor))
+	c.setDivideResult(inst.DstReg, q < -0x8000 || q > 0x7FFF, uint32(q), uint32(r))
+	return nil
+}
+
+// setDivideResult stores the quotient and remainder of a division in Dn and
+// sets the flags. C is always cleared.
+func (c *CPU) setDivideResult(reg uint16, overflow bool, q, r uint32) {
+	c.SR &^= SRC
+	if overflow {
+		c.SR |= SRV
+		return
+	}
+	c.D[reg] = r<<16 | q&0xFFFF
+	c.setNZ(q, SizeWord)
+	c.SR &^= SRV
+}
@@ -0,0 +1,83 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUnmapped is returned by a Bus for an address nothing answers.
+var ErrUnmapped = errors.New("address is not mapped")
+
+// Bus is the CPU's view of the address space. Every memory access made by an
+// instruction, an exception or the host accessors goes through it, so a Bus can
+// map devices, ROM, mirrors and holes without the instructions knowing. An
+// error from any method makes the access take a bus error exception. Words and
+// longs are big-endian; alignment is checked by the CPU before the bus is asked.
+type Bus interface {
+	Read8(addr uint32) (uint8, error)
+	Read16(addr uint32) (uint16, error)
+	Read32(addr uint32) (uint32, error)
+	Write8(addr uint32, v uint8) error
+	Write16(addr uint32, v uint16) error
+	Write32(addr uint32, v uint32) error
+}
+
+// RAM is a Bus of plain memory starting at address 0. Accesses past its end
+// return ErrUnmapped.
+type RAM []byte
+
+// in reports whether n bytes at addr are inside the RAM.
+func (m RAM) in(addr, n uint32) bool {
+	return uint64(addr)+uint64(n) <= uint64(len(m))
+}
+
+// Read8 reads a byte.
+func (m RAM) Read8(addr uint32) (uint8, error) {
+	if !m.in(addr, 1) {
+		return 0, ErrUnmapped
+	}
+	return m[addr], nil
+}
+
+// Read16 reads a word.
+func (m RAM) Read16(addr uint32) (uint16, error) {
+	if !m.in(addr, 2) {
+		return 0, ErrUnmapped
+	}
+	return binary.BigEndian.Uint16(m[addr:]), nil
+}
+
+// Read32 reads a long.
+func (m RAM) Read32(addr uint32) (uint32, error) {
+	if !m.in(addr, 4) {
+		return 0, ErrUnmapped
+	}
+	return binary.BigEndian.Uint32(m[addr:]), nil
+}
+
+// Write8 writes a byte.
+func (m RAM) Write8(addr uint32, v uint8) error {
+	if !m.in(addr, 1) {
+		return ErrUnmapped
+	}
+	m[addr] = v
+	return nil
+}
+
+// Write16 writes a word.
+func (m RAM) Write16(addr uint32, v uint16) error {
+	if !m.in(addr, 2) {
+		return ErrUnmapped
+	}
+	binary.BigEndian.PutUint16(m[addr:], v)
+	return nil
+}
+
+// Write32 writes a long.
+func (m RAM) Write32(addr uint32, v uint32) error {
+	if !m.in(addr, 4) {
+		return ErrUnmapped
+	}
+	binary.BigEndian.PutUint32(m[addr:], v)
+	return nil
+}
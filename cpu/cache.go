@@ -0,0 +1,114 @@
+package cpu
+
+// cacheEntry is a decoded instruction remembered for one address.
+type cacheEntry struct {
+	// opcode is the instruction word the entry was decoded from.
+	opcode uint16
+	inst   *DecodedInstruction
+}
+
+// Cache holds decoded instructions keyed by the address they were fetched from.
+// Entries are validated against the opcode in memory on every lookup, so code
+// that rewrites itself never executes a stale decode.
+type Cache struct {
+	entries  map[uint32]cacheEntry
+	capacity int
+	stats    CacheStats
+}
+
+// CacheStats is a snapshot of the instruction cache counters.
+type CacheStats struct {
+	// Capacity is the maximum number of cached instructions. Zero disables the cache.
+	Capacity int
+	// Entries is the number of instructions currently cached.
+	Entries int
+	// Hits counts lookups that found a valid entry.
+	Hits uint64
+	// Misses counts lookups that had to decode the instruction.
+	Misses uint64
+	// Invalidations counts entries dropped because memory changed under them.
+	Invalidations uint64
+	// Evictions counts entries dropped to make room for new ones.
+	Evictions uint64
+}
+
+// NewCache creates a decoded instruction cache holding up to capacity entries.
+func NewCache(capacity int) *Cache {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &Cache{
+		entries:  make(map[uint32]cacheEntry, capacity),
+		capacity: capacity,
+	}
+}
+
+// Lookup returns the decoded instruction for addr if the cached entry still
+// matches the opcode currently in memory.
+func (ic *Cache) Lookup(addr uint32, opcode uint16) (*DecodedInstruction, bool) {
+	if ic.capacity == 0 {
+		ic.stats.Misses++
+		return nil, false
+	}
+
+	e, ok := ic.entries[addr]
+	if !ok {
+		ic.stats.Misses++
+		return nil, false
+	}
+
+	if e.opcode != opcode {
+		// Self-modifying code: the word at addr is no longer what we decoded.
+		delete(ic.entries, addr)
+		ic.stats.Invalidations++
+		ic.stats.Misses++
+		return nil, false
+	}
+
+	ic.stats.Hits++
+	return e.inst, true
+}
+
+// Store remembers a decoded instruction for addr, evicting an entry if the cache is full.
+func (ic *Cache) Store(addr uint32, opcode uint16, inst *DecodedInstruction) {
+	if ic.capacity == 0 {
+		return
+	}
+
+	if _, ok := ic.entries[addr]; !ok && len(ic.entries) >= ic.capacity {
+		// Map iteration order is unspecified, which is good enough as a cheap random eviction.
+		for k := range ic.entries {
+			delete(ic.entries, k)
+			ic.stats.Evictions++
+			break
+		}
+	}
+	ic.entries[addr] = cacheEntry{opcode: opcode, inst: inst}
+}
+
+// Flush empties the cache. Counters are kept.
+func (ic *Cache) Flush() {
+	clear(ic.entries)
+}
+
+// Resize changes the capacity, flushing the cache in the process.
+func (ic *Cache) Resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	ic.capacity = capacity
+	ic.entries = make(map[uint32]cacheEntry, capacity)
+}
+
+// ResetStats zeroes the hit/miss counters.
+func (ic *Cache) ResetStats() {
+	ic.stats = CacheStats{}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (ic *Cache) Stats() CacheStats {
+	s := ic.stats
+	s.Capacity = ic.capacity
+	s.Entries = len(ic.entries)
+	return s
+}
@@ -0,0 +1,120 @@
+package cpu
+
+import "fmt"
+
+// decodeCompare handles the 1011 group: CMP, CMPA, CMPM and EOR.
+// Format: 1011 <reg> <opmode> <ea>
+func (c *CPU) decodeCompare(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	opmode := (opcode >> 6) & 0b111
+	inst.DstReg = (opcode >> 9) & 0x7
+	inst.SrcMode = (opcode >> 3) & 0x7
+	inst.SrcReg = opcode & 0x7
+
+	switch {
+	case opmode == 0b011 || opmode == 0b111: // CMPA
+		inst.Handler = (*CPU).opCMPA
+		inst.Size = SizeWord
+		if opmode == 0b111 {
+			inst.Size = SizeLong
+		}
+	case opmode < 0b011: // CMP
+		inst.Handler = (*CPU).opCMP
+		inst.Size = sizeFromBits(opmode)
+	case inst.SrcMode == ModeAddr: // CMPM (Ay)+,(Ax)+
+		inst.Handler = (*CPU).opCMPM
+		inst.Size = sizeFromBits(opmode)
+	default:
+		return c.decodeLogical(opcode, inst, (*CPU).opEOR)
+	}
+	return inst, nil
+}
+
+// compare sets the flags for dst - src, like SUB but leaving X alone.
+func (c *CPU) compare(src, dst uint32, size Size) {
+	x := c.SR & SRX
+	c.setFlagsSub(src, dst, dst-src, size)
+	c.SR = c.SR&^SRX | x
+}
+
+// opCMP handles CMP <ea>,Dn.
+func (c *CPU) opCMP(inst *DecodedInstruction) error {
+	src, err := c.GetOperand(inst.SrcMode, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMP failed to get source operand: %w", err)
+	}
+	dst, err := c.GetOperand(ModeData, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMP failed to get register operand: %w", err)
+	}
+	c.compare(src, dst, inst.Size)
+	return nil
+}
+
+// opCMPA handles CMPA <ea>,An. A word source is sign-extended and compared
+// with the whole address register.
+func (c *CPU) opCMPA(inst *DecodedInstruction) error {
+	src, err := c.GetOperand(inst.SrcMode, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMPA failed to get source operand: %w", err)
+	}
+	if inst.Size == SizeWord {
+		src = uint32(signExtend16(uint16(src)))
+	}
+	c.compare(src, c.A[inst.DstReg], SizeLong)
+	return nil
+}
+
+// opCMPM handles CMPM (Ay)+,(Ax)+.
+func (c *CPU) opCMPM(inst *DecodedInstruction) error {
+	src, err := c.GetOperand(ModeAddrPostInc, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMPM failed to get source operand: %w", err)
+	}
+	dst, err := c.GetOperand(ModeAddrPostInc, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMPM failed to get destination operand: %w", err)
+	}
+	c.compare(src, dst, inst.Size)
+	return nil
+}
+
+// opCMPI handles CMPI #<data>,<ea>.
+// Format: 0000 1100 <size> <ea> <immediate>
+func (c *CPU) opCMPI(inst *DecodedInstruction) error {
+	src, err := c.GetOperand(ModeOther, RegImmediate, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMPI failed to get immediate: %w", err)
+	}
+	dst, err := c.GetOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CMPI failed to get destination operand: %w", err)
+	}
+	c.compare(src, dst, inst.Size)
+	return nil
+}
+
+// opTST handles TST <ea>, which sets N and Z from the operand and clears V and C.
+// Format: 0100 1010 <size> <ea>
+func (c *CPU) opTST(inst *DecodedInstruction) error {
+	v, err := c.GetOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("TST failed to get operand: %w", err)
+	}
+	c.setFlagsLogical(v, inst.Size)
+	return nil
+}
+
+// opTAS handles TAS <ea>, which sets N and Z from a byte and then sets its high
+// bit. The read and write are one indivisible bus cycle, so TAS can take a lock
+// shared with another CPU.
+// Format: 0100 1010 11 <ea>
+func (c *CPU) opTAS(inst *DecodedInstruction) error {
+	_, err := c.modifyOperand(inst.DstMode, inst.DstReg, SizeByte, func(dst uint32) uint32 {
+		c.setFlagsLogical(dst, SizeByte)
+		return dst | 0x80
+	})
+	if err != nil {
+		return fmt.Errorf("TAS failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package cpu
+
+// Coprocessor executes the line 1111 instructions of a coprocessor on the
+// 68020's coprocessor interface, such as the 68881 FPU. Its ID, in bits
+// 11-9 of the opcode, picks it out of CPU.Coprocessors.
+type Coprocessor interface {
+	// Execute runs the instruction whose first word is opcode, with PC
+	// just past it, consuming any further words. It returns false for a
+	// command it doesn't know, which takes the line 1111 exception.
+	Execute(c *CPU, opcode uint16) (bool, error)
+}
+
+// SetCoprocessor attaches a coprocessor with the given ID (0-7), or detaches
+// it if cp is nil, and flushes the instruction cache. Coprocessors are only
+// reached on the 68020 and later, and the 68040 and 68060 only have the FPU,
+// with ID 1.
+func (c *CPU) SetCoprocessor(id int, cp Coprocessor) {
+	c.Coprocessors[id&7] = cp
+	c.FlushCache()
+}
+
+// coprocessorFor returns the coprocessor a line 1111 opcode is for, if one
+// is attached and the model reaches it.
+func (c *CPU) coprocessorFor(opcode uint16) Coprocessor {
+	id := opcode >> 9 & 7
+	if c.Model < MC68020 || c.Model >= MC68040 && id != 1 {
+		return nil
+	}
+	return c.Coprocessors[id]
+}
+
+// opCoprocessor passes a line 1111 instruction to its coprocessor. The
+// opcode is in OpMode.
+func (c *CPU) opCoprocessor(inst *DecodedInstruction) error {
+	handled, err := c.coprocessorFor(inst.OpMode).Execute(c, inst.OpMode)
+	if err != nil || handled {
+		return err
+	}
+	c.PC = c.instAddr + 2
+	return c.lineTrap(VectorLineF, inst.OpMode)
+}
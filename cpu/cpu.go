@@ -13,19 +13,94 @@ type CPU struct {
 	// SSP is the supervisor stack pointer.
 	SSP uint32
 	// SR is the status register.
-	SR uint16
+	SR SR
 	// ISP is the interrupt stack pointer.
 	ISP uint32
+	// VBR is the vector base register. The 68000 has none and always uses 0.
+	VBR uint32
+	// SFC and DFC are the source and destination function code registers of
+	// the 68010 and later, used by MOVES. Only the low three bits are kept.
+	SFC, DFC uint32
+	// Model is the member of the family being emulated. Use SetModel to
+	// change it once instructions have run.
+	Model Model
 
-	// Memory
+	// Mem is the RAM behind the default bus. A custom Bus may use it for its
+	// RAM or leave it nil.
 	Mem []byte
-	// Cache for instructions.
-	ICache map[uint32]uint32
+	// Bus carries every memory access. New sets it to RAM(Mem).
+	Bus Bus
+	// AddressMask is applied to every address before it reaches the bus. The
+	// 68000 and 68010 drive only 24 address lines, so New sets Address24 and
+	// addresses wrap at 16 MiB. SetModel sets Address32 for a 68020 or later.
+	AddressMask uint32
+	// ICache holds decoded instructions.
+	ICache *Cache
 
-	// Cycles count.
-	Cycles int32
+	// Cycles counts elapsed clock cycles, using the 68000's instruction and
+	// exception timings. Wait states are not modelled.
+	Cycles uint64
+	// Instructions counts executed instructions.
+	Instructions uint64
+	// Exceptions counts exceptions taken, including TRAPs.
+	Exceptions uint64
 	// Running or not.
 	Running bool
+	// StrictAlignment makes word and long accesses and instruction fetches at
+	// odd addresses take an address error, as on a real 68000. Without it they
+	// read and write the misaligned bytes.
+	StrictAlignment bool
+	// Stopped is set by STOP. The CPU executes nothing until an interrupt
+	// or Reset clears it, but time still passes.
+	Stopped bool
+
+	// OnBeforeExecute, if set, is called with the address and first word of
+	// each instruction after it is fetched and before it is decoded.
+	OnBeforeExecute func(pc uint32, opcode uint16)
+	// OnAfterExecute, if set, is called with the address and decoded form of
+	// each instruction that completes, before any trace exception. It is not
+	// called for instructions that fault.
+	OnAfterExecute func(pc uint32, inst *DecodedInstruction)
+	// HostTraps makes TRAP #n call HostTraps[n], when it is set, instead of
+	// taking the exception, so the host can provide system calls. The call
+	// takes the exception's time, and an error fails the instruction.
+	HostTraps [16]func(c *CPU) error
+	// LineTraps makes an opcode starting with $A or $F that would take the
+	// line 1010 or line 1111 emulator exception call LineTraps[opcode]
+	// instead, as classic Mac OS uses the A-line for its system calls. The
+	// handler runs with PC just past the opcode, so it can read and skip
+	// any words that follow. The call takes the exception's time, and an
+	// error fails the instruction.
+	LineTraps map[uint16]func(c *CPU) error
+	// Coprocessors are the coprocessors attached by SetCoprocessor, by ID.
+	Coprocessors [8]Coprocessor
+	// Unimplemented chooses what happens to an opcode that doesn't decode:
+	// an error from Execute (the default), an exception into the guest, or
+	// a call to OnUnimplemented.
+	Unimplemented UnimplementedPolicy
+	// OnUnimplemented is called under UnimplementedCallback with the opcode
+	// and PC just past it. To emulate the instruction it reads and skips
+	// any extension words, updates the registers and returns true. Returning
+	// false takes the exception instead, and an error fails the instruction.
+	OnUnimplemented func(c *CPU, opcode uint16) (bool, error)
+
+	// exceptionStats counts exceptions and remembers the latest.
+	exceptionStats exceptionStats
+	// checkAlign is set while an instruction runs in strict mode.
+	checkAlign bool
+	// irq holds the interrupt request lines.
+	irq irqLines
+	// instAddr is the address of the instruction being executed.
+	instAddr uint32
+	// watching is set while an instruction runs with watchpoints set.
+	watching bool
+	// watches are the watchpoints, numbered from watchID.
+	watches []watchpoint
+	watchID int
+	// watchHit is the watchpoint that paused the current instruction.
+	watchHit *WatchpointHit
+	// breakpoints are the addresses RunUntil stops at.
+	breakpoints map[uint32]bool
 }
 
 // Status register flags.
@@ -54,12 +129,44 @@ const (
 	SRI = SRI0 | SRI1 | SRI2
 )
 
-// New creates a new CPU instance with given memory size.
+// Address masks for CPU.AddressMask.
+const (
+	// Address24 is the 16 MiB address space of the 68000, 68008 and 68010.
+	Address24 = 0x00FFFFFF
+	// Address32 is the full address space of the 68020 and later.
+	Address32 = 0xFFFFFFFF
+)
+
+// New creates a new CPU instance with given memory size and instruction cache capacity.
+// A cachesize of 0 disables the decoded instruction cache. Like a 68000 after reset,
+// the CPU starts in supervisor mode with interrupts masked, so A7 is the supervisor
+// stack pointer.
 func New(memsize, cachesize int) *CPU {
+	mem := make([]byte, memsize)
 	cpu := &CPU{
-		Mem:     make([]byte, memsize),
-		ICache:  make(map[uint32]uint32, cachesize),
-		Running: false,
+		Mem:         mem,
+		Bus:         RAM(mem),
+		AddressMask: Address24,
+		ICache:      NewCache(cachesize),
+		SR:          SRS | SRI,
+		Model:       MC68000,
+		Running:     false,
 	}
 	return cpu
 }
+
+// CacheStats returns the instruction cache counters.
+func (c *CPU) CacheStats() CacheStats {
+	return c.ICache.Stats()
+}
+
+// FlushCache discards all decoded instructions. Call it after replacing code
+// in bulk, or to measure cold-cache performance.
+func (c *CPU) FlushCache() {
+	c.ICache.Flush()
+}
+
+// SetCacheSize changes the instruction cache capacity and flushes it.
+func (c *CPU) SetCacheSize(n int) {
+	c.ICache.Resize(n)
+}
@@ -0,0 +1,358 @@
+package cpu
+
+// Timings follow the 68000 user's manual, section 8. Decode stores the part of
+// an instruction's time known from its opcode in DecodedInstruction.Cycles, and
+// handlers add what depends on the data: taken branches, shift counts, MOVEM
+// register counts and division. Exceptions add their own processing time.
+
+// eaCycles is the time to calculate an effective address and fetch its operand,
+// for byte and word operands and for long ones, indexed by eaIndex.
+var eaCycles = [12][2]int{
+	{0, 0},   // Dn
+	{0, 0},   // An
+	{4, 8},   // (An)
+	{4, 8},   // (An)+
+	{6, 10},  // -(An)
+	{8, 12},  // (d16,An)
+	{10, 14}, // (d8,An,Xn)
+	{8, 12},  // (xxx).W
+	{12, 16}, // (xxx).L
+	{8, 12},  // (d16,PC)
+	{10, 14}, // (d8,PC,Xn)
+	{4, 8},   // #<data>
+}
+
+// jumpCycles is the time of JMP for each control mode, indexed by eaIndex. JSR
+// takes 8 cycles more.
+var jumpCycles = [12]int{2: 8, 5: 10, 6: 14, 7: 10, 8: 12, 9: 10, 10: 14}
+
+// leaCycles is the time of LEA for each control mode, indexed by eaIndex. PEA
+// takes 8 cycles more.
+var leaCycles = [12]int{2: 4, 5: 8, 6: 12, 7: 8, 8: 12, 9: 8, 10: 12}
+
+// eaIndex numbers the addressing modes 0-11, with the ModeOther submodes after
+// the register modes.
+func eaIndex(mode, reg uint16) int {
+	if mode != ModeOther {
+		return int(mode)
+	}
+	return min(7+int(reg), 11)
+}
+
+// eaTime returns the effective address time for an operand of the given size.
+func eaTime(mode, reg uint16, size Size) int {
+	if size == SizeLong {
+		return eaCycles[eaIndex(mode, reg)][1]
+	}
+	return eaCycles[eaIndex(mode, reg)][0]
+}
+
+// isRegisterOrImmediate reports whether an addressing mode is a register or an
+// immediate, which make long ALU operations into a register 2 cycles slower.
+func isRegisterOrImmediate(mode, reg uint16) bool {
+	return mode <= ModeAddr || mode == ModeOther && reg == RegImmediate
+}
+
+// instructionCycles returns the time of an instruction that can be told from its
+// opcode. It is 0 for opcodes whose time is all exception processing.
+func instructionCycles(opcode uint16) int {
+	mode, reg := (opcode>>3)&0x7, opcode&0x7
+	size := sizeFromBits(opcode >> 6)
+	long := size == SizeLong
+	ea := eaTime(mode, reg, size)
+
+	switch opcode >> 12 {
+	case 0b0000:
+		return immediateCycles(opcode, mode, reg, ea, long)
+	case 0b0001, 0b0010, 0b0011:
+		return moveCycles(opcode)
+	case 0b0100:
+		return miscCycles(opcode, mode, reg, ea, long)
+	case 0b0101:
+		switch {
+		case (opcode>>6)&0b11 != 0b11: // ADDQ, SUBQ
+			return pick(mode == ModeData, pick(long, 8, 4), pick(mode == ModeAddr, 8, pick(long, 12, 8)+ea))
+		case mode == ModeAddr: // DBcc, with the branch taken
+			return 10
+		case mode == ModeData: // Scc, with the condition false
+			return 4
+		}
+		return 8 + eaTime(mode, reg, SizeByte)
+	case 0b0110:
+		if opcode&0xFF00 == OPBSR {
+			return 18
+		}
+		return 8 // Not taken with a short displacement
+	case 0b0111: // MOVEQ
+		return 4
+	case 0b1000, 0b1100:
+		opmode := (opcode >> 6) & 0b111
+		switch {
+		case opmode == 0b011 || opmode == 0b111: // DIVU, DIVS, MULU, MULS
+			if opcode>>12 == 0b1100 {
+				return 38 + eaTime(mode, reg, SizeWord) // Plus 2 per bit of the multiplier
+			}
+			return eaTime(mode, reg, SizeWord) // Plus the division itself
+		case opmode >= 0b100 && mode <= ModeAddr && opcode&0x0130 == 0x0100: // ABCD, SBCD, EXG
+			if opcode>>12 == 0b1100 && opmode != 0b100 {
+				return 6
+			}
+			return pick(mode == ModeData, 6, 18)
+		}
+		return aluCycles(opmode, mode, reg, ea, false)
+	case 0b1001, 0b1101:
+		opmode := (opcode >> 6) & 0b111
+		switch {
+		case opmode == 0b011: // ADDA.W, SUBA.W
+			return 8 + eaTime(mode, reg, SizeWord)
+		case opmode == 0b111: // ADDA.L, SUBA.L
+			return pick(isRegisterOrImmediate(mode, reg), 8, 6) + eaTime(mode, reg, SizeLong)
+		case opmode >= 0b100 && mode <= ModeAddr: // ADDX, SUBX
+			return pick(mode == ModeData, pick(long, 8, 4), pick(long, 30, 18))
+		}
+		return aluCycles(opmode, mode, reg, ea, false)
+	case 0b1011:
+		opmode := (opcode >> 6) & 0b111
+		switch {
+		case opmode == 0b011 || opmode == 0b111: // CMPA
+			return 6 + eaTime(mode, reg, pick(opmode == 0b111, SizeLong, SizeWord))
+		case opmode >= 0b100 && mode == ModeAddr: // CMPM
+			return pick(long, 20, 12)
+		}
+		return aluCycles(opmode, mode, reg, ea, opmode < 0b100)
+	case 0b1110:
+		if (opcode>>6)&0b11 == 0b11 { // Memory shift by one
+			return 8 + eaTime(mode, reg, SizeWord)
+		}
+		return pick(long, 8, 6) // Plus 2 per bit shifted
+	}
+	return 0
+}
+
+// pick returns a if cond holds, and b otherwise.
+func pick[T any](cond bool, a, b T) T {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// aluCycles times ADD, SUB, AND, OR, EOR and CMP between a data register and an
+// effective address. Bit 2 of opmode is set when the result goes to <ea>.
+func aluCycles(opmode, mode, reg uint16, ea int, cmp bool) int {
+	long := opmode&0b11 == 0b10
+	switch {
+	case opmode&0b100 == 0 || cmp:
+		if long && !cmp && isRegisterOrImmediate(mode, reg) {
+			return 8 + ea
+		}
+		return pick(long, 6, 4) + ea
+	case mode == ModeData: // EOR Dn,Dn
+		return pick(long, 8, 4)
+	}
+	return pick(long, 12, 8) + ea
+}
+
+// immediateCycles times the 0000 group: the immediate operations and the bit
+// operations.
+func immediateCycles(opcode, mode, reg uint16, ea int, long bool) int {
+	switch opcode {
+	case OPORItoCCR, OPANDItoCCR, OPEORItoCCR, OPORItoSR, OPANDItoSR, OPEORItoSR:
+		return 20
+	}
+
+	if (opcode>>8)&1 == 1 && mode == ModeAddr { // MOVEP
+		return pick(opcode&0x0040 != 0, 24, 16)
+	}
+
+	if dynamic := (opcode>>8)&1 == 1; dynamic || opcode&0xFF00 == OPBTST {
+		btst := (opcode>>6)&0b11 == 0
+		extra := pick(dynamic, 0, 4) // The bit number is an extension word
+		if mode == ModeData {
+			// The manual's maximum, for bit numbers 16-31 in a long register.
+			switch (opcode >> 6) & 0b11 {
+			case 0b00:
+				return 6 + extra
+			case 0b10:
+				return 10 + extra
+			}
+			return 8 + extra
+		}
+		return pick(btst, 4, 8) + extra + eaTime(mode, reg, SizeByte)
+	}
+
+	if opcode&0xFF00 == OPMOVES { // Roughly the 68010's time
+		return pick(long, 12, 8) + ea
+	}
+	if opcode&0xFF00 == OPCMPI {
+		return pick(mode == ModeData, pick(long, 14, 8), pick(long, 12, 8)+ea)
+	}
+	return pick(mode == ModeData, pick(long, 16, 8), pick(long, 20, 12)+ea)
+}
+
+// moveCycles times MOVE and MOVEA: 4 cycles plus the source's effective address
+// time and the destination's, where -(An) costs the same as (An).
+func moveCycles(opcode uint16) int {
+	size := SizeWord
+	switch (opcode >> 12) & 0b11 {
+	case 0b01:
+		size = SizeByte
+	case 0b10:
+		size = SizeLong
+	}
+	dstMode, dstReg := (opcode>>6)&0x7, (opcode>>9)&0x7
+	if dstMode == ModeAddrPreDec {
+		dstMode = ModeAddrInd
+	}
+	return 4 + eaTime((opcode>>3)&0x7, opcode&0x7, size) + eaTime(dstMode, dstReg, size)
+}
+
+// miscCycles times the 0100 group.
+func miscCycles(opcode, mode, reg uint16, ea int, long bool) int {
+	switch {
+	case opcode&0xFFC0 == OPMOVEFromSR, opcode&0xFFC0 == OPMOVEFromCCR:
+		return pick(mode == ModeData, 6, 8+eaTime(mode, reg, SizeWord))
+	case opcode&0xFFFE == OPMOVEC: // The 68010's time
+		return pick(opcode&1 == 0, 10, 12)
+	case opcode == OPRTD:
+		return 16
+	case opcode&0xFFC0 == OPMOVEToCCR, opcode&0xFFC0 == OPMOVEToSR:
+		return 12 + eaTime(mode, reg, SizeWord)
+	case opcode == OPRTE, opcode == OPRTR:
+		return 20
+	case opcode == OPRESET:
+		return 132
+	case opcode == OPRTS:
+		return 16
+	case opcode == OPILLEGAL, opcode&0xFFF0 == OPTRAP:
+		return 0
+	case opcode&0xFFF8 == OPLINK:
+		return 16
+	case opcode&0xFFF8 == OPUNLK:
+		return 12
+	case opcode&0xF1C0 == OPCHK:
+		return 10 + eaTime(mode, reg, SizeWord)
+	case opcode&0xFFC0 == OPJMP:
+		return jumpCycles[eaIndex(mode, reg)]
+	case opcode&0xFFC0 == OPJSR:
+		return jumpCycles[eaIndex(mode, reg)] + 8
+	case opcode&0xF1C0 == OPLEA:
+		return leaCycles[eaIndex(mode, reg)]
+	case opcode&0xFFC0 == OPPEA && mode != ModeData: // Mode 0 is SWAP
+		return leaCycles[eaIndex(mode, reg)] + 8
+	case opcode&0xFB80 == OPMOVEM && mode != ModeData:
+		// Plus 4 per word or 8 per long moved.
+		if mode == ModeAddrPostInc || mode == ModeAddrPreDec {
+			mode = ModeAddrInd
+		}
+		return pick(opcode&0x0400 != 0, 8, 4) + eaTime(mode, reg, SizeWord)
+	case opcode&0xFF00 == OPTST && (opcode>>6)&0b11 != 0b11:
+		return 4 + ea
+	case opcode&0xFFC0 == OPTAS:
+		return pick(mode == ModeData, 4, 14+eaTime(mode, reg, SizeByte))
+	case (opcode&0xFF00 == OPNEGX || opcode&0xFF00 == OPCLR || opcode&0xFF00 == OPNEG || opcode&0xFF00 == OPNOT) &&
+		(opcode>>6)&0b11 != 0b11:
+		return pick(mode == ModeData, pick(long, 6, 4), pick(long, 12, 8)+ea)
+	}
+	// MOVE USP, STOP, NOP, TRAPV, EXT and SWAP.
+	return 4
+}
+
+// exceptionCycles returns the time to process an exception, beyond the time
+// already charged to the instruction that raised it.
+func exceptionCycles(vector int) int {
+	switch {
+	case vector == VectorBusError, vector == VectorAddressError:
+		return 50
+	case vector == VectorZeroDivide:
+		return 38
+	case vector == VectorCHK, vector == VectorTRAPV:
+		return 30
+	case vector >= VectorSpurious && vector < VectorTrap0, vector >= 64:
+		return 44 // Interrupts
+	}
+	return 34
+}
+
+// branchCycles returns the time a Bcc or BRA adds to its base of 8 cycles: 2
+// when taken, and for a 16-bit displacement 4 when not taken.
+func branchCycles(taken, short bool) int {
+	if !taken && short {
+		return 0
+	}
+	return pick(taken, 2, 4)
+}
+
+// divuCycles returns the time of DIVU beyond its effective address, following
+// the 68000's restoring division one quotient bit at a time. The divisor must
+// not be 0.
+func divuCycles(dividend uint32, divisor uint16) int {
+	if dividend>>16 >= uint32(divisor) {
+		return 10 // Overflow is detected before dividing.
+	}
+	mcycles := 38
+	hdivisor := uint32(divisor) << 16
+	for range 15 {
+		carry := int32(dividend) < 0
+		dividend <<= 1
+		if carry {
+			dividend -= hdivisor
+			continue
+		}
+		mcycles += 2
+		if dividend >= hdivisor {
+			dividend -= hdivisor
+			mcycles--
+		}
+	}
+	return mcycles * 2
+}
+
+// divsCycles returns the time of DIVS beyond its effective address. The divisor
+// must not be 0.
+func divsCycles(dividend int32, divisor int16) int {
+	mcycles := 6
+	if dividend < 0 {
+		mcycles++
+	}
+	adividend := uint32(dividend)
+	if dividend < 0 {
+		adividend = uint32(-int64(dividend))
+	}
+	adivisor := uint32(divisor)
+	if divisor < 0 {
+		adivisor = uint32(-int32(divisor))
+	}
+	adivisor &= 0xFFFF
+	if adividend>>16 >= adivisor {
+		return (mcycles + 2) * 2
+	}
+
+	aquot := adividend / adivisor
+	mcycles += 55
+	if divisor >= 0 {
+		if dividend >= 0 {
+			mcycles--
+		} else {
+			mcycles++
+		}
+	}
+	for range 15 {
+		if int16(aquot) >= 0 {
+			mcycles++
+		}
+		aquot <<= 1
+	}
+	return mcycles * 2
+}
+
+// aborted reports whether the instruction that just ran was abandoned for an
+// exception, so its own time is not charged. taken is the number of exceptions
+// recorded before it ran.
+func (c *CPU) aborted(taken uint64) bool {
+	if c.exceptionStats.taken == taken {
+		return false
+	}
+	last, _ := c.LastException()
+	return !traced(last.Vector)
+}
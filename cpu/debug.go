@@ -0,0 +1,130 @@
+package cpu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// BreakReason says why Step or RunUntil returned.
+type BreakReason int
+
+const (
+	// BreakNone means the instruction ran and nothing stops the CPU.
+	BreakNone BreakReason = iota
+	// BreakBreakpoint means PC is at a breakpoint, which has not run yet.
+	BreakBreakpoint
+	// BreakWatchpoint means a watchpoint paused execution after an instruction.
+	BreakWatchpoint
+	// BreakHalted means the program halted, or the CPU was not running.
+	BreakHalted
+	// BreakIdle means STOP is waiting and no interrupt is pending.
+	BreakIdle
+	// BreakCanceled means the context was canceled.
+	BreakCanceled
+	// BreakError means an instruction could not be executed.
+	BreakError
+)
+
+// String names the reason.
+func (r BreakReason) String() string {
+	switch r {
+	case BreakNone:
+		return "none"
+	case BreakBreakpoint:
+		return "breakpoint"
+	case BreakWatchpoint:
+		return "watchpoint"
+	case BreakHalted:
+		return "halted"
+	case BreakIdle:
+		return "idle"
+	case BreakCanceled:
+		return "canceled"
+	case BreakError:
+		return "error"
+	}
+	return fmt.Sprintf("BreakReason(%d)", int(r))
+}
+
+// cancelCheckInterval is how many instructions RunUntil runs between checks of
+// its context.
+const cancelCheckInterval = 1024
+
+// AddBreakpoint stops RunUntil before the instruction at addr runs.
+func (c *CPU) AddBreakpoint(addr uint32) {
+	if c.breakpoints == nil {
+		c.breakpoints = make(map[uint32]bool)
+	}
+	c.breakpoints[addr] = true
+}
+
+// RemoveBreakpoint removes the breakpoint at addr, reporting whether there was one.
+func (c *CPU) RemoveBreakpoint(addr uint32) bool {
+	if !c.breakpoints[addr] {
+		return false
+	}
+	delete(c.breakpoints, addr)
+	return true
+}
+
+// ClearBreakpoints removes every breakpoint.
+func (c *CPU) ClearBreakpoints() {
+	c.breakpoints = nil
+}
+
+// Breakpoints returns the breakpoint addresses in order.
+func (c *CPU) Breakpoints() []uint32 {
+	return slices.Sorted(maps.Keys(c.breakpoints))
+}
+
+// IsBreakpoint reports whether there is a breakpoint at addr.
+func (c *CPU) IsBreakpoint(addr uint32) bool {
+	return c.breakpoints[addr]
+}
+
+// Step executes one instruction, even if it is at a breakpoint, and reports
+// what stops the CPU before the next one. For BreakWatchpoint the error is the
+// *WatchpointHit, and for BreakError the *ExecError.
+func (c *CPU) Step() (BreakReason, error) {
+	if !c.Running {
+		return BreakHalted, nil
+	}
+	if err := c.Execute(); err != nil {
+		var hit *WatchpointHit
+		if errors.As(err, &hit) {
+			return BreakWatchpoint, err
+		}
+		return BreakError, err
+	}
+	switch {
+	case !c.Running:
+		return BreakHalted, nil
+	case c.Idle():
+		return BreakIdle, nil
+	case c.breakpoints[c.PC]:
+		return BreakBreakpoint, nil
+	}
+	return BreakNone, nil
+}
+
+// RunUntil executes instructions until something stops the CPU, as reported
+// by Step, or ctx is canceled. A breakpoint at the starting PC is stepped
+// over, so calling RunUntil again continues from a breakpoint. Set Running
+// before the first call.
+func (c *CPU) RunUntil(ctx context.Context) (BreakReason, error) {
+	for n := 1; ; n++ {
+		if reason, err := c.Step(); reason != BreakNone {
+			return reason, err
+		}
+		if n%cancelCheckInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return BreakCanceled, ctx.Err()
+			default:
+			}
+		}
+	}
+}
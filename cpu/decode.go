@@ -15,32 +15,203 @@ type DecodedInstruction struct {
 	DstMode, DstReg uint16
 	// OpMode is used by some instructions (like ADD/SUB) for direction and size bits.
 	OpMode uint16
+	// Cycles is the instruction's 68000 timing as far as the opcode decides it.
+	// The handler adds what depends on the data, such as a taken branch.
+	Cycles int
 }
 
 // Decode parses a 16-bit opcode and returns a structured instruction.
 func (c *CPU) Decode(opcode uint16) (*DecodedInstruction, error) {
+	inst, err := c.decode(opcode)
+	if err != nil {
+		return nil, err
+	}
+	inst.Cycles = instructionCycles(opcode)
+	return inst, nil
+}
+
+// decode selects the handler for an opcode and extracts its fields.
+func (c *CPU) decode(opcode uint16) (*DecodedInstruction, error) {
 	inst := &DecodedInstruction{}
+	if c.Model >= MC68020 {
+		// The 68020's additions sit in holes between older instructions.
+		if d := c.decode68020(opcode, inst); d != nil {
+			return d, nil
+		}
+	}
 
 	// Switch on the top 4 bits of the opcode, which is a common way
 	// to group M68k instructions.
 	switch opcode >> 12 {
+	case 0b0000: // Immediate and bit operations
+		return c.decodeImmediate(opcode, inst)
 	case 0b0001, 0b0010, 0b0011: // MOVE
 		return c.decodeMove(opcode, inst)
-	case 0b0101: // ADDQ, SUBQ
+	case 0b0101: // ADDQ, SUBQ, Scc, DBcc
+		if (opcode>>6)&0b11 == 0b11 {
+			return c.decodeScc(opcode, inst)
+		}
 		return c.decodeAddqSubq(opcode, inst)
+	case 0b0110: // Bcc, BRA, BSR
+		inst.Handler = (*CPU).opBcc
+		if opcode&0xFF00 == OPBSR {
+			inst.Handler = (*CPU).opBSR
+		}
+		inst.OpMode = (opcode >> 8) & 0xF // Condition
+		inst.SrcReg = opcode & 0xFF       // 8-bit displacement, 0 for a 16-bit one
+		return inst, nil
 	case 0b0111: // MOVEQ
 		return c.decodeMoveq(opcode, inst)
+	case 0b1000: // OR, DIVU, DIVS, SBCD
+		if op := (opcode >> 6) & 0b111; op == 0b011 || op == 0b111 {
+			return c.decodeDivide(opcode, inst)
+		}
+		if op := (opcode >> 6) & 0b111; op != 0b011 && op != 0b111 && !(op == 0b100 && (opcode>>4)&0b11 == 0) {
+			return c.decodeLogical(opcode, inst, (*CPU).opOR)
+		}
+	case 0b1011: // CMP, CMPA, CMPM, EOR
+		return c.decodeCompare(opcode, inst)
+	case 0b1100: // AND, MULU, MULS, ABCD, EXG
+		if opcode&0x0130 == 0x0100 && (opcode>>6)&0b11 != 0b00 && (opcode>>6)&0b11 != 0b11 {
+			return c.decodeExg(opcode, inst)
+		}
+		if op := (opcode >> 6) & 0b111; op != 0b011 && op != 0b111 && !(op >= 0b100 && (opcode>>4)&0b11 == 0) {
+			return c.decodeLogical(opcode, inst, (*CPU).opAND)
+		}
+	case 0b1001: // SUB, SUBA, SUBX
+		return c.decodeSub(opcode, inst)
 	case 0b1101: // ADD, ADDX
 		return c.decodeAdd(opcode, inst)
+	case 0b1110: // Shifts and rotates
+		return c.decodeShift(opcode, inst)
+	case 0b1111: // MOVE16 and the coprocessor interface
+		if c.coprocessorFor(opcode) != nil {
+			inst.Handler = (*CPU).opCoprocessor
+			inst.OpMode = opcode
+			return inst, nil
+		}
+		if c.Model >= MC68040 {
+			return c.decodeLineF(opcode, inst)
+		}
+		inst.Handler = (*CPU).opLineF
+		inst.OpMode = opcode
+		return inst, nil
+	case 0b1010: // The line 1010 emulator
+		inst.Handler = (*CPU).opLineA
+		inst.OpMode = opcode
+		return inst, nil
 	case 0b0100: // Miscellaneous group
 		switch {
-		case opcode&0xFFC0 == OPTRAP: // TRAP
+		case opcode&0xFFC0 == OPMOVEFromSR: // MOVE from SR
+			inst.Handler = (*CPU).opMOVEfromSR
+			inst.DstMode = (opcode >> 3) & 0x7
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFC0 == OPMOVEFromCCR && (opcode>>3)&0x7 != ModeAddr && c.Model >= MC68010: // MOVE from CCR
+			inst.Handler = (*CPU).opMOVEfromCCR
+			inst.DstMode = (opcode >> 3) & 0x7
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFC0 == OPMOVEToCCR, opcode&0xFFC0 == OPMOVEToSR: // MOVE to CCR and SR
+			inst.Handler = (*CPU).opMOVEtoCCR
+			if opcode&0xFFC0 == OPMOVEToSR {
+				inst.Handler = (*CPU).opMOVEtoSR
+			}
+			inst.SrcMode = (opcode >> 3) & 0x7
+			inst.SrcReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFF0 == OPMOVEToUSP: // MOVE to and from USP
+			inst.Handler = (*CPU).opMOVEUSP
+			inst.OpMode = (opcode >> 3) & 1
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFFE == OPMOVEC && c.Model >= MC68010: // MOVEC
+			inst.Handler = (*CPU).opMOVEC
+			inst.OpMode = opcode & 1
+			return inst, nil
+		case opcode == OPRTD && c.Model >= MC68010: // RTD
+			inst.Handler = (*CPU).opRTD
+			return inst, nil
+		case opcode == OPRTE: // RTE
+			inst.Handler = (*CPU).opRTE
+			return inst, nil
+		case opcode == OPRESET: // RESET
+			inst.Handler = (*CPU).opRESET
+			return inst, nil
+		case opcode == OPSTOP: // STOP
+			inst.Handler = (*CPU).opSTOP
+			return inst, nil
+		case opcode == OPNOP: // NOP
+			inst.Handler = (*CPU).opNOP
+			return inst, nil
+		case opcode&0xFF00 == OPNEGX && (opcode>>6)&0b11 != 0b11: // NEGX
+			return c.decodeSingle(opcode, inst, (*CPU).opNEGX)
+		case opcode&0xFF00 == OPCLR && (opcode>>6)&0b11 != 0b11: // CLR
+			return c.decodeSingle(opcode, inst, (*CPU).opCLR)
+		case opcode&0xFF00 == OPNEG && (opcode>>6)&0b11 != 0b11: // NEG
+			return c.decodeSingle(opcode, inst, (*CPU).opNEG)
+		case opcode&0xFF00 == OPNOT && (opcode>>6)&0b11 != 0b11: // NOT
+			return c.decodeSingle(opcode, inst, (*CPU).opNOT)
+		case opcode == OPILLEGAL: // ILLEGAL
+			inst.Handler = (*CPU).opILLEGAL
+			return inst, nil
+		case opcode&0xFFC0 == OPTAS: // TAS
+			inst.Handler = (*CPU).opTAS
+			inst.Size = SizeByte
+			inst.DstMode = (opcode >> 3) & 0x7
+			inst.DstReg = opcode & 0x7
+			if inst.DstMode == ModeAddr || inst.DstMode == ModeOther && inst.DstReg != RegAbsShort && inst.DstReg != RegAbsLong {
+				return nil, fmt.Errorf("invalid TAS destination in opcode %04X", opcode)
+			}
+			return inst, nil
+		case opcode == OPTRAPV: // TRAPV
+			inst.Handler = (*CPU).opTRAPV
+			return inst, nil
+		case opcode&0xF1C0 == OPCHK: // CHK
+			inst.Handler = (*CPU).opCHK
+			inst.SrcMode = (opcode >> 3) & 0x7
+			inst.SrcReg = opcode & 0x7
+			inst.DstReg = (opcode >> 9) & 0x7
+			if inst.SrcMode == ModeAddr {
+				return nil, fmt.Errorf("address register is not a valid CHK bound in opcode %04X", opcode)
+			}
+			return inst, nil
+		case opcode&0xFF00 == OPTST && (opcode>>6)&0b11 != 0b11: // TST
+			return c.decodeSingle(opcode, inst, (*CPU).opTST)
+		case opcode&0xFFB8 == OPEXT|0x80: // EXT.W and EXT.L
+			inst.Handler = (*CPU).opEXT
+			inst.Size = SizeWord
+			if opcode&0x40 != 0 {
+				inst.Size = SizeLong
+			}
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFF8 == OPSWAP: // SWAP
+			inst.Handler = (*CPU).opSWAP
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFF0 == OPTRAP: // TRAP
 			inst.Handler = (*CPU).opTRAP
 			inst.DstReg = opcode & 0xF // The vector number is in the lower 4 bits.
 			return inst, nil
 		case opcode == OPRTS: // RTS
 			inst.Handler = (*CPU).opRTS
 			return inst, nil
+		case opcode == OPRTR: // RTR
+			inst.Handler = (*CPU).opRTR
+			return inst, nil
+		case opcode&0xFFF8 == OPLINK: // LINK
+			inst.Handler = (*CPU).opLINK
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFFF8 == OPUNLK: // UNLK
+			inst.Handler = (*CPU).opUNLK
+			inst.DstReg = opcode & 0x7
+			return inst, nil
+		case opcode&0xFB80 == OPMOVEM && (opcode>>3)&0x7 != ModeData: // MOVEM (mode 0 is EXT)
+			return c.decodeMovem(opcode, inst)
+		case opcode&0xFFC0 == OPJSR, opcode&0xFFC0 == OPJMP, opcode&0xFFC0 == OPPEA, opcode&0xF1C0 == OPLEA:
+			return c.decodeControl(opcode, inst)
 		}
 	}
 
@@ -104,6 +275,50 @@ func (c *CPU) decodeAdd(opcode uint16, inst *DecodedInstruction) (*DecodedInstru
 	inst.DstReg = (opcode >> 9) & 0x7 // This is the Dn register for the operation
 	inst.SrcMode = (opcode >> 3) & 0x7
 	inst.SrcReg = opcode & 0x7
+	if inst.OpMode&0b100 != 0 && inst.SrcMode <= ModeAddr {
+		inst.Handler = (*CPU).opADDX
+	}
+	return inst, nil
+}
+
+// decodeSub handles the SUB, SUBA and SUBX instructions.
+func (c *CPU) decodeSub(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	inst.OpMode = (opcode >> 6) & 0b111 // Captures direction and size bits
+	inst.DstReg = (opcode >> 9) & 0x7   // Dn for SUB, An for SUBA
+	inst.SrcMode = (opcode >> 3) & 0x7
+	inst.SrcReg = opcode & 0x7
+
+	switch inst.OpMode {
+	case 0b011:
+		inst.Handler = (*CPU).opSUBA
+		inst.Size = SizeWord
+		return inst, nil
+	case 0b111:
+		inst.Handler = (*CPU).opSUBA
+		inst.Size = SizeLong
+		return inst, nil
+	}
+	inst.Handler = (*CPU).opSUB
+	inst.Size = sizeFromBits(inst.OpMode)
+	if inst.OpMode&0b100 != 0 && inst.SrcMode <= ModeAddr {
+		inst.Handler = (*CPU).opSUBX
+	}
+	return inst, nil
+}
+
+// decodeScc handles the Scc and DBcc instructions, which share the 11 size field.
+func (c *CPU) decodeScc(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	inst.OpMode = (opcode >> 8) & 0xF // Condition
+	inst.DstMode = (opcode >> 3) & 0x7
+	inst.DstReg = opcode & 0x7
+	if inst.DstMode == ModeAddr {
+		// An address register "destination" marks DBcc, with Dn as the counter.
+		inst.Handler = (*CPU).opDBcc
+		inst.DstMode = ModeData
+		return inst, nil
+	}
+	inst.Handler = (*CPU).opScc
+	inst.Size = SizeByte
 	return inst, nil
 }
 
@@ -113,8 +328,7 @@ func (c *CPU) decodeAddqSubq(opcode uint16, inst *DecodedInstruction) (*DecodedI
 	if (opcode>>8)&1 == 0 {
 		inst.Handler = (*CPU).opADDQ
 	} else {
-		// inst.Handler = (*CPU).opSUBQ // Not yet implemented
-		return nil, fmt.Errorf("unimplemented instruction SUBQ: %04X", opcode)
+		inst.Handler = (*CPU).opSUBQ
 	}
 
 	// The immediate data (1-8) is in bits 11-9. A value of 0 represents 8.
@@ -139,3 +353,118 @@ func (c *CPU) decodeAddqSubq(opcode uint16, inst *DecodedInstruction) (*DecodedI
 	inst.DstReg = opcode & 0x7
 	return inst, nil
 }
+
+// sizeFromBits converts the common two-bit size field (00=.b, 01=.w, 10=.l)
+// in the low bits of v to a Size. The caller must reject 11.
+func sizeFromBits(v uint16) Size {
+	switch v & 0b11 {
+	case 0b00:
+		return SizeByte
+	case 0b01:
+		return SizeWord
+	default:
+		return SizeLong
+	}
+}
+
+// decodeLogical handles AND, OR and EOR between a data register and <ea>.
+// Format: <group> <Dn> <opmode> <ea>
+func (c *CPU) decodeLogical(opcode uint16, inst *DecodedInstruction, handler func(*CPU, *DecodedInstruction) error) (*DecodedInstruction, error) {
+	inst.Handler = handler
+	inst.OpMode = (opcode >> 6) & 0b111 // Bit 2 is the direction, bits 1-0 the size.
+	inst.Size = sizeFromBits(inst.OpMode)
+	inst.DstReg = (opcode >> 9) & 0x7 // This is the Dn register for the operation
+	inst.SrcMode = (opcode >> 3) & 0x7
+	inst.SrcReg = opcode & 0x7
+	return inst, nil
+}
+
+// decodeImmediate handles the instructions in the 0000 group: ORI, ANDI, EORI,
+// SUBI, CMPI, the bit operations, and the CCR and SR forms of the logical operations.
+func (c *CPU) decodeImmediate(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	switch opcode {
+	case OPORItoCCR, OPANDItoCCR, OPEORItoCCR:
+		inst.Handler = (*CPU).opLogicalSR
+		inst.Size = SizeByte
+		inst.OpMode = opcode & 0xFF00
+		return inst, nil
+	case OPORItoSR, OPANDItoSR, OPEORItoSR:
+		inst.Handler = (*CPU).opLogicalSR
+		inst.Size = SizeWord
+		inst.OpMode = opcode & 0xFF00
+		return inst, nil
+	}
+
+	switch {
+	case (opcode>>8)&1 == 1 && (opcode>>3)&0x7 == ModeAddr:
+		if c.Model >= MC68060 {
+			inst.Handler = (*CPU).opUnimplementedInteger
+			return inst, nil
+		}
+		inst.Handler = (*CPU).opMOVEP
+		inst.OpMode = (opcode >> 6) & 0b11 // Bit 1 is the direction, bit 0 the size.
+		inst.Size = SizeWord
+		if inst.OpMode&1 != 0 {
+			inst.Size = SizeLong
+		}
+		inst.DstReg = (opcode >> 9) & 0x7
+		inst.SrcReg = opcode & 0x7
+		return inst, nil
+	case (opcode>>8)&1 == 1, opcode&0xFF00 == OPBTST:
+		return c.decodeBit(opcode, inst)
+	case (opcode>>6)&0b11 == 0b11:
+		return nil, fmt.Errorf("unknown or unimplemented instruction: %04X", opcode)
+	case opcode&0xFF00 == OPMOVES && c.Model >= MC68010:
+		inst.Handler = (*CPU).opMOVES
+		inst.Size = sizeFromBits(opcode >> 6)
+		inst.DstMode = (opcode >> 3) & 0x7
+		inst.DstReg = opcode & 0x7
+		if inst.DstMode <= ModeAddr || inst.DstMode == ModeOther && inst.DstReg != RegAbsShort && inst.DstReg != RegAbsLong {
+			return nil, fmt.Errorf("invalid MOVES operand in opcode %04X", opcode)
+		}
+		return inst, nil
+	}
+	switch opcode & 0xFF00 {
+	case OPORI:
+		inst.Handler = (*CPU).opORI
+	case OPANDI:
+		inst.Handler = (*CPU).opANDI
+	case OPEORI:
+		inst.Handler = (*CPU).opEORI
+	case OPSUBI:
+		inst.Handler = (*CPU).opSUBI
+	case OPCMPI:
+		inst.Handler = (*CPU).opCMPI
+	default:
+		return nil, fmt.Errorf("unknown or unimplemented instruction: %04X", opcode)
+	}
+	inst.Size = sizeFromBits(opcode >> 6)
+	inst.DstMode = (opcode >> 3) & 0x7
+	inst.DstReg = opcode & 0x7
+	return inst, nil
+}
+
+// decodeControl handles JMP, JSR, LEA and PEA, which only accept control
+// addressing modes.
+func (c *CPU) decodeControl(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	inst.SrcMode = (opcode >> 3) & 0x7
+	inst.SrcReg = opcode & 0x7
+	switch {
+	case opcode&0xFFC0 == OPJSR:
+		inst.Handler = (*CPU).opJSR
+	case opcode&0xFFC0 == OPJMP:
+		inst.Handler = (*CPU).opJMP
+	case opcode&0xFFC0 == OPPEA:
+		inst.Handler = (*CPU).opPEA
+	default:
+		inst.Handler = (*CPU).opLEA
+		inst.DstReg = (opcode >> 9) & 0x7
+	}
+
+	control := inst.SrcMode == ModeAddrInd || inst.SrcMode == ModeAddrDisp || inst.SrcMode == ModeAddrIndex ||
+		inst.SrcMode == ModeOther && inst.SrcReg <= RegPCIndex
+	if !control {
+		return nil, fmt.Errorf("unknown or unimplemented instruction: %04X", opcode)
+	}
+	return inst, nil
+}
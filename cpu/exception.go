@@ -0,0 +1,398 @@
+package cpu
+
+import "fmt"
+
+// Exception vector numbers. The handler for vector n is the long at VBR+n*4.
+const (
+	VectorResetSSP           = 0
+	VectorResetPC            = 1
+	VectorBusError           = 2
+	VectorAddressError       = 3
+	VectorIllegalInstruction = 4
+	VectorZeroDivide         = 5
+	VectorCHK                = 6
+	VectorTRAPV              = 7
+	VectorPrivilegeViolation = 8
+	VectorTrace              = 9
+	VectorLineA              = 10
+	VectorLineF              = 11
+	// VectorFormatError is taken by an RTE on the 68010 and later that finds
+	// a frame format it doesn't know.
+	VectorFormatError   = 14
+	VectorUninitialized = 15
+	VectorSpurious      = 24
+	// VectorTrap0 is the vector of TRAP #0; TRAP #n uses VectorTrap0+n.
+	VectorTrap0 = 32
+	// The FPU's exceptions, taken when FPCR enables them.
+	VectorFPBSUN  = 48
+	VectorFPINEX  = 49
+	VectorFPDZ    = 50
+	VectorFPUNFL  = 51
+	VectorFPOPERR = 52
+	VectorFPOVFL  = 53
+	VectorFPSNAN  = 54
+	// VectorUnimplementedInteger is taken by the integer instructions the
+	// 68060 leaves to software, such as MOVEP.
+	VectorUnimplementedInteger = 61
+	// VectorCount is the number of vectors in the table.
+	VectorCount = 256
+)
+
+// vectorNames describes the vectors with fixed meanings.
+var vectorNames = map[int]string{
+	VectorResetSSP:           "reset SSP",
+	VectorResetPC:            "reset PC",
+	VectorBusError:           "bus error",
+	VectorAddressError:       "address error",
+	VectorIllegalInstruction: "illegal instruction",
+	VectorZeroDivide:         "zero divide",
+	VectorCHK:                "CHK",
+	VectorTRAPV:              "TRAPV",
+	VectorPrivilegeViolation: "privilege violation",
+	VectorTrace:              "trace",
+	VectorLineA:              "line 1010 emulator",
+	VectorLineF:              "line 1111 emulator",
+	VectorFormatError:        "format error",
+	VectorUninitialized:      "uninitialized interrupt",
+	VectorSpurious:           "spurious interrupt",
+
+	VectorFPBSUN:  "FPU branch on unordered",
+	VectorFPINEX:  "FPU inexact result",
+	VectorFPDZ:    "FPU divide by zero",
+	VectorFPUNFL:  "FPU underflow",
+	VectorFPOPERR: "FPU operand error",
+	VectorFPOVFL:  "FPU overflow",
+	VectorFPSNAN:  "FPU signalling NaN",
+
+	VectorUnimplementedInteger: "unimplemented integer instruction",
+}
+
+// VectorName describes an exception vector, e.g. "zero divide" or "TRAP #3".
+func VectorName(vector int) string {
+	if name, ok := vectorNames[vector]; ok {
+		return name
+	}
+	switch {
+	case vector > VectorSpurious && vector < VectorTrap0:
+		return fmt.Sprintf("level %d autovector", vector-VectorSpurious)
+	case vector >= VectorTrap0 && vector < VectorTrap0+16:
+		return fmt.Sprintf("TRAP #%d", vector-VectorTrap0)
+	case vector >= 64 && vector < VectorCount:
+		return fmt.Sprintf("user vector %d", vector)
+	}
+	return fmt.Sprintf("reserved vector %d", vector)
+}
+
+// UnhandledExceptionError reports an exception whose vector holds zero. Real
+// code never puts a handler at address 0, which holds the reset SSP, so this
+// means the program installed no handler and the emulator stops instead of
+// running from address 0.
+type UnhandledExceptionError struct {
+	Vector int
+}
+
+// Error names the exception.
+func (e *UnhandledExceptionError) Error() string {
+	return fmt.Sprintf("unhandled %s exception (vector %d)", VectorName(e.Vector), e.Vector)
+}
+
+// handler returns the address of the handler for vector.
+func (c *CPU) handler(vector int) (uint32, error) {
+	addr := (c.VBR + uint32(vector)*4) & c.AddressMask
+	pc, err := c.Bus.Read32(addr)
+	if err != nil {
+		return 0, fmt.Errorf("vector %d at $%08X is outside memory", vector, addr)
+	}
+	if pc == 0 {
+		return 0, &UnhandledExceptionError{Vector: vector}
+	}
+	if _, err := c.Bus.Read16(pc & c.AddressMask); err != nil {
+		return 0, fmt.Errorf("handler $%08X for %s is outside memory", pc, VectorName(vector))
+	}
+	return pc, nil
+}
+
+// enterException switches to supervisor mode with tracing off and returns the
+// handler address. It returns the old SR so it can be stacked.
+func (c *CPU) enterException(vector int) (uint32, SR, error) {
+	pc, err := c.handler(vector)
+	if err != nil {
+		return 0, 0, err
+	}
+	sr := c.SR
+	c.setSR(sr&^SRT | SRS)
+	return pc, sr, nil
+}
+
+// stackFrame runs push, which stacks an exception frame, and turns a fault into
+// an error: a 68000 that faults while stacking a frame halts with a double
+// fault instead of taking another exception.
+func (c *CPU) stackFrame(vector int, push func()) (err error) {
+	sp := c.A[7]
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if _, ok := p.(busFault); !ok {
+			panic(p)
+		}
+		c.A[7] = sp
+		err = fmt.Errorf("supervisor stack $%08X is outside memory during %s", sp, VectorName(vector))
+	}()
+	push()
+	return nil
+}
+
+// Exception frame formats, in the top four bits of the format word the 68010
+// and later stack after the PC.
+const (
+	// FrameNormal is the four-word frame of most exceptions.
+	FrameNormal = 0x0
+	// FrameAddress adds the faulting address. The 68040 and 68060 stack it
+	// for address errors, and here for bus errors too.
+	FrameAddress = 0x2
+	// FrameBusError68010 is the 68010's 29-word bus and address error frame.
+	FrameBusError68010 = 0x8
+	// FrameShortBusError is the 68020's 16-word frame for a bus or address
+	// error at an instruction boundary.
+	FrameShortBusError = 0xA
+)
+
+// frameExtra is the number of bytes each frame format has beyond the SR, PC
+// and format word.
+var frameExtra = map[uint16]uint32{
+	FrameNormal:        0,
+	FrameAddress:       4,
+	FrameBusError68010: 50,
+	FrameShortBusError: 24,
+}
+
+// formatWord returns the format word for a frame of format for vector.
+func formatWord(format uint16, vector int) uint16 {
+	return format<<12 | uint16(vector*4)&0xFFF
+}
+
+// exception takes a group 1 or 2 exception: it enters supervisor mode, pushes
+// the return PC and the old SR on the supervisor stack, and continues at the
+// handler for vector. The 68010 and later push a format word first.
+func (c *CPU) exception(vector int, pc uint32) error {
+	handler, sr, err := c.enterException(vector)
+	if err != nil {
+		return err
+	}
+	err = c.stackFrame(vector, func() {
+		if c.Model >= MC68010 {
+			c.push16(formatWord(FrameNormal, vector))
+		}
+		c.push32(pc)
+		c.push16(uint16(sr))
+	})
+	if err != nil {
+		return err
+	}
+	c.PC = handler
+	c.Cycles += uint64(exceptionCycles(vector))
+	c.recordException(ExceptionRecord{Vector: vector, Address: c.instAddr, PC: pc, SR: sr, Handler: handler})
+	return nil
+}
+
+// groupZero takes a bus or address error. The 68000 stacks an extended frame:
+// PC, SR, the instruction register, the access address, and a word describing
+// the access (bit 4 set for reads, bit 3 set unless it was an instruction
+// fetch, and the function code in bits 2-0). The 68010 stacks its format $8
+// frame instead, with a special status word (bit 13 set for instruction
+// fetches, 12 for data reads, 8 for reads and the function code in bits 2-0)
+// and the access address, and the instruction register among its internal
+// state, which is otherwise left zero. The 68020 stacks format $A, with the
+// instruction register in pipe stage C and a special status word with bit 14
+// set for instruction fetches, 8 for data accesses and 6 for reads. Later
+// models stack format $2 with the access address.
+func (c *CPU) groupZero(vector int, f busFault, fetch bool, ir uint16) error {
+	fc := uint16(1) // User data
+	if fetch {
+		fc = 2
+	}
+	if c.SR.Supervisor() {
+		fc += 4
+	}
+
+	handler, sr, err := c.enterException(vector)
+	if err != nil {
+		return err
+	}
+	pc := c.PC
+	var push func()
+	switch {
+	case c.Model >= MC68040:
+		push = func() {
+			c.push32(f.addr)
+			c.push16(formatWord(FrameAddress, vector))
+			c.push32(pc)
+			c.push16(uint16(sr))
+		}
+	case c.Model >= MC68020:
+		ssw := fc
+		switch {
+		case fetch:
+			ssw |= 1<<14 | 1<<6
+		case !f.write:
+			ssw |= 1<<8 | 1<<6
+		default:
+			ssw |= 1 << 8
+		}
+		push = func() {
+			c.push32(0) // Internal registers
+			c.push32(0) // Data output buffer
+			c.push32(0) // Internal registers
+			c.push32(f.addr)
+			c.push16(0)  // Pipe stage B
+			c.push16(ir) // Pipe stage C
+			c.push16(ssw)
+			c.push16(0) // Internal register
+			c.push16(formatWord(FrameShortBusError, vector))
+			c.push32(pc)
+			c.push16(uint16(sr))
+		}
+	case c.Model >= MC68010:
+		ssw := fc
+		switch {
+		case fetch:
+			ssw |= 1<<13 | 1<<8
+		case !f.write:
+			ssw |= 1<<12 | 1<<8
+		}
+		push = func() {
+			for range 16 {
+				c.push16(0) // Internal state
+			}
+			c.push16(ir) // Instruction input buffer
+			for range 5 {
+				c.push16(0) // Data buffers and unused words
+			}
+			c.push32(f.addr)
+			c.push16(ssw)
+			c.push16(formatWord(FrameBusError68010, vector))
+			c.push32(pc)
+			c.push16(uint16(sr))
+		}
+	default:
+		status := fc
+		if !f.write {
+			status |= 1 << 4
+		}
+		if !fetch {
+			status |= 1 << 3
+		}
+		push = func() {
+			c.push32(pc)
+			c.push16(uint16(sr))
+			c.push16(ir)
+			c.push32(f.addr)
+			c.push16(status)
+		}
+	}
+	if err := c.stackFrame(vector, push); err != nil {
+		return err
+	}
+	c.PC = handler
+	c.Cycles += uint64(exceptionCycles(vector))
+	c.recordException(ExceptionRecord{
+		Vector:       vector,
+		Address:      c.instAddr,
+		PC:           pc,
+		SR:           sr,
+		Handler:      handler,
+		FaultAddress: f.addr,
+	})
+	return nil
+}
+
+// busError takes a bus error for an access outside memory. Any failure to take
+// it is returned with the access described.
+func (c *CPU) busError(f busFault, fetch bool, ir uint16) error {
+	if err := c.groupZero(VectorBusError, f, fetch, ir); err != nil {
+		return fmt.Errorf("bus error %s $%08X: %w", accessName(f, fetch), f.addr, err)
+	}
+	return nil
+}
+
+// addressError takes an address error for a word or long access, or an
+// instruction fetch, at an odd address. Any failure to take it is returned with
+// the access described.
+func (c *CPU) addressError(f busFault, fetch bool, ir uint16) error {
+	if err := c.groupZero(VectorAddressError, f, fetch, ir); err != nil {
+		return fmt.Errorf("address error %s $%08X: %w", accessName(f, fetch), f.addr, err)
+	}
+	return nil
+}
+
+// accessName describes the kind of access that faulted.
+func accessName(f busFault, fetch bool) string {
+	switch {
+	case fetch:
+		return "fetching"
+	case f.write:
+		return "writing"
+	}
+	return "reading"
+}
+
+// privilegeViolation raises the privilege violation exception for the current
+// instruction. The stacked PC points at the instruction itself.
+func (c *CPU) privilegeViolation() error {
+	return c.exception(VectorPrivilegeViolation, c.instAddr)
+}
+
+// Reset performs the 68000 reset sequence: supervisor mode with tracing off and
+// interrupts masked, VBR cleared, and the SSP and PC loaded from vectors 0 and 1.
+// It also leaves the stopped state.
+func (c *CPU) Reset() error {
+	ssp, err := c.Bus.Read32(VectorResetSSP * 4)
+	if err != nil {
+		return fmt.Errorf("cannot read the reset vectors: %w", err)
+	}
+	pc, err := c.Bus.Read32(VectorResetPC * 4)
+	if err != nil {
+		return fmt.Errorf("cannot read the reset vectors: %w", err)
+	}
+	c.VBR = 0
+	c.SR = SRS | SRI
+	c.Stopped = false
+	c.A[7] = ssp
+	c.PC = pc
+	return nil
+}
+
+// opILLEGAL handles ILLEGAL, which always takes the illegal instruction exception.
+func (c *CPU) opILLEGAL(inst *DecodedInstruction) error {
+	return c.exception(VectorIllegalInstruction, c.instAddr)
+}
+
+// opTRAPV handles TRAPV, which takes the TRAPV exception if V is set.
+func (c *CPU) opTRAPV(inst *DecodedInstruction) error {
+	if c.SR&SRV == 0 {
+		return nil
+	}
+	return c.exception(VectorTRAPV, c.PC)
+}
+
+// opCHK handles CHK <ea>,Dn. It takes the CHK exception if the low word of Dn
+// is negative (setting N) or greater than the operand (clearing N).
+// Format: 0100 <Dn> 110 <ea>
+func (c *CPU) opCHK(inst *DecodedInstruction) error {
+	bound, err := c.GetOperand(inst.SrcMode, inst.SrcReg, SizeWord)
+	if err != nil {
+		return fmt.Errorf("CHK failed to get bound: %w", err)
+	}
+	v := int16(c.D[inst.DstReg])
+	switch {
+	case v < 0:
+		c.SR |= SRN
+	case v > int16(bound):
+		c.SR &^= SRN
+	default:
+		return nil
+	}
+	return c.exception(VectorCHK, c.PC)
+}
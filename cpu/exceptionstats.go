@@ -0,0 +1,96 @@
+package cpu
+
+import "fmt"
+
+// ExceptionHistory is the number of recent exceptions the CPU remembers.
+const ExceptionHistory = 16
+
+// ExceptionRecord describes an exception the CPU took.
+type ExceptionRecord struct {
+	// Vector is the exception vector number.
+	Vector int
+	// Address is the instruction that caused the exception, or for an
+	// interrupt the one it interrupted.
+	Address uint32
+	// PC and SR are the values stacked in the frame.
+	PC uint32
+	SR SR
+	// Handler is where execution continued, or 0 for TRAP #15, which halts.
+	Handler uint32
+	// FaultAddress is the access address of a bus or address error.
+	FaultAddress uint32
+	// Instruction is the number of instructions executed before it.
+	Instruction uint64
+}
+
+// String describes the exception on one line.
+func (r ExceptionRecord) String() string {
+	s := fmt.Sprintf("%s (vector %d) at $%08X after %d instructions: stacked PC=$%08X SR=$%04X",
+		VectorName(r.Vector), r.Vector, r.Address, r.Instruction, r.PC, uint16(r.SR))
+	if r.Vector == VectorBusError || r.Vector == VectorAddressError {
+		s += fmt.Sprintf(" access=$%08X", r.FaultAddress)
+	}
+	if r.Handler != 0 {
+		s += fmt.Sprintf(", handler $%08X", r.Handler)
+	}
+	return s
+}
+
+// exceptionStats counts exceptions by vector and keeps the most recent ones.
+type exceptionStats struct {
+	counts [VectorCount]uint64
+	recent [ExceptionHistory]ExceptionRecord
+	// taken is the number of records written to recent, which is used as a ring.
+	taken uint64
+}
+
+// recordException counts an exception and adds it to the history.
+func (c *CPU) recordException(r ExceptionRecord) {
+	c.Exceptions++
+	r.Instruction = c.Instructions
+	s := &c.exceptionStats
+	if r.Vector >= 0 && r.Vector < VectorCount {
+		s.counts[r.Vector]++
+	}
+	s.recent[s.taken%ExceptionHistory] = r
+	s.taken++
+}
+
+// ExceptionCounts returns the number of exceptions taken through each vector,
+// leaving out vectors that were never used.
+func (c *CPU) ExceptionCounts() map[int]uint64 {
+	counts := make(map[int]uint64)
+	for v, n := range c.exceptionStats.counts {
+		if n > 0 {
+			counts[v] = n
+		}
+	}
+	return counts
+}
+
+// RecentExceptions returns up to ExceptionHistory of the latest exceptions,
+// oldest first.
+func (c *CPU) RecentExceptions() []ExceptionRecord {
+	s := &c.exceptionStats
+	n := min(s.taken, ExceptionHistory)
+	list := make([]ExceptionRecord, 0, n)
+	for i := s.taken - n; i < s.taken; i++ {
+		list = append(list, s.recent[i%ExceptionHistory])
+	}
+	return list
+}
+
+// LastException returns the most recent exception, and false if there was none.
+func (c *CPU) LastException() (ExceptionRecord, bool) {
+	s := &c.exceptionStats
+	if s.taken == 0 {
+		return ExceptionRecord{}, false
+	}
+	return s.recent[(s.taken-1)%ExceptionHistory], true
+}
+
+// ResetExceptionStats clears the per-vector counts and the history. The
+// Exceptions total is left alone.
+func (c *CPU) ResetExceptionStats() {
+	c.exceptionStats = exceptionStats{}
+}
@@ -2,30 +2,143 @@ package cpu
 
 import "fmt"
 
-// Execute fetches, decodes, and executes a single instruction.
-func (c *CPU) Execute() error {
+// ExecError reports an instruction that could not be decoded or executed.
+type ExecError struct {
+	// Addr is the address of the faulting instruction.
+	Addr uint32
+	// Opcode is its first word.
+	Opcode uint16
+	// Err is the underlying problem.
+	Err error
+}
+
+// Error formats the failure with the instruction's address and opcode.
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("opcode %04X at $%08X: %v", e.Opcode, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// Execute takes any pending interrupt, then fetches, decodes and executes a
+// single instruction, consuming its extension words and counting its cycles.
+// If the instruction fails, PC is left pointing at it and the error is an
+// *ExecError; a watchpoint that pauses execution returns a *WatchpointHit.
+func (c *CPU) Execute() (err error) {
 	if !c.Running {
 		return nil
 	}
 
+	// Interrupts are recognised between instructions.
+	if c.irq.pending.Load() != 0 {
+		if _, err := c.interrupt(); err != nil {
+			return &ExecError{Addr: c.PC, Err: fmt.Errorf("interrupt failed: %w", err)}
+		}
+	}
+	if c.Stopped {
+		// Waiting for an interrupt; only the clock advances.
+		c.Cycles += 4
+		return nil
+	}
+
 	// Fetch
-	opcode := c.ReadU16(c.PC)
+	addr := c.PC
+	c.instAddr = addr
+	if c.StrictAlignment && addr&1 != 0 {
+		if err := c.addressError(busFault{addr: addr, odd: true}, true, 0); err != nil {
+			c.PC = addr
+			return &ExecError{Addr: addr, Err: err}
+		}
+		return nil
+	}
+	opcode, ferr := c.Bus.Read16(addr & c.AddressMask)
+	if ferr != nil {
+		if err := c.busError(busFault{addr: addr & c.AddressMask}, true, 0); err != nil {
+			c.PC = addr
+			return &ExecError{Addr: addr, Err: err}
+		}
+		return nil
+	}
 	c.PC += 2
+	if c.OnBeforeExecute != nil {
+		c.OnBeforeExecute(addr, opcode)
+	}
 
-	// Decode
-	inst, err := c.Decode(opcode)
-	if err != nil {
-		return fmt.Errorf("decode failed: %w", err)
+	// Only the instruction's own accesses are checked for alignment, so host
+	// code may still use the accessors on odd addresses.
+	c.checkAlign = c.StrictAlignment
+	c.watching = len(c.watches) > 0
+	defer func() {
+		// Runs last, once any fault has been taken.
+		if err == nil && c.watchHit != nil {
+			err = c.watchHit
+		}
+		c.watchHit = nil
+	}()
+	defer func() {
+		c.checkAlign = false
+		c.watching = false
+		p := recover()
+		if p == nil {
+			return
+		}
+		f, ok := p.(busFault)
+		if !ok {
+			panic(p)
+		}
+		take := c.busError
+		if f.odd {
+			take = c.addressError
+		}
+		if ferr := take(f, false, opcode); ferr != nil {
+			c.PC = addr
+			err = &ExecError{Addr: addr, Opcode: opcode, Err: ferr}
+		}
+	}()
+
+	// Decode, unless this address was decoded before and hasn't changed.
+	inst, ok := c.ICache.Lookup(addr, opcode)
+	if !ok {
+		var err error
+		inst, err = c.Decode(opcode)
+		switch {
+		case err == nil:
+			c.ICache.Store(addr, opcode, inst)
+		case c.Unimplemented != UnimplementedError:
+			// Not cached, so a policy change takes effect at once.
+			inst = &DecodedInstruction{Handler: (*CPU).opUnimplemented, OpMode: opcode}
+		default:
+			c.PC = addr
+			return &ExecError{Addr: addr, Opcode: opcode, Err: fmt.Errorf("decode failed: %w", err)}
+		}
 	}
 
 	if inst.Handler == nil {
-		return fmt.Errorf("no handler for opcode %04X", opcode)
+		c.PC = addr
+		return &ExecError{Addr: addr, Opcode: opcode, Err: fmt.Errorf("no handler")}
 	}
 
 	// Execute
+	tracing, taken := c.SR.Trace(), c.exceptionStats.taken
 	err = inst.Handler(c, inst)
 	if err != nil {
-		return fmt.Errorf("execution failed for opcode %04X: %w", opcode, err)
+		c.PC = addr
+		return &ExecError{Addr: addr, Opcode: opcode, Err: fmt.Errorf("execution failed: %w", err)}
+	}
+	c.Instructions++
+	if !c.aborted(taken) {
+		c.Cycles += uint64(inst.Cycles)
+	}
+	if c.OnAfterExecute != nil {
+		c.OnAfterExecute(addr, inst)
+	}
+
+	if tracing {
+		if err := c.trace(taken); err != nil {
+			return &ExecError{Addr: addr, Opcode: opcode, Err: fmt.Errorf("trace failed: %w", err)}
+		}
 	}
 
 	return nil
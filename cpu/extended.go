@@ -0,0 +1,198 @@
+package cpu
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// ExtendedWords converts f to the FPU's 96-bit extended precision format, as
+// it is stored in memory: the sign and 15-bit exponent, a word of padding,
+// and the 64-bit mantissa with its explicit integer bit.
+func ExtendedWords(f float64) [6]uint16 {
+	b := math.Float64bits(f)
+	sign := uint16(b>>63) << 15
+	exp := int(b>>52) & 0x7FF
+	frac := b & (1<<52 - 1)
+
+	var e int
+	var m uint64
+	switch {
+	case exp == 0 && frac == 0:
+		// Zero
+	case exp == 0x7FF:
+		e, m = 0x7FFF, frac<<11 // Infinity or NaN
+	case exp == 0:
+		// Denormals are normalised, as the wider exponent can hold them.
+		n := bits.LeadingZeros64(frac)
+		e, m = 16383-1011-n, frac<<n
+	default:
+		e, m = exp-1023+16383, 1<<63|frac<<11
+	}
+	return [6]uint16{sign | uint16(e), 0, uint16(m >> 48), uint16(m >> 32), uint16(m >> 16), uint16(m)}
+}
+
+// ExtendedFloat converts an extended precision value stored as by
+// ExtendedWords to the nearest float64.
+func ExtendedFloat(w [6]uint16) float64 {
+	sign := 1.0
+	if w[0]&0x8000 != 0 {
+		sign = -1
+	}
+	e := int(w[0] & 0x7FFF)
+	m := uint64(w[2])<<48 | uint64(w[3])<<32 | uint64(w[4])<<16 | uint64(w[5])
+	if e == 0x7FFF {
+		if m<<1 != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(float64(m), e-16383-63)
+}
+
+// Extended is an extended precision real as the FPU's registers hold it: a
+// sign, a 15-bit biased exponent and a 64-bit mantissa whose top bit is the
+// explicit integer bit. An exponent of $7FFF marks an infinity, with a zero
+// fraction, or a NaN. Values with a zero exponent are denormalised.
+type Extended struct {
+	Sign bool
+	Exp  uint16
+	Mant uint64
+}
+
+// extendedBias is the exponent bias of the extended precision format.
+const extendedBias = 16383
+
+// extendedNaN is the NaN the FPU produces for an invalid operation, and what
+// its data registers hold after a reset.
+var extendedNaN = Extended{Exp: 0x7FFF, Mant: ^uint64(0)}
+
+// ExtendedFromWords converts the 96-bit memory format of ExtendedWords to
+// an Extended.
+func ExtendedFromWords(w [6]uint16) Extended {
+	return Extended{
+		Sign: w[0]&0x8000 != 0,
+		Exp:  w[0] & 0x7FFF,
+		Mant: uint64(w[2])<<48 | uint64(w[3])<<32 | uint64(w[4])<<16 | uint64(w[5]),
+	}
+}
+
+// Words returns x in the 96-bit memory format.
+func (x Extended) Words() [6]uint16 {
+	w := [6]uint16{x.Exp, 0, uint16(x.Mant >> 48), uint16(x.Mant >> 32), uint16(x.Mant >> 16), uint16(x.Mant)}
+	if x.Sign {
+		w[0] |= 0x8000
+	}
+	return w
+}
+
+// Float64 returns the nearest float64 to x.
+func (x Extended) Float64() float64 {
+	return ExtendedFloat(x.Words())
+}
+
+// ExtendedFromFloat64 converts f to an Extended. Every float64 is exact.
+func ExtendedFromFloat64(f float64) Extended {
+	return extendedFromIEEE(math.Float64bits(f), 11, 52)
+}
+
+// IsNaN reports whether x is a NaN.
+func (x Extended) IsNaN() bool {
+	return x.Exp == 0x7FFF && x.Mant<<1 != 0
+}
+
+// IsInf reports whether x is an infinity.
+func (x Extended) IsInf() bool {
+	return x.Exp == 0x7FFF && x.Mant<<1 == 0
+}
+
+// IsZero reports whether x is a zero of either sign.
+func (x Extended) IsZero() bool {
+	return x.Exp != 0x7FFF && x.Mant == 0
+}
+
+// isSignalling reports whether x is a signalling NaN, whose most significant
+// fraction bit is clear.
+func (x Extended) isSignalling() bool {
+	return x.IsNaN() && x.Mant&(1<<62) == 0
+}
+
+// quiet returns the NaN x with its quiet bit set.
+func (x Extended) quiet() Extended {
+	x.Mant |= 1 << 62
+	return x
+}
+
+// String formats x as a decimal, to the precision of a float64.
+func (x Extended) String() string {
+	switch {
+	case x.IsNaN():
+		return "NaN"
+	case x.IsInf() && x.Sign:
+		return "-Inf"
+	case x.IsInf():
+		return "+Inf"
+	}
+	return x.bigFloat().Text('g', 20)
+}
+
+// bigFloat returns the finite or infinite x exactly.
+func (x Extended) bigFloat() *big.Float {
+	if x.IsInf() {
+		return new(big.Float).SetInf(x.Sign)
+	}
+	f := new(big.Float).SetUint64(x.Mant)
+	e := int(x.Exp)
+	if e == 0 {
+		e = 1 // Denormals share the smallest exponent.
+	}
+	f.SetMantExp(f, e-extendedBias-63)
+	if x.Sign {
+		f.Neg(f)
+	}
+	return f
+}
+
+// extendedFromBig converts f, which must be representable, to an Extended.
+func extendedFromBig(f *big.Float) Extended {
+	x := Extended{Sign: f.Signbit()}
+	switch {
+	case f.IsInf():
+		x.Exp = 0x7FFF
+		return x
+	case f.Sign() == 0:
+		return x
+	}
+	m := new(big.Float)
+	e := f.MantExp(m) - 1 + extendedBias
+	mant, _ := m.Abs(m).SetMantExp(m, 64).Uint64()
+	if e < 1 {
+		mant >>= uint(1 - e)
+		e = 0
+	}
+	x.Exp, x.Mant = uint16(e), mant
+	return x
+}
+
+// extendedFromIEEE converts an IEEE single or double, with expBits of
+// exponent and fracBits of fraction, to an Extended. NaNs keep their
+// fraction, so a signalling NaN stays one.
+func extendedFromIEEE(b uint64, expBits, fracBits uint) Extended {
+	exp := int(b>>fracBits) & (1<<expBits - 1)
+	frac := b & (1<<fracBits - 1)
+	bias := 1<<(expBits-1) - 1
+	x := Extended{Sign: b>>(expBits+fracBits)&1 != 0}
+	switch {
+	case exp == 0 && frac == 0:
+	case exp == 1<<expBits-1:
+		x.Exp, x.Mant = 0x7FFF, frac<<(63-fracBits)
+	case exp == 0:
+		n := bits.LeadingZeros64(frac)
+		x.Exp = uint16(64 - bias - int(fracBits) - n + extendedBias)
+		x.Mant = frac << n
+	default:
+		x.Exp = uint16(exp - bias + extendedBias)
+		x.Mant = 1<<63 | frac<<(63-fracBits)
+	}
+	return x
+}
@@ -1,15 +1,184 @@
 package cpu
 
+import "fmt"
+
+// Condition codes, as found in bits 11-8 of Bcc, DBcc and Scc.
+const (
+	CondT  = 0x0 // True
+	CondF  = 0x1 // False
+	CondHI = 0x2 // Higher
+	CondLS = 0x3 // Lower or same
+	CondCC = 0x4 // Carry clear
+	CondCS = 0x5 // Carry set
+	CondNE = 0x6 // Not equal
+	CondEQ = 0x7 // Equal
+	CondVC = 0x8 // Overflow clear
+	CondVS = 0x9 // Overflow set
+	CondPL = 0xA // Plus
+	CondMI = 0xB // Minus
+	CondGE = 0xC // Greater or equal
+	CondLT = 0xD // Less than
+	CondGT = 0xE // Greater than
+	CondLE = 0xF // Less or equal
+)
+
+// TestCondition evaluates a 4-bit condition code against the status register.
+func (c *CPU) TestCondition(cond uint16) bool {
+	carry := c.SR&SRC != 0
+	overflow := c.SR&SRV != 0
+	zero := c.SR&SRZ != 0
+	negative := c.SR&SRN != 0
+
+	switch cond & 0xF {
+	case CondT:
+		return true
+	case CondF:
+		return false
+	case CondHI:
+		return !carry && !zero
+	case CondLS:
+		return carry || zero
+	case CondCC:
+		return !carry
+	case CondCS:
+		return carry
+	case CondNE:
+		return !zero
+	case CondEQ:
+		return zero
+	case CondVC:
+		return !overflow
+	case CondVS:
+		return overflow
+	case CondPL:
+		return !negative
+	case CondMI:
+		return negative
+	case CondGE:
+		return negative == overflow
+	case CondLT:
+		return negative != overflow
+	case CondGT:
+		return !zero && negative == overflow
+	default: // CondLE
+		return zero || negative != overflow
+	}
+}
+
+// branchTarget returns the destination of a branch and the address of the next
+// instruction. The 8-bit displacement was stored in SrcReg by the decoder; zero
+// means a 16-bit displacement follows the opcode. Both are relative to the
+// address after the opcode.
+func (c *CPU) branchTarget(inst *DecodedInstruction) (target, next uint32) {
+	base := c.PC
+	if inst.SrcReg == 0 {
+		disp := signExtend16(c.ReadU16(base))
+		return uint32(int32(base) + disp), base + 2
+	}
+	return uint32(int32(base) + int32(int8(inst.SrcReg))), base
+}
+
+// opBcc handles BRA and the conditional branches.
+// Format: 0110 <condition> <8-bit displacement>
+func (c *CPU) opBcc(inst *DecodedInstruction) error {
+	target, next := c.branchTarget(inst)
+	taken := c.TestCondition(inst.OpMode)
+	if taken {
+		c.PC = target
+	} else {
+		c.PC = next
+	}
+	c.Cycles += uint64(branchCycles(taken, inst.SrcReg != 0))
+	return nil
+}
+
+// opBSR handles the BSR (Branch to Subroutine) instruction.
+// Format: 0110 0001 <8-bit displacement>
+func (c *CPU) opBSR(inst *DecodedInstruction) error {
+	target, next := c.branchTarget(inst)
+	c.push32(next)
+	c.PC = target
+	return nil
+}
+
+// opDBcc handles the DBcc (Test Condition, Decrement and Branch) instructions.
+// If the condition is false, the low word of Dn is decremented, and the branch
+// is taken unless it reached -1.
+// Format: 0101 <condition> 11001 <Dn> <16-bit displacement>
+func (c *CPU) opDBcc(inst *DecodedInstruction) error {
+	disp := signExtend16(c.ReadU16(c.PC))
+	target := uint32(int32(c.PC) + disp)
+	c.PC += 2
+
+	if c.TestCondition(inst.OpMode) {
+		c.Cycles += 2
+		return nil
+	}
+	count := uint16(c.D[inst.DstReg]) - 1
+	c.D[inst.DstReg] = c.D[inst.DstReg]&0xFFFF0000 | uint32(count)
+	if count != 0xFFFF {
+		c.PC = target
+	} else {
+		c.Cycles += 4
+	}
+	return nil
+}
+
+// opScc handles the Scc (Set According to Condition) instructions. The byte
+// operand is set to all ones if the condition is true and cleared otherwise.
+// Format: 0101 <condition> 11 <ea>
+func (c *CPU) opScc(inst *DecodedInstruction) error {
+	var value uint32
+	if c.TestCondition(inst.OpMode) {
+		value = 0xFF
+		if inst.DstMode == ModeData {
+			c.Cycles += 2
+		}
+	}
+	err := c.PutOperand(inst.DstMode, inst.DstReg, SizeByte, value)
+	if err != nil {
+		return fmt.Errorf("Scc failed to put result: %w", err)
+	}
+	return nil
+}
+
+// opJMP handles the JMP instruction.
+// Format: 0100 1110 11 <ea>
+func (c *CPU) opJMP(inst *DecodedInstruction) error {
+	addr, err := c.effectiveAddress(inst.SrcMode, inst.SrcReg)
+	if err != nil {
+		return fmt.Errorf("JMP failed: %w", err)
+	}
+	c.PC = addr
+	return nil
+}
+
+// opJSR handles the JSR (Jump to Subroutine) instruction. The return address
+// is that of the instruction after JSR and its extension words.
+// Format: 0100 1110 10 <ea>
+func (c *CPU) opJSR(inst *DecodedInstruction) error {
+	addr, err := c.effectiveAddress(inst.SrcMode, inst.SrcReg)
+	if err != nil {
+		return fmt.Errorf("JSR failed: %w", err)
+	}
+	c.push32(c.PC)
+	c.PC = addr
+	return nil
+}
+
 // opRTS handles the RTS (Return from Subroutine) instruction.
 // Format: 0100 1110 0111 0101 (4E75)
 func (c *CPU) opRTS(inst *DecodedInstruction) error {
-	// Get the current stack pointer (A7).
-	sp := c.A[7]
-	// Read the return address (a long word) from the stack.
-	returnAddr := c.ReadU32(sp)
-	// Pop the address off the stack by incrementing the stack pointer.
-	c.A[7] += 4
-	// Set the Program Counter to the return address.
-	c.PC = returnAddr
+	c.PC = c.pop32()
+	return nil
+}
+
+// opRTR handles the RTR (Return and Restore Condition Codes) instruction.
+// The system byte of SR is not affected.
+// Format: 0100 1110 0111 0111 (4E77)
+func (c *CPU) opRTR(inst *DecodedInstruction) error {
+	ccr := SR(c.pop16()) & 0x1F
+	c.SR = c.SR&0xFF00 | ccr
+	c.PC = c.pop32()
 	return nil
 }
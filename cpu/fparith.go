@@ -0,0 +1,457 @@
+package cpu
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// FPSR exception status bits, which are also the exception enable bits of
+// FPCR. Each operation sets them afresh.
+const (
+	FPExcBSUN  = 0x8000 // Branch or set on unordered
+	FPExcSNAN  = 0x4000 // Signalling NaN
+	FPExcOPERR = 0x2000 // Operand error
+	FPExcOVFL  = 0x1000 // Overflow
+	FPExcUNFL  = 0x0800 // Underflow
+	FPExcDZ    = 0x0400 // Divide by zero
+	FPExcINEX2 = 0x0200 // Inexact operation
+	FPExcINEX1 = 0x0100 // Inexact decimal input
+)
+
+// FPSR condition code bits.
+const (
+	FPCCN   = 0x08000000 // Negative
+	FPCCZ   = 0x04000000 // Zero
+	FPCCI   = 0x02000000 // Infinity
+	FPCCNaN = 0x01000000 // Not a number
+)
+
+// FPU rounding modes, in the RND field of FPCR.
+const (
+	FPRoundNearest = iota
+	FPRoundZero
+	FPRoundMinus
+	FPRoundPlus
+)
+
+// fpPrecision describes what results are rounded to: the bits of mantissa,
+// counting the integer bit, and the exponent range of normalised values.
+// Smaller results are denormalised, keeping the smallest exponent.
+type fpPrecision struct {
+	bits           int
+	minExp, maxExp int
+}
+
+var (
+	precExtended = fpPrecision{64, -16382, 16383}
+	precDouble   = fpPrecision{53, -1022, 1023}
+	precSingle   = fpPrecision{24, -126, 127}
+)
+
+// workPrec is the precision operations are carried out at before rounding.
+// It is well beyond 64 bits, so rounding to odd there and then to the
+// destination gives the correctly rounded result.
+const workPrec = 128
+
+// toOdd makes r, truncated at workPrec with accuracy acc, a rounded-to-odd
+// result: an inexact value gets its lowest bit set, so the final rounding
+// still sees that bits were lost.
+func toOdd(r *big.Float, acc big.Accuracy) *big.Float {
+	if acc == big.Exact || r.IsInf() || r.Sign() == 0 {
+		return r
+	}
+	m := new(big.Float)
+	e := r.MantExp(m)
+	i, _ := m.SetMantExp(m, workPrec).Int(nil)
+	neg := i.Sign() < 0
+	i.Abs(i).SetBit(i, 0, 1)
+	odd := new(big.Float).SetInt(i)
+	odd.SetMantExp(odd, e-workPrec)
+	if neg {
+		odd.Neg(odd)
+	}
+	return odd
+}
+
+// work returns a float to compute an operation's result in, truncating at
+// workPrec.
+func work() *big.Float {
+	return new(big.Float).SetPrec(workPrec).SetMode(big.ToZero)
+}
+
+// roundInt rounds s to an integer in the rounding mode, reporting whether
+// it wasn't one already.
+func roundInt(s *big.Float, mode uint32) (*big.Int, bool) {
+	i, acc := s.Int(nil)
+	if acc == big.Exact {
+		return i, false
+	}
+	up := false // Away from zero
+	switch mode {
+	case FPRoundNearest:
+		frac := new(big.Float).SetPrec(s.Prec()+64).Sub(s, new(big.Float).SetInt(i))
+		frac.Abs(frac)
+		switch frac.Cmp(big.NewFloat(0.5)) {
+		case 1:
+			up = true
+		case 0:
+			up = i.Bit(0) != 0
+		}
+	case FPRoundMinus:
+		up = s.Sign() < 0
+	case FPRoundPlus:
+		up = s.Sign() > 0
+	}
+	if up {
+		if s.Sign() < 0 {
+			i.Sub(i, big.NewInt(1))
+		} else {
+			i.Add(i, big.NewInt(1))
+		}
+	}
+	return i, true
+}
+
+// round rounds v, exact or rounded to odd, to the precision in the rounding
+// mode, and returns the exception status bits it raised. Results too large
+// become an infinity or the largest value, as the mode decides, and tiny
+// ones are denormalised.
+func (p fpPrecision) round(v *big.Float, mode uint32) (*big.Float, uint32) {
+	if v.IsInf() || v.Sign() == 0 {
+		return v, 0
+	}
+	e := v.MantExp(nil) - 1
+	q := max(e-p.bits+1, p.minExp-p.bits+1)
+	s := new(big.Float).SetMantExp(v, -q)
+	i, inexact := roundInt(s, mode)
+	r := new(big.Float).SetInt(i)
+	r.SetMantExp(r, q)
+	if i.Sign() == 0 && v.Signbit() {
+		r.Neg(r)
+	}
+
+	var exc uint32
+	if inexact {
+		exc |= FPExcINEX2
+		if e < p.minExp {
+			exc |= FPExcUNFL
+		}
+	}
+	if r.Sign() != 0 && r.MantExp(nil)-1 > p.maxExp {
+		exc |= FPExcOVFL | FPExcINEX2
+		neg := v.Signbit()
+		if mode == FPRoundZero || mode == FPRoundMinus && !neg || mode == FPRoundPlus && neg {
+			// The largest finite value
+			r.SetInt(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(p.bits)), big.NewInt(1)))
+			r.SetMantExp(r, p.maxExp-p.bits+1)
+			if neg {
+				r.Neg(r)
+			}
+		} else {
+			r.SetInf(neg)
+		}
+	}
+	return r, exc
+}
+
+// ieeeBits encodes f, which the precision p can represent, as an IEEE single
+// or double with expBits of exponent and fracBits of fraction.
+func ieeeBits(f *big.Float, expBits, fracBits uint) uint64 {
+	var b uint64
+	if f.Signbit() {
+		b = 1 << (expBits + fracBits)
+	}
+	bias := 1<<(expBits-1) - 1
+	switch {
+	case f.IsInf():
+		return b | (1<<expBits-1)<<fracBits
+	case f.Sign() == 0:
+		return b
+	}
+	m := new(big.Float)
+	e := f.MantExp(m) - 1 + bias
+	mant, _ := m.Abs(m).SetMantExp(m, int(fracBits)+1).Uint64()
+	if e < 1 {
+		return b | mant>>uint(1-e)
+	}
+	return b | uint64(e)<<fracBits | mant&(1<<fracBits-1)
+}
+
+// ieeeNaN encodes the NaN x as an IEEE single or double, keeping the top of
+// its fraction.
+func ieeeNaN(x Extended, expBits, fracBits uint) uint64 {
+	var b uint64
+	if x.Sign {
+		b = 1 << (expBits + fracBits)
+	}
+	return b | (1<<expBits-1)<<fracBits | x.Mant<<1>>(64-fracBits)
+}
+
+// fpResult is an operation's result before it is rounded: a NaN, or an exact
+// or rounded-to-odd value.
+type fpResult struct {
+	nan   bool
+	value Extended // The NaN
+	v     *big.Float
+	exc   uint32
+}
+
+// nanResult propagates a NaN operand: the destination's if both are NaNs,
+// quietened. A signalling NaN raises SNAN.
+func nanResult(dst, src Extended) (fpResult, bool) {
+	r := fpResult{nan: true}
+	if dst.isSignalling() || src.isSignalling() {
+		r.exc |= FPExcSNAN
+	}
+	switch {
+	case dst.IsNaN():
+		r.value = dst.quiet()
+	case src.IsNaN():
+		r.value = src.quiet()
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+// operandError is the result of an invalid operation.
+func operandError() fpResult {
+	return fpResult{nan: true, value: extendedNaN, exc: FPExcOPERR}
+}
+
+// value is an exact or rounded-to-odd result.
+func value(v *big.Float, acc big.Accuracy) fpResult {
+	return fpResult{v: toOdd(v, acc)}
+}
+
+// fpAdd adds or subtracts src and dst. Values of opposite sign that cancel
+// out give +0, or -0 when rounding toward minus infinity.
+func fpAdd(dst, src Extended, sub bool, mode uint32) fpResult {
+	if r, ok := nanResult(dst, src); ok {
+		return r
+	}
+	if sub {
+		src.Sign = !src.Sign
+	}
+	if dst.IsInf() && src.IsInf() && dst.Sign != src.Sign {
+		return operandError()
+	}
+	r := work()
+	r.Add(dst.bigFloat(), src.bigFloat())
+	if r.Sign() == 0 {
+		neg := dst.Sign && src.Sign || dst.Sign != src.Sign && mode == FPRoundMinus
+		if r.Signbit() != neg {
+			r.Neg(r)
+		}
+	}
+	return value(r, r.Acc())
+}
+
+// fpMul multiplies dst by src.
+func fpMul(dst, src Extended) fpResult {
+	if r, ok := nanResult(dst, src); ok {
+		return r
+	}
+	if dst.IsInf() && src.IsZero() || dst.IsZero() && src.IsInf() {
+		return operandError()
+	}
+	r := work()
+	r.Mul(dst.bigFloat(), src.bigFloat())
+	return value(r, r.Acc())
+}
+
+// fpDiv divides dst by src. A finite value over zero is an infinity, and
+// raises DZ.
+func fpDiv(dst, src Extended) fpResult {
+	if r, ok := nanResult(dst, src); ok {
+		return r
+	}
+	switch {
+	case dst.IsZero() && src.IsZero(), dst.IsInf() && src.IsInf():
+		return operandError()
+	case src.IsZero() && !dst.IsInf():
+		return fpResult{v: new(big.Float).SetInf(dst.Sign != src.Sign), exc: FPExcDZ}
+	}
+	r := work()
+	r.Quo(dst.bigFloat(), src.bigFloat())
+	return value(r, r.Acc())
+}
+
+// fpSqrt returns the square root of src. -0 is its own root.
+func fpSqrt(src Extended) fpResult {
+	if r, ok := nanResult(src, src); ok {
+		return r
+	}
+	switch {
+	case src.IsZero():
+		return fpResult{v: src.bigFloat()}
+	case src.Sign:
+		return operandError()
+	case src.IsInf():
+		return fpResult{v: src.bigFloat()}
+	}
+	x := src.bigFloat()
+	r := work()
+	r.Sqrt(x)
+	sq := new(big.Float).SetPrec(2*workPrec).Mul(r, r)
+	acc := big.Exact
+	if sq.Cmp(x) != 0 {
+		acc = big.Below
+	}
+	return value(r, acc)
+}
+
+// fpInt rounds src to an integer in the rounding mode.
+func fpInt(src Extended, mode uint32) fpResult {
+	if r, ok := nanResult(src, src); ok {
+		return r
+	}
+	if src.IsInf() || src.IsZero() {
+		return fpResult{v: src.bigFloat()}
+	}
+	i, inexact := roundInt(src.bigFloat(), mode)
+	r := new(big.Float).SetInt(i)
+	if i.Sign() == 0 && src.Sign {
+		r.Neg(r)
+	}
+	res := fpResult{v: r}
+	if inexact {
+		res.exc = FPExcINEX2
+	}
+	return res
+}
+
+// fpCompare returns the condition codes of dst-src, as FCMP sets them. The
+// difference is never rounded, so equal values always compare as zero.
+func fpCompare(dst, src Extended) (uint32, uint32) {
+	if r, ok := nanResult(dst, src); ok {
+		return FPCCNaN, r.exc
+	}
+	switch {
+	case dst.IsInf() && src.IsInf() && dst.Sign == src.Sign:
+		if dst.Sign {
+			return FPCCZ | FPCCN, 0
+		}
+		return FPCCZ, 0
+	case dst.IsInf():
+		return FPCCI | signCC(dst.Sign), 0
+	case src.IsInf():
+		return FPCCI | signCC(!src.Sign), 0
+	case dst.IsZero() && src.IsZero():
+		return FPCCZ | signCC(dst.Sign && !src.Sign), 0
+	}
+	switch dst.bigFloat().Cmp(src.bigFloat()) {
+	case -1:
+		return FPCCN, 0
+	case 0:
+		return FPCCZ, 0
+	}
+	return 0, 0
+}
+
+// signCC returns N if neg is set.
+func signCC(neg bool) uint32 {
+	if neg {
+		return FPCCN
+	}
+	return 0
+}
+
+// conditionCodes returns the FPSR condition codes describing x.
+func (x Extended) conditionCodes() uint32 {
+	cc := signCC(x.Sign)
+	switch {
+	case x.IsNaN():
+		cc |= FPCCNaN
+	case x.IsInf():
+		cc |= FPCCI
+	case x.IsZero():
+		cc |= FPCCZ
+	}
+	return cc
+}
+
+// fpTestCondition evaluates one of the 32 FPU predicates of FBcc against the
+// condition codes. The low four bits choose the test; the predicates with
+// bit 4 set also signal BSUN when the NaN bit is set.
+func fpTestCondition(pred uint16, cc uint32) bool {
+	n, z, nan := cc&FPCCN != 0, cc&FPCCZ != 0, cc&FPCCNaN != 0
+	switch pred & 0xF {
+	case 0x0: // F
+		return false
+	case 0x1: // EQ
+		return z
+	case 0x2: // OGT
+		return !(nan || z || n)
+	case 0x3: // OGE
+		return z || !(nan || n)
+	case 0x4: // OLT
+		return n && !(nan || z)
+	case 0x5: // OLE
+		return z || n && !nan
+	case 0x6: // OGL
+		return !(nan || z)
+	case 0x7: // OR
+		return !nan
+	case 0x8: // UN
+		return nan
+	case 0x9: // UEQ
+		return nan || z
+	case 0xA: // UGT
+		return nan || !(n || z)
+	case 0xB: // UGE
+		return nan || z || !n
+	case 0xC: // ULT
+		return nan || n && !z
+	case 0xD: // ULE
+		return nan || z || n
+	case 0xE: // NE
+		return !z
+	}
+	return true // T
+}
+
+// fpToInteger converts x to an integer of the given bits in the rounding
+// mode. NaNs and values out of range raise OPERR and give the largest
+// integer of their sign.
+func fpToInteger(x Extended, size uint, mode uint32) (int64, uint32) {
+	lo, hi := -int64(1)<<(size-1), int64(1)<<(size-1)-1
+	if x.IsNaN() {
+		exc := uint32(FPExcOPERR)
+		if x.isSignalling() {
+			exc |= FPExcSNAN
+		}
+		return hi, exc
+	}
+	limit := hi
+	if x.Sign {
+		limit = lo
+	}
+	if x.IsInf() {
+		return limit, FPExcOPERR
+	}
+	i, inexact := roundInt(x.bigFloat(), mode)
+	if !i.IsInt64() || i.Int64() < lo || i.Int64() > hi {
+		return limit, FPExcOPERR
+	}
+	if inexact {
+		return i.Int64(), FPExcINEX2
+	}
+	return i.Int64(), 0
+}
+
+// extendedFromInt converts an integer exactly.
+func extendedFromInt(v int64) Extended {
+	if v == 0 {
+		return Extended{}
+	}
+	x := Extended{Sign: v < 0}
+	u := uint64(v)
+	if v < 0 {
+		u = -u
+	}
+	n := bits.LeadingZeros64(u)
+	x.Exp = uint16(63 - n + extendedBias)
+	x.Mant = u << n
+	return x
+}
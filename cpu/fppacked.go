@@ -0,0 +1,120 @@
+package cpu
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// The packed decimal real format is 96 bits: the mantissa and exponent
+// signs, two bits set for infinities and NaNs, a three-digit BCD exponent,
+// a fourth exponent digit (the 68882's), one integer digit and sixteen
+// fraction digits. An infinity or NaN has an exponent of $FFF.
+
+// packedDigits is the number of mantissa digits in a packed decimal real.
+const packedDigits = 17
+
+// packedToExtended converts a packed decimal real, rounding in the given
+// mode. An inexact conversion raises INEX1.
+func packedToExtended(w [6]uint16, mode uint32) (Extended, uint32) {
+	sign := w[0]&0x8000 != 0
+	frac := uint64(w[2])<<48 | uint64(w[3])<<32 | uint64(w[4])<<16 | uint64(w[5])
+	if w[0]&0x7FFF == 0x7FFF {
+		if frac == 0 {
+			return Extended{Sign: sign, Exp: 0x7FFF}, 0
+		}
+		return Extended{Sign: sign, Exp: 0x7FFF, Mant: frac}, 0
+	}
+
+	exp := int(w[1]>>12)*1000 + int(w[0]>>8&0xF)*100 + int(w[0]>>4&0xF)*10 + int(w[0]&0xF)
+	if w[0]&0x4000 != 0 {
+		exp = -exp
+	}
+	digits := new(big.Int).SetUint64(uint64(w[1] & 0xF))
+	ten := big.NewInt(10)
+	for i := 60; i >= 0; i -= 4 {
+		digits.Mul(digits, ten).Add(digits, big.NewInt(int64(frac>>uint(i)&0xF)))
+	}
+	if digits.Sign() == 0 {
+		return Extended{Sign: sign}, 0
+	}
+
+	// The digits are an integer scaled by 10^(exp-16).
+	num, den := digits, big.NewInt(1)
+	if scale := exp - (packedDigits - 1); scale >= 0 {
+		num.Mul(num, new(big.Int).Exp(ten, big.NewInt(int64(scale)), nil))
+	} else {
+		den.Exp(ten, big.NewInt(int64(-scale)), nil)
+	}
+	if sign {
+		num.Neg(num)
+	}
+	r := work().SetRat(new(big.Rat).SetFrac(num, den))
+	v, exc := precExtended.round(toOdd(r, r.Acc()), mode)
+	if exc&FPExcINEX2 != 0 {
+		exc = exc&^FPExcINEX2 | FPExcINEX1
+	}
+	return extendedFromBig(v), exc
+}
+
+// extendedToPacked converts x to a packed decimal real. A k-factor above
+// zero is the number of significant digits, at most 17; otherwise -k is
+// the number of digits after the decimal point. A k-factor above 17 raises
+// OPERR, and a result that isn't exact raises INEX2.
+func extendedToPacked(x Extended, k int) ([6]uint16, uint32) {
+	var w [6]uint16
+	if x.Sign {
+		w[0] = 0x8000
+	}
+	switch {
+	case x.Exp == 0x7FFF:
+		w[0] |= 0x7FFF
+		if x.IsNaN() {
+			w[2], w[3], w[4], w[5] = uint16(x.Mant>>48), uint16(x.Mant>>32), uint16(x.Mant>>16), uint16(x.Mant)
+		}
+		return w, 0
+	case x.IsZero():
+		return w, 0
+	}
+
+	var exc uint32
+	if k > packedDigits {
+		k, exc = packedDigits, FPExcOPERR
+	}
+	f := x.bigFloat()
+	f.Abs(f)
+	_, e := decimalDigits(f, packedDigits)
+	n := k
+	if k <= 0 {
+		n = e + 1 - k
+	}
+	n = min(max(n, 1), packedDigits)
+	digits, e := decimalDigits(f, n)
+
+	exact, _ := new(big.Rat).SetString(digits[:1] + "." + digits[1:] + "e" + strconv.Itoa(e))
+	if want, _ := f.Rat(nil); exact.Cmp(want) != 0 {
+		exc |= FPExcINEX2
+	}
+
+	digits += strings.Repeat("0", packedDigits-len(digits))
+	if e < 0 {
+		w[0] |= 0x4000
+		e = -e
+	}
+	w[0] |= uint16(e/100%10)<<8 | uint16(e/10%10)<<4 | uint16(e%10)
+	w[1] = uint16(e/1000%10)<<12 | uint16(digits[0]-'0')
+	var frac uint64
+	for _, d := range digits[1:] {
+		frac = frac<<4 | uint64(d-'0')
+	}
+	w[2], w[3], w[4], w[5] = uint16(frac>>48), uint16(frac>>32), uint16(frac>>16), uint16(frac)
+	return w, exc
+}
+
+// decimalDigits returns the first n significant digits of f, rounded, and
+// its decimal exponent.
+func decimalDigits(f *big.Float, n int) (string, int) {
+	mant, exp, _ := strings.Cut(f.Text('e', n-1), "e")
+	e, _ := strconv.Atoi(exp)
+	return strings.Replace(mant, ".", "", 1), e
+}
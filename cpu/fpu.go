@@ -0,0 +1,635 @@
+package cpu
+
+import "fmt"
+
+// FPU is a 68881 or 68882 floating-point coprocessor, or the FPU built into
+// the 68040 and 68060. Attach it as coprocessor 1 with
+// CPU.SetCoprocessor(1, NewFPU()). It runs the instructions the assembler
+// knows: FMOVE, FMOVEM, FADD, FSUB, FMUL, FDIV, FSQRT, FABS, FNEG, FCMP,
+// FTST, FINT, FINTRZ, FBcc, FNOP, FSAVE and FRESTORE, and on a 68040 or
+// 68060 the FS and FD rounding forms. Other commands take the line 1111
+// exception, for software to emulate.
+//
+// Results are rounded correctly to extended precision, or to single or
+// double if FPCR or the instruction asks, keeping the extended exponent
+// range. An exception FPCR enables is taken after the instruction, with the
+// next instruction's address stacked.
+type FPU struct {
+	// FP holds the data registers FP0-FP7.
+	FP [8]Extended
+	// FPCR enables exceptions in bits 15-8, with the layout of the FPSR
+	// exception status byte, and chooses the rounding precision (bits 7-6:
+	// extended, single or double) and mode (bits 5-4).
+	FPCR uint32
+	// FPSR holds the condition codes in bits 27-24, the quotient byte, the
+	// exception status of the last instruction in bits 15-8 and the accrued
+	// exceptions in bits 7-3.
+	FPSR uint32
+	// FPIAR is the address of the last arithmetic instruction.
+	FPIAR uint32
+	// Used is set once the FPU has run an instruction, so FSAVE stores an
+	// idle frame instead of a null one.
+	Used bool
+}
+
+// FPU register masks: the bits of each control register that exist.
+const (
+	fpcrMask = 0x0000FFF0
+	fpsrMask = 0x0FFFFFF8
+)
+
+// Accrued exception bits of FPSR.
+const (
+	fpAccIOP  = 0x80
+	fpAccOVFL = 0x40
+	fpAccUNFL = 0x20
+	fpAccDZ   = 0x10
+	fpAccINEX = 0x08
+)
+
+// fpTraps are the FPU exceptions in order of priority, with their vectors.
+var fpTraps = []struct {
+	bit    uint32
+	vector int
+}{
+	{FPExcBSUN, VectorFPBSUN},
+	{FPExcSNAN, VectorFPSNAN},
+	{FPExcOPERR, VectorFPOPERR},
+	{FPExcOVFL, VectorFPOVFL},
+	{FPExcUNFL, VectorFPUNFL},
+	{FPExcDZ, VectorFPDZ},
+	{FPExcINEX2 | FPExcINEX1, VectorFPINEX},
+}
+
+// fpOperations are the opmodes of the arithmetic instructions FPU runs.
+var fpOperations = func() map[uint16]bool {
+	m := make(map[uint16]bool, len(FPUOpmodes))
+	for _, op := range FPUOpmodes {
+		m[op] = true
+	}
+	return m
+}()
+
+// NewFPU returns an FPU in its reset state.
+func NewFPU() *FPU {
+	f := &FPU{}
+	f.Reset()
+	return f
+}
+
+// Reset puts the FPU in its reset state: the data registers hold NaNs and
+// the control registers are cleared.
+func (f *FPU) Reset() {
+	for i := range f.FP {
+		f.FP[i] = extendedNaN
+	}
+	f.FPCR, f.FPSR, f.FPIAR = 0, 0, 0
+	f.Used = false
+}
+
+// Execute runs an FPU instruction. It implements Coprocessor.
+func (f *FPU) Execute(c *CPU, opcode uint16) (bool, error) {
+	switch {
+	case opcode&0xFF80 == OPFBcc:
+		return f.branch(c, opcode)
+	case opcode&0xFFC0 == OPFSAVE:
+		return f.save(c, opcode)
+	case opcode&0xFFC0 == OPFRESTORE:
+		return f.restore(c, opcode)
+	case opcode&0xFFC0 == OPFPU:
+		cmd := c.ReadU16(c.PC)
+		c.PC += 2
+		f.Used = true
+		switch cmd >> 13 {
+		case 0, 2:
+			return f.arithmetic(c, opcode, cmd)
+		case 3:
+			return f.moveOut(c, opcode, cmd)
+		case 4, 5:
+			return f.moveControl(c, opcode, cmd)
+		case 6, 7:
+			return f.moveMultiple(c, opcode, cmd)
+		}
+	}
+	return false, nil
+}
+
+// roundingMode returns the RND field of FPCR.
+func (f *FPU) roundingMode() uint32 {
+	return f.FPCR >> 4 & 3
+}
+
+// precision returns what FPCR rounds results to. Single and double keep the
+// extended exponent range.
+func (f *FPU) precision() fpPrecision {
+	switch f.FPCR >> 6 & 3 {
+	case 1:
+		return fpPrecision{precSingle.bits, precExtended.minExp, precExtended.maxExp}
+	case 2:
+		return fpPrecision{precDouble.bits, precExtended.minExp, precExtended.maxExp}
+	}
+	return precExtended
+}
+
+// finish records an instruction's exceptions in FPSR, and its condition
+// codes if setCC is set, and takes the exception FPCR enables, if any.
+func (f *FPU) finish(c *CPU, exc, cc uint32, setCC bool) error {
+	f.FPSR = f.FPSR&^0xFF00 | exc
+	if setCC {
+		f.FPSR = f.FPSR&^0x0F000000 | cc
+	}
+	if exc&(FPExcBSUN|FPExcSNAN|FPExcOPERR) != 0 {
+		f.FPSR |= fpAccIOP
+	}
+	if exc&FPExcOVFL != 0 {
+		f.FPSR |= fpAccOVFL
+	}
+	if exc&FPExcUNFL != 0 {
+		f.FPSR |= fpAccUNFL
+	}
+	if exc&FPExcDZ != 0 {
+		f.FPSR |= fpAccDZ
+	}
+	if exc&(FPExcINEX1|FPExcINEX2|FPExcOVFL) != 0 {
+		f.FPSR |= fpAccINEX
+	}
+	enabled := exc & f.FPCR
+	for _, t := range fpTraps {
+		if enabled&t.bit != 0 {
+			return c.exception(t.vector, c.PC)
+		}
+	}
+	return nil
+}
+
+// arithmetic runs the instructions with an FPn or <ea> source: FMOVE to a
+// register, the monadic and dyadic operations, FCMP and FTST.
+func (f *FPU) arithmetic(c *CPU, opcode, cmd uint16) (bool, error) {
+	opmode := cmd & 0x7F
+	if !fpOperations[opmode] || opmode >= 0x40 && c.Model < MC68040 {
+		return false, nil
+	}
+	f.FPIAR = c.instAddr
+	var src Extended
+	var exc uint32
+	if cmd>>13 == 0 {
+		if opcode&0x3F != 0 {
+			return false, nil
+		}
+		src = f.FP[cmd>>10&7]
+	} else {
+		var ok bool
+		var err error
+		src, exc, ok, err = f.load(c, opcode>>3&7, opcode&7, cmd>>10&7)
+		if !ok || err != nil {
+			return ok, err
+		}
+	}
+
+	n := cmd >> 7 & 7
+	dst := f.FP[n]
+	prec, mode := f.precision(), f.roundingMode()
+	if opmode >= 0x40 {
+		// The 68040's FS and FD forms
+		prec = fpPrecision{precSingle.bits, precExtended.minExp, precExtended.maxExp}
+		if opmode&4 != 0 {
+			prec.bits = precDouble.bits
+		}
+		opmode &^= 0x44
+		if opmode == 0x01 {
+			opmode = FPUOpmodes["fsqrt"]
+		}
+	}
+
+	var r fpResult
+	switch opmode {
+	case FPUOpmodes["fcmp"]:
+		cc, cmpExc := fpCompare(dst, src)
+		return true, f.finish(c, exc|cmpExc, cc, true)
+	case FPUOpmodes["ftst"]:
+		if src.isSignalling() {
+			exc |= FPExcSNAN
+		}
+		return true, f.finish(c, exc, src.conditionCodes(), true)
+	case FPUOpmodes["fmove"]:
+		var nan bool
+		if r, nan = nanResult(src, src); !nan {
+			r = fpResult{v: src.bigFloat()}
+		}
+	case FPUOpmodes["fint"]:
+		r = fpInt(src, mode)
+	case FPUOpmodes["fintrz"]:
+		r = fpInt(src, FPRoundZero)
+	case FPUOpmodes["fsqrt"]:
+		r = fpSqrt(src)
+	case FPUOpmodes["fabs"], FPUOpmodes["fneg"]:
+		var nan bool
+		if r, nan = nanResult(src, src); !nan {
+			src.Sign = opmode == FPUOpmodes["fneg"] && !src.Sign
+			r = fpResult{v: src.bigFloat()}
+		}
+	case FPUOpmodes["fadd"]:
+		r = fpAdd(dst, src, false, mode)
+	case FPUOpmodes["fsub"]:
+		r = fpAdd(dst, src, true, mode)
+	case FPUOpmodes["fmul"]:
+		r = fpMul(dst, src)
+	case FPUOpmodes["fdiv"]:
+		r = fpDiv(dst, src)
+	}
+
+	exc |= r.exc
+	res := r.value
+	if !r.nan {
+		v, roundExc := prec.round(r.v, mode)
+		res = extendedFromBig(v)
+		exc |= roundExc
+	}
+	// An enabled SNAN, OPERR or DZ leaves the destination for the handler.
+	if exc&f.FPCR&(FPExcSNAN|FPExcOPERR|FPExcDZ) == 0 {
+		f.FP[n] = res
+	}
+	return true, f.finish(c, exc, res.conditionCodes(), true)
+}
+
+// fpFormatBytes are the sizes of the FPU operand formats in memory: long,
+// single, extended, packed, word, double and byte.
+var fpFormatBytes = [7]uint32{4, 4, 12, 12, 2, 8, 1}
+
+// operandAddress returns the address of an FPU operand of n bytes in
+// memory, stepping (An)+ and -(An) by n and consuming an immediate.
+func (c *CPU) operandAddress(mode, reg uint16, n uint32) (uint32, error) {
+	if n == 1 && reg == 7 && (mode == ModeAddrPostInc || mode == ModeAddrPreDec) {
+		n = 2
+	}
+	switch {
+	case mode == ModeAddrPostInc:
+		addr := c.A[reg]
+		c.A[reg] += n
+		return addr, nil
+	case mode == ModeAddrPreDec:
+		c.A[reg] -= n
+		return c.A[reg], nil
+	case mode == ModeOther && reg == RegImmediate:
+		addr := c.PC
+		if n == 1 {
+			addr++ // A byte immediate is the low byte of a word.
+			n = 2
+		}
+		c.PC += n
+		return addr, nil
+	}
+	return c.effectiveAddress(mode, reg)
+}
+
+// readWords reads an extended or packed operand.
+func (c *CPU) readWords(addr uint32) [6]uint16 {
+	var w [6]uint16
+	for i := range w {
+		w[i] = c.ReadU16(addr + uint32(i)*2)
+	}
+	return w
+}
+
+// writeWords writes an extended or packed operand.
+func (c *CPU) writeWords(addr uint32, w [6]uint16) {
+	for i, v := range w {
+		c.WriteU16(addr+uint32(i)*2, v)
+	}
+}
+
+// validFPEA reports whether an <ea> can hold an operand of the format: not
+// an address register, and a data register only for the formats of 32 bits
+// or less. Destinations can't be immediate or PC relative.
+func validFPEA(mode, reg, format uint16, write bool) bool {
+	switch {
+	case mode == ModeAddr, mode == ModeData && fpFormatBytes[format] > 4:
+		return false
+	case mode == ModeOther && reg > RegImmediate:
+		return false
+	case write && mode == ModeOther && reg > RegAbsLong:
+		return false
+	}
+	return true
+}
+
+// load reads an FPU source operand in the given format and converts it to
+// extended precision. It returns false for an operand the FPU can't take.
+func (f *FPU) load(c *CPU, mode, reg, format uint16) (Extended, uint32, bool, error) {
+	if format > 6 || !validFPEA(mode, reg, format, false) {
+		return Extended{}, 0, false, nil
+	}
+	n := fpFormatBytes[format]
+	if mode == ModeData {
+		v := c.D[reg]
+		switch format {
+		case 0:
+			return extendedFromInt(int64(int32(v))), 0, true, nil
+		case 1:
+			return extendedFromIEEE(uint64(v), 8, 23), 0, true, nil
+		case 4:
+			return extendedFromInt(int64(int16(v))), 0, true, nil
+		}
+		return extendedFromInt(int64(int8(v))), 0, true, nil
+	}
+
+	addr, err := c.operandAddress(mode, reg, n)
+	if err != nil {
+		return Extended{}, 0, false, fmt.Errorf("FPU failed to get operand address: %w", err)
+	}
+	switch format {
+	case 0:
+		return extendedFromInt(int64(int32(c.ReadU32(addr)))), 0, true, nil
+	case 1:
+		return extendedFromIEEE(uint64(c.ReadU32(addr)), 8, 23), 0, true, nil
+	case 2:
+		return ExtendedFromWords(c.readWords(addr)), 0, true, nil
+	case 3:
+		x, exc := packedToExtended(c.readWords(addr), f.roundingMode())
+		return x, exc, true, nil
+	case 4:
+		return extendedFromInt(int64(int16(c.ReadU16(addr)))), 0, true, nil
+	case 5:
+		b := uint64(c.ReadU32(addr))<<32 | uint64(c.ReadU32(addr+4))
+		return extendedFromIEEE(b, 11, 52), 0, true, nil
+	}
+	return extendedFromInt(int64(int8(c.read8(addr)))), 0, true, nil
+}
+
+// moveOut runs FMOVE FPn,<ea>, converting to the destination's format. The
+// packed format takes a k-factor from the command word, or from a data
+// register in the dynamic form. The condition codes are left alone.
+func (f *FPU) moveOut(c *CPU, opcode, cmd uint16) (bool, error) {
+	format := cmd >> 10 & 7
+	mode, reg := opcode>>3&7, opcode&7
+	k := int(int8(cmd<<1) >> 1)
+	if format == 7 {
+		format, k = 3, int(int8(c.D[cmd>>4&7]<<1)>>1)
+	}
+	if !validFPEA(mode, reg, format, true) {
+		return false, nil
+	}
+	f.FPIAR = c.instAddr
+	x := f.FP[cmd>>7&7]
+	rnd := f.roundingMode()
+
+	var exc uint32
+	var out uint64
+	switch format {
+	case 0, 4, 6:
+		size := map[uint16]Size{0: SizeLong, 4: SizeWord, 6: SizeByte}[format]
+		var v int64
+		v, exc = fpToInteger(x, uint(size.Bytes())*8, rnd)
+		out = uint64(v)
+	case 1, 5:
+		expBits, fracBits, prec := uint(8), uint(23), precSingle
+		if format == 5 {
+			expBits, fracBits, prec = 11, 52, precDouble
+		}
+		if x.IsNaN() {
+			if x.isSignalling() {
+				exc = FPExcSNAN
+			}
+			out = ieeeNaN(x.quiet(), expBits, fracBits)
+			break
+		}
+		v, roundExc := prec.round(x.bigFloat(), rnd)
+		out, exc = ieeeBits(v, expBits, fracBits), roundExc
+	}
+
+	if mode == ModeData {
+		size := map[uint16]Size{0: SizeLong, 1: SizeLong, 4: SizeWord, 6: SizeByte}[format]
+		o := Operand{Mode: mode, Reg: reg, Size: size}
+		if err := c.WriteOperand(o, uint32(out)); err != nil {
+			return true, err
+		}
+		return true, f.finish(c, exc, 0, false)
+	}
+	addr, err := c.operandAddress(mode, reg, fpFormatBytes[format])
+	if err != nil {
+		return true, fmt.Errorf("FPU failed to get operand address: %w", err)
+	}
+	switch format {
+	case 0, 1:
+		c.WriteU32(addr, uint32(out))
+	case 4:
+		c.WriteU16(addr, uint16(out))
+	case 6:
+		c.write8(addr, uint8(out))
+	case 5:
+		c.WriteU32(addr, uint32(out>>32))
+		c.WriteU32(addr+4, uint32(out))
+	case 2:
+		c.writeWords(addr, x.Words())
+	case 3:
+		var w [6]uint16
+		w, exc = extendedToPacked(x, k)
+		c.writeWords(addr, w)
+	}
+	return true, f.finish(c, exc, 0, false)
+}
+
+// fpControl returns the control registers in select bits sel, in the order
+// they are moved: FPCR, FPSR and FPIAR.
+func (f *FPU) fpControl(sel uint16) []*uint32 {
+	var regs []*uint32
+	if sel&4 != 0 {
+		regs = append(regs, &f.FPCR)
+	}
+	if sel&2 != 0 {
+		regs = append(regs, &f.FPSR)
+	}
+	if sel&1 != 0 {
+		regs = append(regs, &f.FPIAR)
+	}
+	return regs
+}
+
+// moveControl runs FMOVE and FMOVEM to and from the control registers. A
+// data register holds only one of them, and an address register only FPIAR.
+func (f *FPU) moveControl(c *CPU, opcode, cmd uint16) (bool, error) {
+	sel := cmd >> 10 & 7
+	mode, reg := opcode>>3&7, opcode&7
+	toMemory := cmd>>13 == 5
+	regs := f.fpControl(sel)
+	switch {
+	case sel == 0 || cmd&0x3FF != 0:
+		return false, nil
+	case mode <= ModeAddr && len(regs) != 1, mode == ModeAddr && sel != 1:
+		return false, nil
+	case mode == ModeOther && reg > RegImmediate, toMemory && mode == ModeOther && reg > RegAbsLong:
+		return false, nil
+	}
+
+	if mode <= ModeAddr {
+		r := &c.D[reg]
+		if mode == ModeAddr {
+			r = &c.A[reg]
+		}
+		if toMemory {
+			*r = *regs[0]
+		} else {
+			*regs[0] = *r
+		}
+	} else {
+		addr, err := c.operandAddress(mode, reg, uint32(len(regs))*4)
+		if err != nil {
+			return true, fmt.Errorf("FMOVEM failed to get address: %w", err)
+		}
+		for i, r := range regs {
+			if toMemory {
+				c.WriteU32(addr+uint32(i)*4, *r)
+			} else {
+				*r = c.ReadU32(addr + uint32(i)*4)
+			}
+		}
+	}
+	f.FPCR &= fpcrMask
+	f.FPSR &= fpsrMask
+	return true, nil
+}
+
+// moveMultiple runs FMOVEM.X, moving data registers as extended reals. The
+// list is in the command word or, in the dynamic forms, a data register.
+// To -(An), FPn is in bit n of the list and the registers are stored
+// downwards; otherwise FP0 is in bit 7 and they go upwards. Either way FP0
+// ends up at the lowest address.
+func (f *FPU) moveMultiple(c *CPU, opcode, cmd uint16) (bool, error) {
+	mode, reg := opcode>>3&7, opcode&7
+	toMemory := cmd>>13 == 7
+	predec := cmd>>11&2 == 0
+	list := cmd & 0xFF
+	if cmd>>11&1 != 0 {
+		list = uint16(c.D[cmd>>4&7] & 0xFF)
+	}
+	switch {
+	case mode <= ModeAddr, mode == ModeOther && reg > RegAbsLong && (toMemory || reg > RegPCIndex):
+		return false, nil
+	case predec != (mode == ModeAddrPreDec && toMemory):
+		return false, nil
+	case !toMemory && mode == ModeAddrPreDec, toMemory && mode == ModeAddrPostInc:
+		return false, nil
+	}
+
+	var regs []uint16
+	for n := range uint16(8) {
+		bit := uint16(0x80) >> n
+		if predec {
+			bit = 1 << n
+		}
+		if list&bit != 0 {
+			regs = append(regs, n)
+		}
+	}
+	addr, err := c.operandAddress(mode, reg, uint32(len(regs))*12)
+	if err != nil {
+		return true, fmt.Errorf("FMOVEM failed to get address: %w", err)
+	}
+	for i, n := range regs {
+		a := addr + uint32(i)*12
+		if toMemory {
+			c.writeWords(a, f.FP[n].Words())
+		} else {
+			f.FP[n] = ExtendedFromWords(c.readWords(a))
+		}
+	}
+	return true, nil
+}
+
+// branch runs FBcc and FNOP. The displacement is relative to the word after
+// the opcode. A predicate from $10 up signals BSUN if the last result was a
+// NaN.
+func (f *FPU) branch(c *CPU, opcode uint16) (bool, error) {
+	pred := opcode & 0x3F
+	if pred > 0x1F {
+		return false, nil
+	}
+	base := c.PC
+	var disp int32
+	if opcode&0x40 != 0 {
+		disp = int32(c.ReadU32(c.PC))
+		c.PC += 4
+	} else {
+		disp = int32(int16(c.ReadU16(c.PC)))
+		c.PC += 2
+	}
+	cc := f.FPSR & 0x0F000000
+	if fpTestCondition(pred, cc) {
+		c.PC = base + uint32(disp)
+	}
+	if pred&0x10 != 0 && cc&FPCCNaN != 0 {
+		return true, f.finish(c, f.FPSR&0xFF00|FPExcBSUN, 0, false)
+	}
+	return true, nil
+}
+
+// fpIdleFrame is the header of the 68881's idle state frame: its version
+// and the size of what follows.
+const fpIdleFrame = 0x1F180000
+
+// save runs FSAVE, which is privileged. Until the FPU has been used it
+// stores a null frame, a zero long; after that an idle frame of 28 bytes.
+func (f *FPU) save(c *CPU, opcode uint16) (bool, error) {
+	mode, reg := opcode>>3&7, opcode&7
+	if mode <= ModeAddr || mode == ModeAddrPostInc || mode == ModeOther && reg > RegAbsLong {
+		return false, nil
+	}
+	if !c.SR.Supervisor() {
+		return true, c.privilegeViolation()
+	}
+	header, size := uint32(0), uint32(4)
+	if f.Used {
+		header, size = fpIdleFrame, 4+fpIdleFrame>>16&0xFF
+	}
+	addr, err := c.operandAddress(mode, reg, size)
+	if err != nil {
+		return true, fmt.Errorf("FSAVE failed to get address: %w", err)
+	}
+	c.WriteU32(addr, header)
+	for i := uint32(4); i < size; i += 4 {
+		c.WriteU32(addr+i, 0)
+	}
+	return true, nil
+}
+
+// restore runs FRESTORE, which is privileged. A null frame resets the FPU;
+// any other frame is skipped, as the registers hold all the state there is.
+func (f *FPU) restore(c *CPU, opcode uint16) (bool, error) {
+	mode, reg := opcode>>3&7, opcode&7
+	if mode <= ModeAddr || mode == ModeAddrPreDec || mode == ModeOther && reg > RegPCIndex {
+		return false, nil
+	}
+	if !c.SR.Supervisor() {
+		return true, c.privilegeViolation()
+	}
+	var header uint32
+	if mode == ModeAddrPostInc {
+		header = c.ReadU32(c.A[reg])
+		c.A[reg] += 4
+		if header != 0 {
+			c.A[reg] += header >> 16 & 0xFF
+		}
+	} else {
+		addr, err := c.effectiveAddress(mode, reg)
+		if err != nil {
+			return true, fmt.Errorf("FRESTORE failed to get address: %w", err)
+		}
+		header = c.ReadU32(addr)
+	}
+	if header>>24 == 0 {
+		f.Reset()
+	} else {
+		f.Used = true
+	}
+	return true, nil
+}
+
+// Float64 returns FPn as the nearest float64, for host code.
+func (f *FPU) Float64(n int) float64 {
+	return f.FP[n&7].Float64()
+}
+
+// SetFloat64 sets FPn to v, for host code.
+func (f *FPU) SetFloat64(n int, v float64) {
+	f.FP[n&7] = ExtendedFromFloat64(v)
+}
@@ -52,6 +52,8 @@ const (
 	OPNEG                 = 0x4400 // NEG
 	OPNEGX                = 0x4000 // NEGX
 	OPNBCD                = 0x4800 // NBCD
+	OPABCD                = 0xC100 // ABCD
+	OPSBCD                = 0x8100 // SBCD
 	OPEXT                 = 0x4800 // EXT
 	OPSWAP                = 0x4840 // SWAP
 	OPBCHG                = 0x0840 // BCHG
@@ -80,6 +82,7 @@ const (
 	OPCMP  = 0xB000 // CMP
 	OPCMPI = 0x0C00 // CMPI
 	OPCMPA = 0xB000 // CMPA (Base, size bits added separately)
+	OPCMPM = 0xB108 // CMPM
 	OPCHK  = 0x4180 // CHK
 
 	// Shift and Rotate Instructions
@@ -102,7 +105,7 @@ const (
 	OPMOVEFromSR  = 0x40C0 // MOVE from SR
 	OPMOVEToSR    = 0x46C0 // MOVE to SR (privileged)
 	OPMOVEToCCR   = 0x44C0 // MOVE to CCR - technically doesn't exist on MC68000
-	OPMOVEFromCCR = 0x42C0 // MOVE from CCR - technically doesn't exist on MC68000
+	OPMOVEFromCCR = 0x42C0 // MOVE from CCR, new in the MC68010
 	OPMOVEFromUSP = 0x4E68 // MOVE from USP
 	OPMOVEToUSP   = 0x4E60 // MOVE to USP
 
@@ -150,6 +153,33 @@ const (
 	// Jump and Subroutine Instructions
 	OPJMP = 0x4EC0 // JMP
 	OPJSR = 0x4E80 // JSR
+
+	// 68010 Instructions
+	OPMOVEC = 0x4E7A // MOVEC Rc,Rn (base, bit 0 set for Rn,Rc), followed by the register word
+	OPMOVES = 0x0E00 // MOVES (base, size and EA OR'd), followed by the register word
+	OPRTD   = 0x4E74 // RTD
+
+	// 68020 Instructions
+	OPEXTB     = 0x49C0 // EXTB.L (base, Dn OR'd)
+	OPMULL     = 0x4C00 // MULU.L and MULS.L (base, EA OR'd), followed by the register word
+	OPDIVL     = 0x4C40 // DIVU.L, DIVS.L, DIVUL.L and DIVSL.L (base, EA OR'd), followed by the register word
+	OPCAS      = 0x08C0 // CAS (base, size in bits 10-9 and EA OR'd), followed by the register word
+	OPCAS2     = 0x0CFC // CAS2.W (bit 9 set for .L), followed by two register words
+	OPPACK     = 0x8140 // PACK (base, registers and R/M bit OR'd), followed by the adjustment
+	OPUNPK     = 0x8180 // UNPK (base, registers and R/M bit OR'd), followed by the adjustment
+	OPTRAPcc   = 0x50F8 // TRAPcc (base, condition and opmode OR'd)
+	OPBitField = 0xE8C0 // BFTST (base, operation in bits 10-8 and EA OR'd), followed by the field word
+
+	// 68040 and 68060 Instructions
+	OPMOVE16        = 0xF600 // MOVE16 to or from an absolute address (base, opmode and register OR'd)
+	OPMOVE16PostInc = 0xF620 // MOVE16 (Ax)+,(Ay)+ (base, Ax OR'd)
+
+	// FPU Instructions (coprocessor 1)
+	OPFPU      = 0xF200 // General FPU instruction (base, EA OR'd), followed by a command word
+	OPFBcc     = 0xF280 // FBcc with a word displacement (base, condition OR'd)
+	OPFBccLong = 0xF2C0 // FBcc with a long displacement (base, condition OR'd)
+	OPFSAVE    = 0xF300 // FSAVE (base, EA OR'd)
+	OPFRESTORE = 0xF340 // FRESTORE (base, EA OR'd)
 )
 
 // BranchOpcodes maps branch mnemonics to their base opcodes.
@@ -191,3 +221,64 @@ var ConditionCodes = map[string]uint16{
 	"gt": 0xE, // greater than
 	"le": 0xF, // less or equal
 }
+
+// FPUOpmodes maps the arithmetic FPU mnemonics to the opmode field of their
+// command word. The FS and FD forms round to single and double precision and
+// only exist on the 68040 and 68060.
+var FPUOpmodes = map[string]uint16{
+	"fmove":  0x00,
+	"fint":   0x01,
+	"fintrz": 0x03,
+	"fsqrt":  0x04,
+	"fabs":   0x18,
+	"fneg":   0x1A,
+	"fdiv":   0x20,
+	"fadd":   0x22,
+	"fmul":   0x23,
+	"fsub":   0x28,
+	"fcmp":   0x38,
+	"ftst":   0x3A,
+	"fsmove": 0x40,
+	"fssqrt": 0x41,
+	"fdmove": 0x44,
+	"fdsqrt": 0x45,
+	"fsabs":  0x58,
+	"fsneg":  0x5A,
+	"fdabs":  0x5C,
+	"fdneg":  0x5E,
+	"fsdiv":  0x60,
+	"fsadd":  0x62,
+	"fsmul":  0x63,
+	"fddiv":  0x64,
+	"fdadd":  0x66,
+	"fdmul":  0x67,
+	"fssub":  0x68,
+	"fdsub":  0x6C,
+}
+
+// FPUFormats maps size suffixes to the source format field of an FPU command
+// word: long, single, extended, packed, word, double and byte.
+var FPUFormats = map[string]uint16{
+	"l": 0, "s": 1, "x": 2, "p": 3, "w": 4, "d": 5, "b": 6,
+}
+
+// FPUControlRegisters maps the FPU control registers to their select bits.
+var FPUControlRegisters = map[string]uint16{
+	"fpcr":  4,
+	"fpsr":  2,
+	"fpiar": 1,
+}
+
+// FPUConditions maps FBcc condition mnemonics to their 6-bit predicates.
+// The first 16 never signal BSUN; the rest are the same tests, signalling it
+// when an operand is a NaN.
+var FPUConditions = map[string]uint16{
+	"f": 0x00, "eq": 0x01, "ogt": 0x02, "oge": 0x03,
+	"olt": 0x04, "ole": 0x05, "ogl": 0x06, "or": 0x07,
+	"un": 0x08, "ueq": 0x09, "ugt": 0x0A, "uge": 0x0B,
+	"ult": 0x0C, "ule": 0x0D, "ne": 0x0E, "t": 0x0F,
+	"sf": 0x10, "seq": 0x11, "gt": 0x12, "ge": 0x13,
+	"lt": 0x14, "le": 0x15, "gl": 0x16, "gle": 0x17,
+	"ngle": 0x18, "ngl": 0x19, "nle": 0x1A, "nlt": 0x1B,
+	"nge": 0x1C, "ngt": 0x1D, "sne": 0x1E, "st": 0x1F,
+}
@@ -0,0 +1,146 @@
+package cpu
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Autovector asks RaiseInterrupt to use the level's autovector, as when a
+// device answers the interrupt acknowledge cycle with VPA instead of a vector.
+const Autovector = 0
+
+// NMILevel is the non-maskable interrupt level. It is taken whatever the mask,
+// once each time the line is asserted.
+const NMILevel = 7
+
+// irqLines holds the state of the seven interrupt request lines. Devices may
+// assert and clear them from other goroutines while the CPU runs.
+type irqLines struct {
+	// pending has bit n set while level n is asserted.
+	pending atomic.Uint32
+	// vectors holds the vector each level supplies, or Autovector.
+	vectors [8]atomic.Uint32
+	// nmi is set when level 7 is asserted and cleared when it is taken.
+	nmi atomic.Uint32
+	// raised counts the levels asserted while they were clear.
+	raised atomic.Uint64
+}
+
+// RaiseInterrupt asserts interrupt level 1-7. The CPU takes it before the next
+// instruction if the level is above the SR interrupt mask, or at once for level
+// 7, using vector or, for Autovector, the level's autovector. The line stays
+// asserted until ClearInterrupt, as with a device holding its IRQ output, so a
+// handler must clear the source before lowering the mask.
+func (c *CPU) RaiseInterrupt(level, vector int) error {
+	if level < 1 || level > 7 {
+		return fmt.Errorf("interrupt level %d is not 1-7", level)
+	}
+	if vector != Autovector && (vector < 2 || vector >= VectorCount) {
+		return fmt.Errorf("interrupt vector %d is not 2-%d", vector, VectorCount-1)
+	}
+	c.irq.vectors[level].Store(uint32(vector))
+	old := c.irq.pending.Or(1 << level)
+	if old&(1<<level) == 0 {
+		c.irq.raised.Add(1)
+		if level == NMILevel {
+			c.irq.nmi.Store(1)
+		}
+	}
+	return nil
+}
+
+// InterruptsRaised returns how many times RaiseInterrupt has asserted a level
+// that was clear. A device holding its line asserted counts once.
+func (c *CPU) InterruptsRaised() uint64 {
+	return c.irq.raised.Load()
+}
+
+// ClearInterrupt deasserts interrupt level 1-7.
+func (c *CPU) ClearInterrupt(level int) {
+	if level < 1 || level > 7 {
+		return
+	}
+	c.irq.pending.And(^uint32(1 << level))
+}
+
+// Idle reports whether the CPU is stopped with no interrupt asserted, so only
+// a device raising one or a reset can make it continue.
+func (c *CPU) Idle() bool {
+	return c.Stopped && c.irq.pending.Load() == 0
+}
+
+// PendingInterrupts returns a mask with bit n set for each asserted level n.
+func (c *CPU) PendingInterrupts() uint8 {
+	return uint8(c.irq.pending.Load())
+}
+
+// interrupt takes the highest asserted interrupt that the mask allows. It
+// stacks the PC and SR like any exception, then raises the mask to the level
+// being serviced, leaving the stopped state. It reports whether an interrupt
+// was taken.
+func (c *CPU) interrupt() (bool, error) {
+	pending := c.irq.pending.Load()
+	level := 7
+	for level > 0 && pending&(1<<level) == 0 {
+		level--
+	}
+	switch {
+	case level == 0:
+		return false, nil
+	case level == NMILevel:
+		if c.irq.nmi.Swap(0) == 0 {
+			return false, nil
+		}
+	case level <= c.SR.IntMask():
+		return false, nil
+	}
+
+	vector := int(c.irq.vectors[level].Load())
+	if vector == Autovector {
+		vector = VectorSpurious + level
+	}
+	c.instAddr = c.PC
+	if err := c.exception(vector, c.PC); err != nil {
+		return false, err
+	}
+	c.SR = c.SR&^SRI | SR(level)<<8
+	c.Stopped = false
+	return true, nil
+}
+
+// InterruptLines is the state of the interrupt request lines, for saving and
+// restoring a machine.
+type InterruptLines struct {
+	// Pending has bit n set while level n is asserted.
+	Pending uint8
+	// Vectors holds the vector each level supplies, or Autovector.
+	Vectors [8]uint8
+	// NMI is set while an asserted level 7 has not been taken.
+	NMI bool
+}
+
+// InterruptLines returns the state of the interrupt request lines.
+func (c *CPU) InterruptLines() InterruptLines {
+	l := InterruptLines{
+		Pending: uint8(c.irq.pending.Load()),
+		NMI:     c.irq.nmi.Load() != 0,
+	}
+	for i := range l.Vectors {
+		l.Vectors[i] = uint8(c.irq.vectors[i].Load())
+	}
+	return l
+}
+
+// SetInterruptLines restores the interrupt request lines saved by
+// InterruptLines. Level 0 is never asserted.
+func (c *CPU) SetInterruptLines(l InterruptLines) {
+	for i, v := range l.Vectors {
+		c.irq.vectors[i].Store(uint32(v))
+	}
+	var nmi uint32
+	if l.NMI {
+		nmi = 1
+	}
+	c.irq.nmi.Store(nmi)
+	c.irq.pending.Store(uint32(l.Pending &^ 1))
+}
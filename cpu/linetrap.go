@@ -0,0 +1,24 @@
+package cpu
+
+// opLineA takes the line 1010 emulator exception, which every opcode
+// starting with $A raises. The opcode is in OpMode.
+func (c *CPU) opLineA(inst *DecodedInstruction) error {
+	return c.lineTrap(VectorLineA, inst.OpMode)
+}
+
+// opLineF takes the line 1111 emulator exception for an opcode starting with
+// $F that no coprocessor or instruction claims. The opcode is in OpMode.
+func (c *CPU) opLineF(inst *DecodedInstruction) error {
+	return c.lineTrap(VectorLineF, inst.OpMode)
+}
+
+// lineTrap calls the host's handler for opcode from LineTraps, or takes the
+// emulator exception with the address of the instruction stacked, so the
+// guest's handler can emulate it and return past it.
+func (c *CPU) lineTrap(vector int, opcode uint16) error {
+	if h := c.LineTraps[opcode]; h != nil {
+		c.Cycles += uint64(exceptionCycles(vector))
+		return h(c)
+	}
+	return c.exception(vector, c.instAddr)
+}
@@ -0,0 +1,138 @@
+package cpu
+
+import "fmt"
+
+// logicOp is the bitwise operation performed by a logical instruction.
+type logicOp func(dst, src uint32) uint32
+
+func and(dst, src uint32) uint32 { return dst & src }
+func or(dst, src uint32) uint32  { return dst | src }
+func eor(dst, src uint32) uint32 { return dst ^ src }
+
+// opAND handles the AND instruction.
+// Format: 1100 <Dn> <opmode> <ea>
+func (c *CPU) opAND(inst *DecodedInstruction) error {
+	return c.logicalRegister("AND", inst, and)
+}
+
+// opOR handles the OR instruction.
+// Format: 1000 <Dn> <opmode> <ea>
+func (c *CPU) opOR(inst *DecodedInstruction) error {
+	return c.logicalRegister("OR", inst, or)
+}
+
+// opEOR handles the EOR instruction. Its only form is Dn to <ea>.
+// Format: 1011 <Dn> 1<size> <ea>
+func (c *CPU) opEOR(inst *DecodedInstruction) error {
+	return c.logicalRegister("EOR", inst, eor)
+}
+
+// logicalRegister performs a logical operation between Dn and <ea>.
+// Bit 2 of the opmode selects the direction:
+// 0: Dn = Dn op <ea>
+// 1: <ea> = <ea> op Dn
+func (c *CPU) logicalRegister(name string, inst *DecodedInstruction, op logicOp) error {
+	// The <ea> is resolved once, so it is read and written at the same place.
+	ea, err := c.ResolveOperand(inst.SrcMode, inst.SrcReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("%s failed to get <ea> operand: %w", name, err)
+	}
+	from, to := ea, Operand{Mode: ModeData, Reg: inst.DstReg, Size: inst.Size}
+	if inst.OpMode&0b100 != 0 {
+		from, to = to, ea
+	}
+	src, dst := c.ReadOperand(from), c.ReadOperand(to)
+
+	result := op(dst, src)
+	c.setFlagsLogical(result, inst.Size)
+
+	if err := c.WriteOperand(to, result); err != nil {
+		return fmt.Errorf("%s failed to put result: %w", name, err)
+	}
+	return nil
+}
+
+// opANDI handles ANDI to <ea>.
+func (c *CPU) opANDI(inst *DecodedInstruction) error {
+	return c.logicalImmediate("ANDI", inst, and)
+}
+
+// opORI handles ORI to <ea>.
+func (c *CPU) opORI(inst *DecodedInstruction) error {
+	return c.logicalImmediate("ORI", inst, or)
+}
+
+// opEORI handles EORI to <ea>.
+func (c *CPU) opEORI(inst *DecodedInstruction) error {
+	return c.logicalImmediate("EORI", inst, eor)
+}
+
+// logicalImmediate performs a logical operation between an immediate and <ea>.
+// Format: 0000 <op> <size> <ea> <immediate>
+func (c *CPU) logicalImmediate(name string, inst *DecodedInstruction, op logicOp) error {
+	// The immediate comes first, before any extension words of the destination.
+	src, err := c.GetOperand(ModeOther, RegImmediate, inst.Size)
+	if err != nil {
+		return fmt.Errorf("%s failed to get immediate: %w", name, err)
+	}
+	ea, err := c.ResolveOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("%s failed to get destination operand: %w", name, err)
+	}
+	dst := c.ReadOperand(ea)
+
+	result := op(dst, src)
+	c.setFlagsLogical(result, inst.Size)
+
+	err = c.WriteOperand(ea, result)
+	if err != nil {
+		return fmt.Errorf("%s failed to put result: %w", name, err)
+	}
+	return nil
+}
+
+// opLogicalSR handles ANDI, ORI and EORI to CCR (byte size) and to SR (word size).
+// The SR forms are privileged. The decoder stores the operation in OpMode.
+func (c *CPU) opLogicalSR(inst *DecodedInstruction) error {
+	if inst.Size == SizeWord && !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	imm, err := c.GetOperand(ModeOther, RegImmediate, inst.Size)
+	if err != nil {
+		return fmt.Errorf("failed to get immediate: %w", err)
+	}
+
+	mask := SR(0xFFFF)
+	if inst.Size == SizeByte {
+		// Only the condition codes can change; keep the system byte.
+		mask = 0x1F
+		if inst.OpMode == OPANDI {
+			imm |= 0xFF00
+		}
+	}
+
+	v := SR(imm) & mask
+	switch inst.OpMode {
+	case OPANDI:
+		c.setSR(c.SR & SR(imm))
+	case OPORI:
+		c.setSR(c.SR | v)
+	case OPEORI:
+		c.setSR(c.SR ^ v)
+	}
+	return nil
+}
+
+// opNOT handles the NOT instruction.
+// Format: 0100 0110 <size> <ea>
+func (c *CPU) opNOT(inst *DecodedInstruction) error {
+	_, err := c.modifyOperand(inst.DstMode, inst.DstReg, inst.Size, func(dst uint32) uint32 {
+		result := ^dst
+		c.setFlagsLogical(result, inst.Size)
+		return result
+	})
+	if err != nil {
+		return fmt.Errorf("NOT failed: %w", err)
+	}
+	return nil
+}
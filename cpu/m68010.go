@@ -0,0 +1,116 @@
+package cpu
+
+import "fmt"
+
+// Control registers MOVEC can reach, numbered as in the register word.
+const (
+	ControlSFC = 0x000
+	ControlDFC = 0x001
+	ControlUSP = 0x800
+	ControlVBR = 0x801
+)
+
+// controlRegister returns a pointer to the control register numbered n, or
+// nil if the model has no such register.
+func (c *CPU) controlRegister(n uint16) *uint32 {
+	switch n {
+	case ControlSFC:
+		return &c.SFC
+	case ControlDFC:
+		return &c.DFC
+	case ControlUSP:
+		return &c.USP
+	case ControlVBR:
+		return &c.VBR
+	}
+	return nil
+}
+
+// opMOVEC handles MOVEC Rc,Rn and MOVEC Rn,Rc, which copy a control register
+// to or from a general register. It is privileged, and an unknown control
+// register takes the illegal instruction exception. OpMode is 1 when the
+// control register is the destination.
+// Format: 0100 1110 0111 101d, then a/d <Rn> <Rc>
+func (c *CPU) opMOVEC(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	rc := c.controlRegister(ext & 0xFFF)
+	if rc == nil {
+		return c.exception(VectorIllegalInstruction, c.instAddr)
+	}
+	rn := &c.D[(ext>>12)&7]
+	if ext&0x8000 != 0 {
+		rn = &c.A[(ext>>12)&7]
+	}
+	if inst.OpMode == 0 {
+		*rn = *rc
+		return nil
+	}
+	*rc = *rn
+	if ext&0xFFF == ControlSFC || ext&0xFFF == ControlDFC {
+		*rc &= 7
+	}
+	return nil
+}
+
+// opMOVES handles MOVES Rn,<ea> and MOVES <ea>,Rn. It is privileged. The bus
+// has a single address space, so the function codes in SFC and DFC don't
+// change where the access goes. A byte or word read into an address
+// register is sign-extended.
+// Format: 0000 1110 <size> <ea>, then a/d <Rn> dr 000 0000 0000
+func (c *CPU) opMOVES(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	reg := (ext >> 12) & 7
+	if ext&0x0800 != 0 {
+		v := c.D[reg]
+		if ext&0x8000 != 0 {
+			v = c.A[reg]
+		}
+		if err := c.PutOperand(inst.DstMode, inst.DstReg, inst.Size, v); err != nil {
+			return fmt.Errorf("MOVES failed to put result: %w", err)
+		}
+		return nil
+	}
+	v, err := c.GetOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("MOVES failed to get operand: %w", err)
+	}
+	if ext&0x8000 == 0 {
+		return c.WriteOperand(Operand{Mode: ModeData, Reg: reg, Size: inst.Size}, v)
+	}
+	switch inst.Size {
+	case SizeByte:
+		v = uint32(int32(int8(v)))
+	case SizeWord:
+		v = uint32(signExtend16(uint16(v)))
+	}
+	c.A[reg] = v
+	return nil
+}
+
+// opRTD handles RTD #d16, which returns from a subroutine and then adds the
+// displacement to the stack pointer to drop the arguments.
+// Format: 0100 1110 0111 0100, then the displacement
+func (c *CPU) opRTD(inst *DecodedInstruction) error {
+	disp := signExtend16(c.ReadU16(c.PC))
+	c.PC = c.pop32()
+	c.A[7] = uint32(int32(c.A[7]) + disp)
+	return nil
+}
+
+// opMOVEfromCCR handles MOVE CCR,<ea>, which stores the condition codes as a
+// word with the system byte clear.
+// Format: 0100 0010 11 <ea>
+func (c *CPU) opMOVEfromCCR(inst *DecodedInstruction) error {
+	if err := c.PutOperand(inst.DstMode, inst.DstReg, SizeWord, uint32(c.SR&0x1F)); err != nil {
+		return fmt.Errorf("MOVE from CCR failed to put result: %w", err)
+	}
+	return nil
+}
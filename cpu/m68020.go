@@ -0,0 +1,418 @@
+package cpu
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Bit field operations, numbered as in bits 10-8 of the opcode.
+const (
+	bfTST = iota
+	bfEXTU
+	bfCHG
+	bfEXTS
+	bfCLR
+	bfFFO
+	bfSET
+	bfINS
+)
+
+// decode68020 selects the handler for the instructions the 68020 added, or
+// returns nil if opcode isn't one of them.
+func (c *CPU) decode68020(opcode uint16, inst *DecodedInstruction) *DecodedInstruction {
+	mode, reg := (opcode>>3)&0x7, opcode&0x7
+	memoryAlterable := mode >= ModeAddrInd && (mode != ModeOther || reg <= RegAbsLong)
+	control := mode == ModeAddrInd || mode == ModeAddrDisp || mode == ModeAddrIndex || mode == ModeOther && reg <= RegPCIndex
+	switch {
+	case opcode&0xFFF8 == OPEXTB:
+		inst.Handler = (*CPU).opEXTB
+	case opcode&0xFFC0 == OPMULL && mode != ModeAddr:
+		inst.Handler = (*CPU).opMULL
+	case opcode&0xFFC0 == OPDIVL && mode != ModeAddr:
+		inst.Handler = (*CPU).opDIVL
+	case opcode&0xFDFF == OPCAS2:
+		inst.Handler = (*CPU).opCAS2
+		inst.Size = SizeWord
+		if opcode&0x0200 != 0 {
+			inst.Size = SizeLong
+		}
+		return inst
+	case opcode&0xF9C0 == OPCAS && opcode&0x0600 != 0 && memoryAlterable:
+		inst.Handler = (*CPU).opCAS
+		inst.Size = sizeFromBits((opcode>>9)&3 - 1)
+	case opcode&0xF1F0 == OPPACK, opcode&0xF1F0 == OPUNPK:
+		inst.Handler = (*CPU).opPACK
+		if opcode&0xF1F0 == OPUNPK {
+			inst.Handler = (*CPU).opUNPK
+		}
+		inst.SrcReg = reg
+		inst.DstReg = (opcode >> 9) & 0x7
+		inst.SrcMode = ModeData
+		if opcode&0x0008 != 0 {
+			inst.SrcMode = ModeAddrPreDec
+		}
+		return inst
+	case opcode&0xF0F8 == OPTRAPcc && reg >= 2 && reg <= 4:
+		inst.Handler = (*CPU).opTRAPcc
+		inst.OpMode = (opcode >> 8) & 0xF
+		inst.SrcReg = reg
+		return inst
+	case opcode&0xF8C0 == OPBitField && (mode == ModeData || control):
+		op := (opcode >> 8) & 0x7
+		writes := op == bfCHG || op == bfCLR || op == bfSET || op == bfINS
+		if writes && mode == ModeOther && reg >= RegPCDisp {
+			return nil
+		}
+		inst.Handler = (*CPU).opBitField
+		inst.OpMode = op
+	default:
+		return nil
+	}
+	inst.DstMode = mode
+	inst.DstReg = reg
+	return inst
+}
+
+// opEXTB handles EXTB.L Dn, which sign-extends the low byte to 32 bits.
+// Format: 0100 1001 1100 0 <Dn>
+func (c *CPU) opEXTB(inst *DecodedInstruction) error {
+	d := &c.D[inst.DstReg]
+	*d = uint32(int8(*d))
+	c.setFlagsLogical(*d, SizeLong)
+	return nil
+}
+
+// opMULL handles MULU.L and MULS.L <ea>,Dl, and the 64-bit forms
+// <ea>,Dh:Dl. The 32-bit form sets V when the product doesn't fit. The
+// 68060 leaves the 64-bit forms to its support package.
+// Format: 0100 1100 00 <ea>, then 0 <Dl> s q 0000 0000 <Dh>
+func (c *CPU) opMULL(inst *DecodedInstruction) error {
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	signed, quad := ext&0x0800 != 0, ext&0x0400 != 0
+	if quad && c.Model >= MC68060 {
+		return c.opUnimplementedInteger(inst)
+	}
+	src, err := c.GetOperand(inst.DstMode, inst.DstReg, SizeLong)
+	if err != nil {
+		return fmt.Errorf("MULL failed to get source operand: %w", err)
+	}
+	dl, dh := (ext>>12)&7, ext&7
+
+	var hi, lo uint32
+	var overflow bool
+	if signed {
+		p := int64(int32(c.D[dl])) * int64(int32(src))
+		hi, lo = uint32(uint64(p)>>32), uint32(p)
+		overflow = p != int64(int32(p))
+	} else {
+		p := uint64(c.D[dl]) * uint64(src)
+		hi, lo = uint32(p>>32), uint32(p)
+		overflow = hi != 0
+	}
+
+	c.SR &^= SRN | SRZ | SRV | SRC
+	if quad {
+		c.D[dh] = hi
+		c.D[dl] = lo
+		if hi&0x80000000 != 0 {
+			c.SR |= SRN
+		}
+		if hi == 0 && lo == 0 {
+			c.SR |= SRZ
+		}
+		return nil
+	}
+	c.D[dl] = lo
+	c.setNZ(lo, SizeLong)
+	if overflow {
+		c.SR |= SRV
+	}
+	return nil
+}
+
+// opDIVL handles DIVU.L and DIVS.L <ea>,Dq, the 64-bit dividend forms
+// <ea>,Dr:Dq, and DIVUL.L and DIVSL.L <ea>,Dr:Dq, which divide 32 bits and
+// keep the remainder. When the quotient doesn't fit, V is set and the
+// registers are left alone. The 68060 leaves the 64-bit forms to its
+// support package.
+// Format: 0100 1100 01 <ea>, then 0 <Dq> s q 0000 0000 <Dr>
+func (c *CPU) opDIVL(inst *DecodedInstruction) error {
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	signed, quad := ext&0x0800 != 0, ext&0x0400 != 0
+	if quad && c.Model >= MC68060 {
+		return c.opUnimplementedInteger(inst)
+	}
+	divisor, err := c.GetOperand(inst.DstMode, inst.DstReg, SizeLong)
+	if err != nil {
+		return fmt.Errorf("DIVL failed to get divisor: %w", err)
+	}
+	if divisor == 0 {
+		c.SR &^= SRC
+		return c.exception(VectorZeroDivide, c.PC)
+	}
+	dq, dr := (ext>>12)&7, ext&7
+
+	var q, r uint32
+	var overflow bool
+	if signed {
+		dividend := int64(int32(c.D[dq]))
+		if quad {
+			dividend = int64(uint64(c.D[dr])<<32 | uint64(c.D[dq]))
+		}
+		d := int64(int32(divisor))
+		if dividend == -1<<63 && d == -1 {
+			overflow = true
+		} else {
+			sq, sr := dividend/d, dividend%d
+			q, r = uint32(sq), uint32(sr)
+			overflow = sq != int64(int32(sq))
+		}
+	} else {
+		dividend := uint64(c.D[dq])
+		if quad {
+			dividend |= uint64(c.D[dr]) << 32
+		}
+		uq, ur := dividend/uint64(divisor), dividend%uint64(divisor)
+		q, r = uint32(uq), uint32(ur)
+		overflow = uq > 0xFFFFFFFF
+	}
+
+	c.SR &^= SRV | SRC
+	if overflow {
+		c.SR |= SRV
+		return nil
+	}
+	if dr != dq {
+		c.D[dr] = r
+	}
+	c.D[dq] = q
+	c.setNZ(q, SizeLong)
+	return nil
+}
+
+// opCAS handles CAS Dc,Du,<ea>. The operand is compared with Dc; if they are
+// equal, Du is written to it, and otherwise it is loaded into Dc. The bus
+// is never shared, so the read-modify-write needs no locking.
+// Format: 0000 1ss0 11 <ea>, then 0000 000 <Du> 000 <Dc>
+func (c *CPU) opCAS(inst *DecodedInstruction) error {
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	o, err := c.ResolveOperand(inst.DstMode, inst.DstReg, inst.Size)
+	if err != nil {
+		return fmt.Errorf("CAS failed to resolve operand: %w", err)
+	}
+	v := c.ReadOperand(o)
+	dc := Operand{Mode: ModeData, Reg: ext & 7, Size: inst.Size}
+	c.compare(c.ReadOperand(dc), v, inst.Size)
+	if c.SR&SRZ != 0 {
+		return c.WriteOperand(o, c.D[(ext>>6)&7])
+	}
+	return c.WriteOperand(dc, v)
+}
+
+// opCAS2 handles CAS2 Dc1:Dc2,Du1:Du2,(Rn1):(Rn2), which compares two
+// operands and updates both only if both match. Otherwise both are loaded
+// into Dc1 and Dc2. The 68060 leaves it to its support package.
+// Format: 0000 1ss0 1111 1100, then two words of D/A <Rn> 000 <Du> 000 <Dc>
+func (c *CPU) opCAS2(inst *DecodedInstruction) error {
+	if c.Model >= MC68060 {
+		return c.opUnimplementedInteger(inst)
+	}
+	ext1, ext2 := c.ReadU16(c.PC), c.ReadU16(c.PC+2)
+	c.PC += 4
+	addr := func(ext uint16) uint32 {
+		if ext&0x8000 != 0 {
+			return c.A[(ext>>12)&7]
+		}
+		return c.D[(ext>>12)&7]
+	}
+	o1 := Operand{Mode: ModeAddrInd, Size: inst.Size, Addr: addr(ext1)}
+	o2 := Operand{Mode: ModeAddrInd, Size: inst.Size, Addr: addr(ext2)}
+	dc1 := Operand{Mode: ModeData, Reg: ext1 & 7, Size: inst.Size}
+	dc2 := Operand{Mode: ModeData, Reg: ext2 & 7, Size: inst.Size}
+	v1, v2 := c.ReadOperand(o1), c.ReadOperand(o2)
+
+	c.compare(c.ReadOperand(dc1), v1, inst.Size)
+	if c.SR&SRZ != 0 {
+		c.compare(c.ReadOperand(dc2), v2, inst.Size)
+	}
+	if c.SR&SRZ != 0 {
+		if err := c.WriteOperand(o1, c.D[(ext1>>6)&7]); err != nil {
+			return err
+		}
+		return c.WriteOperand(o2, c.D[(ext2>>6)&7])
+	}
+	if err := c.WriteOperand(dc1, v1); err != nil {
+		return err
+	}
+	return c.WriteOperand(dc2, v2)
+}
+
+// predecrement steps An down for a byte or word access, by 2 for a byte on
+// A7 to keep the stack aligned, and returns the new address.
+func (c *CPU) predecrement(reg uint16, size Size) uint32 {
+	step := uint32(2)
+	if size == SizeByte && reg != 7 {
+		step = 1
+	}
+	c.A[reg] -= step
+	return c.A[reg]
+}
+
+// opPACK handles PACK Dx,Dy,#adj and PACK -(Ax),-(Ay),#adj. The adjustment
+// is added to two unpacked BCD digits, and their low nibbles are packed
+// into a byte. The flags are not affected.
+// Format: 1000 <Ry> 1010 0 r <Rx>, then the adjustment
+func (c *CPU) opPACK(inst *DecodedInstruction) error {
+	adj := c.ReadU16(c.PC)
+	c.PC += 2
+	if inst.SrcMode == ModeData {
+		v := uint16(c.D[inst.SrcReg]) + adj
+		c.D[inst.DstReg] = c.D[inst.DstReg]&^0xFF | uint32(v>>4&0xF0|v&0x0F)
+		return nil
+	}
+	addr := c.predecrement(inst.SrcReg, SizeWord)
+	v := uint16(c.read8(addr))<<8 | uint16(c.read8(addr+1))
+	v += adj
+	c.write8(c.predecrement(inst.DstReg, SizeByte), byte(v>>4&0xF0|v&0x0F))
+	return nil
+}
+
+// opUNPK handles UNPK Dx,Dy,#adj and UNPK -(Ax),-(Ay),#adj, the reverse of
+// PACK: the two digits of a packed byte are spread into a word, and the
+// adjustment added. The flags are not affected.
+// Format: 1000 <Ry> 1100 0 r <Rx>, then the adjustment
+func (c *CPU) opUNPK(inst *DecodedInstruction) error {
+	adj := c.ReadU16(c.PC)
+	c.PC += 2
+	if inst.SrcMode == ModeData {
+		b := uint16(byte(c.D[inst.SrcReg]))
+		v := (b<<4&0x0F00 | b&0x0F) + adj
+		c.D[inst.DstReg] = c.D[inst.DstReg]&^0xFFFF | uint32(v)
+		return nil
+	}
+	b := uint16(c.read8(c.predecrement(inst.SrcReg, SizeByte)))
+	v := (b<<4&0x0F00 | b&0x0F) + adj
+	addr := c.predecrement(inst.DstReg, SizeWord)
+	c.write8(addr, byte(v>>8))
+	c.write8(addr+1, byte(v))
+	return nil
+}
+
+// opTRAPcc handles TRAPcc, TRAPcc.W #d16 and TRAPcc.L #d32, which take the
+// TRAPV exception if the condition is true. The operand is only there for
+// the handler to read. SrcReg holds the opmode: 2 for a word, 3 for a long
+// and 4 for none.
+// Format: 0101 <cond> 1111 1 <opmode>
+func (c *CPU) opTRAPcc(inst *DecodedInstruction) error {
+	switch inst.SrcReg {
+	case 2:
+		c.PC += 2
+	case 3:
+		c.PC += 4
+	}
+	if !c.TestCondition(inst.OpMode) {
+		return nil
+	}
+	return c.exception(VectorTRAPV, c.PC)
+}
+
+// opBitField handles the bit field instructions BFTST, BFEXTU, BFCHG,
+// BFEXTS, BFCLR, BFFFO, BFSET and BFINS on a data register or memory. Bits
+// are numbered from the most significant, and a field in a register wraps
+// around it. N and Z are set from the field as it was, or from the value
+// inserted by BFINS.
+// Format: 1110 1ooo 11 <ea>, then 0 <Dn> Do <offset> Dw <width>
+func (c *CPU) opBitField(inst *DecodedInstruction) error {
+	ext := c.ReadU16(c.PC)
+	c.PC += 2
+	offset := int32(ext>>6) & 31
+	if ext&0x0800 != 0 {
+		offset = int32(c.D[(ext>>6)&7])
+	}
+	width := uint32(ext) & 31
+	if ext&0x0020 != 0 {
+		width = c.D[ext&7] & 31
+	}
+	if width == 0 {
+		width = 32
+	}
+	mask := ^uint32(0) >> (32 - width)
+	dn := &c.D[(ext>>12)&7]
+
+	// read and write move the field between the operand and the low bits of
+	// a value.
+	var read func() uint32
+	var write func(uint32)
+	if inst.DstMode == ModeData {
+		d := &c.D[inst.DstReg]
+		rot := uint32(offset) & 31
+		read = func() uint32 {
+			return bits.RotateLeft32(*d, int(rot)) >> (32 - width)
+		}
+		write = func(v uint32) {
+			r := bits.RotateLeft32(*d, int(rot))
+			r = r&^(mask<<(32-width)) | (v&mask)<<(32-width)
+			*d = bits.RotateLeft32(r, -int(rot))
+		}
+	} else {
+		base, err := c.effectiveAddress(inst.DstMode, inst.DstReg)
+		if err != nil {
+			return fmt.Errorf("bit field failed to get address: %w", err)
+		}
+		addr := uint32(int32(base) + offset>>3)
+		shift := uint32(offset & 7)
+		n := (shift + width + 7) / 8
+		// The field is at most 5 bytes, held at the top of a 64-bit value.
+		load := func() uint64 {
+			var x uint64
+			for i := range n {
+				x |= uint64(c.read8(addr+i)) << (56 - 8*i)
+			}
+			return x
+		}
+		pos := 64 - shift - width
+		read = func() uint32 {
+			return uint32(load()>>pos) & mask
+		}
+		write = func(v uint32) {
+			x := load()&^(uint64(mask)<<pos) | uint64(v&mask)<<pos
+			for i := range n {
+				c.write8(addr+i, byte(x>>(56-8*i)))
+			}
+		}
+	}
+
+	field := read()
+	if inst.OpMode == bfINS {
+		field = *dn & mask
+	}
+	c.SR &^= SRN | SRZ | SRV | SRC
+	if field>>(width-1)&1 != 0 {
+		c.SR |= SRN
+	}
+	if field == 0 {
+		c.SR |= SRZ
+	}
+
+	switch inst.OpMode {
+	case bfEXTU:
+		*dn = field
+	case bfEXTS:
+		*dn = uint32(int32(field<<(32-width)) >> (32 - width))
+	case bfFFO:
+		lead := uint32(bits.LeadingZeros32(field << (32 - width)))
+		*dn = uint32(offset) + min(lead, width)
+	case bfCHG:
+		write(^field)
+	case bfCLR:
+		write(0)
+	case bfSET:
+		write(mask)
+	case bfINS:
+		write(field)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package cpu
+
+import "fmt"
+
+// Memory gives host code typed access to the CPU's address space, for
+// devices, system calls, debuggers and loaders. The accessors instructions
+// use fault into a bus error exception, which panics outside Execute;
+// Memory's methods return an error instead for any access the bus rejects,
+// including those past the end of RAM, so they are safe anywhere. Addresses
+// are masked by AddressMask and every access goes through Bus, so ROM,
+// devices and holes behave as they do for the guest. Alignment isn't checked.
+// Words and longs are big-endian. Errors wrap the bus's, such as ErrUnmapped.
+type Memory struct {
+	c     *CPU
+	watch bool
+}
+
+// Memory returns accessors whose accesses watchpoints don't see.
+func (c *CPU) Memory() Memory {
+	return Memory{c: c}
+}
+
+// WatchedMemory returns accessors whose accesses watchpoints see like the
+// guest's own, for host code acting for the guest, such as a system call
+// copying a buffer. Inside Execute, e.g. in a HostTraps handler, a
+// watchpoint asking to pause stops the instruction; outside it the watchpoint
+// functions still run, but nothing is paused.
+func (c *CPU) WatchedMemory() Memory {
+	return Memory{c: c, watch: true}
+}
+
+// fail describes a rejected access.
+func (m Memory) fail(addr uint32, write bool, err error) error {
+	if write {
+		return fmt.Errorf("writing $%08X: %w", addr, err)
+	}
+	return fmt.Errorf("reading $%08X: %w", addr, err)
+}
+
+// watched reports an access to the watchpoints, if the accessors are watched.
+func (m Memory) watched(addr, n uint32, write bool, v uint32) {
+	c := m.c
+	if !m.watch || len(c.watches) == 0 {
+		return
+	}
+	inside := c.watching
+	c.checkWatch(addr, n, write, v)
+	if !inside {
+		c.watchHit = nil
+	}
+}
+
+// ReadU8 reads a byte.
+func (m Memory) ReadU8(addr uint32) (uint8, error) {
+	addr &= m.c.AddressMask
+	v, err := m.c.Bus.Read8(addr)
+	if err != nil {
+		return 0, m.fail(addr, false, err)
+	}
+	m.watched(addr, 1, false, uint32(v))
+	return v, nil
+}
+
+// ReadU16 reads a word.
+func (m Memory) ReadU16(addr uint32) (uint16, error) {
+	addr &= m.c.AddressMask
+	v, err := m.c.Bus.Read16(addr)
+	if err != nil {
+		return 0, m.fail(addr, false, err)
+	}
+	m.watched(addr, 2, false, uint32(v))
+	return v, nil
+}
+
+// ReadU32 reads a long.
+func (m Memory) ReadU32(addr uint32) (uint32, error) {
+	addr &= m.c.AddressMask
+	v, err := m.c.Bus.Read32(addr)
+	if err != nil {
+		return 0, m.fail(addr, false, err)
+	}
+	m.watched(addr, 4, false, v)
+	return v, nil
+}
+
+// WriteU8 writes a byte.
+func (m Memory) WriteU8(addr uint32, v uint8) error {
+	addr &= m.c.AddressMask
+	if err := m.c.Bus.Write8(addr, v); err != nil {
+		return m.fail(addr, true, err)
+	}
+	m.watched(addr, 1, true, uint32(v))
+	return nil
+}
+
+// WriteU16 writes a word.
+func (m Memory) WriteU16(addr uint32, v uint16) error {
+	addr &= m.c.AddressMask
+	if err := m.c.Bus.Write16(addr, v); err != nil {
+		return m.fail(addr, true, err)
+	}
+	m.watched(addr, 2, true, uint32(v))
+	return nil
+}
+
+// WriteU32 writes a long.
+func (m Memory) WriteU32(addr uint32, v uint32) error {
+	addr &= m.c.AddressMask
+	if err := m.c.Bus.Write32(addr, v); err != nil {
+		return m.fail(addr, true, err)
+	}
+	m.watched(addr, 4, true, v)
+	return nil
+}
+
+// ReadS8 reads a signed byte.
+func (m Memory) ReadS8(addr uint32) (int8, error) {
+	v, err := m.ReadU8(addr)
+	return int8(v), err
+}
+
+// ReadS16 reads a signed word.
+func (m Memory) ReadS16(addr uint32) (int16, error) {
+	v, err := m.ReadU16(addr)
+	return int16(v), err
+}
+
+// ReadS32 reads a signed long.
+func (m Memory) ReadS32(addr uint32) (int32, error) {
+	v, err := m.ReadU32(addr)
+	return int32(v), err
+}
+
+// WriteS8 writes a signed byte.
+func (m Memory) WriteS8(addr uint32, v int8) error {
+	return m.WriteU8(addr, uint8(v))
+}
+
+// WriteS16 writes a signed word.
+func (m Memory) WriteS16(addr uint32, v int16) error {
+	return m.WriteU16(addr, uint16(v))
+}
+
+// WriteS32 writes a signed long.
+func (m Memory) WriteS32(addr uint32, v int32) error {
+	return m.WriteU32(addr, uint32(v))
+}
+
+// ReadBytes fills b from guest memory at addr, a byte at a time, stopping at
+// the first byte the bus rejects.
+func (m Memory) ReadBytes(addr uint32, b []byte) error {
+	for i := range b {
+		v, err := m.ReadU8(addr + uint32(i))
+		if err != nil {
+			return err
+		}
+		b[i] = v
+	}
+	return nil
+}
+
+// WriteBytes copies b into guest memory at addr, a byte at a time, stopping
+// at the first byte the bus rejects.
+func (m Memory) WriteBytes(addr uint32, b []byte) error {
+	for i, v := range b {
+		if err := m.WriteU8(addr+uint32(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package cpu
+
+//go:generate go run ../cmd/refgen -o ../docs/reference.md
+//go:generate go run ../cmd/refgen -format html -o ../docs/reference.html
+
+// InstructionInfo describes an MC68000 instruction for documentation and tools.
+type InstructionInfo struct {
+	// Mnemonic is the instruction as the manual lists it, e.g. "ADD" or "ANDI to CCR".
+	Mnemonic string
+	// Name is the full name of the operation.
+	Name string
+	// Encoding is the first instruction word, most significant bit first.
+	// Digits are fixed bits and letters are fields, see EncodingFields.
+	Encoding string
+	// Sizes lists the valid operation sizes ("b", "w", "l"); empty if unsized.
+	Sizes string
+	// Flags shows the effect on X, N, Z, V and C: "*" set by the result, "-"
+	// unaffected, "0" cleared, "1" set and "U" undefined.
+	Flags string
+	// Cycles is the 68000 timing of the fastest form, one value per size
+	// separated by slashes. n is a shift count or register count.
+	Cycles string
+	// Example is a sample in assembler syntax. It may branch to "label",
+	// which documentation tools define after it.
+	Example string
+	// Privileged is true for instructions that need supervisor mode.
+	Privileged bool
+}
+
+// EncodingFields explains the letters used in InstructionInfo.Encoding.
+var EncodingFields = map[byte]string{
+	'c': "condition",
+	'd': "direction",
+	'e': "effective address register",
+	'i': "count is an immediate (0) or a register (1)",
+	'k': "8-bit displacement",
+	'm': "effective address mode",
+	'M': "destination effective address mode",
+	'n': "shift count or register",
+	'o': "operation mode",
+	'q': "immediate data",
+	'r': "register",
+	'R': "destination register",
+	's': "size",
+	't': "register (0) or memory (1) operands",
+	'v': "vector",
+	'y': "second register",
+}
+
+// InstructionSet lists the MC68000 instructions in alphabetical order.
+var InstructionSet = []InstructionInfo{
+	{Mnemonic: "ABCD", Name: "Add Decimal with Extend", Encoding: "1100rrr10000tyyy", Sizes: "b", Flags: "*U*U*", Cycles: "6", Example: "abcd d1,d0"},
+	{Mnemonic: "ADD", Name: "Add", Encoding: "1101rrrooommmeee", Sizes: "bwl", Flags: "*****", Cycles: "4/4/8", Example: "add.w d1,d0"},
+	{Mnemonic: "ADDA", Name: "Add Address", Encoding: "1101rrrs11mmmeee", Sizes: "wl", Flags: "-----", Cycles: "8/8", Example: "adda.l d0,a0"},
+	{Mnemonic: "ADDI", Name: "Add Immediate", Encoding: "00000110ssmmmeee", Sizes: "bwl", Flags: "*****", Cycles: "8/8/16", Example: "addi.w #$100,d0"},
+	{Mnemonic: "ADDQ", Name: "Add Quick", Encoding: "0101qqq0ssmmmeee", Sizes: "bwl", Flags: "*****", Cycles: "4/4/8", Example: "addq.l #1,d0"},
+	{Mnemonic: "ADDX", Name: "Add with Extend", Encoding: "1101rrr1ss00tyyy", Sizes: "bwl", Flags: "*****", Cycles: "4/4/8", Example: "addx.l d1,d0"},
+	{Mnemonic: "AND", Name: "Logical AND", Encoding: "1100rrrooommmeee", Sizes: "bwl", Flags: "-**00", Cycles: "4/4/8", Example: "and.w d1,d0"},
+	{Mnemonic: "ANDI", Name: "Logical AND Immediate", Encoding: "00000010ssmmmeee", Sizes: "bwl", Flags: "-**00", Cycles: "8/8/16", Example: "andi.b #$0f,d0"},
+	{Mnemonic: "ANDI to CCR", Name: "AND Immediate to Condition Code Register", Encoding: "0000001000111100", Sizes: "b", Flags: "*****", Cycles: "20", Example: "andi #$1f,ccr"},
+	{Mnemonic: "ANDI to SR", Name: "AND Immediate to Status Register", Encoding: "0000001001111100", Sizes: "w", Flags: "*****", Cycles: "20", Example: "andi #$2700,sr", Privileged: true},
+	{Mnemonic: "ASL, ASR", Name: "Arithmetic Shift Left and Right", Encoding: "1110nnndssi00yyy", Sizes: "bwl", Flags: "*****", Cycles: "6+2n/6+2n/8+2n", Example: "asl.w #2,d0"},
+	{Mnemonic: "Bcc", Name: "Branch Conditionally", Encoding: "0110cccckkkkkkkk", Sizes: "", Flags: "-----", Cycles: "10", Example: "bne label"},
+	{Mnemonic: "BCHG", Name: "Test Bit and Change", Encoding: "0000rrr101mmmeee", Sizes: "bl", Flags: "--*--", Cycles: "8", Example: "bchg d1,d0"},
+	{Mnemonic: "BCLR", Name: "Test Bit and Clear", Encoding: "0000rrr110mmmeee", Sizes: "bl", Flags: "--*--", Cycles: "10", Example: "bclr d1,d0"},
+	{Mnemonic: "BRA", Name: "Branch", Encoding: "01100000kkkkkkkk", Sizes: "", Flags: "-----", Cycles: "10", Example: "bra label"},
+	{Mnemonic: "BSET", Name: "Test Bit and Set", Encoding: "0000rrr111mmmeee", Sizes: "bl", Flags: "--*--", Cycles: "8", Example: "bset d1,d0"},
+	{Mnemonic: "BSR", Name: "Branch to Subroutine", Encoding: "01100001kkkkkkkk", Sizes: "", Flags: "-----", Cycles: "18", Example: "bsr label"},
+	{Mnemonic: "BTST", Name: "Test Bit", Encoding: "0000rrr100mmmeee", Sizes: "bl", Flags: "--*--", Cycles: "6", Example: "btst d1,d0"},
+	{Mnemonic: "CHK", Name: "Check Register Against Bound", Encoding: "0100rrr110mmmeee", Sizes: "w", Flags: "-*UUU", Cycles: "10", Example: "chk d1,d0"},
+	{Mnemonic: "CLR", Name: "Clear", Encoding: "01000010ssmmmeee", Sizes: "bwl", Flags: "-0100", Cycles: "4/4/6", Example: "clr.l d0"},
+	{Mnemonic: "CMP", Name: "Compare", Encoding: "1011rrrooommmeee", Sizes: "bwl", Flags: "-****", Cycles: "4/4/6", Example: "cmp.w d1,d0"},
+	{Mnemonic: "CMPA", Name: "Compare Address", Encoding: "1011rrrs11mmmeee", Sizes: "wl", Flags: "-****", Cycles: "6/6", Example: "cmpa.l d0,a0"},
+	{Mnemonic: "CMPI", Name: "Compare Immediate", Encoding: "00001100ssmmmeee", Sizes: "bwl", Flags: "-****", Cycles: "8/8/14", Example: "cmpi.w #10,d0"},
+	{Mnemonic: "CMPM", Name: "Compare Memory to Memory", Encoding: "1011rrr1ss001yyy", Sizes: "bwl", Flags: "-****", Cycles: "12/12/20", Example: "cmpm.b (a0)+,(a1)+"},
+	{Mnemonic: "DBcc", Name: "Test Condition, Decrement, and Branch", Encoding: "0101cccc11001rrr", Sizes: "w", Flags: "-----", Cycles: "10", Example: "dbra d0,label"},
+	{Mnemonic: "DIVS", Name: "Signed Divide", Encoding: "1000rrr111mmmeee", Sizes: "w", Flags: "-***0", Cycles: "158", Example: "divs d1,d0"},
+	{Mnemonic: "DIVU", Name: "Unsigned Divide", Encoding: "1000rrr011mmmeee", Sizes: "w", Flags: "-***0", Cycles: "140", Example: "divu d1,d0"},
+	{Mnemonic: "EOR", Name: "Logical Exclusive-OR", Encoding: "1011rrrooommmeee", Sizes: "bwl", Flags: "-**00", Cycles: "4/4/8", Example: "eor.w d1,d0"},
+	{Mnemonic: "EORI", Name: "Logical Exclusive-OR Immediate", Encoding: "00001010ssmmmeee", Sizes: "bwl", Flags: "-**00", Cycles: "8/8/16", Example: "eori.w #$ff,d0"},
+	{Mnemonic: "EORI to CCR", Name: "Exclusive-OR Immediate to Condition Code Register", Encoding: "0000101000111100", Sizes: "b", Flags: "*****", Cycles: "20", Example: "eori #$1f,ccr"},
+	{Mnemonic: "EORI to SR", Name: "Exclusive-OR Immediate to Status Register", Encoding: "0000101001111100", Sizes: "w", Flags: "*****", Cycles: "20", Example: "eori #$2000,sr", Privileged: true},
+	{Mnemonic: "EXG", Name: "Exchange Registers", Encoding: "1100rrr1oooooyyy", Sizes: "l", Flags: "-----", Cycles: "6", Example: "exg d0,d1"},
+	{Mnemonic: "EXT", Name: "Sign Extend", Encoding: "0100100ooo000rrr", Sizes: "wl", Flags: "-**00", Cycles: "4", Example: "ext.w d0"},
+	{Mnemonic: "ILLEGAL", Name: "Take Illegal Instruction Trap", Encoding: "0100101011111100", Sizes: "", Flags: "-----", Cycles: "34", Example: "illegal"},
+	{Mnemonic: "JMP", Name: "Jump", Encoding: "0100111011mmmeee", Sizes: "", Flags: "-----", Cycles: "8", Example: "jmp (a0)"},
+	{Mnemonic: "JSR", Name: "Jump to Subroutine", Encoding: "0100111010mmmeee", Sizes: "", Flags: "-----", Cycles: "16", Example: "jsr (a0)"},
+	{Mnemonic: "LEA", Name: "Load Effective Address", Encoding: "0100rrr111mmmeee", Sizes: "l", Flags: "-----", Cycles: "4", Example: "lea (a0),a1"},
+	{Mnemonic: "LINK", Name: "Link and Allocate", Encoding: "0100111001010rrr", Sizes: "", Flags: "-----", Cycles: "16", Example: "link a6,#-8"},
+	{Mnemonic: "LSL, LSR", Name: "Logical Shift Left and Right", Encoding: "1110nnndssi01yyy", Sizes: "bwl", Flags: "***0*", Cycles: "6+2n/6+2n/8+2n", Example: "lsr.l #1,d0"},
+	{Mnemonic: "MOVE", Name: "Move Data from Source to Destination", Encoding: "00ssRRRMMMmmmeee", Sizes: "bwl", Flags: "-**00", Cycles: "4/4/4", Example: "move.l d1,d0"},
+	{Mnemonic: "MOVEA", Name: "Move Address", Encoding: "00ssrrr001mmmeee", Sizes: "wl", Flags: "-----", Cycles: "4/4", Example: "movea.l d0,a0"},
+	{Mnemonic: "MOVE to CCR", Name: "Move to Condition Code Register", Encoding: "0100010011mmmeee", Sizes: "w", Flags: "*****", Cycles: "12", Example: "move #$1f,ccr"},
+	{Mnemonic: "MOVE to SR", Name: "Move to the Status Register", Encoding: "0100011011mmmeee", Sizes: "w", Flags: "*****", Cycles: "12", Example: "move #$2700,sr", Privileged: true},
+	{Mnemonic: "MOVE from SR", Name: "Move from the Status Register", Encoding: "0100000011mmmeee", Sizes: "w", Flags: "-----", Cycles: "6", Example: "move sr,d0"},
+	{Mnemonic: "MOVE USP", Name: "Move User Stack Pointer", Encoding: "010011100110drrr", Sizes: "l", Flags: "-----", Cycles: "4", Example: "move usp,a0", Privileged: true},
+	{Mnemonic: "MOVEM", Name: "Move Multiple Registers", Encoding: "01001d001smmmeee", Sizes: "wl", Flags: "-----", Cycles: "8+4n/8+8n", Example: "movem.l d0-d2,-(a7)"},
+	{Mnemonic: "MOVEP", Name: "Move Peripheral Data", Encoding: "0000rrrooo001yyy", Sizes: "wl", Flags: "-----", Cycles: "16/24", Example: "movep.w 2(a0),d0"},
+	{Mnemonic: "MOVEQ", Name: "Move Quick", Encoding: "0111rrr0qqqqqqqq", Sizes: "l", Flags: "-**00", Cycles: "4", Example: "moveq #1,d0"},
+	{Mnemonic: "MULS", Name: "Signed Multiply", Encoding: "1100rrr111mmmeee", Sizes: "w", Flags: "-**00", Cycles: "70", Example: "muls d1,d0"},
+	{Mnemonic: "MULU", Name: "Unsigned Multiply", Encoding: "1100rrr011mmmeee", Sizes: "w", Flags: "-**00", Cycles: "70", Example: "mulu d1,d0"},
+	{Mnemonic: "NBCD", Name: "Negate Decimal with Extend", Encoding: "0100100000mmmeee", Sizes: "b", Flags: "*U*U*", Cycles: "6", Example: "nbcd d0"},
+	{Mnemonic: "NEG", Name: "Negate", Encoding: "01000100ssmmmeee", Sizes: "bwl", Flags: "*****", Cycles: "4/4/6", Example: "neg.l d0"},
+	{Mnemonic: "NEGX", Name: "Negate with Extend", Encoding: "01000000ssmmmeee", Sizes: "bwl", Flags: "*****", Cycles: "4/4/6", Example: "negx.l d0"},
+	{Mnemonic: "NOP", Name: "No Operation", Encoding: "0100111001110001", Sizes: "", Flags: "-----", Cycles: "4", Example: "nop"},
+	{Mnemonic: "NOT", Name: "Logical Complement", Encoding: "01000110ssmmmeee", Sizes: "bwl", Flags: "-**00", Cycles: "4/4/6", Example: "not.b d0"},
+	{Mnemonic: "OR", Name: "Logical Inclusive-OR", Encoding: "1000rrrooommmeee", Sizes: "bwl", Flags: "-**00", Cycles: "4/4/8", Example: "or.w d1,d0"},
+	{Mnemonic: "ORI", Name: "Logical Inclusive-OR Immediate", Encoding: "00000000ssmmmeee", Sizes: "bwl", Flags: "-**00", Cycles: "8/8/16", Example: "ori.w #$8000,d0"},
+	{Mnemonic: "ORI to CCR", Name: "Inclusive-OR Immediate to Condition Code Register", Encoding: "0000000000111100", Sizes: "b", Flags: "*****", Cycles: "20", Example: "ori #$10,ccr"},
+	{Mnemonic: "ORI to SR", Name: "Inclusive-OR Immediate to Status Register", Encoding: "0000000001111100", Sizes: "w", Flags: "*****", Cycles: "20", Example: "ori #$0700,sr", Privileged: true},
+	{Mnemonic: "PEA", Name: "Push Effective Address", Encoding: "0100100001mmmeee", Sizes: "l", Flags: "-----", Cycles: "12", Example: "pea (a0)"},
+	{Mnemonic: "RESET", Name: "Reset External Devices", Encoding: "0100111001110000", Sizes: "", Flags: "-----", Cycles: "132", Example: "reset", Privileged: true},
+	{Mnemonic: "ROL, ROR", Name: "Rotate Left and Right", Encoding: "1110nnndssi11yyy", Sizes: "bwl", Flags: "-**0*", Cycles: "6+2n/6+2n/8+2n", Example: "rol.w #4,d0"},
+	{Mnemonic: "ROXL, ROXR", Name: "Rotate with Extend Left and Right", Encoding: "1110nnndssi10yyy", Sizes: "bwl", Flags: "***0*", Cycles: "6+2n/6+2n/8+2n", Example: "roxl.l #1,d0"},
+	{Mnemonic: "RTE", Name: "Return from Exception", Encoding: "0100111001110011", Sizes: "", Flags: "*****", Cycles: "20", Example: "rte", Privileged: true},
+	{Mnemonic: "RTR", Name: "Return and Restore Condition Codes", Encoding: "0100111001110111", Sizes: "", Flags: "*****", Cycles: "20", Example: "rtr"},
+	{Mnemonic: "RTS", Name: "Return from Subroutine", Encoding: "0100111001110101", Sizes: "", Flags: "-----", Cycles: "16", Example: "rts"},
+	{Mnemonic: "SBCD", Name: "Subtract Decimal with Extend", Encoding: "1000rrr10000tyyy", Sizes: "b", Flags: "*U*U*", Cycles: "6", Example: "sbcd d1,d0"},
+	{Mnemonic: "Scc", Name: "Set According to Condition", Encoding: "0101cccc11mmmeee", Sizes: "b", Flags: "-----", Cycles: "4", Example: "seq d0"},
+	{Mnemonic: "STOP", Name: "Load Status Register and Stop", Encoding: "0100111001110010", Sizes: "", Flags: "*****", Cycles: "4", Example: "stop #$2700", Privileged: true},
+	{Mnemonic: "SUB", Name: "Subtract", Encoding: "1001rrrooommmeee", Sizes: "bwl", Flags: "*****", Cycles: "4/4/8", Example: "sub.w d1,d0"},
+	{Mnemonic: "SUBA", Name: "Subtract Address", Encoding: "1001rrrs11mmmeee", Sizes: "wl", Flags: "-----", Cycles: "8/8", Example: "suba.l d0,a0"},
+	{Mnemonic: "SUBI", Name: "Subtract Immediate", Encoding: "00000100ssmmmeee", Sizes: "bwl", Flags: "*****", Cycles: "8/8/16", Example: "subi.w #$100,d0"},
+	{Mnemonic: "SUBQ", Name: "Subtract Quick", Encoding: "0101qqq1ssmmmeee", Sizes: "bwl", Flags: "*****", Cycles: "4/4/8", Example: "subq.l #1,d0"},
+	{Mnemonic: "SUBX", Name: "Subtract with Extend", Encoding: "1001rrr1ss00tyyy", Sizes: "bwl", Flags: "*****", Cycles: "4/4/8", Example: "subx.l d1,d0"},
+	{Mnemonic: "SWAP", Name: "Swap Register Halves", Encoding: "0100100001000rrr", Sizes: "w", Flags: "-**00", Cycles: "4", Example: "swap d0"},
+	{Mnemonic: "TAS", Name: "Test and Set an Operand", Encoding: "0100101011mmmeee", Sizes: "b", Flags: "-**00", Cycles: "4", Example: "tas d0"},
+	{Mnemonic: "TRAP", Name: "Trap", Encoding: "010011100100vvvv", Sizes: "", Flags: "-----", Cycles: "34", Example: "trap #15"},
+	{Mnemonic: "TRAPV", Name: "Trap on Overflow", Encoding: "0100111001110110", Sizes: "", Flags: "-----", Cycles: "4", Example: "trapv"},
+	{Mnemonic: "TST", Name: "Test an Operand", Encoding: "01001010ssmmmeee", Sizes: "bwl", Flags: "-**00", Cycles: "4/4/4", Example: "tst.w d0"},
+	{Mnemonic: "UNLK", Name: "Unlink", Encoding: "0100111001011rrr", Sizes: "", Flags: "-----", Cycles: "12", Example: "unlk a6"},
+}
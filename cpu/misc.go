@@ -0,0 +1,128 @@
+package cpu
+
+import "fmt"
+
+// decodeSingle handles the single-operand group: NEGX, CLR, NEG, NOT and TST.
+// Format: 0100 <op> <size> <ea>
+func (c *CPU) decodeSingle(opcode uint16, inst *DecodedInstruction, handler func(*CPU, *DecodedInstruction) error) (*DecodedInstruction, error) {
+	inst.Handler = handler
+	inst.Size = sizeFromBits(opcode >> 6)
+	inst.DstMode = (opcode >> 3) & 0x7
+	inst.DstReg = opcode & 0x7
+	if inst.DstMode == ModeAddr {
+		return nil, fmt.Errorf("address register is not a valid destination in opcode %04X", opcode)
+	}
+	return inst, nil
+}
+
+// decodeExg handles EXG. OpMode holds the exchange mode.
+// Format: 1100 <Rx> 1 <opmode> <Ry>
+func (c *CPU) decodeExg(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	inst.OpMode = (opcode >> 3) & 0x1F
+	switch inst.OpMode {
+	case exgData, exgAddr, exgDataAddr:
+	default:
+		return nil, fmt.Errorf("invalid EXG mode in opcode %04X", opcode)
+	}
+	inst.Handler = (*CPU).opEXG
+	inst.SrcReg = (opcode >> 9) & 0x7
+	inst.DstReg = opcode & 0x7
+	return inst, nil
+}
+
+// EXG operation modes.
+const (
+	exgData     = 0b01000 // Dx and Dy
+	exgAddr     = 0b01001 // Ax and Ay
+	exgDataAddr = 0b10001 // Dx and Ay
+)
+
+// opCLR handles the CLR instruction. N, V and C are cleared and Z is set.
+// Format: 0100 0010 <size> <ea>
+func (c *CPU) opCLR(inst *DecodedInstruction) error {
+	if err := c.PutOperand(inst.DstMode, inst.DstReg, inst.Size, 0); err != nil {
+		return fmt.Errorf("CLR failed to put result: %w", err)
+	}
+	c.SR = c.SR&^(SRN|SRV|SRC) | SRZ
+	return nil
+}
+
+// opNEG handles the NEG instruction, subtracting the operand from zero.
+// Format: 0100 0100 <size> <ea>
+func (c *CPU) opNEG(inst *DecodedInstruction) error {
+	_, err := c.modifyOperand(inst.DstMode, inst.DstReg, inst.Size, func(dst uint32) uint32 {
+		result := -dst
+		c.setFlagsSub(dst, 0, result, inst.Size)
+		return result
+	})
+	if err != nil {
+		return fmt.Errorf("NEG failed: %w", err)
+	}
+	return nil
+}
+
+// opNEGX handles the NEGX instruction, subtracting the operand and X from zero.
+// Z is only cleared, never set, so multi-precision negation can test the whole value.
+// Format: 0100 0000 <size> <ea>
+func (c *CPU) opNEGX(inst *DecodedInstruction) error {
+	z := c.SR & SRZ
+	_, err := c.modifyOperand(inst.DstMode, inst.DstReg, inst.Size, func(dst uint32) uint32 {
+		result := -dst
+		if c.SR&SRX != 0 {
+			result--
+		}
+		c.setFlagsSub(dst, 0, result, inst.Size)
+		if c.SR&SRZ != 0 {
+			c.SR = c.SR&^SRZ | z
+		}
+		return result
+	})
+	if err != nil {
+		return fmt.Errorf("NEGX failed: %w", err)
+	}
+	return nil
+}
+
+// opEXT handles the EXT instruction. A word size extends the low byte into the
+// low word, and a long size extends the low word into the whole register.
+// Format: 0100 1000 1<size> 000 <Dn>
+func (c *CPU) opEXT(inst *DecodedInstruction) error {
+	d := &c.D[inst.DstReg]
+	if inst.Size == SizeWord {
+		*d = *d&0xFFFF0000 | uint32(uint16(int8(*d)))
+	} else {
+		*d = uint32(int16(*d))
+	}
+	c.setFlagsLogical(*d, inst.Size)
+	return nil
+}
+
+// opSWAP handles the SWAP instruction, exchanging the halves of a data register.
+// Format: 0100 1000 0100 0 <Dn>
+func (c *CPU) opSWAP(inst *DecodedInstruction) error {
+	d := &c.D[inst.DstReg]
+	*d = *d<<16 | *d>>16
+	c.setFlagsLogical(*d, SizeLong)
+	return nil
+}
+
+// opEXG handles the EXG instruction. The flags are not affected.
+func (c *CPU) opEXG(inst *DecodedInstruction) error {
+	var x, y *uint32
+	switch inst.OpMode {
+	case exgData:
+		x, y = &c.D[inst.SrcReg], &c.D[inst.DstReg]
+	case exgAddr:
+		x, y = &c.A[inst.SrcReg], &c.A[inst.DstReg]
+	default:
+		x, y = &c.D[inst.SrcReg], &c.A[inst.DstReg]
+	}
+	*x, *y = *y, *x
+	return nil
+}
+
+// opNOP handles NOP, which does nothing.
+// Format: 0100 1110 0111 0001
+func (c *CPU) opNOP(inst *DecodedInstruction) error {
+	return nil
+}
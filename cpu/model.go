@@ -0,0 +1,83 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Model selects which member of the 68000 family the CPU behaves as. The
+// values are the part numbers, so later models compare greater.
+type Model int
+
+const (
+	// MC68000 is the default. The zero Model behaves as one too.
+	MC68000 Model = 68000
+	// MC68010 adds the vector base register and the SFC and DFC function
+	// code registers, reached with MOVEC, and MOVES, RTD and MOVE from CCR.
+	// MOVE from SR becomes privileged, and exception frames end with a format
+	// and vector word, which RTE checks. Its loop mode only saves time on
+	// real hardware, and cycles follow the 68000's timings, so it has no
+	// effect here. Later models have all of this too.
+	MC68010 Model = 68010
+	// MC68020 adds the full extension word formats of the indexed
+	// addressing modes, for An and the PC alike: a scale of 1, 2, 4 or 8 on
+	// the index, word and long base displacements, suppressed base and index
+	// registers, and memory indirection, pre- or post-indexed. Its new
+	// instructions are EXTB.L, 32- and 64-bit MULS, MULU, DIVS and DIVU, the
+	// BFxxx bit field group, CAS, CAS2, PACK, UNPK and TRAPcc. Bus and
+	// address errors stack its short format $A frame. Cycles still follow
+	// the 68000's timings. SetModel gives it all 32 address lines, and
+	// setting CPU.AddressMask to Address24 afterwards makes a 68EC020.
+	// Coprocessors, such as a 68881 from NewFPU, are attached with
+	// CPU.SetCoprocessor.
+	MC68020 Model = 68020
+	// MC68040 adds MOVE16. Unless an FPU is attached as coprocessor 1,
+	// floating-point instructions take the line 1111 exception, as on the
+	// 68LC040, for a software package to emulate.
+	MC68040 Model = 68040
+	// MC68060 is a 68040 that also traps MOVEP, CAS2 and the 64-bit
+	// multiplies and divides through the unimplemented integer instruction
+	// vector, for the 68060 support package.
+	MC68060 Model = 68060
+)
+
+// models maps the names ParseModel accepts to models.
+var models = map[string]Model{
+	"68000": MC68000,
+	"68010": MC68010,
+	"68020": MC68020,
+	"68040": MC68040,
+	"68060": MC68060,
+}
+
+// String returns the model's part number, e.g. "68040".
+func (m Model) String() string {
+	if m == 0 {
+		m = MC68000
+	}
+	return fmt.Sprintf("%d", int(m))
+}
+
+// ParseModel returns the model with the given part number, with or without
+// the MC prefix: "68040" or "mc68040".
+func ParseModel(name string) (Model, error) {
+	m, ok := models[strings.TrimPrefix(strings.ToLower(name), "mc")]
+	if !ok {
+		return 0, fmt.Errorf("unknown CPU model %q (want 68000, 68010, 68020, 68040 or 68060)", name)
+	}
+	return m, nil
+}
+
+// SetModel changes the model and flushes the instruction cache, since the
+// same opcode may decode differently. It also sets AddressMask to the
+// model's address bus: Address24 for the 68000 and 68010 and Address32 for
+// the 68020 and later. Set AddressMask after it for a part with a different
+// bus, such as the 24-bit 68EC020.
+func (c *CPU) SetModel(m Model) {
+	c.Model = m
+	c.AddressMask = Address24
+	if m >= MC68020 {
+		c.AddressMask = Address32
+	}
+	c.FlushCache()
+}
@@ -0,0 +1,81 @@
+package cpu
+
+// Forms of MOVE16, in DecodedInstruction.OpMode. The first four are the
+// opmode field of the absolute forms.
+const (
+	move16PostIncToAbs = iota // MOVE16 (Ay)+,(xxx).L
+	move16AbsToPostInc        // MOVE16 (xxx).L,(Ay)+
+	move16IndToAbs            // MOVE16 (Ay),(xxx).L
+	move16AbsToInd            // MOVE16 (xxx).L,(Ay)
+	move16PostInc             // MOVE16 (Ax)+,(Ay)+
+)
+
+// decodeLineF handles the line 1111 opcodes of the 68040 and 68060. MOVE16
+// is executed; everything else, the FPU instructions included, takes the line
+// 1111 exception.
+func (c *CPU) decodeLineF(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	switch {
+	case opcode&0xFFF8 == OPMOVE16PostInc:
+		inst.Handler = (*CPU).opMOVE16
+		inst.OpMode = move16PostInc
+		inst.SrcReg = opcode & 0x7
+	case opcode&0xFFE0 == OPMOVE16:
+		inst.Handler = (*CPU).opMOVE16
+		inst.OpMode = (opcode >> 3) & 0x3
+		inst.SrcReg = opcode & 0x7
+	default:
+		inst.Handler = (*CPU).opLineF
+		inst.OpMode = opcode
+	}
+	return inst, nil
+}
+
+// opMOVE16 handles MOVE16, which copies a 16-byte line. Both addresses are
+// rounded down to a multiple of 16, and a postincremented register advances
+// by 16. The (Ax)+,(Ay)+ form has Ay in an extension word; the others are
+// followed by the absolute address.
+func (c *CPU) opMOVE16(inst *DecodedInstruction) error {
+	var src, dst uint32
+	var inc []uint16 // Registers to postincrement
+	ay := inst.SrcReg
+	if inst.OpMode == move16PostInc {
+		ext := c.ReadU16(c.PC)
+		c.PC += 2
+		ax := inst.SrcReg
+		ay = (ext >> 12) & 0x7
+		src, dst = c.A[ax], c.A[ay]
+		inc = []uint16{ax, ay}
+	} else {
+		abs := c.ReadU32(c.PC)
+		c.PC += 4
+		switch inst.OpMode {
+		case move16PostIncToAbs, move16IndToAbs:
+			src, dst = c.A[ay], abs
+		default:
+			src, dst = abs, c.A[ay]
+		}
+		if inst.OpMode <= move16AbsToPostInc {
+			inc = []uint16{ay}
+		}
+	}
+
+	var line [4]uint32
+	src &^= 0xF
+	dst &^= 0xF
+	for i := range line {
+		line[i] = c.ReadU32(src + uint32(i)*4)
+	}
+	for i, v := range line {
+		c.WriteU32(dst+uint32(i)*4, v)
+	}
+	for _, r := range inc {
+		c.A[r] += 16
+	}
+	return nil
+}
+
+// opUnimplementedInteger takes the unimplemented integer instruction
+// exception of the 68060, with the address of the instruction stacked.
+func (c *CPU) opUnimplementedInteger(inst *DecodedInstruction) error {
+	return c.exception(VectorUnimplementedInteger, c.instAddr)
+}
@@ -0,0 +1,114 @@
+package cpu
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// decodeMovem handles MOVEM. Bit 10 of the opcode is the direction (1 = memory
+// to registers), stored in OpMode, and bit 6 the size.
+// Format: 0100 1d00 1s <ea> <register mask>
+func (c *CPU) decodeMovem(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	inst.Handler = (*CPU).opMOVEM
+	inst.OpMode = (opcode >> 10) & 1
+	inst.Size = SizeWord
+	if opcode&0x0040 != 0 {
+		inst.Size = SizeLong
+	}
+	inst.DstMode = (opcode >> 3) & 0x7
+	inst.DstReg = opcode & 0x7
+
+	// Registers to memory needs a control alterable <ea> or -(An); memory to
+	// registers a control <ea> or (An)+.
+	var valid bool
+	switch inst.DstMode {
+	case ModeAddrInd, ModeAddrDisp, ModeAddrIndex:
+		valid = true
+	case ModeAddrPreDec:
+		valid = inst.OpMode == 0
+	case ModeAddrPostInc:
+		valid = inst.OpMode == 1
+	case ModeOther:
+		valid = inst.DstReg <= RegAbsLong || inst.OpMode == 1 && inst.DstReg <= RegPCIndex
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown or unimplemented instruction: %04X", opcode)
+	}
+	return inst, nil
+}
+
+// movemRegister returns register i of a MOVEM mask: D0-D7, then A0-A7.
+func (c *CPU) movemRegister(i int) *uint32 {
+	if i < 8 {
+		return &c.D[i]
+	}
+	return &c.A[i-8]
+}
+
+// opMOVEM moves the registers in the mask to or from consecutive memory,
+// lowest register at the lowest address. For -(An) the mask is reversed
+// (A7 in bit 0) and registers are stored from A7 down. Words loaded into
+// registers are sign-extended to 32 bits.
+func (c *CPU) opMOVEM(inst *DecodedInstruction) error {
+	m, err := c.GetOperand(ModeOther, RegImmediate, SizeWord)
+	if err != nil {
+		return fmt.Errorf("MOVEM failed to get register mask: %w", err)
+	}
+	mask := uint16(m)
+	step := uint32(inst.Size.Bytes())
+
+	var addr uint32
+	switch inst.DstMode {
+	case ModeAddrPreDec, ModeAddrPostInc:
+		addr = c.A[inst.DstReg]
+	default:
+		addr, err = c.effectiveAddress(inst.DstMode, inst.DstReg)
+		if err != nil {
+			return fmt.Errorf("MOVEM failed to get address: %w", err)
+		}
+	}
+
+	count := bits.OnesCount16(mask)
+	c.Cycles += uint64(count) * 2 * uint64(step) // 4 per word, 8 per long
+
+	if inst.DstMode == ModeAddrPreDec {
+		// The 68000 stores the initial value of An if it is in the list.
+		for i := range 16 {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			addr -= step
+			c.putMovem(addr, *c.movemRegister(15 - i), inst.Size)
+		}
+		c.A[inst.DstReg] = addr
+		return nil
+	}
+
+	for i := range 16 {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		reg := c.movemRegister(i)
+		if inst.OpMode == 0 {
+			c.putMovem(addr, *reg, inst.Size)
+		} else if inst.Size == SizeWord {
+			*reg = uint32(signExtend16(c.ReadU16(addr)))
+		} else {
+			*reg = c.ReadU32(addr)
+		}
+		addr += step
+	}
+	if inst.DstMode == ModeAddrPostInc {
+		c.A[inst.DstReg] = addr
+	}
+	return nil
+}
+
+// putMovem stores one register for MOVEM.
+func (c *CPU) putMovem(addr, v uint32, size Size) {
+	if size == SizeWord {
+		c.WriteU16(addr, uint16(v))
+	} else {
+		c.WriteU32(addr, v)
+	}
+}
@@ -0,0 +1,33 @@
+package cpu
+
+// opMOVEP handles MOVEP, which moves a data register to or from every other
+// byte of memory, high-order byte first, for 8-bit peripherals on one half of
+// the data bus. The displacement word follows the opcode, and the condition
+// codes are unaffected. A word read only replaces the low word of Dn.
+// Format: 0000 <Dn> 1 <dir> <size> 001 <An>
+func (c *CPU) opMOVEP(inst *DecodedInstruction) error {
+	addr := uint32(int32(c.A[inst.SrcReg]) + signExtend16(c.ReadU16(c.PC)))
+	c.PC += 2
+
+	n := 2
+	if inst.Size == SizeLong {
+		n = 4
+	}
+	if inst.OpMode&0b10 != 0 { // Register to memory
+		v := c.D[inst.DstReg]
+		for i := range n {
+			c.write8(addr+uint32(i)*2, byte(v>>((n-1-i)*8)))
+		}
+		return nil
+	}
+
+	var v uint32
+	for i := range n {
+		v = v<<8 | uint32(c.read8(addr+uint32(i)*2))
+	}
+	if n == 2 {
+		v |= c.D[inst.DstReg] &^ 0xFFFF
+	}
+	c.D[inst.DstReg] = v
+	return nil
+}
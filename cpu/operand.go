@@ -0,0 +1,128 @@
+package cpu
+
+import "fmt"
+
+// Operand is an effective address resolved once. Reads and writes through it
+// go to the same register or memory address, so a read-modify-write
+// instruction such as ADD to (A0)+ or NOT (d16,An) consumes its extension
+// words and steps (An)+ or -(An) a single time.
+type Operand struct {
+	// Mode and Reg are the <ea> fields.
+	Mode, Reg uint16
+	// Size is the operand size.
+	Size Size
+	// Addr is the address of a memory operand, or the value of an immediate.
+	Addr uint32
+}
+
+// ResolveOperand resolves an <ea>, consuming its extension words. (An)+ and
+// -(An) step by the operand size, and by 2 for bytes on A7. Immediates are read
+// here, so they come before any later operand's extension words.
+func (c *CPU) ResolveOperand(mode, reg uint16, size Size) (Operand, error) {
+	o := Operand{Mode: mode, Reg: reg, Size: size}
+	if size != SizeByte && size != SizeWord && size != SizeLong {
+		return o, fmt.Errorf("invalid operand size %d", size)
+	}
+	switch {
+	case mode == ModeData, mode == ModeAddr:
+		return o, nil
+	case mode == ModeOther && reg == RegImmediate:
+		switch size {
+		case SizeByte:
+			// Byte immediates are stored as a word, high byte is ignored
+			o.Addr = uint32(c.ReadU16(c.PC) & 0xFF)
+			c.PC += 2
+		case SizeWord:
+			o.Addr = uint32(c.ReadU16(c.PC))
+			c.PC += 2
+		case SizeLong:
+			o.Addr = c.ReadU32(c.PC)
+			c.PC += 4
+		}
+		return o, nil
+	}
+	addr, err := c.memoryAddress(mode, reg, size)
+	if err != nil {
+		return o, err
+	}
+	o.Addr = addr
+	return o, nil
+}
+
+// IsMemory reports whether the operand is in memory.
+func (o Operand) IsMemory() bool {
+	return o.Mode != ModeData && o.Mode != ModeAddr && !o.isImmediate()
+}
+
+func (o Operand) isImmediate() bool {
+	return o.Mode == ModeOther && o.Reg == RegImmediate
+}
+
+// ReadOperand reads a resolved operand. Only the low byte or word of a
+// register is returned for those sizes.
+func (c *CPU) ReadOperand(o Operand) uint32 {
+	var v uint32
+	switch {
+	case o.Mode == ModeData:
+		v = c.D[o.Reg]
+	case o.Mode == ModeAddr:
+		v = c.A[o.Reg]
+	case o.isImmediate():
+		return o.Addr
+	default:
+		switch o.Size {
+		case SizeByte:
+			return uint32(c.read8(o.Addr))
+		case SizeWord:
+			return uint32(c.ReadU16(o.Addr))
+		default:
+			return c.ReadU32(o.Addr)
+		}
+	}
+	switch o.Size {
+	case SizeByte:
+		return v & 0xFF
+	case SizeWord:
+		return v & 0xFFFF
+	}
+	return v
+}
+
+// WriteOperand writes a resolved operand. Byte and word writes to a data
+// register leave its upper bits alone, while a word written to an address
+// register is sign-extended. Immediates and PC-relative operands can't be
+// written.
+func (c *CPU) WriteOperand(o Operand, value uint32) error {
+	switch {
+	case o.Mode == ModeData:
+		switch o.Size {
+		case SizeByte:
+			c.D[o.Reg] = (c.D[o.Reg] & 0xFFFFFF00) | (value & 0xFF)
+		case SizeWord:
+			c.D[o.Reg] = (c.D[o.Reg] & 0xFFFF0000) | (value & 0xFFFF)
+		default:
+			c.D[o.Reg] = value
+		}
+	case o.Mode == ModeAddr:
+		switch o.Size {
+		case SizeByte:
+			return fmt.Errorf("invalid size .B for put operand to A%d", o.Reg)
+		case SizeWord:
+			c.A[o.Reg] = uint32(signExtend16(uint16(value)))
+		default:
+			c.A[o.Reg] = value
+		}
+	case o.Mode == ModeOther && o.Reg != RegAbsShort && o.Reg != RegAbsLong:
+		return fmt.Errorf("invalid destination addressing sub-mode %d for mode %d", o.Reg, o.Mode)
+	default:
+		switch o.Size {
+		case SizeByte:
+			c.write8(o.Addr, byte(value))
+		case SizeWord:
+			c.WriteU16(o.Addr, uint16(value))
+		default:
+			c.WriteU32(o.Addr, value)
+		}
+	}
+	return nil
+}
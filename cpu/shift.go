@@ -0,0 +1,175 @@
+package cpu
+
+import "fmt"
+
+// Shift and rotate kinds, from bits 4-3 of the register form and bits 10-9 of
+// the memory form.
+const (
+	shiftAS  = 0b00 // ASL, ASR
+	shiftLS  = 0b01 // LSL, LSR
+	shiftROX = 0b10 // ROXL, ROXR
+	shiftRO  = 0b11 // ROL, ROR
+)
+
+// decodeShift handles the shift and rotate instructions in the 1110 group.
+// The decoder stores the kind in OpMode bits 1-0 and the direction in bit 2
+// (1 = left). A count in a data register is marked by SrcMode = ModeData;
+// otherwise SrcReg holds the count itself.
+func (c *CPU) decodeShift(opcode uint16, inst *DecodedInstruction) (*DecodedInstruction, error) {
+	left := (opcode >> 8) & 1
+	if (opcode>>6)&0b11 == 0b11 {
+		// Memory form: 1110 0<kind> <dir> 11 <ea>, always a word shifted by one.
+		if opcode&0x0800 != 0 {
+			return nil, fmt.Errorf("unknown or unimplemented instruction: %04X", opcode)
+		}
+		inst.Handler = (*CPU).opShiftMemory
+		inst.OpMode = (opcode>>9)&0b11 | left<<2
+		inst.Size = SizeWord
+		inst.SrcMode = ModeOther
+		inst.SrcReg = 1
+		inst.DstMode = (opcode >> 3) & 0x7
+		inst.DstReg = opcode & 0x7
+		alterable := inst.DstMode >= ModeAddrInd && (inst.DstMode != ModeOther || inst.DstReg <= RegAbsLong)
+		if !alterable {
+			return nil, fmt.Errorf("unknown or unimplemented instruction: %04X", opcode)
+		}
+		return inst, nil
+	}
+
+	// Register form: 1110 <count/reg> <dir> <size> <i/r> <kind> <Dn>
+	inst.Handler = (*CPU).opShiftRegister
+	inst.OpMode = (opcode>>3)&0b11 | left<<2
+	inst.Size = sizeFromBits(opcode >> 6)
+	inst.DstMode = ModeData
+	inst.DstReg = opcode & 0x7
+	count := (opcode >> 9) & 0x7
+	if (opcode>>5)&1 == 1 {
+		inst.SrcMode = ModeData
+		inst.SrcReg = count
+	} else {
+		inst.SrcMode = ModeOther
+		if count == 0 {
+			count = 8
+		}
+		inst.SrcReg = count
+	}
+	return inst, nil
+}
+
+// opShiftRegister handles ASd, LSd, ROXd and ROd on a data register. A count
+// in a register is taken modulo 64.
+func (c *CPU) opShiftRegister(inst *DecodedInstruction) error {
+	count := uint32(inst.SrcReg)
+	if inst.SrcMode == ModeData {
+		count = c.D[inst.SrcReg] % 64
+	}
+	c.Cycles += uint64(2 * count)
+
+	value := c.D[inst.DstReg]
+	result := c.shift(inst.OpMode, value, count, inst.Size)
+	switch inst.Size {
+	case SizeByte:
+		c.D[inst.DstReg] = value&0xFFFFFF00 | result
+	case SizeWord:
+		c.D[inst.DstReg] = value&0xFFFF0000 | result
+	default:
+		c.D[inst.DstReg] = result
+	}
+	return nil
+}
+
+// opShiftMemory handles the memory forms, which shift a word in memory by one bit.
+func (c *CPU) opShiftMemory(inst *DecodedInstruction) error {
+	addr, err := c.memoryAddress(inst.DstMode, inst.DstReg, SizeWord)
+	if err != nil {
+		return fmt.Errorf("shift failed to get address: %w", err)
+	}
+
+	result := c.shift(inst.OpMode, uint32(c.ReadU16(addr)), 1, SizeWord)
+	c.WriteU16(addr, uint16(result))
+	return nil
+}
+
+// shift shifts or rotates value by count bits and sets the flags. op holds the
+// kind in bits 1-0 and the direction in bit 2, as stored by decodeShift.
+func (c *CPU) shift(op uint16, value, count uint32, size Size) uint32 {
+	var msb, mask uint32
+	switch size {
+	case SizeByte:
+		msb, mask = 0x80, 0xFF
+	case SizeWord:
+		msb, mask = 0x8000, 0xFFFF
+	default:
+		msb, mask = 0x80000000, 0xFFFFFFFF
+	}
+
+	kind := op & 0b11
+	left := op&0b100 != 0
+	value &= mask
+	x := c.SR&SRX != 0
+	carry, overflow := false, false
+	for range count {
+		if left {
+			carry = value&msb != 0
+			value = (value << 1) & mask
+			switch kind {
+			case shiftAS:
+				// V is set if the sign bit changes at any time during the shift.
+				if (value&msb != 0) != carry {
+					overflow = true
+				}
+			case shiftROX:
+				if x {
+					value |= 1
+				}
+			case shiftRO:
+				if carry {
+					value |= 1
+				}
+			}
+		} else {
+			carry = value&1 != 0
+			sign := value & msb
+			value >>= 1
+			switch kind {
+			case shiftAS:
+				value |= sign
+			case shiftROX:
+				if x {
+					value |= msb
+				}
+			case shiftRO:
+				if carry {
+					value |= msb
+				}
+			}
+		}
+		if kind != shiftRO {
+			x = carry
+		}
+	}
+
+	c.SR &^= SRN | SRZ | SRV | SRC
+	c.setNZ(value, size)
+	if overflow {
+		c.SR |= SRV
+	}
+	switch {
+	case count == 0:
+		// Only ROXd copies X into C when nothing is shifted; the others clear C.
+		if kind == shiftROX && x {
+			c.SR |= SRC
+		}
+	case kind == shiftRO:
+		// Plain rotates leave X alone.
+		if carry {
+			c.SR |= SRC
+		}
+	default:
+		c.SR &^= SRX
+		if carry {
+			c.SR |= SRC | SRX
+		}
+	}
+	return value
+}
@@ -0,0 +1,57 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SR is the value of the status register: the system byte (trace, supervisor,
+// interrupt mask) and the condition code register.
+type SR uint16
+
+// Supervisor reports whether the S bit is set.
+func (s SR) Supervisor() bool {
+	return s&SRS != 0
+}
+
+// Trace reports whether the T bit is set.
+func (s SR) Trace() bool {
+	return s&SRT != 0
+}
+
+// IntMask returns the interrupt priority mask (0-7).
+func (s SR) IntMask() int {
+	return int(s&SRI) >> 8
+}
+
+// CCR returns the condition code register, the low byte of SR.
+func (s SR) CCR() uint8 {
+	return uint8(s & 0x1F)
+}
+
+// FlagString returns the condition codes as "XNZVC", with a dash for each clear flag.
+func (s SR) FlagString() string {
+	flags := []byte("XNZVC")
+	for i, bit := range []SR{SRX, SRN, SRZ, SRV, SRC} {
+		if s&bit == 0 {
+			flags[i] = '-'
+		}
+	}
+	return string(flags)
+}
+
+// String formats the register as hex followed by its decoded state,
+// e.g. "2704 S I7 --Z--". A set trace bit adds a leading "T".
+func (s SR) String() string {
+	parts := []string{fmt.Sprintf("%04X", uint16(s))}
+	if s.Trace() {
+		parts = append(parts, "T")
+	}
+	if s.Supervisor() {
+		parts = append(parts, "S")
+	} else {
+		parts = append(parts, "U")
+	}
+	parts = append(parts, fmt.Sprintf("I%d", s.IntMask()), s.FlagString())
+	return strings.Join(parts, " ")
+}
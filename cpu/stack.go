@@ -0,0 +1,72 @@
+package cpu
+
+import "fmt"
+
+// push16 pushes a word onto the stack at A7.
+func (c *CPU) push16(v uint16) {
+	c.A[7] -= 2
+	c.WriteU16(c.A[7], v)
+}
+
+// push32 pushes a long onto the stack at A7.
+func (c *CPU) push32(v uint32) {
+	c.A[7] -= 4
+	c.WriteU32(c.A[7], v)
+}
+
+// pop16 pops a word from the stack at A7.
+func (c *CPU) pop16() uint16 {
+	v := c.ReadU16(c.A[7])
+	c.A[7] += 2
+	return v
+}
+
+// pop32 pops a long from the stack at A7.
+func (c *CPU) pop32() uint32 {
+	v := c.ReadU32(c.A[7])
+	c.A[7] += 4
+	return v
+}
+
+// opLEA handles the LEA (Load Effective Address) instruction.
+// Format: 0100 <An> 111 <ea>
+func (c *CPU) opLEA(inst *DecodedInstruction) error {
+	addr, err := c.effectiveAddress(inst.SrcMode, inst.SrcReg)
+	if err != nil {
+		return fmt.Errorf("LEA failed: %w", err)
+	}
+	c.A[inst.DstReg] = addr
+	return nil
+}
+
+// opPEA handles the PEA (Push Effective Address) instruction.
+// Format: 0100 1000 01 <ea>
+func (c *CPU) opPEA(inst *DecodedInstruction) error {
+	addr, err := c.effectiveAddress(inst.SrcMode, inst.SrcReg)
+	if err != nil {
+		return fmt.Errorf("PEA failed: %w", err)
+	}
+	c.push32(addr)
+	return nil
+}
+
+// opLINK handles the LINK instruction: push An, point An at it, then add the
+// displacement to the stack pointer to allocate a frame.
+// Format: 0100 1110 0101 0 <An> <16-bit displacement>
+func (c *CPU) opLINK(inst *DecodedInstruction) error {
+	displacement := signExtend16(c.ReadU16(c.PC))
+	c.PC += 2
+
+	c.push32(c.A[inst.DstReg])
+	c.A[inst.DstReg] = c.A[7]
+	c.A[7] = uint32(int32(c.A[7]) + displacement)
+	return nil
+}
+
+// opUNLK handles the UNLK instruction, undoing LINK.
+// Format: 0100 1110 0101 1 <An>
+func (c *CPU) opUNLK(inst *DecodedInstruction) error {
+	c.A[7] = c.A[inst.DstReg]
+	c.A[inst.DstReg] = c.pop32()
+	return nil
+}
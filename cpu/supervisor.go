@@ -0,0 +1,128 @@
+package cpu
+
+import "fmt"
+
+// setSR replaces the status register, switching between the user and supervisor
+// stacks when the S bit changes. A7 is always the active stack pointer; the
+// inactive one is kept in USP or SSP.
+func (c *CPU) setSR(sr SR) {
+	sr &= 0xA71F // Unused SR bits always read as zero.
+	switch {
+	case c.SR.Supervisor() && !sr.Supervisor():
+		c.SSP = c.A[7]
+		c.A[7] = c.USP
+	case !c.SR.Supervisor() && sr.Supervisor():
+		c.USP = c.A[7]
+		c.A[7] = c.SSP
+	}
+	c.SR = sr
+}
+
+// StackPointers returns the user and supervisor stack pointers, taking the
+// active one from A7.
+func (c *CPU) StackPointers() (usp, ssp uint32) {
+	if c.SR.Supervisor() {
+		return c.USP, c.A[7]
+	}
+	return c.A[7], c.SSP
+}
+
+// opMOVEtoSR handles MOVE <ea>,SR. It is privileged.
+// Format: 0100 0110 11 <ea>
+func (c *CPU) opMOVEtoSR(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	v, err := c.GetOperand(inst.SrcMode, inst.SrcReg, SizeWord)
+	if err != nil {
+		return fmt.Errorf("MOVE to SR failed to get operand: %w", err)
+	}
+	c.setSR(SR(v))
+	return nil
+}
+
+// opMOVEtoCCR handles MOVE <ea>,CCR. Only the low byte of the word is used.
+// Format: 0100 0100 11 <ea>
+func (c *CPU) opMOVEtoCCR(inst *DecodedInstruction) error {
+	v, err := c.GetOperand(inst.SrcMode, inst.SrcReg, SizeWord)
+	if err != nil {
+		return fmt.Errorf("MOVE to CCR failed to get operand: %w", err)
+	}
+	c.SR = c.SR&0xFF00 | SR(v&0x1F)
+	return nil
+}
+
+// opMOVEfromSR handles MOVE SR,<ea>, which the 68000 allows in user mode and
+// later models make privileged.
+// Format: 0100 0000 11 <ea>
+func (c *CPU) opMOVEfromSR(inst *DecodedInstruction) error {
+	if c.Model >= MC68010 && !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	if err := c.PutOperand(inst.DstMode, inst.DstReg, SizeWord, uint32(c.SR)); err != nil {
+		return fmt.Errorf("MOVE from SR failed to put result: %w", err)
+	}
+	return nil
+}
+
+// opMOVEUSP handles MOVE An,USP and MOVE USP,An. It is privileged. OpMode is 1
+// when the USP is the source.
+// Format: 0100 1110 0110 d <An>
+func (c *CPU) opMOVEUSP(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	if inst.OpMode == 1 {
+		c.A[inst.DstReg] = c.USP
+	} else {
+		c.USP = c.A[inst.DstReg]
+	}
+	return nil
+}
+
+// opRTE handles RTE, popping SR and then PC from the supervisor stack. It is
+// privileged. On the 68010 and later it also pops the format word and the
+// rest of the frame it describes; an unknown format takes the format error
+// exception with the stack untouched.
+func (c *CPU) opRTE(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	sp := c.A[7]
+	sr := SR(c.pop16())
+	pc := c.pop32()
+	if c.Model >= MC68010 {
+		extra, ok := frameExtra[c.pop16()>>12]
+		if !ok {
+			c.A[7] = sp
+			return c.exception(VectorFormatError, c.instAddr)
+		}
+		c.A[7] += extra
+	}
+	c.PC = pc
+	c.setSR(sr)
+	return nil
+}
+
+// opRESET handles RESET. It is privileged. The emulated machine has no external
+// devices to reset, so it does nothing else.
+func (c *CPU) opRESET(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	return nil
+}
+
+// opSTOP handles STOP #imm, loading SR and stopping the CPU until an interrupt
+// above the new mask or a reset. It is privileged.
+// Format: 0100 1110 0111 0010 <immediate>
+func (c *CPU) opSTOP(inst *DecodedInstruction) error {
+	if !c.SR.Supervisor() {
+		return c.privilegeViolation()
+	}
+	imm := c.ReadU16(c.PC)
+	c.PC += 2
+	c.setSR(SR(imm))
+	c.Stopped = true
+	return nil
+}
@@ -0,0 +1,32 @@
+package cpu
+
+// traced reports whether an exception raised by an instruction is followed by
+// a trace exception when the instruction ran with T set. Traps and the
+// arithmetic exceptions are; illegal instructions, privilege violations and bus
+// and address errors are not.
+func traced(vector int) bool {
+	switch {
+	case vector == VectorZeroDivide, vector == VectorCHK, vector == VectorTRAPV:
+		return true
+	case vector >= VectorTrap0 && vector < VectorTrap0+16:
+		return true
+	}
+	return false
+}
+
+// trace takes the trace exception after an instruction that started with T set.
+// taken is the number of exceptions recorded before the instruction ran. If the
+// instruction raised a trap, the trace frame holds the trap handler's address,
+// so a debugger sees the handler before its first instruction runs.
+func (c *CPU) trace(taken uint64) error {
+	if !c.Running {
+		return nil
+	}
+	if c.exceptionStats.taken != taken {
+		if last, _ := c.LastException(); !traced(last.Vector) {
+			return nil
+		}
+	}
+	c.Stopped = false
+	return c.exception(VectorTrace, c.PC)
+}
@@ -1,18 +1,21 @@
 package cpu
 
-// opTRAP handles the TRAP instruction.
+// opTRAP handles the TRAP instruction, taking the exception through vector
+// VectorTrap0+n, or calling the host's handler from HostTraps. Without a host
+// handler, TRAP #15 is reserved by the VM to halt the machine.
 // Format: 0100 1110 0100 <vector>
 func (c *CPU) opTRAP(inst *DecodedInstruction) error {
-	// The trap vector is stored in the lower 4 bits of the opcode.
-	// The decoder will place it in the DstReg field for us.
-	vector := inst.DstReg
-	println("TRAP instruction invoked with vector:", vector)
-	// We'll use TRAP #15 as a special instruction to halt the VM.
-	if vector == 15 {
+	// The decoder places the vector number in DstReg.
+	n := int(inst.DstReg)
+	if h := c.HostTraps[n]; h != nil {
+		c.Cycles += uint64(exceptionCycles(VectorTrap0 + n))
+		return h(c)
+	}
+	if n == 15 {
+		c.Cycles += uint64(exceptionCycles(VectorTrap0 + n))
+		c.recordException(ExceptionRecord{Vector: VectorTrap0 + n, Address: c.instAddr, PC: c.PC, SR: c.SR})
 		c.Running = false
+		return nil
 	}
-
-	// In a full OS, other TRAP vectors would trigger exceptions
-	// and call system routines. For now, we just halt on #15.
-	return nil
+	return c.exception(VectorTrap0+n, c.PC)
 }
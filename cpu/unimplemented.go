@@ -0,0 +1,49 @@
+package cpu
+
+import "fmt"
+
+// UnimplementedPolicy chooses what Execute does with an opcode the emulator
+// can't decode, whether the real chip lacks it or the emulator does. Opcodes
+// starting with $A or $F always decode, taking their emulator exceptions as
+// the real chip does (see CPU.LineTraps).
+type UnimplementedPolicy int
+
+const (
+	// UnimplementedError stops Execute with an *ExecError, leaving PC at the
+	// opcode. It is the default.
+	UnimplementedError UnimplementedPolicy = iota
+	// UnimplementedException takes the illegal instruction exception, as a
+	// real 68000 would, so the guest's handler decides.
+	UnimplementedException
+	// UnimplementedCallback calls CPU.OnUnimplemented, which may emulate
+	// the instruction. Without a callback, or when it declines, the
+	// exception is taken as for UnimplementedException.
+	UnimplementedCallback
+)
+
+// String names the policy.
+func (p UnimplementedPolicy) String() string {
+	switch p {
+	case UnimplementedError:
+		return "error"
+	case UnimplementedException:
+		return "exception"
+	case UnimplementedCallback:
+		return "callback"
+	}
+	return fmt.Sprintf("UnimplementedPolicy(%d)", int(p))
+}
+
+// opUnimplemented stands in for an opcode that didn't decode, under a policy
+// other than UnimplementedError. The opcode is in OpMode.
+func (c *CPU) opUnimplemented(inst *DecodedInstruction) error {
+	opcode := inst.OpMode
+	if c.Unimplemented == UnimplementedCallback && c.OnUnimplemented != nil {
+		handled, err := c.OnUnimplemented(c, opcode)
+		if err != nil || handled {
+			return err
+		}
+		c.PC = c.instAddr + 2
+	}
+	return c.exception(VectorIllegalInstruction, c.instAddr)
+}
@@ -1,25 +1,98 @@
 package cpu
 
-import "encoding/binary"
+// busFault is raised by the memory accessors when the bus rejects an access,
+// or for a word or long access at an odd address when alignment is checked.
+// Execute recovers it and takes a bus or address error exception.
+type busFault struct {
+	addr  uint32
+	write bool
+	odd   bool
+}
+
+// checkAlignment panics with a busFault if a word or long is misaligned while an
+// instruction runs in strict mode.
+func (c *CPU) checkAlignment(addr, n uint32, write bool) {
+	if c.checkAlign && n > 1 && addr&1 != 0 {
+		panic(busFault{addr: addr, write: write, odd: true})
+	}
+}
+
+// read8 reads a byte from memory.
+func (c *CPU) read8(addr uint32) byte {
+	addr &= c.AddressMask
+	v, err := c.Bus.Read8(addr)
+	if err != nil {
+		panic(busFault{addr: addr})
+	}
+	if c.watching {
+		c.checkWatch(addr, 1, false, uint32(v))
+	}
+	return v
+}
+
+// write8 writes a byte to memory.
+func (c *CPU) write8(addr uint32, val byte) {
+	addr &= c.AddressMask
+	if err := c.Bus.Write8(addr, val); err != nil {
+		panic(busFault{addr: addr, write: true})
+	}
+	if c.watching {
+		c.checkWatch(addr, 1, true, uint32(val))
+	}
+}
 
 // ReadU16 reads a big-endian 16-bit word from memory at the given address.
+// Like the other accessors instructions use, it panics outside Execute if the
+// bus rejects the access; host code should use Memory.
 func (c *CPU) ReadU16(addr uint32) uint16 {
-	return binary.BigEndian.Uint16(c.Mem[addr:])
+	addr &= c.AddressMask
+	c.checkAlignment(addr, 2, false)
+	v, err := c.Bus.Read16(addr)
+	if err != nil {
+		panic(busFault{addr: addr})
+	}
+	if c.watching {
+		c.checkWatch(addr, 2, false, uint32(v))
+	}
+	return v
 }
 
 // WriteU16 writes a 16-bit word to memory at the given address in big-endian format.
 func (c *CPU) WriteU16(addr uint32, val uint16) {
-	binary.BigEndian.PutUint16(c.Mem[addr:], val)
+	addr &= c.AddressMask
+	c.checkAlignment(addr, 2, true)
+	if err := c.Bus.Write16(addr, val); err != nil {
+		panic(busFault{addr: addr, write: true})
+	}
+	if c.watching {
+		c.checkWatch(addr, 2, true, uint32(val))
+	}
 }
 
 // ReadU32 reads a big-endian 32-bit long word from memory at the given address.
 func (c *CPU) ReadU32(addr uint32) uint32 {
-	return binary.BigEndian.Uint32(c.Mem[addr:])
+	addr &= c.AddressMask
+	c.checkAlignment(addr, 4, false)
+	v, err := c.Bus.Read32(addr)
+	if err != nil {
+		panic(busFault{addr: addr})
+	}
+	if c.watching {
+		c.checkWatch(addr, 4, false, v)
+	}
+	return v
 }
 
 // WriteU32 writes a 32-bit long word to memory at the given address in big-endian format.
 func (c *CPU) WriteU32(addr uint32, val uint32) {
-	binary.BigEndian.PutUint32(c.Mem[addr:], val)
+	addr &= c.AddressMask
+	c.checkAlignment(addr, 4, true)
+	if err := c.Bus.Write32(addr, val); err != nil {
+		panic(busFault{addr: addr, write: true})
+	}
+	if c.watching {
+		c.checkWatch(addr, 4, true, val)
+	}
 }
 
 // setNZ updates the N and Z flags in the SR based on a value and operation size.
@@ -102,3 +175,49 @@ func (c *CPU) setFlagsArith(src, dst, result uint32, size Size) {
 		c.SR |= SRV
 	}
 }
+
+// setFlagsSub sets the C, V, N, Z, and X flags for dst - src.
+// Carry is a borrow out of the most significant bit.
+func (c *CPU) setFlagsSub(src, dst, result uint32, size Size) {
+	c.SR &^= (SRX | SRN | SRZ | SRV | SRC)
+
+	var msbMask, signMask uint32
+	switch size {
+	case SizeByte:
+		msbMask, signMask = 0x80, 0xFF
+	case SizeWord:
+		msbMask, signMask = 0x8000, 0xFFFF
+	case SizeLong:
+		msbMask, signMask = 0x80000000, 0xFFFFFFFF
+	}
+
+	s := src & msbMask
+	d := dst & msbMask
+	r := result & msbMask
+
+	if (result & signMask) == 0 {
+		c.SR |= SRZ
+	}
+	if r != 0 {
+		c.SR |= SRN
+	}
+
+	// Borrow (C): the source was larger than the destination, as unsigned values.
+	if (s&^d)|(r&^d)|(s&r) != 0 {
+		c.SR |= SRC
+		c.SR |= SRX // Extend flag is always set with Carry
+	}
+
+	// Overflow (V): the operands had different signs and the result's sign
+	// differs from the destination's.
+	if s != d && r != d {
+		c.SR |= SRV
+	}
+}
+
+// setFlagsLogical sets N and Z from the result and clears V and C, as every
+// logical operation does. X is not affected.
+func (c *CPU) setFlagsLogical(result uint32, size Size) {
+	c.SR &^= (SRV | SRC)
+	c.setNZ(result, size)
+}
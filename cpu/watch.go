@@ -0,0 +1,123 @@
+package cpu
+
+import "fmt"
+
+// WatchKind selects the accesses a watchpoint fires on.
+type WatchKind int
+
+const (
+	// WatchRead fires when an instruction reads the range.
+	WatchRead WatchKind = 1 << iota
+	// WatchWrite fires when an instruction writes the range.
+	WatchWrite
+	// WatchAccess fires on reads and writes.
+	WatchAccess = WatchRead | WatchWrite
+)
+
+// String returns "read", "write" or "access".
+func (k WatchKind) String() string {
+	switch k {
+	case WatchRead:
+		return "read"
+	case WatchWrite:
+		return "write"
+	case WatchAccess:
+		return "access"
+	}
+	return fmt.Sprintf("WatchKind(%d)", int(k))
+}
+
+// WatchEvent describes an access that hit a watchpoint.
+type WatchEvent struct {
+	// ID is the watchpoint's, as returned by AddWatchpoint.
+	ID int
+	// PC is the address of the instruction making the access.
+	PC uint32
+	// Addr and Size give the access, which may cover more than the watched range.
+	Addr uint32
+	Size int
+	// Write is set for writes.
+	Write bool
+	// Value is the data read or written.
+	Value uint32
+}
+
+// String describes the access, e.g. "write.w $1234 to $00001000 at $00000400".
+func (e WatchEvent) String() string {
+	op, dir := "read", "from"
+	if e.Write {
+		op, dir = "write", "to"
+	}
+	size := map[int]string{1: "b", 2: "w", 4: "l"}[e.Size]
+	return fmt.Sprintf("%s.%s $%0*X %s $%08X at $%08X", op, size, e.Size*2, e.Value, dir, e.Addr, e.PC)
+}
+
+// WatchFunc is called for every access that hits a watchpoint, after the
+// access. Returning true pauses execution once the instruction completes.
+type WatchFunc func(c *CPU, e WatchEvent) bool
+
+// WatchpointHit is returned by Execute after an instruction whose access to a
+// watched range paused execution. The instruction has completed and PC points
+// at the next one, so calling Execute again continues.
+type WatchpointHit struct {
+	WatchEvent
+}
+
+// Error describes the watchpoint hit.
+func (h *WatchpointHit) Error() string {
+	return fmt.Sprintf("watchpoint %d: %s", h.ID, h.WatchEvent)
+}
+
+// watchpoint is a watched range.
+type watchpoint struct {
+	id   int
+	addr uint32
+	size uint32
+	kind WatchKind
+	fn   WatchFunc
+}
+
+// AddWatchpoint watches size bytes from addr for the accesses instructions
+// make, and returns an ID for RemoveWatchpoint. fn is called for each hit; a
+// nil fn pauses on every hit. Instruction fetches, vector reads and the host
+// accessors used outside Execute don't trigger watchpoints. They may not be
+// added or removed while another goroutine runs the CPU.
+func (c *CPU) AddWatchpoint(addr, size uint32, kind WatchKind, fn WatchFunc) int {
+	c.watchID++
+	c.watches = append(c.watches, watchpoint{id: c.watchID, addr: addr & c.AddressMask, size: max(size, 1), kind: kind, fn: fn})
+	return c.watchID
+}
+
+// RemoveWatchpoint removes a watchpoint. It reports whether the ID existed.
+func (c *CPU) RemoveWatchpoint(id int) bool {
+	for i, w := range c.watches {
+		if w.id == id {
+			c.watches = append(c.watches[:i], c.watches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearWatchpoints removes every watchpoint.
+func (c *CPU) ClearWatchpoints() {
+	c.watches = nil
+}
+
+// checkWatch runs the watchpoints covering an access the current instruction
+// made, remembering the first that asks to pause.
+func (c *CPU) checkWatch(addr, n uint32, write bool, value uint32) {
+	kind := WatchRead
+	if write {
+		kind = WatchWrite
+	}
+	for _, w := range c.watches {
+		if w.kind&kind == 0 || uint64(addr)+uint64(n) <= uint64(w.addr) || uint64(addr) >= uint64(w.addr)+uint64(w.size) {
+			continue
+		}
+		e := WatchEvent{ID: w.id, PC: c.instAddr, Addr: addr, Size: int(n), Write: write, Value: value}
+		if (w.fn == nil || w.fn(c, e)) && c.watchHit == nil {
+			c.watchHit = &WatchpointHit{e}
+		}
+	}
+}
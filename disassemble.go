@@ -0,0 +1,32 @@
+package m68k
+
+import "github.com/Urethramancer/m68k/disassembler"
+
+// Disassemble lists code as assembly source that reassembles to the same
+// bytes, following control flow from its start to tell code from data.
+func Disassemble(code []byte) (string, error) {
+	return disassembler.Disassemble(code)
+}
+
+// Instruction is one decoded instruction.
+type Instruction struct {
+	// Address is where the instruction starts.
+	Address uint32
+	// Size is its length in bytes.
+	Size int
+	// Mnemonic includes the size suffix, such as "move.l".
+	Mnemonic string
+	// Operands are separated by commas, as the assembler accepts them.
+	Operands string
+}
+
+// Decode decodes the instruction at addr in mem, which is the memory from
+// address 0. Branch targets are absolute addresses. It reports false for a
+// word that isn't an instruction, or an address past the end of mem.
+func Decode(mem []byte, addr uint32) (Instruction, bool) {
+	inst := disassembler.DecodeAt(mem, addr)
+	if inst.Mnemonic == "dc.w" || inst.Mnemonic == "?" {
+		return Instruction{}, false
+	}
+	return Instruction{Address: inst.Address, Size: int(inst.Size), Mnemonic: inst.Mnemonic, Operands: inst.Operands}, true
+}
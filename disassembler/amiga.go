@@ -0,0 +1,248 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterProfile("amiga", func() Profile { return &amigaProfile{} })
+}
+
+// amigaCustomBase is the address of the OCS/ECS/AGA custom chip registers.
+const amigaCustomBase = 0xDFF000
+
+// amigaRegs maps custom register offsets to their names.
+var amigaRegs = map[uint16]string{
+	0x000: "BLTDDAT", 0x002: "DMACONR", 0x004: "VPOSR", 0x006: "VHPOSR",
+	0x008: "DSKDATR", 0x00A: "JOY0DAT", 0x00C: "JOY1DAT", 0x00E: "CLXDAT",
+	0x010: "ADKCONR", 0x012: "POT0DAT", 0x014: "POT1DAT", 0x016: "POTGOR",
+	0x018: "SERDATR", 0x01A: "DSKBYTR", 0x01C: "INTENAR", 0x01E: "INTREQR",
+	0x020: "DSKPTH", 0x022: "DSKPTL", 0x024: "DSKLEN", 0x026: "DSKDAT",
+	0x028: "REFPTR", 0x02A: "VPOSW", 0x02C: "VHPOSW", 0x02E: "COPCON",
+	0x030: "SERDAT", 0x032: "SERPER", 0x034: "POTGO", 0x036: "JOYTEST",
+	0x038: "STREQU", 0x03A: "STRVBL", 0x03C: "STRHOR", 0x03E: "STRLONG",
+	0x040: "BLTCON0", 0x042: "BLTCON1", 0x044: "BLTAFWM", 0x046: "BLTALWM",
+	0x048: "BLTCPTH", 0x04A: "BLTCPTL", 0x04C: "BLTBPTH", 0x04E: "BLTBPTL",
+	0x050: "BLTAPTH", 0x052: "BLTAPTL", 0x054: "BLTDPTH", 0x056: "BLTDPTL",
+	0x058: "BLTSIZE", 0x05A: "BLTCON0L", 0x05C: "BLTSIZV", 0x05E: "BLTSIZH",
+	0x060: "BLTCMOD", 0x062: "BLTBMOD", 0x064: "BLTAMOD", 0x066: "BLTDMOD",
+	0x070: "BLTCDAT", 0x072: "BLTBDAT", 0x074: "BLTADAT", 0x07C: "DENISEID",
+	0x07E: "DSKSYNC", 0x080: "COP1LCH", 0x082: "COP1LCL", 0x084: "COP2LCH",
+	0x086: "COP2LCL", 0x088: "COPJMP1", 0x08A: "COPJMP2", 0x08C: "COPINS",
+	0x08E: "DIWSTRT", 0x090: "DIWSTOP", 0x092: "DDFSTRT", 0x094: "DDFSTOP",
+	0x096: "DMACON", 0x098: "CLXCON", 0x09A: "INTENA", 0x09C: "INTREQ",
+	0x09E: "ADKCON", 0x100: "BPLCON0", 0x102: "BPLCON1", 0x104: "BPLCON2",
+	0x106: "BPLCON3", 0x108: "BPL1MOD", 0x10A: "BPL2MOD", 0x10C: "BPLCON4",
+	0x10E: "CLXCON2", 0x1C0: "HTOTAL", 0x1C2: "HSSTOP", 0x1C4: "HBSTRT",
+	0x1C6: "HBSTOP", 0x1C8: "VTOTAL", 0x1CA: "VSSTOP", 0x1CC: "VBSTRT",
+	0x1CE: "VBSTOP", 0x1D0: "SPRHSTRT", 0x1D2: "SPRHSTOP", 0x1D4: "BPLHSTRT",
+	0x1D6: "BPLHSTOP", 0x1D8: "HHPOSW", 0x1DA: "HHPOSR", 0x1DC: "BEAMCON0",
+	0x1DE: "HSSTRT", 0x1E0: "VSSTRT", 0x1E2: "HCENTER", 0x1E4: "DIWHIGH",
+	0x1FC: "FMODE", 0x1FE: "NOOP",
+}
+
+func init() {
+	// Registers that come in numbered banks.
+	for ch := uint16(0); ch < 4; ch++ {
+		base := 0x0A0 + ch*0x10
+		for i, name := range []string{"LCH", "LCL", "LEN", "PER", "VOL", "DAT"} {
+			amigaRegs[base+uint16(i)*2] = fmt.Sprintf("AUD%d%s", ch, name)
+		}
+	}
+	for i := uint16(0); i < 8; i++ {
+		amigaRegs[0x0E0+i*4] = fmt.Sprintf("BPL%dPTH", i+1)
+		amigaRegs[0x0E2+i*4] = fmt.Sprintf("BPL%dPTL", i+1)
+		amigaRegs[0x110+i*2] = fmt.Sprintf("BPL%dDAT", i+1)
+		amigaRegs[0x120+i*4] = fmt.Sprintf("SPR%dPTH", i)
+		amigaRegs[0x122+i*4] = fmt.Sprintf("SPR%dPTL", i)
+		for j, name := range []string{"POS", "CTL", "DATA", "DATB"} {
+			amigaRegs[0x140+i*8+uint16(j)*2] = fmt.Sprintf("SPR%d%s", i, name)
+		}
+	}
+	for i := uint16(0); i < 32; i++ {
+		amigaRegs[0x180+i*2] = fmt.Sprintf("COLOR%02d", i)
+	}
+}
+
+var (
+	amigaDMACON = map[int]string{
+		10: "BLTPRI", 9: "DMAEN", 8: "BPLEN", 7: "COPEN", 6: "BLTEN", 5: "SPREN",
+		4: "DSKEN", 3: "AUD3EN", 2: "AUD2EN", 1: "AUD1EN", 0: "AUD0EN",
+	}
+	amigaINTENA = map[int]string{
+		14: "INTEN", 13: "EXTER", 12: "DSKSYN", 11: "RBF", 10: "AUD3", 9: "AUD2",
+		8: "AUD1", 7: "AUD0", 6: "BLIT", 5: "VERTB", 4: "COPER", 3: "PORTS",
+		2: "SOFT", 1: "DSKBLK", 0: "TBE",
+	}
+	amigaADKCON = map[int]string{
+		14: "PRECOMP1", 13: "PRECOMP0", 12: "MFMPREC", 11: "UARTBRK", 10: "WORDSYNC",
+		9: "MSBSYNC", 8: "FAST", 7: "USE3PN", 6: "USE2P3", 5: "USE1P2", 4: "USE0P1",
+		3: "USE3VN", 2: "USE2V3", 1: "USE1V2", 0: "USE0V1",
+	}
+	amigaBPLCON0 = map[int]string{
+		15: "HIRES", 11: "HAM", 10: "DPF", 9: "COLOR", 8: "GAUD", 7: "UHRES",
+		6: "SHRES", 5: "BYPASS", 3: "LPEN", 2: "LACE", 1: "ERSY", 0: "ECSENA",
+	}
+)
+
+// amigaDecodeValue describes a value written to a custom register, or returns ""
+// for registers without interesting bitfields.
+func amigaDecodeValue(reg uint16, v uint16) string {
+	setClr := func(names map[int]string) string {
+		op := "CLR"
+		if v&0x8000 != 0 {
+			op = "SET"
+		}
+		if bits := decodeBits(v&0x7FFF, names); bits != "" {
+			return op + " " + bits
+		}
+		return op
+	}
+
+	switch reg {
+	case 0x096:
+		return setClr(amigaDMACON)
+	case 0x09A, 0x09C:
+		return setClr(amigaINTENA)
+	case 0x09E:
+		return setClr(amigaADKCON)
+	case 0x100:
+		bpu := (v>>12)&7 | (v>>1)&8
+		fields := []string{fmt.Sprintf("BPU=%d", bpu)}
+		if bits := decodeBits(v&^0x7010, amigaBPLCON0); bits != "" {
+			fields = append(fields, bits)
+		}
+		return strings.Join(fields, "|")
+	}
+	if reg >= 0x180 && reg < 0x1C0 {
+		return fmt.Sprintf("rgb $%03x", v&0xFFF)
+	}
+	return ""
+}
+
+// amigaRegName names the register at offset reg, falling back to its offset.
+func amigaRegName(reg uint16) string {
+	if name, ok := amigaRegs[reg]; ok {
+		return name
+	}
+	return fmt.Sprintf("custom+$%03x", reg)
+}
+
+// amigaProfile annotates custom chip accesses and copper lists.
+type amigaProfile struct {
+	regs baseTracker
+}
+
+// Reset forgets the tracked address registers.
+func (p *amigaProfile) Reset() {
+	p.regs.keep = isAmigaCustom
+	p.regs.reset()
+}
+
+// isAmigaCustom reports whether addr is in the custom chip register block.
+func isAmigaCustom(addr uint32) bool {
+	return addr >= amigaCustomBase && addr < amigaCustomBase+0x200
+}
+
+// Instruction names the custom registers an instruction reads or writes.
+func (p *amigaProfile) Instruction(inst *Instruction) string {
+	if inst.Operands == "" {
+		return ""
+	}
+	ops := splitOperands(inst.Operands)
+	if inst.Mnemonic == "lea" || inst.Mnemonic == "pea" {
+		p.regs.track(inst, ops)
+		return ""
+	}
+
+	var notes []string
+	for i, op := range ops {
+		reg, ok := p.customOffset(op)
+		if !ok {
+			continue
+		}
+
+		name := amigaRegName(reg)
+		if strings.HasSuffix(inst.Mnemonic, ".l") {
+			name += "/" + amigaRegName(reg+2)
+		}
+		if i == len(ops)-1 && len(ops) > 1 {
+			if v, ok := parseImmediate(ops[0]); ok && !strings.HasSuffix(inst.Mnemonic, ".l") {
+				if desc := amigaDecodeValue(reg, uint16(v)); desc != "" {
+					name += " = " + desc
+				}
+			}
+		}
+		notes = append(notes, name)
+	}
+
+	p.regs.track(inst, ops)
+	return strings.Join(notes, ", ")
+}
+
+// customOffset returns the custom register offset an operand refers to.
+func (p *amigaProfile) customOffset(op string) (uint16, bool) {
+	addr, ok := p.regs.resolve(op)
+	if !ok || !isAmigaCustom(addr) {
+		return 0, false
+	}
+	return uint16(addr - amigaCustomBase), true
+}
+
+// Data recognizes a copper list: MOVE, WAIT and SKIP pairs ending in $FFFF,$FFFE.
+func (p *amigaProfile) Data(data []byte, addr uint32) (string, int) {
+	const maxInstructions = 4096
+
+	moves := 0
+	end := -1
+	for i := 0; i+4 <= len(data) && i/4 < maxInstructions; i += 4 {
+		w1 := binary.BigEndian.Uint16(data[i:])
+		w2 := binary.BigEndian.Uint16(data[i+2:])
+		if w1 == 0xFFFF && w2 == 0xFFFE {
+			end = i + 4
+			break
+		}
+		if w1&1 == 0 {
+			if w1 >= 0x200 {
+				return "", 0
+			}
+			if _, ok := amigaRegs[w1]; ok {
+				moves++
+			}
+		}
+	}
+	if end < 8 || moves == 0 {
+		return "", 0
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "; copper list at $%08X\n", addr)
+	for i := 0; i < end; i += 4 {
+		w1 := binary.BigEndian.Uint16(data[i:])
+		w2 := binary.BigEndian.Uint16(data[i+2:])
+		fmt.Fprintf(&sb, "    dc.w    $%04x,$%04x\t; %s\n", w1, w2, copperComment(w1, w2))
+	}
+	return sb.String(), end
+}
+
+// copperComment describes a single copper instruction.
+func copperComment(w1, w2 uint16) string {
+	switch {
+	case w1 == 0xFFFF && w2 == 0xFFFE:
+		return "end of list"
+	case w1&1 == 0:
+		s := amigaRegName(w1 & 0x1FE)
+		if desc := amigaDecodeValue(w1&0x1FE, w2); desc != "" {
+			s += " = " + desc
+		}
+		return s
+	default:
+		op := "wait"
+		if w2&1 != 0 {
+			op = "skip"
+		}
+		return fmt.Sprintf("%s v=$%02x h=$%02x", op, w1>>8, w1&0xFE)
+	}
+}
@@ -0,0 +1,295 @@
+package disassembler
+
+import (
+	"maps"
+	"slices"
+)
+
+// maxInstructionSize is the longest 68000 instruction in bytes.
+const maxInstructionSize = 10
+
+// Analysis is a disassembly that can be revised, for interactive tools that
+// let the user mark entry points and data while exploring an image. Each
+// instruction is decoded once, when control flow first reaches it, and the
+// flow from each entry point is kept apart, so a change re-runs only the
+// entry points whose flow it touches instead of the whole image.
+type Analysis struct {
+	code    []byte
+	decoded map[uint32]*Instruction // Every instruction decoded so far
+	data    []bool                  // Bytes the user marked as data
+	flows   map[uint32]*flow        // By entry point
+	refs    map[uint32]int          // Flows reaching each instruction
+	jumps   map[uint32]int          // Flows branching to each address
+	calls   map[uint32]int          // Flows calling each address
+	runs    uint64
+}
+
+// flow is what control flow analysis found from one entry point.
+type flow struct {
+	reached []uint32 // Instructions reached, sorted
+	jumps   []uint32 // Branch targets
+	calls   []uint32 // Subroutine targets
+	blocked []uint32 // Instructions not followed because they overlap data, sorted
+}
+
+// AnalysisStats counts the work an Analysis has done.
+type AnalysisStats struct {
+	// Decoded is the number of instructions decoded and cached.
+	Decoded int
+	// Entries is the number of entry points.
+	Entries int
+	// Runs is the number of times control flow was followed from an entry
+	// point, including the first.
+	Runs uint64
+}
+
+// NewAnalysis analyses code from address 0, like Disassemble.
+func NewAnalysis(code []byte) *Analysis {
+	a := &Analysis{
+		code:    code,
+		decoded: make(map[uint32]*Instruction),
+		flows:   make(map[uint32]*flow),
+		refs:    make(map[uint32]int),
+		jumps:   make(map[uint32]int),
+		calls:   make(map[uint32]int),
+	}
+	a.AddEntry(0)
+	return a
+}
+
+// AddEntry marks addr as the start of code, such as a routine only reached
+// through a jump table, and follows control flow from it.
+func (a *Analysis) AddEntry(addr uint32) {
+	addr &^= 1
+	if _, ok := a.flows[addr]; ok {
+		return
+	}
+	a.run(addr)
+}
+
+// RemoveEntry forgets the entry point at addr. Code that only it reached
+// becomes data again.
+func (a *Analysis) RemoveEntry(addr uint32) {
+	addr &^= 1
+	if f, ok := a.flows[addr]; ok {
+		a.forget(f)
+		delete(a.flows, addr)
+	}
+}
+
+// Entries returns the entry points in address order.
+func (a *Analysis) Entries() []uint32 {
+	return slices.Sorted(maps.Keys(a.flows))
+}
+
+// MarkData marks the bytes from start up to end as data, which control flow
+// never enters, and re-runs the flows that reached them.
+func (a *Analysis) MarkData(start, end uint32) {
+	end = min(end, uint32(len(a.code)))
+	if start >= end {
+		return
+	}
+	if a.data == nil {
+		a.data = make([]bool, len(a.code))
+	}
+	for i := start; i < end; i++ {
+		a.data[i] = true
+	}
+	a.rerun(func(f *flow) bool { return a.touches(f.reached, start, end) })
+}
+
+// ClearData removes the data mark from the bytes from start up to end and
+// re-runs the flows it had stopped.
+func (a *Analysis) ClearData(start, end uint32) {
+	end = min(end, uint32(len(a.code)))
+	if a.data == nil || start >= end {
+		return
+	}
+	for i := start; i < end; i++ {
+		a.data[i] = false
+	}
+	a.rerun(func(f *flow) bool { return a.touches(f.blocked, start, end) })
+}
+
+// IsData reports whether addr was marked as data.
+func (a *Analysis) IsData(addr uint32) bool {
+	return a.data != nil && addr < uint32(len(a.data)) && a.data[addr]
+}
+
+// IsCode reports whether control flow reaches the instruction at addr.
+func (a *Analysis) IsCode(addr uint32) bool {
+	return a.refs[addr] > 0
+}
+
+// Instruction returns the instruction at addr, decoding it if no flow has
+// yet, or nil if addr is odd or outside the image. Its operands are as
+// written, with branch displacements relative.
+func (a *Analysis) Instruction(addr uint32) *Instruction {
+	if addr%2 != 0 || uint64(addr)+2 > uint64(len(a.code)) {
+		return nil
+	}
+	return a.decode(addr)
+}
+
+// Label returns the kind of label addr gets, if a flow branches to or calls
+// it.
+func (a *Analysis) Label(addr uint32) (LabelType, bool) {
+	if a.calls[addr] > 0 {
+		return SubroutineEntry, true
+	}
+	if a.jumps[addr] > 0 {
+		return JumpTarget, true
+	}
+	return 0, false
+}
+
+// Stats returns how much work the analysis has done.
+func (a *Analysis) Stats() AnalysisStats {
+	return AnalysisStats{Decoded: len(a.decoded), Entries: len(a.flows), Runs: a.runs}
+}
+
+// Disassemble lists the image as DisassembleWithOptions does, with the code
+// and labels the analysis has found so far. The analysis decodes the
+// instructions of every model, and those opts.Model lacks are listed as data.
+func (a *Analysis) Disassemble(opts Options) (string, error) {
+	if len(a.code) == 0 {
+		return "", nil
+	}
+	labels := make(map[uint32]LabelType)
+	for addr := range a.jumps {
+		labels[addr] = JumpTarget
+	}
+	for addr := range a.calls {
+		labels[addr] = SubroutineEntry
+	}
+	return render(a.code, a.decoded, labels, opts)
+}
+
+// decode returns the cached instruction at addr, decoding it the first time.
+func (a *Analysis) decode(addr uint32) *Instruction {
+	inst, ok := a.decoded[addr]
+	if !ok {
+		inst = sweepDecode(a.code, int(addr), latest)
+		a.decoded[addr] = inst
+	}
+	return inst
+}
+
+// overlapsData reports whether n bytes at addr include any marked as data.
+func (a *Analysis) overlapsData(addr, n uint32) bool {
+	if a.data == nil {
+		return false
+	}
+	end := min(uint64(addr)+uint64(n), uint64(len(a.data)))
+	for i := uint64(addr); i < end; i++ {
+		if a.data[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// touches reports whether any of the instructions at addrs, which are
+// sorted, overlaps the bytes from start up to end.
+func (a *Analysis) touches(addrs []uint32, start, end uint32) bool {
+	from := uint32(0)
+	if start > maxInstructionSize {
+		from = start - maxInstructionSize
+	}
+	i, _ := slices.BinarySearch(addrs, from)
+	for ; i < len(addrs) && addrs[i] < end; i++ {
+		size := uint32(2)
+		if inst, ok := a.decoded[addrs[i]]; ok {
+			size = inst.Size
+		}
+		if addrs[i]+size > start {
+			return true
+		}
+	}
+	return false
+}
+
+// rerun follows control flow again from every entry point whose flow is
+// affected.
+func (a *Analysis) rerun(affected func(*flow) bool) {
+	for _, entry := range a.Entries() {
+		if f := a.flows[entry]; affected(f) {
+			a.forget(f)
+			a.run(entry)
+		}
+	}
+}
+
+// run follows control flow from entry, as analyze does, and records the flow.
+func (a *Analysis) run(entry uint32) {
+	a.runs++
+	f := &flow{}
+	q := newQueue()
+	q.push(entry)
+	for {
+		addr, ok := q.pop()
+		if !ok {
+			break
+		}
+		if uint64(addr)+2 > uint64(len(a.code)) {
+			continue
+		}
+		if a.overlapsData(addr, 2) {
+			f.blocked = append(f.blocked, addr)
+			continue
+		}
+		inst := a.decode(addr)
+		if a.overlapsData(addr, inst.Size) {
+			f.blocked = append(f.blocked, addr)
+			continue
+		}
+		f.reached = append(f.reached, addr)
+
+		if !isTerminal(inst.Mnemonic) {
+			q.push(addr + inst.Size)
+		}
+		if target, call, ok := flowTarget(inst); ok {
+			q.push(target)
+			if call {
+				f.calls = append(f.calls, target)
+			} else {
+				f.jumps = append(f.jumps, target)
+			}
+		}
+	}
+	slices.Sort(f.reached)
+	slices.Sort(f.blocked)
+
+	for _, addr := range f.reached {
+		a.refs[addr]++
+		a.decoded[addr].IsCode = true
+	}
+	for _, addr := range f.jumps {
+		a.jumps[addr]++
+	}
+	for _, addr := range f.calls {
+		a.calls[addr]++
+	}
+	a.flows[entry] = f
+}
+
+// forget removes a flow's contribution to the code and labels.
+func (a *Analysis) forget(f *flow) {
+	for _, addr := range f.reached {
+		if a.refs[addr]--; a.refs[addr] == 0 {
+			delete(a.refs, addr)
+			a.decoded[addr].IsCode = false
+		}
+	}
+	release(a.jumps, f.jumps)
+	release(a.calls, f.calls)
+}
+
+// release drops one count for each address.
+func release(counts map[uint32]int, addrs []uint32) {
+	for _, addr := range addrs {
+		if counts[addr]--; counts[addr] == 0 {
+			delete(counts, addr)
+		}
+	}
+}
@@ -13,7 +13,7 @@ func isBranchMnemonic(val string) bool {
 	case "bra", "bsr", "bhi", "bls", "bcc", "bcs", "bne", "beq", "bvc", "bvs", "bpl", "bmi", "bge", "blt", "bgt", "ble":
 		return true
 	default:
-		return strings.HasPrefix(val, "db")
+		return strings.HasPrefix(val, "db") || isFPUBranch(val)
 	}
 }
 
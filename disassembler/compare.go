@@ -10,8 +10,8 @@ func decodeCmp(op uint16, pc int, code []byte) (string, string, int) {
 	var sizeStr string
 	var mn string
 
-	// Bit 8 (0x0100) distinguishes CMP/CMPA from EOR.
-	if (op & 0x0100) != 0 {
+	// Bit 8 (0x0100) distinguishes CMP/CMPA from EOR, except for CMPA.L (opmode 111).
+	if (op&0x0100) != 0 && opmode != 7 {
 		// This is an EOR instruction. Size is encoded in the 3-bit opmode field (bits 8-6).
 		mn = "eor"
 		switch opmode {
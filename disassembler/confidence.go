@@ -0,0 +1,112 @@
+package disassembler
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Confidence says how far an instruction can be trusted to be code, for tools
+// that filter disassembly automatically. Higher is more certain.
+type Confidence int
+
+const (
+	// ConfidenceIllegal is a word that is not a 68000 instruction.
+	ConfidenceIllegal Confidence = iota
+	// ConfidenceData decodes, but control flow never reaches it, so it is
+	// most likely data.
+	ConfidenceData
+	// ConfidenceReachable is reached by control flow from an entry point.
+	ConfidenceReachable
+)
+
+// String returns the lowercase name of the confidence.
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceIllegal:
+		return "illegal"
+	case ConfidenceData:
+		return "data"
+	default:
+		return "reachable"
+	}
+}
+
+// Confidence rates the instruction. Words that do not decode are illegal even
+// when control flow reaches them.
+func (inst *Instruction) Confidence() Confidence {
+	switch {
+	case isUnknown(inst):
+		return ConfidenceIllegal
+	case inst.IsCode:
+		return ConfidenceReachable
+	default:
+		return ConfidenceData
+	}
+}
+
+// isUnknown reports whether inst is a word the decoder could not make sense of.
+func isUnknown(inst *Instruction) bool {
+	return inst.Mnemonic == "dc.w" || inst.Mnemonic == "?"
+}
+
+// Instructions decodes every word offset in code and follows control flow
+// from address 0, as Disassemble does. It returns the instructions in address
+// order, overlapping where a word was decoded both on its own and as part of a
+// longer instruction, so that Confidence can tell them apart. Their operands
+// are as written, with branch displacements relative.
+func Instructions(code []byte) []*Instruction {
+	instructions, _ := analyze(code, latest)
+	list := make([]*Instruction, 0, len(instructions))
+	for _, addr := range slices.Sorted(maps.Keys(instructions)) {
+		list = append(list, instructions[addr])
+	}
+	return list
+}
+
+// UnknownPolicy chooses how reachable words that do not decode are rendered.
+type UnknownPolicy int
+
+const (
+	// UnknownDC renders them as dc.w, the default.
+	UnknownDC UnknownPolicy = iota
+	// UnknownShort renders them as .short, for GNU as.
+	UnknownShort
+	// UnknownError fails the disassembly at the first one.
+	UnknownError
+)
+
+// unknownPolicies maps the names ParseUnknownPolicy accepts to policies.
+var unknownPolicies = map[string]UnknownPolicy{
+	"dc.w":  UnknownDC,
+	"short": UnknownShort,
+	"error": UnknownError,
+}
+
+// UnknownPolicies returns the names ParseUnknownPolicy accepts, sorted.
+func UnknownPolicies() []string {
+	return slices.Sorted(maps.Keys(unknownPolicies))
+}
+
+// ParseUnknownPolicy returns the policy called name: dc.w, short or error.
+func ParseUnknownPolicy(name string) (UnknownPolicy, error) {
+	p, ok := unknownPolicies[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown opcode policy %q (want %s)", name, strings.Join(UnknownPolicies(), ", "))
+	}
+	return p, nil
+}
+
+// renderUnknown returns the mnemonic and operands for the undecodable inst
+// under policy p, or an error if p forbids it.
+func (p UnknownPolicy) renderUnknown(inst *Instruction) (string, string, error) {
+	switch p {
+	case UnknownShort:
+		return ".short", fmt.Sprintf("0x%04x", inst.Op), nil
+	case UnknownError:
+		return "", "", fmt.Errorf("undecodable opcode $%04x at $%x", inst.Op, inst.Address)
+	default:
+		return inst.Mnemonic, inst.Operands, nil
+	}
+}
@@ -0,0 +1,157 @@
+package disassembler
+
+import "fmt"
+
+func init() {
+	RegisterProfile("cpm68k", func() Profile { return &cpmProfile{} })
+}
+
+// cpmBDOS holds the CP/M-68K BDOS functions, called with TRAP #2, the
+// function number in D0.W and the parameter, if any, in D1.
+var cpmBDOS = map[uint32]sysCall{
+	0:  {name: "P_TERMCPM"},
+	1:  {name: "C_READ"},
+	2:  {name: "C_WRITE", params: []string{"char"}},
+	3:  {name: "A_READ"},
+	4:  {name: "A_WRITE", params: []string{"char"}},
+	5:  {name: "L_WRITE", params: []string{"char"}},
+	6:  {name: "C_RAWIO", params: []string{"char"}, values: map[int]map[uint32]string{0: {0xFF: "input", 0xFE: "status"}}},
+	7:  {name: "A_STATIN"},
+	8:  {name: "A_STATOUT"},
+	9:  {name: "C_WRITESTR", params: []string{"string"}},
+	10: {name: "C_READSTR", params: []string{"buffer"}},
+	11: {name: "C_STAT"},
+	12: {name: "S_BDOSVER"},
+	13: {name: "DRV_ALLRESET"},
+	14: {name: "DRV_SET", params: []string{"drive"}},
+	15: {name: "F_OPEN", params: []string{"fcb"}},
+	16: {name: "F_CLOSE", params: []string{"fcb"}},
+	17: {name: "F_SFIRST", params: []string{"fcb"}},
+	18: {name: "F_SNEXT"},
+	19: {name: "F_DELETE", params: []string{"fcb"}},
+	20: {name: "F_READ", params: []string{"fcb"}},
+	21: {name: "F_WRITE", params: []string{"fcb"}},
+	22: {name: "F_MAKE", params: []string{"fcb"}},
+	23: {name: "F_RENAME", params: []string{"fcb"}},
+	24: {name: "DRV_LOGINVEC"},
+	25: {name: "DRV_GET"},
+	26: {name: "F_DMAOFF", params: []string{"dma"}},
+	27: {name: "DRV_ALLOCVEC"},
+	28: {name: "DRV_SETRO"},
+	29: {name: "DRV_ROVEC"},
+	30: {name: "F_ATTRIB", params: []string{"fcb"}},
+	31: {name: "DRV_DPB", params: []string{"buffer"}},
+	32: {name: "F_USERNUM", params: []string{"user"}, values: map[int]map[uint32]string{0: {0xFF: "get"}}},
+	33: {name: "F_READRAND", params: []string{"fcb"}},
+	34: {name: "F_WRITERAND", params: []string{"fcb"}},
+	35: {name: "F_SIZE", params: []string{"fcb"}},
+	36: {name: "F_RANDREC", params: []string{"fcb"}},
+	37: {name: "DRV_RESET", params: []string{"drives"}},
+	40: {name: "F_WRITEZF", params: []string{"fcb"}},
+	46: {name: "DRV_SPACE", params: []string{"drive"}},
+	47: {name: "P_CHAIN"},
+	48: {name: "DRV_FLUSH"},
+	50: {name: "S_BIOS", params: []string{"bpb"}},
+	59: {name: "P_LOAD", params: []string{"lpb"}},
+	61: {name: "F_TRAPINIT", params: []string{"epb"}},
+	62: {name: "S_SUPER"},
+	63: {name: "P_TPA", params: []string{"tpab"}},
+}
+
+// cpmProfile names CP/M-68K BDOS calls. It follows the function number and
+// parameter loaded into D0 and D1 since the last label, and comments each
+// TRAP #2 with the call they make.
+type cpmProfile struct {
+	fn    uint32
+	fnSet bool
+	param string // How D1 was loaded, or "" if unknown
+}
+
+// Reset forgets the tracked registers.
+func (p *cpmProfile) Reset() {
+	p.fnSet, p.param = false, ""
+}
+
+// Instruction follows D0 and D1 and describes BDOS calls.
+func (p *cpmProfile) Instruction(inst *Instruction) string {
+	if n, ok := trapNumber(inst); ok && n == 2 {
+		note := "BDOS call"
+		if p.fnSet {
+			note = fmt.Sprintf("BDOS %d", p.fn)
+			if call, ok := cpmBDOS[p.fn]; ok {
+				note += " " + call.describe([]string{p.param})
+			}
+		}
+		// The BDOS returns its result in D0 and may change D1.
+		p.Reset()
+		return note
+	}
+	if isCall(inst) {
+		p.Reset()
+		return ""
+	}
+
+	ops := splitOperands(inst.Operands)
+	if inst.Mnemonic == "exg" {
+		for _, op := range ops {
+			p.forget(op)
+		}
+		return ""
+	}
+	if writesRegister(inst, ops, "d0") {
+		p.fn, p.fnSet = loadedValue(inst, ops)
+	}
+	if writesRegister(inst, ops, "d1") {
+		p.param = loadedOperand(inst, ops)
+	}
+	return ""
+}
+
+// forget drops what is known about a register.
+func (p *cpmProfile) forget(reg string) {
+	switch reg {
+	case "d0":
+		p.fnSet = false
+	case "d1":
+		p.param = ""
+	}
+}
+
+// Data leaves data to the default rules.
+func (p *cpmProfile) Data(data []byte, addr uint32) (string, int) {
+	return "", 0
+}
+
+// isMove reports whether an instruction copies its source to its destination
+// unchanged.
+func isMove(inst *Instruction) bool {
+	switch inst.Mnemonic {
+	case "move.b", "move.w", "move.l", "movea.w", "movea.l", "moveq", "lea":
+		return true
+	}
+	return false
+}
+
+// loadedOperand returns the source of the value an instruction loads into its
+// destination, "#0" for CLR, or "" if the value is computed.
+func loadedOperand(inst *Instruction, ops []string) string {
+	if _, ok := loadedValue(inst, ops); ok && len(ops) == 1 {
+		return "#0"
+	}
+	if len(ops) == 2 && isMove(inst) {
+		return ops[0]
+	}
+	return ""
+}
+
+// loadedValue returns the constant an instruction loads into its destination
+// register: an immediate moved there, or 0 for CLR.
+func loadedValue(inst *Instruction, ops []string) (uint32, bool) {
+	switch {
+	case len(ops) == 1 && (inst.Mnemonic == "clr.b" || inst.Mnemonic == "clr.w" || inst.Mnemonic == "clr.l"):
+		return 0, true
+	case len(ops) == 2 && isMove(inst) && inst.Mnemonic != "lea":
+		return parseImmediate(ops[0])
+	}
+	return 0, false
+}
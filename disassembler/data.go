@@ -9,7 +9,7 @@ import (
 func isPrintableASCII(b byte) bool {
 	return b >= 0x20 && b <= 0x7E
 }
-func analyzeAndFormatData(data []byte, baseAddr uint32, stringCounter *int) string {
+func analyzeAndFormatData(data []byte, baseAddr uint32, names *namer) string {
 	var sb strings.Builder
 	n := len(data)
 	if n == 0 {
@@ -45,8 +45,7 @@ func analyzeAndFormatData(data []byte, baseAddr uint32, stringCounter *int) stri
 
 		// Rule 1: printable + NUL ≥ 4 chars → string
 		if isNullTerminated && len(run) >= minStrLen {
-			label := fmt.Sprintf("string%d:", *stringCounter)
-			(*stringCounter)++
+			label := names.stringLabel(runAddr, run) + ":"
 			escaped := strings.ReplaceAll(string(run), "'", "''")
 			sb.WriteString(fmt.Sprintf("%-8s dc.b    '%s',$00\n", label, escaped))
 			i = end + 1
@@ -55,8 +54,7 @@ func analyzeAndFormatData(data []byte, baseAddr uint32, stringCounter *int) stri
 
 		// Rule 2: 4-byte aligned, 4 printable chars → tag
 		if len(run) == 4 && allPrintable(run) && runAddr%4 == 0 {
-			label := fmt.Sprintf("string%d:", *stringCounter)
-			(*stringCounter)++
+			label := names.stringLabel(runAddr, run) + ":"
 			escaped := strings.ReplaceAll(string(run), "'", "''")
 			sb.WriteString(fmt.Sprintf("%-8s dc.b    '%s'\n", label, escaped))
 			i = end
@@ -28,86 +28,100 @@ type Instruction struct {
 	IsCode   bool // Flag to mark as reachable code
 }
 
+// Options controls optional parts of the disassembly output.
+type Options struct {
+	// Regions adds a comment where each classified region starts.
+	Regions bool
+	// BlockSize is the block size used to classify regions (DefaultBlockSize if 0).
+	BlockSize int
+	// Profile names a hardware profile (see Profiles) whose annotations are added as comments.
+	Profile string
+	// Symbols names addresses in the image, overriding the generated labels.
+	// Every symbol inside the image gets a label, in code or data.
+	Symbols Symbols
+	// Signatures label the known routines found in the image, unless Symbols
+	// already names the address.
+	Signatures []Signature
+	// Labels chooses how generated labels are named (DefaultLabels if 0).
+	Labels LabelScheme
+	// LabelSeed varies the names HashLabels generates. The same seed always
+	// gives the same names.
+	LabelSeed uint64
+	// A5Symbols names offsets from A5, such as the jump table entries of a
+	// classic Mac application. Operands like ($22,a5) are commented with them.
+	A5Symbols map[int32]string
+	// Unknown chooses how reachable words that do not decode are rendered
+	// (UnknownDC if 0).
+	Unknown UnknownPolicy
+	// Model is the CPU the code is for (MC68000 if 0). Instructions only
+	// later models have don't decode, so they are rendered like any other
+	// unknown word.
+	Model cpu.Model
+}
+
 // Disassemble performs a robust, multi-stage disassembly.
 func Disassemble(code []byte) (string, error) {
+	return DisassembleWithOptions(code, Options{})
+}
+
+// DisassembleWithOptions is Disassemble with optional annotations.
+func DisassembleWithOptions(code []byte, opts Options) (string, error) {
 	if len(code) == 0 {
 		return "", nil
 	}
+	instructions, labelTargets := analyze(code, opts.Model)
+	return render(code, instructions, labelTargets, opts)
+}
 
-	// --- STAGE 1: Linear Sweep ---
-	instructions := make(map[uint32]*Instruction)
-	for pc := 0; pc+1 < len(code); {
-		addr := uint32(pc)
-		op := binary.BigEndian.Uint16(code[pc:])
-		var extensions []byte
-		if pc+2 < len(code) {
-			extensions = code[pc+2:]
-		}
-		mn, ops, used := decode(op, 0, extensions)
-		inst := &Instruction{
-			Address:  addr,
-			Op:       op,
-			Mnemonic: mn,
-			Operands: ops,
-			Size:     uint32(2 + used),
-		}
-		instructions[addr] = inst
-		pc += 2
+// render lists code as assembly source, using the instructions marked as code
+// and the branch and subroutine targets found by control flow analysis.
+func render(code []byte, instructions map[uint32]*Instruction, labelTargets map[uint32]LabelType, opts Options) (string, error) {
+	var prof Profile
+	if opts.Profile != "" {
+		var err error
+		prof, err = NewProfile(opts.Profile)
+		if err != nil {
+			return "", err
+		}
+		prof.Reset()
 	}
 
-	// --- STAGE 2: Control Flow Analysis ---
-	labelTargets := make(map[uint32]LabelType)
-	q := newQueue()
-	q.push(0)
-
-	for {
-		addr, ok := q.pop()
-		if !ok {
-			break
+	if len(opts.Signatures) > 0 {
+		found := FindSignatures(code, opts.Signatures)
+		for addr, name := range opts.Symbols {
+			found[addr] = name
 		}
+		opts.Symbols = found
+	}
 
-		inst, exists := instructions[addr]
-		if !exists || inst.IsCode {
-			continue
+	names := newNamer(instructions, labelTargets, opts)
+	// label returns the imported or generated label at addr, if any.
+	label := func(addr uint32) (string, bool) {
+		if name, ok := opts.Symbols[addr]; ok {
+			return name, true
 		}
-		inst.IsCode = true
-
-		if !isTerminal(inst.Mnemonic) {
-			q.push(addr + inst.Size)
-		}
-
-		isSubroutineCall := inst.Mnemonic == "jsr" || inst.Mnemonic == "bsr"
-		if isBranchMnemonic(inst.Mnemonic) || isSubroutineCall {
-			offsetPC := inst.Address + 2
-			var target int64 = -1
-
-			if isBranchMnemonic(inst.Mnemonic) {
-				offset := parseBranchOffset(inst.Operands)
-				target = int64(offsetPC) + int64(offset)
-			}
-			if addr := parseAbsoluteAddress(inst.Operands); addr >= 0 {
-				target = int64(addr)
-			}
-
-			if target >= 0 {
-				targetAddr := uint32(target)
-				q.push(targetAddr)
-				if isSubroutineCall {
-					labelTargets[targetAddr] = SubroutineEntry
-				} else if _, exists := labelTargets[targetAddr]; !exists {
-					labelTargets[targetAddr] = JumpTarget
-				}
-			}
+		if _, ok := labelTargets[addr]; ok {
+			return names.label(addr), true
 		}
+		return "", false
+	}
+	var regions []Region
+	if opts.Regions {
+		regions = classifyRegions(code, opts.BlockSize, instructions)
 	}
 
 	// --- STAGE 3: Render Final Output ---
 	var out strings.Builder
-	stringCounter := 1
 	pc := uint32(0)
 	totalLen := uint32(len(code))
 
 	for pc < totalLen {
+		// Announce any region starting at or before this item.
+		for len(regions) > 0 && regions[0].Start <= pc {
+			out.WriteString(regionComment(regions[0]))
+			regions = regions[1:]
+		}
+
 		// If the current address is not marked as code, find the end of the
 		// data block and pass it to the data analyzer.
 		if inst, isCode := instructions[pc]; !isCode || !inst.IsCode {
@@ -117,42 +131,70 @@ func Disassemble(code []byte) (string, error) {
 				if inst, isCode := instructions[dataEnd]; isCode && inst.IsCode {
 					break
 				}
+				if _, named := opts.Symbols[dataEnd]; named && dataEnd > dataStart {
+					break
+				}
 				dataEnd++
 			}
-			out.WriteString(analyzeAndFormatData(code[dataStart:dataEnd], dataStart, &stringCounter))
+			if name, named := opts.Symbols[dataStart]; named {
+				fmt.Fprintf(&out, "%s:\n", name)
+			}
+			out.WriteString(renderData(code[dataStart:dataEnd], dataStart, names, prof))
 			pc = dataEnd
 			continue
 		}
 
 		// It's a code instruction. Check if a label needs to be printed.
-		if labelType, exists := labelTargets[pc]; exists {
-			fmt.Fprintf(&out, "%s:\n", labelName(pc, labelType))
+		if name, exists := label(pc); exists {
+			fmt.Fprintf(&out, "%s:\n", name)
+			if prof != nil {
+				prof.Reset()
+			}
 		}
 
 		// Get the instruction and print it.
-		inst := instructions[pc]
-		finalOperands := inst.Operands
+		inst := forModel(code, instructions[pc], opts.Model)
+		mnemonic, finalOperands := inst.Mnemonic, inst.Operands
+		if isUnknown(inst) {
+			var err error
+			mnemonic, finalOperands, err = opts.Unknown.renderUnknown(inst)
+			if err != nil {
+				return "", err
+			}
+		}
 		if isBranchMnemonic(inst.Mnemonic) || inst.Mnemonic == "jsr" {
 			offsetPC := inst.Address + 2
 			var target int64 = -1
 			if isBranchMnemonic(inst.Mnemonic) {
 				offset := parseBranchOffset(inst.Operands)
 				target = int64(offsetPC) + int64(offset)
-			}
-			if addr := parseAbsoluteAddress(inst.Operands); addr >= 0 {
+			} else if addr := parseAbsoluteAddress(inst.Operands); addr >= 0 {
 				target = int64(addr)
 			}
 			if target >= 0 {
-				if labelType, exists := labelTargets[uint32(target)]; exists {
-					finalOperands = labelName(uint32(target), labelType)
+				if name, exists := label(uint32(target)); exists {
+					finalOperands = name
 				}
 			}
 		}
 
-		if finalOperands != "" {
-			fmt.Fprintf(&out, "    %-8s %s\n", inst.Mnemonic, finalOperands)
+		var comment string
+		if prof != nil {
+			comment = prof.Instruction(inst)
+		}
+		if note := a5Note(inst.Operands, opts.A5Symbols); note != "" {
+			if comment != "" {
+				note = comment + ", " + note
+			}
+			comment = note
+		}
+
+		if comment != "" {
+			fmt.Fprintf(&out, "    %-8s %-24s ; %s\n", mnemonic, finalOperands, comment)
+		} else if finalOperands != "" {
+			fmt.Fprintf(&out, "    %-8s %s\n", mnemonic, finalOperands)
 		} else {
-			fmt.Fprintf(&out, "    %s\n", inst.Mnemonic)
+			fmt.Fprintf(&out, "    %s\n", mnemonic)
 		}
 
 		// Advance PC by the size of this single instruction.
@@ -162,18 +204,153 @@ func Disassemble(code []byte) (string, error) {
 	return out.String(), nil
 }
 
+// DecodeAt decodes the single instruction at addr in mem, which holds the whole
+// address space, e.g. the memory of a running VM. There are no labels, so
+// branch targets are shown as absolute addresses.
+func DecodeAt(mem []byte, addr uint32) *Instruction {
+	if uint64(addr)+2 > uint64(len(mem)) {
+		return &Instruction{Address: addr, Mnemonic: "?", Size: 2}
+	}
+	return decodeAt(mem, int(addr), addr)
+}
+
+// DecodeRange decodes the instructions in code, which is loaded at addr, for
+// listing a fragment of a larger image such as a patch. Like DecodeAt it shows
+// branch targets as absolute addresses.
+func DecodeRange(code []byte, addr uint32) []*Instruction {
+	var list []*Instruction
+	for off := 0; off+2 <= len(code); {
+		inst := decodeAt(code, off, addr+uint32(off))
+		list = append(list, inst)
+		off += int(inst.Size)
+	}
+	return list
+}
+
+// decodeAt decodes the instruction at offset off in code, whose address is addr.
+func decodeAt(code []byte, off int, addr uint32) *Instruction {
+	op := binary.BigEndian.Uint16(code[off:])
+	mn, ops, used := decode(op, off+2, code)
+	if isBranchMnemonic(mn) {
+		// DBcc has the counter register before the displacement.
+		prefix, disp := "", ops
+		if i := strings.LastIndexByte(ops, ','); i >= 0 {
+			prefix, disp = ops[:i+1], ops[i+1:]
+		}
+		if disp != "?" {
+			ops = fmt.Sprintf("%s$%x", prefix, int64(addr)+2+int64(parseBranchOffset(disp)))
+		}
+	}
+	return &Instruction{
+		Address:  addr,
+		Op:       op,
+		Mnemonic: mn,
+		Operands: ops,
+		Size:     uint32(2 + used),
+		IsCode:   true,
+	}
+}
+
+// analyze decodes every word offset in code for model (stage 1) and then
+// follows control flow from address 0 (stage 2), marking reachable
+// instructions as code and collecting branch and subroutine targets.
+func analyze(code []byte, model cpu.Model) (map[uint32]*Instruction, map[uint32]LabelType) {
+	// --- STAGE 1: Linear Sweep ---
+	instructions := make(map[uint32]*Instruction)
+	for pc := 0; pc+1 < len(code); pc += 2 {
+		instructions[uint32(pc)] = sweepDecode(code, pc, model)
+	}
+
+	// --- STAGE 2: Control Flow Analysis ---
+	labelTargets := make(map[uint32]LabelType)
+	q := newQueue()
+	q.push(0)
+
+	for {
+		addr, ok := q.pop()
+		if !ok {
+			break
+		}
+
+		inst, exists := instructions[addr]
+		if !exists || inst.IsCode {
+			continue
+		}
+		inst.IsCode = true
+
+		if !isTerminal(inst.Mnemonic) {
+			q.push(addr + inst.Size)
+		}
+
+		if target, call, ok := flowTarget(inst); ok {
+			q.push(target)
+			if call {
+				labelTargets[target] = SubroutineEntry
+			} else if _, exists := labelTargets[target]; !exists {
+				labelTargets[target] = JumpTarget
+			}
+		}
+	}
+
+	return instructions, labelTargets
+}
+
+// sweepDecode decodes the instruction at offset pc in code for analysis, as
+// model would. Its operands are left as written, with branch displacements
+// relative.
+func sweepDecode(code []byte, pc int, model cpu.Model) *Instruction {
+	op := binary.BigEndian.Uint16(code[pc:])
+	var extensions []byte
+	if pc+2 < len(code) {
+		extensions = code[pc+2:]
+	}
+	mn, ops, used := decodeFor(model, op, 0, extensions)
+	return &Instruction{
+		Address:  uint32(pc),
+		Op:       op,
+		Mnemonic: mn,
+		Operands: ops,
+		Size:     uint32(2 + used),
+	}
+}
+
+// flowTarget returns where a branch, BSR or JSR to an absolute address in inst
+// goes, and whether it calls a subroutine.
+func flowTarget(inst *Instruction) (target uint32, call bool, ok bool) {
+	call = inst.Mnemonic == "jsr" || inst.Mnemonic == "bsr"
+	if !isBranchMnemonic(inst.Mnemonic) && !call {
+		return 0, false, false
+	}
+	var t int64 = -1
+	if isBranchMnemonic(inst.Mnemonic) {
+		t = int64(inst.Address+2) + int64(parseBranchOffset(inst.Operands))
+	} else if addr := parseAbsoluteAddress(inst.Operands); addr >= 0 {
+		t = int64(addr)
+	}
+	if t < 0 {
+		return 0, false, false
+	}
+	return uint32(t), call, true
+}
+
 // isTerminal checks if an instruction unconditionally stops linear execution.
 func isTerminal(mn string) bool {
-	return mn == "rts" || mn == "rte" || mn == "rtr" || mn == "jmp" || mn == "bra"
+	return mn == "rts" || mn == "rte" || mn == "rtr" || mn == "rtd" || mn == "jmp" || mn == "bra"
 }
 
 // decode returns mnemonic, operand string, and number of extra bytes consumed.
 func decode(op uint16, pc int, code []byte) (string, string, int) {
+	if mn, ops, n, ok := decode68020(op, pc, code); ok {
+		return mn, ops, n
+	}
 	// Handle dense 0x4E00 opcode space first with specific, ordered checks
 	if (op & 0xFF00) == 0x4E00 {
 		if (op&0xFFF0) == cpu.OPMOVEToUSP || (op&0xFFF0) == cpu.OPMOVEFromUSP {
 			return decodeMoveSystemRegister(op, pc, code)
 		}
+		if op&0xFFFE == cpu.OPMOVEC {
+			return decodeMovec(op, pc, code)
+		}
 		switch op {
 		case cpu.OPNOP:
 			return "nop", "", 0
@@ -190,6 +367,9 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 		case cpu.OPSTOP:
 			imm, used := readImmediateBySize(code, pc, 1)
 			return "stop", imm, used
+		case cpu.OPRTD:
+			disp, used := readImmediateBySize(code, pc, 1)
+			return "rtd", disp, used
 		}
 		if (op & 0xFFF8) == cpu.OPLINK {
 			reg := op & 7
@@ -223,6 +403,9 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 	if (op & 0xF138) == 0x0108 {
 		return decodeMovep(op, pc, code)
 	}
+	if (op & 0xFF00) == cpu.OPMOVES {
+		return decodeMoves(op, pc, code)
+	}
 
 	if (op&0xFF00) == cpu.OPORI ||
 		(op&0xFF00) == cpu.OPANDI ||
@@ -242,7 +425,7 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 
 	hi := op & 0xF000
 	switch {
-	case (op & 0xF0C8) == cpu.OPDBcc:
+	case (op & 0xF0F8) == cpu.OPDBcc:
 		return decodeDbcc(op, pc, code)
 	case (op & 0xF0C0) == cpu.OPScc:
 		return decodeScc(op, pc, code)
@@ -270,16 +453,12 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 	case (op & 0xF000) == cpu.OPAND:
 		if (op & 0xF100) == 0xC100 {
 			opmode := (op >> 3) & 0x1F
-			if opmode == 0b01001 || opmode == 0b10001 {
+			if opmode == 0b01000 || opmode == 0b01001 || opmode == 0b10001 {
 				return decodeExg(op)
 			}
-			if opmode == 0b01000 {
-				regX := (op >> 9) & 7
-				regY := op & 7
-				if regX == regY {
-					return decodeExg(op)
-				}
-			}
+		}
+		if (op & 0xF1F0) == cpu.OPABCD {
+			return decodeBcd(op)
 		}
 		if (op&0xF0C0) == cpu.OPMULU || (op&0xF0C0) == cpu.OPMULS {
 			return decodeMulDiv(op, pc, code)
@@ -289,22 +468,25 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 		if (op&0xF0C0) == cpu.OPDIVU || (op&0xF0C0) == cpu.OPDIVS {
 			return decodeMulDiv(op, pc, code)
 		}
+		if (op & 0xF1F0) == cpu.OPSBCD {
+			return decodeBcd(op)
+		}
 		return decodeLogical(op, pc, code)
+	case (op&0xB130) == 0x9100 && (op&0x00C0) != 0x00C0:
+		return decodeAddxSubx(op, pc, code)
 	case (op & 0xF000) == 0xD000:
 		return decodeAdd(op, pc, code)
 	case (op & 0xF000) == 0x9000:
 		return decodeSub(op, pc, code)
 	case (op & 0xF000) == 0xB000:
-		if (op & 0xF138) == 0xB108 {
+		if (op&0xF138) == 0xB108 && (op&0x00C0) != 0x00C0 {
 			return decodeCmpm(op)
 		}
-		if (op&0x0100) == 0 && (op&0x00C0) != 0 {
-			if (op & 0x01F8) == 0x0180 {
-				return decodeChk(op, pc, code)
-			}
-		}
 		return decodeCmp(op, pc, code)
+	case (op & 0xF1C0) == cpu.OPCHK:
+		return decodeChk(op, pc, code)
 	case (op & 0xFFC0) == cpu.OPMOVEFromSR,
+		(op & 0xFFC0) == cpu.OPMOVEFromCCR,
 		(op & 0xFFC0) == cpu.OPMOVEToCCR,
 		(op & 0xFFC0) == cpu.OPMOVEToSR:
 		return decodeMoveSystemRegister(op, pc, code)
@@ -325,10 +507,8 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 		return decodeSwap(op)
 	case (op & 0xFB80) == 0x4880:
 		return decodeMovem(op, pc, code)
-	case (op&0xF100) == cpu.OPADDX || (op&0xF100) == cpu.OPSUBX:
-		return decodeAddxSubx(op, pc, code)
 	case hi == cpu.OPShiftRotateBase:
-		return decodeShiftRotateGeneric(op)
+		return decodeShiftRotateGeneric(op, pc, code)
 	case (op & 0xFFC0) == cpu.OPPEA:
 		ea := op & 0x3F
 		ops, used := DecodeEA(ea, pc, code, 1)
@@ -338,6 +518,8 @@ func decode(op uint16, pc int, code []byte) (string, string, int) {
 		ea := op & 0x3F
 		ops, used := DecodeEA(ea, pc, code, 0)
 		return "lea", fmt.Sprintf("%s,a%d", ops, reg), used
+	case hi == 0xF000:
+		return decodeLineF(op, pc, code)
 	}
 
 	return "dc.w", fmt.Sprintf("0x%04x", op), 0
@@ -0,0 +1,290 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// fpuOpmodeNames maps the opmode field of FPU command words to mnemonics.
+var fpuOpmodeNames = invert(cpu.FPUOpmodes)
+
+// fpuConditionNames maps FBcc predicates to their condition mnemonics.
+var fpuConditionNames = invert(cpu.FPUConditions)
+
+// invert turns a mnemonic table around.
+func invert(m map[string]uint16) map[uint16]string {
+	r := make(map[uint16]string, len(m))
+	for k, v := range m {
+		r[v] = k
+	}
+	return r
+}
+
+// fpuFormatSuffixes are the size suffixes of the FPU source formats.
+var fpuFormatSuffixes = []string{".l", ".s", ".x", ".p", ".w", ".d", ".b"}
+
+// fpuFormatBytes are the sizes of the FPU source formats in memory.
+var fpuFormatBytes = []int{4, 4, 12, 12, 2, 8, 1}
+
+// isFPUBranch checks if an instruction is an FPU conditional branch.
+func isFPUBranch(mn string) bool {
+	cond, ok := strings.CutPrefix(strings.TrimSuffix(mn, ".l"), "fb")
+	if ok {
+		_, ok = cpu.FPUConditions[cond]
+	}
+	return ok
+}
+
+// decodeLineF decodes the line 1111 instructions of the 68040 and 68060:
+// MOVE16 and the FPU subset they implement in hardware. Anything else is
+// left as data.
+func decodeLineF(op uint16, pc int, code []byte) (string, string, int) {
+	switch {
+	case op&0xFFF8 == cpu.OPMOVE16PostInc:
+		if pc+2 > len(code) {
+			break
+		}
+		ext := binary.BigEndian.Uint16(code[pc:])
+		if ext&0x8FFF != 0x8000 {
+			break
+		}
+		return "move16", fmt.Sprintf("(a%d)+,(a%d)+", op&7, ext>>12&7), 2
+	case op&0xFFE0 == cpu.OPMOVE16:
+		if pc+4 > len(code) {
+			break
+		}
+		abs := fmt.Sprintf("$%x.l", binary.BigEndian.Uint32(code[pc:]))
+		reg := op & 7
+		switch op >> 3 & 3 {
+		case 0:
+			return "move16", fmt.Sprintf("(a%d)+,%s", reg, abs), 4
+		case 1:
+			return "move16", fmt.Sprintf("%s,(a%d)+", abs, reg), 4
+		case 2:
+			return "move16", fmt.Sprintf("(a%d),%s", reg, abs), 4
+		default:
+			return "move16", fmt.Sprintf("%s,(a%d)", abs, reg), 4
+		}
+	case op&0xFF80 == cpu.OPFBcc:
+		return decodeFBcc(op, pc, code)
+	case op&0xFFC0 == cpu.OPFSAVE, op&0xFFC0 == cpu.OPFRESTORE:
+		mn := "fsave"
+		if op&0xFFC0 == cpu.OPFRESTORE {
+			mn = "frestore"
+		}
+		ea, used := DecodeEA(op&0x3F, pc, code, 2)
+		return mn, ea, used
+	case op&0xFFC0 == cpu.OPFPU:
+		if mn, ops, used, ok := decodeFPU(op, pc, code); ok {
+			return mn, ops, used
+		}
+	}
+	return "dc.w", fmt.Sprintf("0x%04x", op), 0
+}
+
+// decodeFBcc decodes FBcc and FNOP, which is FBF with a zero displacement.
+func decodeFBcc(op uint16, pc int, code []byte) (string, string, int) {
+	name, ok := fpuConditionNames[op&0x3F]
+	if !ok {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	if op&0x40 != 0 {
+		if pc+4 > len(code) {
+			return "fb" + name + ".l", "?", 0
+		}
+		disp := int32(binary.BigEndian.Uint32(code[pc:]))
+		return "fb" + name + ".l", formatDisp(int64(disp)), 4
+	}
+	if pc+2 > len(code) {
+		return "fb" + name, "?", 0
+	}
+	disp := int16(binary.BigEndian.Uint16(code[pc:]))
+	if op == cpu.OPFBcc && disp == 0 {
+		return "fnop", "", 2
+	}
+	return "fb" + name, formatDisp(int64(disp)), 2
+}
+
+// decodeFPU decodes a general FPU instruction from its command word. It
+// reports false for the forms outside the subset.
+func decodeFPU(op uint16, pc int, code []byte) (string, string, int, bool) {
+	if pc+2 > len(code) {
+		return "", "", 0, false
+	}
+	cmd := binary.BigEndian.Uint16(code[pc:])
+	ea := op & 0x3F
+	fmtField := cmd >> 10 & 7
+	switch cmd >> 13 {
+	case 0: // FPm,FPn
+		name, ok := fpuOpmodeNames[cmd&0x7F]
+		if !ok || ea != 0 {
+			return "", "", 0, false
+		}
+		src, dst := cmd>>10&7, cmd>>7&7
+		if name == "ftst" || src == dst && name != "fmove" && isMonadic(cmd&0x7F) {
+			return name + ".x", fmt.Sprintf("fp%d", src), 2, true
+		}
+		return name + ".x", fmt.Sprintf("fp%d,fp%d", src, dst), 2, true
+	case 2: // <ea>,FPn
+		name, ok := fpuOpmodeNames[cmd&0x7F]
+		if !ok || fmtField == 7 {
+			return "", "", 0, false
+		}
+		src, used, ok := decodeFPEA(ea, fmtField, pc+2, code)
+		if !ok {
+			return "", "", 0, false
+		}
+		if name == "ftst" {
+			return name + fpuFormatSuffixes[fmtField], src, 2 + used, true
+		}
+		return name + fpuFormatSuffixes[fmtField], fmt.Sprintf("%s,fp%d", src, cmd>>7&7), 2 + used, true
+	case 3: // FMOVE FPn,<ea>, with a k-factor for the packed format
+		var k string
+		switch {
+		case fmtField == 7 && cmd&0x0F != 0, fmtField != 3 && fmtField != 7 && cmd&0x7F != 0:
+			return "", "", 0, false
+		case fmtField == 7:
+			fmtField, k = 3, fmt.Sprintf("{d%d}", cmd>>4&7)
+		case fmtField == 3 && cmd&0x7F != 0:
+			k = fmt.Sprintf("{#%d}", int8(cmd<<1)>>1)
+		}
+		if ea>>3 == 1 || ea >= 0x3A {
+			return "", "", 0, false
+		}
+		dst, used, ok := decodeFPEA(ea, fmtField, pc+2, code)
+		if !ok {
+			return "", "", 0, false
+		}
+		return "fmove" + fpuFormatSuffixes[fmtField], fmt.Sprintf("fp%d,%s%s", cmd>>7&7, dst, k), 2 + used, true
+	case 4, 5: // FMOVE(M) to and from the control registers
+		sel := cmd >> 10 & 7
+		if sel == 0 || cmd&0x3FF != 0 {
+			return "", "", 0, false
+		}
+		mn := "fmove.l"
+		if sel&(sel-1) != 0 {
+			mn = "fmovem.l"
+		}
+		other, used := DecodeEA(ea, pc+2, code, 2)
+		regs := fpControlList(sel)
+		if cmd>>13 == 4 {
+			return mn, other + "," + regs, 2 + used, true
+		}
+		return mn, regs + "," + other, 2 + used, true
+	case 6, 7: // FMOVEM.X
+		mode := cmd >> 11 & 3
+		mask := cmd & 0xFF
+		if mode&1 != 0 || mask == 0 || cmd&0x0700 != 0 || ea>>3 <= 1 {
+			return "", "", 0, false
+		}
+		var data uint8
+		for n := range 8 {
+			bit := uint16(1) << n
+			if mode == 2 {
+				bit = 0x80 >> n
+			}
+			if mask&bit != 0 {
+				data |= 1 << n
+			}
+		}
+		other, used := DecodeEA(ea, pc+2, code, 2)
+		if cmd>>13 == 7 {
+			return "fmovem.x", fpDataList(data) + "," + other, 2 + used, true
+		}
+		return "fmovem.x", other + "," + fpDataList(data), 2 + used, true
+	}
+	return "", "", 0, false
+}
+
+// isMonadic reports whether an opmode takes a single operand.
+func isMonadic(opmode uint16) bool {
+	switch fpuOpmodeNames[opmode] {
+	case "fint", "fintrz", "fsqrt", "fabs", "fneg", "fssqrt", "fdsqrt", "fsabs", "fdabs", "fsneg", "fdneg":
+		return true
+	}
+	return false
+}
+
+// decodeFPEA decodes the effective address of an FPU operand in format f,
+// with immediate reals shown as decimals.
+func decodeFPEA(ea, f uint16, pc int, code []byte) (string, int, bool) {
+	if ea>>3 == 1 || ea>>3 == 0 && fpuFormatBytes[f] > 4 {
+		return "", 0, false
+	}
+	if ea != 0x3C {
+		text, used := DecodeEA(ea, pc, code, 2)
+		return text, used, true
+	}
+	n := fpuFormatBytes[f]
+	if n < 2 {
+		n = 2
+	}
+	if pc+n > len(code) {
+		return "", 0, false
+	}
+	b := code[pc : pc+n]
+	var v float64
+	switch f {
+	case 0:
+		return fmt.Sprintf("#%d", int32(binary.BigEndian.Uint32(b))), n, true
+	case 4:
+		return fmt.Sprintf("#%d", int16(binary.BigEndian.Uint16(b))), n, true
+	case 6:
+		return fmt.Sprintf("#%d", int8(b[1])), n, true
+	case 1:
+		v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 5:
+		v = math.Float64frombits(binary.BigEndian.Uint64(b))
+	case 2:
+		var w [6]uint16
+		for i := range w {
+			w[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		v = cpu.ExtendedFloat(w)
+	default:
+		return "", 0, false // Packed immediates
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return "#" + s, n, true
+}
+
+// fpControlList lists the control registers in select bits sel.
+func fpControlList(sel uint16) string {
+	var regs []string
+	for _, r := range []string{"fpcr", "fpsr", "fpiar"} {
+		if sel&cpu.FPUControlRegisters[r] != 0 {
+			regs = append(regs, r)
+		}
+	}
+	return strings.Join(regs, "/")
+}
+
+// fpDataList lists the data registers with bit n set for FPn, with runs as
+// ranges.
+func fpDataList(data uint8) string {
+	var parts []string
+	for n := 0; n < 8; n++ {
+		if data&(1<<n) == 0 {
+			continue
+		}
+		end := n
+		for end < 7 && data&(1<<(end+1)) != 0 {
+			end++
+		}
+		if end > n {
+			parts = append(parts, fmt.Sprintf("fp%d-fp%d", n, end))
+		} else {
+			parts = append(parts, fmt.Sprintf("fp%d", n))
+		}
+		n = end
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,155 @@
+package disassembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterProfile("gemdos", func() Profile { return &gemdosProfile{} })
+}
+
+// Special values of GEMDOS arguments.
+var (
+	gemdosOpenMode  = map[uint32]string{0: "read", 1: "write", 2: "read/write"}
+	gemdosSeekMode  = map[uint32]string{0: "from start", 1: "from current", 2: "from end"}
+	gemdosExecMode  = map[uint32]string{0: "load and go", 3: "load", 4: "go", 5: "create basepage", 6: "go and free"}
+	gemdosStdHandle = map[uint32]string{0: "stdin", 1: "stdout", 2: "stdaux", 3: "stdprn"}
+)
+
+// gemdosCalls holds the GEMDOS functions of the Atari ST, called with TRAP #1
+// after pushing the arguments, last first, and then the function number as
+// a word.
+var gemdosCalls = map[uint32]sysCall{
+	0x00: {name: "Pterm0"},
+	0x01: {name: "Cconin"},
+	0x02: {name: "Cconout", params: []string{"char"}},
+	0x03: {name: "Cauxin"},
+	0x04: {name: "Cauxout", params: []string{"char"}},
+	0x05: {name: "Cprnout", params: []string{"char"}},
+	0x06: {name: "Crawio", params: []string{"char"}, values: map[int]map[uint32]string{0: {0xFF: "input"}}},
+	0x07: {name: "Crawcin"},
+	0x08: {name: "Cnecin"},
+	0x09: {name: "Cconws", params: []string{"string"}},
+	0x0A: {name: "Cconrs", params: []string{"buffer"}},
+	0x0B: {name: "Cconis"},
+	0x0E: {name: "Dsetdrv", params: []string{"drive"}},
+	0x10: {name: "Cconos"},
+	0x11: {name: "Cprnos"},
+	0x12: {name: "Cauxis"},
+	0x13: {name: "Cauxos"},
+	0x19: {name: "Dgetdrv"},
+	0x1A: {name: "Fsetdta", params: []string{"dta"}},
+	0x20: {name: "Super", params: []string{"stack"}, values: map[int]map[uint32]string{0: {0: "enter", 1: "inquire"}}},
+	0x2A: {name: "Tgetdate"},
+	0x2B: {name: "Tsetdate", params: []string{"date"}},
+	0x2C: {name: "Tgettime"},
+	0x2D: {name: "Tsettime", params: []string{"time"}},
+	0x2F: {name: "Fgetdta"},
+	0x30: {name: "Sversion"},
+	0x31: {name: "Ptermres", params: []string{"keep", "code"}},
+	0x36: {name: "Dfree", params: []string{"buffer", "drive"}, values: map[int]map[uint32]string{1: {0: "current"}}},
+	0x39: {name: "Dcreate", params: []string{"path"}},
+	0x3A: {name: "Ddelete", params: []string{"path"}},
+	0x3B: {name: "Dsetpath", params: []string{"path"}},
+	0x3C: {name: "Fcreate", params: []string{"name", "attr"}},
+	0x3D: {name: "Fopen", params: []string{"name", "mode"}, values: map[int]map[uint32]string{1: gemdosOpenMode}},
+	0x3E: {name: "Fclose", params: []string{"handle"}},
+	0x3F: {name: "Fread", params: []string{"handle", "count", "buffer"}, values: map[int]map[uint32]string{0: gemdosStdHandle}},
+	0x40: {name: "Fwrite", params: []string{"handle", "count", "buffer"}, values: map[int]map[uint32]string{0: gemdosStdHandle}},
+	0x41: {name: "Fdelete", params: []string{"name"}},
+	0x42: {name: "Fseek", params: []string{"offset", "handle", "mode"}, values: map[int]map[uint32]string{2: gemdosSeekMode}},
+	0x43: {name: "Fattrib", params: []string{"name", "set", "attr"}, values: map[int]map[uint32]string{1: {0: "get", 1: "set"}}},
+	0x45: {name: "Fdup", params: []string{"handle"}, values: map[int]map[uint32]string{0: gemdosStdHandle}},
+	0x46: {name: "Fforce", params: []string{"std", "handle"}, values: map[int]map[uint32]string{0: gemdosStdHandle}},
+	0x47: {name: "Dgetpath", params: []string{"buffer", "drive"}, values: map[int]map[uint32]string{1: {0: "current"}}},
+	0x48: {name: "Malloc", params: []string{"size"}, values: map[int]map[uint32]string{0: {0xFFFFFFFF: "largest free"}}},
+	0x49: {name: "Mfree", params: []string{"block"}},
+	0x4A: {name: "Mshrink", params: []string{"zero", "block", "size"}},
+	0x4B: {name: "Pexec", params: []string{"mode", "name", "cmdline", "env"}, values: map[int]map[uint32]string{0: gemdosExecMode}},
+	0x4C: {name: "Pterm", params: []string{"code"}},
+	0x4E: {name: "Fsfirst", params: []string{"spec", "attr"}},
+	0x4F: {name: "Fsnext"},
+	0x56: {name: "Frename", params: []string{"zero", "old", "new"}},
+	0x57: {name: "Fdatime", params: []string{"timeptr", "handle", "set"}, values: map[int]map[uint32]string{2: {0: "get", 1: "set"}}},
+}
+
+// gemdosPush is a value pushed onto the stack.
+type gemdosPush struct {
+	size    int    // In bytes
+	operand string // The source, such as "#9" or "a0", or "" if computed
+}
+
+// gemdosProfile names GEMDOS calls. It follows the values pushed onto the
+// stack since the last label and comments each TRAP #1 with the call they
+// make.
+type gemdosProfile struct {
+	pushes []gemdosPush
+}
+
+// Reset forgets the tracked pushes.
+func (p *gemdosProfile) Reset() {
+	p.pushes = p.pushes[:0]
+}
+
+// Instruction follows the stack and describes GEMDOS calls.
+func (p *gemdosProfile) Instruction(inst *Instruction) string {
+	if n, ok := trapNumber(inst); ok && n == 1 {
+		note := p.describe()
+		p.Reset()
+		return note
+	}
+	if isCall(inst) {
+		p.Reset()
+		return ""
+	}
+	ops := splitOperands(inst.Operands)
+	if inst.Mnemonic == "pea" {
+		p.pushes = append(p.pushes, gemdosPush{size: 4, operand: ops[0]})
+		return ""
+	}
+	if len(ops) > 0 && ops[len(ops)-1] == "-(a7)" {
+		size := 2
+		if strings.HasSuffix(inst.Mnemonic, ".l") {
+			size = 4
+		}
+		if !strings.HasPrefix(inst.Mnemonic, "move.") && !strings.HasPrefix(inst.Mnemonic, "clr.") {
+			p.Reset() // Such as MOVEM, whose pushes aren't followed
+			return ""
+		}
+		p.pushes = append(p.pushes, gemdosPush{size: size, operand: loadedOperand(inst, ops)})
+		return ""
+	}
+	if writesRegister(inst, ops, "a7") || strings.Contains(inst.Operands, "(a7)+") {
+		// The stack pointer moved, as when tidying up after a call.
+		p.Reset()
+	}
+	return ""
+}
+
+// describe names the call the pushes make.
+func (p *gemdosProfile) describe() string {
+	if len(p.pushes) == 0 {
+		return "GEMDOS call"
+	}
+	top := p.pushes[len(p.pushes)-1]
+	fn, ok := parseImmediate(top.operand)
+	if !ok || top.size != 2 {
+		return "GEMDOS call"
+	}
+	note := fmt.Sprintf("GEMDOS $%02x", fn)
+	call, ok := gemdosCalls[fn]
+	if !ok {
+		return note
+	}
+	args := make([]string, 0, len(call.params))
+	for i := len(p.pushes) - 2; i >= 0 && len(args) < len(call.params); i-- {
+		args = append(args, p.pushes[i].operand)
+	}
+	return note + " " + call.describe(args)
+}
+
+// Data leaves data to the default rules.
+func (p *gemdosProfile) Data(data []byte, addr uint32) (string, int) {
+	return "", 0
+}
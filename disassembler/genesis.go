@@ -0,0 +1,193 @@
+package disassembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterProfile("genesis", func() Profile { return &genesisProfile{} })
+}
+
+// Genesis/Mega Drive I/O addresses.
+const (
+	genesisVDPData    = 0xC00000
+	genesisVDPControl = 0xC00004
+	genesisVDPHV      = 0xC00008
+	genesisPSG        = 0xC00011
+)
+
+// genesisPorts names the fixed I/O addresses outside the VDP.
+var genesisPorts = map[uint32]string{
+	0xA10001: "version register",
+	0xA10003: "I/O data 1",
+	0xA10005: "I/O data 2",
+	0xA10007: "I/O data EXP",
+	0xA10009: "I/O control 1",
+	0xA1000B: "I/O control 2",
+	0xA1000D: "I/O control EXP",
+	0xA11100: "Z80 bus request",
+	0xA11200: "Z80 reset",
+	0xA14000: "TMSS",
+}
+
+// genesisPort names an I/O address. The I/O registers sit on odd addresses
+// but are commonly accessed with word moves to the even address below.
+func genesisPort(addr uint32) (string, bool) {
+	if addr >= 0xA10000 && addr < 0xA10020 {
+		addr |= 1
+	}
+	name, ok := genesisPorts[addr]
+	return name, ok
+}
+
+// genesisVDPRegs names the VDP registers set through the control port.
+var genesisVDPRegs = map[uint16]string{
+	0: "mode 1", 1: "mode 2", 2: "plane A table", 3: "window table",
+	4: "plane B table", 5: "sprite table", 7: "background colour",
+	10: "H-interrupt counter", 11: "mode 3", 12: "mode 4", 13: "H-scroll table",
+	15: "auto-increment", 16: "plane size", 17: "window H position",
+	18: "window V position", 19: "DMA length low", 20: "DMA length high",
+	21: "DMA source low", 22: "DMA source mid", 23: "DMA source high",
+}
+
+// genesisMode2 names the flags of VDP register 1.
+var genesisMode2 = map[int]string{6: "DISP", 5: "IE0", 4: "DMA", 3: "V30"}
+
+// genesisTargets names the access codes of a VDP address command.
+var genesisTargets = map[uint32]string{
+	0x0: "VRAM read", 0x1: "VRAM write", 0x3: "CRAM write",
+	0x4: "VSRAM read", 0x5: "VSRAM write", 0x8: "CRAM read",
+}
+
+// genesisProfile annotates VDP and I/O port accesses.
+type genesisProfile struct {
+	regs baseTracker
+	// target describes where the last address command pointed the data port.
+	target string
+}
+
+// Reset forgets the tracked registers and VDP address.
+func (p *genesisProfile) Reset() {
+	p.regs.keep = func(addr uint32) bool {
+		return addr >= 0xA00000 && addr < 0xC00020
+	}
+	p.regs.reset()
+	p.target = ""
+}
+
+// Instruction describes VDP port accesses, register writes and address commands.
+func (p *genesisProfile) Instruction(inst *Instruction) string {
+	if inst.Operands == "" {
+		return ""
+	}
+	ops := splitOperands(inst.Operands)
+	if inst.Mnemonic == "lea" || inst.Mnemonic == "pea" {
+		p.regs.track(inst, ops)
+		return ""
+	}
+
+	long := strings.HasSuffix(inst.Mnemonic, ".l")
+	var notes []string
+	for i, op := range ops {
+		addr, ok := p.regs.resolve(op)
+		if !ok {
+			continue
+		}
+		write := i == len(ops)-1 && len(ops) > 1
+
+		switch addr &^ 2 {
+		case genesisVDPData:
+			note := "VDP data"
+			if write && p.target != "" {
+				note += " -> " + p.target
+			}
+			notes = append(notes, note)
+		case genesisVDPControl:
+			if !write {
+				notes = append(notes, "VDP status")
+				continue
+			}
+			v, ok := parseImmediate(ops[0])
+			if !ok {
+				notes = append(notes, "VDP control")
+				continue
+			}
+			notes = append(notes, p.control(v, long))
+		default:
+			if addr&^7 == genesisVDPHV {
+				notes = append(notes, "VDP H/V counter")
+			} else if addr == genesisPSG {
+				notes = append(notes, "PSG")
+			} else if name, ok := genesisPort(addr); ok {
+				notes = append(notes, name)
+			}
+		}
+	}
+
+	p.regs.track(inst, ops)
+	return strings.Join(notes, ", ")
+}
+
+// control decodes a constant written to the VDP control port.
+func (p *genesisProfile) control(v uint32, long bool) string {
+	if !long {
+		return genesisControlWord(uint16(v))
+	}
+
+	hi := uint16(v >> 16)
+	if hi&0xC000 == 0x8000 {
+		// Two register writes in one long.
+		return genesisControlWord(hi) + "; " + genesisControlWord(uint16(v))
+	}
+
+	code := (v>>30)&3 | (v>>2)&0x3C
+	addr := (v>>16)&0x3FFF | (v&3)<<14
+	name, ok := genesisTargets[code&0xF]
+	if !ok {
+		name = fmt.Sprintf("access code $%x", code&0xF)
+	}
+	p.target = fmt.Sprintf("%s $%04x", name, addr)
+	if code&0x20 != 0 {
+		return "VDP DMA " + p.target
+	}
+	return "VDP " + p.target
+}
+
+// genesisControlWord decodes a single word written to the control port.
+func genesisControlWord(w uint16) string {
+	if w&0xC000 != 0x8000 {
+		return "VDP address command (first word)"
+	}
+
+	reg := (w >> 8) & 0x1F
+	val := w & 0xFF
+	name, ok := genesisVDPRegs[reg]
+	if !ok {
+		name = "unused"
+	}
+
+	desc := fmt.Sprintf("VDP reg %d (%s) = $%02x", reg, name, val)
+	switch reg {
+	case 2:
+		desc += fmt.Sprintf(", VRAM $%04x", uint32(val&0x38)<<10)
+	case 3:
+		desc += fmt.Sprintf(", VRAM $%04x", uint32(val&0x3E)<<10)
+	case 4:
+		desc += fmt.Sprintf(", VRAM $%04x", uint32(val&0x07)<<13)
+	case 5:
+		desc += fmt.Sprintf(", VRAM $%04x", uint32(val&0x7F)<<9)
+	case 13:
+		desc += fmt.Sprintf(", VRAM $%04x", uint32(val&0x3F)<<10)
+	case 1:
+		if bits := decodeBits(val, genesisMode2); bits != "" {
+			desc += " " + bits
+		}
+	}
+	return desc
+}
+
+// Data leaves all data to the default rules.
+func (p *genesisProfile) Data(data []byte, addr uint32) (string, int) {
+	return "", 0
+}
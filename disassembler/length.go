@@ -0,0 +1,86 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownOpcode is returned by InstructionLength for a word that doesn't
+// start an instruction.
+var ErrUnknownOpcode = errors.New("unknown opcode")
+
+// ErrTruncated is returned by InstructionLength when the instruction runs past
+// the end of the code.
+var ErrTruncated = errors.New("instruction is truncated")
+
+// maxInstructionLength is the longest 68000 instruction, e.g. move.l #imm,abs.l.
+const maxInstructionLength = 10
+
+// variableLength marks opcodes in decodedLengths whose length depends on their
+// extension words.
+const variableLength = 0xFF
+
+// decodedLengths holds the length in bytes of the instruction each opcode
+// starts, or 0 if it is unknown. It is filled on first use by running the full
+// decoder, text and all, over every opcode; there is no separate length
+// decoder to keep in step with it. On the 68000 the first word alone decides
+// the length. The 68020's full extension words, and the extension words some
+// later instructions check, make a few opcodes depend on what follows; they
+// are found by decoding each opcode again with different extension words, and
+// marked variableLength.
+var decodedLengths = sync.OnceValue(func() *[65536]uint8 {
+	var table [65536]uint8
+	zero := make([]byte, 2+maxInstructionLength)
+	full := make([]byte, 2+maxInstructionLength+16)
+	for i := 2; i < len(full); i += 2 {
+		// A full extension word with a long base displacement and a long
+		// outer displacement, post-indexed.
+		full[i], full[i+1] = 0x01, 0x37
+	}
+	for op := range table {
+		mn, _, used := decode(uint16(op), 2, zero)
+		fmn, _, fused := decode(uint16(op), 2, full)
+		switch {
+		case mn != fmn || used != fused:
+			table[op] = variableLength
+		case mn != "dc.w":
+			table[op] = uint8(2 + used)
+		}
+	}
+	return &table
+})
+
+// InstructionLength returns the length in bytes of the instruction at the
+// start of code, the same as the Size DecodeRange gives it. The first call
+// decodes all 65536 opcodes to build a length table; after that a length is a
+// table lookup, except for opcodes marked variableLength, which are decoded
+// again in full each time. It is meant for tools that step over many
+// instructions, such as patchers and coverage counters.
+func InstructionLength(code []byte) (int, error) {
+	if len(code) < 2 {
+		return 0, ErrTruncated
+	}
+	op := binary.BigEndian.Uint16(code)
+	n := int(decodedLengths()[op])
+	if n == variableLength {
+		// Zeros past the end still decode, so a truncated instruction
+		// comes out longer than the code.
+		padded := make([]byte, len(code)+maxInstructionLength+16)
+		copy(padded, code)
+		mn, _, used := decode(op, 2, padded)
+		if mn == "dc.w" {
+			n = 0
+		} else {
+			n = 2 + used
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w $%04x", ErrUnknownOpcode, op)
+	}
+	if n > len(code) {
+		return 0, fmt.Errorf("%w: %d bytes needed, %d left", ErrTruncated, n, len(code))
+	}
+	return n, nil
+}
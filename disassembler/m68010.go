@@ -0,0 +1,65 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// controlRegisterNames names the control registers MOVEC can reach.
+var controlRegisterNames = map[uint16]string{
+	cpu.ControlSFC: "sfc",
+	cpu.ControlDFC: "dfc",
+	cpu.ControlUSP: "usp",
+	cpu.ControlVBR: "vbr",
+}
+
+// generalRegisterName names the register in bits 15-12 of a MOVEC or MOVES
+// register word.
+func generalRegisterName(ext uint16) string {
+	if ext&0x8000 != 0 {
+		return fmt.Sprintf("a%d", ext>>12&7)
+	}
+	return fmt.Sprintf("d%d", ext>>12&7)
+}
+
+// decodeMovec decodes MOVEC Rc,Rn and MOVEC Rn,Rc. A control register the
+// 68010 doesn't have leaves the word as data.
+func decodeMovec(op uint16, pc int, code []byte) (string, string, int) {
+	if pc+2 > len(code) {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	ext := binary.BigEndian.Uint16(code[pc:])
+	rc, ok := controlRegisterNames[ext&0xFFF]
+	if !ok {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	rn := generalRegisterName(ext)
+	if op&1 != 0 {
+		return "movec", fmt.Sprintf("%s,%s", rn, rc), 2
+	}
+	return "movec", fmt.Sprintf("%s,%s", rc, rn), 2
+}
+
+// decodeMoves decodes MOVES Rn,<ea> and MOVES <ea>,Rn.
+func decodeMoves(op uint16, pc int, code []byte) (string, string, int) {
+	if pc+2 > len(code) {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	ext := binary.BigEndian.Uint16(code[pc:])
+	if ext&0x07FF != 0 {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	mode, reg := op>>3&7, op&7
+	if op&0x00C0 == 0x00C0 || mode < 2 || mode == 7 && reg > 1 {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	size := (op >> 6) & 3
+	eaText, used := DecodeEA(op&0x3F, pc+2, code, size)
+	rn := generalRegisterName(ext)
+	if ext&0x0800 != 0 {
+		return "moves" + SizeSuffix(size), fmt.Sprintf("%s,%s", rn, eaText), 2 + used
+	}
+	return "moves" + SizeSuffix(size), fmt.Sprintf("%s,%s", eaText, rn), 2 + used
+}
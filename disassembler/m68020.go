@@ -0,0 +1,200 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// bitFieldNames names the bit field instructions by bits 10-8 of the opcode.
+var bitFieldNames = [8]string{"bftst", "bfextu", "bfchg", "bfexts", "bfclr", "bfffo", "bfset", "bfins"}
+
+// decode68020 decodes the instructions the 68020 added. They sit in holes
+// between older instructions, so they are tried first. The last result is
+// false for anything else.
+func decode68020(op uint16, pc int, code []byte) (string, string, int, bool) {
+	mode, reg := op>>3&7, op&7
+	switch {
+	case op&0xFFF8 == cpu.OPEXTB:
+		return "extb.l", fmt.Sprintf("d%d", reg), 0, true
+	case op&0xFF80 == cpu.OPMULL && mode != 1 && validEA(mode, reg, true):
+		return decodeMulDivLong(op, pc, code)
+	case op&0xFDFF == cpu.OPCAS2:
+		return decodeCas2(op, pc, code)
+	case op&0xF9C0 == cpu.OPCAS && op&0x0600 != 0 && mode >= 2 && validEA(mode, reg, false):
+		return decodeCas(op, pc, code)
+	case op&0xF1F0 == cpu.OPPACK || op&0xF1F0 == cpu.OPUNPK:
+		return decodePack(op, pc, code)
+	case op&0xF0F8 == cpu.OPTRAPcc && reg >= 2 && reg <= 4:
+		return decodeTrapcc(op, pc, code)
+	case op&0xF8C0 == cpu.OPBitField && (mode == 0 || validEA(mode, reg, false) && mode != 3 && mode != 4 && (mode != 7 || reg < 4)):
+		return decodeBitField(op, pc, code)
+	}
+	return "", "", 0, false
+}
+
+// validEA reports whether mode and reg name an addressing mode. The PC
+// relative modes and immediate data are only valid when readable is set.
+func validEA(mode, reg uint16, readable bool) bool {
+	if mode != 7 {
+		return true
+	}
+	if readable {
+		return reg <= 4
+	}
+	return reg <= 1
+}
+
+// decodeMulDivLong decodes MULU.L, MULS.L, DIVU.L, DIVS.L, DIVUL.L and DIVSL.L.
+func decodeMulDivLong(op uint16, pc int, code []byte) (string, string, int, bool) {
+	if pc+2 > len(code) {
+		return "", "", 0, false
+	}
+	ext := binary.BigEndian.Uint16(code[pc:])
+	if ext&0x83F8 != 0 {
+		return "", "", 0, false
+	}
+	ea, used := DecodeEA(op&0x3F, pc+2, code, 2)
+	lo, hi := ext>>12&7, ext&7
+	signed := ext&0x0800 != 0
+	quad := ext&0x0400 != 0
+
+	if op&0xFFC0 == cpu.OPMULL {
+		mn := "mulu.l"
+		if signed {
+			mn = "muls.l"
+		}
+		if quad {
+			return mn, fmt.Sprintf("%s,d%d:d%d", ea, hi, lo), 2 + used, true
+		}
+		if hi != 0 {
+			return "", "", 0, false
+		}
+		return mn, fmt.Sprintf("%s,d%d", ea, lo), 2 + used, true
+	}
+
+	mn := "divu"
+	if signed {
+		mn = "divs"
+	}
+	switch {
+	case quad:
+		return mn + ".l", fmt.Sprintf("%s,d%d:d%d", ea, hi, lo), 2 + used, true
+	case hi == lo:
+		return mn + ".l", fmt.Sprintf("%s,d%d", ea, lo), 2 + used, true
+	}
+	return mn + "l.l", fmt.Sprintf("%s,d%d:d%d", ea, hi, lo), 2 + used, true
+}
+
+// decodeCas decodes CAS Dc,Du,<ea>.
+func decodeCas(op uint16, pc int, code []byte) (string, string, int, bool) {
+	if pc+2 > len(code) {
+		return "", "", 0, false
+	}
+	ext := binary.BigEndian.Uint16(code[pc:])
+	if ext&0xFE38 != 0 {
+		return "", "", 0, false
+	}
+	size := (op>>9)&3 - 1
+	ea, used := DecodeEA(op&0x3F, pc+2, code, size)
+	return "cas" + SizeSuffix(size), fmt.Sprintf("d%d,d%d,%s", ext&7, ext>>6&7, ea), 2 + used, true
+}
+
+// decodeCas2 decodes CAS2 Dc1:Dc2,Du1:Du2,(Rn1):(Rn2).
+func decodeCas2(op uint16, pc int, code []byte) (string, string, int, bool) {
+	if pc+4 > len(code) {
+		return "", "", 0, false
+	}
+	ext1 := binary.BigEndian.Uint16(code[pc:])
+	ext2 := binary.BigEndian.Uint16(code[pc+2:])
+	if ext1&0x0E38 != 0 || ext2&0x0E38 != 0 {
+		return "", "", 0, false
+	}
+	mn := "cas2.w"
+	if op&0x0200 != 0 {
+		mn = "cas2.l"
+	}
+	return mn, fmt.Sprintf("d%d:d%d,d%d:d%d,(%s):(%s)",
+		ext1&7, ext2&7, ext1>>6&7, ext2>>6&7, generalRegisterName(ext1), generalRegisterName(ext2)), 4, true
+}
+
+// decodePack decodes PACK and UNPK.
+func decodePack(op uint16, pc int, code []byte) (string, string, int, bool) {
+	if pc+2 > len(code) {
+		return "", "", 0, false
+	}
+	mn := "pack"
+	if op&0xF1F0 == cpu.OPUNPK {
+		mn = "unpk"
+	}
+	adj, used := readImmediateBySize(code, pc, 1)
+	rx, ry := op&7, op>>9&7
+	if op&0x0008 != 0 {
+		return mn, fmt.Sprintf("-(a%d),-(a%d),%s", rx, ry, adj), used, true
+	}
+	return mn, fmt.Sprintf("d%d,d%d,%s", rx, ry, adj), used, true
+}
+
+// decodeTrapcc decodes TRAPcc with no operand, a word or a long.
+func decodeTrapcc(op uint16, pc int, code []byte) (string, string, int, bool) {
+	mn := "trap" + condName(op>>8&0xF)
+	switch op & 7 {
+	case 2:
+		imm, used := readImmediateBySize(code, pc, 1)
+		return mn + ".w", imm, used, used != 0
+	case 3:
+		imm, used := readImmediateBySize(code, pc, 2)
+		return mn + ".l", imm, used, used != 0
+	}
+	return mn, "", 0, true
+}
+
+// decodeBitField decodes the BFxxx instructions, with the field written
+// {offset:width} after the operand.
+func decodeBitField(op uint16, pc int, code []byte) (string, string, int, bool) {
+	if pc+2 > len(code) {
+		return "", "", 0, false
+	}
+	ext := binary.BigEndian.Uint16(code[pc:])
+	kind := op >> 8 & 7
+	mode, reg := op>>3&7, op&7
+	writes := kind == 2 || kind == 4 || kind == 6 || kind == 7
+	if writes && mode == 7 && reg > 1 || ext&0x8000 != 0 {
+		return "", "", 0, false
+	}
+	hasReg := kind == 1 || kind == 3 || kind == 5 || kind == 7
+	if !hasReg && ext&0x7000 != 0 {
+		return "", "", 0, false
+	}
+
+	offset := fmt.Sprintf("%d", ext>>6&31)
+	if ext&0x0800 != 0 {
+		if ext&0x0600 != 0 {
+			return "", "", 0, false
+		}
+		offset = fmt.Sprintf("d%d", ext>>6&7)
+	}
+	width := fmt.Sprintf("%d", ext&31)
+	switch {
+	case ext&0x20 != 0:
+		if ext&0x18 != 0 {
+			return "", "", 0, false
+		}
+		width = fmt.Sprintf("d%d", ext&7)
+	case ext&31 == 0:
+		width = "32"
+	}
+	ea, used := DecodeEA(op&0x3F, pc+2, code, 2)
+	field := fmt.Sprintf("%s{%s:%s}", ea, offset, width)
+	dn := fmt.Sprintf("d%d", ext>>12&7)
+
+	mn := bitFieldNames[kind]
+	switch kind {
+	case 1, 3, 5:
+		return mn, field + "," + dn, 2 + used, true
+	case 7:
+		return mn, dn + "," + field, 2 + used, true
+	}
+	return mn, field, 2 + used, true
+}
@@ -0,0 +1,211 @@
+package disassembler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterProfile("68060", func() Profile { return &m68060Profile{} })
+}
+
+// m68060Timing describes how the 68060 issues an instruction.
+type m68060Timing struct {
+	// Superscalar is true for the pOEP|sOEP class, which can issue in either
+	// operand execution pipeline; the rest are pOEP-only.
+	Superscalar bool
+	// Latency is the number of cycles before the result can be used, for
+	// register operands.
+	Latency int
+}
+
+// m68060Timings is the metadata table behind the 68060 profile, by base
+// mnemonic, with the condition codes of Bcc, DBcc and Scc written as "cc".
+// Entries ending in ".l" override the base entry for long operations. The
+// classes follow the superscalar dispatch tables of the MC68060 User's
+// Manual; the latencies are the register-to-register execution times.
+var m68060Timings = map[string]m68060Timing{
+	"add": {true, 1}, "adda": {true, 1}, "addi": {true, 1}, "addq": {true, 1},
+	"sub": {true, 1}, "suba": {true, 1}, "subi": {true, 1}, "subq": {true, 1},
+	"and": {true, 1}, "andi": {true, 1}, "or": {true, 1}, "ori": {true, 1},
+	"eor": {true, 1}, "eori": {true, 1}, "not": {true, 1}, "neg": {true, 1},
+	"cmp": {true, 1}, "cmpa": {true, 1}, "cmpi": {true, 1}, "tst": {true, 1},
+	"move": {true, 1}, "movea": {true, 1}, "moveq": {true, 1}, "clr": {true, 1},
+	"lea": {true, 1}, "pea": {true, 1}, "ext": {true, 1}, "swap": {true, 1},
+	"asl": {true, 1}, "asr": {true, 1}, "lsl": {true, 1}, "lsr": {true, 1},
+	"rol": {true, 1}, "ror": {true, 1}, "nop": {true, 1}, "bcc": {true, 1},
+	"bra": {true, 1}, "bsr": {true, 1}, "scc": {true, 1},
+
+	"addx": {false, 1}, "subx": {false, 1}, "negx": {false, 1}, "cmpm": {false, 1},
+	"roxl": {false, 1}, "roxr": {false, 1}, "exg": {false, 1},
+	"abcd": {false, 1}, "sbcd": {false, 1}, "nbcd": {false, 1},
+	"btst": {false, 1}, "bchg": {false, 1}, "bclr": {false, 1}, "bset": {false, 1},
+	"mulu": {false, 2}, "muls": {false, 2},
+	"divu": {false, 22}, "divs": {false, 22}, "divu.l": {false, 38}, "divs.l": {false, 38},
+	"chk": {false, 3}, "tas": {false, 1}, "dbcc": {false, 1},
+	"jmp": {false, 1}, "jsr": {false, 1}, "rts": {false, 1}, "rtr": {false, 1},
+	"rte": {false, 1}, "link": {false, 2}, "unlk": {false, 2}, "movem": {false, 1},
+	"movep": {false, 1}, "trap": {false, 1}, "trapv": {false, 1}, "stop": {false, 1},
+	"reset": {false, 1}, "illegal": {false, 1},
+}
+
+// m68060Conditions are the condition codes, for recognising Bcc, DBcc and Scc.
+var m68060Conditions = map[string]bool{
+	"t": true, "f": true, "hi": true, "ls": true, "cc": true, "cs": true,
+	"ne": true, "eq": true, "vc": true, "vs": true, "pl": true, "mi": true,
+	"ge": true, "lt": true, "gt": true, "le": true, "ra": true,
+}
+
+// lookup68060 looks up an instruction in the table.
+func lookup68060(mnemonic string) (m68060Timing, bool) {
+	base, size, _ := strings.Cut(mnemonic, ".")
+	if t, ok := m68060Timings[base+"."+size]; ok {
+		return t, true
+	}
+	if t, ok := m68060Timings[base]; ok {
+		return t, true
+	}
+	for _, prefix := range []string{"db", "b", "s"} {
+		if cond, ok := strings.CutPrefix(base, prefix); ok && m68060Conditions[cond] {
+			t, ok := m68060Timings[prefix+"cc"]
+			return t, ok
+		}
+	}
+	return m68060Timing{}, false
+}
+
+// reRegister matches the data and address registers in an operand.
+var reRegister = regexp.MustCompile(`\b([da][0-7]|sp)\b`)
+
+// m68060Profile annotates each instruction with the pipeline the 68060 would
+// issue it to and its latency, in a simplified model of superscalar
+// dispatch: an instruction pairs with the one before it, issuing in the
+// secondary pipeline (sOEP) in the same cycle, if both are pOEP|sOEP, it is
+// at most 6 bytes long, it doesn't read a register the first writes, and
+// only one of the two accesses memory. An instruction that reads the result
+// of one with a longer latency is marked as waiting for it.
+type m68060Profile struct {
+	prev *m68060Issue
+}
+
+// m68060Issue is what the profile remembers about the last instruction.
+type m68060Issue struct {
+	timing m68060Timing
+	paired bool // Issued in the sOEP
+	writes []string
+	memory bool
+}
+
+// Reset forgets the previous instruction, since a label may be reached from
+// elsewhere.
+func (p *m68060Profile) Reset() {
+	p.prev = nil
+}
+
+// Instruction classifies inst and decides whether it pairs with the one
+// before.
+func (p *m68060Profile) Instruction(inst *Instruction) string {
+	t, ok := lookup68060(inst.Mnemonic)
+	if !ok {
+		p.prev = nil
+		return ""
+	}
+	reads, writes, memory := registerUse(inst)
+	cur := &m68060Issue{timing: t, writes: writes, memory: memory}
+
+	var waits string
+	if prev := p.prev; prev != nil {
+		dependent := ""
+		for _, r := range reads {
+			for _, w := range prev.writes {
+				if r == w {
+					dependent = r
+				}
+			}
+		}
+		cur.paired = t.Superscalar && prev.timing.Superscalar && !prev.paired &&
+			inst.Size <= 6 && dependent == "" && !(memory && prev.memory)
+		if dependent != "" && prev.timing.Latency > 1 {
+			waits = ", waits for " + dependent
+		}
+	}
+	if isTerminal(inst.Mnemonic) {
+		p.prev = nil
+	} else {
+		p.prev = cur
+	}
+
+	switch {
+	case cur.paired:
+		return fmt.Sprintf("sOEP, paired, latency %d%s", t.Latency, waits)
+	case t.Superscalar:
+		return fmt.Sprintf("pOEP, latency %d%s", t.Latency, waits)
+	default:
+		return fmt.Sprintf("pOEP only, latency %d%s", t.Latency, waits)
+	}
+}
+
+// Data leaves data to the default rules.
+func (p *m68060Profile) Data(data []byte, addr uint32) (string, int) {
+	return "", 0
+}
+
+// registerUse lists the registers inst reads and writes and reports whether
+// it accesses memory. Register lists, as in MOVEM, aren't followed.
+func registerUse(inst *Instruction) (reads, writes []string, memory bool) {
+	base, _, _ := strings.Cut(inst.Mnemonic, ".")
+	ops := splitOperands(inst.Operands)
+	if inst.Operands == "" {
+		ops = nil
+	}
+	for i, op := range ops {
+		op = strings.TrimSpace(op)
+		regs := reRegister.FindAllString(op, -1)
+		for j, r := range regs {
+			if r == "sp" {
+				regs[j] = "a7"
+			}
+		}
+		last := i == len(ops)-1
+		bare := len(regs) == 1 && len(op) <= 4 && !strings.ContainsAny(op, "(#")
+		switch {
+		case bare && last && writesOnly(base):
+			writes = append(writes, regs[0])
+		case bare && last && !readsOnly(base):
+			reads = append(reads, regs[0])
+			writes = append(writes, regs[0])
+		default:
+			reads = append(reads, regs...)
+			if strings.Contains(op, ")+") || strings.HasPrefix(op, "-(") {
+				writes = append(writes, regs[0])
+			}
+		}
+		if base != "lea" && !isBranchMnemonic(inst.Mnemonic) && (strings.Contains(op, "(") || strings.HasPrefix(op, "$")) {
+			memory = true
+		}
+	}
+	if base == "exg" {
+		writes = append(writes, reads...)
+	}
+	return reads, writes, memory
+}
+
+// writesOnly reports whether an instruction overwrites its destination
+// register without reading it.
+func writesOnly(base string) bool {
+	switch base {
+	case "move", "movea", "moveq", "lea", "clr":
+		return true
+	}
+	return len(base) > 1 && base[0] == 's' && m68060Conditions[base[1:]]
+}
+
+// readsOnly reports whether an instruction only reads its destination.
+func readsOnly(base string) bool {
+	switch base {
+	case "cmp", "cmpa", "cmpi", "cmpm", "tst", "btst", "chk":
+		return true
+	}
+	return len(base) > 1 && base[0] == 'b' && m68060Conditions[base[1:]]
+}
@@ -0,0 +1,335 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Resource is one resource from a classic Mac OS resource fork.
+type Resource struct {
+	Type       string // Four-character type, such as "CODE"
+	ID         int16
+	Name       string
+	Attributes uint8
+	Data       []byte
+}
+
+// Wrappers that carry a resource fork along with a file's data fork.
+const (
+	appleSingleMagic  = 0x00051600
+	appleDoubleMagic  = 0x00051607
+	appleResourceFork = 2 // AppleSingle and AppleDouble entry holding the resource fork
+	macBinaryHeader   = 128
+)
+
+// ParseResourceFork reads the resources in a resource fork. The fork may be
+// raw, as copied from a file's ..namedfork/rsrc, or wrapped in MacBinary,
+// AppleSingle or AppleDouble, which is how it usually survives on other
+// systems.
+func ParseResourceFork(data []byte) ([]Resource, error) {
+	fork, err := unwrapResourceFork(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fork) < 16 {
+		return nil, errors.New("resource fork is too short")
+	}
+	dataOff := binary.BigEndian.Uint32(fork[0:])
+	mapOff := binary.BigEndian.Uint32(fork[4:])
+	mapLen := binary.BigEndian.Uint32(fork[12:])
+	if uint64(mapOff)+uint64(mapLen) > uint64(len(fork)) || mapLen < 30 || dataOff > uint32(len(fork)) {
+		return nil, errors.New("resource map lies outside the fork")
+	}
+	m := fork[mapOff : mapOff+mapLen]
+	typeList := int(binary.BigEndian.Uint16(m[24:]))
+	nameList := int(binary.BigEndian.Uint16(m[26:]))
+	if typeList+2 > len(m) {
+		return nil, errors.New("resource type list lies outside the map")
+	}
+	types := int(int16(binary.BigEndian.Uint16(m[typeList:]))) + 1
+
+	var res []Resource
+	for i := range types {
+		t := typeList + 2 + i*8
+		if t+8 > len(m) {
+			return nil, errors.New("resource type list is truncated")
+		}
+		typ := string(m[t : t+4])
+		count := int(binary.BigEndian.Uint16(m[t+4:])) + 1
+		refs := typeList + int(binary.BigEndian.Uint16(m[t+6:]))
+		for j := range count {
+			r := refs + j*12
+			if r+12 > len(m) {
+				return nil, fmt.Errorf("references to %q resources are truncated", typ)
+			}
+			rsrc := Resource{
+				Type:       typ,
+				ID:         int16(binary.BigEndian.Uint16(m[r:])),
+				Attributes: m[r+4],
+			}
+			if n := binary.BigEndian.Uint16(m[r+2:]); n != 0xFFFF {
+				at := nameList + int(n)
+				if at < len(m) && at+1+int(m[at]) <= len(m) {
+					rsrc.Name = string(m[at+1 : at+1+int(m[at])])
+				}
+			}
+			off := uint64(dataOff) + uint64(binary.BigEndian.Uint32(m[r+4:])&0xFFFFFF)
+			if off+4 > uint64(len(fork)) {
+				return nil, fmt.Errorf("%s %d lies outside the fork", typ, rsrc.ID)
+			}
+			size := uint64(binary.BigEndian.Uint32(fork[off:]))
+			if off+4+size > uint64(len(fork)) {
+				return nil, fmt.Errorf("%s %d is truncated", typ, rsrc.ID)
+			}
+			rsrc.Data = fork[off+4 : off+4+size]
+			res = append(res, rsrc)
+		}
+	}
+	return res, nil
+}
+
+// unwrapResourceFork returns the resource fork inside an AppleSingle,
+// AppleDouble or MacBinary file, or data itself.
+func unwrapResourceFork(data []byte) ([]byte, error) {
+	if len(data) >= 26 {
+		if magic := binary.BigEndian.Uint32(data); magic == appleSingleMagic || magic == appleDoubleMagic {
+			n := int(binary.BigEndian.Uint16(data[24:]))
+			for i := range n {
+				e := 26 + i*12
+				if e+12 > len(data) {
+					break
+				}
+				if binary.BigEndian.Uint32(data[e:]) != appleResourceFork {
+					continue
+				}
+				off := uint64(binary.BigEndian.Uint32(data[e+4:]))
+				size := uint64(binary.BigEndian.Uint32(data[e+8:]))
+				if off+size > uint64(len(data)) {
+					return nil, errors.New("resource fork entry lies outside the file")
+				}
+				return data[off : off+size], nil
+			}
+			return nil, errors.New("file has no resource fork")
+		}
+	}
+	if len(data) >= macBinaryHeader && data[0] == 0 && data[1] >= 1 && data[1] <= 63 && data[74] == 0 && data[82] == 0 {
+		dataLen := uint64(binary.BigEndian.Uint32(data[83:]))
+		rsrcLen := uint64(binary.BigEndian.Uint32(data[87:]))
+		off := macBinaryHeader + (dataLen+macBinaryHeader-1)/macBinaryHeader*macBinaryHeader
+		if rsrcLen > 0 && off+rsrcLen <= uint64(len(data)) {
+			return data[off : off+rsrcLen], nil
+		}
+	}
+	return data, nil
+}
+
+// MacSegment is a CODE resource laid out in a MacApplication's image.
+type MacSegment struct {
+	ID      int16
+	Name    string
+	Address uint32 // Where the segment's code starts in the image
+	Size    uint32
+}
+
+// MacJumpEntry is an entry in an application's jump table: a routine that
+// other segments call through A5.
+type MacJumpEntry struct {
+	Segment int16
+	Offset  uint32 // From the start of the segment's code
+	// Address is where the routine lies in the image.
+	Address uint32
+	// A5Offset is what calls to the routine add to A5, as in jsr A5Offset(a5).
+	A5Offset int32
+}
+
+// MacApplication is the code of a classic Mac application, with its CODE
+// segments laid out one after another in a single image to disassemble.
+type MacApplication struct {
+	Image     []byte
+	Segments  []MacSegment
+	JumpTable []MacJumpEntry
+	// AboveA5 and BelowA5 are the sizes of the application's A5 world: the
+	// parameters and jump table above A5 and the globals below it.
+	AboveA5, BelowA5 uint32
+	// JumpTableOffset is where the jump table starts, relative to A5.
+	JumpTableOffset uint32
+}
+
+// Near and far model jump table entries and segment headers.
+const (
+	macLoadSeg      = 0xA9F0
+	macPushSegment  = 0x3F3C // move.w #segment,-(sp)
+	macNearHeader   = 4
+	macFarHeader    = 0x28
+	macFarSignature = 0xFFFF
+)
+
+// LoadMacCode lays out the CODE resources among res and reads the jump table
+// in CODE 0. Segments are placed in ID order from address 0, and the jump
+// table's entries are resolved to addresses in the image. Both the near
+// model, with 16-bit offsets, and the 32-bit far model are understood.
+func LoadMacCode(res []Resource) (*MacApplication, error) {
+	var jt []byte
+	var segs []Resource
+	for _, r := range res {
+		if r.Type != "CODE" {
+			continue
+		}
+		if r.ID == 0 {
+			jt = r.Data
+		} else {
+			segs = append(segs, r)
+		}
+	}
+	if jt == nil {
+		return nil, errors.New("no CODE 0 resource holds a jump table")
+	}
+	if len(jt) < 16 {
+		return nil, errors.New("CODE 0 is too short")
+	}
+	slices.SortFunc(segs, func(a, b Resource) int { return int(a.ID) - int(b.ID) })
+
+	app := &MacApplication{
+		AboveA5:         binary.BigEndian.Uint32(jt[0:]),
+		BelowA5:         binary.BigEndian.Uint32(jt[4:]),
+		JumpTableOffset: binary.BigEndian.Uint32(jt[12:]),
+	}
+	starts := make(map[int16]uint32)
+	for _, r := range segs {
+		header := macNearHeader
+		if len(r.Data) >= 2 && binary.BigEndian.Uint16(r.Data) == macFarSignature {
+			header = macFarHeader
+		}
+		if len(r.Data) < header {
+			return nil, fmt.Errorf("CODE %d is too short for its header", r.ID)
+		}
+		code := r.Data[header:]
+		addr := uint32(len(app.Image))
+		app.Segments = append(app.Segments, MacSegment{ID: r.ID, Name: r.Name, Address: addr, Size: uint32(len(code))})
+		starts[r.ID] = addr
+		app.Image = append(app.Image, code...)
+		if len(app.Image)%2 != 0 {
+			app.Image = append(app.Image, 0)
+		}
+	}
+
+	size := binary.BigEndian.Uint32(jt[8:])
+	entries := jt[16:min(uint64(16)+uint64(size), uint64(len(jt)))]
+	far := false
+	for i := 0; i+8 <= len(entries); i += 8 {
+		e := entries[i : i+8]
+		w := func(n int) uint16 { return binary.BigEndian.Uint16(e[n:]) }
+		var seg int16
+		var off uint32
+		switch {
+		case !far && w(0) == 0 && w(2) == macFarSignature && w(4) == 0 && w(6) == 0:
+			far = true // The far model's entries follow this marker.
+			continue
+		case far && w(2) == macLoadSeg:
+			seg, off = int16(w(0)), binary.BigEndian.Uint32(e[4:])
+		case !far && w(2) == macPushSegment && w(6) == macLoadSeg:
+			seg, off = int16(w(4)), uint32(w(0))
+		default:
+			continue // An entry already loaded, or padding
+		}
+		start, ok := starts[seg]
+		if !ok {
+			continue
+		}
+		app.JumpTable = append(app.JumpTable, MacJumpEntry{
+			Segment:  seg,
+			Offset:   off,
+			Address:  start + off,
+			A5Offset: int32(app.JumpTableOffset) + int32(i) + 2,
+		})
+	}
+	return app, nil
+}
+
+// segmentName returns a label-safe name for segment id, from its resource
+// name where it has one.
+func (app *MacApplication) segmentName(id int16) string {
+	for _, s := range app.Segments {
+		if s.ID != id {
+			continue
+		}
+		name := strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+				return r
+			}
+			return '_'
+		}, s.Name)
+		if strings.Trim(name, "_") != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("seg%d", id)
+}
+
+// Symbols names each jump table routine after its segment and offset, such
+// as Main_0000 or seg3_01a4.
+func (app *MacApplication) Symbols() Symbols {
+	syms := make(Symbols, len(app.JumpTable))
+	for _, e := range app.JumpTable {
+		if _, ok := syms[e.Address]; !ok {
+			syms[e.Address] = fmt.Sprintf("%s_%04x", app.segmentName(e.Segment), e.Offset)
+		}
+	}
+	return syms
+}
+
+// Disassemble lists the application's code, following flow from every jump
+// table entry. Unless opts says otherwise, routines are named by Symbols,
+// calls through the jump table are commented with the routine they reach,
+// and the mac profile names the A-line traps.
+func (app *MacApplication) Disassemble(opts Options) (string, error) {
+	if len(app.Image) == 0 {
+		return "", nil
+	}
+	a := NewAnalysis(app.Image)
+	for _, e := range app.JumpTable {
+		if e.Address < uint32(len(app.Image)) {
+			a.AddEntry(e.Address)
+		}
+	}
+	syms := app.Symbols()
+	for addr, name := range opts.Symbols {
+		syms[addr] = name
+	}
+	opts.Symbols = syms
+	if opts.A5Symbols == nil {
+		opts.A5Symbols = make(map[int32]string, len(app.JumpTable))
+		for _, e := range app.JumpTable {
+			opts.A5Symbols[e.A5Offset] = syms[e.Address]
+		}
+	}
+	if opts.Profile == "" {
+		opts.Profile = "mac"
+	}
+	return a.Disassemble(opts)
+}
+
+// a5Note names the A5-relative operands of an instruction from names.
+func a5Note(operands string, names map[int32]string) string {
+	if len(names) == 0 || !strings.Contains(operands, ",a5)") {
+		return ""
+	}
+	var notes []string
+	for _, op := range splitOperands(operands) {
+		m := reTrackDisp.FindStringSubmatch(op)
+		if m == nil || m[2] != "5" {
+			continue
+		}
+		disp, err := parseSigned(m[1])
+		if err != nil {
+			continue
+		}
+		if name, ok := names[int32(disp)]; ok {
+			notes = append(notes, name)
+		}
+	}
+	return strings.Join(notes, ", ")
+}
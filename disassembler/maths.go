@@ -14,9 +14,14 @@ func decodeAdd(op uint16, pc int, code []byte) (string, string, int) {
 	ea := op & 0x3F
 	eaText, used := DecodeEA(ea, pc, code, size)
 
-	// ADDA detection: bits 8..6 == 0b111 (0x01C0)
-	if (op & 0x01C0) == 0x01C0 {
+	// ADDA detection: bits 7..6 == 0b11, with bit 8 giving the size.
+	if (op & 0x00C0) == 0x00C0 {
 		// ADDA always: EA -> An
+		size, sizeStr = 1, ".w"
+		if dir {
+			size, sizeStr = 2, ".l"
+		}
+		eaText, used = DecodeEA(ea, pc, code, size)
 		return "adda" + sizeStr, fmt.Sprintf("%s,a%d", eaText, reg), used
 	}
 
@@ -37,9 +42,14 @@ func decodeSub(op uint16, pc int, code []byte) (string, string, int) {
 	ea := op & 0x3F
 	eaText, used := DecodeEA(ea, pc, code, size)
 
-	// SUBA detection: bits 8..6 == 0b111 (0x01C0)
-	if (op & 0x01C0) == 0x01C0 {
+	// SUBA detection: bits 7..6 == 0b11, with bit 8 giving the size.
+	if (op & 0x00C0) == 0x00C0 {
 		// SUBA always: EA -> An
+		size, sizeStr = 1, ".w"
+		if dir {
+			size, sizeStr = 2, ".l"
+		}
+		eaText, used = DecodeEA(ea, pc, code, size)
 		return "suba" + sizeStr, fmt.Sprintf("%s,a%d", eaText, reg), used
 	}
 
@@ -68,32 +78,38 @@ func decodeAddxSubx(op uint16, pc int, code []byte) (string, string, int) {
 
 	src := op & 7
 	dst := (op >> 9) & 7
-	mode := (op >> 3) & 7
-
-	switch mode {
-	case 0: // register form
-		return mn, fmt.Sprintf("d%d,d%d", src, dst), 0
-	case 4: // predecrement form
+	// Bit 3 selects the predecrement form over the register form.
+	if op&0x0008 != 0 {
 		return mn, fmt.Sprintf("-(a%d),-(a%d)", src, dst), 0
 	}
+	return mn, fmt.Sprintf("d%d,d%d", src, dst), 0
+}
 
-	ea := uint16((mode << 3) | src)
-	eaText, used := DecodeEA(ea, pc, code, sizeBits)
-	return mn, fmt.Sprintf("%s,d%d", eaText, dst), used
+// decodeBcd decodes ABCD and SBCD, which have the same two forms as ADDX.
+func decodeBcd(op uint16) (string, string, int) {
+	mn := "abcd"
+	if op&0xF000 == 0x8000 {
+		mn = "sbcd"
+	}
+	src := op & 7
+	dst := (op >> 9) & 7
+	if op&0x0008 != 0 {
+		return mn, fmt.Sprintf("-(a%d),-(a%d)", src, dst), 0
+	}
+	return mn, fmt.Sprintf("d%d,d%d", src, dst), 0
 }
 
 // decodeMulDiv decodes MULS, MULU, DIVS, DIVU.
 func decodeMulDiv(op uint16, pc int, code []byte) (string, string, int) {
 	var mn string
-	opType := (op >> 11) & 0x1F
-	switch opType {
-	case 0x18: // 11000 = MULU
+	switch op & 0xF1C0 {
+	case cpu.OPMULU:
 		mn = "mulu.w"
-	case 0x19: // 11001 = MULS
+	case cpu.OPMULS:
 		mn = "muls.w"
-	case 0x10: // 10000 = DIVU
+	case cpu.OPDIVU:
 		mn = "divu.w"
-	case 0x11: // 10001 = DIVS
+	case cpu.OPDIVS:
 		mn = "divs.w"
 	default:
 		return "dc.w", fmt.Sprintf("0x%04x", op), 0
@@ -0,0 +1,72 @@
+package disassembler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// latest has the instructions of every other model, for analyses that
+// aren't given one.
+const latest = cpu.MC68060
+
+// laterIndexed matches the operands of the indexed addressing modes the
+// 68000 lacks, as decodeIndexed writes them: a scaled index, memory
+// indirection, or the full format's sized or suppressed parts.
+var laterIndexed = regexp.MustCompile(`\*[248]|\[|\((?:\$[0-9a-f]+\.[wl],z?(?:a[0-7]|pc)|z(?:a[0-7]|pc)|(?:a[0-7]|pc),[ad][0-7]\.[wl])`)
+
+// minModel returns the first model that has the instruction op decodes to,
+// given the mnemonic and operands decode returned for it.
+func minModel(op uint16, pc int, code []byte, mn, ops string) cpu.Model {
+	switch {
+	case isUnknown(&Instruction{Mnemonic: mn}):
+		return cpu.MC68000
+	case mn == "move16":
+		return cpu.MC68040
+	case op>>12 == 0xF:
+		// The 68881 and 68882 work with the 68020 and 68030.
+		return cpu.MC68020
+	case op&0xF0FF == 0x60FF:
+		// Bcc.L, BRA.L and BSR.L.
+		return cpu.MC68020
+	case laterIndexed.MatchString(ops):
+		return cpu.MC68020
+	case mn == "movec" || strings.HasPrefix(mn, "moves") || mn == "rtd":
+		return cpu.MC68010
+	case mn == "move" && strings.HasPrefix(ops, "ccr,"):
+		return cpu.MC68010
+	}
+	if _, _, _, ok := decode68020(op, pc, code); ok {
+		return cpu.MC68020
+	}
+	return cpu.MC68000
+}
+
+// decodeFor is decode for a model, leaving instructions it doesn't have as
+// data. A zero model is the 68000.
+func decodeFor(model cpu.Model, op uint16, pc int, code []byte) (string, string, int) {
+	mn, ops, used := decode(op, pc, code)
+	if minModel(op, pc, code, mn, ops) > max(model, cpu.MC68000) {
+		return "dc.w", fmt.Sprintf("0x%04x", op), 0
+	}
+	return mn, ops, used
+}
+
+// forModel returns inst, decoded from code, as model sees it: unchanged, or
+// as a data word if only a later model has it.
+func forModel(code []byte, inst *Instruction, model cpu.Model) *Instruction {
+	ext := code[min(int(inst.Address)+2, len(code)):]
+	if minModel(inst.Op, 0, ext, inst.Mnemonic, inst.Operands) <= max(model, cpu.MC68000) {
+		return inst
+	}
+	return &Instruction{
+		Address:  inst.Address,
+		Op:       inst.Op,
+		Mnemonic: "dc.w",
+		Operands: fmt.Sprintf("0x%04x", inst.Op),
+		Size:     2,
+		IsCode:   true,
+	}
+}
@@ -3,6 +3,7 @@ package disassembler
 import (
 	"encoding/binary"
 	"fmt"
+	"math/bits"
 
 	"github.com/Urethramancer/m68k/cpu"
 )
@@ -22,10 +23,12 @@ func decodeMoveGeneral(op uint16, pc int, code []byte) (string, string, int) {
 		return "dc.w", fmt.Sprintf("0x%04x", op), 0
 	}
 
+	// MOVE encodes sizes as 1=byte, 3=word, 2=long; DecodeEA wants 0, 1, 2.
+	eaSize := [4]uint16{0, 0, 2, 1}[sizeBits]
 	srcEA := uint16(((op>>3)&0x7)<<3) | uint16(op&0x7)
 	dstEA := uint16(((op>>6)&0x7)<<3) | uint16((op>>9)&0x7)
-	srcText, cons1 := DecodeEA(srcEA, pc, code, sizeBits)
-	dstText, cons2 := DecodeEA(dstEA, pc+cons1, code, sizeBits)
+	srcText, cons1 := DecodeEA(srcEA, pc, code, eaSize)
+	dstText, cons2 := DecodeEA(dstEA, pc+cons1, code, eaSize)
 	dstMode := (op >> 6) & 0x7
 
 	if dstMode == 1 {
@@ -53,6 +56,10 @@ func decodeMovem(op uint16, pc int, code []byte) (string, string, int) {
 	mask := binary.BigEndian.Uint16(code[pc:])
 
 	eaText, used := DecodeEA(ea, pc+2, code, 0)
+	if (ea>>3)&7 == 4 {
+		// Predecrement masks run from A7 in bit 0 to D0 in bit 15.
+		mask = bits.Reverse16(mask)
+	}
 	regList := movemMaskToList(mask)
 
 	if isLoad {
@@ -112,6 +119,8 @@ func decodeMoveSystemRegister(op uint16, pc int, code []byte) (string, string, i
 	switch op & 0xFFC0 {
 	case cpu.OPMOVEFromSR:
 		return "move", fmt.Sprintf("sr,%s", eaText), used
+	case cpu.OPMOVEFromCCR:
+		return "move", fmt.Sprintf("ccr,%s", eaText), used
 	case cpu.OPMOVEToCCR:
 		return "move", fmt.Sprintf("%s,ccr", eaText), used
 	case cpu.OPMOVEToSR:
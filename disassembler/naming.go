@@ -0,0 +1,164 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// LabelScheme chooses how the disassembler names the labels it generates.
+type LabelScheme int
+
+const (
+	// DefaultLabels names code by address, as loc_1234 and sub_1234, and
+	// numbers strings in order, as string1.
+	DefaultLabels LabelScheme = iota
+	// AddressLabels names everything by address, strings as str_1234.
+	AddressLabels
+	// SequentialLabels numbers each kind of label in address order: loc_1,
+	// sub_1 and string1. Names survive code moving, but not labels being
+	// added or removed before them.
+	SequentialLabels
+	// HashLabels names labels after what they label: the instructions at a
+	// code label and the text of a string, hashed with Options.LabelSeed.
+	// Branch targets and operands with hex numbers, mostly addresses that
+	// change as code moves, are left out, so
+	// routines keep their names between two revisions of a ROM unless they
+	// change themselves, and diffs show only what did. Labels that would
+	// share a name get _2, _3 and so on in address order.
+	HashLabels
+)
+
+// labelSchemes maps the names ParseLabelScheme accepts to schemes.
+var labelSchemes = map[string]LabelScheme{
+	"default":    DefaultLabels,
+	"address":    AddressLabels,
+	"sequential": SequentialLabels,
+	"hash":       HashLabels,
+}
+
+// LabelSchemes returns the names ParseLabelScheme accepts, sorted.
+func LabelSchemes() []string {
+	names := make([]string, 0, len(labelSchemes))
+	for name := range labelSchemes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParseLabelScheme returns the scheme called name: default, address,
+// sequential or hash.
+func ParseLabelScheme(name string) (LabelScheme, error) {
+	s, ok := labelSchemes[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown label scheme %q (want %s)", name, strings.Join(LabelSchemes(), ", "))
+	}
+	return s, nil
+}
+
+// hashedInstructions is how many instructions at a code label HashLabels
+// hashes.
+const hashedInstructions = 4
+
+// namer generates the labels for one disassembly.
+type namer struct {
+	scheme  LabelScheme
+	seed    uint64
+	code    map[uint32]string // Code labels, named up front
+	used    map[string]bool   // Names given, for HashLabels
+	strings int               // Strings named so far
+}
+
+// newNamer names the code labels in targets, whose instructions are in
+// instructions.
+func newNamer(instructions map[uint32]*Instruction, targets map[uint32]LabelType, opts Options) *namer {
+	n := &namer{scheme: opts.Labels, seed: opts.LabelSeed, code: make(map[uint32]string, len(targets))}
+	if n.scheme == HashLabels {
+		n.used = make(map[string]bool)
+	}
+	count := make(map[LabelType]int)
+	for _, addr := range slices.Sorted(maps.Keys(targets)) {
+		kind := targets[addr]
+		prefix := "loc_"
+		if kind == SubroutineEntry {
+			prefix = "sub_"
+		}
+		switch n.scheme {
+		case SequentialLabels:
+			count[kind]++
+			n.code[addr] = fmt.Sprintf("%s%d", prefix, count[kind])
+		case HashLabels:
+			n.code[addr] = n.unique(prefix, n.hashCode(instructions, addr, kind))
+		default:
+			n.code[addr] = labelName(addr, kind)
+		}
+	}
+	return n
+}
+
+// label returns the generated label at addr.
+func (n *namer) label(addr uint32) string {
+	return n.code[addr]
+}
+
+// stringLabel names the string text at addr.
+func (n *namer) stringLabel(addr uint32, text []byte) string {
+	n.strings++
+	switch n.scheme {
+	case AddressLabels:
+		return fmt.Sprintf("str_%04X", addr)
+	case HashLabels:
+		h := n.hasher()
+		h.Write(text)
+		return n.unique("str_", h.Sum64())
+	default:
+		return fmt.Sprintf("string%d", n.strings)
+	}
+}
+
+// hasher returns a hash seeded with the label seed.
+func (n *namer) hasher() hash.Hash64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, n.seed)
+	return h
+}
+
+// hashCode hashes the instructions starting at addr, leaving out branch
+// targets and operands with hex numbers, which are mostly addresses.
+func (n *namer) hashCode(instructions map[uint32]*Instruction, addr uint32, kind LabelType) uint64 {
+	h := n.hasher()
+	h.Write([]byte{byte(kind)})
+	for range hashedInstructions {
+		inst, ok := instructions[addr]
+		if !ok || !inst.IsCode {
+			break
+		}
+		h.Write([]byte(inst.Mnemonic))
+		h.Write([]byte{0})
+		if !isBranchMnemonic(inst.Mnemonic) && !strings.Contains(inst.Operands, "$") {
+			h.Write([]byte(inst.Operands))
+		}
+		h.Write([]byte{0})
+		if isTerminal(inst.Mnemonic) {
+			break
+		}
+		addr += inst.Size
+	}
+	return h.Sum64()
+}
+
+// unique turns a hash into a name no earlier label has.
+func (n *namer) unique(prefix string, sum uint64) string {
+	base := fmt.Sprintf("%s%08x", prefix, uint32(sum^sum>>32))
+	name := base
+	for i := 2; n.used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	n.used[name] = true
+	return name
+}
@@ -11,6 +11,10 @@ func parseAbsoluteAddress(op string) int {
 	if op == "" {
 		return -1
 	}
+	// Register-relative operands such as ($2a,a5) or (a0) are not absolute.
+	if strings.Contains(op, ",a") || strings.Contains(op, ",pc") || strings.HasPrefix(op, "(a") || strings.HasPrefix(op, "(pc") {
+		return -1
+	}
 	// look for "$", then read contiguous hex digits
 	i := strings.Index(op, "$")
 	if i >= 0 {
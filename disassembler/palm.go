@@ -0,0 +1,164 @@
+package disassembler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PalmDatabase is a Palm OS resource database, the format of PRC files.
+type PalmDatabase struct {
+	Name       string
+	Attributes uint16
+	Version    uint16
+	Type       string // Four-character type, such as "appl"
+	Creator    string // Four-character creator ID
+	Resources  []Resource
+}
+
+// Layout of a Palm database header and its resource list.
+const (
+	palmHeaderSize   = 78
+	palmEntrySize    = 10 // Type, ID and offset of a resource
+	palmResourceDB   = 0x0001
+	palmNameSize     = 32
+	palmTypeOffset   = 60
+	palmRecordsCount = 76
+)
+
+// ParsePRC reads a Palm OS resource database. Each resource's data runs from
+// its offset to the next resource's, or to the end of the file. Resource
+// IDs are unsigned on the Palm, but are kept in Resource's int16.
+func ParsePRC(data []byte) (*PalmDatabase, error) {
+	if len(data) < palmHeaderSize {
+		return nil, errors.New("PRC file is too short for its header")
+	}
+	db := &PalmDatabase{
+		Name:       string(data[:palmNameSize]),
+		Attributes: binary.BigEndian.Uint16(data[32:]),
+		Version:    binary.BigEndian.Uint16(data[34:]),
+		Type:       string(data[palmTypeOffset : palmTypeOffset+4]),
+		Creator:    string(data[palmTypeOffset+4 : palmTypeOffset+8]),
+	}
+	if i := strings.IndexByte(db.Name, 0); i >= 0 {
+		db.Name = db.Name[:i]
+	}
+	if db.Attributes&palmResourceDB == 0 {
+		return nil, fmt.Errorf("%q is a record database, not a resource database", db.Name)
+	}
+	n := int(binary.BigEndian.Uint16(data[palmRecordsCount:]))
+	if palmHeaderSize+n*palmEntrySize > len(data) {
+		return nil, errors.New("PRC resource list is truncated")
+	}
+	offsets := make([]uint32, n)
+	for i := range n {
+		e := data[palmHeaderSize+i*palmEntrySize:]
+		offsets[i] = binary.BigEndian.Uint32(e[6:])
+		if offsets[i] > uint32(len(data)) {
+			return nil, fmt.Errorf("resource %d lies outside the file", i)
+		}
+	}
+	sorted := slices.Clone(offsets)
+	slices.Sort(sorted)
+	for i := range n {
+		e := data[palmHeaderSize+i*palmEntrySize:]
+		start := offsets[i]
+		end := uint32(len(data))
+		if j, _ := slices.BinarySearch(sorted, start+1); j < len(sorted) {
+			end = sorted[j]
+		}
+		db.Resources = append(db.Resources, Resource{
+			Type: string(e[:4]),
+			ID:   int16(binary.BigEndian.Uint16(e[4:])),
+			Data: data[start:end],
+		})
+	}
+	return db, nil
+}
+
+// PalmApplication is the code of a Palm OS application, with its code
+// resources laid out one after another in a single image to disassemble.
+type PalmApplication struct {
+	Image []byte
+	// Segments are the code resources other than code 0, in ID order.
+	Segments []MacSegment
+	// AboveA5 and BelowA5 are the sizes of the application's A5 world, from
+	// code 0, or zero without one.
+	AboveA5, BelowA5 uint32
+	// Entry is where the system starts the application: the first byte of
+	// code 1.
+	Entry uint32
+}
+
+// LoadPalmCode lays out the code resources of db in ID order from address 0.
+// Unlike a Mac CODE resource, a Palm code resource has no header; code 1
+// holds the startup code, which is entered at its first byte, and code 0
+// holds the sizes of the A5 world. Initialised globals are left in the data
+// resources for the startup code to unpack.
+func LoadPalmCode(db *PalmDatabase) (*PalmApplication, error) {
+	app := &PalmApplication{}
+	var segs []Resource
+	for _, r := range db.Resources {
+		if r.Type != "code" {
+			continue
+		}
+		if r.ID == 0 {
+			if len(r.Data) >= 8 {
+				app.AboveA5 = binary.BigEndian.Uint32(r.Data[0:])
+				app.BelowA5 = binary.BigEndian.Uint32(r.Data[4:])
+			}
+			continue
+		}
+		segs = append(segs, r)
+	}
+	slices.SortFunc(segs, func(a, b Resource) int { return int(uint16(a.ID)) - int(uint16(b.ID)) })
+	if len(segs) == 0 || segs[0].ID != 1 {
+		return nil, errors.New("no code 1 resource holds the startup code")
+	}
+	for _, r := range segs {
+		addr := uint32(len(app.Image))
+		app.Segments = append(app.Segments, MacSegment{ID: r.ID, Address: addr, Size: uint32(len(r.Data))})
+		app.Image = append(app.Image, r.Data...)
+		if len(app.Image)%2 != 0 {
+			app.Image = append(app.Image, 0)
+		}
+	}
+	return app, nil
+}
+
+// Symbols names the entry point "start" and the start of every other code
+// resource after its ID, such as code2.
+func (app *PalmApplication) Symbols() Symbols {
+	syms := make(Symbols, len(app.Segments))
+	for _, s := range app.Segments[1:] {
+		syms[s.Address] = fmt.Sprintf("code%d", uint16(s.ID))
+	}
+	syms[app.Entry] = "start"
+	return syms
+}
+
+// Disassemble lists the application's code, following flow from the start
+// of every code resource. Unless opts says otherwise, they are named by
+// Symbols and the palm profile names the system traps.
+func (app *PalmApplication) Disassemble(opts Options) (string, error) {
+	if len(app.Image) == 0 {
+		return "", nil
+	}
+	a := NewAnalysis(app.Image)
+	for _, s := range app.Segments {
+		if s.Size > 0 {
+			a.AddEntry(s.Address)
+		}
+	}
+	syms := app.Symbols()
+	for addr, name := range opts.Symbols {
+		syms[addr] = name
+	}
+	opts.Symbols = syms
+	if opts.Profile == "" {
+		opts.Profile = "palm"
+	}
+	return a.Disassemble(opts)
+}
@@ -0,0 +1,62 @@
+package disassembler
+
+import "fmt"
+
+func init() {
+	RegisterProfile("palm", func() Profile { return &palmProfile{} })
+}
+
+// Palm OS system calls are a TRAP #15 followed by a trap word.
+const (
+	palmTrap     = 15
+	palmTrapBase = 0xA000
+)
+
+// palmTraps names the common Palm OS system traps, numbered as in the SDK's
+// SysTraps.h.
+var palmTraps = map[uint16]string{
+	0xA012: "MemChunkFree", 0xA013: "MemPtrNew", 0xA01E: "MemHandleNew",
+	0xA021: "MemHandleLock", 0xA022: "MemHandleUnlock", 0xA026: "MemMove",
+	0xA027: "MemSet", 0xA05F: "DmGetResource", 0xA061: "DmReleaseResource",
+	0xA08F: "SysAppStartup", 0xA090: "SysAppExit", 0xA0A0: "SysTaskDelay",
+	0xA0A9: "SysHandleEvent", 0xA0F7: "TimGetTicks", 0xA11D: "EvtGetEvent",
+	0xA16F: "FrmInitForm", 0xA171: "FrmDrawForm", 0xA174: "FrmSetActiveForm",
+	0xA192: "FrmAlert", 0xA19B: "FrmGotoForm", 0xA1A0: "FrmDispatchEvent",
+	0xA1A1: "FrmCloseAllForms", 0xA1BF: "MenuHandleEvent", 0xA220: "WinDrawChars",
+}
+
+// PalmTrapName returns the name of the Palm OS system trap word op, such as
+// "MemPtrNew", and whether it is known.
+func PalmTrapName(op uint16) (string, bool) {
+	name, ok := palmTraps[op]
+	return name, ok
+}
+
+// palmProfile names the system traps of Palm OS.
+type palmProfile struct {
+	trap bool // The last instruction was TRAP #15
+}
+
+// Reset forgets the last instruction.
+func (p *palmProfile) Reset() {
+	p.trap = false
+}
+
+// Instruction names the trap word following a TRAP #15.
+func (p *palmProfile) Instruction(inst *Instruction) string {
+	after := p.trap
+	n, ok := trapNumber(inst)
+	p.trap = ok && n == palmTrap
+	if !after || inst.Op&0xF000 != palmTrapBase {
+		return ""
+	}
+	if name, ok := PalmTrapName(inst.Op); ok {
+		return name
+	}
+	return fmt.Sprintf("system trap $%04x", inst.Op)
+}
+
+// Data leaves data to the default rules.
+func (p *palmProfile) Data(data []byte, addr uint32) (string, int) {
+	return "", 0
+}
@@ -0,0 +1,241 @@
+package disassembler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile adds machine-specific comments to the disassembly, such as names for
+// hardware registers. A new instance is created for every disassembly, so
+// profiles may keep state between calls.
+type Profile interface {
+	// Reset is called at the start and at every label, where any tracked
+	// register contents can no longer be trusted.
+	Reset()
+	// Instruction returns a comment for a reachable instruction, or "".
+	Instruction(inst *Instruction) string
+	// Data may claim data starting at addr. It returns the rendered lines and
+	// the number of bytes consumed, or 0 to leave the data to the default rules.
+	Data(data []byte, addr uint32) (string, int)
+}
+
+var profiles = map[string]func() Profile{}
+
+// RegisterProfile makes a profile available by name to NewProfile and Options.Profile.
+func RegisterProfile(name string, create func() Profile) {
+	profiles[strings.ToLower(name)] = create
+}
+
+// NewProfile creates a fresh instance of the named profile.
+func NewProfile(name string) (Profile, error) {
+	create, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s (available: %s)", name, strings.Join(Profiles(), ", "))
+	}
+	return create(), nil
+}
+
+// Profiles returns the names of the registered profiles, sorted.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderData formats a data block, letting the profile claim the parts it recognizes
+// and passing the rest to analyzeAndFormatData.
+func renderData(data []byte, addr uint32, names *namer, prof Profile) string {
+	if prof == nil {
+		return analyzeAndFormatData(data, addr, names)
+	}
+
+	var sb strings.Builder
+	start := 0
+	for i := 0; i < len(data); {
+		if (addr+uint32(i))%2 != 0 {
+			i++
+			continue
+		}
+		text, used := prof.Data(data[i:], addr+uint32(i))
+		if used <= 0 {
+			i += 2
+			continue
+		}
+		sb.WriteString(analyzeAndFormatData(data[start:i], addr+uint32(start), names))
+		sb.WriteString(text)
+		i += used
+		start = i
+	}
+	sb.WriteString(analyzeAndFormatData(data[start:], addr+uint32(start), names))
+	return sb.String()
+}
+
+// splitOperands splits a rendered operand string at top-level commas.
+func splitOperands(ops string) []string {
+	var parts []string
+	depth, last := 0, 0
+	for i, c := range ops {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, ops[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, ops[last:])
+}
+
+// parseImmediate returns the value of a rendered immediate such as #$8010 or #-5.
+func parseImmediate(s string) (uint32, bool) {
+	if !strings.HasPrefix(s, "#") {
+		return 0, false
+	}
+	s = s[1:]
+	if strings.HasPrefix(s, "$") {
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		return uint32(v), err == nil
+	}
+	v, err := strconv.ParseInt(s, 10, 32)
+	return uint32(v), err == nil
+}
+
+// decodeBits names the set bits of v, highest first, using names[bit].
+func decodeBits(v uint16, names map[int]string) string {
+	var parts []string
+	for bit := 15; bit >= 0; bit-- {
+		if v&(1<<bit) == 0 {
+			continue
+		}
+		if name, ok := names[bit]; ok {
+			parts = append(parts, name)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// parseSigned parses a rendered displacement such as $96, -4 or 8.
+// Hex displacements are rendered as 16-bit two's complement.
+func parseSigned(s string) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var v int64
+	var err error
+	if strings.HasPrefix(s, "$") {
+		var u uint64
+		u, err = strconv.ParseUint(s[1:], 16, 16)
+		v = int64(int16(u))
+	} else {
+		v, err = strconv.ParseInt(s, 10, 32)
+	}
+	if neg {
+		v = -v
+	}
+	return v, err
+}
+
+var (
+	reTrackAbs  = regexp.MustCompile(`^\$([0-9a-f]+)\.l$`)
+	reTrackDisp = regexp.MustCompile(`^\((-?\$?[0-9a-f]+),a([0-7])\)$`)
+	reTrackInd  = regexp.MustCompile(`^\(a([0-7])\)$`)
+	reTrackStep = regexp.MustCompile(`a([0-7])\)?\+?$`)
+)
+
+// baseTracker follows constant addresses loaded into address registers with
+// LEA or MOVE(A).L, so that (An) and (d16,An) operands can be resolved.
+type baseTracker struct {
+	base [8]uint32
+	set  [8]bool
+	// keep reports whether an address is worth tracking.
+	keep func(addr uint32) bool
+}
+
+// reset forgets every tracked register.
+func (t *baseTracker) reset() {
+	t.set = [8]bool{}
+}
+
+// resolve returns the 24-bit address an absolute long or tracked register
+// operand refers to.
+func (t *baseTracker) resolve(op string) (uint32, bool) {
+	var addr uint32
+	if m := reTrackAbs.FindStringSubmatch(op); m != nil {
+		v, _ := strconv.ParseUint(m[1], 16, 32)
+		addr = uint32(v)
+	} else if m := reTrackDisp.FindStringSubmatch(op); m != nil {
+		r := m[2][0] - '0'
+		if !t.set[r] {
+			return 0, false
+		}
+		disp, err := parseSigned(m[1])
+		if err != nil {
+			return 0, false
+		}
+		addr = uint32(int64(t.base[r]) + disp)
+	} else if m := reTrackInd.FindStringSubmatch(op); m != nil {
+		r := m[1][0] - '0'
+		if !t.set[r] {
+			return 0, false
+		}
+		addr = t.base[r]
+	} else {
+		return 0, false
+	}
+	return addr & 0xFFFFFF, true
+}
+
+// track updates the tracked registers after an instruction.
+func (t *baseTracker) track(inst *Instruction, ops []string) {
+	if len(ops) != 2 {
+		return
+	}
+
+	// Anything that writes or steps an address register invalidates it.
+	for _, op := range ops {
+		if strings.HasSuffix(op, ")+") || strings.HasPrefix(op, "-(") {
+			if m := reTrackStep.FindStringSubmatch(op); m != nil {
+				t.set[m[1][0]-'0'] = false
+			}
+		}
+	}
+	dst := ops[1]
+	if len(dst) != 2 || dst[0] != 'a' {
+		return
+	}
+	r := dst[1] - '0'
+	t.set[r] = false
+
+	var addr uint32
+	switch inst.Mnemonic {
+	case "lea":
+		m := reTrackAbs.FindStringSubmatch(ops[0])
+		if m == nil {
+			return
+		}
+		v, _ := strconv.ParseUint(m[1], 16, 32)
+		addr = uint32(v)
+	case "movea.l", "move.l":
+		v, ok := parseImmediate(ops[0])
+		if !ok {
+			return
+		}
+		addr = v
+	default:
+		return
+	}
+	addr &= 0xFFFFFF
+	if t.keep == nil || t.keep(addr) {
+		t.base[r] = addr
+		t.set[r] = true
+	}
+}
@@ -0,0 +1,174 @@
+package disassembler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// RegionKind is the rough classification of a block of a binary.
+type RegionKind int
+
+const (
+	// RegionCode is mostly reachable code.
+	RegionCode RegionKind = iota
+	// RegionText is mostly printable ASCII.
+	RegionText
+	// RegionFill is a single repeated byte, usually padding.
+	RegionFill
+	// RegionGraphics has the low-to-medium entropy typical of bitmaps and tiles.
+	RegionGraphics
+	// RegionCompressed has near-random entropy: packed, encrypted or sampled data.
+	RegionCompressed
+	// RegionData is anything else.
+	RegionData
+)
+
+// DefaultBlockSize is the block size used when classifying regions.
+const DefaultBlockSize = 256
+
+// String returns the lowercase name of the kind.
+func (k RegionKind) String() string {
+	switch k {
+	case RegionCode:
+		return "code"
+	case RegionText:
+		return "text"
+	case RegionFill:
+		return "fill"
+	case RegionGraphics:
+		return "graphics"
+	case RegionCompressed:
+		return "compressed"
+	default:
+		return "data"
+	}
+}
+
+// Region is a run of adjacent blocks with the same classification.
+type Region struct {
+	Start uint32
+	End   uint32 // Exclusive
+	Kind  RegionKind
+	// Entropy is the average Shannon entropy of the blocks, in bits per byte.
+	Entropy float64
+}
+
+// ClassifyRegions splits code into blocks of blockSize bytes, measures each block's
+// entropy and classifies it. Adjacent blocks of the same kind are merged.
+// The classification is a heuristic meant to show where to look first.
+func ClassifyRegions(code []byte, blockSize int) []Region {
+	if len(code) == 0 {
+		return nil
+	}
+	instructions, _ := analyze(code, latest)
+	return classifyRegions(code, blockSize, instructions)
+}
+
+// classifyRegions does the work of ClassifyRegions for an already analysed binary.
+func classifyRegions(code []byte, blockSize int, instructions map[uint32]*Instruction) []Region {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	isCode := codeMask(code, instructions)
+
+	var regions []Region
+	for start := 0; start < len(code); start += blockSize {
+		end := min(start+blockSize, len(code))
+		block := code[start:end]
+
+		var codeBytes int
+		for _, c := range isCode[start:end] {
+			if c {
+				codeBytes++
+			}
+		}
+
+		e := Entropy(block)
+		kind := classifyBlock(block, e, float64(codeBytes)/float64(len(block)))
+
+		if n := len(regions); n > 0 && regions[n-1].Kind == kind {
+			r := &regions[n-1]
+			size := float64(r.End - r.Start)
+			r.Entropy = (r.Entropy*size + e*float64(len(block))) / (size + float64(len(block)))
+			r.End = uint32(end)
+			continue
+		}
+		regions = append(regions, Region{Start: uint32(start), End: uint32(end), Kind: kind, Entropy: e})
+	}
+	return regions
+}
+
+// classifyBlock picks a kind from the block's entropy, share of code bytes and
+// share of printable bytes.
+func classifyBlock(block []byte, entropy, codeRatio float64) RegionKind {
+	if codeRatio >= 0.5 {
+		return RegionCode
+	}
+	if entropy == 0 {
+		return RegionFill
+	}
+
+	var printable int
+	for _, b := range block {
+		if isPrintableASCII(b) || b == 0 || b == '\n' || b == '\r' || b == '\t' {
+			printable++
+		}
+	}
+	switch {
+	case float64(printable)/float64(len(block)) >= 0.85:
+		return RegionText
+	case entropy >= 7.2:
+		return RegionCompressed
+	case entropy >= 1 && entropy < 5:
+		return RegionGraphics
+	default:
+		return RegionData
+	}
+}
+
+// Entropy returns the Shannon entropy of data in bits per byte (0 to 8).
+func Entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var e float64
+	n := float64(len(data))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// FormatRegions renders a region list followed by the total bytes of each kind.
+func FormatRegions(regions []Region) string {
+	var sb strings.Builder
+	totals := make(map[RegionKind]uint32)
+	for _, r := range regions {
+		fmt.Fprintf(&sb, "%08X-%08X  %-10s  %4.2f bits/byte\n", r.Start, r.End-1, r.Kind, r.Entropy)
+		totals[r.Kind] += r.End - r.Start
+	}
+
+	sb.WriteString("\n")
+	for k := RegionCode; k <= RegionData; k++ {
+		if totals[k] > 0 {
+			fmt.Fprintf(&sb, "%-10s  %d bytes\n", k, totals[k])
+		}
+	}
+	return sb.String()
+}
+
+// regionComment formats the annotation printed where a region starts.
+func regionComment(r Region) string {
+	return fmt.Sprintf("; --- $%08X-$%08X: %s, %.2f bits/byte ---\n", r.Start, r.End-1, r.Kind, r.Entropy)
+}
@@ -8,12 +8,16 @@ import "fmt"
 //
 //	15–12: 1110 (0xE)
 //	11–9 : <register/count>
-//	8    : 0 = register shift, 1 = memory shift (not handled here)
-//	7    : 0 = register count, 1 = immediate count (in register form)
-//	6–7  : size bits (00=byte, 01=word, 10=long)
-//	5–3  : direction + type of shift
+//	8    : 0 = right, 1 = left
+//	7–6  : size bits (00=byte, 01=word, 10=long, 11=memory form)
+//	5    : 0 = immediate count, 1 = register count
+//	4–3  : type of shift
 //	2–0  : destination register
 //
+// The memory form shifts a word at <ea> by one bit and moves the type to bits 10–9:
+//
+//	1110 0<type> <dir> 11 <ea>
+//
 // The instruction families are divided into right and left variants:
 //
 //	Right: ASR, LSR, ROXR, ROR
@@ -21,21 +25,29 @@ import "fmt"
 //
 // Example encodings:
 //
-//	0xE008 → LSR.W D0
-//	0xE018 → ROR.W D0
-//	0xE108 → LSL.W D0
-//	0xE118 → ROL.W D0
-func decodeShiftRotateGeneric(op uint16) (string, string, int) {
+//	0xE048 → LSR.W #8,D0
+//	0xE158 → ROL.W #8,D0
+//	0xE3E8 → LSL.W (d16,A0)
+func decodeShiftRotateGeneric(op uint16, pc int, code []byte) (string, string, int) {
+	mnBases := []string{"asr", "lsr", "roxr", "ror", "asl", "lsl", "roxl", "rol"}
+
 	// Bit 8 (0x0100): 0 = right shift/rotate, 1 = left shift/rotate
 	isLeft := (op & 0x0100) != 0
 
-	// Operation index bits 5–3
-	opType := (op >> 3) & 3 // 0..3 for AS/LS/ROX/ROR base
+	if (op>>6)&3 == 3 {
+		opType := (op >> 9) & 3
+		if isLeft {
+			opType += 4
+		}
+		ops, used := DecodeEA(op&0x3F, pc, code, 1)
+		return mnBases[opType], ops, used
+	}
+
+	// Operation type bits 4–3
+	opType := (op >> 3) & 3 // 0..3 for AS/LS/ROX/RO
 	if isLeft {
 		opType += 4 // add 4 to select ASL/LSL/ROXL/ROL
 	}
-
-	mnBases := []string{"asr", "lsr", "roxr", "ror", "asl", "lsl", "roxl", "rol"}
 	mn := mnBases[opType]
 
 	// Bits 7–6 encode size: 00=b, 01=w, 10=l
@@ -48,11 +60,10 @@ func decodeShiftRotateGeneric(op uint16) (string, string, int) {
 		mn += ".l"
 	}
 
-	// Bit 5 (0x0020) distinguishes register-count (0) vs immediate-count (1) forms
-	isRegForm := (op & 0x0020) == 0
-	if isRegForm {
+	// Bit 5 (0x0020) distinguishes immediate-count (0) vs register-count (1) forms
+	dstReg := op & 7
+	if op&0x0020 != 0 {
 		cntReg := (op >> 9) & 7
-		dstReg := op & 7
 		return mn, fmt.Sprintf("d%d,d%d", cntReg, dstReg), 0
 	}
 
@@ -61,6 +72,5 @@ func decodeShiftRotateGeneric(op uint16) (string, string, int) {
 	if cnt == 0 {
 		cnt = 8
 	}
-	dstReg := op & 7
 	return mn, fmt.Sprintf("#%d,d%d", cnt, dstReg), 0
 }
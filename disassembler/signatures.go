@@ -0,0 +1,190 @@
+package disassembler
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Signature identifies a known routine by its bytes. Wildcards stand for the
+// parts that change with where the routine and the code it refers to are
+// linked: absolute long addresses, PC-relative displacements and branches out
+// of the routine.
+type Signature struct {
+	Name string
+	// Pattern holds the byte values to match, or -1 for a wildcard.
+	Pattern []int
+}
+
+// Fixed returns the number of bytes that are not wildcards.
+func (s Signature) Fixed() int {
+	n := 0
+	for _, b := range s.Pattern {
+		if b >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// String formats the signature as a database line: the name, then the bytes
+// in hex with "??" for wildcards.
+func (s Signature) String() string {
+	var sb strings.Builder
+	sb.WriteString(s.Name)
+	for _, b := range s.Pattern {
+		if b < 0 {
+			sb.WriteString(" ??")
+		} else {
+			fmt.Fprintf(&sb, " %02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// match reports whether the signature matches code at off.
+func (s Signature) match(code []byte, off int) bool {
+	if off+len(s.Pattern) > len(code) {
+		return false
+	}
+	for i, b := range s.Pattern {
+		if b >= 0 && code[off+i] != byte(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSignatures reads a signature database, one signature per line as
+// written by Signature.String. Blank lines and lines starting with "#" or ";"
+// are skipped.
+func ParseSignatures(r io.Reader) ([]Signature, error) {
+	var sigs []Signature
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0][0] == '#' || fields[0][0] == ';' {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: signature %s has no bytes", line, fields[0])
+		}
+
+		sig := Signature{Name: fields[0], Pattern: make([]int, 0, len(fields)-1)}
+		for _, f := range fields[1:] {
+			if f == "??" {
+				sig.Pattern = append(sig.Pattern, -1)
+				continue
+			}
+			v, err := strconv.ParseUint(f, 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid byte %s", line, f)
+			}
+			sig.Pattern = append(sig.Pattern, int(v))
+		}
+		if sig.Pattern[0] < 0 {
+			return nil, fmt.Errorf("line %d: signature %s starts with a wildcard", line, sig.Name)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, sc.Err()
+}
+
+// WriteSignatures writes a signature database.
+func WriteSignatures(w io.Writer, sigs []Signature) error {
+	for _, s := range sigs {
+		if _, err := fmt.Fprintln(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtinSignatures is generated from the assembler's runtime library and the
+// compiler support routines in sigsrc.
+//
+//go:generate go run ../cmd/sig68 -o signatures.sig ../assembler/lib/runtime.i sigsrc/libgcc.asm
+//go:embed signatures.sig
+var builtinSignatures string
+
+// BuiltinSignatures returns the signatures built into the disassembler.
+func BuiltinSignatures() []Signature {
+	sigs, err := ParseSignatures(strings.NewReader(builtinSignatures))
+	if err != nil {
+		panic(fmt.Sprintf("built-in signatures: %v", err))
+	}
+	return sigs
+}
+
+// reAbsoluteLong matches an absolute long operand, the only kind of address
+// the linker relocates.
+var reAbsoluteLong = regexp.MustCompile(`\$[0-9a-f]+\.l\b`)
+
+// NewSignature makes a signature for the routine in code, which is linked at
+// addr, keeping at most limit bytes (all of them if limit is 0).
+func NewSignature(name string, code []byte, addr uint32, limit int) Signature {
+	if limit > 0 && len(code) > limit {
+		code = code[:limit]
+	}
+	pattern := make([]int, len(code))
+	for i, b := range code {
+		pattern[i] = int(b)
+	}
+
+	end := int64(addr) + int64(len(code))
+	for _, inst := range DecodeRange(code, addr) {
+		off := int(inst.Address - addr)
+		last := min(off+int(inst.Size), len(code))
+		wild := off + 2
+		switch {
+		case isBranchMnemonic(inst.Mnemonic):
+			target := parseAbsoluteAddress(inst.Operands[strings.LastIndexByte(inst.Operands, ',')+1:])
+			if target >= int(addr) && int64(target) < end {
+				continue
+			}
+			if inst.Size == 2 {
+				wild = off + 1 // Short branch: the displacement is in the opcode.
+			}
+		case reAbsoluteLong.MatchString(inst.Operands), strings.Contains(inst.Operands, "pc)"):
+		default:
+			continue
+		}
+		for i := wild; i < last; i++ {
+			pattern[i] = -1
+		}
+	}
+	return Signature{Name: name, Pattern: pattern}
+}
+
+// FindSignatures scans the even offsets of code for the signatures and names
+// the routines found. Where several match, the one with the most fixed bytes
+// wins. A name found more than once gets a suffix, as in "memcpy_2".
+func FindSignatures(code []byte, sigs []Signature) Symbols {
+	sorted := slices.Clone(sigs)
+	slices.SortStableFunc(sorted, func(a, b Signature) int {
+		return b.Fixed() - a.Fixed()
+	})
+
+	found := make(Symbols)
+	seen := make(map[string]int)
+	for off := 0; off+1 < len(code); off += 2 {
+		for _, s := range sorted {
+			if byte(s.Pattern[0]) != code[off] || !s.match(code, off) {
+				continue
+			}
+			seen[s.Name]++
+			name := s.Name
+			if n := seen[s.Name]; n > 1 {
+				name = fmt.Sprintf("%s_%d", s.Name, n)
+			}
+			found[uint32(off)] = name
+			break
+		}
+	}
+	return found
+}
@@ -0,0 +1,18 @@
+; libgcc.asm - compiler support routines from GCC's libgcc for the 68000
+; (lb1sf68.S), as linked into C programs. Only the code matters; it is
+; assembled by go generate to make signatures.
+
+; __mulsi3 multiplies the longs at 4(a7) and 8(a7), returning the low 32 bits
+; of the product in D0.
+__mulsi3:
+	move.w	4(a7),d0
+	mulu.w	10(a7),d0
+	move.w	6(a7),d1
+	mulu.w	8(a7),d1
+	add.w	d1,d0
+	swap	d0
+	clr.w	d0
+	move.w	6(a7),d1
+	mulu.w	10(a7),d1
+	add.l	d1,d0
+	rts
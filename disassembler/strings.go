@@ -0,0 +1,87 @@
+package disassembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String is a run of printable ASCII found outside reachable code.
+type String struct {
+	Address uint32
+	Text    string
+	// Terminated is set when the text is followed by a NUL byte.
+	Terminated bool
+}
+
+// Strings extracts every printable ASCII run of at least minLen bytes, like the
+// Unix strings tool, but skips bytes that flow analysis identified as code.
+func Strings(code []byte, minLen int) []String {
+	if len(code) == 0 {
+		return nil
+	}
+	if minLen < 1 {
+		minLen = 1
+	}
+
+	instructions, _ := analyze(code, latest)
+	isCode := codeMask(code, instructions)
+
+	var list []String
+	for i := 0; i < len(code); {
+		if isCode[i] || !isPrintableASCII(code[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(code) && !isCode[i] && isPrintableASCII(code[i]) {
+			i++
+		}
+		if i-start < minLen {
+			continue
+		}
+
+		list = append(list, String{
+			Address:    uint32(start),
+			Text:       string(code[start:i]),
+			Terminated: i < len(code) && code[i] == 0,
+		})
+	}
+	return list
+}
+
+// codeMask marks every byte covered by a reachable instruction.
+func codeMask(code []byte, instructions map[uint32]*Instruction) []bool {
+	mask := make([]bool, len(code))
+	for addr, inst := range instructions {
+		if !inst.IsCode {
+			continue
+		}
+		for i := addr; i < addr+inst.Size && int(i) < len(code); i++ {
+			mask[i] = true
+		}
+	}
+	return mask
+}
+
+// FormatStrings renders strings one per line with their addresses.
+// With labels set, each line is a labelled dc.b directive ready for an include
+// file, numbered the same way Disassemble names its strings.
+func FormatStrings(list []String, labels bool) string {
+	var sb strings.Builder
+	for i, s := range list {
+		if !labels {
+			fmt.Fprintf(&sb, "%08X  %s\n", s.Address, s.Text)
+			continue
+		}
+
+		label := fmt.Sprintf("string%d:", i+1)
+		escaped := strings.ReplaceAll(s.Text, "'", "''")
+		nul := ""
+		if s.Terminated {
+			nul = ",$00"
+		}
+		fmt.Fprintf(&sb, "%-8s dc.b    '%s'%s\t; $%08X\n", label, escaped, nul, s.Address)
+	}
+	return sb.String()
+}
@@ -0,0 +1,124 @@
+package disassembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Symbols maps addresses to names imported from other tools, which replace the
+// generated loc_ and sub_ labels.
+type Symbols map[uint32]string
+
+// Line formats understood by ParseSymbols, tried in order.
+var (
+	// GNU nm, with or without -S: "00001000 T _main" or "00001000 0000001c T _main".
+	reNM = regexp.MustCompile(`^([0-9A-Fa-f]+)\s+(?:[0-9A-Fa-f]+\s+)?([A-Za-z?])\s+(\S+)$`)
+	// vlink -M: "  _main: global reloc, value 0x1000, size 28" in older
+	// versions, "  0x00001000 _main: global func, size 28" in newer ones.
+	reVlinkOld = regexp.MustCompile(`^(\S+):\s+(?:global|local|weak)\b.*\bvalue\s+0x([0-9A-Fa-f]+)`)
+	reVlinkNew = regexp.MustCompile(`^0x([0-9A-Fa-f]+)\s+(\S+):\s+(?:global|local|weak)\b`)
+	// IDA .map: " 0001:00001000       sub_1000".
+	reIDA = regexp.MustCompile(`^[0-9A-Fa-f]{4}:([0-9A-Fa-f]+)\s+(\S+)$`)
+	// Assembler-style equates, as written by Exodus and vasm: "main equ $1000",
+	// "main = $1000" or "main: equ $1000".
+	reEquate = regexp.MustCompile(`(?i)^([^\s:=]+):?\s*(?:=|equ\b)\s*(?:\$|0x)([0-9A-Fa-f]+)$`)
+	// Plain address and name lists: "$1000 main", "0x1000 main" or "00001000 main".
+	rePair = regexp.MustCompile(`^(?:\$|0x)?([0-9A-Fa-f]+)\s+(\S+)$`)
+)
+
+// ParseSymbols reads a symbol list from GNU nm, a vlink -M map, an IDA .map,
+// assembler equates or plain "address name" lines, mixed freely. Lines in
+// none of these forms, such as headers and section listings, are skipped,
+// as are nm's undefined and absolute symbols. Characters that are not valid
+// in labels, as in C++ names like "Foo::bar(int)", become underscores.
+func ParseSymbols(r io.Reader) (Symbols, error) {
+	syms := make(Symbols)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '*' {
+			continue
+		}
+
+		var addr, name string
+		if m := reNM.FindStringSubmatch(line); m != nil {
+			if strings.ContainsAny(m[2], "AaUuw?") {
+				continue
+			}
+			addr, name = m[1], m[3]
+		} else if m := reVlinkOld.FindStringSubmatch(line); m != nil {
+			addr, name = m[2], m[1]
+		} else if m := reVlinkNew.FindStringSubmatch(line); m != nil {
+			addr, name = m[1], m[2]
+		} else if m := reIDA.FindStringSubmatch(line); m != nil {
+			addr, name = m[1], m[2]
+		} else if m := reEquate.FindStringSubmatch(line); m != nil {
+			addr, name = m[2], m[1]
+		} else if m := rePair.FindStringSubmatch(line); m != nil {
+			addr, name = m[1], m[2]
+		} else {
+			continue
+		}
+
+		v, err := strconv.ParseUint(addr, 16, 32)
+		if err != nil {
+			continue
+		}
+		if _, dup := syms[uint32(v)]; !dup {
+			syms[uint32(v)] = SymbolName(name)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(syms) == 0 {
+		return nil, fmt.Errorf("no symbols found")
+	}
+	return syms, nil
+}
+
+// SymbolName turns an imported name into a valid label: characters other than
+// letters, digits, "_" and "." become "_", and a leading digit gets a "_".
+func SymbolName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !isLabelChar(c) {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// isLabelChar reports whether c may appear in a label.
+func isLabelChar(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Relocate returns the symbols that fall inside an image loaded at base, with
+// addresses relative to its start.
+func (s Symbols) Relocate(base uint32) Symbols {
+	out := make(Symbols, len(s))
+	for addr, name := range s {
+		if addr >= base {
+			out[addr-base] = name
+		}
+	}
+	return out
+}
+
+// Lookup returns the address of the named symbol.
+func (s Symbols) Lookup(name string) (uint32, bool) {
+	for addr, n := range s {
+		if n == name {
+			return addr, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,66 @@
+package disassembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sysCall describes an operating system call: its name and what each of its
+// arguments means, in the order the call takes them.
+type sysCall struct {
+	name   string
+	params []string
+	// values names the special values of some arguments, by index.
+	values map[int]map[uint32]string
+}
+
+// describe formats a call with its rendered arguments, such as
+// "Fopen(fname=a0, mode=#2 read/write)". Arguments that couldn't be found
+// show as "?".
+func (c sysCall) describe(args []string) string {
+	parts := make([]string, len(c.params))
+	for i, p := range c.params {
+		arg := "?"
+		if i < len(args) && args[i] != "" {
+			arg = args[i]
+		}
+		if v, ok := parseImmediate(arg); ok {
+			if name, ok := c.values[i][v]; ok {
+				arg += " " + name
+			}
+		}
+		parts[i] = p + "=" + arg
+	}
+	return fmt.Sprintf("%s(%s)", c.name, strings.Join(parts, ", "))
+}
+
+// trapNumber returns the vector number of a TRAP instruction.
+func trapNumber(inst *Instruction) (uint32, bool) {
+	if inst.Mnemonic != "trap" {
+		return 0, false
+	}
+	return parseImmediate(inst.Operands)
+}
+
+// writesRegister reports whether an instruction with operands ops changes
+// the data or address register reg, such as "d0".
+func writesRegister(inst *Instruction, ops []string, reg string) bool {
+	if len(ops) == 0 || ops[len(ops)-1] != reg {
+		return false
+	}
+	switch strings.SplitN(inst.Mnemonic, ".", 2)[0] {
+	case "tst", "cmp", "cmpa", "cmpi", "btst", "chk":
+		return false
+	}
+	return true
+}
+
+// isCall reports whether an instruction calls code that may change any
+// register.
+func isCall(inst *Instruction) bool {
+	switch strings.SplitN(inst.Mnemonic, ".", 2)[0] {
+	case "bsr", "jsr", "trap", "trapv":
+		return true
+	}
+	return false
+}
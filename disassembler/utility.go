@@ -104,18 +104,7 @@ func DecodeEA(ea uint16, pc int, code []byte, size uint16) (string, int) {
 		if pc+2 > len(code) {
 			return fmt.Sprintf("(?,a%d,x?)", reg), 0
 		}
-		ext := binary.BigEndian.Uint16(code[pc:])
-		disp := int8(ext & 0xFF)
-		idx := (ext >> 12) & 7
-		sizeChar := "w"
-		if (ext & 0x0800) != 0 {
-			sizeChar = "l"
-		}
-		regType := "d"
-		if (ext & 0x8000) != 0 {
-			regType = "a"
-		}
-		return fmt.Sprintf("(%s,a%d,%s%d.%s)", formatDisp8(disp), reg, regType, idx, sizeChar), 2
+		return decodeIndexed(fmt.Sprintf("a%d", reg), pc, code)
 	case 7:
 		switch reg {
 		case 0:
@@ -140,18 +129,7 @@ func DecodeEA(ea uint16, pc int, code []byte, size uint16) (string, int) {
 			if pc+2 > len(code) {
 				return "(?,pc,xn)", 0
 			}
-			ext := binary.BigEndian.Uint16(code[pc:])
-			disp := int8(ext & 0xFF)
-			idx := (ext >> 12) & 7
-			sizeChar := "w"
-			if (ext & 0x0800) != 0 {
-				sizeChar = "l"
-			}
-			regType := "d"
-			if (ext & 0x8000) != 0 {
-				regType = "a"
-			}
-			return fmt.Sprintf("(%s,pc,%s%d.%s)", formatDisp8(disp), regType, idx, sizeChar), 2
+			return decodeIndexed("pc", pc, code)
 		case 4:
 			return readImmediateBySize(code, pc, size)
 		}
@@ -193,15 +171,18 @@ func TestableDecode(op uint16, pc int, code []byte) (string, string, int) {
 	return decode(op, pc, code)
 }
 
+// formatDisp8 and formatDisp16 format a displacement as decimal when it is
+// small or negative, so it assembles back to the same value, and otherwise
+// as hex.
 func formatDisp8(v int8) string {
-	if v >= -9 && v <= 9 {
+	if v <= 9 {
 		return fmt.Sprintf("%d", v)
 	}
 	return fmt.Sprintf("$%x", uint8(v))
 }
 
 func formatDisp16(v int16) string {
-	if v >= -9 && v <= 9 {
+	if v <= 9 {
 		return fmt.Sprintf("%d", v)
 	}
 	return fmt.Sprintf("$%x", uint16(v))
@@ -259,3 +240,84 @@ func Hexdump(data []byte) {
 		fmt.Println("|")
 	}
 }
+
+// decodeIndexed decodes the extension words of an indexed mode on base, "a0"
+// to "a7" or "pc". The brief format gives (d8,An,Xn.s*scale), the scale
+// only shown when it isn't 1. The 68020's full format gives (bd,An,Xn),
+// ([bd,An],Xn,od) or ([bd,An,Xn],od), leaving out suppressed and null parts
+// and writing a suppressed base as zan or zpc. Its displacements carry
+// their size, so the text assembles back to the same words.
+func decodeIndexed(base string, pc int, code []byte) (string, int) {
+	ext := binary.BigEndian.Uint16(code[pc:])
+	index := fmt.Sprintf("d%d.w", (ext>>12)&7)
+	if ext&0x8000 != 0 {
+		index = fmt.Sprintf("a%d.w", (ext>>12)&7)
+	}
+	if ext&0x0800 != 0 {
+		index = index[:len(index)-1] + "l"
+	}
+	if scale := (ext >> 9) & 3; scale != 0 {
+		index += fmt.Sprintf("*%d", 1<<scale)
+	}
+	if ext&0x0100 == 0 {
+		return fmt.Sprintf("(%s,%s,%s)", formatDisp8(int8(ext)), base, index), 2
+	}
+
+	iis := ext & 7
+	if ext&0x0008 != 0 || ext&0x0030 == 0 || iis == 4 || ext&0x0040 != 0 && iis > 4 {
+		return fmt.Sprintf("(%s,?)", base), 2
+	}
+	used := 2
+	disp := func(size uint16) (string, bool) {
+		switch size {
+		case 2:
+			if pc+used+2 > len(code) {
+				return "", false
+			}
+			v := binary.BigEndian.Uint16(code[pc+used:])
+			used += 2
+			return fmt.Sprintf("$%x.w", v), true
+		case 3:
+			if pc+used+4 > len(code) {
+				return "", false
+			}
+			v := binary.BigEndian.Uint32(code[pc+used:])
+			used += 4
+			return fmt.Sprintf("$%x.l", v), true
+		}
+		return "", true
+	}
+	bd, ok := disp((ext >> 4) & 3)
+	if !ok {
+		return fmt.Sprintf("(?,%s)", base), 0
+	}
+	od := ""
+	if iis != 0 {
+		if od, ok = disp(iis & 3); !ok {
+			return fmt.Sprintf("(?,%s)", base), 0
+		}
+	}
+
+	if ext&0x0080 != 0 {
+		base = "z" + base
+	}
+	if ext&0x0040 != 0 {
+		index = ""
+	}
+	join := func(parts ...string) string {
+		var kept []string
+		for _, p := range parts {
+			if p != "" {
+				kept = append(kept, p)
+			}
+		}
+		return strings.Join(kept, ",")
+	}
+	switch {
+	case iis == 0:
+		return "(" + join(bd, base, index) + ")", used
+	case iis > 4:
+		return "([" + join(bd, base) + "]," + join(index, od) + ")", used
+	}
+	return "([" + join(bd, base, index) + "]" + strings.TrimSuffix(","+od, ",") + ")", used
+}
@@ -0,0 +1,31 @@
+// Package m68k is the stable face of the module: assembling, disassembling
+// and running 68000 family code through a small API that keeps working from
+// one release to the next.
+//
+// # Compatibility
+//
+// Everything this package exports is covered by the module's v1 guarantee.
+// Names are not removed or renamed, function signatures don't change, and
+// struct fields are only ever added, so code that compiles against v1.x
+// compiles against every later v1 release. The surface is recorded in
+// api/v1.txt and checked by the tests. Behaviour follows the documentation
+// here; output that is meant for people, such as disassembly listings and
+// error messages, may still improve.
+//
+// The packages below it (cpu, vm, assembler, disassembler, patch and radix)
+// carry the full feature set and are the place for tools that need it, but
+// they follow the emulator as it grows and may change between minor
+// releases; the changes are noted in the release notes. Machine.VM and
+// Machine.CPU reach them from here. Packages under internal/ are not
+// importable outside the module.
+//
+// # Deprecation
+//
+// A name that is replaced is marked with a "Deprecated:" paragraph naming its
+// replacement, and keeps working for the rest of v1. It is only removed in
+// a new major version, with its own import path
+// (github.com/Urethramancer/m68k/v2).
+package m68k
+
+// Version is the version of the stable API.
+const Version = "1.0.0"
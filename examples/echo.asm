@@ -0,0 +1,88 @@
+; echo.asm - interrupt-driven echo on the UART.
+;
+; The UART interrupt handler queues each character it receives in a ring
+; buffer, and the main loop sleeps with STOP until there is something to
+; print. It copies its input to the output with letters upper-cased, and
+; halts when the input ends. Run it from the reset vectors with the UART
+; at $FF0100, interrupting at level 4:
+;
+;   echo hello | run68 -reset -uart 0xFF0100 -uartlevel 4 examples/echo.asm
+
+STACK_TOP	equ	$10000
+UART		equ	$FF0100
+
+	include	"vectors.i"
+	include	"system.i"
+
+start:
+	movea.l	#UART,a5
+	lea	on_uart(pc),a0
+	move.l	a0,$70			; Install it as the level 4 autovector (28)
+	move.b	#UART_RX_INT,2(a5)	; UART_CONTROL
+
+; The queue is checked with interrupts masked, so a character arriving
+; between the check and STOP can't be missed: STOP unmasks them and waits
+; in one step.
+main:
+	move.w	#$2700,sr
+	move.b	tail,d0
+	cmp.b	head,d0
+	bne.s	main_print
+	tst.b	input_done
+	bne.s	main_exit
+	stop	#$2000
+	bra.s	main
+
+main_print:
+	move.w	#$2000,sr
+	andi.w	#$FF,d0
+	lea	buffer,a0
+	move.b	(a0,d0.w),d1
+	addq.b	#1,tail
+	cmpi.b	#'a',d1
+	bcs.s	main_put
+	cmpi.b	#'z',d1
+	bhi.s	main_put
+	subi.b	#$20,d1
+main_put:
+	btst	#1,1(a5)		; UART_STATUS, UART_TX_EMPTY
+	beq.s	main_put
+	move.b	d1,(a5)			; UART_DATA
+	bra.s	main
+
+main_exit:
+	bsr	sys_exit
+
+; on_uart runs at level 4 for each character and once at the end of the
+; input. It must clear the cause before returning, or the UART interrupts
+; again at once: reading UART_DATA takes the character, and at the end the
+; handler disables the interrupt instead.
+on_uart:
+	movem.l	d0/a0-a1,-(a7)
+	movea.l	#UART,a0
+	btst	#0,1(a0)		; UART_STATUS, UART_RX_READY
+	beq.s	on_uart_eof
+	moveq	#0,d0
+	move.b	head,d0
+	lea	buffer,a1
+	move.b	(a0),(a1,d0.w)		; UART_DATA
+	addq.b	#1,head
+	bra.s	on_uart_done
+on_uart_eof:
+	clr.b	2(a0)			; UART_CONTROL: no more interrupts
+	st	input_done
+on_uart_done:
+	movem.l	(a7)+,d0/a0-a1
+	rte
+
+	include	"exceptions.i"
+
+head:
+	dc.b	0			; Next free slot, written by on_uart
+tail:
+	dc.b	0			; Next character to print
+input_done:
+	dc.b	0			; Set by on_uart at the end of the input
+	even
+buffer:
+	ds.b	256
@@ -0,0 +1,30 @@
+; pattern.asm - draws an XOR pattern into the framebuffer.
+;
+; Each pixel of a 320x200 screen with a byte per pixel gets the exclusive
+; or of its coordinates, shown as a shade of grey. Run it with the
+; framebuffer at $100000 and save the picture:
+;
+;   run68 -fb 0x100000 -fbmode 320x200x8 -fbdump pattern.png examples/pattern.asm
+
+FB	equ	$100000
+WIDTH	equ	320
+HEIGHT	equ	200
+
+start:
+	movea.l	#FB,a0
+	moveq	#0,d1			; y
+row:
+	moveq	#0,d0			; x
+col:
+	move.b	d0,d2
+	eor.b	d1,d2
+	move.b	d2,(a0)+
+	addq.w	#1,d0
+	cmpi.w	#WIDTH,d0
+	bne.s	col
+	addq.w	#1,d1
+	cmpi.w	#HEIGHT,d1
+	bne.s	row
+	bsr	sys_exit
+
+	include	"system.i"
@@ -0,0 +1,13 @@
+; Formats a number with the runtime library. Run it with run68 and the
+; text is left in buffer, with A0 pointing at its end.
+start:
+	move.l	#-12345,d0
+	lea	buffer,a0
+	bsr	itoa
+	bsr	sys_exit
+
+	include	"system.i"
+	include	"runtime.i"
+
+buffer:
+	ds.b	16
@@ -1,3 +1,6 @@
+; MOVE from CCR is a 68010 instruction.
+        machine 68010
+
 ; --- move from sr (supervisor only)
 move_from_sr:
         move    sr,d0          ; expect: 40c0
@@ -0,0 +1,11 @@
+; sum: D0 = D1 + D2
+sum:
+	move.l	d1,d0
+	add.l	d2,d0
+	rts
+
+; double: D0 = D1 * 2
+double:
+	move.l	d1,d0
+	asl.l	#1,d0
+	rts
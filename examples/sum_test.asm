@@ -0,0 +1,21 @@
+; Tests for sum.asm. Run with: test68 -v examples/sum.asm examples/sum_test.asm
+; Each test_* routine returns with D0=0 to pass.
+
+test_sum:
+	moveq	#2,d1
+	moveq	#3,d2
+	bsr	sum
+	subq.l	#5,d0
+	rts
+
+test_sum_negative:
+	moveq	#-7,d1
+	moveq	#7,d2
+	bsr	sum
+	rts
+
+test_double:
+	move.l	#$40000000,d1
+	bsr	double
+	sub.l	#$80000000,d0
+	rts
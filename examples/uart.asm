@@ -0,0 +1,107 @@
+; uart.asm - polled and interrupt-driven I/O with the UART.
+;
+; Output is polled: each character waits for UART_TX_EMPTY before it is
+; written to UART_DATA. Input arrives by interrupt: the handler counts the
+; characters and lines it receives, and the main loop sleeps with STOP
+; until the input ends, then prints the counts. Run it from the reset
+; vectors with the UART at $FF0100, interrupting at level 4:
+;
+;   printf 'one\ntwo\n' | run68 -reset -uart 0xFF0100 -uartlevel 4 examples/uart.asm
+
+STACK_TOP	equ	$10000
+UART		equ	$FF0100
+
+	include	"vectors.i"
+	include	"system.i"
+
+start:
+	movea.l	#UART,a5
+	lea	banner,a0
+	bsr	puts
+	lea	on_uart(pc),a0
+	move.l	a0,$70			; Install it as the level 4 autovector (28)
+	move.b	#UART_RX_INT,2(a5)	; UART_CONTROL
+
+; The flag is checked with interrupts masked, so the end of the input
+; can't slip in between the check and STOP: STOP unmasks them and waits
+; in one step.
+main:
+	move.w	#$2700,sr
+	tst.b	input_done
+	bne.s	main_report
+	stop	#$2000
+	bra.s	main
+
+main_report:
+	move.w	#$2000,sr
+	move.l	chars,d0
+	bsr	putnum
+	lea	chars_msg,a0
+	bsr	puts
+	move.l	lines,d0
+	bsr	putnum
+	lea	lines_msg,a0
+	bsr	puts
+	bsr	sys_exit
+
+; puts writes the NUL-terminated string at (A0).
+puts:
+	move.b	(a0)+,d0
+	beq.s	puts_done
+	bsr.s	putc
+	bra.s	puts
+puts_done:
+	rts
+
+; putc writes the character in D0 once the UART can take it.
+putc:
+	btst	#1,1(a5)		; UART_STATUS, UART_TX_EMPTY
+	beq.s	putc
+	move.b	d0,(a5)			; UART_DATA
+	rts
+
+; putnum writes D0.L in decimal.
+putnum:
+	lea	number,a0
+	bsr	utoa
+	lea	number,a0
+	bra.s	puts
+
+; on_uart runs at level 4 for each character and once at the end of the
+; input. Reading UART_DATA takes the character and drops the interrupt
+; until the next; at the end the handler disables the interrupt instead.
+on_uart:
+	movem.l	d0/a0,-(a7)
+	movea.l	#UART,a0
+	btst	#0,1(a0)		; UART_STATUS, UART_RX_READY
+	beq.s	on_uart_eof
+	move.b	(a0),d0			; UART_DATA
+	addq.l	#1,chars
+	cmpi.b	#10,d0
+	bne.s	on_uart_done
+	addq.l	#1,lines
+	bra.s	on_uart_done
+on_uart_eof:
+	clr.b	2(a0)			; UART_CONTROL: no more interrupts
+	st	input_done
+on_uart_done:
+	movem.l	(a7)+,d0/a0
+	rte
+
+	include	"exceptions.i"
+	include	"runtime.i"
+
+chars:
+	dc.l	0			; Characters received, counted by on_uart
+lines:
+	dc.l	0			; Newlines received
+input_done:
+	dc.b	0			; Set by on_uart at the end of the input
+banner:
+	dc.b	'Counting characters and lines until the input ends.',10,0
+chars_msg:
+	dc.b	' characters',10,0
+lines_msg:
+	dc.b	' lines',10,0
+number:
+	ds.b	12
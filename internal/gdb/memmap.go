@@ -0,0 +1,80 @@
+package gdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Region is a range of target memory reported to gdb in the memory map.
+type Region struct {
+	Start  uint32
+	Length uint32
+	// ROM marks memory gdb cannot write, so it uses hardware breakpoints there.
+	ROM bool
+}
+
+// Contains reports whether addr is inside the region.
+func (r Region) Contains(addr uint32) bool {
+	return addr >= r.Start && uint64(addr) < uint64(r.Start)+uint64(r.Length)
+}
+
+// MemoryMapXML describes regions in gdb's memory-map format. gdb refuses to
+// access memory outside the map, and with "breakpoint auto-hw" (the default)
+// uses hardware breakpoints in ROM.
+func MemoryMapXML(regions []Region) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE memory-map PUBLIC "+//IDN gnu.org//DTD GDB Memory Map V1.0//EN" "http://sourceware.org/gdb/gdb-memory-map.dtd">
+<memory-map>
+`)
+	for _, r := range regions {
+		kind := "ram"
+		if r.ROM {
+			kind = "rom"
+		}
+		fmt.Fprintf(&sb, "  <memory type=\"%s\" start=\"0x%x\" length=\"0x%x\"/>\n", kind, r.Start, r.Length)
+	}
+	sb.WriteString("</memory-map>\n")
+	return sb.String()
+}
+
+// BreakpointKind is how a stub places a breakpoint.
+type BreakpointKind int
+
+const (
+	// NoBreakpoint means the address is outside the memory map.
+	NoBreakpoint BreakpointKind = iota
+	// SoftwareBreakpoint is a breakpoint in writable memory, which a stub may
+	// place by patching the instruction (Z0) or in hardware (Z1).
+	SoftwareBreakpoint
+	// HardwareBreakpoint is a breakpoint in ROM, which only hardware can
+	// watch for (Z1).
+	HardwareBreakpoint
+)
+
+// String returns the kind's name.
+func (k BreakpointKind) String() string {
+	switch k {
+	case SoftwareBreakpoint:
+		return "software"
+	case HardwareBreakpoint:
+		return "hardware"
+	default:
+		return "none"
+	}
+}
+
+// BreakpointFor returns the kind of breakpoint addr can take: a software one
+// in RAM, a hardware one in ROM, which cannot be patched, and none outside
+// the regions.
+func BreakpointFor(regions []Region, addr uint32) BreakpointKind {
+	for _, r := range regions {
+		if r.Contains(addr) {
+			if r.ROM {
+				return HardwareBreakpoint
+			}
+			return SoftwareBreakpoint
+		}
+	}
+	return NoBreakpoint
+}
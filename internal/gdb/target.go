@@ -0,0 +1,89 @@
+// Package gdb holds the pieces of a GDB remote serial protocol stub that
+// describe the target: the register set, the memory map, and how breakpoints
+// are placed in it.
+package gdb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// TargetXML describes the 68000 register set in the order used by the g and G
+// packets: D0-D7, A0-A5, FP (A6), SP (A7), PS (the status register) and PC.
+// The status register is described as flags, so gdb shows the decoded bits.
+const TargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <architecture>m68k</architecture>
+  <feature name="org.gnu.gdb.m68k.core">
+    <flags id="sr_flags" size="4">
+      <field name="C" start="0" end="0"/>
+      <field name="V" start="1" end="1"/>
+      <field name="Z" start="2" end="2"/>
+      <field name="N" start="3" end="3"/>
+      <field name="X" start="4" end="4"/>
+      <field name="I" start="8" end="10"/>
+      <field name="S" start="13" end="13"/>
+      <field name="T" start="15" end="15"/>
+    </flags>
+    <reg name="d0" bitsize="32" regnum="0"/>
+    <reg name="d1" bitsize="32"/>
+    <reg name="d2" bitsize="32"/>
+    <reg name="d3" bitsize="32"/>
+    <reg name="d4" bitsize="32"/>
+    <reg name="d5" bitsize="32"/>
+    <reg name="d6" bitsize="32"/>
+    <reg name="d7" bitsize="32"/>
+    <reg name="a0" bitsize="32" type="data_ptr"/>
+    <reg name="a1" bitsize="32" type="data_ptr"/>
+    <reg name="a2" bitsize="32" type="data_ptr"/>
+    <reg name="a3" bitsize="32" type="data_ptr"/>
+    <reg name="a4" bitsize="32" type="data_ptr"/>
+    <reg name="a5" bitsize="32" type="data_ptr"/>
+    <reg name="fp" bitsize="32" type="data_ptr"/>
+    <reg name="sp" bitsize="32" type="data_ptr"/>
+    <reg name="ps" bitsize="32" type="sr_flags"/>
+    <reg name="pc" bitsize="32" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+// NumRegisters is the number of registers described by TargetXML.
+const NumRegisters = 18
+
+// Registers encodes the CPU registers as the hex payload of a g reply.
+func Registers(c *cpu.CPU) string {
+	buf := make([]byte, 0, NumRegisters*4)
+	for _, d := range c.D {
+		buf = binary.BigEndian.AppendUint32(buf, d)
+	}
+	for _, a := range c.A {
+		buf = binary.BigEndian.AppendUint32(buf, a)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(c.SR))
+	buf = binary.BigEndian.AppendUint32(buf, c.PC)
+	return hex.EncodeToString(buf)
+}
+
+// SetRegisters decodes the hex payload of a G packet into the CPU registers.
+func SetRegisters(c *cpu.CPU, payload string) error {
+	buf, err := hex.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("invalid register data: %w", err)
+	}
+	if len(buf) != NumRegisters*4 {
+		return fmt.Errorf("expected %d bytes of register data, got %d", NumRegisters*4, len(buf))
+	}
+
+	reg := func(i int) uint32 { return binary.BigEndian.Uint32(buf[i*4:]) }
+	for i := range 8 {
+		c.D[i] = reg(i)
+		c.A[i] = reg(8 + i)
+	}
+	c.SR = cpu.SR(reg(16))
+	c.PC = reg(17)
+	return nil
+}
@@ -0,0 +1,105 @@
+package gdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Features answers gdb's queries about the target.
+type Features struct {
+	// Regions is the memory map. Without regions, no memory map is offered.
+	Regions []Region
+}
+
+// Supported returns the features to add to a qSupported reply.
+func (f *Features) Supported() string {
+	s := "qXfer:features:read+"
+	if len(f.Regions) > 0 {
+		s += ";qXfer:memory-map:read+"
+	}
+	return s
+}
+
+// HandleQuery answers a qXfer read packet (without the $ and checksum), such
+// as "qXfer:features:read:target.xml:0,fff". It reports false for packets it
+// does not handle, so the stub can try others.
+func (f *Features) HandleQuery(packet string) (string, bool) {
+	rest, ok := strings.CutPrefix(packet, "qXfer:")
+	if !ok {
+		return "", false
+	}
+
+	parts := strings.SplitN(rest, ":", 4)
+	if len(parts) != 4 || parts[1] != "read" {
+		return "", true // An empty reply marks an unsupported object or operation.
+	}
+	object, annex, window := parts[0], parts[2], parts[3]
+
+	var doc string
+	switch {
+	case object == "features" && annex == "target.xml":
+		doc = TargetXML
+	case object == "features":
+		return "E00", true
+	case object == "memory-map" && len(f.Regions) > 0:
+		doc = MemoryMapXML(f.Regions)
+	default:
+		return "", true
+	}
+
+	offset, length, err := parseWindow(window)
+	if err != nil {
+		return "E01", true
+	}
+	return xferChunk(doc, offset, length), true
+}
+
+// parseWindow parses the "offset,length" of a qXfer read, both in hex.
+func parseWindow(s string) (int, int, error) {
+	off, length, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid qXfer window %q", s)
+	}
+	o, err := strconv.ParseUint(off, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	l, err := strconv.ParseUint(length, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(o), int(l), nil
+}
+
+// xferChunk returns the part of doc gdb asked for, prefixed by "m" if there is
+// more to read or "l" if this is the last part.
+func xferChunk(doc string, offset, length int) string {
+	if offset >= len(doc) {
+		return "l"
+	}
+	end := offset + length
+	if end >= len(doc) {
+		return "l" + escape(doc[offset:])
+	}
+	return "m" + escape(doc[offset:end])
+}
+
+// escape applies the binary escaping of the remote protocol: '#', '$', '}'
+// and '*' become '}' followed by the byte XOR 0x20.
+func escape(s string) string {
+	if !strings.ContainsAny(s, "#$}*") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '#', '$', '}', '*':
+			sb.WriteByte('}')
+			sb.WriteByte(c ^ 0x20)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
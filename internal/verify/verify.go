@@ -0,0 +1,98 @@
+// Package verify checks the assembler and the disassembler against each
+// other. Every instruction of an assembly is decoded from the words it was
+// encoded as, and the decoded text is assembled again at the same address.
+// An instruction that decodes to a different length, or whose decoded text
+// assembles to different words, is a disagreement between the two halves,
+// and one of them has a bug.
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+// Mismatch is an instruction the disassembler reads back differently from
+// how the assembler encoded it.
+type Mismatch struct {
+	// Line, Address and Source locate the instruction in the assembly.
+	Line    int
+	Address uint32
+	Source  string
+	// Encoded is what the assembler emitted, and Decoded the text the
+	// disassembler read it as.
+	Encoded []byte
+	Decoded string
+	// Reassembled is what Decoded assembles to, when it assembles.
+	Reassembled []byte
+	// Problem says how the two disagree.
+	Problem string
+}
+
+// String describes the mismatch on one line.
+func (m Mismatch) String() string {
+	return fmt.Sprintf("line %d: $%06X %s: encoded as % X, decoded as %s: %s",
+		m.Line, m.Address, m.Source, m.Encoded, m.Decoded, m.Problem)
+}
+
+// Check decodes and reassembles every instruction in encodings, the record
+// of an assembly from Assembler.Encodings, and returns those that don't
+// survive the trip. Directives are skipped, as data has no single reading.
+func Check(encodings []assembler.Encoding) []Mismatch {
+	var list []Mismatch
+	for _, e := range encodings {
+		if len(e.Words) == 0 {
+			continue
+		}
+		if m, ok := check(e); !ok {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// check round-trips one instruction, reporting whether it survived.
+func check(e assembler.Encoding) (Mismatch, bool) {
+	code := make([]byte, 0, len(e.Words)*2)
+	for _, w := range e.Words {
+		code = binary.BigEndian.AppendUint16(code, w)
+	}
+	m := Mismatch{Line: e.Line, Address: e.Address, Source: e.Source, Encoded: code}
+	inst := disassembler.DecodeRange(code, e.Address)[0]
+	mn := inst.Mnemonic
+	if inst.Op&0xF000 == cpu.OPBRA && inst.Op&0xFF == 0 {
+		// The disassembler leaves a branch's size to the assembler, which
+		// would pick the short form whenever it fits.
+		mn += ".w"
+	}
+	m.Decoded = strings.TrimSpace(mn + " " + inst.Operands)
+	switch {
+	case mn == "dc.w":
+		m.Problem = "not decoded"
+		return m, false
+	case int(inst.Size) != len(code):
+		m.Problem = fmt.Sprintf("decoded as %d bytes", inst.Size)
+		return m, false
+	}
+
+	// The decoded text is assembled for the latest target, so that every
+	// instruction and mode the disassembler knows is accepted.
+	asm := assembler.New()
+	asm.Target = cpu.MC68060
+	re, err := asm.Assemble("\t"+m.Decoded+"\n", e.Address)
+	if err != nil {
+		m.Problem = fmt.Sprintf("does not reassemble: %v", err)
+		return m, false
+	}
+	m.Reassembled = re
+	if !bytes.Equal(re, code) {
+		m.Problem = fmt.Sprintf("reassembles as % X", re)
+		return m, false
+	}
+	return m, true
+}
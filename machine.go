@@ -0,0 +1,169 @@
+package m68k
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/vm"
+)
+
+// MachineConfig describes a Machine. The zero value is a 68000 with 16 MiB
+// of RAM.
+type MachineConfig struct {
+	// MemorySize is the RAM in bytes, from address 0 (16 MiB if 0).
+	MemorySize int
+	// Model is the CPU (the 68000 if 0).
+	Model Model
+	// FPU attaches a floating-point unit, for a 68020 or later.
+	FPU bool
+	// Address32 gives the CPU 32 address lines instead of wrapping addresses
+	// at 16 MiB. A 68020 or later has them anyway.
+	Address32 bool
+}
+
+// Machine is a 68000 family CPU with RAM. Like the real chip after reset,
+// it starts in supervisor mode with interrupts masked, and a program halts
+// it with TRAP #15.
+type Machine struct {
+	vm *vm.VM
+}
+
+// machineCache is the number of decoded instructions a Machine caches.
+const machineCache = 1024
+
+// NewMachine returns a machine configured by cfg.
+func NewMachine(cfg MachineConfig) (*Machine, error) {
+	size := cfg.MemorySize
+	if size == 0 {
+		size = 16 * 1024 * 1024
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid memory size %d", size)
+	}
+	if cfg.FPU && cfg.Model < MC68020 {
+		return nil, fmt.Errorf("an FPU needs a 68020 or later")
+	}
+	v := vm.New(size, machineCache)
+	v.CPU.SetModel(cfg.Model)
+	if cfg.FPU {
+		v.CPU.SetCoprocessor(1, cpu.NewFPU())
+	}
+	if cfg.Address32 {
+		v.CPU.AddressMask = cpu.Address32
+	}
+	return &Machine{vm: v}, nil
+}
+
+// Load copies an assembled program to its origin and sets the PC to its
+// entry point.
+func (m *Machine) Load(p *Program) error {
+	if err := m.Write(p.Origin, p.Code); err != nil {
+		return err
+	}
+	m.vm.CPU.PC = p.Entry
+	return nil
+}
+
+// Read fills b from memory at addr.
+func (m *Machine) Read(addr uint32, b []byte) error {
+	return m.vm.CPU.Memory().ReadBytes(addr, b)
+}
+
+// Write copies b to memory at addr.
+func (m *Machine) Write(addr uint32, b []byte) error {
+	return m.vm.CPU.Memory().WriteBytes(addr, b)
+}
+
+// Registers are the CPU's user-visible registers.
+type Registers struct {
+	D [8]uint32
+	// A7 is the active stack pointer, as SR chooses; USP and SSP hold both.
+	A        [8]uint32
+	PC       uint32
+	SR       uint16
+	USP, SSP uint32
+}
+
+// Registers returns the CPU's registers.
+func (m *Machine) Registers() Registers {
+	c := m.vm.CPU
+	r := Registers{D: c.D, A: c.A, PC: c.PC, SR: uint16(c.SR)}
+	r.USP, r.SSP = c.StackPointers()
+	return r
+}
+
+// SetRegisters loads the CPU's registers. A7 is taken as the stack pointer
+// SR makes active, and the other comes from USP or SSP.
+func (m *Machine) SetRegisters(r Registers) {
+	c := m.vm.CPU
+	c.D, c.A, c.PC, c.SR = r.D, r.A, r.PC, cpu.SR(r.SR)
+	if c.SR.Supervisor() {
+		c.USP = r.USP
+	} else {
+		c.SSP = r.SSP
+	}
+}
+
+// Step runs one instruction.
+func (m *Machine) Step() error {
+	return m.vm.Step()
+}
+
+// StopReason says why Run returned. Breakpoints set through VM or CPU add
+// the vm package's other reasons.
+type StopReason = vm.RunReason
+
+// Reasons reported by Run.
+const (
+	// Halted means the program halted.
+	Halted = vm.RunHalted
+	// Stopped means the program executed STOP and nothing could interrupt
+	// it.
+	Stopped = vm.RunStopped
+	// CycleLimit means the cycle limit was reached.
+	CycleLimit = vm.RunCycleLimit
+	// Cancelled means the context was cancelled.
+	Cancelled = vm.RunCancelled
+	// Fault means an instruction failed; Run returns its error.
+	Fault = vm.RunFault
+)
+
+// Run runs from the PC until the program halts or stops, maxCycles clock
+// cycles have passed (no limit if 0) or ctx is cancelled.
+func (m *Machine) Run(ctx context.Context, maxCycles uint64) (StopReason, error) {
+	return m.vm.Run(ctx, vm.RunOptions{MaxCycles: maxCycles})
+}
+
+// OnTrap makes TRAP #n (0-15) call fn instead of taking its exception, for
+// system calls provided by the host. fn runs with the PC on the next
+// instruction, and an error from it fails the TRAP. A nil fn removes the
+// handler.
+func (m *Machine) OnTrap(n int, fn func(*Machine) error) error {
+	if fn == nil {
+		return m.vm.OnTrap(n, nil)
+	}
+	return m.vm.OnTrap(n, func(*cpu.CPU) error { return fn(m) })
+}
+
+// OnLineTrap makes the line 1010 or line 1111 opcode (one starting with $A
+// or $F) call fn instead of taking its emulator exception, for system calls
+// such as classic Mac OS's A-line traps. fn runs with the PC just past the
+// opcode, and skips any words that follow it. A nil fn removes the handler.
+func (m *Machine) OnLineTrap(opcode uint16, fn func(*Machine) error) error {
+	if fn == nil {
+		return m.vm.OnLineTrap(opcode, nil)
+	}
+	return m.vm.OnLineTrap(opcode, func(*cpu.CPU) error { return fn(m) })
+}
+
+// VM returns the virtual machine underneath, with its devices, debugger and
+// the rest of the vm package. It is outside the v1 guarantee.
+func (m *Machine) VM() *vm.VM {
+	return m.vm
+}
+
+// CPU returns the CPU underneath. It is outside the v1 guarantee.
+func (m *Machine) CPU() *cpu.CPU {
+	return m.vm.CPU
+}
@@ -0,0 +1,170 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// BPS actions, stored in the low two bits of each command.
+const (
+	bpsSourceRead = 0
+	bpsTargetRead = 1
+	bpsSourceCopy = 2
+	bpsTargetCopy = 3
+)
+
+// WriteBPS writes a BPS patch turning original into patched. Unchanged runs
+// are copied from the source and changed runs are stored literally, with the
+// CRC32 checksums BPS appliers use to reject the wrong source file.
+func WriteBPS(w io.Writer, original, patched []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString("BPS1")
+	writeBPSNumber(&buf, uint64(len(original)))
+	writeBPSNumber(&buf, uint64(len(patched)))
+	writeBPSNumber(&buf, 0) // No metadata
+
+	pos := 0
+	for _, r := range diffRuns(original, patched) {
+		if r[0] > pos {
+			writeBPSNumber(&buf, uint64(r[0]-pos-1)<<2|bpsSourceRead)
+		}
+		writeBPSNumber(&buf, uint64(r[1]-r[0]-1)<<2|bpsTargetRead)
+		buf.Write(patched[r[0]:r[1]])
+		pos = r[1]
+	}
+	if pos < len(patched) {
+		// The tail is unchanged; diffRuns covers anything past the source.
+		writeBPSNumber(&buf, uint64(len(patched)-pos-1)<<2|bpsSourceRead)
+	}
+
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(original)))
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(patched)))
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(buf.Bytes())))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeBPSNumber writes n in BPS's variable-length encoding: seven bits per
+// byte, least significant first, with the top bit marking the last byte and
+// each continuation implicitly adding one.
+func writeBPSNumber(buf *bytes.Buffer, n uint64) {
+	for {
+		x := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			buf.WriteByte(0x80 | x)
+			return
+		}
+		buf.WriteByte(x)
+		n--
+	}
+}
+
+// ApplyBPS applies a BPS patch to image and returns the result. The checksums
+// are verified, so a patch made for a different file is refused. The image
+// itself is not modified.
+func ApplyBPS(image, p []byte) ([]byte, error) {
+	if !bytes.HasPrefix(p, []byte("BPS1")) || len(p) < 4+3+12 {
+		return nil, fmt.Errorf("not a BPS patch")
+	}
+	footer := p[len(p)-12:]
+	if crc32.ChecksumIEEE(p[:len(p)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, fmt.Errorf("BPS patch is corrupt")
+	}
+	if crc32.ChecksumIEEE(image) != binary.LittleEndian.Uint32(footer) {
+		return nil, fmt.Errorf("BPS patch is for a different file")
+	}
+
+	r := &bpsReader{data: p[:len(p)-12], pos: 4}
+	sourceSize, targetSize, metaSize := r.number(), r.number(), r.number()
+	r.pos += int(metaSize)
+	if r.err != nil || sourceSize != uint64(len(image)) || targetSize > 1<<32 || r.pos > len(r.data) {
+		return nil, fmt.Errorf("BPS header does not match the file")
+	}
+
+	out := make([]byte, 0, min(targetSize, 1<<24))
+	var sourceOffset, targetOffset int64
+	for r.pos < len(r.data) && r.err == nil {
+		cmd := r.number()
+		n := int(cmd>>2) + 1
+		if uint64(len(out)+n) > targetSize {
+			return nil, fmt.Errorf("BPS action writes past the target size")
+		}
+		switch cmd & 3 {
+		case bpsSourceRead:
+			if len(out)+n > len(image) {
+				return nil, fmt.Errorf("BPS source read past the end of the file")
+			}
+			out = append(out, image[len(out):len(out)+n]...)
+		case bpsTargetRead:
+			if r.pos+n > len(r.data) {
+				return nil, fmt.Errorf("BPS literal is truncated")
+			}
+			out = append(out, r.data[r.pos:r.pos+n]...)
+			r.pos += n
+		case bpsSourceCopy:
+			sourceOffset += r.offset()
+			if sourceOffset < 0 || sourceOffset+int64(n) > int64(len(image)) {
+				return nil, fmt.Errorf("BPS source copy outside the file")
+			}
+			out = append(out, image[sourceOffset:sourceOffset+int64(n)]...)
+			sourceOffset += int64(n)
+		case bpsTargetCopy:
+			targetOffset += r.offset()
+			if targetOffset < 0 || targetOffset >= int64(len(out)) {
+				return nil, fmt.Errorf("BPS target copy outside the output")
+			}
+			// Copy a byte at a time: the source may overlap what is written.
+			for range n {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if uint64(len(out)) != targetSize || crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(footer[4:]) {
+		return nil, fmt.Errorf("BPS result does not match its checksum")
+	}
+	return out, nil
+}
+
+// bpsReader decodes the numbers in a BPS patch.
+type bpsReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// number reads a number written by writeBPSNumber.
+func (r *bpsReader) number() uint64 {
+	var n uint64
+	shift := uint64(1)
+	for {
+		if r.pos >= len(r.data) || shift > 1<<56 {
+			r.err = fmt.Errorf("BPS number is truncated")
+			return 0
+		}
+		x := r.data[r.pos]
+		r.pos++
+		n += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return n
+		}
+		shift <<= 7
+		n += shift
+	}
+}
+
+// offset reads a signed relative offset, stored with the sign in the low bit.
+func (r *bpsReader) offset() int64 {
+	n := r.number()
+	if n&1 != 0 {
+		return -int64(n >> 1)
+	}
+	return int64(n >> 1)
+}
@@ -0,0 +1,121 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// IPS limits: offsets are 24 bits and records hold at most 65535 bytes.
+const (
+	ipsMaxOffset = 1<<24 - 1
+	ipsMaxRecord = 0xFFFF
+	// ipsEOF is the offset that reads as the "EOF" trailer, so no record may
+	// start there.
+	ipsEOF = 0x454F46
+)
+
+// WriteIPS writes an IPS patch turning original into patched. IPS can only
+// overwrite and extend, so patched may not be shorter, and it cannot address
+// beyond 16 MiB.
+func WriteIPS(w io.Writer, original, patched []byte) error {
+	if len(patched) < len(original) {
+		return fmt.Errorf("IPS cannot shrink a file from %d to %d bytes", len(original), len(patched))
+	}
+	if len(patched) > ipsMaxOffset {
+		return fmt.Errorf("IPS cannot address %d bytes", len(patched))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("PATCH")
+	for _, r := range diffRuns(original, patched) {
+		start, end := r[0], r[1]
+		if start == ipsEOF {
+			// Start one byte early, repeating an unchanged byte.
+			start--
+		}
+		for start < end {
+			n := min(end-start, ipsMaxRecord)
+			buf.Write([]byte{byte(start >> 16), byte(start >> 8), byte(start), byte(n >> 8), byte(n)})
+			buf.Write(patched[start : start+n])
+			start += n
+		}
+	}
+	buf.WriteString("EOF")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// diffRuns returns the [start, end) ranges where patched differs from
+// original, including any bytes beyond the end of original.
+func diffRuns(original, patched []byte) [][2]int {
+	var runs [][2]int
+	for i := 0; i < len(patched); {
+		if i < len(original) && original[i] == patched[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(patched) && (j >= len(original) || original[j] != patched[j]) {
+			j++
+		}
+		runs = append(runs, [2]int{i, j})
+		i = j
+	}
+	return runs
+}
+
+// ApplyIPS applies an IPS patch to image and returns the result. Records past
+// the end of the image extend it, and the common truncation extension after the
+// trailer is honoured. The image itself is not modified.
+func ApplyIPS(image, p []byte) ([]byte, error) {
+	if !bytes.HasPrefix(p, []byte("PATCH")) {
+		return nil, fmt.Errorf("not an IPS patch")
+	}
+	out := bytes.Clone(image)
+	pos := 5
+	for {
+		if pos+3 > len(p) {
+			return nil, fmt.Errorf("IPS patch ends without EOF")
+		}
+		offset := int(p[pos])<<16 | int(p[pos+1])<<8 | int(p[pos+2])
+		pos += 3
+		if offset == ipsEOF {
+			break
+		}
+		if pos+2 > len(p) {
+			return nil, fmt.Errorf("IPS record at $%06X is truncated", offset)
+		}
+		size := int(p[pos])<<8 | int(p[pos+1])
+		pos += 2
+
+		var data []byte
+		if size == 0 {
+			// Run-length record: a 16-bit count and the byte to repeat.
+			if pos+3 > len(p) {
+				return nil, fmt.Errorf("IPS run at $%06X is truncated", offset)
+			}
+			size = int(p[pos])<<8 | int(p[pos+1])
+			data = bytes.Repeat([]byte{p[pos+2]}, size)
+			pos += 3
+		} else {
+			if pos+size > len(p) {
+				return nil, fmt.Errorf("IPS record at $%06X is truncated", offset)
+			}
+			data = p[pos : pos+size]
+			pos += size
+		}
+		if end := offset + size; end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	if len(p)-pos == 3 {
+		size := int(p[pos])<<16 | int(p[pos+1])<<8 | int(p[pos+2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
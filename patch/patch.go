@@ -0,0 +1,199 @@
+// Package patch describes changes to 68000 binary images, converts them to the
+// IPS and BPS patch formats, and applies patches in those formats.
+//
+// A patch description has one change per line:
+//
+//	$1000: 4E 71 4E 71 -> 4E 75 4E 71
+//	$2040: bne $2050 -> bra $2050
+//	$3000: moveq #0,d0; rts -> moveq #1,d0; rts
+//
+// The address is where the change starts in the target's memory. Each side of
+// the arrow is either hex bytes or instructions separated by ";", assembled at
+// the address. The old side must match the image, so a description written for
+// one version of a program refuses to damage another. Instructions on the new
+// side may be shorter than the old ones, in which case the rest is filled with
+// NOPs. Lines starting with "*" or "//" are comments.
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+// Change replaces the bytes at Address.
+type Change struct {
+	// Address is where the change starts in the target's memory.
+	Address uint32
+	// Old is what the image must hold before the change, and New what replaces it.
+	Old, New []byte
+	// Line is the line of the description the change came from.
+	Line int
+}
+
+// reHexBytes matches a side of a change written as hex bytes.
+var reHexBytes = regexp.MustCompile(`^([0-9A-Fa-f]{2}\s*)+$`)
+
+// Parse reads a patch description.
+func Parse(r io.Reader) ([]Change, error) {
+	var changes []Change
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "*") || strings.HasPrefix(text, "//") {
+			continue
+		}
+		c, err := parseChange(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		c.Line = line
+		changes = append(changes, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// parseChange parses "address: old -> new".
+func parseChange(text string) (Change, error) {
+	addrText, rest, ok := strings.Cut(text, ":")
+	if !ok {
+		return Change{}, fmt.Errorf("expected address: old -> new")
+	}
+	addr, err := parseAddress(addrText)
+	if err != nil {
+		return Change{}, err
+	}
+	oldText, newText, ok := strings.Cut(rest, "->")
+	if !ok {
+		return Change{}, fmt.Errorf("expected old -> new after the address")
+	}
+
+	c := Change{Address: addr}
+	oldCode, oldIsCode, err := parseSide(strings.TrimSpace(oldText), addr)
+	if err != nil {
+		return Change{}, fmt.Errorf("old: %w", err)
+	}
+	newCode, newIsCode, err := parseSide(strings.TrimSpace(newText), addr)
+	if err != nil {
+		return Change{}, fmt.Errorf("new: %w", err)
+	}
+	c.Old, c.New = oldCode, newCode
+
+	switch {
+	case len(c.New) > len(c.Old):
+		return Change{}, fmt.Errorf("new bytes (%d) are longer than the old (%d)", len(c.New), len(c.Old))
+	case len(c.New) < len(c.Old) && newIsCode && oldIsCode && (len(c.Old)-len(c.New))%2 == 0:
+		for len(c.New) < len(c.Old) {
+			c.New = append(c.New, cpu.OPNOP>>8, cpu.OPNOP&0xFF)
+		}
+	case len(c.New) < len(c.Old):
+		return Change{}, fmt.Errorf("new bytes (%d) are shorter than the old (%d)", len(c.New), len(c.Old))
+	}
+	return c, nil
+}
+
+// parseAddress reads an address in hex with a "$" or "0x" prefix, or in decimal.
+func parseAddress(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	var v uint64
+	var err error
+	switch {
+	case strings.HasPrefix(s, "$"):
+		v, err = strconv.ParseUint(s[1:], 16, 32)
+	case strings.HasPrefix(strings.ToLower(s), "0x"):
+		v, err = strconv.ParseUint(s[2:], 16, 32)
+	default:
+		v, err = strconv.ParseUint(s, 10, 32)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %s", s)
+	}
+	return uint32(v), nil
+}
+
+// parseSide turns one side of a change into bytes, reporting whether it was
+// written as instructions.
+func parseSide(s string, addr uint32) ([]byte, bool, error) {
+	if s == "" {
+		return nil, false, fmt.Errorf("missing bytes or instructions")
+	}
+	if reHexBytes.MatchString(s) {
+		var code []byte
+		for _, f := range strings.Fields(s) {
+			for i := 0; i < len(f); i += 2 {
+				b, _ := strconv.ParseUint(f[i:i+2], 16, 8)
+				code = append(code, byte(b))
+			}
+		}
+		return code, false, nil
+	}
+
+	src := "\t" + strings.Join(strings.Split(s, ";"), "\n\t") + "\n"
+	code, err := assembler.New().Assemble(src, addr)
+	if err != nil {
+		return nil, true, err
+	}
+	return code, true, nil
+}
+
+// MismatchError reports an image that does not hold a change's old bytes.
+type MismatchError struct {
+	Change Change
+	// Found is what the image holds instead.
+	Found []byte
+}
+
+// Error shows the expected and actual bytes, disassembled.
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("line %d: $%06X holds %s, expected %s", e.Change.Line, e.Change.Address,
+		Describe(e.Found, e.Change.Address), Describe(e.Change.Old, e.Change.Address))
+}
+
+// Describe formats bytes at addr as hex followed by their disassembly.
+func Describe(code []byte, addr uint32) string {
+	var list []string
+	for _, inst := range disassembler.DecodeRange(code, addr) {
+		list = append(list, strings.TrimSpace(inst.Mnemonic+" "+inst.Operands))
+	}
+	return fmt.Sprintf("% X (%s)", code, strings.Join(list, "; "))
+}
+
+// Apply checks every change against image, which is loaded at base, and returns
+// a patched copy. The image itself is not modified.
+func Apply(image []byte, base uint32, changes []Change) ([]byte, error) {
+	out := bytes.Clone(image)
+	for _, c := range changes {
+		if c.Address < base || uint64(c.Address-base)+uint64(len(c.Old)) > uint64(len(image)) {
+			return nil, fmt.Errorf("line %d: $%06X is outside the image", c.Line, c.Address)
+		}
+		off := c.Address - base
+		found := image[off : off+uint32(len(c.Old))]
+		if !bytes.Equal(found, c.Old) {
+			return nil, &MismatchError{Change: c, Found: bytes.Clone(found)}
+		}
+		copy(out[off:], c.New)
+	}
+	return out, nil
+}
+
+// ApplyFile applies an IPS or BPS patch file, recognised by its header, to image.
+func ApplyFile(image, p []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(p, []byte("PATCH")):
+		return ApplyIPS(image, p)
+	case bytes.HasPrefix(p, []byte("BPS1")):
+		return ApplyBPS(image, p)
+	}
+	return nil, fmt.Errorf("unknown patch format; expected IPS or BPS")
+}
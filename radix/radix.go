@@ -0,0 +1,138 @@
+// Package radix holds the number style shared by the disassembler, register
+// dumps, traces and the monitor, so that their output can be made consistent
+// enough to grep.
+package radix
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Style says how hex numbers are written. The zero Style leaves every tool's
+// output as it has always been: "$" in front of operands, bare uppercase hex in
+// address columns and register dumps.
+type Style struct {
+	// Prefix replaces the "$" in front of hex numbers, e.g. "0x". When set,
+	// register dumps get it too.
+	Prefix string
+	// Upper and Lower force the case of hex digits. With neither, each tool
+	// keeps its own.
+	Upper, Lower bool
+	// Decimal shows immediates below this value in decimal, e.g. #10 rather
+	// than #$a. Zero keeps them all in hex.
+	Decimal uint32
+}
+
+// Parse reads a style from a comma-separated list: "$" or "0x" for the
+// prefix, "upper" or "lower" for the case and "dec=N" for the decimal
+// threshold, e.g. "0x,upper,dec=16". An empty string is the zero Style.
+func Parse(spec string) (Style, error) {
+	var s Style
+	if spec == "" {
+		return s, nil
+	}
+	for item := range strings.SplitSeq(spec, ",") {
+		switch item = strings.ToLower(strings.TrimSpace(item)); {
+		case item == "$" || item == "0x":
+			s.Prefix = item
+		case item == "upper":
+			s.Upper, s.Lower = true, false
+		case item == "lower":
+			s.Upper, s.Lower = false, true
+		case strings.HasPrefix(item, "dec="):
+			n, err := strconv.ParseUint(item[4:], 10, 32)
+			if err != nil {
+				return Style{}, fmt.Errorf("invalid decimal threshold %s", item[4:])
+			}
+			s.Decimal = uint32(n)
+		default:
+			return Style{}, fmt.Errorf("unknown number style %q", item)
+		}
+	}
+	return s, nil
+}
+
+// prefix returns the prefix for operands.
+func (s Style) prefix() string {
+	if s.Prefix == "" {
+		return "$"
+	}
+	return s.Prefix
+}
+
+// digits sets the case of hex digits, leaving them alone if neither is forced.
+func (s Style) digits(h string) string {
+	switch {
+	case s.Upper:
+		return strings.ToUpper(h)
+	case s.Lower:
+		return strings.ToLower(h)
+	}
+	return h
+}
+
+// Field formats a register or address column: width hex digits, uppercase
+// unless Lower is set, with the prefix only if one was chosen.
+func (s Style) Field(v uint32, width int) string {
+	h := fmt.Sprintf("%0*X", width, v)
+	if s.Lower {
+		h = strings.ToLower(h)
+	}
+	return s.Prefix + h
+}
+
+// Apply rewrites the "$" hex numbers in text, such as a disassembly listing,
+// in the style. Quoted strings are left alone.
+func (s Style) Apply(text string) string {
+	if s == (Style{}) {
+		return text
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(text))
+	quoted, comment := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\n':
+			quoted, comment = false, false
+		case c == ';' && !quoted:
+			comment = true
+		case c == '\'' && !comment:
+			quoted = !quoted
+		case c == '$' && !quoted && (i == 0 || !isWordChar(text[i-1])):
+			j := i + 1
+			for j < len(text) && isHexDigit(text[j]) {
+				j++
+			}
+			if j == i+1 || j < len(text) && isWordChar(text[j]) {
+				break
+			}
+			sb.WriteString(s.number(text[i+1:j], i > 0 && text[i-1] == '#'))
+			i = j - 1
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// number formats the hex digits of one number, in decimal if it is a small
+// immediate.
+func (s Style) number(h string, immediate bool) string {
+	if immediate && s.Decimal > 0 {
+		if v, err := strconv.ParseUint(h, 16, 32); err == nil && v < uint64(s.Decimal) {
+			return strconv.FormatUint(v, 10)
+		}
+	}
+	return s.prefix() + s.digits(h)
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || (c|0x20) >= 'a' && (c|0x20) <= 'f'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || (c|0x20) >= 'a' && (c|0x20) <= 'z'
+}
@@ -0,0 +1,210 @@
+package assembler_test
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k"
+)
+
+// apiSurface lists the exported names of the package in dir, one per line:
+// functions and methods with their signatures, types with their exported
+// fields, and constants and variables.
+func apiSurface(t *testing.T, dir string) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	var files []*ast.File
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, 0)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", e.Name(), err)
+		}
+		files = append(files, f)
+	}
+	expr := func(n ast.Node) string {
+		var b bytes.Buffer
+		if err := printer.Fprint(&b, fset, n); err != nil {
+			t.Fatalf("failed to print: %v", err)
+		}
+		return strings.Join(strings.Fields(b.String()), " ")
+	}
+	// Parameter names are left out, so renaming one changes nothing.
+	types := func(fl *ast.FieldList) string {
+		var list []string
+		for _, field := range fl.List {
+			for range max(len(field.Names), 1) {
+				list = append(list, expr(field.Type))
+			}
+		}
+		return strings.Join(list, ", ")
+	}
+	signature := func(ft *ast.FuncType) string {
+		sig := "(" + types(ft.Params) + ")"
+		switch {
+		case ft.Results == nil:
+		case len(ft.Results.List) == 1 && len(ft.Results.List[0].Names) == 0:
+			sig += " " + types(ft.Results)
+		default:
+			sig += " (" + types(ft.Results) + ")"
+		}
+		return sig
+	}
+
+	var lines []string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !d.Name.IsExported() {
+					continue
+				}
+				sig := signature(d.Type)
+				if d.Recv != nil {
+					lines = append(lines, "method ("+expr(d.Recv.List[0].Type)+") "+d.Name.Name+sig)
+				} else {
+					lines = append(lines, "func "+d.Name.Name+sig)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if !s.Name.IsExported() {
+							continue
+						}
+						st, ok := s.Type.(*ast.StructType)
+						switch {
+						case s.Assign != 0:
+							lines = append(lines, "type "+s.Name.Name+" = "+expr(s.Type))
+						case ok:
+							lines = append(lines, "type "+s.Name.Name+" struct")
+							for _, field := range st.Fields.List {
+								for _, name := range field.Names {
+									if name.IsExported() {
+										lines = append(lines, "type "+s.Name.Name+" struct, "+name.Name+" "+expr(field.Type))
+									}
+								}
+							}
+						default:
+							lines = append(lines, "type "+s.Name.Name+" "+expr(s.Type))
+						}
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							if name.IsExported() {
+								lines = append(lines, d.Tok.String()+" "+name.Name)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+// TestAPI checks the root package against the v1 API recorded in
+// api/v1.txt. Removing or changing anything listed there breaks the v1
+// guarantee; additions are allowed but must be recorded.
+func TestAPI(t *testing.T) {
+	data, err := os.ReadFile("../api/v1.txt")
+	if err != nil {
+		t.Fatalf("failed to read the API listing: %v", err)
+	}
+	var want []string
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			want = append(want, line)
+		}
+	}
+	got := apiSurface(t, "..")
+	for _, line := range want {
+		if !slices.Contains(got, line) {
+			t.Errorf("removed or changed, breaking the v1 API: %s", line)
+		}
+	}
+	for _, line := range got {
+		if !slices.Contains(want, line) {
+			t.Errorf("not recorded in api/v1.txt: %s", line)
+		}
+	}
+}
+
+// TestStableAPI assembles, runs and disassembles a program through the
+// root package.
+func TestStableAPI(t *testing.T) {
+	p, err := m68k.Assemble(`
+	org	$1000
+start:
+	moveq	#40,d0
+	ori.w	#2,d0
+	trap	#0
+	move.l	d0,result
+	trap	#15
+result:	dc.l	0
+	end	start
+`, m68k.AssembleOptions{})
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	if p.Origin != 0x1000 || p.Entry != 0x1000 || p.Labels["result"] == 0 {
+		t.Fatalf("unexpected program: origin %X entry %X labels %v", p.Origin, p.Entry, p.Labels)
+	}
+
+	m, err := m68k.NewMachine(m68k.MachineConfig{MemorySize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("failed to create the machine: %v", err)
+	}
+	if err := m.Load(p); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	r := m.Registers()
+	r.A[7] = 0x8000
+	m.SetRegisters(r)
+	traps := 0
+	if err := m.OnTrap(0, func(m *m68k.Machine) error {
+		traps++
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to set the trap: %v", err)
+	}
+	reason, err := m.Run(context.Background(), 10000)
+	if err != nil || reason != m68k.Halted {
+		t.Fatalf("expected the program to halt, got %s: %v", reason, err)
+	}
+	b := make([]byte, 4)
+	if err := m.Read(p.Labels["result"], b); err != nil || b[3] != 42 {
+		t.Errorf("expected 42 in memory, got % X: %v", b, err)
+	}
+	if traps != 1 || m.Registers().D[0] != 42 {
+		t.Errorf("expected one TRAP #0 and D0=42, got %d and %d", traps, m.Registers().D[0])
+	}
+
+	mem := make([]byte, 0x1000+len(p.Code))
+	copy(mem[0x1000:], p.Code)
+	inst, ok := m68k.Decode(mem, 0x1002)
+	if !ok || inst.Mnemonic != "ori.w" || inst.Operands != "#2,d0" || inst.Size != 4 {
+		t.Errorf("expected ori.w #2,d0, got %+v", inst)
+	}
+	if _, ok := m68k.Decode([]byte{0xFF, 0xFF}, 0); ok {
+		t.Error("expected $FFFF not to decode")
+	}
+	if _, err := m68k.NewMachine(m68k.MachineConfig{FPU: true}); err == nil {
+		t.Error("expected an FPU to need a 68020")
+	}
+}
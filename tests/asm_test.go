@@ -1,11 +1,19 @@
 package assembler_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
 )
 
 // Assembles source and checks against an expected byte sequence (in hex).
@@ -50,6 +58,11 @@ func TestBasicEncodings(t *testing.T) {
 		{"NOP", "nop", "4E 71"},
 		{"STOP", "stop #$2700", "4E 72 27 00"},
 		{"TRAP1", "trap #1", "4E 41"},
+		{"MOVEM_PreDec", "movem.l d0-d2/a0,-(a7)", "48 E7 E0 80"},
+		{"MOVEM_PostInc_Single", "movem.l (a7)+,d0", "4C DF 00 01"},
+		{"CMPI_Long", "cmpi.l #-1,d0", "0C 80 FF FF FF FF"},
+		{"ANDI_Long", "andi.l #1,d3", "02 83 00 00 00 01"},
+		{"CMPM", "cmpm.b (a0)+,(a1)+", "B3 08"},
 	}
 	for _, tc := range tests {
 		assembleAndMatchHex(t, tc.name, tc.src, tc.hex)
@@ -112,6 +125,7 @@ func TestAddressingModes_Encodings(t *testing.T) {
 		{"Addr_Index", "move.w 8(a0,d1.w),d4", "38 30 10 08"},
 		{"PC_Relative", "move.w label(pc),d5\nlabel: dc.w $1234", "3A 3A 00 02 12 34"},
 		{"Immediate", "move.w #$ABCD,d6", "3C 3C AB CD"},
+		{"Immediate_Byte", "move.b #14,d0", "10 3C 00 0E"},
 		{"Absolute_Short", "move.w ($1234).w,d7", "3E 38 12 34"},
 		{"Absolute_Long", "move.l ($123456).l,d0", "20 39 00 12 34 56"},
 	}
@@ -140,10 +154,13 @@ func TestFlowControl_Encodings(t *testing.T) {
 	tests := []struct {
 		name, src, hex string
 	}{
-		{"BRA_Short", "bra.s label\nlabel: nop", "60 00 4E 71"},
-		{"BNE_Short", "bne.s label\nlabel: nop", "66 00 4E 71"},
-		{"BEQ_Short", "beq.s label\nlabel: nop", "67 00 4E 71"},
-		{"BSR_Short", "bsr.s label\nlabel: nop", "61 00 4E 71"},
+		{"BRA_Short", "bra.s label\nnop\nlabel: nop", "60 02 4E 71 4E 71"},
+		{"BNE_Short", "bne.s label\nnop\nlabel: nop", "66 02 4E 71 4E 71"},
+		{"BEQ_Short", "beq.s label\nnop\nlabel: nop", "67 02 4E 71 4E 71"},
+		{"BSR_Short", "bsr.s label\nnop\nlabel: nop", "61 02 4E 71 4E 71"},
+		{"BRA_Next", "bra label\nlabel: nop", "60 00 00 02 4E 71"},
+		{"BRA_Address", "bra $1010", "60 0E"},
+		{"DBRA_Address", "dbra d0,$1010", "51 C8 00 0E"},
 		{"JSR_AbsLong", "jsr $E.l", "4E B9 00 00 00 0E"},
 		{"JMP_Indirect", "jmp (a0)", "4E D0"},
 		{"RTE", "rte", "4E 73"},
@@ -152,6 +169,9 @@ func TestFlowControl_Encodings(t *testing.T) {
 	for _, tc := range tests {
 		assembleAndMatchHex(t, tc.name, tc.src, tc.hex)
 	}
+	if _, err := assembler.New().Assemble("bra.s label\nlabel: nop", 0); err == nil {
+		t.Error("expected a short branch to the next instruction to fail")
+	}
 }
 
 // TestCombinedCodeAndData checks a realistic mixed code and data scenario.
@@ -177,8 +197,9 @@ string3:
     dc.b 'Copyright (C) 2025',$00
     dc.b $00
 `
+	// The forward reference in the LEA is sized for the worst case (absolute long).
 	expected := `
-41 FA 00 10 70 0D 4E B9 00 00 10 0E 4E 75 4E 71
+41 F9 00 00 10 14 70 0D 4E B9 00 00 10 10 4E 75 4E 71
 4E 75 54 68 69 73 20 69 73 20 61 20 74 65 73 74
 20 73 74 72 69 6E 67 2E 00 00 00 DE AD BE EF 00
 56 45 52 31 00 00 00 00 41 42 43 00 00 00 43
@@ -188,3 +209,647 @@ string3:
 
 	assembleAndMatchHex(t, "CombinedCodeAndData", src, expected)
 }
+
+// TestSizingWarnings checks that worst-case forward references are reported.
+func TestSizingWarnings(t *testing.T) {
+	src := `
+start:
+    bra done
+    lea data,a0
+    bra.w done
+data:
+    dc.w 1
+done:
+    rts
+`
+	asm := assembler.New()
+	asm.WarnSizing = true
+	if _, err := asm.Assemble(src, 0x1000); err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	st := asm.SizingStats()
+	if st.Count != 2 || st.Bytes != 4 {
+		t.Errorf("expected 2 missed optimizations (4 bytes), got %d (%d bytes)", st.Count, st.Bytes)
+	}
+
+	w := asm.Warnings()
+	if len(w) != 2 || w[0].Line != 3 || w[1].Line != 4 {
+		t.Errorf("unexpected warnings: %v", w)
+	}
+}
+
+// TestLabelAddressing checks the global label mode and the per-operand overrides.
+func TestLabelAddressing(t *testing.T) {
+	src := `
+start:
+    lea data,a0
+    move.w data.l,d0
+    jsr start
+    lea start,a1
+data:
+    dc.w 1
+`
+	tests := []struct {
+		mode string
+		hex  string
+	}{
+		{"auto", "41 F9 00 00 10 16 30 39 00 00 10 16 4E B9 00 00 10 00 43 FA FF EC 00 01"},
+		{"pc", "41 FA 00 10 30 39 00 00 10 12 4E BA FF F4 43 FA FF F0 00 01"},
+		{"abs", "41 F9 00 00 10 18 30 39 00 00 10 18 4E B9 00 00 10 00 43 F9 00 00 10 00 00 01"},
+	}
+	for _, tc := range tests {
+		mode, err := assembler.ParseLabelAddressing(tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		asm := assembler.New()
+		asm.LabelMode = mode
+		code, err := asm.Assemble(src, 0x1000)
+		if err != nil {
+			t.Fatalf("[%s] failed to assemble: %v", tc.mode, err)
+		}
+		expected, _ := hex.DecodeString(strings.Join(strings.Fields(tc.hex), ""))
+		if string(code) != string(expected) {
+			t.Errorf("[%s] expected % X, got % X", tc.mode, expected, code)
+		}
+	}
+}
+
+// TestCustomDirective checks a host-registered directive, including a forward label.
+func TestCustomDirective(t *testing.T) {
+	asm := assembler.New()
+	// row turns strings of '#' and '.' into one byte per 8 pixels.
+	err := asm.RegisterDirective("row", func(ctx *assembler.DirectiveContext) ([]byte, error) {
+		var out []byte
+		for _, a := range ctx.Args {
+			var b byte
+			for i, c := range strings.Trim(a, "\"") {
+				if c == '#' {
+					b |= 0x80 >> (i % 8)
+				}
+			}
+			out = append(out, b)
+		}
+		return out, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = asm.RegisterDirective(".ptr", func(ctx *assembler.DirectiveContext) ([]byte, error) {
+		v, err := ctx.Value(ctx.Args[0])
+		return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := asm.RegisterDirective("dc.b", nil); err == nil {
+		t.Error("expected an error replacing a built-in directive")
+	}
+
+	src := `
+    .ptr sprite
+    ROW "#......#"
+sprite:
+    row "##......","......##"
+    nop
+`
+	code, err := asm.Assemble(src, 0x1000)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	expected := []byte{0x00, 0x00, 0x10, 0x06, 0x81, 0x00, 0xC0, 0x03, 0x4E, 0x71}
+	if string(code) != string(expected) {
+		t.Errorf("expected % X, got % X", expected, code)
+	}
+}
+
+// TestSymbolReport checks values, definition lines and usage lines in the symbol report.
+func TestSymbolReport(t *testing.T) {
+	src := `COUNT equ 16
+start:
+    moveq #COUNT,d0
+    dc.b 'COUNT',0
+    dc.w COUNT,$C0
+`
+	asm := assembler.New()
+	if _, err := asm.Assemble(src, 0x1000); err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	var sb strings.Builder
+	if err := asm.WriteSymbolReport(&sb); err != nil {
+		t.Fatal(err)
+	}
+	report := sb.String()
+	for _, want := range []string{
+		"count                  00000010  equ    1        3, 5\n",
+		"start                  00001000  label  2        -\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report is missing %q:\n%s", want, report)
+		}
+	}
+}
+
+// TestEncodings checks the record of how each line was encoded.
+func TestEncodings(t *testing.T) {
+	src := `start:
+	move.l	#1,d0
+	add.l	#4,d1
+	lea	data,a0
+	lea	start,a1
+	bne	start
+	bra	later
+	dc.w	1,2
+later:
+	rts
+data:
+	dc.l	0
+`
+	asm := assembler.New()
+	if _, err := asm.Assemble(src, 0); err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	enc := asm.Encodings()
+	if len(enc) != 9 {
+		t.Fatalf("expected 9 records, got %d", len(enc))
+	}
+	checks := []struct {
+		i             int
+		hex           string
+		modes         []string
+		branch        string
+		optimizations []string
+		missed        bool
+	}{
+		{0, "7001", []string{"#imm", "Dn"}, "", []string{"moveq"}, false},
+		{1, "5881", []string{"#imm", "Dn"}, "", []string{"addq"}, false},
+		{2, "41F9 0000 001A", []string{"abs.l", "An"}, "", nil, true},
+		{3, "43FA FFF4", []string{"(d16,PC)", "An"}, "", []string{"pc-relative label"}, false},
+		{4, "66F0", []string{"label"}, "short", []string{"short branch"}, false},
+		{5, "6000 0006", []string{"label"}, "word", nil, true},
+	}
+	for _, c := range checks {
+		e := enc[c.i]
+		if e.Hex != c.hex || !slices.Equal(e.Modes, c.modes) || e.Branch != c.branch || !slices.Equal(e.Optimizations, c.optimizations) || (len(e.Missed) > 0) != c.missed {
+			t.Errorf("line %d (%s): unexpected record %+v", e.Line, e.Source, e)
+		}
+	}
+	if e := enc[6]; e.Source != "dc.w 1,2" || e.Size != 4 || e.Words != nil {
+		t.Errorf("expected a record for the data, got %+v", e)
+	}
+
+	var buf bytes.Buffer
+	if err := asm.WriteEncodings(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"opcode": 28673`) {
+		t.Errorf("expected the MOVEQ opcode in the JSON:\n%s", buf.String())
+	}
+}
+
+// TestIncludeLibrary assembles a program built on the standard include files
+// and checks that a local include directory takes precedence.
+func TestIncludeLibrary(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "local.i"), []byte("VALUE equ 42\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := `
+STACK_TOP equ $8000
+    include "vectors.i"
+    include 'local.i'
+start:
+    moveq #VALUE,d0
+    jsr sys_exit
+    include "exceptions.i"
+    include "system.i"
+`
+	asm := assembler.New()
+	asm.IncludeDirs = []string{dir}
+	code, err := asm.Assemble(src, 0)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	if len(code) < 0x104 {
+		t.Fatalf("expected a 256-byte vector table before the code, got %d bytes", len(code))
+	}
+	if sp := binary.BigEndian.Uint32(code[0:]); sp != 0x8000 {
+		t.Errorf("expected initial SSP $8000, got $%08X", sp)
+	}
+	if pc := binary.BigEndian.Uint32(code[4:]); pc != 0x100 {
+		t.Errorf("expected reset PC $100, got $%08X", pc)
+	}
+	if got := binary.BigEndian.Uint16(code[0x100:]); got != 0x702A {
+		t.Errorf("expected moveq #42,d0 at $100, got %04X", got)
+	}
+
+	_, err = assembler.New().Assemble(`include "missing.i"`, 0)
+	if err == nil || !strings.Contains(err.Error(), "missing.i") {
+		t.Errorf("expected a missing include error, got %v", err)
+	}
+}
+
+// TestRuntimeLibrary checks that the runtime routines assemble alongside a caller.
+func TestRuntimeLibrary(t *testing.T) {
+	src := `
+start:
+    bsr memcpy
+    bsr itoa
+    trap #15
+    include "runtime.i"
+`
+	asm := assembler.New()
+	code, err := asm.Assemble(src, 0)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	var sb strings.Builder
+	if err := asm.WriteSymbolReport(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"memcpy", "memset", "strcmp", "divmod32", "itoa", "utoa"} {
+		if !strings.Contains(sb.String(), "\n  "+name+" ") {
+			t.Errorf("runtime.i does not define %s", name)
+		}
+	}
+	// memcpy starts right after the caller with tst.l d0.
+	if got := binary.BigEndian.Uint16(code[10:]); got != 0x4A80 {
+		t.Errorf("expected tst.l d0 at memcpy, got %04X", got)
+	}
+}
+
+// TestMaxSize checks the MAXSIZE directive, the MaxSize override and the section sizes.
+func TestMaxSize(t *testing.T) {
+	src := `
+    maxsize 8
+start:
+    moveq #1,d0
+    nop
+table:
+    dc.l 1,2
+`
+	asm := assembler.New()
+	_, err := asm.Assemble(src, 0)
+	if !errors.Is(err, assembler.ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "12 bytes is 4 over the limit of 8 (largest sections: table 8, start 4)") {
+		t.Errorf("unexpected message: %v", err)
+	}
+
+	want := []assembler.Section{{Name: "start", Start: 0, Size: 4}, {Name: "table", Start: 4, Size: 8}}
+	if got := asm.Sections(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected sections %+v, got %+v", want, got)
+	}
+
+	asm.MaxSize = 16
+	if _, err := asm.Assemble(src, 0); err != nil {
+		t.Errorf("MaxSize should override MAXSIZE: %v", err)
+	}
+}
+
+func TestBanks(t *testing.T) {
+	src := `
+start:
+    bsr.s shared
+    bra.s start
+shared:
+    bank 0
+entry0:
+    moveq #1,d0
+    rts
+    bank 1
+entry1:
+    moveq #2,d0
+    nop
+    rts
+    endbank
+after:
+    nop
+`
+	asm := assembler.New()
+	code, err := asm.Assemble(src, 0)
+	if err != nil {
+		t.Fatalf("assembly failed: %v", err)
+	}
+
+	// The main output skips the window, which is as long as the largest bank.
+	want := []byte{0x61, 0x02, 0x60, 0xFC, 0, 0, 0, 0, 0, 0, 0x4E, 0x71}
+	if !bytes.Equal(code, want) {
+		t.Errorf("main output: expected % X, got % X", want, code)
+	}
+
+	banks := asm.Banks()
+	if len(banks) != 2 {
+		t.Fatalf("expected 2 banks, got %d", len(banks))
+	}
+	if banks[0].Number != 0 || banks[0].Start != 4 || !bytes.Equal(banks[0].Data, []byte{0x70, 0x01, 0x4E, 0x75}) {
+		t.Errorf("unexpected bank 0: %+v", banks[0])
+	}
+	if banks[1].Number != 1 || banks[1].Start != 4 || !bytes.Equal(banks[1].Data, []byte{0x70, 0x02, 0x4E, 0x71, 0x4E, 0x75}) {
+		t.Errorf("unexpected bank 1: %+v", banks[1])
+	}
+
+	var sb strings.Builder
+	if err := asm.WriteBankTable(&sb); err != nil {
+		t.Fatalf("WriteBankTable failed: %v", err)
+	}
+	for _, line := range []string{"0      00000004  4", "1      00000004  6", "entry1                 1      00000004"} {
+		if !strings.Contains(sb.String(), line) {
+			t.Errorf("bank table is missing %q:\n%s", line, sb.String())
+		}
+	}
+	if strings.Contains(sb.String(), "after") {
+		t.Errorf("bank table should not list main labels:\n%s", sb.String())
+	}
+
+	for _, bad := range []string{"bank 0\nbank 0\nendbank", "bank 0\nnop", "endbank"} {
+		if _, err := assembler.New().Assemble(bad, 0); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	pass := []string{
+		"*-start == 4",
+		"end-start = 4",
+		"(1 << 4) | 1 == $11",
+		"-2 * 3 + 7 == %1",
+		"10 % 4 == 2 && !(3 < 2)",
+		"~0 & $FF == 255",
+		"LIMIT >= * || 0",
+		"'A' + 1 == 66",
+		"4 <> 5",
+	}
+	for _, expr := range pass {
+		src := "LIMIT equ 512\nstart:\n moveq #1,d0\n nop\n assert " + expr + "\nend:\n"
+		if _, err := assembler.New().Assemble(src, 0); err != nil {
+			t.Errorf("assert %s: %v", expr, err)
+		}
+	}
+
+	_, err := assembler.New().Assemble("start:\n ds.b 600\n assert *-start <= 512, \"boot block too big\"\n", 0)
+	if !errors.Is(err, assembler.ErrAssert) || !strings.Contains(err.Error(), "line 3: assertion failed: boot block too big") {
+		t.Errorf("unexpected error for a failed assertion: %v", err)
+	}
+
+	for _, bad := range []string{"assert missing == 1", "assert 1 +", "assert (1", "assert 1/0"} {
+		if _, err := assembler.New().Assemble(bad, 0); err == nil || errors.Is(err, assembler.ErrAssert) {
+			t.Errorf("expected an evaluation error for %q, got %v", bad, err)
+		}
+	}
+}
+
+// TestPatchPoints reserves NOP slides with PATCH and at called routines.
+func TestPatchPoints(t *testing.T) {
+	asm := assembler.New()
+	asm.PatchPad = 2
+	code, err := asm.Assemble(`
+start:
+	bsr	func
+	jsr	func.l
+	trap	#15
+func:
+	moveq	#1,d0
+	rts
+hook:
+	patch
+	rts
+`, 0x400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []assembler.PatchPoint{
+		{Address: 0x40C, Size: 4, Label: "func", Line: 6},
+		{Address: 0x414, Size: 6, Label: "hook", Line: 10},
+	}
+	got := asm.PatchPoints()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d patch points, got %v", len(want), got)
+	}
+	for i, p := range got {
+		if p != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], p)
+		}
+		for a := p.Address; a < p.Address+p.Size; a += 2 {
+			if op := uint16(code[a-0x400])<<8 | uint16(code[a-0x3FF]); op != 0x4E71 {
+				t.Errorf("expected NOP at $%X, got %04X", a, op)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if err := asm.WriteMap(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "; Patch points\n0000040C  4 bytes  func\n00000414  6 bytes  hook\n") {
+		t.Errorf("patch points missing from map file:\n%s", sb.String())
+	}
+
+	c := runProgram(t, "\tbsr\tf\n\ttrap\t#15\nf:\tpatch 4\n\tmoveq\t#5,d0\n\trts\n")
+	if c.D[0] != 5 {
+		t.Errorf("expected the slide to run through, got D0=%d", c.D[0])
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble("\torg\t$2000\nstart:\tnop\n\ttrap\t#15\n\tend\tstart\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := asm.Program()
+	if !bytes.Equal(p.Code, code) || p.Origin != 0x2000 || !p.HasEntry || p.Entry != 0x2000 || p.Labels["start"] != 0x2000 {
+		t.Errorf("unexpected program %+v", p)
+	}
+
+	raw, err := assembler.LookupOutputFormat("RAW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := raw.Write(p, &buf); err != nil || !bytes.Equal(buf.Bytes(), code) {
+		t.Errorf("expected raw output %X, got %X (%v)", code, buf.Bytes(), err)
+	}
+
+	// A format of our own: the origin as a long, then the code.
+	const name = "test-load"
+	if _, err := assembler.LookupOutputFormat(name); err != nil {
+		err := assembler.RegisterOutputFormat(name, assembler.OutputFormatFunc(func(p *assembler.Program, w io.Writer) error {
+			if err := binary.Write(w, binary.BigEndian, p.Origin); err != nil {
+				return err
+			}
+			_, err := w.Write(p.Code)
+			return err
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Contains(assembler.OutputFormats(), name) {
+		t.Errorf("expected %s among %v", name, assembler.OutputFormats())
+	}
+	f, err := assembler.LookupOutputFormat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := f.Write(p, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte{0, 0, 0x20, 0}, code...); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("expected %X, got %X", want, buf.Bytes())
+	}
+
+	if err := assembler.RegisterOutputFormat("raw", f); err == nil {
+		t.Error("expected an error replacing the raw format")
+	}
+	if _, err := assembler.LookupOutputFormat("nonesuch"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// TestTargetInstructions checks MOVE16 and the FPU subset, which need a
+// 68040 or 68060 target or, for the FPU, a 68020 with FPU on, and the
+// instructions that need a 68010.
+func TestTargetInstructions(t *testing.T) {
+	tests := []struct {
+		name, src, hex string
+	}{
+		{"MOVE16_PostInc", "move16 (a0)+,(a1)+", "F6 20 90 00"},
+		{"MOVE16_Abs", "move16 (a2)+,$1000", "F6 02 00 00 10 00"},
+		{"FADD_Registers", "fadd.x fp1,fp0", "F2 00 04 22"},
+		{"FMOVE_FromData", "fmove.l d0,fp1", "F2 00 40 80"},
+		{"FMOVE_Single", "fmove.s #1.5,fp0", "F2 3C 44 00 3F C0 00 00"},
+		{"FABS_Monadic", "fabs fp2", "F2 00 09 18"},
+		{"FMOVE_Control", "fmove.l fpsr,d0", "F2 00 A8 00"},
+		{"FMOVEM_PreDec", "fmovem.x fp0-fp2/fp7,-(a7)", "F2 27 E0 87"},
+		{"FNOP", "fnop", "F2 80 00 00"},
+	}
+	for _, tc := range tests {
+		assembleAndMatchHex(t, tc.name, "\tmachine 68040\n\t"+tc.src, tc.hex)
+	}
+
+	if _, err := assembler.New().Assemble("move16 (a0)+,(a1)+", 0); err == nil {
+		t.Error("expected MOVE16 to need a 68040 target")
+	}
+	asm := assembler.New()
+	asm.Target = cpu.MC68060
+	if _, err := asm.Assemble("fnop", 0); err != nil {
+		t.Errorf("expected the 68060 target to allow FNOP: %v", err)
+	}
+	assembleAndMatchHex(t, "FPU_68020", "\tmachine 68020\n\tfpu\n\tfmove.p fp0,(a0){#17}", "F2 10 6C 11")
+	for _, src := range []string{"\tmachine 68020\n\tfadd fp1,fp0", "\tmachine 68020\n\tfpu 68881\n\tfsadd fp1,fp0", "\tfpu\n\tfnop"} {
+		if _, err := assembler.New().Assemble(src, 0); err == nil {
+			t.Errorf("expected %q to be rejected", src)
+		}
+	}
+	asm = assembler.New()
+	asm.Target, asm.FPU = cpu.MC68020, true
+	if _, err := asm.Assemble("fnop", 0); err != nil {
+		t.Errorf("expected a 68020 with FPU to allow FNOP: %v", err)
+	}
+
+	tests = []struct {
+		name, src, hex string
+	}{
+		{"MOVEC_FromVBR", "movec vbr,d0", "4E 7A 08 01"},
+		{"MOVEC_ToVBR", "movec a1,vbr", "4E 7B 98 01"},
+		{"MOVEC_USP", "movec usp,a0", "4E 7A 88 00"},
+		{"MOVES_Store", "moves.l d0,(a0)", "0E 90 08 00"},
+		{"MOVES_Load", "moves.w 4(a1),a2", "0E 69 A0 00 00 04"},
+		{"RTD", "rtd #8", "4E 74 00 08"},
+		{"MOVE_FromCCR", "move ccr,d1", "42 C1"},
+	}
+	for _, tc := range tests {
+		assembleAndMatchHex(t, tc.name, "\tmachine 68010\n\t"+tc.src, tc.hex)
+	}
+	for _, src := range []string{"movec vbr,d0", "rtd #4", "move ccr,d0"} {
+		if _, err := assembler.New().Assemble(src, 0); err == nil {
+			t.Errorf("expected %q to need a 68010 target", src)
+		}
+	}
+	if _, err := assembler.New().Assemble("\tmachine 68010\n\tmoves.w d0,d1", 0); err == nil {
+		t.Error("expected MOVES to reject a register operand")
+	}
+}
+
+// TestAssemble68020Addressing covers scaled indexes, the full extension word and
+// memory indirect modes, and their gating below a 68020 target.
+func TestAssemble68020Addressing(t *testing.T) {
+	tests := []struct {
+		name, src, hex string
+	}{
+		{"Scaled", "move.w 4(a0,d1.w*4),d0", "30 30 14 04"},
+		{"ScaledInside", "move.w (4,a0,d1.l*2),d0", "30 30 1A 04"},
+		{"WordBase", "move.w ($1000,a0,d1.w),d0", "30 30 11 20 10 00"},
+		{"PostIndexed", "move.w ([4,a0],d1.l*4,8),d0", "30 30 1D 26 00 04 00 08"},
+		{"PreIndexed", "move.w ([4,a0,d1.l*4],$12345),d0", "30 30 1D 23 00 04 00 01 23 45"},
+		{"Indirect", "move.w ([a0]),d0", "30 30 01 51"},
+		{"SuppressedBase", "move.w ($100000.l,za0),d0", "30 30 01 F0 00 10 00 00"},
+		{"PCScaled", "lea (8,pc,d0.w*8),a1", "43 FB 06 08"},
+		{"PCIndirect", "lea ([$10,pc],a2.l,4),a1", "43 FB A9 26 00 10 00 04"},
+		{"PlainDisplacement", "move.w (6,a3),d0", "30 2B 00 06"},
+		{"LoneIndex", "move.l d2,(d3.l*4)", "21 82 3D 90"},
+	}
+	for _, tc := range tests {
+		assembleAndMatchHex(t, tc.name, "\tmachine 68020\n\t"+tc.src, tc.hex)
+	}
+	for _, src := range []string{"move.w 4(a0,d1.w*4),d0", "move.w ([a0]),d0", "lea (8,pc,d0.w*2),a1"} {
+		if _, err := assembler.New().Assemble(src, 0); err == nil {
+			t.Errorf("expected %q to need a 68020 target", src)
+		}
+	}
+	if _, err := assembler.New().Assemble("move.w 4(a0,d1.w*1),d0", 0); err != nil {
+		t.Errorf("expected a scale of 1 to assemble for a 68000: %v", err)
+	}
+}
+
+// TestAssemble68020Instructions covers the instructions the 68020 added, and
+// their gating below a 68020 target.
+func TestAssemble68020Instructions(t *testing.T) {
+	tests := []struct {
+		name, src, hex string
+	}{
+		{"EXTB", "extb.l d3", "49 C3"},
+		{"MULU_L", "mulu.l (a0),d1", "4C 10 10 00"},
+		{"MULS_Quad", "muls.l d0,d2:d1", "4C 00 1C 02"},
+		{"DIVU_L", "divu.l #10,d3", "4C 7C 30 03 00 00 00 0A"},
+		{"DIVS_Quad", "divs.l d0,d4:d3", "4C 40 3C 04"},
+		{"DIVUL", "divul.l d0,d4:d3", "4C 40 30 04"},
+		{"CAS", "cas.l d0,d1,(a0)", "0E D0 00 40"},
+		{"CAS_Default", "cas d2,d3,4(a1)", "0C E9 00 C2 00 04"},
+		{"CAS2", "cas2.w d0:d1,d2:d3,(a0):(d4)", "0C FC 80 80 40 C1"},
+		{"PACK", "pack d0,d1,#$3030", "83 40 30 30"},
+		{"UNPK", "unpk -(a0),-(a1),#0", "83 88 00 00"},
+		{"TRAPcc", "trapeq", "57 FC"},
+		{"TRAPcc_W", "trapne.w #1", "56 FA 00 01"},
+		{"TRAPcc_L", "trapgt.l #$12345", "5E FB 00 01 23 45"},
+		{"BFEXTU", "bfextu d0{4:8},d1", "E9 C0 11 08"},
+		{"BFINS", "bfins d1,(a0){d2:d3}", "EF D0 18 A3"},
+		{"BFTST", "bftst 4(a0){0:32}", "E8 E8 00 00 00 04"},
+		{"BFFFO", "bfffo (a2){d1:5},d7", "ED D2 78 45"},
+		{"BFSET", "bfset (a0){3:4}", "EE D0 00 C4"},
+	}
+	for _, tc := range tests {
+		assembleAndMatchHex(t, tc.name, "\tmachine 68020\n\t"+tc.src, tc.hex)
+	}
+	for _, src := range []string{"extb.l d0", "muls.l d0,d1", "bftst (a0){0:8}", "trapeq"} {
+		if _, err := assembler.New().Assemble(src, 0); err == nil {
+			t.Errorf("expected %q to need a 68020 target", src)
+		}
+	}
+	for _, src := range []string{"bfset d0", "bfclr (pc){0:8}", "bftst (a0){0:33}", "cas.l d0,d1,d2", "pack d0,-(a1),#0"} {
+		if _, err := assembler.New().Assemble("\tmachine 68020\n\t"+src, 0); err == nil {
+			t.Errorf("expected %q to fail", src)
+		}
+	}
+}
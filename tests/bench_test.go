@@ -0,0 +1,45 @@
+package assembler_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k/assembler"
+)
+
+// largeSource builds a program of roughly n lines mixing backward and forward
+// references, data and plain instructions.
+func largeSource(n int) string {
+	var sb strings.Builder
+	sb.WriteString("org $1000\n")
+	for i := 0; i < n/10; i++ {
+		fmt.Fprintf(&sb, "blk%d:\n", i)
+		sb.WriteString("    move.l d0,d1\n")
+		sb.WriteString("    add.w #$10,d2\n")
+		fmt.Fprintf(&sb, "    lea blk%d,a0\n", i)
+		fmt.Fprintf(&sb, "    beq blk%d\n", i+1)
+		fmt.Fprintf(&sb, "    bne.s blk%d\n", i)
+		sb.WriteString("    move.w (a0)+,d3\n")
+		fmt.Fprintf(&sb, "    dbra d3,blk%d\n", i)
+		sb.WriteString("    dc.w $1234,$5678\n")
+		sb.WriteString("    nop\n")
+	}
+	fmt.Fprintf(&sb, "blk%d:\n    rts\n", n/10)
+	return sb.String()
+}
+
+func benchmarkAssemble(b *testing.B, lines int) {
+	src := largeSource(lines)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		asm := assembler.New()
+		if _, err := asm.Assemble(src, 0x1000); err != nil {
+			b.Fatalf("assembly failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkAssemble5k(b *testing.B)  { benchmarkAssemble(b, 5000) }
+func BenchmarkAssemble50k(b *testing.B) { benchmarkAssemble(b, 50000) }
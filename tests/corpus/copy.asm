@@ -0,0 +1,27 @@
+; Copies a string into RAM and counts its length.
+; The reference copy.bin was assembled by hand from the MC68000 manual.
+
+	org	$1000
+start:
+	lea	src(pc),a0
+	movea.l	#$2000,a1
+	moveq	#5,d0
+loop:
+	move.b	(a0)+,(a1)+
+	dbra	d0,loop
+	bsr.s	count
+	rts
+
+; count returns the length of the string at a1 in d0.
+count:
+	moveq	#0,d0
+count_next:
+	tst.b	(a1)+
+	beq.s	count_done
+	addq.l	#1,d0
+	bra.s	count_next
+count_done:
+	rts
+
+src:
+	dc.b	'hello',0
@@ -0,0 +1,29 @@
+; Fills a 64-entry colour table from a sine table and advances the phase, as
+; copper-bar and plasma effects do once a frame. Written for this corpus and
+; placed in the public domain. The reference effect.bin was assembled by hand
+; from the MC68000 manual.
+
+	org	$2000
+frame:
+	lea	sine(pc),a0
+	lea	$10000,a1
+	move.w	phase(pc),d0
+	moveq	#63,d7
+fill:
+	move.w	d0,d1
+	andi.w	#15,d1
+	move.b	(a0,d1.w),d2
+	ext.w	d2
+	asl.w	#2,d2
+	addi.w	#$0100,d2
+	move.w	d2,(a1)+
+	addq.w	#1,d0
+	dbra	d7,fill
+	lea	phase(pc),a0
+	addq.w	#1,(a0)
+	rts
+
+phase:
+	dc.w	0
+sine:
+	dc.b	0,49,90,117,127,117,90,49,0,-49,-90,-117,-127,-117,-90,-49
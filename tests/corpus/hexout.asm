@@ -0,0 +1,21 @@
+; Prints d0 as eight hex digits to a serial data register, the way monitor
+; ROMs such as TUTOR print addresses. Written for this corpus and placed in
+; the public domain. The reference hexout.bin was assembled by hand from the
+; MC68000 manual.
+
+	org	$400
+hex8:
+	movem.l	d0-d2/a0,-(a7)
+	moveq	#7,d2
+	lea	digits(pc),a0
+hex8_next:
+	rol.l	#4,d0
+	move.l	d0,d1
+	andi.w	#$000F,d1
+	move.b	0(a0,d1.w),$FF0000
+	dbra	d2,hex8_next
+	movem.l	(a7)+,d0-d2/a0
+	rts
+
+digits:
+	dc.b	'0123456789ABCDEF'
@@ -0,0 +1,65 @@
+package assembler_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k/assembler"
+)
+
+// TestCorpus assembles the sources in tests/corpus and compares them with
+// reference binaries built without asm68, by hand or by another assembler.
+func TestCorpus(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("corpus", "*.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) == 0 {
+		t.Skip("no corpus sources")
+	}
+	for _, fn := range sources {
+		name := strings.TrimSuffix(filepath.Base(fn), ".asm")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(fn, ".asm") + ".bin")
+			if err != nil {
+				t.Fatalf("missing reference: %v", err)
+			}
+			asm := assembler.New()
+			asm.IncludeDirs = []string{"corpus"}
+			got, err := asm.Assemble(string(src), 0)
+			if err != nil {
+				t.Fatalf("failed to assemble: %v", err)
+			}
+			if i := firstDifference(got, want); i >= 0 {
+				t.Errorf("output differs from the reference at offset $%X: got % X, want % X",
+					i, window(got, i), window(want, i))
+			}
+		})
+	}
+}
+
+// firstDifference returns the offset of the first byte where a and b differ,
+// or -1 if they are the same.
+func firstDifference(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
+
+// window returns up to eight bytes of b from off, for error messages.
+func window(b []byte, off int) []byte {
+	return b[min(off, len(b)):min(off+8, len(b))]
+}
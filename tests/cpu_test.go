@@ -0,0 +1,2194 @@
+package assembler_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// runProgram assembles src at address 0 and runs it until it halts with TRAP #15.
+func runProgram(t *testing.T, src string) *cpu.CPU {
+	t.Helper()
+	code, err := assembler.New().Assemble(src, 0)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	c := cpu.New(0x1000, 0)
+	copy(c.Mem, code)
+	c.A[7] = 0x1000
+	c.Running = true
+	for steps := 0; c.Running; steps++ {
+		if steps == 100000 {
+			t.Fatalf("program did not halt, PC=%08X", c.PC)
+		}
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed at PC=%08X: %v", c.PC, err)
+		}
+	}
+	return c
+}
+
+// TestInstructionCache checks hit counting and invalidation of rewritten code.
+func TestInstructionCache(t *testing.T) {
+	c := cpu.New(0x100, 16)
+	c.WriteU16(0, 0x7001) // moveq #1,d0
+	c.Running = true
+
+	for i := 0; i < 3; i++ {
+		c.PC = 0
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execute failed: %v", err)
+		}
+	}
+
+	s := c.CacheStats()
+	if s.Misses != 1 || s.Hits != 2 || s.Entries != 1 {
+		t.Errorf("unexpected stats after warm-up: %+v", s)
+	}
+
+	// Self-modifying code: the cached decode must not be reused.
+	c.WriteU16(0, 0x7002) // moveq #2,d0
+	c.PC = 0
+	if err := c.Execute(); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if c.D[0] != 2 {
+		t.Errorf("expected D0=2 after rewrite, got %d", c.D[0])
+	}
+	if s = c.CacheStats(); s.Invalidations != 1 {
+		t.Errorf("expected 1 invalidation, got %d", s.Invalidations)
+	}
+
+	c.FlushCache()
+	if s = c.CacheStats(); s.Entries != 0 || s.Capacity != 16 {
+		t.Errorf("unexpected stats after flush: %+v", s)
+	}
+}
+
+// TestSRString checks the decoded status register helpers.
+func TestSRString(t *testing.T) {
+	sr := cpu.SR(0x2704)
+	if !sr.Supervisor() || sr.Trace() || sr.IntMask() != 7 {
+		t.Errorf("unexpected decode of %04X", uint16(sr))
+	}
+	if got := sr.FlagString(); got != "--Z--" {
+		t.Errorf("expected --Z--, got %s", got)
+	}
+	if got := cpu.SR(0x8019).String(); got != "8019 T U I0 XN--C" {
+		t.Errorf("unexpected String(): %s", got)
+	}
+}
+
+// TestLogicalOps runs AND, OR, EOR and NOT in their register, immediate and memory forms.
+func TestLogicalOps(t *testing.T) {
+	c := runProgram(t, `
+	moveq	#$0F,d0
+	moveq	#$3C,d1
+	and.b	d1,d0
+	or.w	#$1200,d0
+	eor.l	d0,d1
+	not.b	d1
+	andi.b	#$F0,d1
+	ori.l	#$80000000,d2
+	eori	#$04,ccr
+	move.l	#$200,a0
+	move.l	#$FF00FF00,(a0)
+	not.w	(a0)
+	and.l	(a0),d0
+	trap	#15
+`)
+	if c.D[1] != 0x12C0 || c.D[2] != 0x80000000 {
+		t.Errorf("unexpected D1=%08X D2=%08X", c.D[1], c.D[2])
+	}
+	if got := c.ReadU32(0x200); got != 0x00FFFF00 {
+		t.Errorf("expected NOT.W to leave $00FFFF00 in memory, got $%08X", got)
+	}
+	if c.D[0] != 0x1200 {
+		t.Errorf("expected D0=$1200, got $%08X", c.D[0])
+	}
+	if got := c.SR.FlagString(); got != "-----" {
+		t.Errorf("expected all flags clear after AND, got %s", got)
+	}
+
+	c = runProgram(t, `
+	moveq	#-1,d0
+	ori.b	#$1F,ccr
+	eor.w	d0,d0
+	trap	#15
+`)
+	if got := c.SR.FlagString(); got != "X-Z--" {
+		t.Errorf("expected X kept and Z set after EOR, got %s", got)
+	}
+}
+
+// TestShifts runs the shift and rotate family and checks counts and flags.
+func TestShifts(t *testing.T) {
+	tests := []struct {
+		name, src string
+		d0        uint32
+		flags     string
+	}{
+		{"asl overflow", "moveq #$40,d0\n asl.b #1,d0", 0x80, "-N-V-"},
+		{"asr sign", "move.w #$8001,d0\n asr.w #1,d0", 0xC000, "XN--C"},
+		{"lsl count modulo 64", "moveq #65,d1\n moveq #3,d0\n lsl.l d1,d0", 6, "-----"},
+		{"lsr keeps upper bits", "move.l #$12345681,d0\n lsr.b #4,d0", 0x12345608, "-----"},
+		{"lsl by eight", "moveq #1,d0\n lsl.w #8,d0", 0x100, "-----"},
+		{"rol keeps X", "move.l #$80000001,d0\n rol.l #1,d0", 3, "----C"},
+		{"ror", "moveq #1,d0\n ror.b #1,d0", 0x80, "-N--C"},
+		{"roxr through X", "moveq #1,d0\n roxr.b #1,d0", 0, "X-Z-C"},
+		{"roxl count zero", "moveq #0,d0\n moveq #0,d1\n ori.b #$10,ccr\n roxl.w d1,d0", 0, "X-Z-C"},
+	}
+	for _, tc := range tests {
+		c := runProgram(t, tc.src+"\n trap #15\n")
+		if c.D[0] != tc.d0 {
+			t.Errorf("[%s] expected D0=$%08X, got $%08X", tc.name, tc.d0, c.D[0])
+		}
+		if got := c.SR.FlagString(); got != tc.flags {
+			t.Errorf("[%s] expected flags %s, got %s", tc.name, tc.flags, got)
+		}
+	}
+
+	c := runProgram(t, `
+	move.l	#$200,a0
+	move.w	#$C000,(a0)
+	asl	(a0)+
+	lsr.w	$200
+	trap	#15
+`)
+	if got := c.ReadU16(0x200); got != 0x4000 {
+		t.Errorf("expected $4000 in memory, got $%04X", got)
+	}
+	if c.A[0] != 0x202 {
+		t.Errorf("expected A0=$202, got $%08X", c.A[0])
+	}
+	if got := c.SR.FlagString(); got != "-----" {
+		t.Errorf("expected flags clear after LSR, got %s", got)
+	}
+}
+
+// TestBitOps runs the static and dynamic bit operations on registers and memory.
+func TestBitOps(t *testing.T) {
+	c := runProgram(t, `
+	moveq	#0,d0
+	bset	#31,d0
+	seq	d4
+	moveq	#33,d1
+	bchg	d1,d0
+	btst	#1,d0
+	seq	d5
+	move.l	#$200,a0
+	move.b	#$81,(a0)
+	bclr	#15,(a0)+
+	sne	d6
+	bset	#9,$201
+	btst	d1,#2
+	trap	#15
+`)
+	if c.D[0] != 0x80000002 {
+		t.Errorf("expected D0=$80000002, got $%08X", c.D[0])
+	}
+	if c.D[4] != 0xFF || c.D[5] != 0 || c.D[6] != 0xFF {
+		t.Errorf("unexpected Z results: D4=%02X D5=%02X D6=%02X", c.D[4], c.D[5], c.D[6])
+	}
+	if c.Mem[0x200] != 0x01 || c.Mem[0x201] != 0x02 {
+		t.Errorf("unexpected memory: %02X %02X", c.Mem[0x200], c.Mem[0x201])
+	}
+	if c.A[0] != 0x201 {
+		t.Errorf("expected (a0)+ to step by one byte, A0=$%08X", c.A[0])
+	}
+	if c.SR&cpu.SRZ != 0 {
+		t.Error("expected Z clear after testing a set bit of an immediate")
+	}
+}
+
+// TestMovemExecution saves and restores registers on the stack and loads sign-extended words.
+func TestMovemExecution(t *testing.T) {
+	c := runProgram(t, `
+	moveq	#1,d0
+	moveq	#2,d1
+	moveq	#3,d2
+	move.l	#$300,a0
+	movem.l	d0-d2/a0,-(a7)
+	move.l	a7,d6
+	moveq	#0,d0
+	moveq	#0,d1
+	moveq	#0,d2
+	move.l	d0,a0
+	movem.l	(a7)+,d0-d2/a0
+	move.l	#$200,a1
+	move.w	#$8000,(a1)
+	move.w	#$1234,2(a1)
+	movem.w	(a1),d3/a2
+	movem.w	d1/d2,4(a1)
+	trap	#15
+`)
+	if c.D[0] != 1 || c.D[1] != 2 || c.D[2] != 3 || c.A[0] != 0x300 {
+		t.Errorf("registers not restored: D0-D2=%X,%X,%X A0=%X", c.D[0], c.D[1], c.D[2], c.A[0])
+	}
+	if c.D[6] != 0xFF0 || c.A[7] != 0x1000 {
+		t.Errorf("unexpected stack pointers: during=%08X after=%08X", c.D[6], c.A[7])
+	}
+	// D0 is at the lowest address, A0 at the highest.
+	if c.ReadU32(0xFF0) != 1 || c.ReadU32(0xFFC) != 0x300 {
+		t.Errorf("unexpected stack layout: %08X .. %08X", c.ReadU32(0xFF0), c.ReadU32(0xFFC))
+	}
+	if c.D[3] != 0xFFFF8000 || c.A[2] != 0x1234 {
+		t.Errorf("expected sign-extended words, got D3=%08X A2=%08X", c.D[3], c.A[2])
+	}
+	if c.ReadU16(0x204) != 2 || c.ReadU16(0x206) != 3 {
+		t.Errorf("unexpected words stored: %04X %04X", c.ReadU16(0x204), c.ReadU16(0x206))
+	}
+}
+
+// TestMovepExecution checks that MOVEP uses every other byte, both ways and in
+// both sizes.
+func TestMovepExecution(t *testing.T) {
+	c := runProgram(t, `
+	move.l	#$200,a0
+	move.l	#$11223344,d0
+	movep.l	d0,1(a0)
+	move.w	#$AABB,d1
+	movep.w	d1,-2(a0)
+	move.l	#$FFFFFFFF,d2
+	movep.w	1(a0),d2
+	movep.l	1(a0),d3
+	move	#4,ccr
+	movep.w	-2(a0),d4
+	move	sr,d5
+	trap	#15
+`)
+	want := []byte{0x11, 0, 0x22, 0, 0x33, 0, 0x44}
+	for i, b := range want {
+		if got := c.Mem[0x201+i]; got != b {
+			t.Errorf("byte $%X: expected %02X, got %02X", 0x201+i, b, got)
+		}
+	}
+	if c.Mem[0x1FE] != 0xAA || c.Mem[0x1FF] != 0 || c.Mem[0x200] != 0xBB {
+		t.Errorf("unexpected word stored: % X", c.Mem[0x1FE:0x201])
+	}
+	if c.D[2] != 0xFFFF1122 {
+		t.Errorf("expected a word read to keep the high word, got D2=%08X", c.D[2])
+	}
+	if c.D[3] != 0x11223344 || c.D[4] != 0xAABB {
+		t.Errorf("unexpected reads: D3=%08X D4=%08X", c.D[3], c.D[4])
+	}
+	if c.D[5]&0x1F != 4 {
+		t.Errorf("expected the condition codes to be unaffected, got SR=%04X", c.D[5])
+	}
+}
+
+// TestCompare checks the flags set by the compare family and TST.
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name, src, flags string
+	}{
+		{"cmp equal", "moveq #5,d0\n cmp.l #5,d0", "--Z--"},
+		{"cmp borrow", "moveq #3,d0\n moveq #5,d1\n cmp.b d1,d0", "-N--C"},
+		{"cmpi overflow", "move.l #$7FFFFFFF,d0\n cmpi.l #-1,d0", "-N-VC"},
+		{"cmpa sign-extends", "move.l #$10000,a0\n cmpa.w #-1,a0", "----C"},
+		{"cmp keeps X", "ori.b #$10,ccr\n moveq #0,d0\n cmp.l d0,d0", "X-Z--"},
+		{"tst", "moveq #-1,d0\n ori.b #$03,ccr\n tst.b d0", "-N---"},
+	}
+	for _, tc := range tests {
+		c := runProgram(t, tc.src+"\n trap #15\n")
+		if got := c.SR.FlagString(); got != tc.flags {
+			t.Errorf("[%s] expected flags %s, got %s", tc.name, tc.flags, got)
+		}
+	}
+
+	c := runProgram(t, `
+	move.l	#$200,a0
+	move.l	#$300,a1
+	move.w	#$0102,(a0)
+	move.w	#$0101,(a1)
+	cmpm.b	(a0)+,(a1)+
+	seq	d0
+	cmpm.b	(a0)+,(a1)+
+	scs	d1
+	trap	#15
+`)
+	if c.D[0] != 0xFF || c.D[1] != 0xFF {
+		t.Errorf("unexpected CMPM results: D0=%02X D1=%02X", c.D[0], c.D[1])
+	}
+	if c.A[0] != 0x202 || c.A[1] != 0x302 {
+		t.Errorf("expected both addresses to advance, got A0=%08X A1=%08X", c.A[0], c.A[1])
+	}
+	if c.ReadU16(0x300) != 0x0101 {
+		t.Error("CMPM must not write its destination")
+	}
+}
+
+// TestRuntimeRoutines runs routines from the built-in runtime.i.
+func TestRuntimeRoutines(t *testing.T) {
+	c := runProgram(t, `
+	lea	src(pc),a1
+	move.l	#$400,a0
+	moveq	#6,d0
+	bsr	memcpy
+	move.l	#$400,a0
+	lea	src(pc),a1
+	bsr	strcmp
+	move.l	d0,d6
+	move.l	#1000003,d0
+	moveq	#10,d1
+	bsr	divmod32
+	trap	#15
+src:
+	dc.b	'hello',0
+	even
+	include	"runtime.i"
+`)
+	if got := string(c.Mem[0x400:0x405]); got != "hello" {
+		t.Errorf("memcpy copied %q", got)
+	}
+	if c.D[6] != 0 {
+		t.Errorf("expected strcmp to return 0, got %08X", c.D[6])
+	}
+	if c.D[0] != 100000 || c.D[1] != 3 {
+		t.Errorf("expected divmod32 to return 100000 r 3, got %d r %d", c.D[0], c.D[1])
+	}
+}
+
+// TestRuntimeItoa formats signed and unsigned numbers with runtime.i.
+func TestRuntimeItoa(t *testing.T) {
+	c := runProgram(t, `
+	move.l	#$400,a0
+	move.l	#-1234,d0
+	bsr	itoa
+	move.l	#$410,a0
+	moveq	#-1,d0
+	bsr	utoa
+	trap	#15
+	include	"runtime.i"
+`)
+	if got := string(c.Mem[0x400:0x406]); got != "-1234\x00" {
+		t.Errorf("itoa wrote %q", got)
+	}
+	if got := string(c.Mem[0x410:0x41B]); got != "4294967295\x00" {
+		t.Errorf("utoa wrote %q", got)
+	}
+}
+
+// TestMiscellaneous runs CLR, NEG, NEGX, NOT, EXT, SWAP and EXG.
+func TestMiscellaneous(t *testing.T) {
+	c := runProgram(t, `
+	move.l	#$12345678,d0
+	clr.b	d0
+	moveq	#5,d1
+	neg.l	d1
+	move.l	#$400,a0
+	move.w	#$00FF,(a0)
+	not.w	(a0)+
+	move.w	#$0080,d4
+	ext.w	d4
+	move.l	#$00008000,d5
+	ext.l	d5
+	move.l	#$1234ABCD,d6
+	swap	d6
+	move.l	#$600,a1
+	exg	d6,a1
+	moveq	#0,d2
+	moveq	#1,d3
+	neg.l	d3
+	negx.l	d2
+	trap	#15
+`)
+	want := []uint32{0x12345600, 0xFFFFFFFB, 0xFFFFFFFF, 0xFFFFFFFF, 0xFF80, 0xFFFF8000, 0x600}
+	for i, w := range want {
+		if c.D[i] != w {
+			t.Errorf("expected D%d=%08X, got %08X", i, w, c.D[i])
+		}
+	}
+	if c.A[1] != 0xABCD1234 {
+		t.Errorf("expected EXG to move the swapped value to A1, got %08X", c.A[1])
+	}
+	if c.ReadU16(0x400) != 0xFF00 || c.A[0] != 0x402 {
+		t.Errorf("NOT (A0)+ gave %04X with A0=%08X", c.ReadU16(0x400), c.A[0])
+	}
+	// NEGX of 0 with X set borrows, so C and X are set and N comes from the result.
+	if c.SR&(cpu.SRX|cpu.SRN|cpu.SRC) != cpu.SRX|cpu.SRN|cpu.SRC {
+		t.Errorf("unexpected flags after NEGX: %s", c.SR.FlagString())
+	}
+}
+
+// TestClearFlags checks that CLR clears N, V and C, sets Z and leaves X alone.
+func TestClearFlags(t *testing.T) {
+	c := runProgram(t, `
+	ori	#$1B,ccr
+	clr.w	d0
+	trap	#15
+`)
+	if got := c.SR.FlagString(); got != "X-Z--" {
+		t.Errorf("expected X-Z-- after CLR, got %s", got)
+	}
+}
+
+// TestSubtract runs the SUB family and checks borrow and overflow.
+func TestSubtract(t *testing.T) {
+	c := runProgram(t, `
+	moveq	#10,d0
+	moveq	#3,d1
+	sub.l	d1,d0
+	subq.w	#8,d0
+	move.l	#$300,a0
+	suba.w	#$10,a0
+	subq.l	#2,a0
+	move.l	#$400,a1
+	move.w	#100,(a1)
+	sub.w	d1,(a1)
+	subi.b	#$10,d1
+	trap	#15
+`)
+	if c.D[0] != 0x0000FFFF {
+		t.Errorf("expected D0=$0000FFFF, got $%08X", c.D[0])
+	}
+	if c.A[0] != 0x2EE {
+		t.Errorf("expected A0=$2EE, got $%08X", c.A[0])
+	}
+	if got := c.ReadU16(0x400); got != 97 {
+		t.Errorf("expected 97 in memory, got %d", got)
+	}
+	if c.D[1] != 0xF3 {
+		t.Errorf("expected D1=$F3, got $%08X", c.D[1])
+	}
+	if got := c.SR.FlagString(); got != "XN--C" {
+		t.Errorf("expected a borrow from SUBI, got %s", got)
+	}
+
+	c = runProgram(t, `
+	move.l	#$80000000,d0
+	moveq	#1,d1
+	sub.l	d1,d0
+	trap	#15
+`)
+	if got := c.SR.FlagString(); got != "---V-" {
+		t.Errorf("expected signed overflow, got %s", got)
+	}
+}
+
+// TestExtendArithmetic chains ADDX and SUBX through X, in registers and in
+// memory, and checks that Z is only ever cleared.
+func TestExtendArithmetic(t *testing.T) {
+	c := runProgram(t, `
+	move.l	#$FFFFFFFF,d0
+	moveq	#1,d1
+	moveq	#1,d2
+	moveq	#0,d3
+	add.l	d2,d0
+	addx.l	d3,d1
+	move.l	#$0001FFFF,$400
+	move.l	#$00000001,$410
+	lea	$404,a0
+	lea	$414,a1
+	move	#4,ccr
+	addx.w	-(a0),-(a1)
+	addx.w	-(a0),-(a1)
+	move	sr,d7
+	moveq	#0,d4
+	moveq	#1,d5
+	moveq	#0,d2
+	moveq	#1,d3
+	move	#4,ccr
+	subx.l	d2,d4
+	subx.l	d3,d5
+	move	sr,d6
+	move	#$10,ccr
+	moveq	#5,d2
+	moveq	#2,d3
+	subx.b	d3,d2
+	trap	#15
+`)
+	if c.D[0] != 0 || c.D[1] != 2 {
+		t.Errorf("expected the carry added into the high long, got D1:D0 = %08X:%08X", c.D[1], c.D[0])
+	}
+	if got := c.ReadU32(0x410); got != 0x00020000 || c.A[0] != 0x400 || c.A[1] != 0x410 {
+		t.Errorf("expected $00020000 at $410 with A0=$400 A1=$410, got $%08X, A0=$%X A1=$%X", got, c.A[0], c.A[1])
+	}
+	if c.D[7]&0x1F != 0 {
+		t.Errorf("expected a non-zero word to clear Z and no carry out, got SR=%04X", c.D[7])
+	}
+	if c.D[4] != 0 || c.D[5] != 0 || c.D[6]&0x1F != 4 {
+		t.Errorf("expected a zero result with Z kept, got D5:D4 = %08X:%08X, SR=%04X", c.D[5], c.D[4], c.D[6])
+	}
+	if c.D[2] != 2 {
+		t.Errorf("expected 5-2-X = 2, got D2=%08X", c.D[2])
+	}
+}
+
+// TestBranchExecution runs loops, subroutine calls and conditional sets.
+func TestBranchExecution(t *testing.T) {
+	c := runProgram(t, `
+	moveq	#0,d0
+	moveq	#9,d1
+loop:
+	bsr	add_count
+	dbra	d1,loop
+	subq.l	#1,d0
+	beq	wrong
+	bpl.s	positive
+wrong:
+	moveq	#-1,d2
+	bra	done
+positive:
+	seq	d3
+	sne	d4
+	moveq	#1,d2
+done:
+	trap	#15
+
+add_count:
+	addq.l	#1,d0
+	rts
+`)
+	if c.D[0] != 9 || c.D[1] != 0xFFFF || c.D[2] != 1 {
+		t.Errorf("unexpected D0=%08X D1=%08X D2=%08X", c.D[0], c.D[1], c.D[2])
+	}
+	if c.D[3] != 0 || c.D[4] != 0xFF {
+		t.Errorf("unexpected Scc results D3=%08X D4=%08X", c.D[3], c.D[4])
+	}
+	if c.A[7] != 0x1000 {
+		t.Errorf("stack not balanced, A7=%08X", c.A[7])
+	}
+}
+
+// TestConditions checks the signed and unsigned conditions against flag combinations.
+func TestConditions(t *testing.T) {
+	c := cpu.New(0x10, 0)
+	for _, tc := range []struct {
+		sr   cpu.SR
+		cond uint16
+		want bool
+	}{
+		{0, cpu.CondHI, true},
+		{cpu.SRC, cpu.CondHI, false},
+		{cpu.SRZ, cpu.CondLS, true},
+		{cpu.SRN | cpu.SRV, cpu.CondGE, true},
+		{cpu.SRN, cpu.CondLT, true},
+		{cpu.SRN | cpu.SRV, cpu.CondGT, true},
+		{cpu.SRZ | cpu.SRN | cpu.SRV, cpu.CondGT, false},
+		{cpu.SRV, cpu.CondLE, true},
+		{cpu.SRX, cpu.CondCS, false},
+		{0, cpu.CondF, false},
+	} {
+		c.SR = tc.sr
+		if got := c.TestCondition(tc.cond); got != tc.want {
+			t.Errorf("condition %X with SR %s: got %v", tc.cond, tc.sr, got)
+		}
+	}
+}
+
+// TestInstructionSetMetadata checks the shape of every instruction description.
+func TestInstructionSetMetadata(t *testing.T) {
+	for _, info := range cpu.InstructionSet {
+		if len(info.Encoding) != 16 {
+			t.Errorf("%s: encoding %q is not 16 bits", info.Mnemonic, info.Encoding)
+		}
+		for i := 0; i < len(info.Encoding); i++ {
+			b := info.Encoding[i]
+			if _, ok := cpu.EncodingFields[b]; !ok && b != '0' && b != '1' {
+				t.Errorf("%s: unknown encoding field %q", info.Mnemonic, b)
+			}
+		}
+		if len(info.Flags) != 5 || strings.Trim(info.Flags, "*-01U") != "" {
+			t.Errorf("%s: invalid flags %q", info.Mnemonic, info.Flags)
+		}
+		if info.Example == "" || info.Name == "" {
+			t.Errorf("%s: missing name or example", info.Mnemonic)
+		}
+	}
+}
+
+// TestExecuteErrors checks PC advancement over extension words and the fault report.
+func TestExecuteErrors(t *testing.T) {
+	c := cpu.New(0x100, 0)
+	c.WriteU16(0x10, 0x203C) // move.l #$12345678,d0
+	c.WriteU32(0x12, 0x12345678)
+	c.WriteU16(0x16, 0xFFFF) // Line F, not implemented
+	c.PC = 0x10
+	c.Running = true
+
+	if err := c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC != 0x16 || c.D[0] != 0x12345678 {
+		t.Errorf("expected PC=$16 and D0=$12345678, got PC=$%X D0=$%X", c.PC, c.D[0])
+	}
+
+	err := c.Execute()
+	var ee *cpu.ExecError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected an ExecError, got %v", err)
+	}
+	if ee.Addr != 0x16 || ee.Opcode != 0xFFFF || c.PC != 0x16 {
+		t.Errorf("unexpected fault report %v with PC=$%X", err, c.PC)
+	}
+	if !strings.Contains(err.Error(), "FFFF at $00000016") {
+		t.Errorf("message lacks opcode and address: %v", err)
+	}
+}
+
+// TestSubroutines round-trips calls through every JSR form, a stack frame and RTR.
+func TestSubroutines(t *testing.T) {
+	c := runProgram(t, `
+	moveq	#0,d0
+	jsr	count
+	lea	count,a0
+	jsr	(a0)
+	jsr	count(pc)
+	lea	2(a0),a1
+	jsr	-2(a1)
+	moveq	#4,d1
+	jsr	-4(a0,d1.w)
+	pea	back
+	jmp	(a0)
+back:
+	link	a6,#-8
+	move.l	#$CAFE,-4(a6)
+	move.l	a7,d2
+	move.l	-4(a6),d3
+	unlk	a6
+	ori	#$1F,ccr
+	pea	done
+	move.w	#$0004,-(a7)
+	rtr
+	moveq	#-1,d0
+done:
+	trap	#15
+
+count:
+	addq.l	#1,d0
+	rts
+`)
+	if c.D[0] != 6 {
+		t.Errorf("expected 6 calls, got %d", c.D[0])
+	}
+	if c.D[2] != 0xFF4 || c.A[6] != 0 {
+		t.Errorf("unexpected frame: frame SP=%08X A6=%08X", c.D[2], c.A[6])
+	}
+	if c.D[3] != 0xCAFE {
+		t.Errorf("expected the local at -4(a6), got %08X", c.D[3])
+	}
+	if got := c.SR.FlagString(); got != "--Z--" {
+		t.Errorf("expected RTR to restore Z only, got %s", got)
+	}
+	if c.A[7] != 0x1000 {
+		t.Errorf("stack not balanced, A7=%08X", c.A[7])
+	}
+}
+
+// TestPrivilege drops to user mode and checks that MOVE to SR traps to the
+// privilege violation handler on the supervisor stack.
+func TestPrivilege(t *testing.T) {
+	c := runProgram(t, `
+	bra.w	start
+	ds.b	28
+	dc.l	violation		; Vector 8
+start:
+	move.l	#$800,a0
+	move.l	a0,usp
+	andi.w	#$DFFF,sr		; Enter user mode
+	move.l	a7,d1			; The user stack
+faulty:
+	move.w	#$2700,sr
+	trap	#15
+violation:
+	move.w	(a7),d2			; Stacked SR
+	move.l	2(a7),d3		; Stacked PC
+	lea	faulty(pc),a1
+	move.l	a1,d4
+	move.l	a7,d5
+	trap	#15
+`)
+	if c.D[1] != 0x800 {
+		t.Errorf("expected the user stack at $800, got %08X", c.D[1])
+	}
+	if c.D[2]&cpu.SRS != 0 {
+		t.Errorf("expected the stacked SR to be in user mode, got %04X", c.D[2])
+	}
+	if c.D[3] != c.D[4] {
+		t.Errorf("expected the stacked PC to point at MOVE to SR ($%08X), got $%08X", c.D[4], c.D[3])
+	}
+	if c.D[5] != 0x1000-6 || !c.SR.Supervisor() {
+		t.Errorf("expected the handler on the supervisor stack, got A7=%08X SR=%s", c.D[5], c.SR)
+	}
+	if usp, _ := c.StackPointers(); usp != 0x800 {
+		t.Errorf("expected USP to be kept, got %08X", usp)
+	}
+	if c.Exceptions != 2 { // The violation and the final TRAP.
+		t.Errorf("expected 2 exceptions, got %d", c.Exceptions)
+	}
+}
+
+// TestRTE returns from a hand-built exception frame into user mode.
+func TestRTE(t *testing.T) {
+	c := runProgram(t, `
+	move.l	#$800,a0
+	move.l	a0,usp
+	lea	user(pc),a1
+	move.l	a1,-(a7)
+	move.w	#$0004,-(a7)		; User mode, Z set
+	rte
+user:
+	seq	d0
+	move.l	a7,d1
+	trap	#15
+`)
+	if c.D[0]&0xFF != 0xFF || c.D[1] != 0x800 || c.SR.Supervisor() {
+		t.Errorf("unexpected state after RTE: D0=%08X D1=%08X SR=%s", c.D[0], c.D[1], c.SR)
+	}
+}
+
+// TestExceptions raises each exception with only its own vector installed and
+// checks the stacked frame.
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		vector int
+		// after is true if the stacked PC follows the faulting instruction.
+		after bool
+	}{
+		{"Illegal", "illegal", cpu.VectorIllegalInstruction, false},
+		{"ZeroDivide", "moveq #0,d1\n divu d1,d0", cpu.VectorZeroDivide, true},
+		{"CHK", "moveq #-1,d0\n chk #10,d0", cpu.VectorCHK, true},
+		{"TRAPV", "ori #2,ccr\n trapv", cpu.VectorTRAPV, true},
+		{"TRAP", "trap #3", cpu.VectorTrap0 + 3, true},
+		{"BusError", "move.l #$F00000,a0\n move.w (a0),d0", cpu.VectorBusError, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			asm := assembler.New()
+			code, err := asm.Assemble(`
+start:
+	`+tc.src+`
+fault_end:
+	trap	#15
+handler:
+	move.l	a7,d7
+	trap	#15
+`, 0x400)
+			if err != nil {
+				t.Fatalf("failed to assemble: %v", err)
+			}
+			labels := asm.Labels()
+			c := cpu.New(0x1000, 0)
+			copy(c.Mem[0x400:], code)
+			c.WriteU32(uint32(tc.vector)*4, labels["handler"])
+			c.A[7] = 0x1000
+			c.PC = 0x400
+			c.Running = true
+			for steps := 0; c.Running && steps < 100; steps++ {
+				if err := c.Execute(); err != nil {
+					t.Fatalf("execution failed: %v", err)
+				}
+			}
+
+			sp := c.D[7]
+			if tc.vector == cpu.VectorBusError {
+				if sp != 0x1000-14 {
+					t.Fatalf("expected a 14-byte frame, got A7=%08X", sp)
+				}
+				if status, addr := c.ReadU16(sp), c.ReadU32(sp+2); status != 0x1D || addr != 0xF00000 {
+					t.Errorf("unexpected bus error frame: status %04X address %08X", status, addr)
+				}
+				return
+			}
+			if sp != 0x1000-6 {
+				t.Fatalf("expected a 6-byte frame, got A7=%08X", sp)
+			}
+			pc := c.ReadU32(sp + 2)
+			want := labels["fault_end"]
+			if !tc.after {
+				want -= 2
+			}
+			if pc != want {
+				t.Errorf("expected stacked PC %08X, got %08X", want, pc)
+			}
+			if !c.SR.Supervisor() || c.Exceptions != 2 {
+				t.Errorf("expected one exception in supervisor mode, got SR=%s exceptions=%d", c.SR, c.Exceptions)
+			}
+		})
+	}
+}
+
+// TestUnhandledException stops with an error when the vector holds no handler.
+func TestUnhandledException(t *testing.T) {
+	c := cpu.New(0x1000, 0)
+	c.WriteU16(0x400, cpu.OPILLEGAL)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	err := c.Execute()
+	var unhandled *cpu.UnhandledExceptionError
+	if !errors.As(err, &unhandled) || unhandled.Vector != cpu.VectorIllegalInstruction {
+		t.Errorf("expected an unhandled illegal instruction, got %v", err)
+	}
+}
+
+// TestUnimplementedPolicy runs opcodes the CPU doesn't decode under each policy.
+func TestUnimplementedPolicy(t *testing.T) {
+	setup := func(policy cpu.UnimplementedPolicy, words ...uint16) *cpu.CPU {
+		c := cpu.New(0x1000, 16)
+		c.Unimplemented = policy
+		for i, w := range words {
+			c.WriteU16(0x400+uint32(i)*2, w)
+		}
+		c.WriteU32(uint32(cpu.VectorIllegalInstruction)*4, 0x600)
+		c.WriteU32(uint32(cpu.VectorLineA)*4, 0x700)
+		c.WriteU32(uint32(cpu.VectorLineF)*4, 0x800)
+		c.A[7] = 0x1000
+		c.PC = 0x400
+		c.Running = true
+		return c
+	}
+
+	c := setup(cpu.UnimplementedError, 0x4E7F)
+	var ee *cpu.ExecError
+	if err := c.Execute(); !errors.As(err, &ee) || c.PC != 0x400 {
+		t.Errorf("expected an ExecError at $400, got %v at PC=%08X", err, c.PC)
+	}
+
+	for _, tc := range []struct {
+		opcode uint16
+		pc     uint32
+	}{{0x4E7F, 0x600}, {0xA123, 0x700}, {0xF000, 0x800}} {
+		c = setup(cpu.UnimplementedException, tc.opcode)
+		if err := c.Execute(); err != nil {
+			t.Fatalf("%04X: execution failed: %v", tc.opcode, err)
+		}
+		if c.PC != tc.pc || c.ReadU32(c.A[7]+2) != 0x400 {
+			t.Errorf("%04X: expected the exception at %X to stack $400, got PC=%08X", tc.opcode, tc.pc, c.PC)
+		}
+	}
+
+	// The callback emulates 4E7F with an extension word loaded into D0, and
+	// declines anything else.
+	c = setup(cpu.UnimplementedCallback, 0x4E7F, 0x1234, cpu.OPNOP, 0xA123)
+	c.OnUnimplemented = func(c *cpu.CPU, opcode uint16) (bool, error) {
+		if opcode != 0x4E7F {
+			return false, nil
+		}
+		c.D[0] = uint32(c.ReadU16(c.PC))
+		c.PC += 2
+		return true, nil
+	}
+	for range 3 {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed: %v", err)
+		}
+	}
+	if c.D[0] != 0x1234 {
+		t.Errorf("expected the callback to emulate the opcode, got D0=%08X", c.D[0])
+	}
+	if c.PC != 0x700 {
+		t.Errorf("expected a declined opcode to take the line 1010 exception, got PC=%08X", c.PC)
+	}
+}
+
+// TestDivide checks DIVU and DIVS results, remainder signs and overflow.
+func TestDivide(t *testing.T) {
+	c := runProgram(t, `
+	move.l	#100003,d0
+	divu	#10,d0
+	move.l	#-7,d1
+	divs	#2,d1
+	move.l	#$12345678,d2
+	divu	#1,d2
+	trap	#15
+`)
+	if c.D[0] != 3<<16|10000 {
+		t.Errorf("expected DIVU 10000 r 3, got %08X", c.D[0])
+	}
+	if c.D[1] != 0xFFFFFFFD {
+		t.Errorf("expected DIVS -3 r -1, got %08X", c.D[1])
+	}
+	if c.D[2] != 0x12345678 || c.SR&cpu.SRV == 0 {
+		t.Errorf("expected overflow to leave D2 alone and set V, got %08X %s", c.D[2], c.SR.FlagString())
+	}
+}
+
+// TestReset loads the stack pointer and PC from the reset vectors.
+func TestReset(t *testing.T) {
+	c := cpu.New(0x1000, 0)
+	c.WriteU32(0, 0x800)
+	c.WriteU32(4, 0x400)
+	c.SR = 0
+	c.VBR = 0x100
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if c.A[7] != 0x800 || c.PC != 0x400 || c.SR != 0x2700 || c.VBR != 0 {
+		t.Errorf("unexpected state after reset: A7=%08X PC=%08X SR=%04X VBR=%08X", c.A[7], c.PC, uint16(c.SR), c.VBR)
+	}
+}
+
+// TestInterrupts checks priority masking, autovectors and the NMI edge.
+func TestInterrupts(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+start:
+	move.w	#$2300,sr
+	moveq	#0,d0
+loop:
+	addq.l	#1,d0
+	cmp.l	#20,d0
+	bne	loop
+	trap	#15
+handler:
+	addq.l	#1,d5
+	rte
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	labels := asm.Labels()
+	c := cpu.New(0x1000, 0)
+	copy(c.Mem[0x400:], code)
+	c.WriteU32((cpu.VectorSpurious+5)*4, labels["handler"])
+	c.WriteU32(64*4, labels["handler"])
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+
+	step := func(n int) {
+		t.Helper()
+		for range n {
+			if err := c.Execute(); err != nil {
+				t.Fatalf("execution failed: %v", err)
+			}
+		}
+	}
+
+	step(3)
+	if err := c.RaiseInterrupt(3, cpu.Autovector); err != nil {
+		t.Fatal(err)
+	}
+	step(3)
+	if c.D[5] != 0 {
+		t.Fatalf("level 3 was taken with the mask at 3")
+	}
+	c.ClearInterrupt(3)
+
+	if err := c.RaiseInterrupt(5, cpu.Autovector); err != nil {
+		t.Fatal(err)
+	}
+	ret := c.PC
+	step(1)
+	if c.D[5] != 1 || c.SR.IntMask() != 5 {
+		t.Fatalf("expected the level 5 handler with mask 5, got D5=%d SR=%s", c.D[5], c.SR)
+	}
+	if sr, pc := c.ReadU16(c.A[7]), c.ReadU32(c.A[7]+2); sr != 0x2300 || pc != ret {
+		t.Errorf("expected a frame with SR $2300 and PC $%X, got SR $%04X and PC $%X", ret, sr, pc)
+	}
+	c.ClearInterrupt(5)
+	step(1)
+	if c.PC != ret || c.SR.IntMask() != 3 {
+		t.Errorf("RTE returned to $%X with mask %d", c.PC, c.SR.IntMask())
+	}
+
+	// Level 7 ignores the mask but is taken once per assertion.
+	c.SR |= cpu.SRI
+	if err := c.RaiseInterrupt(7, 64); err != nil {
+		t.Fatal(err)
+	}
+	step(1)
+	if c.D[5] != 2 {
+		t.Fatalf("level 7 was not taken")
+	}
+	for steps := 0; c.Running && steps < 1000; steps++ {
+		step(1)
+	}
+	if c.D[5] != 2 || c.D[0] != 20 {
+		t.Errorf("expected one NMI and a finished loop, got D5=%d D0=%d", c.D[5], c.D[0])
+	}
+	if err := c.RaiseInterrupt(8, cpu.Autovector); err == nil {
+		t.Error("expected an error for level 8")
+	}
+}
+
+// TestExceptionStats counts exceptions per vector and keeps their frames.
+func TestExceptionStats(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	trap	#1
+	trap	#1
+	moveq	#0,d1
+	divu	d1,d0
+	trap	#15
+handler:
+	rte
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	c := cpu.New(0x1000, 0)
+	copy(c.Mem[0x400:], code)
+	handler := asm.Labels()["handler"]
+	c.WriteU32((cpu.VectorTrap0+1)*4, handler)
+	c.WriteU32(cpu.VectorZeroDivide*4, handler)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	for steps := 0; c.Running && steps < 100; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed: %v", err)
+		}
+	}
+
+	counts := c.ExceptionCounts()
+	want := map[int]uint64{cpu.VectorTrap0 + 1: 2, cpu.VectorZeroDivide: 1, cpu.VectorTrap0 + 15: 1}
+	if len(counts) != len(want) {
+		t.Errorf("expected counts %v, got %v", want, counts)
+	}
+	for v, n := range want {
+		if counts[v] != n {
+			t.Errorf("vector %d: expected %d, got %d", v, n, counts[v])
+		}
+	}
+
+	recent := c.RecentExceptions()
+	if len(recent) != 4 {
+		t.Fatalf("expected 4 recent exceptions, got %d", len(recent))
+	}
+	first := recent[0]
+	if first.Address != 0x400 || first.PC != 0x402 || first.Handler != handler || first.SR != 0x2700 {
+		t.Errorf("unexpected first record: %s", first)
+	}
+	if recent[2].Vector != cpu.VectorZeroDivide || recent[2].Address != 0x406 {
+		t.Errorf("unexpected zero divide record: %s", recent[2])
+	}
+	if last, ok := c.LastException(); !ok || last.Vector != cpu.VectorTrap0+15 {
+		t.Errorf("expected TRAP #15 last, got %s", last)
+	}
+}
+
+// TestStop waits in the stopped state until an interrupt resumes the CPU.
+func TestStop(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	stop	#$2000
+	moveq	#1,d0
+	trap	#15
+handler:
+	moveq	#2,d1
+	rte
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	c := cpu.New(0x1000, 0)
+	copy(c.Mem[0x400:], code)
+	c.WriteU32((cpu.VectorSpurious+4)*4, asm.Labels()["handler"])
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+
+	for range 5 {
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.Stopped || !c.Idle() || c.PC != 0x404 || c.SR != 0x2000 || c.Instructions != 1 {
+		t.Fatalf("expected to wait after STOP, got stopped=%v PC=%08X SR=%04X after %d instructions",
+			c.Stopped, c.PC, uint16(c.SR), c.Instructions)
+	}
+	if c.Cycles <= 4 {
+		t.Errorf("expected cycles to pass while stopped, got %d", c.Cycles)
+	}
+
+	if err := c.RaiseInterrupt(4, cpu.Autovector); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	c.ClearInterrupt(4)
+	if c.Stopped || c.D[1] != 2 {
+		t.Fatalf("expected the interrupt to resume the CPU, got stopped=%v D1=%d", c.Stopped, c.D[1])
+	}
+	for steps := 0; c.Running && steps < 10; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Running || c.D[0] != 1 {
+		t.Errorf("expected to continue after STOP and halt, got D0=%d", c.D[0])
+	}
+}
+
+// TestTrace runs a guest debugger that logs the PC after each traced instruction.
+func TestTrace(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	ori.w	#$8000,sr
+	moveq	#1,d0
+	moveq	#2,d1
+	trap	#3
+	andi.w	#$7FFF,sr
+	trap	#15
+trap3:
+	moveq	#3,d2
+	rte
+tracer:
+	addq.l	#1,d7
+	move.l	2(a7),(a6)+
+	rte
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	labels := asm.Labels()
+	c := cpu.New(0x1000, 0)
+	copy(c.Mem[0x400:], code)
+	c.WriteU32(cpu.VectorTrace*4, labels["tracer"])
+	c.WriteU32((cpu.VectorTrap0+3)*4, labels["trap3"])
+	c.A[6] = 0x800
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	for steps := 0; c.Running && steps < 100; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed: %v", err)
+		}
+	}
+
+	want := []uint32{0x406, 0x408, labels["trap3"], 0x40E}
+	if c.D[7] != uint32(len(want)) {
+		t.Fatalf("expected %d trace exceptions, got %d", len(want), c.D[7])
+	}
+	for i, pc := range want {
+		if got := c.ReadU32(0x800 + uint32(i)*4); got != pc {
+			t.Errorf("trace %d: expected PC $%X, got $%X", i, pc, got)
+		}
+	}
+	if c.D[2] != 3 || c.SR.Trace() {
+		t.Errorf("expected the trap handler to run and T to end clear, got D2=%d SR=%s", c.D[2], c.SR)
+	}
+}
+
+// TestAddressError checks odd word accesses and jumps in strict mode.
+func TestAddressError(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		status uint16
+		addr   uint32
+	}{
+		{"Read", "lea $801,a0\n move.w (a0),d0", 0x1D, 0x801},
+		{"Write", "lea $801,a0\n move.l d0,(a0)", 0x0D, 0x801},
+		{"Fetch", "jmp $803", 0x16, 0x803},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			asm := assembler.New()
+			code, err := asm.Assemble(tc.src+"\n trap #15\nhandler:\n move.l a7,d7\n trap #15\n", 0x400)
+			if err != nil {
+				t.Fatalf("failed to assemble: %v", err)
+			}
+			c := cpu.New(0x1000, 0)
+			copy(c.Mem[0x400:], code)
+			c.WriteU32(cpu.VectorAddressError*4, asm.Labels()["handler"])
+			c.StrictAlignment = true
+			c.A[7] = 0x1000
+			c.PC = 0x400
+			c.Running = true
+			for steps := 0; c.Running && steps < 100; steps++ {
+				if err := c.Execute(); err != nil {
+					t.Fatalf("execution failed: %v", err)
+				}
+			}
+
+			sp := c.D[7]
+			if sp != 0x1000-14 {
+				t.Fatalf("expected a 14-byte frame, got A7=%08X", sp)
+			}
+			if status, addr := c.ReadU16(sp), c.ReadU32(sp+2); status != tc.status || addr != tc.addr {
+				t.Errorf("expected status %04X address %08X, got %04X %08X", tc.status, tc.addr, status, addr)
+			}
+			if last, _ := c.LastException(); last.Vector != cpu.VectorTrap0+15 {
+				t.Errorf("unexpected last exception %s", last)
+			}
+		})
+	}
+
+	// Without strict checking the misaligned bytes are used.
+	c := runProgram(t, "\tlea\tdata(pc),a0\n\tmove.w\t1(a0),d0\n\ttrap\t#15\ndata:\n\tdc.b\t1,2,3,4\n")
+	if c.D[0] != 0x0203 {
+		t.Errorf("expected a misaligned read of $0203, got $%04X", c.D[0])
+	}
+}
+
+// TestCycles checks instruction timings against the 68000 manual, including the
+// parts that depend on the data.
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		d0   uint32
+		want uint64
+	}{
+		{"moveq", "moveq #1,d0", 0, 4},
+		{"move.l abs.w to (a0)+", "move.l $800,(a0)+", 0, 4 + 12 + 8},
+		{"move.w d0 to -(a0)", "move.w d0,-(a0)", 0, 8},
+		{"add.l d1,d0", "add.l d1,d0", 0, 8},
+		{"add.w (a0),d0", "add.w (a0),d0", 0, 8},
+		{"add.l d0,(a0)", "add.l d0,(a0)", 0, 20},
+		{"subi.l to d0", "subi.l #$10000,d0", 0, 16},
+		{"cmpi.l to d0", "cmpi.l #$10000,d0", 0, 14},
+		{"clr.l d0", "clr.l d0", 0, 6},
+		{"tas d0", "tas d0", 0, 4},
+		{"tas (a0)", "tas (a0)", 0, 18},
+		{"lea d16(a0)", "lea 4(a0),a1", 0, 8},
+		{"bne taken", "bne.s next\n\tnop\nnext:", 0, 10},
+		{"beq not taken", "beq.s next\n\tnop\nnext:", 0, 8 + 4},
+		{"beq.w not taken", "beq.w next\nnext:", 0, 12},
+		{"dbra loop", "moveq #2,d0\nloop:\n\tdbra d0,loop", 0, 4 + 10 + 10 + 14},
+		{"lsl.l by d1", "moveq #5,d1\n\tlsl.l d1,d0", 0, 4 + 8 + 10},
+		{"movem.l 3 regs", "movem.l d0-d2,-(a7)", 0, 8 + 3*8},
+		{"divu", "divu d1,d0", 100, 2 * (38 + 2*15 - 3)},
+		{"divu overflow", "divu d1,d0", 0x70000, 10},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := assembler.New().Assemble("\t"+tc.src+"\n", 0x400)
+			if err != nil {
+				t.Fatalf("failed to assemble: %v", err)
+			}
+			c := cpu.New(0x1000, 0)
+			copy(c.Mem[0x400:], code)
+			c.A[0], c.A[7] = 0x900, 0x1000
+			c.D[0], c.D[1] = tc.d0, 7
+			c.PC = 0x400
+			c.Running = true
+			for c.PC < 0x400+uint32(len(code)) {
+				if err := c.Execute(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.Cycles != tc.want {
+				t.Errorf("expected %d cycles, got %d", tc.want, c.Cycles)
+			}
+		})
+	}
+
+	// A TRAP costs its exception processing; the handler's RTE is separate.
+	c := runProgram(t, "\tlea\thandler,a0\n\tmove.l\ta0,$84\n\ttrap\t#1\n\ttrap\t#15\nhandler:\n\trte\n")
+	if want := uint64(12 + 16 + 34 + 20 + 34); c.Cycles != want {
+		t.Errorf("expected %d cycles for the TRAP program, got %d", want, c.Cycles)
+	}
+}
+
+// deviceBus maps a counter register at $7000 over RAM and rejects writes to
+// the ROM below $100.
+type deviceBus struct {
+	cpu.RAM
+	reads uint32
+}
+
+func (b *deviceBus) Read32(addr uint32) (uint32, error) {
+	if addr == 0x7000 {
+		b.reads++
+		return b.reads, nil
+	}
+	return b.RAM.Read32(addr)
+}
+
+func (b *deviceBus) Write16(addr uint32, v uint16) error {
+	if addr < 0x100 {
+		return cpu.ErrUnmapped
+	}
+	return b.RAM.Write16(addr, v)
+}
+
+// TestBus runs code against a custom bus with a device register and a ROM that
+// raises bus errors on writes.
+func TestBus(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	move.l	$7000,d0
+	move.l	$7000,d1
+	move.w	d0,$10
+	trap	#15
+handler:
+	moveq	#9,d2
+	trap	#15
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	c := cpu.New(0x10000, 0)
+	c.Bus = &deviceBus{RAM: c.Mem}
+	copy(c.Mem[0x400:], code)
+	c.WriteU32(cpu.VectorBusError*4, asm.Labels()["handler"])
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	for c.Running {
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.D[0] != 1 || c.D[1] != 2 {
+		t.Errorf("expected the device to count reads, got d0=%d d1=%d", c.D[0], c.D[1])
+	}
+	if c.D[2] != 9 {
+		t.Error("expected a bus error for the write to ROM")
+	}
+	if last, _ := c.LastException(); last.Vector != cpu.VectorTrap0+15 {
+		t.Errorf("expected to halt with TRAP #15, got %s", last)
+	}
+}
+
+// TestAddressMask checks that addresses wrap at 16 MiB on a 68000 and reach
+// the bus unchanged with a 32-bit mask.
+func TestAddressMask(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	move.l	#$CAFEBABE,d0
+	move.l	d0,$01000010
+	move.l	$FF000010,d1
+	trap	#15
+handler:
+	moveq	#-1,d2
+	trap	#15
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	run := func(mask uint32) *cpu.CPU {
+		c := cpu.New(0x10000, 0)
+		c.AddressMask = mask
+		copy(c.Mem[0x400:], code)
+		c.WriteU32(cpu.VectorBusError*4, asm.Labels()["handler"])
+		c.A[7] = 0x1000
+		c.PC = 0x400
+		c.Running = true
+		for c.Running {
+			if err := c.Execute(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+
+	c := run(cpu.Address24)
+	if c.ReadU32(0x10) != 0xCAFEBABE || c.D[1] != 0xCAFEBABE || c.D[2] != 0 {
+		t.Errorf("expected the accesses to wrap to $10, got $%08X and d1=$%08X", c.ReadU32(0x10), c.D[1])
+	}
+	c = run(cpu.Address32)
+	if c.D[2] != 0xFFFFFFFF || c.ReadU32(0x10) != 0 {
+		t.Error("expected a bus error with 32-bit addresses")
+	}
+}
+
+// TestSetModelAddressMask checks that SetModel gives each model its address
+// bus and that the mask can still be set afterwards.
+func TestSetModelAddressMask(t *testing.T) {
+	want := map[cpu.Model]uint32{
+		cpu.MC68000: cpu.Address24,
+		cpu.MC68010: cpu.Address24,
+		cpu.MC68020: cpu.Address32,
+		cpu.MC68040: cpu.Address32,
+		cpu.MC68060: cpu.Address32,
+	}
+	for m, mask := range want {
+		c := cpu.New(0x10000, 0)
+		c.SetModel(m)
+		if c.AddressMask != mask {
+			t.Errorf("%v: expected mask $%08X, got $%08X", m, mask, c.AddressMask)
+		}
+	}
+
+	// A 68EC020 has 24 address lines, so its accesses wrap.
+	c := cpu.New(0x10000, 0)
+	c.SetModel(cpu.MC68020)
+	c.AddressMask = cpu.Address24
+	c.D[0] = 0xCAFEBABE
+	c.WriteU16(0x400, 0x23C0) // move.l d0,$01000010
+	c.WriteU32(0x402, 0x01000010)
+	c.PC = 0x400
+	c.Running = true
+	if err := c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ReadU32(0x10) != 0xCAFEBABE {
+		t.Errorf("expected the write to wrap to $10 with the mask overridden, got $%08X", c.ReadU32(0x10))
+	}
+}
+
+// TestWatchpoints checks that reads and writes of a watched range call back
+// and pause after the instruction.
+func TestWatchpoints(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	move.l	$2000,d0
+	move.w	#5,$2002
+	move.b	$1fff,d1
+	addq.l	#1,d0
+	trap	#15
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	c := cpu.New(0x10000, 0)
+	copy(c.Mem[0x400:], code)
+	c.WriteU32(0x2000, 0x12345678)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+
+	var reads []cpu.WatchEvent
+	c.AddWatchpoint(0x2000, 4, cpu.WatchRead, func(_ *cpu.CPU, e cpu.WatchEvent) bool {
+		reads = append(reads, e)
+		return false
+	})
+	id := c.AddWatchpoint(0x2003, 1, cpu.WatchWrite, nil)
+
+	c.Running = true
+	var hits []*cpu.WatchpointHit
+	for c.Running {
+		err := c.Execute()
+		var hit *cpu.WatchpointHit
+		if errors.As(err, &hit) {
+			hits = append(hits, hit)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(reads) != 1 || reads[0].Addr != 0x2000 || reads[0].Value != 0x12345678 || reads[0].PC != 0x400 {
+		t.Errorf("expected one long read of $2000, got %v", reads)
+	}
+	if len(hits) != 1 || hits[0].ID != id || !hits[0].Write || hits[0].Value != 5 || hits[0].Size != 2 {
+		t.Fatalf("expected to pause on the word write, got %v", hits)
+	}
+	if c.D[0] != 0x12345679 {
+		t.Error("execution didn't continue after the pause")
+	}
+	if !c.RemoveWatchpoint(id) || c.RemoveWatchpoint(id) {
+		t.Error("expected the watchpoint to be removed once")
+	}
+}
+
+// TestMemory checks the host accessors report errors instead of faulting and
+// only report to watchpoints when asked.
+func TestMemory(t *testing.T) {
+	c := cpu.New(0x1000, 0)
+	mem := c.Memory()
+	if err := mem.WriteS16(0x100, -2); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadS16(0x100); err != nil || v != -2 {
+		t.Errorf("expected -2, got %d (%v)", v, err)
+	}
+	if v, _ := mem.ReadU8(0x101); v != 0xFE {
+		t.Errorf("expected $FE, got $%02X", v)
+	}
+	if err := mem.WriteS32(0x102, -0x12345678); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mem.ReadU32(0x102); v != 0xEDCBA988 {
+		t.Errorf("expected $EDCBA988, got $%08X", v)
+	}
+	// Odd addresses are fine for the host.
+	if v, err := mem.ReadU16(0x101); err != nil || v != 0xFEED {
+		t.Errorf("expected $FEED, got $%04X (%v)", v, err)
+	}
+
+	// The last bytes of memory, and past them.
+	if err := mem.WriteU16(0xFFE, 0xBEEF); err != nil {
+		t.Error(err)
+	}
+	if _, err := mem.ReadU32(0xFFE); !errors.Is(err, cpu.ErrUnmapped) {
+		t.Errorf("expected ErrUnmapped for a long straddling the end, got %v", err)
+	}
+	if err := mem.WriteU8(0x1000, 1); err == nil || !strings.Contains(err.Error(), "$00001000") {
+		t.Errorf("expected an error naming $00001000, got %v", err)
+	}
+	b := make([]byte, 4)
+	if err := mem.ReadBytes(0xFFE, b); err == nil {
+		t.Error("expected ReadBytes past the end to fail")
+	}
+	if err := mem.WriteBytes(0x200, []byte("m68k")); err != nil {
+		t.Fatal(err)
+	}
+	if mem.ReadBytes(0x200, b); string(b) != "m68k" {
+		t.Errorf("expected m68k, got %q", b)
+	}
+
+	var seen []cpu.WatchEvent
+	c.AddWatchpoint(0x200, 4, cpu.WatchWrite, func(_ *cpu.CPU, e cpu.WatchEvent) bool {
+		seen = append(seen, e)
+		return true
+	})
+	mem.WriteU8(0x200, 1)
+	if len(seen) != 0 {
+		t.Errorf("expected Memory to bypass watchpoints, got %v", seen)
+	}
+	c.WatchedMemory().WriteU16(0x202, 7)
+	if len(seen) != 1 || seen[0].Addr != 0x202 || seen[0].Value != 7 {
+		t.Errorf("expected WatchedMemory to report the write, got %v", seen)
+	}
+	// Outside Execute nothing is paused, so the next instruction runs freely.
+	c.WriteU16(0x400, 0x4E71) // nop
+	c.PC, c.Running = 0x400, true
+	if err := c.Execute(); err != nil {
+		t.Errorf("expected no pause, got %v", err)
+	}
+}
+
+// TestBreakpoints runs to a breakpoint, continues over it and stops for
+// watchpoints, halts and cancellation.
+func TestBreakpoints(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	moveq	#0,d0
+loop:
+	addq.l	#1,d0
+	cmp.l	#3,d0
+	bne	loop
+	move.l	d0,$2000
+	trap	#15
+spin:
+	bra	spin
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	c := cpu.New(0x10000, 0)
+	copy(c.Mem[0x400:], code)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	loop := asm.Labels()["loop"]
+	c.AddBreakpoint(loop)
+	c.AddWatchpoint(0x2000, 4, cpu.WatchWrite, nil)
+
+	ctx := context.Background()
+	var counts []uint32
+	for {
+		reason, err := c.RunUntil(ctx)
+		if reason != cpu.BreakBreakpoint {
+			if reason != cpu.BreakWatchpoint || err == nil {
+				t.Fatalf("expected the watchpoint, got %s: %v", reason, err)
+			}
+			break
+		}
+		if c.PC != loop {
+			t.Fatalf("stopped at $%X instead of the breakpoint", c.PC)
+		}
+		counts = append(counts, c.D[0])
+	}
+	if !slices.Equal(counts, []uint32{0, 1, 2}) {
+		t.Errorf("expected to stop with d0 = 0, 1 and 2, got %v", counts)
+	}
+	if reason, _ := c.Step(); reason != cpu.BreakHalted {
+		t.Errorf("expected TRAP #15 to halt, got %s", reason)
+	}
+
+	c.PC = asm.Labels()["spin"]
+	c.Running = true
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if reason, err := c.RunUntil(ctx); reason != cpu.BreakCanceled || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the loop to be canceled, got %s: %v", reason, err)
+	}
+}
+
+func TestExecuteHooks(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	moveq	#2,d0
+loop:
+	subq.l	#1,d0
+	bne	loop
+	trap	#15
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	c := cpu.New(0x10000, 16)
+	copy(c.Mem[0x400:], code)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+
+	var before, after []uint32
+	c.OnBeforeExecute = func(pc uint32, opcode uint16) {
+		if opcode != binary.BigEndian.Uint16(c.Mem[pc:]) {
+			t.Errorf("wrong opcode %04X for $%X", opcode, pc)
+		}
+		before = append(before, pc)
+	}
+	c.OnAfterExecute = func(pc uint32, inst *cpu.DecodedInstruction) {
+		if inst == nil || inst.Handler == nil {
+			t.Errorf("no decoded instruction for $%X", pc)
+		}
+		after = append(after, pc)
+	}
+	for c.Running {
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []uint32{0x400, 0x402, 0x404, 0x402, 0x404, 0x406}
+	if !slices.Equal(before, want) || !slices.Equal(after, want) {
+		t.Errorf("expected hooks at %X, got %X before and %X after", want, before, after)
+	}
+}
+
+// TestReadModifyWrite checks that instructions which read and write the same
+// <ea> resolve it once, consuming extension words and stepping (An)+ a single
+// time.
+func TestReadModifyWrite(t *testing.T) {
+	c := runProgram(t, `
+	lea	$800,a0
+	move.l	#10,(a0)
+	move.l	#20,4(a0)
+	addq.l	#1,(a0)+
+	add.l	#5,(a0)
+	lea	$800,a1
+	moveq	#3,d1
+	add.l	d1,4(a1)
+	not.l	4(a1)
+	moveq	#0,d2
+	add.l	d1,(a1,d2.w)
+	subi.w	#1,$802
+	or.b	d1,-(a0)
+	move.l	(a1),d3
+	move.l	4(a1),d4
+	move.l	value(pc),d5
+	trap	#15
+value:
+	dc.l	$12345678
+`)
+	if c.A[0] != 0x803 || c.D[3] != 15 || int32(c.D[4]) != -29 || c.D[5] != 0x12345678 {
+		t.Errorf("got a0=$%X d3=%d d4=%d d5=$%X, expected a0=$803 d3=15 d4=-29 d5=$12345678",
+			c.A[0], c.D[3], int32(c.D[4]), c.D[5])
+	}
+	if c.Mem[0x803] != 0x0F {
+		t.Errorf("expected OR to -(a0) to write $0F, got $%02X", c.Mem[0x803])
+	}
+}
+
+// TestModels checks MOVE16 and the traps the 68040 and 68060 take for
+// instructions they leave to software.
+func TestModels(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	machine	68060
+	lea	$806,a0
+	lea	$900,a1
+	move16	(a0)+,(a1)+
+	move16	$800,(a1)
+	fnop
+movep:
+	movep.w	d0,0(a0)
+	trap	#15
+line_f:
+	moveq	#1,d6
+	addq.l	#4,2(a7)
+	rte
+unimplemented:
+	moveq	#2,d7
+	addq.l	#4,2(a7)
+	rte
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	labels := asm.Labels()
+
+	// run executes the program as model m until it halts or reaches stop.
+	run := func(m cpu.Model, stop uint32) *cpu.CPU {
+		c := cpu.New(0x1000, 16)
+		c.SetModel(m)
+		copy(c.Mem[0x400:], code)
+		for i := range 16 {
+			c.Mem[0x800+i] = byte(i + 1)
+		}
+		c.WriteU32(cpu.VectorLineF*4, labels["line_f"])
+		c.WriteU32(cpu.VectorUnimplementedInteger*4, labels["unimplemented"])
+		c.A[7] = 0x1000
+		c.PC = 0x400
+		c.Running = true
+		for steps := 0; c.Running && c.PC != stop && steps < 100; steps++ {
+			if err := c.Execute(); err != nil {
+				t.Fatalf("%s: execution failed at PC=%08X: %v", m, c.PC, err)
+			}
+		}
+		return c
+	}
+
+	c := run(cpu.MC68060, 0)
+	if c.A[0] != 0x816 || c.A[1] != 0x910 {
+		t.Errorf("expected both registers to advance by 16, got A0=%08X A1=%08X", c.A[0], c.A[1])
+	}
+	if !slices.Equal(c.Mem[0x900:0x910], c.Mem[0x800:0x810]) || !slices.Equal(c.Mem[0x910:0x920], c.Mem[0x800:0x810]) {
+		t.Errorf("expected aligned lines to be copied, got % X", c.Mem[0x900:0x920])
+	}
+	if c.D[6] != 1 || c.D[7] != 2 {
+		t.Errorf("expected the line 1111 and unimplemented integer traps, got D6=%d D7=%d", c.D[6], c.D[7])
+	}
+
+	c = run(cpu.MC68040, labels["movep"])
+	if c.D[6] != 1 || c.A[0] != 0x816 {
+		t.Errorf("expected a 68040 to run MOVE16 and trap FNOP, got D6=%d A0=%08X", c.D[6], c.A[0])
+	}
+	if name := cpu.MC68060.String(); name != "68060" {
+		t.Errorf("expected 68060, got %s", name)
+	}
+	if m, err := cpu.ParseModel("MC68040"); err != nil || m != cpu.MC68040 {
+		t.Errorf("expected MC68040 to parse, got %v, %v", m, err)
+	}
+}
+
+func TestModel68010(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	machine	68010
+	lea	$800,a0
+	movec	a0,vbr
+	movec	vbr,d0
+	moveq	#-1,d1
+	movec	d1,sfc
+	movec	sfc,d2
+	move.l	#$12345678,d3
+	lea	$a00,a1
+	moves.l	d3,(a1)
+	moves.w	(a1),a2
+	move.l	a7,d4
+	move.l	#1,-(a7)
+	bsr.s	sub
+	sub.l	a7,d4
+	trap	#0
+	tst.w	$2000
+resume:
+	move.w	#$3000,-(a7)
+	pea	user(pc)
+	move.w	sr,-(a7)
+	rte
+user:
+	move	#$15,ccr
+	move	ccr,4(a1)
+	move	sr,d7
+done:
+	nop
+sub:
+	rtd	#4
+trap0:
+	move.w	6(a7),d5
+	rte
+bus:
+	move.w	6(a7),8(a1)
+	lea	58(a7),a7
+	bra.s	resume
+format:
+	move.w	6(a7),d6
+	lea	16(a7),a7
+	lea	$c00,a3
+	move.l	a3,usp
+	andi.w	#$dfff,sr
+	bra.s	user
+priv:
+	moveq	#1,d7
+	addq.l	#2,2(a7)
+	rte
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	labels := asm.Labels()
+
+	c := cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68010)
+	copy(c.Mem[0x400:], code)
+	c.WriteU32(0x800+cpu.VectorTrap0*4, labels["trap0"])
+	c.WriteU32(0x800+cpu.VectorBusError*4, labels["bus"])
+	c.WriteU32(0x800+cpu.VectorFormatError*4, labels["format"])
+	c.WriteU32(0x800+cpu.VectorPrivilegeViolation*4, labels["priv"])
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	for steps := 0; c.Running && c.PC != labels["done"] && steps < 100; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed at PC=%08X: %v", c.PC, err)
+		}
+	}
+	if c.PC != labels["done"] {
+		t.Fatalf("expected to reach done, stopped at PC=%08X", c.PC)
+	}
+	if c.VBR != 0x800 || c.D[0] != 0x800 {
+		t.Errorf("expected MOVEC to set and read VBR, got VBR=%08X D0=%08X", c.VBR, c.D[0])
+	}
+	if c.SFC != 7 || c.D[2] != 7 {
+		t.Errorf("expected SFC to keep three bits, got SFC=%d D2=%d", c.SFC, c.D[2])
+	}
+	if c.ReadU32(0xa00) != 0x12345678 || c.A[2] != 0x1234 {
+		t.Errorf("expected MOVES to store and load, got %08X A2=%08X", c.ReadU32(0xa00), c.A[2])
+	}
+	if c.D[4] != 0 {
+		t.Errorf("expected RTD to drop the argument, A7 is off by %d", int32(c.D[4]))
+	}
+	if c.D[5] != cpu.VectorTrap0*4 {
+		t.Errorf("expected a format $0 word for TRAP #0, got %04X", c.D[5])
+	}
+	if w := c.ReadU16(0xa08); w != 0x8000|cpu.VectorBusError*4 {
+		t.Errorf("expected a format $8 word for the bus error, got %04X", w)
+	}
+	if c.D[6] != cpu.VectorFormatError*4 {
+		t.Errorf("expected RTE to take a format error, got %04X", c.D[6])
+	}
+	if c.ReadU16(0xa04) != 0x15 {
+		t.Errorf("expected MOVE from CCR to store the flags, got %04X", c.ReadU16(0xa04))
+	}
+	if c.D[7] != 1 {
+		t.Errorf("expected MOVE from SR to be privileged in user mode, got D7=%d", c.D[7])
+	}
+	if c.A[7] != 0xc00 || c.SSP != 0x1000 {
+		t.Errorf("expected every frame to be popped, got USP=%08X SSP=%08X", c.A[7], c.SSP)
+	}
+}
+
+// TestModel68020 runs the 68020 addressing modes and checks that a 68000
+// ignores the scale bits of a brief extension word.
+func TestModel68020(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	machine	68020
+	lea	$800,a0
+	moveq	#2,d1
+	move.w	(4,a0,d1.w*4),d0
+	move.w	([4,a0],d1.l*4,8),d2
+	move.w	([0,a0,d1.l*4],2),d3
+	move.w	($a04,za0,d1.w),d4
+pcrel:
+	lea	(8,pc,d1.w*2),a1
+ind:
+	move.w	([$100,pc]),d5
+done:
+	nop
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	labels := asm.Labels()
+
+	c := cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68020)
+	copy(c.Mem[0x400:], code)
+	c.WriteU16(0x80c, 0x1234)
+	c.WriteU32(0x804, 0x900)
+	c.WriteU16(0x910, 0x5678)
+	c.WriteU32(0x808, 0xa00)
+	c.WriteU16(0xa02, 0x9abc)
+	c.WriteU16(0xa06, 0xdef0)
+	c.WriteU16(0xa08, 0x4321)
+	c.WriteU32(labels["ind"]+2+0x100, 0xa08)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	for steps := 0; c.Running && c.PC != labels["done"] && steps < 20; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed at PC=%08X: %v", c.PC, err)
+		}
+	}
+	if c.PC != labels["done"] {
+		t.Fatalf("expected to reach done, stopped at PC=%08X", c.PC)
+	}
+	if c.D[0] != 0x1234 {
+		t.Errorf("expected a scaled index, got D0=%08X", c.D[0])
+	}
+	if c.D[2] != 0x5678 {
+		t.Errorf("expected post-indexed memory indirect, got D2=%08X", c.D[2])
+	}
+	if c.D[3] != 0x9abc {
+		t.Errorf("expected pre-indexed memory indirect, got D3=%08X", c.D[3])
+	}
+	if c.D[4] != 0xdef0 {
+		t.Errorf("expected a suppressed base register, got D4=%08X", c.D[4])
+	}
+	if c.A[1] != labels["pcrel"]+2+8+4 {
+		t.Errorf("expected a scaled PC index, got A1=%08X", c.A[1])
+	}
+	if c.D[5] != 0x4321 {
+		t.Errorf("expected PC memory indirect, got D5=%08X", c.D[5])
+	}
+
+	// move.w (4,a0,d1.w*4),d0 on a 68000 leaves the index unscaled.
+	c = cpu.New(0x1000, 16)
+	copy(c.Mem[0x400:], []byte{0x30, 0x30, 0x14, 0x04})
+	c.A[0] = 0x800
+	c.D[1] = 2
+	c.WriteU16(0x806, 0x1111)
+	c.PC = 0x400
+	c.Running = true
+	if err := c.Execute(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if c.D[0] != 0x1111 {
+		t.Errorf("expected the 68000 to ignore the scale, got D0=%08X", c.D[0])
+	}
+}
+
+// TestModel68020Instructions runs the instructions the 68020 added.
+func TestModel68020Instructions(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	machine	68020
+	moveq	#-128,d0
+	extb.l	d0
+	move.l	#$10000,d1
+	move.l	d1,d2
+	mulu.l	d1,d2
+	move.l	d1,d3
+	mulu.l	d1,d4:d3
+	move.l	#-7,d5
+	moveq	#2,d6
+	divsl.l	d6,d7:d5
+	moveq	#0,d0
+	move.l	#$20000,d1
+	divu.l	#2,d0:d1
+	lea	$800,a0
+	moveq	#5,d2
+	moveq	#9,d3
+	cas.w	d2,d3,(a0)
+	move.w	(a0),d4
+	moveq	#7,d5
+	cas.w	d5,d3,(a0)
+	move.w	#$0304,d6
+	pack	d6,d6,#0
+	unpk	d6,d7,#$3030
+	trapeq
+	bfextu	$810{4:8},d2
+	bfins	d3,$810{0:4}
+	moveq	#0,d6
+	bfffo	d6{0:32},d5
+	bfset	d6{8:8}
+done:
+	nop
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	c := cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68020)
+	copy(c.Mem[0x400:], code)
+	c.WriteU16(0x800, 5)
+	c.WriteU16(0x810, 0xABCD)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	done := asm.Labels()["done"]
+	for steps := 0; c.Running && c.PC != done && steps < 50; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed at PC=%08X: %v", c.PC, err)
+		}
+	}
+	if c.PC != done {
+		t.Fatalf("expected to reach done, stopped at PC=%08X", c.PC)
+	}
+	if c.D[0] != 0 || c.D[1] != 0x10000 {
+		t.Errorf("expected DIVU.L of a 64-bit dividend, got D0=%08X D1=%08X", c.D[0], c.D[1])
+	}
+	if c.D[2] != 0xBC {
+		t.Errorf("expected BFEXTU to extract $BC, got D2=%08X", c.D[2])
+	}
+	if c.ReadU16(0x810) != 0x9BCD {
+		t.Errorf("expected BFINS to write $9BCD, got %04X", c.ReadU16(0x810))
+	}
+	if c.D[4] != 9 {
+		t.Errorf("expected CAS to store Du, got D4=%08X", c.D[4])
+	}
+	if c.D[5] != 32 {
+		t.Errorf("expected BFFFO of zero to give offset+width, got D5=%08X", c.D[5])
+	}
+	if c.D[6] != 0x00FF0000 {
+		t.Errorf("expected BFSET to set bits 23-16, got D6=%08X", c.D[6])
+	}
+	if c.D[7]&0xFFFF != 0x3334 {
+		t.Errorf("expected PACK and UNPK to round trip, got D7=%08X", c.D[7])
+	}
+
+	// Check the intermediate results one instruction at a time.
+	c = cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68020)
+	copy(c.Mem[0x400:], code)
+	c.WriteU16(0x800, 5)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	step := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := c.Execute(); err != nil {
+				t.Fatalf("execution failed at PC=%08X: %v", c.PC, err)
+			}
+		}
+	}
+	step(2)
+	if c.D[0] != 0xFFFFFF80 {
+		t.Errorf("expected EXTB.L to sign extend, got D0=%08X", c.D[0])
+	}
+	step(3)
+	if c.D[2] != 0 || c.SR&cpu.SRV == 0 {
+		t.Errorf("expected MULU.L to overflow, got D2=%08X SR=%04X", c.D[2], c.SR)
+	}
+	step(2)
+	if c.D[4] != 1 || c.D[3] != 0 {
+		t.Errorf("expected a 64-bit product, got D4:D3=%08X:%08X", c.D[4], c.D[3])
+	}
+	step(3)
+	if int32(c.D[5]) != -3 || int32(c.D[7]) != -1 {
+		t.Errorf("expected DIVSL.L to give -3 remainder -1, got D5=%08X D7=%08X", c.D[5], c.D[7])
+	}
+	step(7)
+	if c.SR&cpu.SRZ == 0 {
+		t.Error("expected CAS to set Z when the compare matched")
+	}
+	step(3)
+	if c.D[5] != 9 || c.SR&cpu.SRZ != 0 {
+		t.Errorf("expected a failed CAS to load the operand, got D5=%08X", c.D[5])
+	}
+	step(2)
+	if c.D[6]&0xFF != 0x34 {
+		t.Errorf("expected PACK to give $34, got D6=%08X", c.D[6])
+	}
+
+	// TRAPcc takes the TRAPV vector when its condition holds.
+	c = cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68020)
+	c.WriteU16(0x400, 0x50FC) // trapt
+	c.WriteU32(uint32(cpu.VectorTRAPV)*4, 0x600)
+	c.SR = 0x2000
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	if err := c.Execute(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if c.PC != 0x600 {
+		t.Errorf("expected TRAPT to take the TRAPV vector, got PC=%08X", c.PC)
+	}
+}
+
+// TestFPU runs a 68881 attached to a 68020: arithmetic, conversions to and
+// from memory, condition codes and FBcc, and an enabled exception.
+func TestFPU(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	machine	68020
+	fpu
+	fmove.d	#1.5,fp0
+	fadd.s	#2.25,fp0
+	fmove.l	#10,fp1
+	fmul.x	fp0,fp1
+	fmove.x	fp1,fp2
+	fsub.w	#40,fp2
+	fmove.l	#1,fp3
+	fdiv.l	#3,fp3
+	fmove.s	fp3,$800
+	fmove.d	fp3,$808
+	fmove.p	fp0,$810{#17}
+	fmove.p	$810,fp4
+	fsqrt.l	#2,fp5
+	fcmp.x	fp0,fp1
+	fbgt	greater
+	moveq	#1,d0
+greater:
+	fmove.l	fp1,d1
+	fmove.l	fpsr,d2
+	fmove.l	#0,fp6
+	fdiv.x	fp6,fp0
+	fmove.l	fpsr,d3
+	fmovem.x	fp0-fp1,-(a7)
+	fmove.l	#$0400,fpcr
+	fdiv.x	fp6,fp1
+done:
+	nop
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	c := cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68020)
+	fpu := cpu.NewFPU()
+	c.SetCoprocessor(1, fpu)
+	copy(c.Mem[0x400:], code)
+	c.WriteU32(uint32(cpu.VectorFPDZ)*4, 0x900)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	for steps := 0; c.Running && c.PC != 0x900 && steps < 50; steps++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("execution failed at PC=%08X: %v", c.PC, err)
+		}
+	}
+	if c.PC != 0x900 {
+		t.Fatalf("expected the enabled divide by zero to take vector 50, stopped at PC=%08X", c.PC)
+	}
+	if c.ReadU32(c.A[7]+2) != asm.Labels()["done"] {
+		t.Errorf("expected the exception to stack the next instruction, got %08X", c.ReadU32(c.A[7]+2))
+	}
+
+	for _, tc := range []struct {
+		n    int
+		want float64
+	}{{1, 37.5}, {2, -2.5}, {4, 3.75}, {5, math.Sqrt2}, {6, 0}} {
+		if got := fpu.Float64(tc.n); got != tc.want {
+			t.Errorf("expected FP%d=%v, got %v", tc.n, tc.want, got)
+		}
+	}
+	if !fpu.FP[0].IsInf() || fpu.FP[0].Sign {
+		t.Errorf("expected 3.75/0 to give +Inf, got %v", fpu.FP[0])
+	}
+	if got := fpu.FP[3].Words(); got != [6]uint16{0x3FFD, 0, 0xAAAA, 0xAAAA, 0xAAAA, 0xAAAB} {
+		t.Errorf("expected 1/3 rounded to extended precision, got %04X", got)
+	}
+	if got := c.ReadU32(0x800); got != math.Float32bits(1.0/3) {
+		t.Errorf("expected 1/3 as a single, got %08X", got)
+	}
+	if got := uint64(c.ReadU32(0x808))<<32 | uint64(c.ReadU32(0x80C)); got != math.Float64bits(1.0/3) {
+		t.Errorf("expected 1/3 as a double, got %016X", got)
+	}
+	if c.ReadU32(0x810) != 0x00000003 || c.ReadU32(0x814) != 0x75000000 || c.ReadU32(0x818) != 0 {
+		t.Errorf("expected 3.75 in packed decimal, got %08X %08X", c.ReadU32(0x810), c.ReadU32(0x814))
+	}
+	if c.D[0] != 0 || c.D[1] != 38 {
+		t.Errorf("expected FBGT taken and 37.5 rounded to even 38, got D0=%d D1=%d", c.D[0], c.D[1])
+	}
+	if c.D[2]&cpu.FPExcINEX2 == 0 {
+		t.Errorf("expected FMOVE.L of 37.5 to be inexact, got FPSR=%08X", c.D[2])
+	}
+	if c.D[3]&(cpu.FPCCI|cpu.FPExcDZ) != cpu.FPCCI|cpu.FPExcDZ || c.D[3]&0x10 == 0 {
+		t.Errorf("expected an infinity, DZ and accrued DZ, got FPSR=%08X", c.D[3])
+	}
+	if got := cpu.ExtendedFromWords([6]uint16{c.ReadU16(c.A[7] + 20), 0, c.ReadU16(c.A[7] + 24), c.ReadU16(c.A[7] + 26), c.ReadU16(c.A[7] + 28), c.ReadU16(c.A[7] + 30)}); got.Float64() != 37.5 {
+		t.Errorf("expected FMOVEM to store FP1 above FP0, got %v", got)
+	}
+
+	// Without an FPU the same instructions take the line 1111 exception.
+	c = cpu.New(0x1000, 16)
+	c.SetModel(cpu.MC68020)
+	copy(c.Mem[0x400:], code)
+	c.Unimplemented = cpu.UnimplementedException
+	c.WriteU32(uint32(cpu.VectorLineF)*4, 0x900)
+	c.A[7] = 0x1000
+	c.PC = 0x400
+	c.Running = true
+	if err := c.Execute(); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if c.PC != 0x900 || c.ReadU32(c.A[7]+2) != 0x400 {
+		t.Errorf("expected the line 1111 exception for $400, got PC=%08X", c.PC)
+	}
+}
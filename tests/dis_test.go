@@ -2,6 +2,10 @@ package assembler_test
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/Urethramancer/m68k/assembler"
@@ -96,8 +100,8 @@ func TestAddqSubq(t *testing.T) {
 func TestMovem(t *testing.T) {
 	// Opcode for: movem.l <reglist>,-(a7)
 	op := uint16(0x48E7)
-	// Register mask for d0-d5, which is 0x003F
-	code := []byte{0x00, 0x3F}
+	// Register mask for d0-d5, reversed for predecrement: 0xFC00
+	code := []byte{0xFC, 0x00}
 
 	mn, ops, used := disassembler.TestableDecode(op, 0, code)
 
@@ -175,7 +179,7 @@ func TestLogicalRegister(t *testing.T) {
 		ops  string
 	}{
 		// AND
-		{0xC141, "and.w", "d0,d1"},   // Dn -> Dn
+		{0xC240, "and.w", "d0,d1"},   // Dn -> Dn
 		{0xC041, "and.w", "d1,d0"},   // Dn <- Dn
 		{0xC150, "and.w", "d0,(a0)"}, // Dn -> (An)
 		{0xC050, "and.w", "(a0),d0"}, // (An) -> Dn
@@ -548,3 +552,899 @@ func TestMoveSystemRegisters(t *testing.T) {
 		})
 	}
 }
+
+// TestStrings checks that printable opcodes in reachable code are not reported as text.
+func TestStrings(t *testing.T) {
+	code := []byte{
+		0x70, 0x41, // moveq #65,d0 ("pA")
+		0x70, 0x42, // moveq #66,d0 ("pB")
+		0x4E, 0x75, // rts ("Nu")
+	}
+	code = append(code, "Hello!\x00\x00ab\x00"...)
+
+	list := disassembler.Strings(code, 4)
+	if len(list) != 1 {
+		t.Fatalf("expected 1 string, got %+v", list)
+	}
+	if s := list[0]; s.Address != 6 || s.Text != "Hello!" || !s.Terminated {
+		t.Errorf("unexpected string: %+v", s)
+	}
+
+	want := "string1: dc.b    'Hello!',$00\t; $00000006\n"
+	if got := disassembler.FormatStrings(list, true); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+// TestClassifyRegions checks code, text, fill and high-entropy blocks.
+func TestClassifyRegions(t *testing.T) {
+	code := make([]byte, 0, 256)
+	for i := 0; i < 31; i++ {
+		code = append(code, 0x4E, 0x71) // nop
+	}
+	code = append(code, 0x4E, 0x75) // rts
+	code = append(code, strings.Repeat("The quick brown fox. ", 4)[:64]...)
+	code = append(code, make([]byte, 64)...)
+	x := uint32(1)
+	for i := 0; i < 64; i++ {
+		x = x*1103515245 + 12345
+		code = append(code, byte(x>>16))
+	}
+	// A 64-byte block tops out at 6 bits/byte, so random bytes read as data, not compressed.
+	regions := disassembler.ClassifyRegions(code, 64)
+	kinds := make([]string, len(regions))
+	for i, r := range regions {
+		kinds[i] = r.Kind.String()
+	}
+	if got := strings.Join(kinds, ","); got != "code,text,fill,data" {
+		t.Errorf("unexpected classification %s", got)
+	}
+	if e := disassembler.Entropy([]byte{0, 1, 2, 3}); e != 2 {
+		t.Errorf("expected entropy 2, got %f", e)
+	}
+}
+
+// TestAmigaProfile checks custom register names, bitfields and copper list detection.
+func TestAmigaProfile(t *testing.T) {
+	code := []byte{
+		0x4B, 0xF9, 0x00, 0xDF, 0xF0, 0x00, // lea $dff000.l,a5
+		0x3B, 0x7C, 0x83, 0x80, 0x00, 0x96, // move.w #$8380,($96,a5)
+		0x30, 0x2D, 0x00, 0x06, // move.w (6,a5),d0
+		0x4E, 0x75, // rts
+		0x01, 0x80, 0x0F, 0xFF, // copper: move $fff to COLOR00
+		0x2C, 0x01, 0xFF, 0xFE, // copper: wait
+		0xFF, 0xFF, 0xFF, 0xFE, // copper: end
+	}
+	text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Profile: "amiga"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"; DMACON = SET DMAEN|BPLEN|COPEN\n",
+		"; VHPOSR\n",
+		"$0180,$0fff\t; COLOR00 = rgb $fff\n",
+		"; wait v=$2c h=$00\n",
+		"; end of list\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+
+	if _, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Profile: "c64"}); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+// TestGenesisProfile checks VDP register writes, address commands and data port targets.
+func TestGenesisProfile(t *testing.T) {
+	code := []byte{
+		0x4B, 0xF9, 0x00, 0xC0, 0x00, 0x04, // lea $c00004.l,a5
+		0x3A, 0xBC, 0x81, 0x74, // move.w #$8174,(a5)
+		0x2A, 0xBC, 0xC0, 0x00, 0x00, 0x00, // move.l #$c0000000,(a5)
+		0x33, 0xFC, 0x0E, 0xEE, 0x00, 0xC0, 0x00, 0x00, // move.w #$eee,$c00000.l
+		0x4E, 0x75, // rts
+	}
+	text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Profile: "genesis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"; VDP reg 1 (mode 2) = $74 DISP|IE0|DMA\n",
+		"; VDP CRAM write $0000\n",
+		"; VDP data -> CRAM write $0000\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+}
+
+// Test68060Profile checks the pairing and latency notes.
+func Test68060Profile(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+loop:
+	move.l	(a0)+,d0
+	add.l	d1,d2
+	add.l	d0,d3
+	mulu.w	d0,d4
+	add.w	d4,d5
+	subq.l	#1,d6
+	bne	loop
+	rts
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Profile: "68060"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"(a0)+,d0                 ; pOEP, latency 1\n",
+		"d1,d2                    ; sOEP, paired, latency 1\n",
+		"d0,d3                    ; pOEP, latency 1\n",
+		"d0,d4                    ; pOEP only, latency 2\n",
+		"d4,d5                    ; pOEP, latency 1, waits for d4\n",
+		"#1,d6                    ; sOEP, paired, latency 1\n",
+		"rts                               ; pOEP only, latency 1\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+}
+
+// TestSystemCallProfiles checks that the CP/M-68K and GEMDOS profiles name
+// the calls TRAP #2 and TRAP #1 make, with their arguments.
+func TestSystemCallProfiles(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	moveq	#9,d0
+	move.l	a0,d1
+	trap	#2
+	move.w	#$ff,d1
+	moveq	#6,d0
+	trap	#2
+	move.w	#2,-(a7)
+	pea	(a1)
+	move.w	#$3d,-(a7)
+	trap	#1
+	addq.l	#8,a7
+	move.l	a2,-(a7)
+	move.l	#16,-(a7)
+	move.w	d0,-(a7)
+	move.w	#$40,-(a7)
+	trap	#1
+	lea	12(a7),a7
+	clr.w	-(a7)
+	trap	#1
+	trap	#1
+	rts
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		profile string
+		want    []string
+	}{
+		{"cpm68k", []string{
+			"; BDOS 9 C_WRITESTR(string=a0)\n",
+			"; BDOS 6 C_RAWIO(char=#255 input)\n",
+		}},
+		{"gemdos", []string{
+			"; GEMDOS $3d Fopen(name=(a1), mode=#2 read/write)\n",
+			"; GEMDOS $40 Fwrite(handle=d0, count=#$10, buffer=a2)\n",
+			"; GEMDOS $00 Pterm0()\n",
+			"; GEMDOS call\n",
+		}},
+	} {
+		text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Profile: tc.profile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s: missing %q in:\n%s", tc.profile, want, text)
+			}
+		}
+	}
+}
+
+// TestSymbols imports symbols in several formats and checks that they replace
+// the generated labels.
+func TestSymbols(t *testing.T) {
+	list := `
+# nm
+00001000 T _start
+00001004 t print
+         U _extern
+00000004 A SIZE
+Symbols of data:
+  _message: global reloc, value 0x100c, size 4
+  0x00001010 _unused: local abs, size 0
+ 0001:00001008       done
+count equ $1012
+$1014 Foo::bar(int)
+`
+	syms, err := disassembler.ParseSymbols(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := disassembler.Symbols{
+		0x1000: "_start",
+		0x1004: "print",
+		0x1008: "done",
+		0x100C: "_message",
+		0x1010: "_unused",
+		0x1012: "count",
+		0x1014: "Foo__bar_int_",
+	}
+	if len(syms) != len(want) {
+		t.Errorf("expected %d symbols, got %v", len(want), syms)
+	}
+	for addr, name := range want {
+		if syms[addr] != name {
+			t.Errorf("symbol at $%X: expected %s, got %q", addr, name, syms[addr])
+		}
+	}
+
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	bsr.s	sub
+	bra.s	done
+sub:
+	nop
+	rts
+done:
+	rts
+	dc.b	"text"
+`, 0x1000)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Symbols: syms.Relocate(0x1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"_start:\n", "bsr      print", "bra      done", "print:\n", "_message:\n"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("expected %q in the listing:\n%s", s, text)
+		}
+	}
+	if strings.Contains(text, "sub_0004") {
+		t.Errorf("expected the imported name to replace the generated one:\n%s", text)
+	}
+}
+
+// TestSignatures makes signatures from a routine and finds the runtime library
+// in an assembled program.
+func TestSignatures(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+routine:
+	move.l	$12345678,a6
+	jsr	-552(a6)
+	bsr	routine
+	bra	elsewhere
+	rts
+elsewhere:
+	nop
+`, 0x1000)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	sig := disassembler.NewSignature("routine", code[:asm.Labels()["elsewhere"]-0x1000], 0x1000, 0)
+	want := "routine 2C 79 ?? ?? ?? ?? 4E AE FD D8 61 F4 60 00 ?? ?? 4E 75"
+	if sig.String() != want {
+		t.Errorf("expected %s, got %s", want, sig)
+	}
+	sigs, err := disassembler.ParseSignatures(strings.NewReader("# test\n" + want + "\n"))
+	if err != nil || len(sigs) != 1 || sigs[0].String() != want {
+		t.Errorf("signature changed in a round trip: %v, %v", sigs, err)
+	}
+
+	code, err = assembler.New().Assemble(`
+	move.l	#100,d0
+	move.l	#7,d1
+	bsr	divmod32
+	lea	buf,a0
+	bsr	itoa
+	trap	#15
+	include	"runtime.i"
+buf:
+	ds.b	16
+`, 0)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{Signatures: disassembler.BuiltinSignatures()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"divmod32:\n", "itoa:\n", "utoa:\n", "bsr      divmod32"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("expected %q in the listing:\n%s", s, text)
+		}
+	}
+}
+
+func TestInstructionLength(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+start:
+	move.l	#$12345678,$00ff0000
+	movem.l	d0-d7/a0-a6,-(a7)
+	btst	#3,4(a0)
+	addi.w	#1,(a0,d1.w)
+	lea	12(pc),a1
+	dbra	d0,start
+	bra	start
+	link	a6,#-8
+	moveq	#1,d0
+	move.b	d0,$1234
+	rts
+`, 0)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	off := 0
+	for _, inst := range disassembler.DecodeRange(code, 0) {
+		n, err := disassembler.InstructionLength(code[off:])
+		if err != nil || n != int(inst.Size) {
+			t.Errorf("%s %s: expected length %d, got %d (%v)", inst.Mnemonic, inst.Operands, inst.Size, n, err)
+		}
+		off += int(inst.Size)
+	}
+	if off != len(code) {
+		t.Errorf("lengths cover %d bytes of %d", off, len(code))
+	}
+
+	if _, err := disassembler.InstructionLength([]byte{0x20, 0x3c, 0x00}); !errors.Is(err, disassembler.ErrTruncated) {
+		t.Errorf("expected a truncation error, got %v", err)
+	}
+	if _, err := disassembler.InstructionLength([]byte{0xff, 0xff}); !errors.Is(err, disassembler.ErrUnknownOpcode) {
+		t.Errorf("expected an unknown opcode error, got %v", err)
+	}
+}
+
+// TestAnalysis revises an analysis with annotations and checks only the
+// affected flows are re-run.
+func TestAnalysis(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+start:
+	bsr	sub1
+	lea	table(pc),a0
+	rts
+sub1:
+	moveq	#1,d0
+	rts
+handler:
+	moveq	#2,d0
+	bra	done
+done:
+	rts
+table:
+	dc.l	handler
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := asm.Labels()
+	sub1, handler, done := labels["sub1"], labels["handler"], labels["done"]
+
+	a := disassembler.NewAnalysis(code)
+	want, _ := disassembler.Disassemble(code)
+	if got, _ := a.Disassemble(disassembler.Options{}); got != want {
+		t.Errorf("expected the same listing as Disassemble:\n%s\ngot:\n%s", want, got)
+	}
+	if a.IsCode(handler) {
+		t.Error("expected the handler, only reached through the table, to be data")
+	}
+
+	a.AddEntry(handler)
+	if !a.IsCode(handler) || !a.IsCode(done) {
+		t.Error("expected the new entry point to be followed")
+	}
+	if kind, ok := a.Label(done); !ok || kind != disassembler.JumpTarget {
+		t.Errorf("expected a jump label at done, got %v, %v", kind, ok)
+	}
+	listing, _ := a.Disassemble(disassembler.Options{})
+	if !strings.Contains(listing, "moveq    #2,d0") {
+		t.Errorf("expected the handler in the listing:\n%s", listing)
+	}
+	decoded := a.Stats().Decoded
+
+	// Only the flow from 0 reaches sub1.
+	a.MarkData(sub1, sub1+4)
+	if s := a.Stats(); s.Runs != 3 {
+		t.Errorf("expected one flow re-run, got %d runs in all", s.Runs)
+	}
+	if a.IsCode(sub1) || !a.IsData(sub1) || !a.IsCode(handler) {
+		t.Error("expected sub1 to become data and the handler to stay code")
+	}
+	if _, ok := a.Label(sub1); !ok {
+		t.Error("expected the call to sub1 to keep its label")
+	}
+
+	a.ClearData(sub1, sub1+4)
+	if s := a.Stats(); s.Runs != 4 || s.Decoded != decoded {
+		t.Errorf("expected one re-run from the cache, got %+v (%d decoded before)", s, decoded)
+	}
+	if !a.IsCode(sub1) {
+		t.Error("expected sub1 to be code again")
+	}
+
+	a.RemoveEntry(handler)
+	if a.IsCode(handler) || len(a.Entries()) != 1 {
+		t.Errorf("expected the handler to be data again, with entries %v", a.Entries())
+	}
+	if got, _ := a.Disassemble(disassembler.Options{}); got != want {
+		t.Errorf("expected the original listing back, got:\n%s", got)
+	}
+}
+
+// TestLabelSchemes disassembles two revisions of a program, the second with
+// code inserted early on, and checks which names each scheme keeps.
+func TestLabelSchemes(t *testing.T) {
+	rev := func(extra string) []byte {
+		code, err := assembler.New().Assemble(`
+	bsr	print
+	`+extra+`
+	bsr	count
+	lea	msg(pc),a0
+	rts
+print:
+	move.b	(a0)+,d0
+	bne.s	print
+	rts
+count:
+	moveq	#0,d1
+count_loop:
+	addq.l	#1,d1
+	cmpi.l	#5,d1
+	bne.s	count_loop
+	rts
+msg:
+	dc.b	'Hello, world',0
+`, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	labels := func(code []byte, opts disassembler.Options) []string {
+		text, err := disassembler.DisassembleWithOptions(code, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, line := range strings.Split(text, "\n") {
+			if name, _, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, " ") {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	old, moved := rev("nop"), rev("nop\n\tnop\n\tmoveq\t#3,d2")
+
+	got := labels(old, disassembler.Options{Labels: disassembler.SequentialLabels})
+	if want := []string{"sub_1", "sub_2", "loc_1", "string1"}; !slices.Equal(got, want) {
+		t.Errorf("expected sequential labels %v, got %v", want, got)
+	}
+	got = labels(old, disassembler.Options{Labels: disassembler.AddressLabels})
+	if len(got) != 4 || got[0] != "sub_0010" || !strings.HasPrefix(got[3], "str_") {
+		t.Errorf("expected address labels, got %v", got)
+	}
+
+	hash := disassembler.Options{Labels: disassembler.HashLabels, LabelSeed: 7}
+	before, after := labels(old, hash), labels(moved, hash)
+	if !slices.Equal(before, after) || len(slices.Compact(slices.Sorted(slices.Values(before)))) != 4 {
+		t.Errorf("expected hash labels to survive the move, got %v and %v", before, after)
+	}
+	hash.LabelSeed = 8
+	if other := labels(old, hash); slices.Equal(before, other) {
+		t.Errorf("expected another seed to give other names, got %v", other)
+	}
+	if _, err := disassembler.ParseLabelScheme("nonsense"); err == nil {
+		t.Error("expected an error for an unknown scheme")
+	}
+}
+
+// TestConfidence checks the confidence of decoded words and the policies for
+// rendering reachable ones that do not decode.
+func TestConfidence(t *testing.T) {
+	// moveq #1,d0, an undecodable word, rts and then a nop that is never reached.
+	code := []byte{0x70, 0x01, 0xFF, 0xFF, 0x4E, 0x75, 0x4E, 0x71}
+	want := map[uint32]disassembler.Confidence{
+		0: disassembler.ConfidenceReachable,
+		2: disassembler.ConfidenceIllegal,
+		4: disassembler.ConfidenceReachable,
+		6: disassembler.ConfidenceData,
+	}
+	list := disassembler.Instructions(code)
+	if len(list) != len(want) {
+		t.Fatalf("expected %d instructions, got %d", len(want), len(list))
+	}
+	for _, inst := range list {
+		if got := inst.Confidence(); got != want[inst.Address] {
+			t.Errorf("$%x: expected %v, got %v", inst.Address, want[inst.Address], got)
+		}
+	}
+
+	text, err := disassembler.DisassembleWithOptions(code, disassembler.Options{})
+	if err != nil || !strings.Contains(text, "dc.w     0xffff") {
+		t.Errorf("expected dc.w by default, got %v:\n%s", err, text)
+	}
+	text, err = disassembler.DisassembleWithOptions(code, disassembler.Options{Unknown: disassembler.UnknownShort})
+	if err != nil || !strings.Contains(text, ".short   0xffff") {
+		t.Errorf("expected .short, got %v:\n%s", err, text)
+	}
+	if _, err = disassembler.DisassembleWithOptions(code, disassembler.Options{Unknown: disassembler.UnknownError}); err == nil {
+		t.Error("expected an error for the undecodable word")
+	}
+	if _, err := disassembler.ParseUnknownPolicy("nonsense"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+// TestLineF checks decoding of MOVE16 and the FPU subset.
+func TestLineF(t *testing.T) {
+	tests := []struct {
+		op       uint16
+		ext      []byte
+		mn, ops  string
+		extBytes int
+	}{
+		{0xF620, []byte{0x90, 0x00}, "move16", "(a0)+,(a1)+", 2},
+		{0xF602, []byte{0x00, 0x00, 0x10, 0x00}, "move16", "(a2)+,$1000.l", 4},
+		{0xF200, []byte{0x04, 0x22}, "fadd.x", "fp1,fp0", 2},
+		{0xF200, []byte{0x09, 0x18}, "fabs.x", "fp2", 2},
+		{0xF23C, []byte{0x44, 0x00, 0x3F, 0xC0, 0x00, 0x00}, "fmove.s", "#1.5,fp0", 6},
+		{0xF227, []byte{0xE0, 0x87}, "fmovem.x", "fp0-fp2/fp7,-(a7)", 2},
+		{0xF280, []byte{0x00, 0x00}, "fnop", "", 2},
+		{0xF210, []byte{0x7C, 0xB0}, "fmove.p", "fp1,(a0){d3}", 2},
+		{0xF221, []byte{0x6D, 0x7D}, "fmove.p", "fp2,-(a1){#-3}", 2},
+		{0xF000, nil, "dc.w", "0xf000", 0},
+	}
+	for _, tt := range tests {
+		mn, ops, n := disassembler.TestableDecode(tt.op, 0, tt.ext)
+		if mn != tt.mn || ops != tt.ops || n != tt.extBytes {
+			t.Errorf("%04X: expected %s %s (%d), got %s %s (%d)", tt.op, tt.mn, tt.ops, tt.extBytes, mn, ops, n)
+		}
+	}
+}
+
+// Test68010 checks decoding of the instructions the 68010 added.
+func Test68010(t *testing.T) {
+	tests := []struct {
+		op       uint16
+		ext      []byte
+		mn, ops  string
+		extBytes int
+	}{
+		{0x4E7A, []byte{0x08, 0x01}, "movec", "vbr,d0", 2},
+		{0x4E7B, []byte{0x98, 0x01}, "movec", "a1,vbr", 2},
+		{0x4E7A, []byte{0x00, 0x02}, "dc.w", "0x4e7a", 0},
+		{0x0E90, []byte{0x08, 0x00}, "moves.l", "d0,(a0)", 2},
+		{0x0E69, []byte{0xA0, 0x00, 0x00, 0x04}, "moves.w", "(4,a1),a2", 4},
+		{0x0E80, []byte{0x08, 0x00}, "dc.w", "0x0e80", 0},
+		{0x4E74, []byte{0x00, 0x08}, "rtd", "#8", 2},
+		{0x42C1, nil, "move", "ccr,d1", 0},
+	}
+	for _, tt := range tests {
+		mn, ops, n := disassembler.TestableDecode(tt.op, 0, tt.ext)
+		if mn != tt.mn || ops != tt.ops || n != tt.extBytes {
+			t.Errorf("%04X: expected %s %s (%d), got %s %s (%d)", tt.op, tt.mn, tt.ops, tt.extBytes, mn, ops, n)
+		}
+	}
+}
+
+// TestDisassembleModel checks that instructions only later models have are
+// listed as data unless Options.Model allows them.
+func TestDisassembleModel(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  []byte
+		model cpu.Model
+		want  string
+	}{
+		{"rtd", []byte{0x4E, 0x74, 0x00, 0x08}, cpu.MC68010, "rtd      #8"},
+		{"move from ccr", []byte{0x42, 0xC1}, cpu.MC68010, "move     ccr,d1"},
+		{"movec", []byte{0x4E, 0x7A, 0x08, 0x01}, cpu.MC68010, "movec    vbr,d0"},
+		{"extb", []byte{0x49, 0xC0}, cpu.MC68020, "extb.l   d0"},
+		{"scaled index", []byte{0x30, 0x30, 0x14, 0x04}, cpu.MC68020, "move.w   (4,a0,d1.w*4),d0"},
+		{"full format", []byte{0x30, 0x30, 0x11, 0x20, 0x10, 0x00}, cpu.MC68020, "move.w   ($1000.w,a0,d1.w),d0"},
+		{"bra.l", []byte{0x60, 0xFF, 0x00, 0x00, 0x00, 0x04, 0x4E, 0x71, 0x4E, 0x75}, cpu.MC68020, "bra      loc_0006"},
+		{"fpu", []byte{0xF2, 0x00, 0x04, 0x22}, cpu.MC68020, "fadd.x   fp1,fp0"},
+		{"move16", []byte{0xF6, 0x20, 0x90, 0x00}, cpu.MC68040, "move16   (a0)+,(a1)+"},
+	}
+	for _, tt := range tests {
+		dc := fmt.Sprintf("dc.w     0x%02x%02x", tt.code[0], tt.code[1])
+		for _, m := range []cpu.Model{0, cpu.MC68000, tt.model - 10, tt.model, cpu.MC68060} {
+			out, err := disassembler.DisassembleWithOptions(tt.code, disassembler.Options{Model: m})
+			if err != nil {
+				t.Fatalf("%s for %v: %v", tt.name, m, err)
+			}
+			want := tt.want
+			if m < tt.model {
+				want = dc
+			}
+			if !strings.Contains(out, want) {
+				t.Errorf("%s for %v: expected %q in\n%s", tt.name, m, want, out)
+			}
+		}
+	}
+
+	// Bcc.L is data on a 68000, so flow stops there and the target gets
+	// no label.
+	out, err := disassembler.Disassemble([]byte{0x60, 0xFF, 0x00, 0x00, 0x00, 0x04, 0x4E, 0x71, 0x4E, 0x75})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "loc_") {
+		t.Errorf("expected no label for a 68000, got\n%s", out)
+	}
+
+	// An analysis decodes for every model and lists what opts.Model lacks
+	// as data.
+	a := disassembler.NewAnalysis([]byte{0x4E, 0x74, 0x00, 0x08})
+	for m, want := range map[cpu.Model]string{cpu.MC68000: "dc.w     0x4e74", cpu.MC68010: "rtd      #8"} {
+		out, err := a.Disassemble(disassembler.Options{Model: m})
+		if err != nil || !strings.Contains(out, want) {
+			t.Errorf("analysis for %v: expected %q, got\n%s (%v)", m, want, out, err)
+		}
+	}
+}
+
+// TestDecodeSharedOpcodeSpace checks instructions that share their line
+// with a more common one: ADDX and ADDA beside ADD, EXG and ABCD beside AND,
+// CMPA beside CMPM and Scc beside DBcc.
+func TestDecodeSharedOpcodeSpace(t *testing.T) {
+	tests := []struct {
+		op      uint16
+		ext     []byte
+		mn, ops string
+	}{
+		{0xD380, nil, "addx.l", "d0,d1"},
+		{0xD308, nil, "addx.b", "-(a0),-(a1)"},
+		{0x9340, nil, "subx.w", "d0,d1"},
+		{0x90C0, nil, "suba.w", "d0,a0"},
+		{0xD1C8, nil, "adda.l", "a0,a0"},
+		{0xB3C8, nil, "cmpa.l", "a0,a1"},
+		{0xB308, nil, "cmpm.b", "(a0)+,(a1)+"},
+		{0xC141, nil, "exg", "d0,d1"},
+		{0xC300, nil, "abcd", "d0,d1"},
+		{0x8308, nil, "sbcd", "-(a0),-(a1)"},
+		{0xC3FC, []byte{0x00, 0x03}, "muls.w", "#3,d1"},
+		{0x83D0, nil, "divs.w", "(a0),d1"},
+		{0x4380, nil, "chk.w", "d0,d1"},
+		{0x50F8, []byte{0x12, 0x34}, "st", "$1234.w"},
+	}
+	for _, tt := range tests {
+		mn, ops, _ := disassembler.TestableDecode(tt.op, 0, tt.ext)
+		if mn != tt.mn || ops != tt.ops {
+			t.Errorf("%04X: expected %s %s, got %s %s", tt.op, tt.mn, tt.ops, mn, ops)
+		}
+	}
+}
+
+// TestDecode68020Addressing decodes scaled indexes and the full extension word
+// formats.
+func TestDecode68020Addressing(t *testing.T) {
+	tests := []struct {
+		op       uint16
+		ext      []byte
+		mn, ops  string
+		extBytes int
+	}{
+		{0x3030, []byte{0x14, 0x04}, "move.w", "(4,a0,d1.w*4),d0", 2},
+		{0x3030, []byte{0x11, 0x20, 0x10, 0x00}, "move.w", "($1000.w,a0,d1.w),d0", 4},
+		{0x3030, []byte{0x1D, 0x26, 0x00, 0x04, 0x00, 0x08}, "move.w", "([$4.w,a0],d1.l*4,$8.w),d0", 6},
+		{0x3030, []byte{0x1D, 0x23, 0x00, 0x04, 0x00, 0x01, 0x23, 0x45}, "move.w", "([$4.w,a0,d1.l*4],$12345.l),d0", 8},
+		{0x3030, []byte{0x01, 0x51}, "move.w", "([a0]),d0", 2},
+		{0x2182, []byte{0x3D, 0x90}, "move.l", "d2,(za0,d3.l*4)", 2},
+		{0x43FB, []byte{0xA9, 0x26, 0x00, 0x10, 0x00, 0x04}, "lea", "([$10.w,pc],a2.l,$4.w),a1", 6},
+		{0x302B, []byte{0xFF, 0xFA}, "move.w", "(-6,a3),d0", 2},
+	}
+	for _, tt := range tests {
+		mn, ops, n := disassembler.TestableDecode(tt.op, 0, tt.ext)
+		if mn != tt.mn || ops != tt.ops || n != tt.extBytes {
+			t.Errorf("%04X: expected %s %s (%d), got %s %s (%d)", tt.op, tt.mn, tt.ops, tt.extBytes, mn, ops, n)
+		}
+	}
+	code := []byte{0x30, 0x30, 0x1D, 0x23, 0x00, 0x04, 0x00, 0x01, 0x23, 0x45}
+	if n, err := disassembler.InstructionLength(code); err != nil || n != len(code) {
+		t.Errorf("expected a pre-indexed MOVE to be %d bytes, got %d (%v)", len(code), n, err)
+	}
+}
+
+func TestDecode68020Instructions(t *testing.T) {
+	tests := []struct {
+		op       uint16
+		ext      []byte
+		mn, ops  string
+		extBytes int
+	}{
+		{0x49C3, nil, "extb.l", "d3", 0},
+		{0x4C10, []byte{0x10, 0x00}, "mulu.l", "(a0),d1", 2},
+		{0x4C00, []byte{0x1C, 0x02}, "muls.l", "d0,d2:d1", 2},
+		{0x4C40, []byte{0x30, 0x03}, "divu.l", "d0,d3", 2},
+		{0x4C40, []byte{0x3C, 0x04}, "divs.l", "d0,d4:d3", 2},
+		{0x4C40, []byte{0x30, 0x04}, "divul.l", "d0,d4:d3", 2},
+		{0x0ED0, []byte{0x00, 0x40}, "cas.l", "d0,d1,(a0)", 2},
+		{0x0CFC, []byte{0x80, 0x80, 0x40, 0xC1}, "cas2.w", "d0:d1,d2:d3,(a0):(d4)", 4},
+		{0x8340, []byte{0x30, 0x30}, "pack", "d0,d1,#$3030", 2},
+		{0x8388, []byte{0x00, 0x00}, "unpk", "-(a0),-(a1),#0", 2},
+		{0x57FC, nil, "trapeq", "", 0},
+		{0x5EFB, []byte{0x00, 0x01, 0x23, 0x45}, "trapgt.l", "#$12345", 4},
+		{0xE9C0, []byte{0x11, 0x08}, "bfextu", "d0{4:8},d1", 2},
+		{0xEFD0, []byte{0x18, 0xA3}, "bfins", "d1,(a0){d2:d3}", 2},
+		{0xE8E8, []byte{0x00, 0x00, 0x00, 0x04}, "bftst", "(4,a0){0:32}", 4},
+	}
+	for _, tt := range tests {
+		mn, ops, n := disassembler.TestableDecode(tt.op, 0, tt.ext)
+		if mn != tt.mn || ops != tt.ops || n != tt.extBytes {
+			t.Errorf("%04X: expected %s %s (%d), got %s %s (%d)", tt.op, tt.mn, tt.ops, tt.extBytes, mn, ops, n)
+		}
+	}
+}
+
+// resourceFork builds a raw resource fork holding res, all of one type.
+func resourceFork(typ string, res []disassembler.Resource) []byte {
+	var data, refs, names []byte
+	for _, r := range res {
+		nameOff := uint16(0xFFFF)
+		if r.Name != "" {
+			nameOff = uint16(len(names))
+			names = append(append(names, byte(len(r.Name))), r.Name...)
+		}
+		refs = binary.BigEndian.AppendUint16(refs, uint16(r.ID))
+		refs = binary.BigEndian.AppendUint16(refs, nameOff)
+		refs = binary.BigEndian.AppendUint32(refs, uint32(len(data)))
+		refs = binary.BigEndian.AppendUint32(refs, 0)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(r.Data)))
+		data = append(data, r.Data...)
+	}
+	typeList := []byte{0, 0}
+	typeList = append(typeList, typ...)
+	typeList = binary.BigEndian.AppendUint16(typeList, uint16(len(res)-1))
+	typeList = binary.BigEndian.AppendUint16(typeList, uint16(len(typeList)+2))
+	m := make([]byte, 28)
+	binary.BigEndian.PutUint16(m[24:], 28)
+	binary.BigEndian.PutUint16(m[26:], uint16(28+len(typeList)+len(refs)))
+	m = append(append(append(m, typeList...), refs...), names...)
+
+	fork := make([]byte, 16)
+	binary.BigEndian.PutUint32(fork[0:], 16)
+	binary.BigEndian.PutUint32(fork[4:], uint32(16+len(data)))
+	binary.BigEndian.PutUint32(fork[8:], uint32(len(data)))
+	binary.BigEndian.PutUint32(fork[12:], uint32(len(m)))
+	return append(append(fork, data...), m...)
+}
+
+// TestMacCode loads a two-segment application from a resource fork and
+// checks the jump table, segment layout and trap names.
+func TestMacCode(t *testing.T) {
+	segment := func(src string, first, count uint16) []byte {
+		code, err := assembler.New().Assemble(src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := binary.BigEndian.AppendUint16(nil, first)
+		return append(binary.BigEndian.AppendUint16(header, count), code...)
+	}
+	jt := make([]byte, 16)
+	binary.BigEndian.PutUint32(jt[8:], 16)
+	binary.BigEndian.PutUint32(jt[12:], 32)
+	jt = append(jt, 0, 0, 0x3F, 0x3C, 0, 1, 0xA9, 0xF0) // Segment 1, offset 0
+	jt = append(jt, 0, 2, 0x3F, 0x3C, 0, 2, 0xA9, 0xF0) // Segment 2, offset 2
+	fork := resourceFork("CODE", []disassembler.Resource{
+		{ID: 0, Data: jt},
+		{ID: 1, Name: "Main", Data: segment("jsr 42(a5)\n dc.w $A9F4", 0, 1)},
+		{ID: 2, Data: segment("nop\n dc.w $ADC8\n dc.w $A61E\n rts", 8, 1)},
+	})
+
+	res, err := disassembler.ParseResourceFork(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := disassembler.LoadMacCode(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Segments) != 2 || app.Segments[1].Address != 6 || len(app.JumpTable) != 2 {
+		t.Fatalf("expected two segments and two entries, got %+v and %+v", app.Segments, app.JumpTable)
+	}
+	if e := app.JumpTable[1]; e.Address != 8 || e.A5Offset != 42 {
+		t.Errorf("expected the second entry at 8 and 42(a5), got %+v", e)
+	}
+	text, err := app.Disassemble(disassembler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Main_0000:", "seg2_0002:", "; seg2_0002", "; _ExitToShell", "; _SysBeep,AutoPop", "; _NewPtr,Sys,Clear"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+	if _, err := disassembler.LoadMacCode(res[1:]); err == nil {
+		t.Error("expected an error without CODE 0")
+	}
+}
+
+// palmPRC builds a Palm OS resource database named name from res.
+func palmPRC(name string, res []disassembler.Resource) []byte {
+	prc := make([]byte, 78)
+	copy(prc, name)
+	binary.BigEndian.PutUint16(prc[32:], 1) // Resource database
+	copy(prc[60:], "applTEST")
+	binary.BigEndian.PutUint16(prc[76:], uint16(len(res)))
+	off := len(prc) + len(res)*10
+	for _, r := range res {
+		prc = append(prc, r.Type...)
+		prc = binary.BigEndian.AppendUint16(prc, uint16(r.ID))
+		prc = binary.BigEndian.AppendUint32(prc, uint32(off))
+		off += len(r.Data)
+	}
+	for _, r := range res {
+		prc = append(prc, r.Data...)
+	}
+	return prc
+}
+
+// TestPalmCode loads a Palm application from a PRC file and checks the
+// segment layout and the names of its system traps.
+func TestPalmCode(t *testing.T) {
+	code1, err := assembler.New().Assemble(`
+	trap	#15
+	dc.w	$A013
+	trap	#15
+	dc.w	$A3FF
+	jsr	4(pc)		; code2
+	rts
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prc := palmPRC("Test", []disassembler.Resource{
+		{Type: "code", ID: 1, Data: code1},
+		{Type: "tSTR", ID: 1000, Data: []byte("Hi\x00")},
+		{Type: "code", ID: 0, Data: []byte{0, 0, 0, 0x20, 0, 0, 1, 0}},
+		{Type: "code", ID: 2, Data: []byte{0x4E, 0x75}},
+	})
+
+	db, err := disassembler.ParsePRC(prc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Name != "Test" || db.Type != "appl" || db.Creator != "TEST" || len(db.Resources) != 4 {
+		t.Fatalf("unexpected database %+v", db)
+	}
+	if r := db.Resources[1]; r.Type != "tSTR" || r.ID != 1000 || string(r.Data) != "Hi\x00" {
+		t.Errorf("unexpected resource %+v", r)
+	}
+	app, err := disassembler.LoadPalmCode(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.AboveA5 != 0x20 || app.BelowA5 != 0x100 || len(app.Segments) != 2 || app.Segments[1].Address != 14 {
+		t.Fatalf("unexpected layout %+v", app)
+	}
+	text, err := app.Disassemble(disassembler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"start:", "code2:", "; MemPtrNew", "; system trap $a3ff"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+	if _, err := disassembler.LoadPalmCode(&disassembler.PalmDatabase{Resources: db.Resources[1:2]}); err == nil {
+		t.Error("expected an error without code 1")
+	}
+	binary.BigEndian.PutUint16(prc[32:], 0)
+	if _, err := disassembler.ParsePRC(prc); err == nil {
+		t.Error("expected an error for a record database")
+	}
+}
@@ -0,0 +1,148 @@
+package assembler_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/vm"
+)
+
+// fuzzTemplates are the instructions FuzzExecute draws from. Fields in braces
+// are filled from the fuzz input: {s} a size, {w} .w or .l, {d} a data
+// register, {a} an address register pointing into the data window, {q} a
+// quick count, {i} an immediate byte, {n} a bit number, {o} a displacement
+// and {c} a condition. Address registers only ever move forwards or back by
+// a few bytes, so every access stays in the window. Instructions the core
+// doesn't execute yet (ADDI, MULU, MULS, ABCD, SBCD and NBCD) are left out.
+var fuzzTemplates = []string{
+	"move.{s} d{d},d{d}", "moveq #{i},d{d}", "exg d{d},d{d}", "swap d{d}", "ext.{w} d{d}",
+	"add.{s} d{d},d{d}", "sub.{s} d{d},d{d}", "cmp.{s} d{d},d{d}",
+	"addx.{s} d{d},d{d}", "subx.{s} -(a{a}),-(a{a})",
+	"and.{s} d{d},d{d}", "or.{s} d{d},d{d}", "eor.{s} d{d},d{d}",
+	"addq.{s} #{q},d{d}", "subq.{s} #{q},d{d}",
+	"andi.{s} #{i},d{d}", "ori.{s} #{i},d{d}", "eori.{s} #{i},d{d}", "cmpi.{s} #{i},d{d}", "subi.{s} #{i},d{d}",
+	"neg.{s} d{d}", "negx.{s} d{d}", "not.{s} d{d}", "clr.{s} d{d}", "tst.{s} d{d}",
+	"lsl.{s} #{q},d{d}", "lsr.{s} #{q},d{d}", "asl.{s} #{q},d{d}", "asr.{s} #{q},d{d}",
+	"rol.{s} #{q},d{d}", "ror.{s} #{q},d{d}", "roxl.{s} #{q},d{d}", "roxr.{s} #{q},d{d}",
+	"lsl.{s} d{d},d{d}", "asr.{s} d{d},d{d}", "rol.{s} d{d},d{d}", "roxr.{s} d{d},d{d}",
+	"divu d{d},d{d}", "divs d{d},d{d}", "chk d{d},d{d}",
+	"btst d{d},d{d}", "bset #{n},d{d}", "bclr #{n},d{d}", "bchg d{d},d{d}",
+	"s{c} d{d}", "tas d{d}", "trapv", "nop",
+	"move.{s} d{d},(a{a})", "move.{s} (a{a}),d{d}", "move.{s} d{d},(a{a})+", "move.{s} -(a{a}),d{d}",
+	"add.{s} d{d},{o}(a{a})", "sub.{s} {o}(a{a}),d{d}", "addq.{s} #{q},(a{a})", "not.{s} {o}(a{a})", "tas (a{a})",
+	"lea {o}(a{a}),a{a}", "movep.{w} d{d},{o}(a{a})", "movep.{w} {o}(a{a}),d{d}",
+}
+
+var fuzzConditions = []string{"t", "f", "hi", "ls", "cc", "cs", "ne", "eq", "vc", "vs", "pl", "mi", "ge", "lt", "gt", "le"}
+
+// Layout of the machine FuzzExecute runs the instructions in.
+const (
+	fuzzHandler = 0x400  // rte, the handler for every exception vector
+	fuzzCode    = 0x1000 // the generated instructions, then trap #15
+	fuzzDataLo  = 0x8000 // the only memory the instructions may change
+	fuzzDataHi  = 0x9000 // also the supervisor stack
+	fuzzMaxOps  = 64
+)
+
+// fuzzSource turns fuzz input into at most fuzzMaxOps instructions.
+func fuzzSource(data []byte) string {
+	next := func() int {
+		if len(data) == 0 {
+			return 0
+		}
+		b := data[0]
+		data = data[1:]
+		return int(b)
+	}
+	var sb strings.Builder
+	for n := 0; len(data) > 0 && n < fuzzMaxOps; n++ {
+		tmpl := fuzzTemplates[next()%len(fuzzTemplates)]
+		for {
+			start := strings.IndexByte(tmpl, '{')
+			if start < 0 {
+				break
+			}
+			sb.WriteString(tmpl[:start])
+			field := tmpl[start+1]
+			tmpl = tmpl[start+3:]
+			switch field {
+			case 's':
+				sb.WriteByte("bwl"[next()%3])
+			case 'w':
+				sb.WriteByte("wl"[next()%2])
+			case 'd':
+				fmt.Fprintf(&sb, "%d", next()%8)
+			case 'a':
+				fmt.Fprintf(&sb, "%d", next()%4)
+			case 'q':
+				fmt.Fprintf(&sb, "%d", next()%8+1)
+			case 'i':
+				fmt.Fprintf(&sb, "%d", int8(next()))
+			case 'n':
+				fmt.Fprintf(&sb, "%d", next()%32)
+			case 'o':
+				fmt.Fprintf(&sb, "%d", next()%8*2)
+			case 'c':
+				sb.WriteString(fuzzConditions[next()%len(fuzzConditions)])
+			}
+		}
+		sb.WriteString(tmpl + "\n")
+	}
+	sb.WriteString("trap #15\n")
+	return sb.String()
+}
+
+// FuzzExecute assembles random sequences of legal instructions and runs them
+// in a sandbox, checking that the CPU core never panics, keeps the PC
+// word-aligned and writes nowhere but the data window. Every exception vector
+// leads to an RTE, so zero divides, CHK and TRAPV resume the sequence.
+func FuzzExecute(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f"))
+	f.Add([]byte("\x26\x00\x03\x04\x37\x01\x05\x06\x34\x00\x01\x07\x35\x02\x03\x04"))
+	for i := range fuzzTemplates {
+		f.Add([]byte{byte(i), 0xFF, 0x81, 0x7F, 0x02, 0x05, byte(i), 0x00, 0x00, 0x01})
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		src := fuzzSource(data)
+		asm := assembler.New()
+		code, err := asm.Assemble(src, fuzzCode)
+		if err != nil {
+			t.Fatalf("generated source doesn't assemble: %v\n%s", err, src)
+		}
+
+		v := vm.New(0x10000, 64)
+		c := v.CPU
+		for vec := uint32(2); vec < 64; vec++ {
+			c.WriteU32(vec*4, fuzzHandler)
+		}
+		c.WriteU16(fuzzHandler, 0x4E73) // rte
+		v.LoadCode(fuzzCode, code)
+		for i := range 4 {
+			c.A[i] = fuzzDataLo + 0x200 + uint32(i)*0x300
+		}
+		c.A[7] = fuzzDataHi
+		c.PC = fuzzCode
+		before := bytes.Clone(c.Mem)
+
+		r := v.RunSandboxed(vm.SandboxConfig{MaxInstructions: 4 * fuzzMaxOps})
+		if r.Reason == vm.SandboxFault && strings.HasPrefix(r.Error, "emulator fault") {
+			t.Fatalf("%s\n%s", r.Error, src)
+		}
+		if r.Reason != vm.SandboxHalted {
+			t.Fatalf("expected the sequence to halt, got %s (%s)\n%s", r.Reason, r.Error, src)
+		}
+		if c.PC%2 != 0 {
+			t.Fatalf("PC $%08X is odd\n%s", c.PC, src)
+		}
+		for addr := range c.Mem {
+			if (addr < fuzzDataLo || addr >= fuzzDataHi) && c.Mem[addr] != before[addr] {
+				t.Fatalf("memory at $%08X changed outside the data window\n%s", addr, src)
+			}
+		}
+	})
+}
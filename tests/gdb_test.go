@@ -0,0 +1,280 @@
+package assembler_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/internal/gdb"
+	"github.com/Urethramancer/m68k/vm"
+)
+
+// TestGDBRegisters round-trips the registers through the g/G packet encoding.
+func TestGDBRegisters(t *testing.T) {
+	c := cpu.New(0x100, 0)
+	c.D[0] = 0x12345678
+	c.A[7] = 0x8000
+	c.SR = 0x2704
+	c.PC = 0x400
+
+	payload := gdb.Registers(c)
+	if len(payload) != gdb.NumRegisters*8 {
+		t.Fatalf("expected %d hex digits, got %d", gdb.NumRegisters*8, len(payload))
+	}
+	if !strings.HasPrefix(payload, "12345678") || !strings.HasSuffix(payload, "0000270400000400") {
+		t.Errorf("unexpected register payload %s", payload)
+	}
+
+	d := cpu.New(0x100, 0)
+	if err := gdb.SetRegisters(d, payload); err != nil {
+		t.Fatal(err)
+	}
+	if d.D != c.D || d.A != c.A || d.SR != c.SR || d.PC != c.PC {
+		t.Errorf("registers did not round-trip")
+	}
+	if err := gdb.SetRegisters(d, "1234"); err == nil {
+		t.Error("expected an error for short register data")
+	}
+}
+
+// TestGDBXfer reads target.xml and the memory map in chunks and checks
+// breakpoint placement.
+func TestGDBXfer(t *testing.T) {
+	f := &gdb.Features{Regions: []gdb.Region{
+		{Start: 0, Length: 0x10000, ROM: true},
+		{Start: 0x10000, Length: 0x30000},
+	}}
+	if got := f.Supported(); got != "qXfer:features:read+;qXfer:memory-map:read+" {
+		t.Errorf("unexpected qSupported features %s", got)
+	}
+
+	read := func(object, annex string) string {
+		var doc strings.Builder
+		for off := 0; ; off += 0x40 {
+			reply, ok := f.HandleQuery("qXfer:" + object + ":read:" + annex + ":" + strconv.FormatInt(int64(off), 16) + ",40")
+			if !ok || reply == "" || (reply[0] != 'm' && reply[0] != 'l') {
+				t.Fatalf("unexpected reply %q", reply)
+			}
+			doc.WriteString(reply[1:])
+			if reply[0] == 'l' {
+				return doc.String()
+			}
+		}
+	}
+
+	if got := read("features", "target.xml"); got != gdb.TargetXML {
+		t.Errorf("target.xml did not read back intact:\n%s", got)
+	}
+	mm := read("memory-map", "")
+	for _, want := range []string{`<memory type="rom" start="0x0" length="0x10000"/>`, `<memory type="ram" start="0x10000" length="0x30000"/>`} {
+		if !strings.Contains(mm, want) {
+			t.Errorf("memory map is missing %s:\n%s", want, mm)
+		}
+	}
+
+	if reply, ok := f.HandleQuery("qXfer:features:read:other.xml:0,40"); !ok || reply != "E00" {
+		t.Errorf("expected E00 for an unknown annex, got %q", reply)
+	}
+	if _, ok := f.HandleQuery("qSupported"); ok {
+		t.Error("HandleQuery should not claim non-qXfer packets")
+	}
+
+	for addr, want := range map[uint32]gdb.BreakpointKind{0x100: gdb.HardwareBreakpoint, 0x10000: gdb.SoftwareBreakpoint, 0x40000: gdb.NoBreakpoint} {
+		if got := gdb.BreakpointFor(f.Regions, addr); got != want {
+			t.Errorf("breakpoint at $%X: expected %s, got %s", addr, want, got)
+		}
+	}
+}
+
+// gdbClient sends packets to a GDBServer and reads the replies.
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (g *gdbClient) call(packet string) string {
+	g.t.Helper()
+	g.send(packet)
+	return g.reply(packet)
+}
+
+// send writes a packet without waiting for the reply.
+func (g *gdbClient) send(packet string) {
+	g.t.Helper()
+	var sum byte
+	for i := 0; i < len(packet); i++ {
+		sum += packet[i]
+	}
+	if _, err := fmt.Fprintf(g.conn, "$%s#%02x", packet, sum); err != nil {
+		g.t.Fatal(err)
+	}
+}
+
+// reply reads the reply to packet, skipping acknowledgements.
+func (g *gdbClient) reply(packet string) string {
+	g.t.Helper()
+	for {
+		b, err := g.r.ReadByte()
+		if err != nil {
+			g.t.Fatalf("%s: %v", packet, err)
+		}
+		if b != '$' {
+			continue // The acknowledgement
+		}
+		reply, err := g.r.ReadString('#')
+		if err != nil {
+			g.t.Fatal(err)
+		}
+		g.r.Discard(2)
+		return reply[:len(reply)-1]
+	}
+}
+
+// TestGDBServer drives a session the way gdb does: reading registers and
+// memory, setting a breakpoint, continuing to it, stepping and running to the end.
+func TestGDBServer(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	moveq	#0,d0
+loop:
+	addq.l	#1,d0
+	cmp.l	#3,d0
+	bne	loop
+	move.l	d0,$2000
+	trap	#15
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	v := vm.New(0x10000, 16)
+	v.LoadCode(0x400, code)
+	v.CPU.PC = 0x400
+	v.CPU.A[7] = 0x8000
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- v.NewGDBServer().Serve(server)
+		server.Close()
+	}()
+	g := &gdbClient{t: t, conn: client, r: bufio.NewReader(client)}
+
+	if reply := g.call("qSupported:multiprocess+"); !strings.Contains(reply, "qXfer:features:read+") {
+		t.Errorf("unexpected qSupported reply %s", reply)
+	}
+	if reply := g.call("?"); reply != "S05" {
+		t.Errorf("expected S05, got %s", reply)
+	}
+	if regs := g.call("g"); len(regs) != gdb.NumRegisters*8 || !strings.HasSuffix(regs, "00000400") {
+		t.Errorf("unexpected registers %s", regs)
+	}
+	if mem := g.call("m400,2"); mem != "7000" {
+		t.Errorf("expected moveq #0,d0 at $400, got %s", mem)
+	}
+
+	loop := asm.Labels()["loop"]
+	if reply := g.call(fmt.Sprintf("Z0,%x,2", loop)); reply != "OK" {
+		t.Fatalf("Z0 failed: %s", reply)
+	}
+	for i := 1; i <= 3; i++ {
+		if reply := g.call("c"); reply != "S05" || v.CPU.PC != loop || v.CPU.D[0] != uint32(i-1) {
+			t.Fatalf("continue %d: got %s at $%X with d0=%d", i, reply, v.CPU.PC, v.CPU.D[0])
+		}
+		if i == 2 {
+			if reply := g.call("s"); reply != "S05" || v.CPU.PC != loop+2 {
+				t.Fatalf("step: got %s at $%X", reply, v.CPU.PC)
+			}
+		}
+	}
+	if reply := g.call(fmt.Sprintf("z0,%x,2", loop)); reply != "OK" {
+		t.Fatalf("z0 failed: %s", reply)
+	}
+	if reply := g.call("M2000,4:deadbeef"); reply != "OK" || v.CPU.ReadU32(0x2000) != 0xDEADBEEF {
+		t.Errorf("memory write failed: %s", reply)
+	}
+	if reply := g.call("c"); reply != "W00" {
+		t.Errorf("expected the program to exit, got %s", reply)
+	}
+	if mem := g.call("m2000,4"); mem != "00000003" {
+		t.Errorf("expected the program's result in memory, got %s", mem)
+	}
+	if reply := g.call("D"); reply != "OK" {
+		t.Errorf("detach failed: %s", reply)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("session ended with %v", err)
+	}
+}
+
+// TestGDBContinueQueue checks that a packet gdb sends during a continue is
+// answered after the stop reply instead of being lost.
+func TestGDBContinueQueue(t *testing.T) {
+	v := vm.New(0x10000, 16)
+	v.CPU.WriteU16(0x400, 0x60FE) // bra.s *
+	v.CPU.PC = 0x400
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		v.NewGDBServer().Serve(server)
+		server.Close()
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	g := &gdbClient{t: t, conn: client, r: bufio.NewReader(client)}
+
+	g.send("c")
+	g.send("m400,2")
+	if _, err := client.Write([]byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if reply := g.reply("c"); reply != "S02" {
+		t.Errorf("expected the interrupt to stop the continue, got %s", reply)
+	}
+	if reply := g.reply("m400,2"); reply != "60fe" {
+		t.Errorf("expected the queued memory read, got %s", reply)
+	}
+}
+
+// TestGDBBreakpointKinds checks that a software breakpoint is refused in
+// ROM, where a hardware one is accepted, and both outside memory.
+func TestGDBBreakpointKinds(t *testing.T) {
+	v := vm.New(0x10000, 16)
+	if err := v.LoadROM(0, make([]byte, 0x1000), "ROM"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.MemoryMap().Map(vm.Region{Start: 0x8000, Size: 0x8000, Kind: vm.RegionUnmapped, Name: "hole"}); err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		v.NewGDBServer().Serve(server)
+		server.Close()
+	}()
+	g := &gdbClient{t: t, conn: client, r: bufio.NewReader(client)}
+
+	for _, tt := range []struct{ packet, want string }{
+		{"Z0,100,2", "E01"},
+		{"Z1,100,2", "OK"},
+		{"Z0,2000,2", "OK"},
+		{"Z1,2000,2", "OK"},
+		{"Z0,9000,2", "E01"},
+		{"Z1,9000,2", "E01"},
+		{"z1,100,2", "OK"},
+	} {
+		if reply := g.call(tt.packet); reply != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.packet, tt.want, reply)
+		}
+	}
+	if !v.CPU.IsBreakpoint(0x2000) || v.CPU.IsBreakpoint(0x100) {
+		t.Error("expected a breakpoint at $2000 only")
+	}
+}
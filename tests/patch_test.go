@@ -0,0 +1,133 @@
+package assembler_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k/patch"
+)
+
+func TestPatch(t *testing.T) {
+	// moveq #0,d0; rts; nop; nop; nop at $1000.
+	image := []byte{0x70, 0x00, 0x4E, 0x75, 0x4E, 0x71, 0x4E, 0x71, 0x4E, 0x71}
+	desc := `* Return 1 and reuse the slide.
+$1000: moveq #0,d0 -> moveq #1,d0
+$1004: nop; nop -> rts
+// trailing byte change
+0x1008: 4E71 -> 4E 75
+`
+	changes, err := patch.Parse(strings.NewReader(desc))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(changes) != 3 || changes[1].Line != 3 {
+		t.Fatalf("Parse returned %+v", changes)
+	}
+	if want := []byte{0x4E, 0x75, 0x4E, 0x71}; !bytes.Equal(changes[1].New, want) {
+		t.Errorf("padded new code = % X, want % X", changes[1].New, want)
+	}
+
+	patched, err := patch.Apply(image, 0x1000, changes)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []byte{0x70, 0x01, 0x4E, 0x75, 0x4E, 0x75, 0x4E, 0x71, 0x4E, 0x75}
+	if !bytes.Equal(patched, want) {
+		t.Errorf("patched = % X, want % X", patched, want)
+	}
+	if image[1] != 0 {
+		t.Error("Apply modified the original image")
+	}
+
+	// Applying twice must fail: the old bytes are gone.
+	var mismatch *patch.MismatchError
+	if _, err := patch.Apply(patched, 0x1000, changes); !errors.As(err, &mismatch) {
+		t.Fatalf("second Apply error = %v, want MismatchError", err)
+	} else if !strings.Contains(err.Error(), "moveq") {
+		t.Errorf("mismatch error does not show disassembly: %v", err)
+	}
+
+	if _, err := patch.Parse(strings.NewReader("$1000: 4E 71 -> 4E 75 4E 71\n")); err == nil {
+		t.Error("Parse accepted new bytes longer than the old")
+	}
+
+	var ips bytes.Buffer
+	if err := patch.WriteIPS(&ips, image, patched); err != nil {
+		t.Fatalf("WriteIPS: %v", err)
+	}
+	wantIPS := []byte("PATCH\x00\x00\x01\x00\x01\x01\x00\x00\x05\x00\x01\x75\x00\x00\x09\x00\x01\x75EOF")
+	if !bytes.Equal(ips.Bytes(), wantIPS) {
+		t.Errorf("IPS = % X, want % X", ips.Bytes(), wantIPS)
+	}
+
+	var bps bytes.Buffer
+	if err := patch.WriteBPS(&bps, image, patched); err != nil {
+		t.Fatalf("WriteBPS: %v", err)
+	}
+	b := bps.Bytes()
+	if !bytes.HasPrefix(b, []byte("BPS1")) {
+		t.Fatalf("BPS header = %q", b[:4])
+	}
+	n := len(b)
+	if got := binary.LittleEndian.Uint32(b[n-12:]); got != crc32.ChecksumIEEE(image) {
+		t.Errorf("BPS source CRC = %08X", got)
+	}
+	if got := binary.LittleEndian.Uint32(b[n-8:]); got != crc32.ChecksumIEEE(patched) {
+		t.Errorf("BPS target CRC = %08X", got)
+	}
+	if got := binary.LittleEndian.Uint32(b[n-4:]); got != crc32.ChecksumIEEE(b[:n-4]) {
+		t.Errorf("BPS patch CRC = %08X", got)
+	}
+}
+
+// TestApplyPatchFiles round-trips IPS and BPS patches and checks that the
+// readers handle run-length records and refuse the wrong source file.
+func TestApplyPatchFiles(t *testing.T) {
+	original := bytes.Repeat([]byte{0x4E, 0x71}, 64)
+	patched := bytes.Clone(original)
+	copy(patched[10:], []byte{0x4E, 0x75})
+	patched = append(patched, 0x12, 0x34)
+
+	for _, tc := range []struct {
+		name  string
+		write func(w io.Writer, original, patched []byte) error
+	}{
+		{"IPS", patch.WriteIPS},
+		{"BPS", patch.WriteBPS},
+	} {
+		var buf bytes.Buffer
+		if err := tc.write(&buf, original, patched); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := patch.ApplyFile(original, buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(got, patched) {
+			t.Errorf("%s: applied % X, want % X", tc.name, got, patched)
+		}
+	}
+
+	// A run of four $FF bytes at offset 2, then truncation to 8 bytes.
+	ips := []byte("PATCH\x00\x00\x02\x00\x00\x00\x04\xFFEOF\x00\x00\x08")
+	got, err := patch.ApplyIPS(original, ips)
+	if want := []byte{0x4E, 0x71, 0xFF, 0xFF, 0xFF, 0xFF, 0x4E, 0x71}; err != nil || !bytes.Equal(got, want) {
+		t.Errorf("IPS run: got % X, %v; want % X", got, err, want)
+	}
+
+	var bps bytes.Buffer
+	if err := patch.WriteBPS(&bps, original, patched); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := patch.ApplyBPS(patched, bps.Bytes()); err == nil {
+		t.Error("BPS patch applied to the wrong file")
+	}
+	if _, err := patch.ApplyFile(original, []byte("ZIP")); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,65 @@
+package assembler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/internal/verify"
+)
+
+// TestVerify round-trips a spread of instructions through the disassembler
+// and back, and checks that a disagreement is reported.
+func TestVerify(t *testing.T) {
+	asm := assembler.New()
+	_, err := asm.Assemble(`
+	machine	68060
+start:
+	move.l	$12(a2),$1234.w
+	moveq	#-5,d2
+	addx.l	d0,d1
+	addx.b	-(a0),-(a1)
+	suba.w	d0,a0
+	subx.w	d0,d1
+	cmpa.l	a0,a1
+	cmpm.b	(a0)+,(a1)+
+	exg	d0,d1
+	muls.w	#3,d1
+	divs.w	(a0),d1
+	chk.w	d0,d1
+	abcd	d0,d1
+	sbcd	-(a0),-(a1)
+	st	$1234
+	movem.l	d0-d7/a0-a6,-(a7)
+	lsl.w	(a0)
+	lea	start(pc),a1
+	dbra	d0,start
+	bsr.w	start
+	beq	start
+	movec	vbr,d0
+	move.w	([4,a0],d1.l*4,8),d0
+	fadd.d	#2.5,fp0
+	movep.l	4(a0),d1
+	dc.w	$1234
+`, 0x1000)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	for _, m := range verify.Check(asm.Encodings()) {
+		t.Errorf("unexpected mismatch: %s", m)
+	}
+
+	list := verify.Check([]assembler.Encoding{
+		{Line: 1, Address: 0x1000, Source: "bad", Words: []uint16{0x4E7F}},
+		{Line: 2, Address: 0x1002, Source: "long", Words: []uint16{0x3028, 0x0004, 0x0000}},
+	})
+	if len(list) != 2 {
+		t.Fatalf("expected 2 mismatches, got %v", list)
+	}
+	if list[0].Problem != "not decoded" {
+		t.Errorf("expected an undecodable word, got %s", list[0])
+	}
+	if !strings.Contains(list[1].Problem, "decoded as 4 bytes") {
+		t.Errorf("expected a length mismatch, got %s", list[1])
+	}
+}
@@ -0,0 +1,2099 @@
+package assembler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Urethramancer/m68k/assembler"
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+	"github.com/Urethramancer/m68k/radix"
+	"github.com/Urethramancer/m68k/vm"
+)
+
+// TestWriteState checks the compact and JSON register dumps.
+func TestWriteState(t *testing.T) {
+	v := vm.New(0x100, 0)
+	v.CPU.D[0] = 0x12345678
+	v.CPU.PC = 0x40
+	v.CPU.SR = 0x2704
+
+	var sb strings.Builder
+	if err := v.WriteState(&sb, vm.StateCompact); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sb.String(), "PC=00000040 SR=2704 --Z-- D=12345678,00000000,") {
+		t.Errorf("unexpected compact state: %s", sb.String())
+	}
+
+	sb.Reset()
+	if err := v.WriteState(&sb, vm.StateJSON); err != nil {
+		t.Fatal(err)
+	}
+	var s vm.State
+	if err := json.Unmarshal([]byte(sb.String()), &s); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if s != v.State() {
+		t.Errorf("JSON round trip mismatch: %+v", s)
+	}
+}
+
+// TestMonitor drives the monitor through memory modify, display, trace and a register set.
+func TestMonitor(t *testing.T) {
+	v := vm.New(0x1000, 0)
+	var out strings.Builder
+	in := strings.NewReader("MM.W 100 7005 7203\n.PC 100\nT 2\nMD 100 4\n.D9 1\nQU\nMD 0\n")
+	if err := v.NewMonitor(in, &out).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.CPU.D[0] != 5 || v.CPU.D[1] != 3 || v.CPU.PC != 0x104 {
+		t.Errorf("unexpected state after trace: %+v", v.State())
+	}
+	text := out.String()
+	for _, want := range []string{
+		"00000100  70 05 72 03",
+		"? unknown register D9",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "00000000  ") {
+		t.Error("commands after QU were executed")
+	}
+
+	// Symbols label listings and stand in for addresses.
+	v.Symbols = disassembler.Symbols{0x100: "start", 0x104: "loop"}
+	v.LoadCode(0x104, []byte{0x60, 0xFE}) // bra.s loop
+	out.Reset()
+	if err := v.NewMonitor(strings.NewReader("DI start 3\nSY oo\n"), &out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	text = out.String()
+	for _, want := range []string{"start:\n", "loop:\n", "bra      loop", "00000104  loop\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+}
+
+// TestPerfCounters reads the instruction and cycle counters from guest code.
+func TestPerfCounters(t *testing.T) {
+	v := vm.New(0x1000, 0)
+	if err := v.EnablePerfCounters(0xF00); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EnablePerfCounters(0xFF8); err == nil {
+		t.Error("expected an error for a block past the end of memory")
+	}
+	rom := vm.New(0x10000, 0)
+	if err := rom.LoadROM(0x8000, make([]byte, 0x100), "ROM"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rom.EnablePerfCounters(0x8000); err == nil {
+		t.Error("expected an error for a block in ROM")
+	}
+	if err := rom.EnableRandom(0x80F8, 1); err == nil {
+		t.Error("expected an error for a random number device overlapping ROM")
+	}
+
+	// moveq #1,d0; moveq #2,d1; move.l $f04.w,d2; move.l $f00.w,d3
+	v.LoadCode(0x100, []byte{0x70, 0x01, 0x72, 0x02, 0x24, 0x38, 0x0F, 0x04, 0x26, 0x38, 0x0F, 0x00})
+	v.CPU.PC = 0x100
+	v.CPU.Running = true
+	for range 4 {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.D[2] != 2 {
+		t.Errorf("expected 2 instructions before the read, got %d", v.CPU.D[2])
+	}
+	// Two MOVEQs take 4 cycles each, and MOVE.L from an absolute short 16.
+	if v.CPU.D[3] != 24 {
+		t.Errorf("expected 24 cycles before the read, got %d", v.CPU.D[3])
+	}
+}
+
+// TestRandom checks the random number device repeats for a seed and reseeds from guest writes.
+func TestRandom(t *testing.T) {
+	run := func(seed uint32) [3]uint32 {
+		v := vm.New(0x1000, 0)
+		if err := v.EnableRandom(0xF00, seed); err != nil {
+			t.Fatal(err)
+		}
+		// move.l $f00.w,d0 / move.l $f00.w,d1 / move.l d1,$f04.w / move.l $f00.w,d2
+		v.LoadCode(0x100, []byte{0x20, 0x38, 0x0F, 0x00, 0x22, 0x38, 0x0F, 0x00,
+			0x21, 0xC1, 0x0F, 0x04, 0x24, 0x38, 0x0F, 0x00})
+		v.CPU.PC = 0x100
+		v.CPU.Running = true
+		for range 4 {
+			if err := v.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return [3]uint32{v.CPU.D[0], v.CPU.D[1], v.CPU.D[2]}
+	}
+
+	a, b := run(42), run(42)
+	if a != b {
+		t.Errorf("same seed gave %08X and %08X", a, b)
+	}
+	if a[0] == a[1] {
+		t.Errorf("value did not change between reads: %08X", a)
+	}
+	if c := run(43); c == a {
+		t.Error("different seeds gave the same numbers")
+	}
+	// Reseeding with the second value restarts the sequence from that state.
+	v := vm.New(0x1000, 0)
+	v.EnableRandom(0xF00, a[1])
+	if got := v.CPU.ReadU32(0xF00); got != a[2] {
+		t.Errorf("expected reseeded value %08X, got %08X", a[2], got)
+	}
+}
+
+// TestCall runs a subroutine to its RTS and checks the PC is restored.
+func TestCall(t *testing.T) {
+	v := vm.New(0x1000, 0)
+	// $100: moveq #7,d0 / rts
+	v.CPU.WriteU16(0x100, 0x7007)
+	v.CPU.WriteU16(0x102, 0x4E75)
+	// $200: bra.s *
+	v.CPU.WriteU16(0x200, 0x60FE)
+	v.CPU.A[7] = 0x1000
+	v.CPU.PC = 0x40
+
+	steps, err := v.Call(0x100, 0)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if steps != 2 || v.CPU.D[0] != 7 || v.CPU.PC != 0x40 || v.CPU.A[7] != 0x1000 {
+		t.Errorf("unexpected state after Call: steps=%d D0=%08X PC=%08X A7=%08X", steps, v.CPU.D[0], v.CPU.PC, v.CPU.A[7])
+	}
+
+	if _, err := v.Call(0x200, 50); !errors.Is(err, vm.ErrStepLimit) {
+		t.Errorf("expected ErrStepLimit, got %v", err)
+	}
+}
+
+// TestSnapshot round-trips a snapshot and checks that changes are reported.
+func TestSnapshot(t *testing.T) {
+	v := vm.New(0x30000, 0)
+	v.CPU.WriteU16(0, 0x7005) // moveq #5,d0
+	v.CPU.Running = true
+	if err := v.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := vm.WriteSnapshot(&sb, v.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	want, err := vm.ReadSnapshot(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.Regions) != 1 {
+		t.Errorf("expected one non-zero region, got %v", want.Regions)
+	}
+	if diffs := v.Snapshot().Diff(want); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+
+	v.CPU.D[3] = 1
+	v.CPU.Mem[0x20010] = 0xFF
+	diffs := v.Snapshot().Diff(want)
+	expected := []string{"D3: expected $00000000, got $00000001", "memory $00020000-$0002FFFF differs"}
+	if len(diffs) != 2 || diffs[0] != expected[0] || diffs[1] != expected[1] {
+		t.Errorf("expected %q, got %q", expected, diffs)
+	}
+}
+
+// TestMetrics runs a TRAP on each of two CPUs, raises interrupts and reads
+// the counters back through the Prometheus handler.
+func TestMetrics(t *testing.T) {
+	v := vm.New(0x100, 0)
+	v.CPU.WriteU16(0, 0x7005) // moveq #5,d0
+	v.CPU.WriteU16(2, 0x4E4F) // trap #15
+	second := v.AddCPU(0)
+	second.A[7] = 0x80
+	h := v.MetricsHandler("m68k_")
+	v.CPU.Running = true
+	second.Running = true
+	for v.CPU.Running || second.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A line held asserted counts once, and again once it is cleared.
+	for range 2 {
+		if err := v.RaiseInterrupt(2, cpu.Autovector); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.ClearInterrupt(2)
+	if err := v.RaiseInterrupt(2, cpu.Autovector); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.RaiseInterrupt(5, cpu.Autovector); err != nil {
+		t.Fatal(err)
+	}
+
+	m := v.Metrics()
+	if m.Instructions != 4 || m.Cycles != 2*(4+34) || m.Exceptions != 2 || m.Interrupts != 3 || len(m.CPUs) != 2 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+	if c := m.CPUs[0]; c.Instructions != 2 || c.Interrupts != 2 {
+		t.Errorf("unexpected metrics for the first CPU: %+v", c)
+	}
+	if got := v.PublishedMetrics(); got.Instructions != 0 {
+		t.Errorf("published metrics refreshed early: %+v", got)
+	}
+
+	v.UpdateMetrics()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE m68k_instructions_total counter\nm68k_instructions_total{cpu=\"0\"} 2\nm68k_instructions_total{cpu=\"1\"} 2\n",
+		"m68k_exceptions_total{cpu=\"1\"} 1\n",
+		"m68k_interrupts_total{cpu=\"0\"} 2\nm68k_interrupts_total{cpu=\"1\"} 1\n",
+		"# TYPE m68k_mips gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+// TestSandbox stops programs at each of the sandbox limits.
+func TestSandbox(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []uint16
+		cfg    vm.SandboxConfig
+		reason vm.SandboxReason
+	}{
+		{"Halt", []uint16{0x7005, 0x4E4F}, vm.SandboxConfig{}, vm.SandboxHalted},
+		{"Loop", []uint16{0x60FE}, vm.SandboxConfig{MaxInstructions: 100}, vm.SandboxInstructionLimit},
+		{"Trap", []uint16{0x4E41}, vm.SandboxConfig{}, vm.SandboxTrap},
+		// move.l d0,(a0)+ / bra.s *-2 with D0 non-zero fills memory.
+		{"Memory", []uint16{0x7001, 0x20C0, 0x60FC}, vm.SandboxConfig{MaxMemory: vm.SandboxPageSize}, vm.SandboxMemoryLimit},
+		// move.l d0,(a0) with A0 far outside memory.
+		{"Wild", []uint16{0x207C, 0x00F0, 0x0000, 0x2080}, vm.SandboxConfig{}, vm.SandboxFault},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := vm.New(0x10000, 0)
+			for i, w := range tc.code {
+				v.CPU.WriteU16(uint32(i*2), w)
+			}
+			v.CPU.A[0] = 0x1000
+			r := v.RunSandboxed(tc.cfg)
+			if r.Reason != tc.reason {
+				t.Errorf("expected %s, got %s (%s)", tc.reason, r.Reason, r.Error)
+			}
+			if v.CPU.Running {
+				t.Error("CPU still running after the sandbox stopped")
+			}
+		})
+	}
+}
+
+// TestSandboxHostAccess checks that the sandbox refuses a VM whose guest could
+// reach the host, through Easy68K's TRAP #15 or a block device image.
+func TestSandboxHostAccess(t *testing.T) {
+	// move.b #'X',d1 / moveq #6,d0 / trap #15: print a character.
+	v := vm.New(0x10000, 0)
+	for i, w := range []uint16{0x123C, 0x0058, 0x7006, 0x4E4F} {
+		v.CPU.WriteU16(uint32(i*2), w)
+	}
+	var out bytes.Buffer
+	v.EnableEasy68K(strings.NewReader(""), &out)
+	if r := v.RunSandboxed(vm.SandboxConfig{}); r.Reason != vm.SandboxRefused || out.Len() != 0 {
+		t.Errorf("expected Easy68K to be refused, got %s (%s) with %q printed", r.Reason, r.Error, out.String())
+	}
+
+	code, err := assembler.New().Assemble(`
+	movea.l	#$FF0300,a0
+	movea.l	#$2000,a1
+	move.b	#'!',(a1)
+	moveq	#0,d0
+	moveq	#1,d1
+	bsr	sys_block_write
+	bsr	sys_exit
+	include	"system.i"
+`, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := make(memDisk, vm.BlockSectorSize)
+	v = vm.New(0x10000, 0)
+	v.LoadCode(0x1000, code)
+	if err := v.EnableBlockDevice(0xFF0300, 3, disk, int64(len(disk))); err != nil {
+		t.Fatal(err)
+	}
+	v.CPU.PC, v.CPU.A[7] = 0x1000, 0x8000
+	if r := v.RunSandboxed(vm.SandboxConfig{}); r.Reason != vm.SandboxRefused || disk[0] != 0 {
+		t.Errorf("expected the block device to be refused, got %s (%s) with the image changed", r.Reason, r.Error)
+	}
+	if r := v.RunSandboxed(vm.SandboxConfig{}); !strings.Contains(r.Error, "block device") {
+		t.Errorf("expected the error to name the block device, got %q", r.Error)
+	}
+}
+
+// TestRun checks why Run stops and that it keeps to the clock rate.
+func TestRun(t *testing.T) {
+	load := func(code ...uint16) *vm.VM {
+		v := vm.New(0x10000, 0)
+		for i, w := range code {
+			v.CPU.WriteU16(uint32(i*2), w)
+		}
+		return v
+	}
+
+	// moveq #5,d0 / trap #15
+	v := load(0x7005, 0x4E4F)
+	if reason, err := v.Run(context.Background(), vm.RunOptions{}); reason != vm.RunHalted || err != nil || v.CPU.D[0] != 5 {
+		t.Errorf("expected the program to halt, got %s, %v", reason, err)
+	}
+
+	// nop / nop / bra.s *-4, with a breakpoint on the second nop.
+	v = load(0x4E71, 0x4E71, 0x60FA)
+	v.CPU.AddBreakpoint(2)
+	if reason, _ := v.Run(context.Background(), vm.RunOptions{}); reason != vm.RunBreakpoint || v.CPU.PC != 2 {
+		t.Errorf("expected a breakpoint at $2, got %s at $%x", reason, v.CPU.PC)
+	}
+	if reason, _ := v.Run(context.Background(), vm.RunOptions{MaxCycles: 100}); reason != vm.RunBreakpoint || v.CPU.Instructions < 3 {
+		t.Errorf("expected to resume past the breakpoint and reach it again, got %s after %d instructions", reason, v.CPU.Instructions)
+	}
+	v.CPU.RemoveBreakpoint(2)
+	if reason, _ := v.Run(context.Background(), vm.RunOptions{MaxCycles: 1000}); reason != vm.RunCycleLimit {
+		t.Errorf("expected the cycle limit, got %s", reason)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if reason, _ := v.Run(ctx, vm.RunOptions{}); reason != vm.RunCancelled {
+		t.Errorf("expected a cancelled run, got %s", reason)
+	}
+
+	// 20000 cycles at 1 MHz take 20ms.
+	start := time.Now()
+	if reason, _ := v.Run(context.Background(), vm.RunOptions{MaxCycles: 20000, ClockRate: 1000000}); reason != vm.RunCycleLimit {
+		t.Errorf("expected the cycle limit, got %s", reason)
+	}
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("expected the throttled run to take about 20ms, took %v", d)
+	}
+
+	// move.l d0,(a0) with A0 outside memory and no bus error vector.
+	v = load(0x207C, 0x00F0, 0x0000, 0x2080)
+	if reason, err := v.Run(context.Background(), vm.RunOptions{}); reason != vm.RunFault || err == nil {
+		t.Errorf("expected a fault, got %s, %v", reason, err)
+	}
+}
+
+// TestLiveDisassembly annotates operands with register contents and resolved addresses.
+func TestLiveDisassembly(t *testing.T) {
+	v := vm.New(0x10000, 0)
+	code, err := assembler.New().Assemble(`
+	move.l	(a0),d0
+	move.w	4(a1),-(a7)
+	lea	data(pc),a2
+	bne	data
+data:
+	dc.l	$CAFEBABE
+`, 0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.LoadCode(0x100, code)
+	v.CPU.PC = 0x100
+	v.CPU.A[0] = 0x10E // data, after a word-sized forward branch
+	v.CPU.A[1] = 0x10A
+	v.CPU.A[7] = 0x8000
+	v.CPU.D[0] = 7
+
+	list := v.Disassemble(0x100, 4)
+	want := []string{
+		"(a0)=$0000010E [$CAFEBABE] d0=$00000007",
+		"(4,a1)=$0000010E [$CAFE] -(a7)=$00007FFE [$0000]",
+		"(6,pc)=$0000010E [$CAFE] a2=$00000000",
+		"",
+	}
+	if len(list) != len(want) {
+		t.Fatalf("expected %d instructions, got %d", len(want), len(list))
+	}
+	for i, li := range list {
+		if got := strings.Join(li.Notes, " "); got != want[i] {
+			t.Errorf("%s: expected notes %q, got %q", li, want[i], got)
+		}
+	}
+	if got := list[3].Operands; got != "$10e" {
+		t.Errorf("expected an absolute branch target, got %s", got)
+	}
+}
+
+// TestTaint follows a value from memory through registers to a dereference.
+func TestTaint(t *testing.T) {
+	v := vm.New(0x10000, 0)
+	code, err := assembler.New().Assemble(`
+	lea	buf(pc),a0
+	move.l	(a0),d1
+	add.l	d1,d2
+	moveq	#0,d1
+	movea.l	d2,a1
+	move.w	(a1),d3
+	movem.l	d2/a1,-(a7)
+	trap	#15
+buf:
+	dc.l	buf
+`, 0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.LoadCode(0x100, code)
+	v.CPU.PC = 0x100
+	v.CPU.A[7] = 0x8000
+
+	var log strings.Builder
+	v.EnableTaint(&log)
+	if err := v.Taint("$114.l"); err != nil {
+		t.Fatal(err)
+	}
+	v.CPU.Running = true
+	for v.CPU.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := strings.Join(v.Tainted(), " ")
+	want := "d2 d3 a1 $00000114-$00000117 $00007FF8-$00007FFF"
+	if got != want {
+		t.Errorf("expected %s tainted, got %s", want, got)
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 logged instructions, got:\n%s", log.String())
+	}
+	for i, want := range []string{
+		"reads (a0)=$00000114, taints d1",
+		"reads d1, taints d2",
+		"clears d1",
+		"reads d2, taints a1",
+		"reads (a1)=$00000114, dereferences a1, taints d3",
+		"reads d2 a1, taints -(a7)=$00007FF8 -(a7)=$00007FFC",
+	} {
+		if _, after, _ := strings.Cut(lines[i], "; "); after != want {
+			t.Errorf("line %d: expected %q, got %q", i, want, lines[i])
+		}
+	}
+}
+
+// TestTraceCompare records a trace, reads it back and finds where a changed
+// program diverges from it.
+func TestTraceCompare(t *testing.T) {
+	record := func(code []byte) []vm.State {
+		v := vm.New(0x1000, 0)
+		v.LoadCode(0x100, code)
+		v.CPU.PC = 0x100
+		trace, err := v.RecordTrace(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return trace
+	}
+
+	// moveq #1,d0; moveq #2,d1; add.l d1,d0; trap #15
+	a := record([]byte{0x70, 0x01, 0x72, 0x02, 0xD0, 0x81, 0x4E, 0x4F})
+	if len(a) != 4 {
+		t.Fatalf("expected 4 steps, got %d", len(a))
+	}
+
+	var buf bytes.Buffer
+	if err := vm.WriteTrace(&buf, a); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := vm.ReadTrace(strings.NewReader("# converted\n" + buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := vm.CompareTraces(a, imported); d != nil {
+		t.Fatalf("trace changed after a round trip: %s", d)
+	}
+
+	// moveq #3,d1 instead of #2.
+	b := record([]byte{0x70, 0x01, 0x72, 0x03, 0xD0, 0x81, 0x4E, 0x4F})
+	d := vm.CompareTraces(a, b)
+	if d == nil || d.Step != 2 || len(d.Fields) != 1 || d.Fields[0] != "D1" {
+		t.Fatalf("expected D1 to diverge at step 2, got %v", d)
+	}
+
+	s, err := vm.ParseState("pc=$100 sr=2704 d0=5 a7=$1000")
+	if err != nil || s.PC != 0x100 || s.D[0] != 5 || s.A[7] != 0x1000 || s.SR != 0x2704 {
+		t.Errorf("ParseState returned %+v, %v", s, err)
+	}
+	if d := vm.CompareTraces(a, a[:2]); d == nil || d.Step != 2 || d.Fields[0] != "end" {
+		t.Errorf("expected the shorter trace to end at step 2, got %v", d)
+	}
+}
+
+// TestMemoryMap checks that ROM can be read but not written, that unmapped
+// addresses fault, and that ignored ROM writes leave it unchanged.
+func TestMemoryMap(t *testing.T) {
+	asm := assembler.New()
+	code, err := asm.Assemble(`
+	move.w	$2000,d0
+	move.w	d0,$1000
+	move.w	#$1234,$2000
+	moveq	#1,d2
+	trap	#15
+handler:
+	moveq	#2,d2
+	lea	handler2,a0
+	move.l	a0,$8
+	move.w	$3000,d3
+	trap	#15
+handler2:
+	moveq	#3,d2
+	trap	#15
+`, 0x400)
+	if err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+
+	run := func(ignore bool) *vm.VM {
+		v := vm.New(0x10000, 0)
+		v.LoadCode(0x400, code)
+		if err := v.LoadROM(0x2000, []byte{0xCA, 0xFE}, "rom"); err != nil {
+			t.Fatal(err)
+		}
+		m := v.MemoryMap()
+		m.IgnoreROMWrites = ignore
+		if err := m.Map(vm.Region{Start: 0x3000, Size: 0x1000, Kind: vm.RegionUnmapped, Name: "hole"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Map(vm.Region{Start: 0x2800, Size: 0x1000, Kind: vm.RegionRAM}); err == nil {
+			t.Error("expected overlapping regions to be rejected")
+		}
+		v.CPU.WriteU32(cpu.VectorBusError*4, asm.Labels()["handler"])
+		v.CPU.A[7] = 0x1000
+		v.CPU.PC = 0x400
+		v.CPU.Running = true
+		for v.CPU.Running {
+			if err := v.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return v
+	}
+
+	v := run(false)
+	if v.CPU.ReadU16(0x1000) != 0xCAFE {
+		t.Errorf("expected to read $CAFE from ROM, got $%04X", v.CPU.ReadU16(0x1000))
+	}
+	if v.CPU.D[2] != 3 {
+		t.Errorf("expected bus errors for the ROM write and the unmapped read, got d2=%d", v.CPU.D[2])
+	}
+	if v.CPU.ReadU16(0x2000) != 0xCAFE {
+		t.Error("ROM was overwritten")
+	}
+	// The monitor writes through the bus too, so ROM stays protected.
+	var out strings.Builder
+	if err := v.NewMonitor(strings.NewReader("MM.W 2000 1234\n"), &out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "read-only") || v.CPU.ReadU16(0x2000) != 0xCAFE {
+		t.Errorf("expected the monitor to refuse the ROM write, got:\n%s", out.String())
+	}
+	if _, err := v.CPU.Memory().ReadU16(0x3000); !errors.Is(err, cpu.ErrUnmapped) {
+		t.Errorf("expected ErrUnmapped from the hole, got %v", err)
+	}
+
+	v = run(true)
+	if v.CPU.D[2] != 1 || v.CPU.ReadU16(0x2000) != 0xCAFE {
+		t.Errorf("expected the ROM write to be ignored, got d2=%d and $%04X", v.CPU.D[2], v.CPU.ReadU16(0x2000))
+	}
+}
+
+// TestNumberStyle rewrites a listing and a register dump in one style.
+func TestNumberStyle(t *testing.T) {
+	if _, err := radix.Parse("0x,bold"); err == nil {
+		t.Error("expected an error for an unknown style")
+	}
+	style, err := radix.Parse("0x,upper,dec=16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := style.Apply("    move.l   #$a,($fff4,a0)\n    dc.b    '$ab'\nloc_00ab:\n    jmp      $1f0.l ; $beef\n")
+	want := "    move.l   #10,(0xFFF4,a0)\n    dc.b    '$ab'\nloc_00ab:\n    jmp      0x1F0.l ; 0xBEEF\n"
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	v := vm.New(0x100, 0)
+	v.CPU.D[0] = 0xABCDEF01
+	v.Numbers, _ = radix.Parse("$,lower")
+	var buf strings.Builder
+	v.WriteState(&buf, vm.StateCompact)
+	if !strings.HasPrefix(buf.String(), "PC=$00000000 SR=$2700 ") || !strings.Contains(buf.String(), "D=$abcdef01,") {
+		t.Errorf("unexpected compact state: %s", buf.String())
+	}
+	if _, err := vm.ParseState(buf.String()); err != nil {
+		t.Errorf("styled state doesn't parse: %v", err)
+	}
+}
+
+func TestTraceLog(t *testing.T) {
+	// moveq #1,d0; moveq #2,d1; add.l d1,d0; lea $200.w,a0; trap #15
+	code := []byte{0x70, 0x01, 0x72, 0x02, 0xD0, 0x81, 0x41, 0xF8, 0x02, 0x00, 0x4E, 0x4F}
+	run := func(opts vm.TraceOptions) []byte {
+		v := vm.New(0x1000, 0)
+		v.LoadCode(0x100, code)
+		v.CPU.PC = 0x100
+		v.CPU.Running = true
+		var buf bytes.Buffer
+		if err := v.EnableTraceLog(&buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		for v.CPU.Running {
+			if err := v.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := v.DisableTraceLog(); err != nil {
+			t.Fatal(err)
+		}
+		if v.CPU.OnBeforeExecute != nil || v.CPU.OnAfterExecute != nil {
+			t.Error("hooks left set after DisableTraceLog")
+		}
+		return buf.Bytes()
+	}
+
+	text := string(run(vm.TraceOptions{}))
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got:\n%s", text)
+	}
+	for i, want := range []string{"00000100  7001", "; D1=00000002", "; D0=00000003", "41F8 0200", "trap     #15"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("expected %q in line %d: %s", want, i, lines[i])
+		}
+	}
+
+	bin := run(vm.TraceOptions{Format: vm.TraceBinary, Ranges: []vm.TraceRange{{Start: 0x102, End: 0x106}}})
+	r, err := vm.NewTraceLogReader(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec.String())
+	}
+	if len(got) != 2 || got[0] != lines[1] || got[1] != lines[2] {
+		t.Errorf("binary log of $102-$106 doesn't match the text:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+// TestUARTEcho runs examples/echo.asm, which echoes the UART input from its
+// interrupt handler and main loop, with the input arriving while it sleeps.
+func TestUARTEcho(t *testing.T) {
+	src, err := os.ReadFile("../examples/echo.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm := assembler.New()
+	code, err := asm.Assemble(string(src), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(16*1024*1024, 0)
+	v.LoadCode(0, code)
+	if err := v.CPU.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	in, feed := io.Pipe()
+	var out bytes.Buffer
+	if err := v.EnableUART(0xFF0100, 8, in, &out); err == nil {
+		t.Error("expected an error for interrupt level 8")
+	}
+	if err := v.EnableUART(0xFF0100, 4, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for _, line := range []string{"Hello, ", "world!\n", "m68k\n"} {
+			io.WriteString(feed, line)
+		}
+		feed.Close()
+	}()
+
+	v.CPU.Running = true
+	for n := 0; v.CPU.Running && !v.Idle(); n++ {
+		if n == 10000 {
+			t.Fatalf("still running at $%08X with %q printed", v.CPU.PC, out.String())
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.Running {
+		t.Errorf("expected the program to halt, stopped at $%08X", v.CPU.PC)
+	}
+	if got, want := out.String(), "HELLO, WORLD!\nM68K\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if st, err := v.CPU.Bus.Read8(0xFF0100 + vm.UARTStatus); err != nil || st&vm.UARTEOF == 0 {
+		t.Errorf("expected the end of the input in the status register, got $%02X (%v)", st, err)
+	}
+}
+
+func TestUART(t *testing.T) {
+	src, err := os.ReadFile("../examples/uart.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm := assembler.New()
+	code, err := asm.Assemble(string(src), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0, code)
+	if err := v.CPU.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	in, feed := io.Pipe()
+	var out bytes.Buffer
+	// The UART lives outside RAM, in the memory map only.
+	if err := v.EnableUART(0xFF0100, 4, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EnableUART(0xFF0200, 4, in, &out); err == nil {
+		t.Error("expected an error for a second UART")
+	}
+	go func() {
+		for _, part := range []string{"one\n", "two", "\nthree\n"} {
+			io.WriteString(feed, part)
+		}
+		feed.Close()
+	}()
+
+	v.CPU.Running = true
+	for n := 0; v.CPU.Running && !v.Idle(); n++ {
+		if n == 20000 {
+			t.Fatalf("still running at $%08X with %q printed", v.CPU.PC, out.String())
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.Running {
+		t.Errorf("expected the program to halt, stopped at $%08X", v.CPU.PC)
+	}
+	want := "Counting characters and lines until the input ends.\n14 characters\n3 lines\n"
+	if got := out.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if st, err := v.CPU.Bus.Read8(0xFF0100 + vm.UARTStatus); err != nil || st != vm.UARTTxEmpty|vm.UARTEOF {
+		t.Errorf("expected status $%02X, got $%02X (%v)", vm.UARTTxEmpty|vm.UARTEOF, st, err)
+	}
+}
+
+// TestUARTWaitCancel checks that cancelling Run ends a wait for UART input
+// that will never come.
+func TestUARTWaitCancel(t *testing.T) {
+	src, err := os.ReadFile("../examples/uart.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := assembler.New().Assemble(string(src), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0, code)
+	if err := v.CPU.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	in, feed := io.Pipe()
+	defer feed.Close()
+	if err := v.EnableUART(0xFF0100, 4, in, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan vm.RunReason)
+	go func() {
+		reason, _ := v.Run(ctx, vm.RunOptions{})
+		done <- reason
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case reason := <-done:
+		if reason != vm.RunCancelled {
+			t.Errorf("expected %s, got %s", vm.RunCancelled, reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run still waiting for input after cancellation")
+	}
+	if !v.CPU.Stopped {
+		t.Errorf("expected the CPU to be stopped waiting for input, PC=$%08X", v.CPU.PC)
+	}
+}
+
+// TestUARTMonitor drives the monitor over the UART's line from a terminal
+// looped back to it: the monitor takes the commands, and while GO runs the
+// program the characters typed go to the program instead.
+func TestUARTMonitor(t *testing.T) {
+	src, err := os.ReadFile("../examples/uart.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := assembler.New().Assemble(string(src), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0, code)
+	if err := v.CPU.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.NewUARTMonitor(); err == nil {
+		t.Error("expected an error without a UART")
+	}
+	in, feed := io.Pipe()
+	screen, out := io.Pipe()
+	if err := v.EnableUART(0xFF0100, 4, in, out); err != nil {
+		t.Fatal(err)
+	}
+	m, err := v.NewUARTMonitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The terminal types commands, then a line for the program once it has
+	// printed its banner, and ends the input.
+	var seen strings.Builder
+	terminal := make(chan struct{})
+	go func() {
+		defer close(terminal)
+		io.WriteString(feed, "MD 0 8\nGO\n")
+		typed := false
+		buf := make([]byte, 256)
+		for {
+			n, err := screen.Read(buf)
+			seen.Write(buf[:n])
+			if !typed && strings.Contains(seen.String(), "until the input ends.\n") {
+				typed = true
+				io.WriteString(feed, "ab\ncd\n")
+				feed.Close()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	done := make(chan error)
+	go func() { done <- m.Run() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor still running")
+	}
+	out.Close()
+	<-terminal
+
+	got := seen.String()
+	for _, want := range []string{"m68k monitor", "00000000", "Counting characters and lines until the input ends.\n6 characters\n2 lines\n", "Halted after"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+// counterDevice counts the reads of its first register.
+type counterDevice struct{ reads uint8 }
+
+func (d *counterDevice) Read8(offset uint32) (uint8, error) {
+	if offset != 0 {
+		return uint8(offset), nil
+	}
+	d.reads++
+	return d.reads, nil
+}
+
+func (d *counterDevice) Write8(offset uint32, v uint8) error {
+	if offset == 0 {
+		d.reads = v
+		return nil
+	}
+	return errors.New("read-only register")
+}
+
+func TestMapDevice(t *testing.T) {
+	v := vm.New(0x10000, 0)
+	m := v.MemoryMap()
+	d := &counterDevice{}
+	if err := m.MapDevice(0x2000, 4, "counter", d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Map(vm.Region{Start: 0x3000, Size: 4, Kind: vm.RegionDevice}); err == nil {
+		t.Error("expected Map to refuse a device region")
+	}
+
+	// A long read straddling the device's start reaches it a byte at a time.
+	v.CPU.WriteU16(0x1FFE, 0xABCD)
+	if got, err := m.Read32(0x1FFE); err != nil || got != 0xABCD0101 {
+		t.Errorf("expected $ABCD0101, got $%08X (%v)", got, err)
+	}
+	if got, _ := m.Read16(0x2000); got != 0x0201 {
+		t.Errorf("expected the counter to advance to $0201, got $%04X", got)
+	}
+	if err := m.Write8(0x2000, 7); err != nil {
+		t.Fatal(err)
+	}
+	if d.reads != 7 {
+		t.Errorf("expected the counter set to 7, got %d", d.reads)
+	}
+	if err := m.Write16(0x2000, 0x0701); err == nil {
+		t.Error("expected the device's error")
+	}
+	if r, ok := m.Find(0x2003); !ok || r.Kind != vm.RegionDevice || r.Kind.String() != "device" {
+		t.Errorf("expected a device region, got %+v", r)
+	}
+
+	// Save states leave the device out and keep it mapped.
+	var st bytes.Buffer
+	if err := v.SaveState(&st); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.LoadState(&st); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := v.CPU.Bus.Read8(0x2000); got != 8 {
+		t.Errorf("expected the device to survive LoadState, got %d", got)
+	}
+}
+
+// TestTimer counts the ticks of a periodic timer from an autovectored
+// handler while the program sleeps in STOP, then times a one-shot.
+func TestTimer(t *testing.T) {
+	src := `
+	lea	tick(pc),a0
+	move.l	a0,$74			; Level 5 autovector
+	movea.l	#$FF0200,a5
+	move.b	#5,2(a5)		; TIMER_LEVEL
+	move.l	#1000,4(a5)		; TIMER_PERIOD
+	move.b	#$81,(a5)		; TIMER_INT|TIMER_ENABLE
+wait:
+	stop	#$2000
+	cmpi.w	#5,d7
+	bne.s	wait
+	clr.b	(a5)
+	stop	#$2700
+tick:
+	addq.w	#1,d7
+	move.b	#1,1(a5)		; Clear TIMER_EXPIRED
+	rte
+`
+	asm := assembler.New()
+	code, err := asm.Assemble(src, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x1000, code)
+	v.CPU.PC, v.CPU.A[7] = 0x1000, 0x8000
+	const base = 0xFF0200
+	if err := v.EnableTimer(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EnableTimer(base + 0x100); err == nil {
+		t.Error("expected an error for a second timer")
+	}
+
+	v.CPU.Running = true
+	for n := 0; v.CPU.Running && !v.Idle(); n++ {
+		if n == 1000 {
+			t.Fatalf("still running at $%08X after %d ticks", v.CPU.PC, v.CPU.D[7])
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.D[7] != 5 || v.TimerExpiries() != 5 {
+		t.Errorf("expected 5 ticks, got %d with %d expiries", v.CPU.D[7], v.TimerExpiries())
+	}
+	// Sleeping skips ahead, so five periods take little more than 5000 cycles.
+	if v.CPU.Cycles < 5000 || v.CPU.Cycles > 6000 {
+		t.Errorf("expected about 5000 cycles, got %d", v.CPU.Cycles)
+	}
+
+	// A one-shot expires once, counting down in the meantime, and stops.
+	m := v.CPU.Memory()
+	m.WriteU32(base+vm.TimerPeriod, 100)
+	m.WriteU8(base+vm.TimerControl, vm.TimerEnable|vm.TimerOneShot)
+	v.CPU.Cycles += 40
+	if got, _ := m.ReadU32(base + vm.TimerCount); got != 60 {
+		t.Errorf("expected 60 cycles left, got %d", got)
+	}
+	v.CPU.Cycles += 500
+	if got, _ := m.ReadU32(base + vm.TimerCount); got != 0 {
+		t.Errorf("expected a stopped timer to read 0, got %d", got)
+	}
+	if st, _ := m.ReadU8(base + vm.TimerStatus); st != vm.TimerExpired || v.TimerExpiries() != 6 {
+		t.Errorf("expected one more expiry, got status $%02X and %d", st, v.TimerExpiries())
+	}
+	if ctl, _ := m.ReadU8(base + vm.TimerControl); ctl != vm.TimerOneShot {
+		t.Errorf("expected the one-shot to stop, control is $%02X", ctl)
+	}
+}
+
+// frameCounter is a Display that counts the frames and keeps the last.
+type frameCounter struct {
+	frames int
+	last   *image.RGBA
+}
+
+func (d *frameCounter) Show(frame *image.RGBA) error {
+	d.frames++
+	d.last = image.NewRGBA(frame.Rect)
+	copy(d.last.Pix, frame.Pix)
+	return nil
+}
+
+// TestFramebuffer draws into indexed and true colour framebuffers and reads
+// the pictures back as images, PPM and PNG.
+func TestFramebuffer(t *testing.T) {
+	v := vm.New(0x10000, 0)
+	red, blue := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}
+	fb, err := v.EnableFramebuffer(vm.FramebufferConfig{
+		Base: 0x4000, Width: 4, Height: 2, BPP: 2,
+		Palette:     []color.Color{color.Black, red, blue},
+		FrameCycles: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.EnableFramebuffer(vm.FramebufferConfig{Base: 0x9000, Width: 4, Height: 2, BPP: 8}); err == nil {
+		t.Error("expected an error for a second framebuffer")
+	}
+	var d frameCounter
+	fb.SetDisplay(&d)
+
+	// Row 0 is black, red, blue, index 3 (past the palette); row 1 is red.
+	code, err := assembler.New().Assemble(`
+	move.b	#%00011011,$4000
+	move.b	#%01010101,$4001
+loop:
+	bra.s	loop
+`, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.LoadCode(0x1000, code)
+	v.CPU.PC, v.CPU.Running = 0x1000, true
+	for v.CPU.Cycles < 1000 {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.frames < 9 || d.frames > 10 || uint64(d.frames) != fb.Frames() {
+		t.Errorf("expected about 10 frames, got %d (%d counted)", d.frames, fb.Frames())
+	}
+	want := []color.RGBA{{A: 0xFF}, red, blue, {A: 0xFF}, red, red, red, red}
+	img := fb.Image()
+	for i, c := range want {
+		if got := img.RGBAAt(i%4, i/4); got != c {
+			t.Errorf("pixel %d,%d: expected %v, got %v", i%4, i/4, c, got)
+		}
+	}
+	if !bytes.Equal(d.last.Pix, img.Pix) {
+		t.Error("expected the display to show the picture")
+	}
+
+	var ppm bytes.Buffer
+	if err := fb.WritePPM(&ppm); err != nil {
+		t.Fatal(err)
+	}
+	if want := "P6\n4 2\n255\n\x00\x00\x00\xff\x00\x00\x00\x00\xff\x00\x00\x00"; !strings.HasPrefix(ppm.String(), want) || ppm.Len() != len(want)+12 {
+		t.Errorf("unexpected PPM %q", ppm.String())
+	}
+	var buf bytes.Buffer
+	if err := fb.WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := png.Decode(&buf); err != nil || color.RGBAModel.Convert(p.At(2, 0)) != blue {
+		t.Errorf("expected a PNG with blue at 2,0, got %v", err)
+	}
+
+	// RGB565 and xRGB.
+	v = vm.New(0x10000, 0)
+	fb, _ = v.EnableFramebuffer(vm.FramebufferConfig{Base: 0x100, Width: 1, Height: 1, BPP: 16})
+	v.CPU.Memory().WriteU16(0x100, 0xF81F)
+	if got := fb.Image().RGBAAt(0, 0); got != (color.RGBA{0xFF, 0, 0xFF, 0xFF}) {
+		t.Errorf("expected magenta, got %v", got)
+	}
+	v = vm.New(0x10000, 0)
+	fb, _ = v.EnableFramebuffer(vm.FramebufferConfig{Base: 0x100, Width: 1, Height: 1, BPP: 32})
+	v.CPU.Memory().WriteU32(0x100, 0x00123456)
+	if got := fb.Image().RGBAAt(0, 0); got != (color.RGBA{0x12, 0x34, 0x56, 0xFF}) {
+		t.Errorf("expected $123456, got %v", got)
+	}
+	if _, err := vm.New(0x100, 0).EnableFramebuffer(vm.FramebufferConfig{Width: 320, Height: 200, BPP: 8}); err == nil {
+		t.Error("expected an error for a framebuffer past the end of memory")
+	}
+}
+
+// memDisk is a BlockImage in memory.
+type memDisk []byte
+
+func (d memDisk) ReadAt(p []byte, off int64) (int, error) {
+	n := copy(p, d[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d memDisk) WriteAt(p []byte, off int64) (int, error) {
+	return copy(d[off:], p), nil
+}
+
+// TestBlockDevice copies a sector through memory with the system.i calls
+// and checks that bad transfers set the error bit.
+func TestBlockDevice(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	movea.l	#$FF0300,a0
+	movea.l	#$2000,a1
+	moveq	#1,d0
+	moveq	#1,d1
+	bsr	sys_block_read
+	move.l	d0,d6
+	move.b	#'!',$2000
+	moveq	#2,d0
+	moveq	#1,d1
+	bsr	sys_block_write
+	add.l	d0,d6
+	moveq	#3,d0
+	moveq	#2,d1
+	bsr	sys_block_read
+	move.l	d0,d7
+	bsr	sys_exit
+	include	"system.i"
+`, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := make(memDisk, 4*vm.BlockSectorSize)
+	copy(disk[vm.BlockSectorSize:], "sector one")
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x1000, code)
+	if err := v.EnableBlockDevice(0xFF0300, 3, disk, int64(len(disk))); err != nil {
+		t.Fatal(err)
+	}
+	v.CPU.PC, v.CPU.A[7], v.CPU.Running = 0x1000, 0x8000, true
+	for n := 0; v.CPU.Running; n++ {
+		if n == 1000 {
+			t.Fatalf("still running at $%08X", v.CPU.PC)
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := string(disk[2*vm.BlockSectorSize:][:10]); got != "!ector one" || v.CPU.D[6] != 0 {
+		t.Errorf("expected the sector copied, got %q with D6 = %d", got, int32(v.CPU.D[6]))
+	}
+	// The second of the two sectors is past the end, after the first arrived.
+	if int32(v.CPU.D[7]) != -1 {
+		t.Errorf("expected D7 = -1, got %d", int32(v.CPU.D[7]))
+	}
+	m := v.CPU.Memory()
+	if sector, _ := m.ReadU32(0xFF0300 + vm.BlockSector); sector != 4 {
+		t.Errorf("expected the failed transfer to stop at sector 4, got %d", sector)
+	}
+	if n, _ := m.ReadU32(0xFF0300 + vm.BlockSectors); n != 4 {
+		t.Errorf("expected 4 sectors, got %d", n)
+	}
+
+	// The interrupt follows the status until the bits are written back.
+	m.WriteU8(0xFF0300+vm.BlockControl, vm.BlockInterrupt)
+	if v.CPU.PendingInterrupts()&(1<<3) == 0 {
+		t.Error("expected the block device to interrupt at level 3")
+	}
+	m.WriteU8(0xFF0300+vm.BlockStatus, vm.BlockDone|vm.BlockError)
+	if v.CPU.PendingInterrupts() != 0 {
+		t.Error("expected clearing the status to drop the interrupt")
+	}
+}
+
+// TestKeyboard queues keys from a reader and InjectKey for an interrupt
+// handler, and checks the overflow bit and the queue in save states.
+func TestKeyboard(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	org	$400
+start:
+	lea	handler(pc),a0
+	move.l	a0,$74			; Level 5 autovector
+	lea	buffer,a1
+	lea	$FF0300,a0
+	move.b	#$80,1(a0)		; KEYBOARD_INT in KEYBOARD_CONTROL
+wait:
+	stop	#$2000
+	cmp.w	#3,d2
+	bne.s	wait
+	trap	#15
+handler:
+	move.w	4(a0),(a1)+		; KEYBOARD_FLAGS and KEYBOARD_CODE
+	addq.w	#1,d2
+	rte
+buffer:	ds.w	4
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x400, code)
+	v.CPU.PC, v.CPU.A[7] = 0x400, 0x8000
+	if err := v.InjectKey(vm.KeyEvent{Code: 'x'}); !errors.Is(err, vm.ErrNoKeyboard) {
+		t.Errorf("expected ErrNoKeyboard, got %v", err)
+	}
+	in, feed := io.Pipe()
+	if err := v.EnableKeyboard(0xFF0300, 5, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.InjectKey(vm.KeyEvent{Code: 0x1B, Flags: vm.KeyReleased | vm.KeyShift}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		io.WriteString(feed, "ab")
+		feed.Close()
+	}()
+
+	v.CPU.Running = true
+	for n := 0; v.CPU.Running && !v.Idle(); n++ {
+		if n == 1000 {
+			t.Fatalf("still running at $%08X", v.CPU.PC)
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got [3]uint16
+	for i := range got {
+		got[i] = v.CPU.ReadU16(v.CPU.A[1] - 6 + uint32(i)*2)
+	}
+	if want := [3]uint16{0x811B, 'a', 'b'}; v.CPU.Running || got != want {
+		t.Errorf("expected events %04X, got %04X (running %v)", want, got, v.CPU.Running)
+	}
+
+	w := vm.New(0x1000, 0)
+	if err := w.EnableKeyboard(0xFF0300, 5, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := range vm.KeyboardQueueSize + 1 {
+		w.InjectKey(vm.KeyEvent{Code: uint8(i)})
+	}
+	status, _ := w.CPU.Bus.Read8(0xFF0300 + vm.KeyboardStatus)
+	count, _ := w.CPU.Bus.Read8(0xFF0300 + vm.KeyboardCount)
+	if status != vm.KeyboardReady|vm.KeyboardOverflow || count != vm.KeyboardQueueSize {
+		t.Errorf("expected a full queue with the overflow bit, got status $%02X and %d events", status, count)
+	}
+	w.CPU.Bus.Read8(0xFF0300 + vm.KeyboardCode)
+	var saved bytes.Buffer
+	if err := w.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	x := vm.New(0x1000, 0)
+	if err := x.LoadState(bytes.NewReader(saved.Bytes())); err == nil {
+		t.Error("expected an error loading a keyboard state without a keyboard")
+	}
+	x.EnableKeyboard(0xFF0300, 5, nil)
+	if err := x.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := x.CPU.Bus.Read8(0xFF0300 + vm.KeyboardCode); code != 1 {
+		t.Errorf("expected the restored queue to continue with key 1, got %d", code)
+	}
+}
+
+// TestAudio plays a rising count through the audio device, topped up from
+// its half-empty interrupt, and checks what reaches the callback.
+func TestAudio(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	org	$400
+start:
+	lea	handler(pc),a0
+	move.l	a0,$78			; Level 6 autovector
+	lea	$FF0400,a0
+	move.l	#80000,4(a0)		; AUDIO_RATE: a frame every 100 cycles
+	move.b	#$05,(a0)		; AUDIO_ENABLE and AUDIO_16BIT
+	bsr.s	fill
+	move.b	#$85,(a0)		; And AUDIO_INT
+wait:
+	stop	#$2000
+	cmp.w	#64,d1
+	bcs.s	wait
+	trap	#15
+handler:
+	bsr.s	fill
+	rte
+fill:
+	tst.w	12(a0)			; AUDIO_FREE
+	beq.s	full
+	move.w	d1,8(a0)		; AUDIO_DATA
+	addq.w	#1,d1
+	bra.s	fill
+full:
+	rts
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x400, code)
+	v.CPU.PC, v.CPU.A[7] = 0x400, 0x8000
+	a, err := v.EnableAudio(vm.AudioConfig{Base: 0xFF0400, Level: 6, BufferSamples: 16, ChunkSamples: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Audio() != a {
+		t.Error("expected Audio to return the device")
+	}
+	var played []int16
+	chunks := 0
+	a.SetCallback(func(samples []int16) {
+		played = append(played, samples...)
+		chunks++
+	})
+
+	v.CPU.Running = true
+	for n := 0; v.CPU.Running && !v.Idle(); n++ {
+		if n == 100000 {
+			t.Fatalf("still running at $%08X", v.CPU.PC)
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.Running || chunks == 0 || len(played) != chunks*8 {
+		t.Fatalf("expected whole chunks of 8 samples, got %d samples in %d chunks (running %v)", len(played), chunks, v.CPU.Running)
+	}
+	for i, s := range played {
+		if s != int16(i) {
+			t.Fatalf("expected sample %d to be %d, got %d", i, i, s)
+		}
+	}
+	if written := int(v.CPU.D[1] & 0xFFFF); len(played)+a.Buffered() > written || written < 64 {
+		t.Errorf("played %d and buffered %d of %d samples", len(played), a.Buffered(), written)
+	}
+	if status, _ := v.CPU.Bus.Read8(0xFF0400 + vm.AudioStatus); status&(vm.AudioOverrun|vm.AudioUnderrun) != 0 {
+		t.Errorf("expected no overrun or underrun, got status $%02X", status)
+	}
+	if rate, channels := a.Format(); rate != 80000 || channels != 1 {
+		t.Errorf("expected 80000 Hz mono, got %d Hz and %d channels", rate, channels)
+	}
+
+	var saved bytes.Buffer
+	if err := v.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	w := vm.New(0x10000, 0)
+	if err := w.LoadState(bytes.NewReader(saved.Bytes())); err == nil {
+		t.Error("expected an error loading an audio state without an audio device")
+	}
+	b, _ := w.EnableAudio(vm.AudioConfig{Base: 0xFF0400, Level: 6, BufferSamples: 16})
+	if err := w.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if b.Buffered() != a.Buffered() {
+		t.Errorf("expected %d samples queued after loading, got %d", a.Buffered(), b.Buffered())
+	}
+
+	// Bytes are widened to words, and the buffer drops what doesn't fit.
+	x := vm.New(0x1000, 0)
+	c, _ := x.EnableAudio(vm.AudioConfig{Base: 0xFF0400, BufferSamples: 2})
+	for _, s := range []uint8{0x40, 0x80, 0x7F} {
+		x.CPU.Bus.Write8(0xFF0400+vm.AudioData, s)
+	}
+	status, _ := x.CPU.Bus.Read8(0xFF0400 + vm.AudioStatus)
+	if c.Buffered() != 2 || status != vm.AudioOverrun {
+		t.Errorf("expected a full buffer with the overrun bit, got %d samples and status $%02X", c.Buffered(), status)
+	}
+	var got []int16
+	c.SetCallback(func(samples []int16) { got = append(got, samples...) })
+	x.CPU.Bus.Write32(0xFF0400+vm.AudioRate, 8000)
+	x.CPU.Bus.Write8(0xFF0400+vm.AudioControl, vm.AudioEnable)
+	x.CPU.Cycles += 3000
+	c.Flush()
+	if len(got) != 2 || got[0] != 0x4000 || got[1] != -0x8000 {
+		t.Errorf("expected samples 4000 and -8000, got %X", got)
+	}
+}
+
+// TestRTC reads the real-time clock from a clock that advances a second
+// every million cycles.
+func TestRTC(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	org	$400
+	lea	$FF0500,a0
+	move.b	#$55,6(a0)		; Ignored
+	movem.l	(a0),d0-d4
+	move.l	#200000,d5
+wait:
+	subq.l	#1,d5
+	bne.s	wait
+	move.w	2(a0),d6		; RTC_MONTH and RTC_DAY
+	move.b	6(a0),d7		; RTC_SECOND
+	trap	#15
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x400, code)
+	v.CPU.PC = 0x400
+	start := time.Date(2026, time.February, 28, 23, 59, 58, 0, time.UTC)
+	clock := func() time.Time {
+		return start.Add(time.Duration(v.CPU.Cycles) * time.Microsecond)
+	}
+	if err := v.EnableRTC(0xFF0500, clock); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EnableRTC(0xFF0600, nil); err == nil {
+		t.Error("expected an error mapping a second clock")
+	}
+	v.CPU.Running = true
+	for v.CPU.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := v.CPU.D
+	if d[0] != 2026<<16|2<<8|28 || d[1] != 23<<24|59<<16|58<<8|6 {
+		t.Errorf("expected Saturday 2026-02-28 23:59:58, got $%08X $%08X", d[0], d[1])
+	}
+	if d[2] != 0 || d[3] == 0 || d[3] > 100 {
+		t.Errorf("expected a small uptime, got $%08X%08X", d[2], d[3])
+	}
+	if d[4] != uint32(start.Unix()) {
+		t.Errorf("expected %d seconds since 1970, got %d", start.Unix(), d[4])
+	}
+	if date, sec := uint16(d[6]), uint8(d[7]); date != 3<<8|1 || sec > 5 {
+		t.Errorf("expected the clock to pass midnight into March, got $%04X and second %d", date, sec)
+	}
+
+	var saved bytes.Buffer
+	if err := v.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.New(0x10000, 0).LoadState(bytes.NewReader(saved.Bytes())); err == nil {
+		t.Error("expected an error loading a clock state without a clock")
+	}
+}
+
+// TestMultiCPU has two CPUs count to 200 in shared memory, taking turns
+// through a TAS lock.
+func TestMultiCPU(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	org	$400
+	bra.s	first
+	bra.s	second
+first:
+	bsr.s	work
+wait:
+	tst.b	done
+	beq.s	wait
+	trap	#15
+second:
+	bsr.s	work
+	st	done
+	trap	#15
+work:
+	moveq	#99,d0
+loop:
+	tas	lock
+	bmi.s	loop
+	move.w	count,d1
+	addq.w	#1,d1
+	move.w	d1,count
+	clr.b	lock
+	dbf	d0,loop
+	rts
+lock:	dc.b	0
+done:	dc.b	0
+count:	dc.w	0
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x400, code)
+	v.CPU.PC, v.CPU.A[7] = 0x400, 0x8000
+	c := v.AddCPU(16)
+	c.PC, c.A[7], c.Running = 0x402, 0x7000, true
+	if cpus := v.CPUs(); len(cpus) != 2 || cpus[0] != v.CPU || cpus[1] != c {
+		t.Fatalf("expected both CPUs, got %v", cpus)
+	}
+	if err := v.EnableRTC(0xFF0500, nil); err != nil {
+		t.Fatal(err)
+	}
+	if year, err := c.Bus.Read16(0xFF0500 + vm.RTCYear); err != nil || year < 2000 {
+		t.Errorf("expected the second CPU to see the clock mapped later, got %d, %v", year, err)
+	}
+
+	v.CPU.Running = true
+	for n := 0; v.Running() && !v.Idle(); n++ {
+		if n == 100000 {
+			t.Fatalf("still running at $%08X and $%08X", v.CPU.PC, c.PC)
+		}
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := v.CPU.ReadU16(0x400 + uint32(len(code)) - 2)
+	if count != 200 || v.CPU.D[1] == c.D[1] {
+		t.Errorf("expected a count of 200 shared between the CPUs, got %d (D1 %d and %d)", count, v.CPU.D[1], c.D[1])
+	}
+	if diff := int64(v.CPU.Cycles) - int64(c.Cycles); diff < 0 {
+		t.Errorf("expected the first CPU to finish last, %d cycles behind", -diff)
+	}
+
+	var sb strings.Builder
+	if err := v.WriteCPUState(&sb, vm.StateCompact, 1); err != nil || !strings.HasPrefix(sb.String(), "PC=") {
+		t.Errorf("expected the second CPU's registers, got %q, %v", sb.String(), err)
+	}
+	if err := v.WriteCPUState(&sb, vm.StateCompact, 2); err == nil {
+		t.Error("expected an error for a third CPU")
+	}
+
+	var saved bytes.Buffer
+	if err := v.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	w := vm.New(0x10000, 0)
+	w.EnableRTC(0xFF0500, nil)
+	if err := w.LoadState(bytes.NewReader(saved.Bytes())); err == nil || !strings.Contains(err.Error(), "2 CPUs") {
+		t.Errorf("expected an error loading two CPUs into one, got %v", err)
+	}
+	w.AddCPU(0)
+	if err := w.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.CPUs()[1]; got.PC != c.PC || got.D != c.D {
+		t.Errorf("expected the second CPU restored at $%08X, got $%08X", c.PC, got.PC)
+	}
+}
+
+// TestSaveState pauses a program halfway, restores it into another VM and
+// checks both finish in the same state.
+func TestSaveState(t *testing.T) {
+	src := `
+	org $400
+start:
+	lea $F00,a0
+	moveq #99,d2
+loop:
+	add.l (a0),d0
+	addq.l #1,d1
+	dbra d2,loop
+	trap #15
+`
+	code, err := assembler.New().Assemble(src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVM := func() *vm.VM {
+		v := vm.New(0x10000, 16)
+		v.LoadCode(0x400, code)
+		v.CPU.PC = 0x400
+		if err := v.EnableRandom(0xF00, 7); err != nil {
+			t.Fatal(err)
+		}
+		v.CPU.Running = true
+		return v
+	}
+	run := func(v *vm.VM, steps int) {
+		for n := 0; v.CPU.Running && n != steps; n++ {
+			if err := v.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	v := newVM()
+	v.CPU.SetModel(cpu.MC68010)
+	v.CPU.AddressMask = cpu.Address32
+	v.CPU.DFC = 5
+	run(v, 150)
+	if err := v.RaiseInterrupt(3, cpu.Autovector); err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := v.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	v.ClearInterrupt(3)
+	run(v, -1)
+
+	w := vm.New(0x10000, 16)
+	if err := w.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.CPU.PendingInterrupts(); got != 1<<3 {
+		t.Errorf("expected level 3 pending after loading, got %08b", got)
+	}
+	if w.CPU.Model != cpu.MC68010 || w.CPU.DFC != 5 || w.CPU.AddressMask != cpu.Address32 {
+		t.Errorf("expected the 68010 with DFC 5 and 32-bit addresses after loading, got %s with DFC %d and mask $%08X", w.CPU.Model, w.CPU.DFC, w.CPU.AddressMask)
+	}
+	w.ClearInterrupt(3)
+	run(w, -1)
+	if w.State() != v.State() || w.CPU.Cycles != v.CPU.Cycles || w.CPU.Instructions != v.CPU.Instructions {
+		t.Errorf("expected the restored run to finish like the original:\n%+v\n%+v", v.State(), w.State())
+	}
+	if !bytes.Equal(w.CPU.Mem, v.CPU.Mem) {
+		t.Error("memory differs after the restored run")
+	}
+
+	if err := vm.New(0x8000, 0).LoadState(bytes.NewReader(saved.Bytes())); err == nil {
+		t.Error("expected an error loading into a VM with less memory")
+	}
+	if err := vm.New(0x10000, 0).LoadState(bytes.NewReader(saved.Bytes()[:saved.Len()/2])); err == nil {
+		t.Error("expected an error for a truncated save state")
+	}
+	bad := bytes.Clone(saved.Bytes())
+	bad[9] = 99
+	if err := vm.New(0x10000, 0).LoadState(bytes.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("expected an unsupported version error, got %v", err)
+	}
+}
+
+// TestSaveStateFPU checks that the FPU registers survive a save and load,
+// and that a state with an FPU needs one to load into.
+func TestSaveStateFPU(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	machine	68020
+	fpu
+	org	$400
+	fmove.d	#1.5,fp0
+	fmove.l	#10,fp1
+	fmove.l	#$0010,fpcr
+	fmul.x	fp0,fp1
+	fmove.l	#3,fp2
+	fdiv.x	fp2,fp1
+	fmove.l	fp1,d1
+	trap	#15
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVM := func(fpu bool) *vm.VM {
+		v := vm.New(0x10000, 16)
+		v.CPU.SetModel(cpu.MC68020)
+		if fpu {
+			v.CPU.SetCoprocessor(1, cpu.NewFPU())
+		}
+		return v
+	}
+	v := newVM(true)
+	v.LoadCode(0x400, code)
+	v.CPU.PC = 0x400
+	v.CPU.Running = true
+	for range 4 {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var saved bytes.Buffer
+	if err := v.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newVM(true)
+	if err := w.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	f, g := v.CPU.Coprocessors[1].(*cpu.FPU), w.CPU.Coprocessors[1].(*cpu.FPU)
+	if *g != *f {
+		t.Errorf("expected the FPU as saved:\n%+v\ngot\n%+v", *f, *g)
+	}
+	for _, m := range []*vm.VM{v, w} {
+		for m.CPU.Running {
+			if err := m.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if w.CPU.D[1] != v.CPU.D[1] || *g != *f {
+		t.Errorf("expected the restored run to finish like the original, got d1=%d and %d", w.CPU.D[1], v.CPU.D[1])
+	}
+
+	if err := newVM(false).LoadState(bytes.NewReader(saved.Bytes())); err == nil || !strings.Contains(err.Error(), "FPU") {
+		t.Errorf("expected an error loading an FPU into a VM without one, got %v", err)
+	}
+}
+
+// TestRunStats checks the stack peak and memory high-water mark of a program
+// that nests subroutine calls and writes a buffer.
+func TestRunStats(t *testing.T) {
+	src := `
+	org $400
+start:
+	movea.l #$8000,a7
+	bsr outer
+	trap #15
+outer:
+	movem.l d0-d3,-(a7)
+	bsr inner
+	movem.l (a7)+,d0-d3
+	rts
+inner:
+	lea $3000,a0
+	move.l #$12345678,(a0)+
+	move.w d0,(a0)
+	rts
+`
+	code, err := assembler.New().Assemble(src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x400, code)
+	v.CPU.PC = 0x400
+	v.EnableRunStats()
+	v.CPU.Running = true
+	for v.CPU.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := v.RunStats()
+	if s.Instructions != v.CPU.Instructions || s.Cycles != v.CPU.Cycles {
+		t.Errorf("expected the CPU counters, got %d instructions and %d cycles", s.Instructions, s.Cycles)
+	}
+	// Two return addresses and four saved registers.
+	if s.SupervisorStack != 24 {
+		t.Errorf("expected a 24-byte stack peak, got %d", s.SupervisorStack)
+	}
+	if !s.Written || s.HighWater != 0x7FFF {
+		t.Errorf("expected the top of the stack as the high-water mark, got $%08X", s.HighWater)
+	}
+	if !strings.Contains(s.String(), "24 bytes supervisor") {
+		t.Errorf("unexpected summary:\n%s", s)
+	}
+}
+
+// TestEasy68K runs a program written for the Easy68K simulator, using its
+// TRAP #15 tasks for output, input and halting.
+func TestEasy68K(t *testing.T) {
+	src := `
+* Easy68K style
+CR	EQU	$0D
+LF	EQU	$0A
+	ORG	$1000
+START:
+	LEA	PROMPT,A1
+	MOVE.B	#18,D0
+	TRAP	#15		; print the prompt, read D1
+	ADD.L	D1,D1
+	MOVE.B	#3,D0
+	TRAP	#15		; print D1
+	LEA	NAME,A1
+	MOVE.B	#2,D0
+	TRAP	#15		; read a line
+	MOVE.B	#0,D0
+	TRAP	#15		; print it back, D1.W long
+	MOVE.L	#255,D1
+	MOVE.B	#16,D2
+	MOVE.B	#15,D0
+	TRAP	#15		; print D1 in hex
+	SIMHALT
+	MOVE.B	#14,D0		; never reached
+	TRAP	#15
+PROMPT:	DC.B	'Number? ',0
+NAME:	DS.B	82
+	END	START
+`
+	asm := assembler.New()
+	code, err := asm.Assemble(src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := asm.Entry()
+	if !ok || entry != 0x1000 || asm.Origin() != 0x1000 {
+		t.Fatalf("expected the code at and starting from $1000, got origin $%X and entry $%X", asm.Origin(), entry)
+	}
+
+	v := vm.New(0x10000, 0)
+	v.LoadCode(asm.Origin(), code)
+	v.CPU.PC = entry
+	var out bytes.Buffer
+	v.EnableEasy68K(strings.NewReader("21\r\nAda\n"), &out)
+	v.CPU.Running = true
+	for v.CPU.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := out.String(), "Number? 42Ada\nFF"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if v.CPU.D[1]&0xFFFF != 255 {
+		t.Errorf("expected D1 untouched by task 15, got $%08X", v.CPU.D[1])
+	}
+
+	// An unknown task fails the TRAP.
+	v.CPU.D[0] = 99
+	v.CPU.WriteU16(0x2000, 0x4E4F) // trap #15
+	v.CPU.PC = 0x2000
+	v.CPU.Running = true
+	if err := v.Step(); err == nil || !strings.Contains(err.Error(), "task 99") {
+		t.Errorf("expected an unsupported task error, got %v", err)
+	}
+}
+
+func TestOnTrap(t *testing.T) {
+	src := `
+	moveq	#20,d1
+	trap	#3		; host call: d1 = d1*2+1
+	move.l	d1,d2
+	trap	#15
+`
+	asm := assembler.New()
+	code, err := asm.Assemble(src, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New(0x10000, 0)
+	var calledFrom uint32
+	if err := v.OnTrap(3, func(c *cpu.CPU) error {
+		calledFrom = c.PC
+		c.D[1] = c.D[1]*2 + 1
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	v.LoadCode(0x1000, code)
+	v.CPU.PC = 0x1000
+	v.CPU.Running = true
+	for v.CPU.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.D[2] != 41 {
+		t.Errorf("expected the handler's result 41 in D2, got %d", v.CPU.D[2])
+	}
+	if calledFrom != 0x1004 {
+		t.Errorf("expected the handler to see the PC after the TRAP ($1004), got $%X", calledFrom)
+	}
+	if n := v.CPU.ExceptionCounts()[cpu.VectorTrap0+3]; n != 0 {
+		t.Errorf("expected no exception for a host trap, got %d", n)
+	}
+
+	// Errors fail the instruction, and nil restores the exception.
+	v.OnTrap(3, func(*cpu.CPU) error { return errors.New("no such service") })
+	v.CPU.PC, v.CPU.Running = 0x1002, true
+	if err := v.Step(); err == nil || !strings.Contains(err.Error(), "no such service") {
+		t.Errorf("expected the handler's error, got %v", err)
+	}
+	v.OnTrap(3, nil)
+	v.CPU.WriteU32(0x8C, 0x1006) // trap #3 vector
+	v.CPU.A[7] = 0x8000
+	v.CPU.PC, v.CPU.Running = 0x1002, true
+	if err := v.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if v.CPU.PC != 0x1006 {
+		t.Errorf("expected TRAP #3 to vector to $1006, got $%X", v.CPU.PC)
+	}
+	if err := v.OnTrap(16, nil); err == nil {
+		t.Error("expected an error for TRAP #16")
+	}
+}
+
+// TestLineTraps checks that line 1010 and line 1111 opcodes take their
+// emulator exceptions, and that host handlers replace them per opcode.
+func TestLineTraps(t *testing.T) {
+	code, err := assembler.New().Assemble(`
+	moveq	#1,d0
+	dc.w	$a9f4,$0007	; host call with an argument word
+	dc.w	$a123
+	dc.w	$f000
+	trap	#15
+`, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New(0x10000, 0)
+	v.LoadCode(0x1000, code)
+	v.CPU.WriteU32(uint32(cpu.VectorLineA)*4, 0x2000)
+	v.CPU.WriteU32(uint32(cpu.VectorLineF)*4, 0x3000)
+	v.CPU.A[7] = 0x8000
+	if err := v.OnLineTrap(0xA9F4, func(c *cpu.CPU) error {
+		c.D[0] += uint32(c.ReadU16(c.PC))
+		c.PC += 2
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	v.CPU.PC, v.CPU.Running = 0x1000, true
+	for range 3 {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.CPU.D[0] != 8 {
+		t.Errorf("expected the handler to add its argument word, got D0=%d", v.CPU.D[0])
+	}
+	if v.CPU.PC != 0x2000 || v.CPU.ReadU32(v.CPU.A[7]+2) != 0x1006 {
+		t.Errorf("expected $A123 to take the line 1010 exception for $1006, got PC=$%X", v.CPU.PC)
+	}
+	v.CPU.PC = 0x1008
+	if err := v.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if v.CPU.PC != 0x3000 {
+		t.Errorf("expected $F000 to take the line 1111 exception, got PC=$%X", v.CPU.PC)
+	}
+
+	// Removing the handler restores the exception.
+	v.OnLineTrap(0xA9F4, nil)
+	v.CPU.PC = 0x1002
+	if err := v.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if v.CPU.PC != 0x2000 || v.CPU.D[0] != 8 {
+		t.Errorf("expected $A9F4 to take the exception, got PC=$%X", v.CPU.PC)
+	}
+	if err := v.OnLineTrap(0x4E71, func(*cpu.CPU) error { return nil }); err == nil {
+		t.Error("expected an error for NOP")
+	}
+}
+
+// TestPalmStubs launches a Palm application from a PRC file and checks that
+// the stubbed system calls answer it until its startup code returns.
+func TestPalmStubs(t *testing.T) {
+	code1, err := assembler.New().Assemble(`
+	pea	-12(a5)
+	pea	-8(a5)
+	pea	-4(a5)
+	trap	#15
+	dc.w	$A08F			; SysAppStartup
+	lea	12(a7),a7
+	move.l	-4(a5),a0
+	move.w	6(a0),d3		; launchFlags
+	move.w	#1000,-(a7)
+	move.l	#$74535452,-(a7)	; tSTR
+	trap	#15
+	dc.w	$A05F			; DmGetResource
+	addq.l	#6,a7
+	move.l	a0,-(a7)
+	trap	#15
+	dc.w	$A021			; MemHandleLock
+	addq.l	#4,a7
+	move.b	1(a0),d4
+	move.l	#16,-(a7)
+	trap	#15
+	dc.w	$A013			; MemPtrNew
+	addq.l	#4,a7
+	move.l	a0,a2
+	move.w	#$AA,-(a7)
+	move.l	#15,-(a7)
+	move.l	a2,-(a7)
+	trap	#15
+	dc.w	$A027			; MemSet
+	lea	10(a7),a7
+loop:
+	move.l	#100,-(a7)
+	pea	-40(a5)
+	trap	#15
+	dc.w	$A11D			; EvtGetEvent
+	addq.l	#8,a7
+	cmpi.w	#22,-40(a5)		; appStopEvent
+	bne.s	loop
+	move.w	10(a7),d5		; PilotMain's launchFlags
+	rts
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := disassembler.ParsePRC(palmPRC("Stubs", []disassembler.Resource{
+		{Type: "code", ID: 0, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0x40}},
+		{Type: "code", ID: 1, Data: code1},
+		{Type: "tSTR", ID: 1000, Data: []byte("Hi\x00")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vm.New(0x40000, 0)
+	palm, err := v.LoadPalm(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	palm.Trace = &trace
+	if v.CPU.PC != vm.PalmCodeBase || v.Symbols[vm.PalmCodeBase] != "start" {
+		t.Fatalf("expected to start at $%X, got $%X", vm.PalmCodeBase, v.CPU.PC)
+	}
+	v.CPU.Running = true
+	for v.CPU.Running {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := v.CPU.D
+	if d[3] != 0x0C || d[4] != 'i' || d[5] != 0x0C {
+		t.Errorf("expected launch flags $C, 'i' and $C, got $%X, $%X and $%X", d[3], d[4], d[5])
+	}
+	a2 := v.CPU.A[2]
+	if v.CPU.Mem[a2+14] != 0xAA || v.CPU.Mem[a2+15] != 0 {
+		t.Errorf("expected MemSet to fill 15 bytes at $%X", a2)
+	}
+	if n := palm.Calls[0xA11D]; n != 1 {
+		t.Errorf("expected one EvtGetEvent, got %d", n)
+	}
+	if !strings.Contains(trace.String(), "DmGetResource") {
+		t.Errorf("expected DmGetResource in the trace:\n%s", trace.String())
+	}
+
+	small := vm.New(0x12000, 0)
+	if _, err := small.LoadPalm(db); err == nil {
+		t.Error("expected an error loading into too little memory")
+	}
+}
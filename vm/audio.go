@@ -0,0 +1,278 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Layout of the audio device, as offsets from its base address.
+const (
+	AudioControl = 0x0 // Byte: the control bits below
+	AudioStatus  = 0x1 // Byte: the status bits below; write AudioOverrun or AudioUnderrun back to clear it
+	AudioRate    = 0x4 // Long: frames played per second
+	AudioData    = 0x8 // Byte or word: write a sample to queue it; write-only
+	AudioFree    = 0xC // Word: samples that can be queued before the buffer is full; read-only
+	// AudioSize is the size of the device block in bytes.
+	AudioSize = 0x10
+)
+
+// Bits of the AudioControl register.
+const (
+	// AudioEnable plays the queued samples at AudioRate.
+	AudioEnable = 1 << 0
+	// AudioStereo makes each frame two samples, left then right.
+	AudioStereo = 1 << 1
+	// Audio16Bit takes samples as signed big-endian words written to
+	// AudioData. Without it they are signed bytes.
+	Audio16Bit = 1 << 2
+	// AudioInterrupt has the device assert its level while AudioHalfEmpty
+	// is set, so the guest can top up the buffer.
+	AudioInterrupt = 1 << 7
+)
+
+// Bits of the AudioStatus register.
+const (
+	AudioHalfEmpty = 1 << 0 // The buffer is at most half full
+	AudioOverrun   = 1 << 1 // A sample was dropped because the buffer was full
+	AudioUnderrun  = 1 << 2 // Playback ran out of samples
+)
+
+// Defaults for the fields of AudioConfig left at zero.
+const (
+	DefaultAudioBuffer = 8192
+	DefaultAudioChunk  = 1024
+	DefaultAudioClock  = 8000000
+)
+
+// AudioConfig describes an audio device.
+type AudioConfig struct {
+	// Base is the address of the device's registers.
+	Base uint32
+	// Level is the interrupt level 1-7 raised through the autovector while
+	// AudioInterrupt and AudioHalfEmpty are set, or 0 for none.
+	Level int
+	// BufferSamples is the number of samples the buffer holds
+	// (DefaultAudioBuffer if 0).
+	BufferSamples int
+	// ChunkSamples is the number of played samples handed to the callback
+	// at a time (DefaultAudioChunk if 0).
+	ChunkSamples int
+	// ClockHz is the CPU's clock rate, which paces playback against
+	// CPU.Cycles (DefaultAudioClock, 8 MHz, if 0).
+	ClockHz uint64
+}
+
+// Audio is a PCM output device. The guest queues samples in a ring buffer by
+// writing them to AudioData, and the device plays them at AudioRate, paced by
+// the CPU's clock cycles rather than the host's, so runs are repeatable.
+// Played samples go to the callback set with SetCallback, in chunks, for a
+// frontend to send to the sound card or a file. 8-bit samples are widened
+// to 16 bits.
+type Audio struct {
+	cfg  AudioConfig
+	c    *cpu.CPU
+	regs [AudioSize]byte
+	ring []int16
+	head int
+	n    int   // Samples queued
+	high uint8 // First byte of a 16-bit sample
+	last uint64
+	frac uint64 // Cycles times rate carried toward the next frame
+	out  []int16
+	fn   func(samples []int16)
+	line bool
+}
+
+// EnableAudio maps an audio device described by cfg and returns it.
+func (v *VM) EnableAudio(cfg AudioConfig) (*Audio, error) {
+	if v.audio != nil {
+		return nil, fmt.Errorf("an audio device is already mapped at $%08X", v.audio.cfg.Base)
+	}
+	if cfg.Level < 0 || cfg.Level > 7 {
+		return nil, fmt.Errorf("audio interrupt level %d is not 0-7", cfg.Level)
+	}
+	if cfg.BufferSamples == 0 {
+		cfg.BufferSamples = DefaultAudioBuffer
+	}
+	if cfg.ChunkSamples == 0 {
+		cfg.ChunkSamples = DefaultAudioChunk
+	}
+	if cfg.ClockHz == 0 {
+		cfg.ClockHz = DefaultAudioClock
+	}
+	if cfg.BufferSamples < 2 || cfg.BufferSamples > 0xFFFF || cfg.ChunkSamples < 1 {
+		return nil, fmt.Errorf("invalid audio buffer of %d samples in chunks of %d", cfg.BufferSamples, cfg.ChunkSamples)
+	}
+	a := &Audio{cfg: cfg, c: v.CPU, ring: make([]int16, cfg.BufferSamples), last: v.CPU.Cycles}
+	if err := v.MemoryMap().MapDevice(cfg.Base, AudioSize, "audio", a); err != nil {
+		return nil, err
+	}
+	v.audio = a
+	return a, nil
+}
+
+// Audio returns the audio device, or nil without one.
+func (v *VM) Audio() *Audio {
+	return v.audio
+}
+
+// SetCallback sends the played samples to fn, ChunkSamples at a time, or
+// drops them if fn is nil. Stereo samples are interleaved, left first. fn is
+// called from Step, and the slice is reused, so fn copies anything it keeps.
+func (a *Audio) SetCallback(fn func(samples []int16)) {
+	a.fn = fn
+}
+
+// Format returns the frames per second and the number of channels the guest
+// has set.
+func (a *Audio) Format() (rate, channels int) {
+	channels = 1
+	if a.regs[AudioControl]&AudioStereo != 0 {
+		channels = 2
+	}
+	return int(binary.BigEndian.Uint32(a.regs[AudioRate:])), channels
+}
+
+// Buffered returns the number of samples queued and not yet played.
+func (a *Audio) Buffered() int {
+	return a.n
+}
+
+// Flush hands any played samples short of a whole chunk to the callback,
+// e.g. when the run ends.
+func (a *Audio) Flush() {
+	a.advance()
+	if a.fn != nil && len(a.out) > 0 {
+		a.fn(a.out)
+	}
+	a.out = a.out[:0]
+}
+
+// Read8 reads a register.
+func (a *Audio) Read8(offset uint32) (uint8, error) {
+	switch offset {
+	case AudioStatus:
+		a.advance()
+		return a.regs[AudioStatus] | a.halfEmpty(), nil
+	case AudioFree, AudioFree + 1:
+		a.advance()
+		var free [2]byte
+		binary.BigEndian.PutUint16(free[:], uint16(len(a.ring)-a.n))
+		return free[offset-AudioFree], nil
+	case AudioData, AudioData + 1:
+		return 0, nil
+	}
+	return a.regs[offset], nil
+}
+
+// Write8 writes a register.
+func (a *Audio) Write8(offset uint32, v uint8) error {
+	a.advance()
+	switch {
+	case offset == AudioStatus:
+		a.regs[AudioStatus] &^= v
+	case offset == AudioData:
+		if a.regs[AudioControl]&Audio16Bit != 0 {
+			a.high = v
+		} else {
+			a.push(int16(v) << 8)
+		}
+	case offset == AudioData+1:
+		if a.regs[AudioControl]&Audio16Bit != 0 {
+			a.push(int16(uint16(a.high)<<8 | uint16(v)))
+		}
+	case offset == AudioControl:
+		if v&AudioEnable != 0 && a.regs[AudioControl]&AudioEnable == 0 {
+			a.last, a.frac = a.c.Cycles, 0
+		}
+		a.regs[AudioControl] = v
+	case offset < AudioData:
+		a.regs[offset] = v
+	}
+	return a.sync()
+}
+
+// push queues a sample, or notes an overrun if the buffer is full.
+func (a *Audio) push(s int16) {
+	if a.n == len(a.ring) {
+		a.regs[AudioStatus] |= AudioOverrun
+		return
+	}
+	a.ring[(a.head+a.n)%len(a.ring)] = s
+	a.n++
+}
+
+// halfEmpty returns AudioHalfEmpty if the buffer is at most half full.
+func (a *Audio) halfEmpty() uint8 {
+	if a.n <= len(a.ring)/2 {
+		return AudioHalfEmpty
+	}
+	return 0
+}
+
+// advance plays the frames due since the last update.
+func (a *Audio) advance() {
+	elapsed := a.c.Cycles - a.last
+	a.last = a.c.Cycles
+	rate, channels := a.Format()
+	if a.regs[AudioControl]&AudioEnable == 0 || rate == 0 {
+		return
+	}
+	due := elapsed*uint64(rate) + a.frac
+	frames := due / a.cfg.ClockHz
+	a.frac = due % a.cfg.ClockHz
+	want := frames * uint64(channels)
+	for ; want > 0; want-- {
+		if a.n == 0 {
+			a.regs[AudioStatus] |= AudioUnderrun
+			return
+		}
+		a.out = append(a.out, a.ring[a.head])
+		a.head = (a.head + 1) % len(a.ring)
+		a.n--
+		if len(a.out) == a.cfg.ChunkSamples {
+			if a.fn != nil {
+				a.fn(a.out)
+			}
+			a.out = a.out[:0]
+		}
+	}
+}
+
+// sync sets the interrupt line to match the buffer.
+func (a *Audio) sync() error {
+	want := a.cfg.Level != 0 && a.regs[AudioControl]&AudioInterrupt != 0 && a.halfEmpty() != 0
+	if want == a.line {
+		return nil
+	}
+	a.line = want
+	if !want {
+		a.c.ClearInterrupt(a.cfg.Level)
+		return nil
+	}
+	if err := a.c.RaiseInterrupt(a.cfg.Level, cpu.Autovector); err != nil {
+		return fmt.Errorf("audio: %w", err)
+	}
+	return nil
+}
+
+// mayInterrupt reports whether playback could still raise the interrupt by
+// draining the buffer.
+func (a *Audio) mayInterrupt() bool {
+	rate, _ := a.Format()
+	return a.cfg.Level != 0 && a.regs[AudioControl]&(AudioEnable|AudioInterrupt) == AudioEnable|AudioInterrupt && rate != 0 && a.n > 0
+}
+
+// audioMayInterrupt reports whether an audio device is mapped and could
+// still raise its interrupt.
+func (v *VM) audioMayInterrupt() bool {
+	return v.audio != nil && v.audio.mayInterrupt()
+}
+
+// updateAudio plays the frames due and sets the interrupt line.
+func (v *VM) updateAudio() error {
+	v.audio.advance()
+	return v.audio.sync()
+}
@@ -0,0 +1,167 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// BlockSectorSize is the size of a block device sector in bytes.
+const BlockSectorSize = 512
+
+// Layout of the block device, as offsets from its base address.
+const (
+	BlockCommand = 0x0  // Byte: write BlockRead or BlockWrite to transfer
+	BlockStatus  = 0x1  // Byte: the status bits below; write them back to clear them
+	BlockControl = 0x2  // Byte: the control bits below
+	BlockSector  = 0x4  // Long: first sector to transfer
+	BlockAddress = 0x8  // Long: memory address to transfer to or from
+	BlockCount   = 0xC  // Word: number of sectors to transfer
+	BlockSectors = 0x10 // Long: number of sectors in the image; read-only
+	// BlockSize is the size of the device block in bytes.
+	BlockSize = 0x14
+)
+
+// Commands written to BlockCommand.
+const (
+	BlockRead  = 1 // Copy sectors from the image into memory
+	BlockWrite = 2 // Copy memory into sectors of the image
+)
+
+// Bits of the BlockStatus register.
+const (
+	BlockDone  = 1 << 0 // The last command finished
+	BlockError = 1 << 1 // The last command failed: a bad command or sector, a bus error or an I/O error
+)
+
+// Bits of the BlockControl register.
+const (
+	// BlockInterrupt has the device assert its interrupt level while
+	// BlockDone or BlockError is set.
+	BlockInterrupt = 1 << 7
+)
+
+// BlockImage is the storage behind a block device, such as an *os.File.
+type BlockImage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// block is a disk controller that moves whole sectors between an image and
+// memory by DMA, attached to the memory map as a Device.
+type block struct {
+	base    uint32
+	level   int
+	c       *cpu.CPU
+	image   BlockImage
+	sectors uint32
+	regs    [BlockSize]byte
+	buf     []byte
+}
+
+// EnableBlockDevice maps a block device at base, backed by image, which holds
+// size bytes. Sectors past the last whole one are ignored. The guest fills in
+// BlockSector, BlockAddress and BlockCount and writes a command to
+// BlockCommand; the transfer is done by the time the write completes, and
+// sets BlockDone, or BlockError if it failed. BlockSector and BlockAddress
+// are left just past the sectors transferred, so the next command carries
+// on from there. The device interrupts at level 1-7, with its autovector,
+// when BlockInterrupt is set. Memory is written through the bus, so ROM
+// and unmapped addresses fail the transfer, and watchpoints see it.
+func (v *VM) EnableBlockDevice(base uint32, level int, image BlockImage, size int64) error {
+	if level < 1 || level > 7 {
+		return fmt.Errorf("block device interrupt level %d is not 1-7", level)
+	}
+	if v.block != nil {
+		return fmt.Errorf("a block device is already mapped at $%08X", v.block.base)
+	}
+	if size < 0 || size/BlockSectorSize > 0xFFFFFFFF {
+		return fmt.Errorf("invalid block device image size %d", size)
+	}
+	b := &block{base: base, level: level, c: v.CPU, image: image, sectors: uint32(size / BlockSectorSize)}
+	binary.BigEndian.PutUint32(b.regs[BlockSectors:], b.sectors)
+	if err := v.MemoryMap().MapDevice(base, BlockSize, "block device", b); err != nil {
+		return err
+	}
+	v.block = b
+	return nil
+}
+
+// Read8 reads a register.
+func (b *block) Read8(offset uint32) (uint8, error) {
+	return b.regs[offset], nil
+}
+
+// Write8 writes a register.
+func (b *block) Write8(offset uint32, v uint8) error {
+	switch {
+	case offset == BlockCommand:
+		b.regs[BlockCommand] = v
+		b.regs[BlockStatus] = BlockDone
+		if err := b.transfer(v); err != nil {
+			b.regs[BlockStatus] |= BlockError
+		}
+	case offset == BlockStatus:
+		b.regs[BlockStatus] &^= v
+	case offset >= BlockSectors:
+		return nil
+	default:
+		b.regs[offset] = v
+	}
+	return b.update()
+}
+
+// transfer carries out a command.
+func (b *block) transfer(cmd uint8) error {
+	if cmd != BlockRead && cmd != BlockWrite {
+		return fmt.Errorf("unknown command %d", cmd)
+	}
+	sector := binary.BigEndian.Uint32(b.regs[BlockSector:])
+	addr := binary.BigEndian.Uint32(b.regs[BlockAddress:])
+	count := uint32(binary.BigEndian.Uint16(b.regs[BlockCount:]))
+	if b.buf == nil {
+		b.buf = make([]byte, BlockSectorSize)
+	}
+	mem := b.c.WatchedMemory()
+	for range count {
+		if sector >= b.sectors {
+			return fmt.Errorf("sector %d is past the end of the image", sector)
+		}
+		off := int64(sector) * BlockSectorSize
+		if cmd == BlockRead {
+			// ReaderAt may report io.EOF along with the last sector.
+			if n, err := b.image.ReadAt(b.buf, off); n < len(b.buf) {
+				return err
+			}
+			if err := mem.WriteBytes(addr, b.buf); err != nil {
+				return err
+			}
+		} else {
+			if err := mem.ReadBytes(addr, b.buf); err != nil {
+				return err
+			}
+			if _, err := b.image.WriteAt(b.buf, off); err != nil {
+				return err
+			}
+		}
+		sector++
+		addr += BlockSectorSize
+		binary.BigEndian.PutUint32(b.regs[BlockSector:], sector)
+		binary.BigEndian.PutUint32(b.regs[BlockAddress:], addr)
+	}
+	return nil
+}
+
+// update sets the interrupt line to match the status.
+func (b *block) update() error {
+	if b.regs[BlockControl]&BlockInterrupt == 0 || b.regs[BlockStatus] == 0 {
+		b.c.ClearInterrupt(b.level)
+		return nil
+	}
+	if err := b.c.RaiseInterrupt(b.level, cpu.Autovector); err != nil {
+		return fmt.Errorf("block: %w", err)
+	}
+	return nil
+}
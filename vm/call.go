@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CallReturn is the return address Call pushes for the subroutine. It lies
+// outside memory, so reaching it can only mean the subroutine returned.
+const CallReturn uint32 = 0xFFFFFFFE
+
+// ErrStepLimit is returned by Call when the subroutine runs for too long.
+var ErrStepLimit = errors.New("step limit reached")
+
+// ErrStopped is returned by Call when the subroutine executes STOP and no
+// interrupt is pending to resume it.
+var ErrStopped = errors.New("CPU stopped")
+
+// Call runs the subroutine at addr as JSR would, until it returns with RTS or the
+// program halts. The return address is pushed on the current stack at A7. At most
+// limit instructions run; zero means no limit. It returns the number of
+// instructions executed. The PC is restored after a normal return.
+func (v *VM) Call(addr uint32, limit int) (int, error) {
+	c := v.CPU
+	pc := c.PC
+	if err := c.Memory().WriteU32(c.A[7]-4, CallReturn); err != nil {
+		return 0, fmt.Errorf("pushing the return address: %w", err)
+	}
+	c.A[7] -= 4
+	c.PC = addr
+
+	c.Running = true
+	defer func() { c.Running = false }()
+	steps := 0
+	for c.Running {
+		if c.PC == CallReturn {
+			c.PC = pc
+			return steps, nil
+		}
+		if v.Idle() {
+			return steps, fmt.Errorf("%w at $%08X", ErrStopped, c.PC)
+		}
+		if limit > 0 && steps == limit {
+			return steps, fmt.Errorf("%w after %d instructions at $%08X", ErrStepLimit, steps, c.PC)
+		}
+		if err := v.Step(); err != nil {
+			return steps, err
+		}
+		steps++
+	}
+	return steps, nil
+}
@@ -0,0 +1,265 @@
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+// LiveInstruction is an instruction disassembled from VM memory, annotated
+// with the current values its operands refer to.
+type LiveInstruction struct {
+	*disassembler.Instruction
+	// Notes describe each operand that names a register or memory: register
+	// contents, or the resolved address and the data stored there.
+	Notes []string
+}
+
+// String formats the instruction as a listing line with its notes as a comment.
+func (li LiveInstruction) String() string {
+	line := fmt.Sprintf("%08X  %-8s %s", li.Address, li.Mnemonic, li.Operands)
+	if len(li.Notes) == 0 {
+		return line
+	}
+	return fmt.Sprintf("%-48s ; %s", line, strings.Join(li.Notes, " "))
+}
+
+// Disassemble decodes count instructions from memory starting at addr. Operands
+// are resolved against the current registers, so the notes are only exact for
+// the instruction at PC.
+func (v *VM) Disassemble(addr uint32, count int) []LiveInstruction {
+	list := make([]LiveInstruction, 0, count)
+	for range count {
+		if uint64(addr)+2 > uint64(len(v.CPU.Mem)) {
+			break
+		}
+		inst := disassembler.DecodeAt(v.CPU.Mem, addr)
+		list = append(list, LiveInstruction{Instruction: inst, Notes: v.operandNotes(inst)})
+		addr += inst.Size
+	}
+	return list
+}
+
+// Operand forms produced by the disassembler that refer to registers or memory.
+var (
+	reRegister   = regexp.MustCompile(`^([da][0-7]|sp)$`)
+	reIndirect   = regexp.MustCompile(`^(-?)\((a[0-7]|sp)\)(\+?)$`)
+	reDisplaced  = regexp.MustCompile(`^\(([^,]+),(a[0-7]|sp|pc)\)$`)
+	reIndexed    = regexp.MustCompile(`^\(([^,]+),(a[0-7]|sp|pc),([da][0-7])\.([wl])\)$`)
+	reAbsolute   = regexp.MustCompile(`^\$([0-9a-f]+)\.([wl])$`)
+	reImmediate  = regexp.MustCompile(`^#`)
+	operandSizes = map[string]uint32{"b": 1, "w": 2, "l": 4}
+)
+
+// operandNotes annotates the operands of inst. PC-relative operands are
+// resolved from the position of their extension word, which follows those of
+// the operands before them.
+func (v *VM) operandNotes(inst *disassembler.Instruction) []string {
+	size := operandSize(inst.Mnemonic)
+
+	var notes []string
+	ext := firstExtension(inst)
+	for _, op := range splitOperands(inst.Operands) {
+		note, used := v.operandNote(op, size, ext)
+		if note != "" {
+			notes = append(notes, note)
+		}
+		ext += used
+	}
+	return notes
+}
+
+// operandSize returns the operand size in bytes given by a mnemonic's suffix,
+// defaulting to a word.
+func operandSize(mnemonic string) uint32 {
+	if i := strings.LastIndexByte(mnemonic, '.'); i >= 0 {
+		if s, ok := operandSizes[mnemonic[i+1:]]; ok {
+			return s
+		}
+	}
+	return 2
+}
+
+// firstExtension returns the address of the first operand extension word.
+// MOVEM's register mask comes before it.
+func firstExtension(inst *disassembler.Instruction) uint32 {
+	if strings.HasPrefix(inst.Mnemonic, "movem") {
+		return inst.Address + 4
+	}
+	return inst.Address + 2
+}
+
+// operandNote describes one operand and returns the number of extension bytes
+// it occupies. ext is the address of its first extension word.
+func (v *VM) operandNote(op string, size, ext uint32) (string, uint32) {
+	loc, used, ok := v.operandLocation(op, size, ext)
+	if !ok {
+		return "", used
+	}
+	if loc.reg != "" {
+		return fmt.Sprintf("%s=$%08X", op, *v.register(loc.reg)), used
+	}
+
+	note := fmt.Sprintf("%s=$%08X", op, loc.addr)
+	if v.memMap != nil && v.memMap.touchesDevice(loc.addr, size) {
+		// Reading a device's registers could change its state.
+		return note, used
+	}
+	mem := v.CPU.Memory()
+	var value uint32
+	var err error
+	switch size {
+	case 1:
+		var b uint8
+		b, err = mem.ReadU8(loc.addr)
+		value = uint32(b)
+	case 2:
+		var w uint16
+		w, err = mem.ReadU16(loc.addr)
+		value = uint32(w)
+	default:
+		value, err = mem.ReadU32(loc.addr)
+	}
+	if err == nil {
+		note += fmt.Sprintf(" [$%0*X]", size*2, value)
+	}
+	return note, used
+}
+
+// location is the register or memory an operand refers to.
+type location struct {
+	// reg is the register name ("d0"-"a7"), or empty for memory.
+	reg string
+	// addr and size give the memory range of a memory operand.
+	addr, size uint32
+	// base lists the registers used to form the address.
+	base []string
+}
+
+// operandLocation resolves an operand against the current registers and
+// returns the number of extension bytes it occupies. It reports false for
+// operands that name neither a register nor memory, such as immediates.
+func (v *VM) operandLocation(op string, size, ext uint32) (location, uint32, bool) {
+	if m := reRegister.FindStringSubmatch(op); m != nil {
+		return location{reg: regName(m[1])}, 0, true
+	}
+	if reImmediate.MatchString(op) {
+		return location{}, max(size, 2), false
+	}
+
+	loc := location{size: size}
+	var used uint32
+	switch {
+	case reIndirect.MatchString(op):
+		m := reIndirect.FindStringSubmatch(op)
+		loc.addr = *v.register(m[2])
+		loc.base = []string{regName(m[2])}
+		if m[1] == "-" {
+			step := size
+			if size == 1 && (m[2] == "a7" || m[2] == "sp") {
+				step = 2
+			}
+			loc.addr -= step
+		}
+	case reDisplaced.MatchString(op):
+		m := reDisplaced.FindStringSubmatch(op)
+		loc.addr = v.base(m[2], ext) + uint32(parseDisplacement(m[1], 16))
+		if m[2] != "pc" {
+			loc.base = []string{regName(m[2])}
+		}
+		used = 2
+	case reIndexed.MatchString(op):
+		m := reIndexed.FindStringSubmatch(op)
+		index := *v.register(m[3])
+		if m[4] == "w" {
+			index = uint32(int16(index))
+		}
+		loc.addr = v.base(m[2], ext) + uint32(parseDisplacement(m[1], 8)) + index
+		if m[2] != "pc" {
+			loc.base = []string{regName(m[2])}
+		}
+		loc.base = append(loc.base, m[3])
+		used = 2
+	case reAbsolute.MatchString(op):
+		m := reAbsolute.FindStringSubmatch(op)
+		a, _ := strconv.ParseUint(m[1], 16, 32)
+		loc.addr = uint32(a)
+		used = 4
+		if m[2] == "w" {
+			loc.addr = uint32(int16(a))
+			used = 2
+		}
+	default:
+		return location{}, 0, false
+	}
+	return loc, used, true
+}
+
+// regName returns the canonical name of a register, with sp as a7.
+func regName(name string) string {
+	if name == "sp" {
+		return "a7"
+	}
+	return name
+}
+
+// register returns the register named by a disassembled operand.
+func (v *VM) register(name string) *uint32 {
+	if name == "sp" {
+		return &v.CPU.A[7]
+	}
+	n := name[1] - '0'
+	if name[0] == 'd' {
+		return &v.CPU.D[n]
+	}
+	return &v.CPU.A[n]
+}
+
+// base returns the base address of a displaced operand: An, or for the PC the
+// address of the extension word.
+func (v *VM) base(name string, ext uint32) uint32 {
+	if name == "pc" {
+		return ext
+	}
+	return *v.register(name)
+}
+
+// parseDisplacement reads a displacement as the disassembler writes it: small
+// values in decimal, others as unsigned hex of the given width in bits.
+func parseDisplacement(s string, bits int) int32 {
+	if h, ok := strings.CutPrefix(s, "$"); ok {
+		u, _ := strconv.ParseUint(h, 16, bits)
+		if bits == 8 {
+			return int32(int8(u))
+		}
+		return int32(int16(u))
+	}
+	d, _ := strconv.ParseInt(s, 10, 32)
+	return int32(d)
+}
+
+// splitOperands splits an operand list at the commas outside parentheses.
+func splitOperands(ops string) []string {
+	var list []string
+	depth, start := 0, 0
+	for i, r := range ops {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				list = append(list, ops[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if ops != "" {
+		list = append(list, ops[start:])
+	}
+	return list
+}
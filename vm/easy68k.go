@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Easy68K TRAP #15 tasks, selected by D0.B.
+const (
+	Easy68KPrintLine      = 0  // Print D1.W bytes at (A1), then a newline
+	Easy68KPrint          = 1  // Print D1.W bytes at (A1)
+	Easy68KReadString     = 2  // Read a line to (A1), NUL-terminated; length in D1.W
+	Easy68KPrintNumber    = 3  // Print D1.L in signed decimal
+	Easy68KReadNumber     = 4  // Read a decimal number into D1.L
+	Easy68KReadChar       = 5  // Read a character into D1.B
+	Easy68KPrintChar      = 6  // Print the character in D1.B
+	Easy68KInputPending   = 7  // D1.B = 1 if input is waiting, else 0
+	Easy68KTime           = 8  // D1.L = hundredths of a second since midnight
+	Easy68KExit           = 9  // Halt the program
+	Easy68KPrintStrLine   = 13 // Print the NUL-terminated string at (A1), then a newline
+	Easy68KPrintStr       = 14 // Print the NUL-terminated string at (A1)
+	Easy68KPrintBase      = 15 // Print D1.L unsigned in base D2.B (2-36)
+	Easy68KPrintStrNumber = 17 // Print the string at (A1), then D1.L in signed decimal
+	Easy68KPromptNumber   = 18 // Print the string at (A1), then read a number into D1.L
+)
+
+// easy68kMaxLine is the longest line task 2 reads, as in Easy68K.
+const easy68kMaxLine = 80
+
+// easy68kMaxString is the longest NUL-terminated string the tasks print.
+const easy68kMaxString = 0x10000
+
+// easy68k answers TRAP #15 system calls.
+type easy68k struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// EnableEasy68K makes TRAP #15 a system call with the task numbers of the
+// Easy68K simulator, reading in and writing out, so programs written for it
+// run unmodified. Task 9 halts the program in place of the VM's usual TRAP
+// #15. Newlines are written as "\n", and lines read may end in "\n" or
+// "\r\n". An unknown task fails the TRAP instruction.
+func (v *VM) EnableEasy68K(in io.Reader, out io.Writer) {
+	e := &easy68k{in: bufio.NewReader(in), out: out}
+	v.CPU.HostTraps[15] = e.call
+}
+
+// DisableEasy68K restores TRAP #15 to halting the VM.
+func (v *VM) DisableEasy68K() {
+	v.CPU.HostTraps[15] = nil
+}
+
+// call runs the task in D0.B.
+func (e *easy68k) call(c *cpu.CPU) error {
+	task := c.D[0] & 0xFF
+	var err error
+	switch task {
+	case Easy68KPrintLine, Easy68KPrint:
+		var s []byte
+		if s, err = readBytes(c, c.A[1], int(c.D[1]&0xFFFF)); err == nil {
+			if task == Easy68KPrintLine {
+				s = append(s, '\n')
+			}
+			_, err = e.out.Write(s)
+		}
+	case Easy68KReadString:
+		var line string
+		if line, err = e.readLine(); err == nil {
+			if len(line) > easy68kMaxLine {
+				line = line[:easy68kMaxLine]
+			}
+			err = c.WatchedMemory().WriteBytes(c.A[1], append([]byte(line), 0))
+			setWord(c, 1, uint16(len(line)))
+		}
+	case Easy68KPrintNumber:
+		_, err = fmt.Fprint(e.out, int32(c.D[1]))
+	case Easy68KReadNumber:
+		err = e.readNumber(c)
+	case Easy68KReadChar:
+		var b byte
+		if b, err = e.in.ReadByte(); err == nil {
+			c.D[1] = c.D[1]&^0xFF | uint32(b)
+		}
+	case Easy68KPrintChar:
+		_, err = e.out.Write([]byte{byte(c.D[1])})
+	case Easy68KInputPending:
+		var pending uint32
+		if e.in.Buffered() > 0 {
+			pending = 1
+		}
+		c.D[1] = c.D[1]&^0xFF | pending
+	case Easy68KTime:
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		c.D[1] = uint32(now.Sub(midnight) / (10 * time.Millisecond))
+	case Easy68KExit:
+		c.Running = false
+	case Easy68KPrintStrLine, Easy68KPrintStr, Easy68KPrintStrNumber, Easy68KPromptNumber:
+		var s []byte
+		if s, err = readString(c, c.A[1]); err != nil {
+			break
+		}
+		if task == Easy68KPrintStrLine {
+			s = append(s, '\n')
+		}
+		if task == Easy68KPrintStrNumber {
+			s = strconv.AppendInt(s, int64(int32(c.D[1])), 10)
+		}
+		if _, err = e.out.Write(s); err == nil && task == Easy68KPromptNumber {
+			err = e.readNumber(c)
+		}
+	case Easy68KPrintBase:
+		base := int(c.D[2] & 0xFF)
+		if base < 2 || base > 36 {
+			return fmt.Errorf("Easy68K task 15: base %d is not 2-36", base)
+		}
+		_, err = io.WriteString(e.out, strings.ToUpper(strconv.FormatUint(uint64(c.D[1]), base)))
+	default:
+		return fmt.Errorf("unsupported Easy68K task %d", task)
+	}
+	if err != nil {
+		return fmt.Errorf("Easy68K task %d: %w", task, err)
+	}
+	return nil
+}
+
+// readLine reads a line of input without its line ending. The last line may
+// end without one.
+func (e *easy68k) readLine() (string, error) {
+	line, err := e.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readNumber reads a line holding a decimal number into D1.L.
+func (e *easy68k) readNumber(c *cpu.CPU) error {
+	line, err := e.readLine()
+	if err != nil {
+		return err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(line), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", line)
+	}
+	c.D[1] = uint32(n)
+	return nil
+}
+
+// readBytes copies n bytes of guest memory from addr.
+func readBytes(c *cpu.CPU, addr uint32, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if err := c.WatchedMemory().ReadBytes(addr, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readString copies the NUL-terminated string at addr out of guest memory.
+func readString(c *cpu.CPU, addr uint32) ([]byte, error) {
+	mem := c.WatchedMemory()
+	var b []byte
+	for start := addr; ; addr++ {
+		if addr-start == easy68kMaxString {
+			return nil, fmt.Errorf("string at $%08X has no terminating NUL", start)
+		}
+		v, err := mem.ReadU8(addr)
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 {
+			return b, nil
+		}
+		b = append(b, v)
+	}
+}
+
+// setWord replaces the low word of Dn.
+func setWord(c *cpu.CPU, n int, v uint16) {
+	c.D[n] = c.D[n]&^0xFFFF | uint32(v)
+}
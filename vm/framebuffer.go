@@ -0,0 +1,202 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// DefaultFrameCycles is the time between frames when FramebufferConfig
+// doesn't set one: 50 frames a second at 8 MHz.
+const DefaultFrameCycles = 160000
+
+// FramebufferConfig describes a framebuffer in guest RAM.
+type FramebufferConfig struct {
+	// Base is the address of the top left pixel.
+	Base uint32
+	// Width and Height are the size of the screen in pixels.
+	Width, Height int
+	// BPP is the number of bits per pixel. With 1, 2, 4 or 8 each pixel is
+	// an index into Palette, packed from the most significant bit of each
+	// byte. 16 is RGB565 and 32 is xRGB, both big-endian.
+	BPP int
+	// Palette holds the colours of indexed pixels. Without one they are
+	// shades of grey from black to white; indexes past its end are black.
+	Palette []color.Color
+	// FrameCycles is the number of clock cycles between frames shown on the
+	// Display (DefaultFrameCycles if 0).
+	FrameCycles uint64
+}
+
+// Display shows a framebuffer's frames, e.g. in a GUI window.
+type Display interface {
+	// Show is called with each frame. The image is reused for the next
+	// frame, so Show copies anything it keeps.
+	Show(frame *image.RGBA) error
+}
+
+// Framebuffer turns a block of guest memory into images.
+type Framebuffer struct {
+	cfg     FramebufferConfig
+	mem     []byte
+	stride  int // Bytes per row
+	palette []color.RGBA
+	frame   *image.RGBA
+	display Display
+	next    uint64 // CPU.Cycles at which the next frame is shown
+	frames  uint64
+}
+
+// EnableFramebuffer sets aside the memory described by cfg as a framebuffer
+// and returns it. The pixels are plain RAM, so programs draw with ordinary
+// moves and save states keep the picture. Frames go to the Display attached
+// with SetDisplay, if any, every cfg.FrameCycles clock cycles, and any frame
+// can be written out with WritePNG or WritePPM.
+func (v *VM) EnableFramebuffer(cfg FramebufferConfig) (*Framebuffer, error) {
+	if v.fb != nil {
+		return nil, fmt.Errorf("a framebuffer is already at $%08X", v.fb.cfg.Base)
+	}
+	switch cfg.BPP {
+	case 1, 2, 4, 8, 16, 32:
+	default:
+		return nil, fmt.Errorf("unsupported framebuffer depth %d (want 1, 2, 4, 8, 16 or 32)", cfg.BPP)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > 8192 || cfg.Height > 8192 {
+		return nil, fmt.Errorf("invalid framebuffer size %dx%d", cfg.Width, cfg.Height)
+	}
+	stride := (cfg.Width*cfg.BPP + 7) / 8
+	size := uint64(stride) * uint64(cfg.Height)
+	if uint64(cfg.Base)+size > uint64(len(v.CPU.Mem)) {
+		return nil, fmt.Errorf("framebuffer at $%08X needs %d bytes, past the end of memory", cfg.Base, size)
+	}
+	if cfg.FrameCycles == 0 {
+		cfg.FrameCycles = DefaultFrameCycles
+	}
+
+	fb := &Framebuffer{
+		cfg:    cfg,
+		mem:    v.CPU.Mem[cfg.Base : uint64(cfg.Base)+size],
+		stride: stride,
+		frame:  image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height)),
+		next:   v.CPU.Cycles + cfg.FrameCycles,
+	}
+	if cfg.BPP <= 8 {
+		fb.palette = make([]color.RGBA, 1<<cfg.BPP)
+		for i := range fb.palette {
+			if cfg.Palette == nil {
+				g := uint8(i * 255 / (len(fb.palette) - 1))
+				fb.palette[i] = color.RGBA{g, g, g, 0xFF}
+			} else if i < len(cfg.Palette) {
+				fb.palette[i] = color.RGBAModel.Convert(cfg.Palette[i]).(color.RGBA)
+				fb.palette[i].A = 0xFF
+			} else {
+				fb.palette[i] = color.RGBA{A: 0xFF}
+			}
+		}
+	}
+	v.fb = fb
+	return fb, nil
+}
+
+// Framebuffer returns the framebuffer, or nil without one.
+func (v *VM) Framebuffer() *Framebuffer {
+	return v.fb
+}
+
+// Config returns the framebuffer's configuration.
+func (fb *Framebuffer) Config() FramebufferConfig {
+	return fb.cfg
+}
+
+// SetDisplay sends the frames to d, or stops sending them if d is nil.
+func (fb *Framebuffer) SetDisplay(d Display) {
+	fb.display = d
+}
+
+// Frames returns the number of frames shown so far.
+func (fb *Framebuffer) Frames() uint64 {
+	return fb.frames
+}
+
+// SetPalette changes the colour of index i, for hosts emulating a palette
+// register.
+func (fb *Framebuffer) SetPalette(i int, c color.Color) error {
+	if i < 0 || i >= len(fb.palette) {
+		return fmt.Errorf("palette index %d is not 0-%d", i, len(fb.palette)-1)
+	}
+	fb.palette[i] = color.RGBAModel.Convert(c).(color.RGBA)
+	fb.palette[i].A = 0xFF
+	return nil
+}
+
+// Image returns the picture in memory now, as a new image.
+func (fb *Framebuffer) Image() *image.RGBA {
+	img := image.NewRGBA(fb.frame.Rect)
+	fb.render(img)
+	return img
+}
+
+// render converts the pixels in memory into img.
+func (fb *Framebuffer) render(img *image.RGBA) {
+	bpp := fb.cfg.BPP
+	for y := range fb.cfg.Height {
+		row := fb.mem[y*fb.stride : (y+1)*fb.stride]
+		out := img.Pix[y*img.Stride:]
+		for x := range fb.cfg.Width {
+			var c color.RGBA
+			switch bpp {
+			case 16:
+				p := binary.BigEndian.Uint16(row[x*2:])
+				r, g, b := uint8(p>>11), uint8(p>>5&0x3F), uint8(p&0x1F)
+				c = color.RGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 0xFF}
+			case 32:
+				c = color.RGBA{row[x*4+1], row[x*4+2], row[x*4+3], 0xFF}
+			default:
+				bit := x * bpp
+				shift := 8 - bpp - bit%8
+				c = fb.palette[int(row[bit/8]>>shift)&(1<<bpp-1)]
+			}
+			o := out[x*4 : x*4+4]
+			o[0], o[1], o[2], o[3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// WritePNG writes the picture in memory now as a PNG image.
+func (fb *Framebuffer) WritePNG(w io.Writer) error {
+	return png.Encode(w, fb.Image())
+}
+
+// WritePPM writes the picture in memory now as a binary PPM (P6) image.
+func (fb *Framebuffer) WritePPM(w io.Writer) error {
+	img := fb.Image()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", fb.cfg.Width, fb.cfg.Height)
+	for i := 0; i < len(img.Pix); i += 4 {
+		bw.Write(img.Pix[i : i+3])
+	}
+	return bw.Flush()
+}
+
+// updateFramebuffer shows a frame on the display when one is due.
+func (v *VM) updateFramebuffer() error {
+	fb := v.fb
+	if v.CPU.Cycles < fb.next {
+		return nil
+	}
+	// A long STOP may have skipped several frames; show only the last.
+	fb.next += (v.CPU.Cycles-fb.next)/fb.cfg.FrameCycles*fb.cfg.FrameCycles + fb.cfg.FrameCycles
+	fb.frames++
+	if fb.display == nil {
+		return nil
+	}
+	fb.render(fb.frame)
+	if err := fb.display.Show(fb.frame); err != nil {
+		return fmt.Errorf("display failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,487 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/internal/gdb"
+)
+
+// gdbPollInterval is how many instructions a continue runs between checks for
+// an interrupt from gdb.
+const gdbPollInterval = 1024
+
+// Stop replies, with the signal gdb shows for each.
+const (
+	gdbTrap      = "S05" // SIGTRAP: breakpoint, step or watchpoint
+	gdbInterrupt = "S02" // SIGINT: Ctrl-C in gdb
+	gdbIllegal   = "S04" // SIGILL: an instruction could not be executed
+	gdbExited    = "W00" // The program halted with TRAP #15
+)
+
+// GDBServer lets gdb debug a VM over the remote serial protocol, e.g. with
+// "target remote :1234" in m68k-elf-gdb. It handles the register (g, G),
+// memory (m, M), execution (c, s) and breakpoint (Z0, Z1, z0, z1) packets, and
+// sends gdb the target description and memory map. Breakpoints are kept by the
+// CPU rather than patched into memory, but as on real hardware a software
+// breakpoint (Z0) is refused in ROM, where only a hardware one (Z1) fits, and
+// both are refused outside the memory map.
+type GDBServer struct {
+	// Log, if set, receives every packet exchanged, for debugging the link.
+	Log *log.Logger
+
+	v      *VM
+	noAck  bool
+	last   string
+	exited bool
+	queued []gdbEvent // Read during a continue, to handle after it
+}
+
+// NewGDBServer returns a server for the VM. Set up the program and its start
+// address first; the CPU waits at PC for gdb's first command.
+func (v *VM) NewGDBServer() *GDBServer {
+	return &GDBServer{v: v}
+}
+
+// ListenAndServe accepts gdb connections on addr, such as ":1234", serving
+// them one at a time until the listener fails.
+func (s *GDBServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = s.Serve(conn)
+		conn.Close()
+		if err != nil && s.Log != nil {
+			s.Log.Printf("gdb session ended: %v", err)
+		}
+	}
+}
+
+// gdbEvent is something read from gdb: a packet, a request to resend the last
+// reply, a packet with a bad checksum or an interrupt.
+type gdbEvent struct {
+	packet    string
+	resend    bool
+	corrupt   bool
+	interrupt bool
+	err       error
+}
+
+// Serve runs one gdb session on conn. It returns nil when gdb detaches or kills
+// the program, and an error if the connection fails.
+func (s *GDBServer) Serve(conn io.ReadWriter) error {
+	s.noAck, s.last, s.exited, s.queued = false, "", false, nil
+	events := make(chan gdbEvent)
+	done := make(chan struct{})
+	defer close(done)
+	go s.read(bufio.NewReader(conn), events, done)
+
+	w := bufio.NewWriter(conn)
+	for {
+		ev, ok := s.next(events)
+		if !ok {
+			return nil
+		}
+		switch {
+		case ev.err != nil:
+			if ev.err == io.EOF {
+				return nil
+			}
+			return ev.err
+		case ev.interrupt:
+			// Only meaningful while running; the CPU is already stopped.
+			continue
+		}
+
+		switch {
+		case ev.corrupt:
+			// gdb sends it again.
+			if _, err := w.WriteString("-"); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			continue
+		case ev.resend:
+			if err := s.send(w, s.last); err != nil {
+				return err
+			}
+			continue
+		}
+		if !s.noAck {
+			if _, err := w.WriteString("+"); err != nil {
+				return err
+			}
+		}
+		reply, end := s.handle(ev.packet, events)
+		if err := s.send(w, reply); err != nil {
+			return err
+		}
+		if end {
+			return nil
+		}
+	}
+}
+
+// next returns the next event: the first one queued during a continue, or
+// else the next one from gdb. It reports false once gdb's events have ended.
+func (s *GDBServer) next(events <-chan gdbEvent) (gdbEvent, bool) {
+	if len(s.queued) > 0 {
+		ev := s.queued[0]
+		s.queued = s.queued[1:]
+		return ev, true
+	}
+	ev, ok := <-events
+	return ev, ok
+}
+
+// read turns the bytes from gdb into events.
+func (s *GDBServer) read(r *bufio.Reader, events chan<- gdbEvent, done <-chan struct{}) {
+	defer close(events)
+	emit := func(ev gdbEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			emit(gdbEvent{err: err})
+			return
+		}
+		switch b {
+		case 0x03:
+			if !emit(gdbEvent{interrupt: true}) {
+				return
+			}
+		case '-':
+			if !emit(gdbEvent{resend: true}) {
+				return
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				emit(gdbEvent{err: err})
+				return
+			}
+			data = data[:len(data)-1]
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				emit(gdbEvent{err: err})
+				return
+			}
+			if s.Log != nil {
+				s.Log.Printf("<- %s", data)
+			}
+			if want, err := strconv.ParseUint(string(sum[:]), 16, 8); err != nil || byte(want) != checksum(data) {
+				if !emit(gdbEvent{corrupt: true}) {
+					return
+				}
+				continue
+			}
+			if !emit(gdbEvent{packet: data}) {
+				return
+			}
+		}
+		// "+" acknowledgements and anything between packets are ignored.
+	}
+}
+
+// send writes a reply packet, remembering it in case gdb asks again.
+func (s *GDBServer) send(w *bufio.Writer, reply string) error {
+	if s.Log != nil {
+		s.Log.Printf("-> %s", reply)
+	}
+	s.last = reply
+	if _, err := fmt.Fprintf(w, "$%s#%02x", reply, checksum(reply)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// checksum is the modulo 256 sum of a packet's bytes.
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// handle answers a packet. It reports true when the session should end.
+func (s *GDBServer) handle(packet string, events <-chan gdbEvent) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+
+	c := s.v.CPU
+	features := gdb.Features{Regions: s.regions()}
+	if reply, ok := features.HandleQuery(packet); ok {
+		return reply, false
+	}
+
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return gdbTrap, false
+	case 'g':
+		return gdb.Registers(c), false
+	case 'G':
+		if err := gdb.SetRegisters(c, args); err != nil {
+			return "E01", false
+		}
+		return "OK", false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= gdb.NumRegisters {
+			return "E01", false
+		}
+		return gdb.Registers(c)[n*8 : n*8+8], false
+	case 'P':
+		reg, value, ok := strings.Cut(args, "=")
+		n, err := strconv.ParseUint(reg, 16, 8)
+		if !ok || err != nil || n >= gdb.NumRegisters || len(value) != 8 {
+			return "E01", false
+		}
+		regs := gdb.Registers(c)
+		if err := gdb.SetRegisters(c, regs[:n*8]+value+regs[n*8+8:]); err != nil {
+			return "E01", false
+		}
+		return "OK", false
+	case 'm':
+		addr, n, err := parseAddrLength(args)
+		if err != nil {
+			return "E01", false
+		}
+		data, ok := s.memory(addr, n)
+		if !ok {
+			return "E14", false // EFAULT
+		}
+		return hex.EncodeToString(data), false
+	case 'M':
+		spec, payload, _ := strings.Cut(args, ":")
+		addr, n, err := parseAddrLength(spec)
+		data, herr := hex.DecodeString(payload)
+		if err != nil || herr != nil || len(data) != n {
+			return "E01", false
+		}
+		mem, ok := s.memory(addr, n)
+		if !ok {
+			return "E14", false
+		}
+		copy(mem, data)
+		c.FlushCache()
+		return "OK", false
+	case 'c':
+		if err := s.resume(args); err != nil {
+			return "E01", false
+		}
+		return s.run(events), false
+	case 's':
+		if err := s.resume(args); err != nil {
+			return "E01", false
+		}
+		return s.step(), false
+	case 'Z', 'z':
+		kind, rest, _ := strings.Cut(args, ",")
+		if kind != "0" && kind != "1" {
+			return "", false // Watchpoints are not offered.
+		}
+		a, _, _ := strings.Cut(rest, ",")
+		addr, err := strconv.ParseUint(a, 16, 32)
+		if err != nil {
+			return "E01", false
+		}
+		switch gdb.BreakpointFor(s.regions(), uint32(addr)) {
+		case gdb.NoBreakpoint:
+			return "E01", false
+		case gdb.HardwareBreakpoint:
+			if packet[0] == 'Z' && kind == "0" {
+				return "E01", false // ROM can't be patched.
+			}
+		}
+		if packet[0] == 'Z' {
+			c.AddBreakpoint(uint32(addr))
+		} else {
+			c.RemoveBreakpoint(uint32(addr))
+		}
+		return "OK", false
+	case 'H':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		c.Running = false
+		return "OK", true
+	case 'q':
+		switch {
+		case strings.HasPrefix(packet, "qSupported"):
+			return "PacketSize=4000;QStartNoAckMode+;" + features.Supported(), false
+		case packet == "qAttached":
+			return "1", false
+		case packet == "qC":
+			return "QC1", false
+		case packet == "qfThreadInfo":
+			return "m1", false
+		case packet == "qsThreadInfo":
+			return "l", false
+		}
+	case 'Q':
+		if packet == "QStartNoAckMode" {
+			s.noAck = true
+			return "OK", false
+		}
+	}
+	return "", false // Not supported
+}
+
+// regions returns the memory map for gdb: the VM's RAM and ROM regions, or
+// all of its memory if it has no map.
+func (s *GDBServer) regions() []gdb.Region {
+	mem := uint32(len(s.v.CPU.Mem))
+	if s.v.memMap == nil {
+		return []gdb.Region{{Start: 0, Length: mem}}
+	}
+	var list []gdb.Region
+	next := uint32(0)
+	for _, r := range s.v.memMap.Regions() {
+		if r.Start > next && next < mem {
+			list = append(list, gdb.Region{Start: next, Length: min(r.Start, mem) - next})
+		}
+		if r.Kind != RegionUnmapped {
+			list = append(list, gdb.Region{Start: r.Start, Length: r.Size, ROM: r.Kind == RegionROM})
+		}
+		next = uint32(min(r.End(), 1<<32-1))
+	}
+	if next < mem {
+		list = append(list, gdb.Region{Start: next, Length: mem - next})
+	}
+	return list
+}
+
+// memory returns n bytes of VM memory at addr, which gdb reads and writes
+// directly, bypassing ROM protection and devices on the bus.
+func (s *GDBServer) memory(addr uint32, n int) ([]byte, bool) {
+	mem := s.v.CPU.Mem
+	if uint64(addr)+uint64(n) > uint64(len(mem)) {
+		return nil, false
+	}
+	return mem[addr : int(addr)+n], true
+}
+
+// parseAddrLength parses the "addr,length" of an m or M packet.
+func parseAddrLength(s string) (uint32, int, error) {
+	a, l, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, errors.New("missing length")
+	}
+	addr, err := strconv.ParseUint(a, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(l, 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(addr), int(n), nil
+}
+
+// resume sets the PC if a c or s packet gives an address.
+func (s *GDBServer) resume(args string) error {
+	if args != "" {
+		addr, err := strconv.ParseUint(args, 16, 32)
+		if err != nil {
+			return err
+		}
+		s.v.CPU.PC = uint32(addr)
+	}
+	return nil
+}
+
+// step executes one instruction and returns the stop reply.
+func (s *GDBServer) step() string {
+	if s.exited {
+		return gdbExited
+	}
+	s.v.CPU.Running = true
+	if reply, stopped := s.execute(); stopped {
+		return reply
+	}
+	return gdbTrap
+}
+
+// run continues until a breakpoint, the end of the program or an interrupt
+// from gdb, and returns the stop reply. The instruction at PC runs even if it
+// has a breakpoint, so that continuing from one moves on. Anything else gdb
+// sends meanwhile is queued for Serve to handle after the stop reply.
+func (s *GDBServer) run(events <-chan gdbEvent) string {
+	c := s.v.CPU
+	if s.exited {
+		return gdbExited
+	}
+	c.Running = true
+	for n := 1; ; n++ {
+		if reply, stopped := s.execute(); stopped {
+			return reply
+		}
+		if c.IsBreakpoint(c.PC) || s.v.Idle() {
+			return gdbTrap
+		}
+		if n%gdbPollInterval == 0 {
+			select {
+			case ev, ok := <-events:
+				switch {
+				case !ok:
+					return gdbInterrupt
+				case ev.interrupt:
+					return gdbInterrupt
+				case ev.err != nil:
+					s.queued = append(s.queued, ev)
+					return gdbInterrupt
+				}
+				s.queued = append(s.queued, ev)
+			default:
+			}
+		}
+	}
+}
+
+// execute runs one instruction, reporting a stop if it halted the program,
+// paused at a watchpoint or failed.
+func (s *GDBServer) execute() (string, bool) {
+	c := s.v.CPU
+	if err := s.v.Step(); err != nil {
+		if s.Log != nil {
+			s.Log.Printf("stopped: %v", err)
+		}
+		var hit *cpu.WatchpointHit
+		if errors.As(err, &hit) {
+			return gdbTrap, true
+		}
+		return gdbIllegal, true
+	}
+	if !c.Running {
+		s.exited = true
+		return gdbExited, true
+	}
+	return "", false
+}
@@ -0,0 +1,267 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Layout of the keyboard, as offsets from its base address. All registers
+// are bytes.
+const (
+	// KeyboardStatus holds the status bits below. Write KeyboardOverflow
+	// back to clear it.
+	KeyboardStatus = 0x0
+	// KeyboardControl holds the control bits below.
+	KeyboardControl = 0x1
+	// KeyboardCount holds the number of events queued. It is read-only.
+	KeyboardCount = 0x2
+	// KeyboardFlags holds the flags of the oldest event, leaving it queued.
+	KeyboardFlags = 0x4
+	// KeyboardCode holds the key code of the oldest event. Reading it takes
+	// the event off the queue, so a word read of KeyboardFlags returns the
+	// flags and code together and moves on to the next event.
+	KeyboardCode = 0x5
+	// KeyboardSize is the size of the device block in bytes.
+	KeyboardSize = 0x8
+)
+
+// Bits of the KeyboardStatus register.
+const (
+	KeyboardReady    = 1 << 0 // An event is queued
+	KeyboardOverflow = 1 << 1 // Events were lost because the queue was full
+	KeyboardEOF      = 1 << 2 // The host input has ended and no more keys will arrive
+)
+
+// Bits of the KeyboardControl register.
+const (
+	// KeyboardInterrupt has the keyboard assert its interrupt level while
+	// an event is queued.
+	KeyboardInterrupt = 1 << 7
+)
+
+// Bits of KeyEvent.Flags and the KeyboardFlags register.
+const (
+	KeyShift    = 1 << 0
+	KeyControl  = 1 << 1
+	KeyAlt      = 1 << 2
+	KeyMeta     = 1 << 3
+	KeyReleased = 1 << 7 // The key went up rather than down
+)
+
+// KeyboardQueueSize is the number of events the keyboard holds before it
+// starts dropping them.
+const KeyboardQueueSize = 64
+
+// ErrNoKeyboard is returned by InjectKey when no keyboard is mapped.
+var ErrNoKeyboard = errors.New("no keyboard is mapped")
+
+// KeyEvent is a key going down or up.
+type KeyEvent struct {
+	// Code identifies the key. Keys typed at a terminal use the character
+	// they send, so escape sequences arrive a byte at a time.
+	Code uint8
+	// Flags holds KeyReleased and the modifier bits.
+	Flags uint8
+}
+
+// keyboard queues key events from the host for the guest, attached to the
+// memory map as a Device. Events come from a reader, such as a terminal in
+// raw mode, or from InjectKey, so a GUI frontend can pass on presses and
+// releases with their modifiers.
+//
+// Polled input waits for KeyboardReady and takes the event:
+//
+//	getkey:	btst	#0,KEYBOARD_STATUS(a0)	; KEYBOARD_READY
+//		beq.s	getkey
+//		move.w	KEYBOARD_FLAGS(a0),d0	; Flags in the high byte, code in the low
+type keyboard struct {
+	base  uint32
+	level int
+	cpu   *cpu.CPU
+
+	mu       sync.Mutex
+	changed  *sync.Cond // Signalled when the queue or control register changes
+	queue    [KeyboardQueueSize]KeyEvent
+	head     int
+	count    int
+	overflow bool
+	control  uint8
+	reading  bool // A host reader is attached and hasn't ended
+	eof      bool
+}
+
+// EnableKeyboard maps a keyboard at base in the memory map, interrupting at
+// level 1-7 with its autovector when enabled. Characters read from in, if it
+// isn't nil, are queued as key presses from a goroutine, which waits while
+// the queue is full rather than lose them; InjectKey adds events from the
+// host at any time. When the CPU is stopped waiting for the keyboard's
+// interrupt, Step blocks until the next key is read instead of spinning.
+func (v *VM) EnableKeyboard(base uint32, level int, in io.Reader) error {
+	if level < 1 || level > 7 {
+		return fmt.Errorf("keyboard interrupt level %d is not 1-7", level)
+	}
+	if v.keyboard != nil {
+		return fmt.Errorf("a keyboard is already mapped at $%08X", v.keyboard.base)
+	}
+	k := &keyboard{base: base, level: level, cpu: v.CPU, reading: in != nil}
+	k.changed = sync.NewCond(&k.mu)
+	if err := v.MemoryMap().MapDevice(base, KeyboardSize, "keyboard", k); err != nil {
+		return err
+	}
+	v.keyboard = k
+	if in != nil {
+		go k.receive(in)
+	}
+	return nil
+}
+
+// InjectKey queues a key event, as if the key had been pressed or released.
+// It is safe to call while another goroutine runs the VM. An event arriving
+// while the queue is full is dropped and sets KeyboardOverflow, as on a real
+// keyboard controller.
+func (v *VM) InjectKey(ev KeyEvent) error {
+	k := v.keyboard
+	if k == nil {
+		return ErrNoKeyboard
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.push(ev)
+	return nil
+}
+
+// receive queues the characters read from in as key presses.
+func (k *keyboard) receive(in io.Reader) {
+	br := bufio.NewReader(in)
+	for {
+		b, err := br.ReadByte()
+		k.mu.Lock()
+		if err != nil {
+			k.reading, k.eof = false, true
+			k.update()
+			k.mu.Unlock()
+			return
+		}
+		for k.count == KeyboardQueueSize {
+			k.changed.Wait()
+		}
+		k.push(KeyEvent{Code: b})
+		k.mu.Unlock()
+	}
+}
+
+// push adds an event to the queue. The caller holds the lock.
+func (k *keyboard) push(ev KeyEvent) {
+	if k.count == KeyboardQueueSize {
+		k.overflow = true
+		return
+	}
+	k.queue[(k.head+k.count)%KeyboardQueueSize] = ev
+	k.count++
+	k.update()
+}
+
+// update sets the interrupt line to match the queue and signals any waiters.
+// The caller holds the lock.
+func (k *keyboard) update() {
+	if k.control&KeyboardInterrupt != 0 && k.count > 0 {
+		k.cpu.RaiseInterrupt(k.level, cpu.Autovector)
+	} else {
+		k.cpu.ClearInterrupt(k.level)
+	}
+	k.changed.Broadcast()
+}
+
+// Read8 reads a register.
+func (k *keyboard) Read8(offset uint32) (uint8, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	switch offset {
+	case KeyboardStatus:
+		return k.status(), nil
+	case KeyboardControl:
+		return k.control, nil
+	case KeyboardCount:
+		return uint8(k.count), nil
+	case KeyboardFlags:
+		return k.queue[k.head].Flags, nil
+	case KeyboardCode:
+		code := k.queue[k.head].Code
+		if k.count > 0 {
+			k.head = (k.head + 1) % KeyboardQueueSize
+			k.count--
+			k.update()
+		}
+		return code, nil
+	}
+	return 0, nil
+}
+
+// Write8 writes a register.
+func (k *keyboard) Write8(offset uint32, v uint8) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	switch offset {
+	case KeyboardStatus:
+		if v&KeyboardOverflow != 0 {
+			k.overflow = false
+		}
+	case KeyboardControl:
+		k.control = v
+		k.update()
+	}
+	return nil
+}
+
+// status returns the KeyboardStatus register. The caller holds the lock.
+func (k *keyboard) status() uint8 {
+	var s uint8
+	if k.count > 0 {
+		s |= KeyboardReady
+	}
+	if k.overflow {
+		s |= KeyboardOverflow
+	}
+	if k.eof {
+		s |= KeyboardEOF
+	}
+	return s
+}
+
+// mayInterrupt reports whether the keyboard could still raise its
+// interrupt. Without a host reader, InjectKey may add a key at any time.
+func (k *keyboard) mayInterrupt() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.control&KeyboardInterrupt != 0 && (k.count > 0 || !k.eof)
+}
+
+// keyboardMayInterrupt reports whether a keyboard is mapped and could still
+// raise its interrupt.
+func (v *VM) keyboardMayInterrupt() bool {
+	return v.keyboard != nil && v.keyboard.mayInterrupt()
+}
+
+// wait blocks while the queue is empty, the host reader may send more and
+// the interrupt is enabled, for a CPU stopped with nothing else to wake it.
+// It returns early once ctx is done, which wake signals.
+func (k *keyboard) wait(ctx context.Context) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for k.count == 0 && k.reading && k.control&KeyboardInterrupt != 0 && ctx.Err() == nil {
+		k.changed.Wait()
+	}
+}
+
+// wake signals any waiter to check its conditions again.
+func (k *keyboard) wake() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.changed.Broadcast()
+}
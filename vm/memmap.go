@@ -0,0 +1,364 @@
+package vm
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// ErrReadOnly is returned by a MemoryMap for a write to ROM.
+var ErrReadOnly = errors.New("address is read-only")
+
+// RegionKind says how a MemoryMap treats a range of addresses.
+type RegionKind int
+
+const (
+	// RegionRAM is ordinary readable and writable memory.
+	RegionRAM RegionKind = iota
+	// RegionROM can be read but not written.
+	RegionROM
+	// RegionUnmapped answers neither reads nor writes.
+	RegionUnmapped
+	// RegionDevice is answered by a Device, registered with MapDevice.
+	RegionDevice
+)
+
+// String returns the name of the region kind.
+func (k RegionKind) String() string {
+	switch k {
+	case RegionRAM:
+		return "RAM"
+	case RegionROM:
+		return "ROM"
+	case RegionUnmapped:
+		return "unmapped"
+	case RegionDevice:
+		return "device"
+	}
+	return fmt.Sprintf("RegionKind(%d)", int(k))
+}
+
+// Region is a range of addresses registered with a MemoryMap.
+type Region struct {
+	Start uint32
+	Size  uint32
+	Kind  RegionKind
+	// Name describes the region in listings, e.g. "kernel ROM".
+	Name string
+}
+
+// End returns the address just past the region.
+func (r Region) End() uint64 {
+	return uint64(r.Start) + uint64(r.Size)
+}
+
+// overlaps reports whether n bytes at addr touch the region.
+func (r Region) overlaps(addr, n uint32) bool {
+	return uint64(addr) < r.End() && uint64(addr)+uint64(n) > uint64(r.Start)
+}
+
+// Device is a memory-mapped peripheral, attached to a MemoryMap with
+// MapDevice. Its registers are addressed by their offset from the start of
+// its region. Word and long accesses reach it a byte at a time, most
+// significant first, so a device only has to handle bytes. An error takes a
+// bus error exception.
+type Device interface {
+	Read8(offset uint32) (uint8, error)
+	Write8(offset uint32, v uint8) error
+}
+
+// MemoryMap is a cpu.Bus that divides the VM's memory into RAM, ROM, device
+// and unmapped regions. ROM contents live in the same memory as RAM, so they are
+// loaded, disassembled and examined from the monitor like any other code; only
+// the CPU is kept from writing them. Addresses outside every region behave as
+// RAM. Reads and writes of unmapped addresses, and writes to ROM unless
+// IgnoreROMWrites is set, take a bus error exception.
+type MemoryMap struct {
+	// IgnoreROMWrites drops writes to ROM instead of raising a bus error, as
+	// on boards where ROM simply doesn't respond to the write strobe.
+	IgnoreROMWrites bool
+
+	ram     cpu.RAM
+	regions []Region
+	devices map[uint32]Device // By the start of their region
+}
+
+// NewMemoryMap returns a map over mem with no regions registered.
+func NewMemoryMap(mem []byte) *MemoryMap {
+	return &MemoryMap{ram: cpu.RAM(mem)}
+}
+
+// Map registers a region. Regions may not overlap each other. Device regions
+// are registered with MapDevice.
+func (m *MemoryMap) Map(r Region) error {
+	if r.Kind == RegionDevice {
+		return fmt.Errorf("region %q needs a device; use MapDevice", r.Name)
+	}
+	return m.mapRegion(r)
+}
+
+// MapDevice registers a region of size bytes at start that d answers. The
+// device's registers take the place of memory there, so the CPU's accesses
+// have whatever side effects the device gives them.
+func (m *MemoryMap) MapDevice(start, size uint32, name string, d Device) error {
+	if d == nil {
+		return fmt.Errorf("no device for region %q", name)
+	}
+	if err := m.mapRegion(Region{Start: start, Size: size, Kind: RegionDevice, Name: name}); err != nil {
+		return err
+	}
+	if m.devices == nil {
+		m.devices = make(map[uint32]Device)
+	}
+	m.devices[start] = d
+	return nil
+}
+
+// mapRegion adds a region of any kind.
+func (m *MemoryMap) mapRegion(r Region) error {
+	if r.Size == 0 {
+		return fmt.Errorf("region %q is empty", r.Name)
+	}
+	if r.End() > 1<<32 {
+		return fmt.Errorf("region %q at $%08X runs past the end of the address space", r.Name, r.Start)
+	}
+	for _, old := range m.regions {
+		if old.overlaps(r.Start, r.Size) {
+			return fmt.Errorf("region %q at $%08X overlaps %q at $%08X", r.Name, r.Start, old.Name, old.Start)
+		}
+	}
+	m.regions = append(m.regions, r)
+	slices.SortFunc(m.regions, func(a, b Region) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return nil
+}
+
+// Regions returns the registered regions in address order.
+func (m *MemoryMap) Regions() []Region {
+	return slices.Clone(m.regions)
+}
+
+// Find returns the region holding addr, or false if it is in none.
+func (m *MemoryMap) Find(addr uint32) (Region, bool) {
+	for _, r := range m.regions {
+		if r.overlaps(addr, 1) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// touchesDevice reports whether n bytes at addr reach a device.
+func (m *MemoryMap) touchesDevice(addr, n uint32) bool {
+	if len(m.devices) == 0 {
+		return false
+	}
+	for _, r := range m.regions {
+		if r.Kind == RegionDevice && r.overlaps(addr, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// readBytes reads n bytes at addr one at a time, for an access that reaches a
+// device, and returns them as a big-endian value.
+func (m *MemoryMap) readBytes(addr, n uint32) (uint32, error) {
+	var v uint32
+	for i := range n {
+		a := addr + i
+		var b uint8
+		var err error
+		if r, ok := m.Find(a); ok && r.Kind == RegionDevice {
+			b, err = m.devices[r.Start].Read8(a - r.Start)
+		} else if _, err = m.check(a, 1, false); err == nil {
+			b, err = m.ram.Read8(a)
+		}
+		if err != nil {
+			return 0, err
+		}
+		v = v<<8 | uint32(b)
+	}
+	return v, nil
+}
+
+// writeBytes writes the n low bytes of v at addr one at a time, most
+// significant first, for an access that reaches a device.
+func (m *MemoryMap) writeBytes(addr, n, v uint32) error {
+	for i := range n {
+		a := addr + i
+		b := uint8(v >> (8 * (n - 1 - i)))
+		if r, ok := m.Find(a); ok && r.Kind == RegionDevice {
+			if err := m.devices[r.Start].Write8(a-r.Start, b); err != nil {
+				return err
+			}
+			continue
+		}
+		rom, err := m.check(a, 1, true)
+		if err != nil {
+			return err
+		}
+		if !rom {
+			if err := m.ram.Write8(a, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check returns the error for an access of n bytes at addr, and whether it
+// touches ROM.
+func (m *MemoryMap) check(addr, n uint32, write bool) (rom bool, err error) {
+	for _, r := range m.regions {
+		if !r.overlaps(addr, n) {
+			continue
+		}
+		switch r.Kind {
+		case RegionUnmapped:
+			return false, cpu.ErrUnmapped
+		case RegionROM:
+			rom = true
+		}
+	}
+	if rom && write && !m.IgnoreROMWrites {
+		return true, ErrReadOnly
+	}
+	return rom, nil
+}
+
+// Read8 reads a byte.
+func (m *MemoryMap) Read8(addr uint32) (uint8, error) {
+	if m.touchesDevice(addr, 1) {
+		v, err := m.readBytes(addr, 1)
+		return uint8(v), err
+	}
+	if _, err := m.check(addr, 1, false); err != nil {
+		return 0, err
+	}
+	return m.ram.Read8(addr)
+}
+
+// Read16 reads a word.
+func (m *MemoryMap) Read16(addr uint32) (uint16, error) {
+	if m.touchesDevice(addr, 2) {
+		v, err := m.readBytes(addr, 2)
+		return uint16(v), err
+	}
+	if _, err := m.check(addr, 2, false); err != nil {
+		return 0, err
+	}
+	return m.ram.Read16(addr)
+}
+
+// Read32 reads a long.
+func (m *MemoryMap) Read32(addr uint32) (uint32, error) {
+	if m.touchesDevice(addr, 4) {
+		return m.readBytes(addr, 4)
+	}
+	if _, err := m.check(addr, 4, false); err != nil {
+		return 0, err
+	}
+	return m.ram.Read32(addr)
+}
+
+// Write8 writes a byte.
+func (m *MemoryMap) Write8(addr uint32, v uint8) error {
+	if m.touchesDevice(addr, 1) {
+		return m.writeBytes(addr, 1, uint32(v))
+	}
+	rom, err := m.check(addr, 1, true)
+	if err != nil || rom {
+		return err
+	}
+	return m.ram.Write8(addr, v)
+}
+
+// Write16 writes a word.
+func (m *MemoryMap) Write16(addr uint32, v uint16) error {
+	if m.touchesDevice(addr, 2) {
+		return m.writeBytes(addr, 2, uint32(v))
+	}
+	rom, err := m.check(addr, 2, true)
+	if err != nil {
+		return err
+	}
+	if rom {
+		return m.writeAround(addr, []byte{byte(v >> 8), byte(v)})
+	}
+	return m.ram.Write16(addr, v)
+}
+
+// Write32 writes a long.
+func (m *MemoryMap) Write32(addr uint32, v uint32) error {
+	if m.touchesDevice(addr, 4) {
+		return m.writeBytes(addr, 4, v)
+	}
+	rom, err := m.check(addr, 4, true)
+	if err != nil {
+		return err
+	}
+	if rom {
+		return m.writeAround(addr, []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+	}
+	return m.ram.Write32(addr, v)
+}
+
+// writeAround stores the bytes of an ignored ROM write that fall outside ROM.
+func (m *MemoryMap) writeAround(addr uint32, b []byte) error {
+	for i, v := range b {
+		a := addr + uint32(i)
+		if r, ok := m.Find(a); ok && r.Kind == RegionROM {
+			continue
+		}
+		if err := m.ram.Write8(a, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryMap returns the VM's memory map, installing it as the CPU's bus the
+// first time. Until then the CPU sees its memory as plain RAM.
+func (v *VM) MemoryMap() *MemoryMap {
+	if v.memMap == nil {
+		v.memMap = NewMemoryMap(v.CPU.Mem)
+		v.CPU.Bus = v.memMap
+		v.CPU.FlushCache()
+	}
+	return v.memMap
+}
+
+// LoadROM copies image into memory at addr and maps it as ROM.
+func (v *VM) LoadROM(addr uint32, image []byte, name string) error {
+	if uint64(addr)+uint64(len(image)) > uint64(len(v.CPU.Mem)) {
+		return fmt.Errorf("ROM %q at $%08X is outside memory", name, addr)
+	}
+	if err := v.MemoryMap().Map(Region{Start: addr, Size: uint32(len(image)), Kind: RegionROM, Name: name}); err != nil {
+		return err
+	}
+	copy(v.CPU.Mem[addr:], image)
+	return nil
+}
+
+// checkRAM returns an error unless the size bytes at base are in memory and
+// clear of every ROM, device and unmapped region, for a block the VM itself
+// keeps up to date in RAM.
+func (v *VM) checkRAM(base, size uint32, what string) error {
+	if uint64(base)+uint64(size) > uint64(len(v.CPU.Mem)) {
+		return fmt.Errorf("%s at $%08X is outside memory", what, base)
+	}
+	if v.memMap == nil {
+		return nil
+	}
+	for _, r := range v.memMap.regions {
+		if r.Kind != RegionRAM && r.overlaps(base, size) {
+			return fmt.Errorf("%s at $%08X overlaps %s %q", what, base, r.Kind, r.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// MetricsInterval is how many instructions Step runs between refreshes of the
+// published metrics.
+const MetricsInterval = 4096
+
+// Metrics are counters describing the work a VM has done since it was created.
+// The counters are totals over every CPU, and CPUs has each one's own.
+type Metrics struct {
+	CPUMetrics
+	Uptime float64 `json:"uptime_seconds"`
+	// MIPS is the average instruction rate in millions per second over Uptime.
+	MIPS float64 `json:"mips"`
+	// CPUs holds the counters of each CPU, the first being VM.CPU.
+	CPUs []CPUMetrics `json:"cpus"`
+}
+
+// CPUMetrics are the counters of one CPU.
+type CPUMetrics struct {
+	Instructions uint64 `json:"instructions"`
+	Cycles       uint64 `json:"cycles"`
+	Exceptions   uint64 `json:"exceptions"`
+	// Interrupts counts the interrupt requests raised, by devices or with
+	// RaiseInterrupt, whether or not they have been taken yet.
+	Interrupts  uint64 `json:"interrupts"`
+	CacheHits   uint64 `json:"cache_hits"`
+	CacheMisses uint64 `json:"cache_misses"`
+}
+
+// Metrics returns the current counters. It reads the CPUs directly, so call
+// it from the goroutine running the VM; other goroutines should use
+// PublishedMetrics.
+func (v *VM) Metrics() Metrics {
+	m := Metrics{Uptime: time.Since(v.started).Seconds()}
+	for _, c := range v.CPUs() {
+		s := c.CacheStats()
+		cm := CPUMetrics{
+			Instructions: c.Instructions,
+			Cycles:       c.Cycles,
+			Exceptions:   c.Exceptions,
+			Interrupts:   c.InterruptsRaised(),
+			CacheHits:    s.Hits,
+			CacheMisses:  s.Misses,
+		}
+		m.CPUs = append(m.CPUs, cm)
+		m.Instructions += cm.Instructions
+		m.Cycles += cm.Cycles
+		m.Exceptions += cm.Exceptions
+		m.Interrupts += cm.Interrupts
+		m.CacheHits += cm.CacheHits
+		m.CacheMisses += cm.CacheMisses
+	}
+	if m.Uptime > 0 {
+		m.MIPS = float64(m.Instructions) / m.Uptime / 1e6
+	}
+	return m
+}
+
+// EnableMetrics makes Step refresh a copy of the metrics every MetricsInterval
+// instructions, for PublishedMetrics to hand to other goroutines. Call it before
+// running the VM.
+func (v *VM) EnableMetrics() {
+	if v.metricsEnabled {
+		return
+	}
+	v.metricsEnabled = true
+	v.UpdateMetrics()
+}
+
+// UpdateMetrics refreshes the published copy of the metrics immediately, e.g.
+// when the VM halts between refreshes.
+func (v *VM) UpdateMetrics() {
+	m := v.Metrics()
+	v.published.Store(&m)
+}
+
+// PublishedMetrics returns the metrics as of the last refresh. It is safe to
+// call while another goroutine runs the VM.
+func (v *VM) PublishedMetrics() Metrics {
+	if m := v.published.Load(); m != nil {
+		return *m
+	}
+	return Metrics{}
+}
+
+// PublishMetrics enables metrics and exports them as the expvar variable name,
+// which net/http serves at /debug/vars. Like expvar.Publish, it panics if the
+// name is already in use.
+func (v *VM) PublishMetrics(name string) {
+	v.EnableMetrics()
+	expvar.Publish(name, expvar.Func(func() any { return v.PublishedMetrics() }))
+}
+
+// MetricsHandler enables metrics and returns an HTTP handler serving them in
+// the Prometheus text format, with each name starting with prefix.
+func (v *VM) MetricsHandler(prefix string) http.Handler {
+	v.EnableMetrics()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		v.PublishedMetrics().WritePrometheus(w, prefix)
+	})
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text format. The
+// counters have a sample for each CPU, labelled with its index as cpu="0"
+// and so on.
+func (m Metrics) WritePrometheus(w io.Writer, prefix string) error {
+	counters := []struct {
+		name, help string
+		value      func(CPUMetrics) uint64
+	}{
+		{"instructions_total", "Instructions executed.", func(c CPUMetrics) uint64 { return c.Instructions }},
+		{"cycles_total", "Clock cycles elapsed.", func(c CPUMetrics) uint64 { return c.Cycles }},
+		{"exceptions_total", "Exceptions raised, including TRAPs.", func(c CPUMetrics) uint64 { return c.Exceptions }},
+		{"interrupts_total", "Interrupt requests raised.", func(c CPUMetrics) uint64 { return c.Interrupts }},
+		{"icache_hits_total", "Decoded instruction cache hits.", func(c CPUMetrics) uint64 { return c.CacheHits }},
+		{"icache_misses_total", "Decoded instruction cache misses.", func(c CPUMetrics) uint64 { return c.CacheMisses }},
+	}
+	for _, x := range counters {
+		name := prefix + x.name
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, x.help, name); err != nil {
+			return err
+		}
+		for i, c := range m.CPUs {
+			if _, err := fmt.Fprintf(w, "%s{cpu=\"%d\"} %d\n", name, i, x.value(c)); err != nil {
+				return err
+			}
+		}
+	}
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"uptime_seconds", "Seconds since the VM was created.", m.Uptime},
+		{"mips", "Average millions of instructions per second, over every CPU.", m.MIPS},
+	}
+	for _, x := range gauges {
+		name := prefix + x.name
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, x.help, name, name, x.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
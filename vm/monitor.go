@@ -0,0 +1,515 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// DefaultStepLimit bounds GO and T so a runaway program returns to the prompt.
+const DefaultStepLimit = 10000000
+
+// monitorHelp lists the monitor commands. Numbers are hex, with or without "$".
+const monitorHelp = `Commands (numbers in hex; addresses may be symbols):
+  MD addr [count]          display memory
+  MM[.B|.W|.L] addr val..  modify memory
+  DF                       display registers
+  DI [addr] [count]        disassemble with operand values
+  .Dn/.An/.PC/.SR val      set a register
+  GO [addr]                run until the program halts or hits a breakpoint
+  BR [addr..]              set breakpoints, or list them
+  NOBR [addr..]            remove breakpoints, or all of them
+  T [count]                trace instructions
+  EX [-]                   exception counts and history, or clear them
+  TA [loc..|-]             taint registers or addr[.B|.W|.L], list or clear
+  SY [name]                list symbols, or those containing name
+  HE                       this help
+  QU                       leave the monitor
+`
+
+// Monitor is a TUTOR/zBug-style machine monitor driven by a line-based console.
+type Monitor struct {
+	// StepLimit is the most instructions GO or T will run before returning.
+	StepLimit int
+
+	vm     *VM
+	uart   *uart // The UART whose line the monitor shares, if any
+	in     *bufio.Scanner
+	out    io.Writer
+	mdNext uint32
+	diNext uint32
+	diPC   uint32
+}
+
+// NewMonitor creates a monitor for v reading commands from in and writing to out.
+func (v *VM) NewMonitor(in io.Reader, out io.Writer) *Monitor {
+	return &Monitor{
+		StepLimit: DefaultStepLimit,
+		vm:        v,
+		in:        bufio.NewScanner(in),
+		out:       styleWriter{out, v},
+	}
+}
+
+// Run prompts for and executes commands until QU or the end of input.
+func (m *Monitor) Run() error {
+	fmt.Fprintf(m.out, "m68k monitor, HE for help\n")
+	for {
+		fmt.Fprintf(m.out, "> ")
+		if !m.in.Scan() {
+			fmt.Fprintln(m.out)
+			return m.in.Err()
+		}
+
+		quit, err := m.Command(m.in.Text())
+		if err != nil {
+			fmt.Fprintf(m.out, "? %v\n", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Command executes a single monitor command line. It reports whether the command
+// asked to leave the monitor.
+func (m *Monitor) Command(line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+
+	cmd := strings.ToUpper(fields[0])
+	args := fields[1:]
+	switch {
+	case cmd == "HE" || cmd == "?" || cmd == "HELP":
+		_, err := io.WriteString(m.out, monitorHelp)
+		return false, err
+
+	case cmd == "QU" || cmd == "QUIT" || cmd == "EXIT":
+		return true, nil
+
+	case cmd == "DF":
+		return false, m.vm.WriteState(m.out, StateMonitor)
+
+	case cmd == "MD":
+		return false, m.memoryDisplay(args)
+
+	case cmd == "DI":
+		return false, m.disassemble(args)
+
+	case cmd == "MM" || strings.HasPrefix(cmd, "MM."):
+		return false, m.memoryModify(cmd, args)
+
+	case strings.HasPrefix(cmd, "."):
+		return false, m.setRegister(cmd[1:], args)
+
+	case cmd == "GO" || cmd == "G":
+		return false, m.lendLine(m.goCmd, args)
+
+	case cmd == "BR":
+		return false, m.breakpoints(args)
+
+	case cmd == "NOBR":
+		return false, m.noBreakpoints(args)
+
+	case cmd == "EX":
+		return false, m.exceptions(args)
+
+	case cmd == "TA":
+		return false, m.taint(args)
+
+	case cmd == "SY":
+		return false, m.symbols(args)
+
+	case cmd == "T" || cmd == "TR":
+		return false, m.lendLine(m.trace, args)
+
+	default:
+		return false, fmt.Errorf("unknown command %s", fields[0])
+	}
+}
+
+// lendLine runs cmd with the UART's input going to the program, if the
+// monitor shares the UART's line.
+func (m *Monitor) lendLine(cmd func([]string) error, args []string) error {
+	if m.uart != nil {
+		m.uart.setMonitoring(false)
+		defer m.uart.setMonitoring(true)
+	}
+	return cmd(args)
+}
+
+// memoryDisplay dumps count bytes (default 64) as hex and ASCII. Without
+// arguments it continues where the last display ended.
+func (m *Monitor) memoryDisplay(args []string) error {
+	addr, count := m.mdNext, uint32(64)
+	if len(args) > 0 {
+		v, err := m.parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		addr = v
+	}
+	if len(args) > 1 {
+		v, err := parseHex(args[1])
+		if err != nil {
+			return err
+		}
+		count = v
+	}
+	if err := m.checkRange(addr, count); err != nil {
+		return err
+	}
+
+	mem := m.vm.CPU.Mem
+	for off := uint32(0); off < count; off += 16 {
+		n := min(16, count-off)
+		line := mem[addr+off : addr+off+n]
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%08X  ", addr+off)
+		for i := uint32(0); i < 16; i++ {
+			if i < n {
+				fmt.Fprintf(&sb, "%02X ", line[i])
+			} else {
+				sb.WriteString("   ")
+			}
+		}
+		sb.WriteString(" ")
+		for _, b := range line {
+			if b >= 0x20 && b <= 0x7E {
+				sb.WriteByte(b)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		fmt.Fprintln(m.out, sb.String())
+	}
+	m.mdNext = addr + count
+	return nil
+}
+
+// disassemble lists count instructions (default 8) from addr, annotated with the
+// values of their operands. Without an address it starts at the PC, or after
+// the last listing if the PC has not moved since.
+func (m *Monitor) disassemble(args []string) error {
+	addr, count := m.vm.CPU.PC, 8
+	if m.diNext != 0 && m.diPC == addr {
+		addr = m.diNext
+	}
+	if len(args) > 0 {
+		v, err := m.parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		addr = v
+	}
+	if len(args) > 1 {
+		v, err := parseHex(args[1])
+		if err != nil {
+			return err
+		}
+		count = int(v)
+	}
+	if err := m.checkRange(addr, 2); err != nil {
+		return err
+	}
+
+	for _, li := range m.vm.Disassemble(addr, count) {
+		if name, ok := m.vm.Symbols[li.Address]; ok {
+			fmt.Fprintf(m.out, "%s:\n", name)
+		}
+		if loc := reTarget.FindStringSubmatchIndex(li.Operands); loc != nil && !strings.HasSuffix(li.Operands[:loc[0]], "#") {
+			target, _ := strconv.ParseUint(li.Operands[loc[2]:loc[3]], 16, 32)
+			if name, ok := m.vm.Symbols[uint32(target)]; ok {
+				inst := *li.Instruction
+				inst.Operands = li.Operands[:loc[0]] + name
+				li.Instruction = &inst
+			}
+		}
+		fmt.Fprintln(m.out, li)
+		addr = li.Address + li.Size
+	}
+	m.diNext, m.diPC = addr, m.vm.CPU.PC
+	return nil
+}
+
+// memoryModify writes one or more values starting at an address.
+func (m *Monitor) memoryModify(cmd string, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: MM[.B|.W|.L] addr val..")
+	}
+
+	size := uint32(1)
+	switch strings.TrimPrefix(cmd, "MM") {
+	case "", ".B":
+	case ".W":
+		size = 2
+	case ".L":
+		size = 4
+	default:
+		return fmt.Errorf("unknown size %s", cmd)
+	}
+
+	addr, err := m.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	if err := m.checkRange(addr, size*uint32(len(args)-1)); err != nil {
+		return err
+	}
+
+	mem := m.vm.CPU.Memory()
+	for _, a := range args[1:] {
+		v, err := parseHex(a)
+		if err != nil {
+			return err
+		}
+		switch size {
+		case 1:
+			err = mem.WriteU8(addr, byte(v))
+		case 2:
+			err = mem.WriteU16(addr, uint16(v))
+		case 4:
+			err = mem.WriteU32(addr, v)
+		}
+		if err != nil {
+			return err
+		}
+		addr += size
+	}
+	return nil
+}
+
+// setRegister handles .D0-.D7, .A0-.A7, .PC and .SR.
+func (m *Monitor) setRegister(name string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: .%s val", name)
+	}
+	v, err := parseHex(args[0])
+	if err != nil {
+		return err
+	}
+
+	c := m.vm.CPU
+	name = strings.ToUpper(name)
+	switch {
+	case name == "PC":
+		c.PC = v
+	case name == "SR":
+		c.SR = cpu.SR(v)
+	case len(name) == 2 && name[1] >= '0' && name[1] <= '7' && (name[0] == 'D' || name[0] == 'A'):
+		if name[0] == 'D' {
+			c.D[name[1]-'0'] = v
+		} else {
+			c.A[name[1]-'0'] = v
+		}
+	default:
+		return fmt.Errorf("unknown register %s", name)
+	}
+	return nil
+}
+
+// goCmd runs from addr (or the current PC) until the program halts.
+func (m *Monitor) goCmd(args []string) error {
+	c := m.vm.CPU
+	if len(args) > 0 {
+		v, err := m.parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		c.PC = v
+	}
+
+	c.Running = true
+	steps := 0
+	for ; c.Running && !m.vm.Idle() && steps < m.StepLimit; steps++ {
+		if steps > 0 && c.IsBreakpoint(c.PC) {
+			break
+		}
+		if err := m.vm.Step(); err != nil {
+			c.Running = false
+			m.vm.WriteState(m.out, StateMonitor)
+			return err
+		}
+	}
+	switch {
+	case c.Running && c.IsBreakpoint(c.PC) && steps < m.StepLimit:
+		fmt.Fprintf(m.out, "Breakpoint at %08X after %d instructions\n", c.PC, steps)
+		c.Running = false
+	case m.vm.Idle():
+		fmt.Fprintf(m.out, "Stopped by STOP after %d instructions\n", steps)
+		c.Running = false
+	case c.Running:
+		fmt.Fprintf(m.out, "Stopped after %d instructions\n", steps)
+		c.Running = false
+	default:
+		fmt.Fprintf(m.out, "Halted after %d instructions\n", steps)
+	}
+	return m.vm.WriteState(m.out, StateMonitor)
+}
+
+// trace executes count instructions (default 1), showing the registers after each.
+func (m *Monitor) trace(args []string) error {
+	count := uint32(1)
+	if len(args) > 0 {
+		v, err := parseHex(args[0])
+		if err != nil {
+			return err
+		}
+		count = v
+	}
+
+	c := m.vm.CPU
+	c.Running = true
+	defer func() { c.Running = false }()
+	for i := uint32(0); i < count && i < uint32(m.StepLimit) && c.Running && !m.vm.Idle(); i++ {
+		if err := m.vm.Step(); err != nil {
+			return err
+		}
+		if err := m.vm.WriteState(m.out, StateCompact); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// breakpoints sets a breakpoint at each address, or lists them.
+func (m *Monitor) breakpoints(args []string) error {
+	c := m.vm.CPU
+	if len(args) == 0 {
+		for _, addr := range c.Breakpoints() {
+			if name, ok := m.vm.Symbols[addr]; ok {
+				fmt.Fprintf(m.out, "%08X  %s\n", addr, name)
+			} else {
+				fmt.Fprintf(m.out, "%08X\n", addr)
+			}
+		}
+		return nil
+	}
+	for _, a := range args {
+		addr, err := m.parseAddr(a)
+		if err != nil {
+			return err
+		}
+		c.AddBreakpoint(addr)
+	}
+	return nil
+}
+
+// noBreakpoints removes the breakpoints at the addresses, or all of them.
+func (m *Monitor) noBreakpoints(args []string) error {
+	c := m.vm.CPU
+	if len(args) == 0 {
+		c.ClearBreakpoints()
+		return nil
+	}
+	for _, a := range args {
+		addr, err := m.parseAddr(a)
+		if err != nil {
+			return err
+		}
+		if !c.RemoveBreakpoint(addr) {
+			return fmt.Errorf("no breakpoint at %08X", addr)
+		}
+	}
+	return nil
+}
+
+// exceptions lists how often each vector was taken and the most recent
+// exceptions, oldest first. "-" clears them.
+func (m *Monitor) exceptions(args []string) error {
+	c := m.vm.CPU
+	if len(args) == 1 && args[0] == "-" {
+		c.ResetExceptionStats()
+		return nil
+	}
+
+	counts := c.ExceptionCounts()
+	if len(counts) == 0 {
+		fmt.Fprintln(m.out, "No exceptions")
+		return nil
+	}
+	vectors := slices.Sorted(maps.Keys(counts))
+	for _, v := range vectors {
+		fmt.Fprintf(m.out, "%3d %-24s %d\n", v, cpu.VectorName(v), counts[v])
+	}
+	fmt.Fprintln(m.out, "Recent:")
+	for _, r := range c.RecentExceptions() {
+		fmt.Fprintf(m.out, "  %s\n", r)
+	}
+	return nil
+}
+
+// taint marks registers or memory for taint tracking, logging the instructions
+// that touch them to the console. Without arguments it lists what is tainted;
+// "-" stops tracking.
+func (m *Monitor) taint(args []string) error {
+	if len(args) == 1 && args[0] == "-" {
+		m.vm.DisableTaint()
+		return nil
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(m.out, "Tainted: %s\n", strings.Join(m.vm.Tainted(), " "))
+		return nil
+	}
+
+	m.vm.EnableTaint(m.out)
+	for _, a := range args {
+		if err := m.vm.Taint(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbols lists the symbols in address order, or those whose names contain
+// the argument.
+func (m *Monitor) symbols(args []string) error {
+	addrs := slices.Sorted(maps.Keys(m.vm.Symbols))
+	for _, addr := range addrs {
+		name := m.vm.Symbols[addr]
+		if len(args) > 0 && !strings.Contains(strings.ToLower(name), strings.ToLower(args[0])) {
+			continue
+		}
+		fmt.Fprintf(m.out, "%08X  %s\n", addr, name)
+	}
+	return nil
+}
+
+// reTarget matches an absolute address or branch target ending the operands.
+var reTarget = regexp.MustCompile(`\$([0-9a-f]+)(\.[wl])?$`)
+
+// parseAddr parses an address given as a symbol name or a hex number.
+func (m *Monitor) parseAddr(s string) (uint32, error) {
+	if addr, ok := m.vm.Symbols.Lookup(s); ok {
+		return addr, nil
+	}
+	return parseHex(s)
+}
+
+// checkRange makes sure [addr, addr+n) is inside memory.
+func (m *Monitor) checkRange(addr, n uint32) error {
+	if uint64(addr)+uint64(n) > uint64(len(m.vm.CPU.Mem)) {
+		return fmt.Errorf("address $%08X out of range", addr)
+	}
+	return nil
+}
+
+// parseHex parses a monitor number: hex, with an optional "$" or "0x" prefix.
+func parseHex(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", s)
+	}
+	return uint32(v), nil
+}
@@ -0,0 +1,139 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// sharedBus gives the extra CPUs the first CPU's bus, whatever it is at the
+// time, so memory maps, devices and statistics installed later apply to every
+// CPU alike.
+type sharedBus struct {
+	v *VM
+}
+
+// Read8 reads a byte.
+func (b sharedBus) Read8(addr uint32) (uint8, error) {
+	return b.v.CPU.Bus.Read8(addr)
+}
+
+// Read16 reads a word.
+func (b sharedBus) Read16(addr uint32) (uint16, error) {
+	return b.v.CPU.Bus.Read16(addr)
+}
+
+// Read32 reads a long.
+func (b sharedBus) Read32(addr uint32) (uint32, error) {
+	return b.v.CPU.Bus.Read32(addr)
+}
+
+// Write8 writes a byte.
+func (b sharedBus) Write8(addr uint32, v uint8) error {
+	return b.v.CPU.Bus.Write8(addr, v)
+}
+
+// Write16 writes a word.
+func (b sharedBus) Write16(addr uint32, v uint16) error {
+	return b.v.CPU.Bus.Write16(addr, v)
+}
+
+// Write32 writes a long.
+func (b sharedBus) Write32(addr uint32, v uint32) error {
+	return b.v.CPU.Bus.Write32(addr, v)
+}
+
+// AddCPU adds another processor sharing the first CPU's memory and bus, such
+// as a second 68000 acting as a sound or graphics coprocessor, and returns
+// it. The new CPU has the first one's model, address mask and alignment
+// checks and a decoded instruction cache of cachesize entries. It starts out
+// halted in supervisor mode: set its PC and A7, and Running, to start it.
+//
+// With more than one CPU, Step runs an instruction on whichever running CPU
+// has used the fewest clock cycles, so the CPUs keep pace with each other as
+// if they shared a clock, and a test-and-set with TAS is atomic between them.
+// Devices interrupt the first CPU; the host raises interrupts on the others
+// with their own RaiseInterrupt. The monitor, gdb server and run statistics
+// look at the first CPU only.
+func (v *VM) AddCPU(cachesize int) *cpu.CPU {
+	first := v.CPU
+	c := &cpu.CPU{
+		Mem:             first.Mem,
+		Bus:             sharedBus{v},
+		AddressMask:     first.AddressMask,
+		ICache:          cpu.NewCache(cachesize),
+		SR:              cpu.SRS | cpu.SRI,
+		Model:           first.Model,
+		StrictAlignment: first.StrictAlignment,
+		Unimplemented:   first.Unimplemented,
+		OnUnimplemented: first.OnUnimplemented,
+	}
+	if _, ok := first.Coprocessors[1].(*cpu.FPU); ok {
+		c.Coprocessors[1] = cpu.NewFPU() // Each CPU has its own FPU.
+	}
+	v.cores = append(v.cores, c)
+	return c
+}
+
+// CPUs returns every CPU in the VM, the first being VM.CPU.
+func (v *VM) CPUs() []*cpu.CPU {
+	return append([]*cpu.CPU{v.CPU}, v.cores...)
+}
+
+// Running reports whether any CPU is running.
+func (v *VM) Running() bool {
+	if v.CPU.Running {
+		return true
+	}
+	for _, c := range v.cores {
+		if c.Running {
+			return true
+		}
+	}
+	return false
+}
+
+// nextCPU returns the index of the running CPU that has used the fewest
+// cycles, preferring the first CPU on a tie or if none is running.
+func (v *VM) nextCPU() int {
+	next, least := 0, v.CPU.Cycles
+	if !v.CPU.Running {
+		next, least = -1, 0
+	}
+	for i, c := range v.cores {
+		if c.Running && (next < 0 || c.Cycles < least) {
+			next, least = i+1, c.Cycles
+		}
+	}
+	return max(next, 0)
+}
+
+// coresBusy reports whether an extra CPU is running and not stopped waiting
+// for an interrupt, so the VM must keep stepping even if the first CPU has
+// nothing to do.
+func (v *VM) coresBusy() bool {
+	for _, c := range v.cores {
+		if c.Running && !c.Idle() {
+			return true
+		}
+	}
+	return false
+}
+
+// stepCore runs an instruction on extra CPU n, numbered from 1.
+func (v *VM) stepCore(n int) error {
+	if err := v.cores[n-1].Execute(); err != nil {
+		return fmt.Errorf("CPU %d: %w", n, err)
+	}
+	return nil
+}
+
+// WriteCPUState writes the registers of CPU n, numbered from 0 as in CPUs,
+// to w in the given format.
+func (v *VM) WriteCPUState(w io.Writer, format StateFormat, n int) error {
+	if n < 0 || n > len(v.cores) {
+		return fmt.Errorf("CPU %d is not 0-%d", n, len(v.cores))
+	}
+	return v.writeState(w, format, v.CPUs()[n])
+}
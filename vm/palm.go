@@ -0,0 +1,333 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+// Layout of a Palm OS application in memory.
+const (
+	// PalmCodeBase is where the code resources are loaded.
+	PalmCodeBase = 0x10000
+	// PalmStackSize is the room left for the stack at the top of memory.
+	PalmStackSize = 0x4000
+)
+
+// Values the stubs pass to and return from the application.
+const (
+	palmTrap          = 15   // The TRAP that calls the system
+	palmLaunchFlags   = 0x0C // sysAppLaunchFlagNewGlobals | sysAppLaunchFlagUIApp
+	palmAppInfoSize   = 0x40 // Room for a SysAppInfoType
+	palmAppStopEvent  = 22
+	palmTicksPerCycle = 80000 // 100 ticks a second at 8 MHz
+	palmExitWord      = 0     // Trap word after the TRAP #15 the startup code returns to
+)
+
+// Palm system traps the stubs answer.
+const (
+	palmMemPtrNew     = 0xA013
+	palmMemHandleNew  = 0xA01E
+	palmMemHandleLock = 0xA021
+	palmMemMove       = 0xA026
+	palmMemSet        = 0xA027
+	palmDmGetResource = 0xA05F
+	palmSysAppStartup = 0xA08F
+	palmTimGetTicks   = 0xA0F7
+	palmEvtGetEvent   = 0xA11D
+)
+
+// Palm runs a Palm OS application's code with stubs in place of the system,
+// enough to follow its startup and explore it in the monitor, not to show
+// its forms. Memory calls allocate from a heap that is never freed,
+// DmGetResource copies resources out of the database, and EvtGetEvent hands
+// the application an appStopEvent so its event loop ends. Every other
+// system call returns 0 in D0 and A0. The application is started as by a
+// normal launch, and the machine halts when its startup code returns.
+type Palm struct {
+	// Trace, if not nil, gets a line for each system call.
+	Trace io.Writer
+	// Calls counts the system calls made, by trap word.
+	Calls map[uint16]int
+
+	db      *disassembler.PalmDatabase
+	heap    uint32
+	limit   uint32
+	handles map[string]uint32 // Resources already loaded, by type and ID
+}
+
+// LoadPalm loads the code resources of db at PalmCodeBase and makes TRAP #15
+// call the Palm's stubs. The A5 world follows the code, and the heap the A5
+// world, up to the stack at the top of memory. The PC, A5 and A7 are set to
+// launch the application, whose resources are named in Symbols.
+func (v *VM) LoadPalm(db *disassembler.PalmDatabase) (*Palm, error) {
+	app, err := disassembler.LoadPalmCode(db)
+	if err != nil {
+		return nil, err
+	}
+	exit := PalmCodeBase + uint32(len(app.Image))
+	a5 := align4(exit+4) + align4(app.BelowA5)
+	heap := align4(a5 + app.AboveA5)
+	top := uint32(len(v.CPU.Mem)) &^ 3
+	if uint64(heap)+PalmStackSize > uint64(top) {
+		return nil, fmt.Errorf("%d bytes of memory is too small for %q", len(v.CPU.Mem), db.Name)
+	}
+	p := &Palm{
+		Calls:   make(map[uint16]int),
+		db:      db,
+		heap:    heap,
+		limit:   top - PalmStackSize,
+		handles: make(map[string]uint32),
+	}
+	v.LoadCode(PalmCodeBase, app.Image)
+	var stub [4]byte
+	binary.BigEndian.PutUint16(stub[:], 0x4E40|palmTrap)
+	binary.BigEndian.PutUint16(stub[2:], palmExitWord)
+	v.LoadCode(exit, stub[:])
+	clear(v.CPU.Mem[exit+4 : heap])
+
+	// PilotMain(cmd, cmdPBP, launchFlags), returning to the exit stub.
+	sp := top
+	mem := v.CPU.Memory()
+	for _, w := range []struct {
+		size int
+		v    uint32
+	}{{2, palmLaunchFlags}, {4, 0}, {2, 0}, {4, exit}} {
+		sp -= uint32(w.size)
+		if w.size == 2 {
+			err = mem.WriteU16(sp, uint16(w.v))
+		} else {
+			err = mem.WriteU32(sp, w.v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	v.CPU.A[7] = sp
+	v.CPU.A[5] = a5
+	v.CPU.PC = PalmCodeBase + app.Entry
+	v.CPU.HostTraps[palmTrap] = p.call
+
+	if v.Symbols == nil {
+		v.Symbols = make(disassembler.Symbols)
+	}
+	for addr, name := range app.Symbols() {
+		v.Symbols[PalmCodeBase+addr] = name
+	}
+	v.Symbols[exit] = "palm_exit"
+	return p, nil
+}
+
+// align4 rounds n up to a multiple of 4.
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+// call answers the system trap whose word follows the TRAP #15.
+func (p *Palm) call(c *cpu.CPU) error {
+	mem := c.WatchedMemory()
+	op, err := mem.ReadU16(c.PC)
+	if err != nil {
+		return err
+	}
+	if op == palmExitWord {
+		c.Running = false
+		return nil
+	}
+	if op&0xF000 != 0xA000 {
+		return fmt.Errorf("palm: $%04X after TRAP #15 at $%08X is not a system trap", op, c.PC-2)
+	}
+	c.PC += 2
+	p.Calls[op]++
+	name, ok := disassembler.PalmTrapName(op)
+	if !ok {
+		name = fmt.Sprintf("$%04X", op)
+	}
+	result, err := p.stub(c, mem, op)
+	if err != nil {
+		return fmt.Errorf("palm: %s: %w", name, err)
+	}
+	c.D[0], c.A[0] = result, result
+	if p.Trace != nil {
+		fmt.Fprintf(p.Trace, "$%08X %s = $%08X\n", c.PC-4, name, result)
+	}
+	return nil
+}
+
+// stub carries out system trap op, taking its arguments from the stack, and
+// returns its result.
+func (p *Palm) stub(c *cpu.CPU, mem cpu.Memory, op uint16) (uint32, error) {
+	sp := c.A[7]
+	long := func(off uint32) (uint32, error) { return mem.ReadU32(sp + off) }
+	word := func(off uint32) (uint16, error) { return mem.ReadU16(sp + off) }
+	switch op {
+	case palmMemPtrNew:
+		size, err := long(0)
+		if err != nil {
+			return 0, err
+		}
+		return p.alloc(mem, size)
+	case palmMemHandleNew:
+		size, err := long(0)
+		if err != nil {
+			return 0, err
+		}
+		return p.newHandle(mem, size)
+	case palmMemHandleLock:
+		h, err := long(0)
+		if err != nil || h == 0 {
+			return 0, err
+		}
+		return mem.ReadU32(h)
+	case palmMemMove:
+		dst, err := long(0)
+		if err != nil {
+			return 0, err
+		}
+		src, err := long(4)
+		if err != nil {
+			return 0, err
+		}
+		n, err := long(8)
+		if err != nil {
+			return 0, err
+		}
+		b, err := readBytes(c, src, int(max(int32(n), 0)))
+		if err != nil {
+			return 0, err
+		}
+		return 0, mem.WriteBytes(dst, b)
+	case palmMemSet:
+		dst, err := long(0)
+		if err != nil {
+			return 0, err
+		}
+		n, err := long(4)
+		if err != nil {
+			return 0, err
+		}
+		v, err := word(8)
+		if err != nil {
+			return 0, err
+		}
+		b := make([]byte, max(int32(n), 0))
+		for i := range b {
+			b[i] = uint8(v)
+		}
+		return 0, mem.WriteBytes(dst, b)
+	case palmDmGetResource:
+		typ, err := long(0)
+		if err != nil {
+			return 0, err
+		}
+		id, err := word(4)
+		if err != nil {
+			return 0, err
+		}
+		return p.resource(mem, typ, id)
+	case palmSysAppStartup:
+		return 0, p.startup(mem, sp)
+	case palmTimGetTicks:
+		return uint32(c.Cycles / palmTicksPerCycle), nil
+	case palmEvtGetEvent:
+		event, err := long(0)
+		if err != nil {
+			return 0, err
+		}
+		return 0, mem.WriteU16(event, palmAppStopEvent)
+	}
+	// The rest, such as MemHandleUnlock and DmReleaseResource, succeed
+	// without doing anything.
+	return 0, nil
+}
+
+// alloc returns n zeroed bytes from the heap.
+func (p *Palm) alloc(mem cpu.Memory, n uint32) (uint32, error) {
+	if uint64(p.heap)+uint64(n) > uint64(p.limit) {
+		return 0, fmt.Errorf("heap exhausted allocating %d bytes", n)
+	}
+	addr := p.heap
+	p.heap = align4(p.heap + n)
+	return addr, mem.WriteBytes(addr, make([]byte, n))
+}
+
+// newHandle allocates n bytes and a master pointer to them, returning the
+// master pointer's address as the handle.
+func (p *Palm) newHandle(mem cpu.Memory, n uint32) (uint32, error) {
+	block, err := p.alloc(mem, n)
+	if err != nil {
+		return 0, err
+	}
+	h, err := p.alloc(mem, 4)
+	if err != nil {
+		return 0, err
+	}
+	return h, mem.WriteU32(h, block)
+}
+
+// resource returns a handle to a copy of the resource with the type and ID,
+// loading it the first time, or 0 if the database has none.
+func (p *Palm) resource(mem cpu.Memory, typ uint32, id uint16) (uint32, error) {
+	var t [4]byte
+	binary.BigEndian.PutUint32(t[:], typ)
+	key := fmt.Sprintf("%s %d", t[:], id)
+	if h, ok := p.handles[key]; ok {
+		return h, nil
+	}
+	for _, r := range p.db.Resources {
+		if r.Type != string(t[:]) || uint16(r.ID) != id {
+			continue
+		}
+		h, err := p.newHandle(mem, uint32(len(r.Data)))
+		if err != nil {
+			return 0, err
+		}
+		block, err := mem.ReadU32(h)
+		if err != nil {
+			return 0, err
+		}
+		if err := mem.WriteBytes(block, r.Data); err != nil {
+			return 0, err
+		}
+		p.handles[key] = h
+		return h, nil
+	}
+	return 0, nil
+}
+
+// startup answers SysAppStartup(appInfoPP, prevGlobalsPP, globalsPtrPP) with
+// the launch's details.
+func (p *Palm) startup(mem cpu.Memory, sp uint32) error {
+	info, err := p.alloc(mem, palmAppInfoSize)
+	if err != nil {
+		return err
+	}
+	// SysAppInfoType starts with cmd, cmdPBP and launchFlags.
+	if err := mem.WriteU16(info+6, palmLaunchFlags); err != nil {
+		return err
+	}
+	ptrs := make([]uint32, 3)
+	for i := range ptrs {
+		if ptrs[i], err = mem.ReadU32(sp + uint32(i)*4); err != nil {
+			return err
+		}
+	}
+	if ptrs[0] == 0 {
+		return errors.New("no place for the SysAppInfoType pointer")
+	}
+	if err := mem.WriteU32(ptrs[0], info); err != nil {
+		return err
+	}
+	for _, ptr := range ptrs[1:] {
+		if ptr != 0 {
+			if err := mem.WriteU32(ptr, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
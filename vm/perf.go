@@ -0,0 +1,51 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Layout of the performance counter block, as offsets from its base address.
+// All registers are big-endian longs. The low halves are enough to time short
+// routines; read the high halves too for runs longer than 2^32 cycles.
+const (
+	PerfCyclesLo       = 0x0 // Elapsed cycles, bits 31-0
+	PerfInstructionsLo = 0x4 // Executed instructions, bits 31-0
+	PerfCyclesHi       = 0x8 // Elapsed cycles, bits 63-32
+	PerfInstructionsHi = 0xC // Executed instructions, bits 63-32
+	// PerfSize is the size of the counter block in bytes.
+	PerfSize = 0x10
+)
+
+// EnablePerfCounters maps the performance counter block at base, so guest code
+// can read elapsed cycles and instruction counts. The block is refreshed by Step
+// before every instruction; writes to it are overwritten. It must lie in RAM,
+// clear of any ROM, device or unmapped region.
+func (v *VM) EnablePerfCounters(base uint32) error {
+	if base%2 != 0 {
+		return fmt.Errorf("performance counters must be word-aligned, got $%08X", base)
+	}
+	if err := v.checkRAM(base, PerfSize, "performance counter block"); err != nil {
+		return err
+	}
+	v.perfBase = base
+	v.perfEnabled = true
+	v.updatePerfCounters()
+	return nil
+}
+
+// DisablePerfCounters stops refreshing the counter block. Its memory is left as is.
+func (v *VM) DisablePerfCounters() {
+	v.perfEnabled = false
+}
+
+// updatePerfCounters copies the CPU counters into guest memory. It writes the
+// RAM directly, as a bus access outside Execute would panic on a fault.
+func (v *VM) updatePerfCounters() {
+	c := v.CPU
+	block := c.Mem[v.perfBase : v.perfBase+PerfSize]
+	binary.BigEndian.PutUint32(block[PerfCyclesLo:], uint32(c.Cycles))
+	binary.BigEndian.PutUint32(block[PerfInstructionsLo:], uint32(c.Instructions))
+	binary.BigEndian.PutUint32(block[PerfCyclesHi:], uint32(c.Cycles>>32))
+	binary.BigEndian.PutUint32(block[PerfInstructionsHi:], uint32(c.Instructions>>32))
+}
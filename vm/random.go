@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Layout of the random number device, as offsets from its base address. Both
+// registers are big-endian longs.
+const (
+	RandomValue = 0x0 // The next random number, replaced before every instruction
+	RandomSeed  = 0x4 // Write a new seed here to restart the sequence
+	// RandomSize is the size of the device block in bytes.
+	RandomSize = 0x8
+)
+
+// EnableRandom maps a pseudo-random number generator at base. The sequence
+// depends only on the seed and on how many instructions have run, so a program
+// sees the same numbers every time it runs with the same seed. Guest code can
+// restart the sequence by writing a seed to RandomSeed. The device must lie in
+// RAM, clear of any ROM, device or unmapped region.
+func (v *VM) EnableRandom(base, seed uint32) error {
+	if base%2 != 0 {
+		return fmt.Errorf("random number device must be word-aligned, got $%08X", base)
+	}
+	if err := v.checkRAM(base, RandomSize, "random number device"); err != nil {
+		return err
+	}
+	v.randomBase = base
+	v.randomEnabled = true
+	v.SetRandomSeed(seed)
+	return nil
+}
+
+// DisableRandom unmaps the random number device. Its memory is left as is.
+func (v *VM) DisableRandom() {
+	v.randomEnabled = false
+}
+
+// SetRandomSeed restarts the random sequence from seed. It has no effect until
+// the device is mapped with EnableRandom.
+func (v *VM) SetRandomSeed(seed uint32) {
+	if !v.randomEnabled {
+		return
+	}
+	v.randomSeed = seed
+	v.randomState = seed
+	if v.randomState == 0 {
+		// Xorshift never leaves zero, so start from a fixed non-zero state.
+		v.randomState = 0x9E3779B9
+	}
+	regs := v.randomRegs()
+	binary.BigEndian.PutUint32(regs[RandomSeed:], seed)
+	binary.BigEndian.PutUint32(regs[RandomValue:], v.nextRandom())
+}
+
+// updateRandom reseeds the generator if the guest wrote a new seed, and
+// replaces the value register with the next number.
+func (v *VM) updateRandom() {
+	regs := v.randomRegs()
+	if seed := binary.BigEndian.Uint32(regs[RandomSeed:]); seed != v.randomSeed {
+		v.SetRandomSeed(seed)
+		return
+	}
+	binary.BigEndian.PutUint32(regs[RandomValue:], v.nextRandom())
+}
+
+// randomRegs returns the device's registers in RAM. They are used directly,
+// as a bus access outside Execute would panic on a fault.
+func (v *VM) randomRegs() []byte {
+	return v.CPU.Mem[v.randomBase : v.randomBase+RandomSize]
+}
+
+// nextRandom advances the xorshift32 generator.
+func (v *VM) nextRandom() uint32 {
+	x := v.randomState
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	v.randomState = x
+	return x
+}
@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Layout of the real-time clock, as offsets from its base address. All
+// registers are read-only.
+const (
+	RTCYear     = 0x0  // Word: the year, such as 2026
+	RTCMonth    = 0x2  // Byte: 1-12
+	RTCDay      = 0x3  // Byte: 1-31
+	RTCHour     = 0x4  // Byte: 0-23
+	RTCMinute   = 0x5  // Byte: 0-59
+	RTCSecond   = 0x6  // Byte: 0-59
+	RTCWeekday  = 0x7  // Byte: 0-6, from Sunday
+	RTCUptimeHi = 0x8  // Long: clock cycles since the CPU started, bits 63-32
+	RTCUptimeLo = 0xC  // Long: clock cycles since the CPU started, bits 31-0
+	RTCSeconds  = 0x10 // Long: seconds since the start of 1970, UTC
+	// RTCSize is the size of the device block in bytes.
+	RTCSize = 0x14
+)
+
+// rtc is a real-time clock, attached to the memory map as a Device.
+type rtc struct {
+	base uint32
+	c    *cpu.CPU
+	now  func() time.Time
+	regs [RTCSize]byte
+	// taken is the instruction count when regs were filled, so every byte an
+	// instruction reads comes from the same moment.
+	taken uint64
+	valid bool
+}
+
+// EnableRTC maps a real-time clock at base. It shows the date and time now
+// returns, or the host's local time if now is nil, along with the CPU's cycle
+// count. The registers are filled afresh for each instruction that reads
+// them, so a single MOVEM.L of the whole block sees one consistent moment.
+// Tests and reproducible runs pass a now that derives the time from
+// CPU.Cycles.
+func (v *VM) EnableRTC(base uint32, now func() time.Time) error {
+	if v.rtc != nil {
+		return fmt.Errorf("a real-time clock is already mapped at $%08X", v.rtc.base)
+	}
+	if now == nil {
+		now = time.Now
+	}
+	r := &rtc{base: base, c: v.CPU, now: now}
+	if err := v.MemoryMap().MapDevice(base, RTCSize, "rtc", r); err != nil {
+		return err
+	}
+	v.rtc = r
+	return nil
+}
+
+// Read8 reads a register.
+func (r *rtc) Read8(offset uint32) (uint8, error) {
+	if !r.valid || r.taken != r.c.Instructions {
+		r.fill()
+	}
+	return r.regs[offset], nil
+}
+
+// Write8 ignores writes; the clock can't be set by the guest.
+func (r *rtc) Write8(offset uint32, v uint8) error {
+	return nil
+}
+
+// fill reads the clock into the registers.
+func (r *rtc) fill() {
+	t := r.now()
+	binary.BigEndian.PutUint16(r.regs[RTCYear:], uint16(t.Year()))
+	r.regs[RTCMonth] = uint8(t.Month())
+	r.regs[RTCDay] = uint8(t.Day())
+	r.regs[RTCHour] = uint8(t.Hour())
+	r.regs[RTCMinute] = uint8(t.Minute())
+	r.regs[RTCSecond] = uint8(t.Second())
+	r.regs[RTCWeekday] = uint8(t.Weekday())
+	binary.BigEndian.PutUint64(r.regs[RTCUptimeHi:], r.c.Cycles)
+	binary.BigEndian.PutUint32(r.regs[RTCSeconds:], uint32(t.Unix()))
+	r.taken, r.valid = r.c.Instructions, true
+}
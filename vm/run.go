@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"context"
+	"time"
+)
+
+// RunCheckInterval is how many instructions Run executes between checks of
+// its context and, when throttling, of the wall clock.
+const RunCheckInterval = 1024
+
+// RunOptions controls Run.
+type RunOptions struct {
+	// MaxCycles stops the run once the busiest CPU has run this many clock
+	// cycles since Run was called. Zero means no limit.
+	MaxCycles uint64
+	// ClockRate throttles execution to this many clock cycles per second,
+	// e.g. 8000000 for an 8 MHz 68000. Zero runs as fast as the host can.
+	ClockRate uint64
+}
+
+// RunReason says why Run returned.
+type RunReason string
+
+// Reasons reported by Run.
+const (
+	// RunHalted means every CPU stopped running.
+	RunHalted RunReason = "halted"
+	// RunStopped means the program executed STOP and nothing could
+	// interrupt it.
+	RunStopped RunReason = "stopped"
+	// RunBreakpoint means the first CPU reached one of its breakpoints. The
+	// PC is on the breakpoint, which has not run.
+	RunBreakpoint RunReason = "breakpoint"
+	// RunCycleLimit means RunOptions.MaxCycles was reached.
+	RunCycleLimit RunReason = "cycle_limit"
+	// RunCancelled means the context was cancelled.
+	RunCancelled RunReason = "cancelled"
+	// RunFault means an instruction failed; Run returns its error.
+	RunFault RunReason = "fault"
+)
+
+// Run executes from the current PC until the program halts or stops, the first
+// CPU reaches a breakpoint, the cycle limit in opts is reached or ctx is
+// cancelled, and says which. A breakpoint at the PC Run starts from is
+// stepped over, so that a run can be resumed from one. The context and the
+// throttle are checked every RunCheckInterval instructions, and cancelling
+// ctx also ends a wait for input from the UART or keyboard. The only error is that of a failed instruction, with RunFault.
+func (v *VM) Run(ctx context.Context, opts RunOptions) (RunReason, error) {
+	v.runCtx = ctx
+	stopWake := context.AfterFunc(ctx, v.wakeWaits)
+	defer func() {
+		stopWake()
+		v.runCtx = nil
+	}()
+
+	start := time.Now()
+	startCycles := v.elapsed()
+	v.CPU.Running = true
+	for n := uint64(0); ; n++ {
+		if !v.Running() {
+			return RunHalted, nil
+		}
+		if v.Idle() {
+			return RunStopped, nil
+		}
+		if n > 0 && v.CPU.IsBreakpoint(v.CPU.PC) {
+			return RunBreakpoint, nil
+		}
+		ran := v.elapsed() - startCycles
+		if opts.MaxCycles > 0 && ran >= opts.MaxCycles {
+			return RunCycleLimit, nil
+		}
+		if n%RunCheckInterval == 0 || v.CPU.Stopped {
+			// A stopped CPU may have been woken from a device wait by ctx.
+			if ctx.Err() != nil {
+				return RunCancelled, nil
+			}
+			if opts.ClockRate > 0 && !throttle(ctx, start, ran, opts.ClockRate) {
+				return RunCancelled, nil
+			}
+		}
+		if err := v.Step(); err != nil {
+			return RunFault, err
+		}
+	}
+}
+
+// waitContext returns the context that ends device waits: that of Run, or
+// one that is never done when Step is called on its own.
+func (v *VM) waitContext() context.Context {
+	if v.runCtx == nil {
+		return context.Background()
+	}
+	return v.runCtx
+}
+
+// wakeWaits makes any device wait check the context again.
+func (v *VM) wakeWaits() {
+	if v.uart != nil {
+		v.uart.wake()
+	}
+	if v.keyboard != nil {
+		v.keyboard.wake()
+	}
+}
+
+// elapsed returns the clock cycles the busiest CPU has run.
+func (v *VM) elapsed() uint64 {
+	var most uint64
+	for _, c := range v.CPUs() {
+		most = max(most, c.Cycles)
+	}
+	return most
+}
+
+// throttle sleeps until the wall clock since start catches up with cycles at
+// rate cycles per second. It returns false if ctx is cancelled meanwhile.
+func throttle(ctx context.Context, start time.Time, cycles, rate uint64) bool {
+	due := time.Duration(float64(cycles) / float64(rate) * float64(time.Second))
+	wait := due - time.Since(start)
+	if wait <= 0 {
+		return true
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
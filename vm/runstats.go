@@ -0,0 +1,146 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// stackSwitch is how far a stack pointer must drop in one instruction to be
+// taken as a switch to another stack rather than a push.
+const stackSwitch = 0x100000
+
+// RunStats summarises what a program did while statistics were enabled.
+type RunStats struct {
+	Instructions uint64 `json:"instructions"`
+	Cycles       uint64 `json:"cycles"`
+	Exceptions   uint64 `json:"exceptions"`
+	// SupervisorStack and UserStack are the most bytes each stack held below
+	// its top. A stack pointer that rises, or drops by 1 MiB or more at
+	// once, starts a new stack.
+	SupervisorStack uint32 `json:"supervisor_stack"`
+	UserStack       uint32 `json:"user_stack"`
+	// HighWater is the highest address written, valid if Written is set.
+	HighWater uint32 `json:"high_water"`
+	Written   bool   `json:"written"`
+}
+
+// String lists the statistics, one per line.
+func (s RunStats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Instructions:      %d\n", s.Instructions)
+	fmt.Fprintf(&sb, "Cycles:            %d\n", s.Cycles)
+	fmt.Fprintf(&sb, "Exceptions:        %d\n", s.Exceptions)
+	fmt.Fprintf(&sb, "Peak stack:        %d bytes supervisor, %d bytes user\n", s.SupervisorStack, s.UserStack)
+	if s.Written {
+		fmt.Fprintf(&sb, "Memory high-water: $%08X\n", s.HighWater)
+	} else {
+		sb.WriteString("Memory high-water: nothing written\n")
+	}
+	return sb.String()
+}
+
+// runStats tracks a run for RunStats.
+type runStats struct {
+	start   RunStats
+	current RunStats
+	usp     stackDepth
+	ssp     stackDepth
+}
+
+// stackDepth follows one stack pointer.
+type stackDepth struct {
+	top  uint32
+	peak uint32
+}
+
+// sample accounts for the stack pointer being at sp.
+func (d *stackDepth) sample(sp uint32) {
+	if sp > d.top || d.top-sp >= stackSwitch {
+		d.top = sp
+	}
+	d.peak = max(d.peak, d.top-sp)
+}
+
+// statsBus records the highest address written through it.
+type statsBus struct {
+	cpu.Bus
+	s *runStats
+}
+
+// wrote records a write of n bytes at addr.
+func (b statsBus) wrote(addr, n uint32) {
+	last := addr + n - 1
+	if !b.s.current.Written || last > b.s.current.HighWater {
+		b.s.current.HighWater, b.s.current.Written = last, true
+	}
+}
+
+// Write8 writes a byte.
+func (b statsBus) Write8(addr uint32, v uint8) error {
+	err := b.Bus.Write8(addr, v)
+	if err == nil {
+		b.wrote(addr, 1)
+	}
+	return err
+}
+
+// Write16 writes a word.
+func (b statsBus) Write16(addr uint32, v uint16) error {
+	err := b.Bus.Write16(addr, v)
+	if err == nil {
+		b.wrote(addr, 2)
+	}
+	return err
+}
+
+// Write32 writes a long.
+func (b statsBus) Write32(addr uint32, v uint32) error {
+	err := b.Bus.Write32(addr, v)
+	if err == nil {
+		b.wrote(addr, 4)
+	}
+	return err
+}
+
+// EnableRunStats starts collecting RunStats from this point. Step samples the
+// stack pointers after every instruction, and writes are watched by wrapping
+// the CPU's bus, so enable it after installing any other bus or memory map.
+// Enabling again starts over.
+func (v *VM) EnableRunStats() {
+	c := v.CPU
+	if v.runStats != nil {
+		if b, ok := c.Bus.(statsBus); ok {
+			c.Bus = b.Bus
+		}
+	}
+	s := &runStats{start: RunStats{Instructions: c.Instructions, Cycles: c.Cycles, Exceptions: c.Exceptions}}
+	usp, ssp := c.StackPointers()
+	s.usp.top, s.ssp.top = usp, ssp
+	v.runStats = s
+	c.Bus = statsBus{Bus: c.Bus, s: s}
+}
+
+// RunStats returns the statistics collected since EnableRunStats, or only the
+// counters since the VM was created if it wasn't called.
+func (v *VM) RunStats() RunStats {
+	c := v.CPU
+	s := v.runStats
+	if s == nil {
+		return RunStats{Instructions: c.Instructions, Cycles: c.Cycles, Exceptions: c.Exceptions}
+	}
+	r := s.current
+	r.Instructions = c.Instructions - s.start.Instructions
+	r.Cycles = c.Cycles - s.start.Cycles
+	r.Exceptions = c.Exceptions - s.start.Exceptions
+	r.SupervisorStack, r.UserStack = s.ssp.peak, s.usp.peak
+	return r
+}
+
+// sampleStacks updates the stack depths.
+func (v *VM) sampleStacks() {
+	usp, ssp := v.CPU.StackPointers()
+	v.runStats.usp.sample(usp)
+	v.runStats.ssp.sample(ssp)
+}
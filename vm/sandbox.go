@@ -0,0 +1,225 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Sandbox limits are checked at these granularities.
+const (
+	// SandboxPageSize is the unit in which written memory is counted.
+	SandboxPageSize = 0x1000
+	// SandboxCheckInterval is how many instructions run between checks of the
+	// memory quota, the timeout and the instruction rate.
+	SandboxCheckInterval = 4096
+	// DefaultSandboxInstructions is used when SandboxConfig.MaxInstructions is 0.
+	DefaultSandboxInstructions = 10000000
+)
+
+// SandboxConfig limits what untrusted code may do in RunSandboxed.
+type SandboxConfig struct {
+	// MaxInstructions stops the program after this many instructions. Zero
+	// means DefaultSandboxInstructions; there is no unlimited setting.
+	MaxInstructions uint64
+	// MaxCycles stops the program once this many clock cycles have passed.
+	// Zero means no limit beyond MaxInstructions.
+	MaxCycles uint64
+	// MaxMemory stops the program once it has changed more than this many
+	// bytes of memory from their initial contents, counted in whole pages.
+	// Zero means no quota.
+	MaxMemory int
+	// Timeout stops the program after this much wall-clock time. Zero means none.
+	Timeout time.Duration
+	// InstructionRate throttles execution to at most this many instructions
+	// per second. Zero runs at full speed.
+	InstructionRate int
+	// AllowTraps lets TRAP vectors other than #15 (exit) reach the host.
+	AllowTraps bool
+}
+
+// SandboxReason says why a sandboxed program stopped.
+type SandboxReason string
+
+// Reasons reported by RunSandboxed.
+const (
+	SandboxHalted           SandboxReason = "halted"
+	SandboxInstructionLimit SandboxReason = "instruction_limit"
+	SandboxCycleLimit       SandboxReason = "cycle_limit"
+	SandboxMemoryLimit      SandboxReason = "memory_limit"
+	SandboxTimeout          SandboxReason = "timeout"
+	SandboxTrap             SandboxReason = "trap"
+	SandboxFault            SandboxReason = "fault"
+	// SandboxStopped means the program executed STOP and nothing could
+	// interrupt it.
+	SandboxStopped SandboxReason = "stopped"
+	// SandboxRefused means the VM gives the guest a way out to the host, such
+	// as a host trap handler or a device reading stdin or a file, so the
+	// program was not run.
+	SandboxRefused SandboxReason = "refused"
+)
+
+// SandboxReport describes a sandboxed run.
+type SandboxReport struct {
+	Reason SandboxReason `json:"reason"`
+	// Error explains a fault or a blocked trap.
+	Error        string `json:"error,omitempty"`
+	Instructions uint64 `json:"instructions"`
+	Cycles       uint64 `json:"cycles"`
+	// MemoryWritten is the size of the pages written, in bytes. It is only
+	// counted when SandboxConfig.MaxMemory is set.
+	MemoryWritten int           `json:"memory_written"`
+	Elapsed       time.Duration `json:"elapsed_ns"`
+	State         State         `json:"state"`
+}
+
+// RunSandboxed runs the loaded program from the current PC under the limits in
+// cfg and reports how it ended. It never returns an error: faults, including
+// accesses that would crash the emulator, end the run with SandboxFault.
+// Instruction and trap limits are exact; the others are checked every
+// SandboxCheckInterval instructions. A VM set up to reach the host, with host
+// trap or line trap handlers, an unimplemented instruction callback or a
+// mapped device other than the timer and clock, is refused.
+func (v *VM) RunSandboxed(cfg SandboxConfig) (r SandboxReport) {
+	c := v.CPU
+	limit := cfg.MaxInstructions
+	if limit == 0 {
+		limit = DefaultSandboxInstructions
+	}
+
+	var original []byte
+	var written []bool
+	if cfg.MaxMemory > 0 {
+		original = bytes.Clone(c.Mem)
+		written = make([]bool, (len(c.Mem)+SandboxPageSize-1)/SandboxPageSize)
+	}
+
+	start := time.Now()
+	startInstructions, startCycles := c.Instructions, c.Cycles
+	defer func() {
+		if p := recover(); p != nil {
+			r.Reason = SandboxFault
+			r.Error = fmt.Sprintf("emulator fault at $%08X: %v", c.PC, p)
+		}
+		c.Running = false
+		r.Instructions = c.Instructions - startInstructions
+		r.Cycles = c.Cycles - startCycles
+		r.MemoryWritten = countWritten(c.Mem, original, written) * SandboxPageSize
+		r.Elapsed = time.Since(start)
+		r.State = v.State()
+		if r.Reason == SandboxHalted && cfg.MaxMemory > 0 && r.MemoryWritten > cfg.MaxMemory {
+			r.Reason = SandboxMemoryLimit
+		}
+	}()
+
+	if err := v.hostAccess(); err != nil {
+		r.Reason = SandboxRefused
+		r.Error = err.Error()
+		return r
+	}
+
+	c.Running = true
+	for n := uint64(0); ; n++ {
+		if !c.Running {
+			r.Reason = SandboxHalted
+			return r
+		}
+		if v.Idle() {
+			r.Reason = SandboxStopped
+			return r
+		}
+		if n == limit {
+			r.Reason = SandboxInstructionLimit
+			return r
+		}
+		if cfg.MaxCycles > 0 && c.Cycles-startCycles >= cfg.MaxCycles {
+			r.Reason = SandboxCycleLimit
+			return r
+		}
+		if !cfg.AllowTraps {
+			// An opcode that can't be read faults when the instruction runs.
+			if op, err := c.Memory().ReadU16(c.PC); err == nil && op&0xFFF0 == cpu.OPTRAP && op&0xF != 15 {
+				r.Reason = SandboxTrap
+				r.Error = fmt.Sprintf("TRAP #%d at $%08X is not allowed", op&0xF, c.PC)
+				return r
+			}
+		}
+		if err := v.Step(); err != nil {
+			r.Reason = SandboxFault
+			r.Error = err.Error()
+			return r
+		}
+
+		if n%SandboxCheckInterval != SandboxCheckInterval-1 {
+			continue
+		}
+		if cfg.MaxMemory > 0 && countWritten(c.Mem, original, written)*SandboxPageSize > cfg.MaxMemory {
+			r.Reason = SandboxMemoryLimit
+			return r
+		}
+		elapsed := time.Since(start)
+		if cfg.Timeout > 0 && elapsed >= cfg.Timeout {
+			r.Reason = SandboxTimeout
+			return r
+		}
+		if cfg.InstructionRate > 0 {
+			due := time.Duration(float64(n+1) / float64(cfg.InstructionRate) * float64(time.Second))
+			if due > elapsed {
+				time.Sleep(due - elapsed)
+			}
+		}
+	}
+}
+
+// hostAccess returns an error naming the first way sandboxed code could reach
+// the host. The timer and real-time clock are allowed, as they only show the
+// guest the time; any other device, including those mapped by the embedding
+// program, may be backed by the host.
+func (v *VM) hostAccess() error {
+	for i, c := range v.CPUs() {
+		for n, fn := range c.HostTraps {
+			if fn != nil {
+				return fmt.Errorf("TRAP #%d on CPU %d has a host handler", n, i)
+			}
+		}
+		if len(c.LineTraps) > 0 {
+			return fmt.Errorf("CPU %d has line trap handlers", i)
+		}
+		if c.Unimplemented == cpu.UnimplementedCallback && c.OnUnimplemented != nil {
+			return fmt.Errorf("CPU %d calls the host for unimplemented instructions", i)
+		}
+	}
+	if v.memMap == nil {
+		return nil
+	}
+	for _, r := range v.memMap.regions {
+		if r.Kind != RegionDevice {
+			continue
+		}
+		switch v.memMap.devices[r.Start].(type) {
+		case *timer, *rtc:
+			continue
+		}
+		return fmt.Errorf("device %q at $%08X may reach the host", r.Name, r.Start)
+	}
+	return nil
+}
+
+// countWritten marks the pages of mem that differ from original and returns how
+// many have been written so far. Pages stay marked even if they are restored.
+func countWritten(mem, original []byte, written []bool) int {
+	n := 0
+	for i := range written {
+		if !written[i] {
+			lo := i * SandboxPageSize
+			hi := min(lo+SandboxPageSize, len(mem))
+			written[i] = !bytes.Equal(mem[lo:hi], original[lo:hi])
+		}
+		if written[i] {
+			n++
+		}
+	}
+	return n
+}
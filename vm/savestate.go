@@ -0,0 +1,501 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// saveStateMagic starts a save state, followed by the format version as a
+// big-endian word. LoadState refuses versions it doesn't know.
+const (
+	saveStateMagic   = "M68STATE"
+	saveStateVersion = 1
+)
+
+// saveStatePage is the unit memory is saved in. Pages that are all zero take
+// a single byte.
+const saveStatePage = 0x1000
+
+// savedCPU is the fixed part of a save state. Every field is written
+// big-endian in declaration order.
+type savedCPU struct {
+	D, A                         [8]uint32
+	PC, USP, SSP, ISP, VBR, Mask uint32
+	SR                           uint16
+	Cycles, Instructions         uint64
+	Exceptions                   uint64
+	Running, Stopped, Strict     bool
+	IRQ                          cpu.InterruptLines
+	Model                        uint32
+	SFC, DFC                     uint32
+	FPU                          savedFPU
+}
+
+// savedFPU holds the registers of a CPU's FPU, if it has one.
+type savedFPU struct {
+	Enabled           bool
+	FP                [8]cpu.Extended
+	FPCR, FPSR, FPIAR uint32
+	Used              bool
+}
+
+// savedDevices holds the state of the memory-mapped devices. Their registers
+// live in memory and are saved with it.
+type savedDevices struct {
+	PerfEnabled   bool
+	PerfBase      uint32
+	RandomEnabled bool
+	RandomBase    uint32
+	RandomSeed    uint32
+	RandomState   uint32
+}
+
+// savedUART holds the UART's registers. Whether the input
+// has ended belongs to the stream, so it isn't saved.
+type savedUART struct {
+	Enabled bool
+	Base    uint32
+	Data    uint8
+	Control uint8
+	Ready   bool
+}
+
+// savedTimer holds the timer's registers and count.
+type savedTimer struct {
+	Enabled    bool
+	Base       uint32
+	Regs       [TimerSize]byte
+	Left, Last uint64
+	Line       uint8
+	Expired    uint64
+}
+
+// savedBlock holds the block device's registers. The image
+// belongs to the host and isn't saved.
+type savedBlock struct {
+	Enabled bool
+	Base    uint32
+	Regs    [BlockSize]byte
+}
+
+// savedKeyboard holds the keyboard's queue and registers.
+// Whether the host input has ended belongs to the stream, so it isn't saved.
+type savedKeyboard struct {
+	Enabled  bool
+	Base     uint32
+	Queue    [KeyboardQueueSize]KeyEvent
+	Head     uint8
+	Count    uint8
+	Overflow bool
+	Control  uint8
+}
+
+// savedAudio holds the audio device's registers and playback position. The
+// queued samples follow it as a count and the samples, oldest first.
+type savedAudio struct {
+	Enabled    bool
+	Base       uint32
+	Regs       [AudioSize]byte
+	High       uint8
+	Last, Frac uint64
+	Line       bool
+}
+
+// savedRTC records the real-time clock. The time comes from
+// the host, so only where the clock is mapped is saved.
+type savedRTC struct {
+	Enabled bool
+	Base    uint32
+}
+
+// SaveState writes the whole machine to w: each CPU's model, registers,
+// counters and FPU, any asserted interrupts, memory, the memory map and the devices. LoadState
+// restores it, so a long run can be paused and resumed, or earlier states
+// kept to step back to. Breakpoints, watchpoints, hooks and logs belong to
+// the session rather than the machine and are not saved, nor are the
+// streams the UART and keyboard are attached to, the block device's
+// image, the audio played so far, or other devices mapped with MapDevice.
+func (v *VM) SaveState(w io.Writer) error {
+	c := v.CPU
+	bw := bufio.NewWriter(w)
+	bw.WriteString(saveStateMagic)
+	binary.Write(bw, binary.BigEndian, uint16(saveStateVersion))
+	binary.Write(bw, binary.BigEndian, saveCPU(c))
+
+	binary.Write(bw, binary.BigEndian, uint32(len(c.Mem)))
+	for start := 0; start < len(c.Mem); start += saveStatePage {
+		page := c.Mem[start:min(start+saveStatePage, len(c.Mem))]
+		if isZero(page) {
+			bw.WriteByte(0)
+			continue
+		}
+		bw.WriteByte(1)
+		bw.Write(page)
+	}
+
+	var regions []Region
+	if v.memMap != nil {
+		for _, r := range v.memMap.regions {
+			if r.Kind != RegionDevice {
+				regions = append(regions, r)
+			}
+		}
+	}
+	binary.Write(bw, binary.BigEndian, v.memMap != nil)
+	binary.Write(bw, binary.BigEndian, uint32(len(regions)))
+	for _, r := range regions {
+		binary.Write(bw, binary.BigEndian, []uint32{r.Start, r.Size})
+		bw.WriteByte(byte(r.Kind))
+		binary.Write(bw, binary.BigEndian, uint16(len(r.Name)))
+		bw.WriteString(r.Name)
+	}
+	if v.memMap != nil {
+		binary.Write(bw, binary.BigEndian, v.memMap.IgnoreROMWrites)
+	}
+
+	d := savedDevices{
+		PerfEnabled: v.perfEnabled, PerfBase: v.perfBase,
+		RandomEnabled: v.randomEnabled, RandomBase: v.randomBase,
+		RandomSeed: v.randomSeed, RandomState: v.randomState,
+	}
+	binary.Write(bw, binary.BigEndian, d)
+
+	var su savedUART
+	if u := v.uart; u != nil {
+		u.mu.Lock()
+		su = savedUART{Enabled: true, Base: u.base, Data: u.data, Control: u.control, Ready: u.ready}
+		u.mu.Unlock()
+	}
+	binary.Write(bw, binary.BigEndian, su)
+
+	var st savedTimer
+	if t := v.timer; t != nil {
+		st = savedTimer{Enabled: true, Base: t.base, Regs: t.regs, Left: t.left, Last: t.last, Line: uint8(t.line), Expired: t.expired}
+	}
+	binary.Write(bw, binary.BigEndian, st)
+
+	var sb savedBlock
+	if b := v.block; b != nil {
+		sb = savedBlock{Enabled: true, Base: b.base, Regs: b.regs}
+	}
+	binary.Write(bw, binary.BigEndian, sb)
+
+	var sk savedKeyboard
+	if k := v.keyboard; k != nil {
+		k.mu.Lock()
+		sk = savedKeyboard{Enabled: true, Base: k.base, Queue: k.queue, Head: uint8(k.head), Count: uint8(k.count), Overflow: k.overflow, Control: k.control}
+		k.mu.Unlock()
+	}
+	binary.Write(bw, binary.BigEndian, sk)
+
+	var sa savedAudio
+	var queued []int16
+	if a := v.audio; a != nil {
+		sa = savedAudio{Enabled: true, Base: a.cfg.Base, Regs: a.regs, High: a.high, Last: a.last, Frac: a.frac, Line: a.line}
+		for i := range a.n {
+			queued = append(queued, a.ring[(a.head+i)%len(a.ring)])
+		}
+	}
+	binary.Write(bw, binary.BigEndian, sa)
+	binary.Write(bw, binary.BigEndian, uint16(len(queued)))
+	binary.Write(bw, binary.BigEndian, queued)
+
+	var sr savedRTC
+	if v.rtc != nil {
+		sr = savedRTC{Enabled: true, Base: v.rtc.base}
+	}
+	binary.Write(bw, binary.BigEndian, sr)
+
+	binary.Write(bw, binary.BigEndian, uint8(len(v.cores)))
+	for _, core := range v.cores {
+		binary.Write(bw, binary.BigEndian, saveCPU(core))
+	}
+	// bufio.Writer keeps the first error, so it surfaces here.
+	return bw.Flush()
+}
+
+// LoadState restores a machine saved by SaveState. The VM must have as much
+// memory as the saved one, and a UART, timer, block device, keyboard,
+// audio device and real-time clock attached if the saved one had, at the
+// same addresses, and as many CPUs, with an FPU wherever the saved one had
+// one. Devices mapped into the VM stay mapped. Nothing changes if the state
+// can't be read.
+func (v *VM) LoadState(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var head struct {
+		Magic   [len(saveStateMagic)]byte
+		Version uint16
+	}
+	if err := binary.Read(br, binary.BigEndian, &head); err != nil || string(head.Magic[:]) != saveStateMagic {
+		return errors.New("not a save state")
+	}
+	if head.Version != saveStateVersion {
+		return fmt.Errorf("unsupported save state version %d", head.Version)
+	}
+	truncated := func(err error) error {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("truncated save state: %w", err)
+	}
+
+	var sc savedCPU
+	if err := binary.Read(br, binary.BigEndian, &sc); err != nil {
+		return truncated(err)
+	}
+
+	var size uint32
+	if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+		return truncated(err)
+	}
+	if int(size) != len(v.CPU.Mem) {
+		return fmt.Errorf("save state has %d bytes of memory, the VM has %d", size, len(v.CPU.Mem))
+	}
+	mem := make([]byte, size)
+	for start := 0; start < len(mem); start += saveStatePage {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return truncated(err)
+		}
+		switch kind {
+		case 0:
+		case 1:
+			if _, err := io.ReadFull(br, mem[start:min(start+saveStatePage, len(mem))]); err != nil {
+				return truncated(err)
+			}
+		default:
+			return fmt.Errorf("invalid memory page at $%08X in save state", start)
+		}
+	}
+
+	var mapped bool
+	var count uint32
+	if err := binary.Read(br, binary.BigEndian, &mapped); err != nil {
+		return truncated(err)
+	}
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return truncated(err)
+	}
+	var mm *MemoryMap
+	if mapped {
+		mm = NewMemoryMap(v.CPU.Mem)
+	}
+	for range count {
+		var fixed struct {
+			Start, Size uint32
+			Kind        uint8
+			NameLen     uint16
+		}
+		if err := binary.Read(br, binary.BigEndian, &fixed); err != nil {
+			return truncated(err)
+		}
+		name := make([]byte, fixed.NameLen)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return truncated(err)
+		}
+		if mm == nil {
+			return errors.New("save state has memory regions but no memory map")
+		}
+		r := Region{Start: fixed.Start, Size: fixed.Size, Kind: RegionKind(fixed.Kind), Name: string(name)}
+		if err := mm.Map(r); err != nil {
+			return fmt.Errorf("invalid save state: %w", err)
+		}
+	}
+	if mm != nil {
+		if err := binary.Read(br, binary.BigEndian, &mm.IgnoreROMWrites); err != nil {
+			return truncated(err)
+		}
+	}
+	if v.memMap != nil {
+		for _, r := range v.memMap.regions {
+			if r.Kind != RegionDevice {
+				continue
+			}
+			if mm == nil {
+				mm = NewMemoryMap(v.CPU.Mem)
+			}
+			if err := mm.MapDevice(r.Start, r.Size, r.Name, v.memMap.devices[r.Start]); err != nil {
+				return fmt.Errorf("save state conflicts with a device: %w", err)
+			}
+		}
+	}
+
+	var d savedDevices
+	if err := binary.Read(br, binary.BigEndian, &d); err != nil {
+		return truncated(err)
+	}
+	var su savedUART
+	if err := binary.Read(br, binary.BigEndian, &su); err != nil {
+		return truncated(err)
+	}
+	if su.Enabled && (v.uart == nil || v.uart.base != su.Base) {
+		return fmt.Errorf("save state uses a UART at $%08X; enable it there before loading", su.Base)
+	}
+	var st savedTimer
+	if err := binary.Read(br, binary.BigEndian, &st); err != nil {
+		return truncated(err)
+	}
+	if st.Enabled && (v.timer == nil || v.timer.base != st.Base) {
+		return fmt.Errorf("save state uses a timer at $%08X; enable it there before loading", st.Base)
+	}
+	var sb savedBlock
+	if err := binary.Read(br, binary.BigEndian, &sb); err != nil {
+		return truncated(err)
+	}
+	if sb.Enabled && (v.block == nil || v.block.base != sb.Base) {
+		return fmt.Errorf("save state uses a block device at $%08X; enable it there before loading", sb.Base)
+	}
+	var sk savedKeyboard
+	if err := binary.Read(br, binary.BigEndian, &sk); err != nil {
+		return truncated(err)
+	}
+	if sk.Enabled && (v.keyboard == nil || v.keyboard.base != sk.Base) {
+		return fmt.Errorf("save state uses a keyboard at $%08X; enable it there before loading", sk.Base)
+	}
+	if int(sk.Head) >= KeyboardQueueSize || int(sk.Count) > KeyboardQueueSize {
+		return errors.New("save state has a corrupt keyboard queue")
+	}
+	var sa savedAudio
+	var queued uint16
+	if err := binary.Read(br, binary.BigEndian, &sa); err != nil {
+		return truncated(err)
+	}
+	if err := binary.Read(br, binary.BigEndian, &queued); err != nil {
+		return truncated(err)
+	}
+	samples := make([]int16, queued)
+	if err := binary.Read(br, binary.BigEndian, samples); err != nil {
+		return truncated(err)
+	}
+	if sa.Enabled && (v.audio == nil || v.audio.cfg.Base != sa.Base) {
+		return fmt.Errorf("save state uses an audio device at $%08X; enable it there before loading", sa.Base)
+	}
+	if sa.Enabled && len(samples) > len(v.audio.ring) {
+		return fmt.Errorf("save state queues %d audio samples, the buffer holds %d", len(samples), len(v.audio.ring))
+	}
+	var sr savedRTC
+	if err := binary.Read(br, binary.BigEndian, &sr); err != nil {
+		return truncated(err)
+	}
+	if sr.Enabled && (v.rtc == nil || v.rtc.base != sr.Base) {
+		return fmt.Errorf("save state uses a real-time clock at $%08X; enable it there before loading", sr.Base)
+	}
+	var n uint8
+	if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+		return truncated(err)
+	}
+	cores := make([]savedCPU, n)
+	if err := binary.Read(br, binary.BigEndian, cores); err != nil {
+		return truncated(err)
+	}
+	if len(cores) != len(v.cores) {
+		return fmt.Errorf("save state has %d CPUs, the VM has %d", len(cores)+1, len(v.cores)+1)
+	}
+	for i, s := range append([]savedCPU{sc}, cores...) {
+		if _, ok := v.CPUs()[i].Coprocessors[1].(*cpu.FPU); s.FPU.Enabled && !ok {
+			return fmt.Errorf("save state has an FPU on CPU %d; attach one before loading", i)
+		}
+	}
+
+	c := v.CPU
+	restoreCPU(c, sc)
+	copy(c.Mem, mem)
+
+	switch {
+	case mm != nil:
+		v.memMap = mm
+		c.Bus = mm
+	case v.memMap != nil:
+		v.memMap = nil
+		c.Bus = cpu.RAM(c.Mem)
+	}
+	c.FlushCache()
+
+	v.perfEnabled, v.perfBase = d.PerfEnabled, d.PerfBase
+	v.randomEnabled, v.randomBase = d.RandomEnabled, d.RandomBase
+	v.randomSeed, v.randomState = d.RandomSeed, d.RandomState
+	if su.Enabled {
+		u := v.uart
+		u.mu.Lock()
+		u.data, u.control, u.ready = su.Data, su.Control, su.Ready
+		u.update()
+		u.mu.Unlock()
+	}
+	if st.Enabled {
+		t := v.timer
+		t.regs, t.left, t.last, t.line, t.expired = st.Regs, st.Left, st.Last, int(st.Line), st.Expired
+	}
+	if sb.Enabled {
+		b := v.block
+		b.regs = sb.Regs
+		binary.BigEndian.PutUint32(b.regs[BlockSectors:], b.sectors)
+	}
+	if sk.Enabled {
+		k := v.keyboard
+		k.mu.Lock()
+		k.queue, k.head, k.count = sk.Queue, int(sk.Head), int(sk.Count)
+		k.overflow, k.control = sk.Overflow, sk.Control
+		k.update()
+		k.mu.Unlock()
+	}
+	if sa.Enabled {
+		a := v.audio
+		a.regs, a.high, a.last, a.frac, a.line = sa.Regs, sa.High, sa.Last, sa.Frac, sa.Line
+		a.head, a.n = 0, copy(a.ring, samples)
+		a.out = a.out[:0]
+	}
+	c.SetInterruptLines(sc.IRQ)
+	for i, core := range v.cores {
+		restoreCPU(core, cores[i])
+		core.FlushCache()
+		core.SetInterruptLines(cores[i].IRQ)
+	}
+	return nil
+}
+
+// saveCPU returns the registers and counters of c to save.
+func saveCPU(c *cpu.CPU) savedCPU {
+	return savedCPU{
+		D: c.D, A: c.A,
+		PC: c.PC, USP: c.USP, SSP: c.SSP, ISP: c.ISP, VBR: c.VBR, Mask: c.AddressMask,
+		SR:     uint16(c.SR),
+		Cycles: c.Cycles, Instructions: c.Instructions, Exceptions: c.Exceptions,
+		Running: c.Running, Stopped: c.Stopped, Strict: c.StrictAlignment,
+		IRQ:   c.InterruptLines(),
+		Model: uint32(c.Model), SFC: c.SFC, DFC: c.DFC,
+		FPU: saveFPU(c),
+	}
+}
+
+// saveFPU returns the registers of c's FPU to save, if it has one.
+func saveFPU(c *cpu.CPU) savedFPU {
+	f, ok := c.Coprocessors[1].(*cpu.FPU)
+	if !ok {
+		return savedFPU{}
+	}
+	return savedFPU{Enabled: true, FP: f.FP, FPCR: f.FPCR, FPSR: f.FPSR, FPIAR: f.FPIAR, Used: f.Used}
+}
+
+// restoreCPU sets c's model, registers and counters from sc, leaving its
+// interrupt lines for the caller to set once everything else is in place. An
+// FPU the state doesn't have is reset.
+func restoreCPU(c *cpu.CPU, sc savedCPU) {
+	c.SetModel(cpu.Model(sc.Model))
+	c.SFC, c.DFC = sc.SFC, sc.DFC
+	if f, ok := c.Coprocessors[1].(*cpu.FPU); ok {
+		f.Reset()
+		if sc.FPU.Enabled {
+			f.FP, f.FPCR, f.FPSR, f.FPIAR, f.Used = sc.FPU.FP, sc.FPU.FPCR, sc.FPU.FPSR, sc.FPU.FPIAR, sc.FPU.Used
+		}
+	}
+	c.D, c.A = sc.D, sc.A
+	c.PC, c.USP, c.SSP, c.ISP, c.VBR, c.AddressMask = sc.PC, sc.USP, sc.SSP, sc.ISP, sc.VBR, sc.Mask
+	c.SR = cpu.SR(sc.SR)
+	c.Cycles, c.Instructions, c.Exceptions = sc.Cycles, sc.Instructions, sc.Exceptions
+	c.Running, c.Stopped, c.StrictAlignment = sc.Running, sc.Stopped, sc.Strict
+}
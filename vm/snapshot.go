@@ -0,0 +1,110 @@
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// SnapshotRegionSize is the size of the memory regions hashed by Snapshot.
+const SnapshotRegionSize = 0x10000
+
+// Snapshot is the final state of a run, compact enough to keep alongside a
+// corpus of test programs and compare after emulator changes.
+type Snapshot struct {
+	State        State  `json:"state"`
+	Cycles       uint64 `json:"cycles"`
+	Instructions uint64 `json:"instructions"`
+	MemorySize   int    `json:"memory_size"`
+	// Regions maps the start of each memory region that is not all zero to
+	// the SHA-256 of its contents.
+	Regions map[uint32]string `json:"regions"`
+}
+
+// Snapshot captures the registers, counters and hashed memory of the VM.
+func (v *VM) Snapshot() Snapshot {
+	c := v.CPU
+	s := Snapshot{
+		State:        v.State(),
+		Cycles:       c.Cycles,
+		Instructions: c.Instructions,
+		MemorySize:   len(c.Mem),
+		Regions:      make(map[uint32]string),
+	}
+	for start := 0; start < len(c.Mem); start += SnapshotRegionSize {
+		region := c.Mem[start:min(start+SnapshotRegionSize, len(c.Mem))]
+		if isZero(region) {
+			continue
+		}
+		sum := sha256.Sum256(region)
+		s.Regions[uint32(start)] = hex.EncodeToString(sum[:])
+	}
+	return s
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteSnapshot writes s to w as JSON.
+func WriteSnapshot(w io.Writer, s Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return s, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return s, nil
+}
+
+// Diff lists every difference from want to s, one per line. An empty result
+// means the snapshots match.
+func (s Snapshot) Diff(want Snapshot) []string {
+	var diffs []string
+	differ := func(name string, want, got any) {
+		if want != got {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %v, got %v", name, want, got))
+		}
+	}
+
+	for i := range 8 {
+		differ(fmt.Sprintf("D%d", i), hex32(want.State.D[i]), hex32(s.State.D[i]))
+	}
+	for i := range 8 {
+		differ(fmt.Sprintf("A%d", i), hex32(want.State.A[i]), hex32(s.State.A[i]))
+	}
+	differ("PC", hex32(want.State.PC), hex32(s.State.PC))
+	differ("SR", want.State.SR.String(), s.State.SR.String())
+	differ("USP", hex32(want.State.USP), hex32(s.State.USP))
+	differ("SSP", hex32(want.State.SSP), hex32(s.State.SSP))
+	differ("cycles", want.Cycles, s.Cycles)
+	differ("instructions", want.Instructions, s.Instructions)
+	differ("memory size", want.MemorySize, s.MemorySize)
+
+	regions := maps.Clone(want.Regions)
+	maps.Copy(regions, s.Regions)
+	for _, start := range slices.Sorted(maps.Keys(regions)) {
+		if want.Regions[start] != s.Regions[start] {
+			diffs = append(diffs, fmt.Sprintf("memory $%08X-$%08X differs", start, start+SnapshotRegionSize-1))
+		}
+	}
+	return diffs
+}
+
+func hex32(v uint32) string {
+	return fmt.Sprintf("$%08X", v)
+}
@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/radix"
+)
+
+// StateFormat selects how WriteState renders the CPU state.
+type StateFormat int
+
+const (
+	// StateMonitor is the classic multi-line monitor layout.
+	StateMonitor StateFormat = iota
+	// StateCompact puts everything on one line, for traces and logs.
+	StateCompact
+	// StateJSON writes a State as a JSON object.
+	StateJSON
+)
+
+// ParseStateFormat converts "monitor", "compact" or "json" to a StateFormat.
+func ParseStateFormat(s string) (StateFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "monitor":
+		return StateMonitor, nil
+	case "compact":
+		return StateCompact, nil
+	case "json":
+		return StateJSON, nil
+	default:
+		return StateMonitor, fmt.Errorf("unknown state format: %s", s)
+	}
+}
+
+// State is a snapshot of the CPU registers.
+type State struct {
+	D     [8]uint32 `json:"d"`
+	A     [8]uint32 `json:"a"`
+	PC    uint32    `json:"pc"`
+	SR    cpu.SR    `json:"sr"`
+	Flags string    `json:"flags"`
+	USP   uint32    `json:"usp"`
+	SSP   uint32    `json:"ssp"`
+}
+
+// State returns a snapshot of the CPU registers.
+func (v *VM) State() State {
+	return cpuState(v.CPU)
+}
+
+// cpuState returns a snapshot of c's registers.
+func cpuState(c *cpu.CPU) State {
+	usp, ssp := c.StackPointers()
+	return State{
+		D:     c.D,
+		A:     c.A,
+		PC:    c.PC,
+		SR:    c.SR,
+		Flags: c.SR.FlagString(),
+		USP:   usp,
+		SSP:   ssp,
+	}
+}
+
+// WriteState writes the CPU registers to w in the given format.
+func (v *VM) WriteState(w io.Writer, format StateFormat) error {
+	return v.writeState(w, format, v.CPU)
+}
+
+// writeState writes c's registers to w in the given format.
+func (v *VM) writeState(w io.Writer, format StateFormat, c *cpu.CPU) error {
+	s := cpuState(c)
+	switch format {
+	case StateJSON:
+		enc := json.NewEncoder(w)
+		return enc.Encode(s)
+
+	case StateCompact:
+		return s.writeCompact(w, v.Numbers)
+
+	case StateMonitor:
+		n := v.Numbers
+		var sb strings.Builder
+		for i, d := range s.D {
+			fmt.Fprintf(&sb, "D%d %s  ", i, n.Field(d, 8))
+			if i == 3 || i == 7 {
+				sb.WriteString("\n")
+			}
+		}
+		for i, a := range s.A {
+			fmt.Fprintf(&sb, "A%d %s  ", i, n.Field(a, 8))
+			if i == 3 || i == 7 {
+				sb.WriteString("\n")
+			}
+		}
+		// SR.String starts with the four hex digits, which follow the style here.
+		fmt.Fprintf(&sb, "PC %s  SR %s%s  USP %s  SSP %s\n", n.Field(s.PC, 8), n.Field(uint32(s.SR), 4),
+			s.SR.String()[4:], n.Field(s.USP, 8), n.Field(s.SSP, 8))
+		_, err := io.WriteString(w, strings.ReplaceAll(sb.String(), "  \n", "\n"))
+		return err
+
+	default:
+		return fmt.Errorf("unknown state format: %d", format)
+	}
+}
+
+// writeCompact writes s on one line, as for StateCompact, with numbers in the
+// given style.
+func (s State) writeCompact(w io.Writer, n radix.Style) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "PC=%s SR=%s %s D=", n.Field(s.PC, 8), n.Field(uint32(s.SR), 4), s.Flags)
+	writeRegs(&sb, s.D, n)
+	sb.WriteString(" A=")
+	writeRegs(&sb, s.A, n)
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeRegs writes eight registers separated by commas.
+func writeRegs(sb *strings.Builder, regs [8]uint32, n radix.Style) {
+	for i, r := range regs {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(n.Field(r, 8))
+	}
+}
@@ -0,0 +1,419 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+// taint tracks the registers and memory bytes that carry a marked value.
+type taint struct {
+	regs map[string]bool
+	mem  map[uint32]bool
+	out  io.Writer
+}
+
+// TaintEvent describes an instruction that touched a tainted value.
+type TaintEvent struct {
+	// Instruction is the instruction with its operand values before it ran.
+	Instruction LiveInstruction
+	// Reads lists the tainted registers and memory the instruction read.
+	Reads []string
+	// Pointers lists tainted registers used to form an address.
+	Pointers []string
+	// Taints lists the registers and memory the value propagated to.
+	Taints []string
+	// Clears lists tainted locations overwritten with clean values.
+	Clears []string
+}
+
+// String formats the event as a listing line followed by what happened.
+func (e TaintEvent) String() string {
+	line := fmt.Sprintf("%08X  %-8s %s", e.Instruction.Address, e.Instruction.Mnemonic, e.Instruction.Operands)
+	var parts []string
+	if len(e.Reads) > 0 {
+		parts = append(parts, "reads "+strings.Join(e.Reads, " "))
+	}
+	if len(e.Pointers) > 0 {
+		parts = append(parts, "dereferences "+strings.Join(e.Pointers, " "))
+	}
+	if len(e.Taints) > 0 {
+		parts = append(parts, "taints "+strings.Join(e.Taints, " "))
+	}
+	if len(e.Clears) > 0 {
+		parts = append(parts, "clears "+strings.Join(e.Clears, " "))
+	}
+	return fmt.Sprintf("%-48s ; %s", line, strings.Join(parts, ", "))
+}
+
+// EnableTaint starts taint tracking. Every instruction that reads, dereferences
+// or propagates a tainted value is logged to w, which may be nil to only track.
+// Mark the starting points with Taint.
+func (v *VM) EnableTaint(w io.Writer) {
+	if v.taint == nil {
+		v.taint = &taint{regs: map[string]bool{}, mem: map[uint32]bool{}}
+	}
+	v.taint.out = styleWriter{w, v}
+}
+
+// DisableTaint stops taint tracking and forgets all marks.
+func (v *VM) DisableTaint() {
+	v.taint = nil
+}
+
+// Taint marks a register ("d0"-"d7", "a0"-"a7" or "sp") or memory as tainted,
+// enabling tracking without a log if it is off. Memory is a hex address with
+// an optional .b, .w or .l size, defaulting to a long.
+func (v *VM) Taint(spec string) error {
+	if v.taint == nil {
+		v.EnableTaint(nil)
+	}
+
+	name := strings.ToLower(strings.TrimSpace(spec))
+	if reRegister.MatchString(name) {
+		v.taint.regs[regName(name)] = true
+		return nil
+	}
+
+	size := uint32(4)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		s, ok := operandSizes[name[i+1:]]
+		if !ok {
+			return fmt.Errorf("unknown size in %s", spec)
+		}
+		name, size = name[:i], s
+	}
+	addr, err := parseHex(name)
+	if err != nil {
+		return fmt.Errorf("invalid taint location %s: %w", spec, err)
+	}
+	if uint64(addr)+uint64(size) > uint64(len(v.CPU.Mem)) {
+		return fmt.Errorf("taint location $%08X is outside memory", addr)
+	}
+	v.taint.set(location{addr: addr, size: size}, true)
+	return nil
+}
+
+// Tainted lists the tainted registers, then the tainted memory as ranges.
+func (v *VM) Tainted() []string {
+	if v.taint == nil {
+		return nil
+	}
+
+	var list []string
+	for _, prefix := range []string{"d", "a"} {
+		for i := range 8 {
+			if name := fmt.Sprintf("%s%d", prefix, i); v.taint.regs[name] {
+				list = append(list, name)
+			}
+		}
+	}
+
+	addrs := make([]uint32, 0, len(v.taint.mem))
+	for a := range v.taint.mem {
+		addrs = append(addrs, a)
+	}
+	slices.Sort(addrs)
+	for i := 0; i < len(addrs); {
+		j := i
+		for j+1 < len(addrs) && addrs[j+1] == addrs[j]+1 {
+			j++
+		}
+		if i == j {
+			list = append(list, fmt.Sprintf("$%08X", addrs[i]))
+		} else {
+			list = append(list, fmt.Sprintf("$%08X-$%08X", addrs[i], addrs[j]))
+		}
+		i = j + 1
+	}
+	return list
+}
+
+// tainted reports whether any part of a location is tainted.
+func (t *taint) tainted(loc location) bool {
+	if loc.reg != "" {
+		return t.regs[loc.reg]
+	}
+	for i := range loc.size {
+		if t.mem[loc.addr+i] {
+			return true
+		}
+	}
+	return false
+}
+
+// set marks or clears a location.
+func (t *taint) set(loc location, on bool) {
+	if loc.reg != "" {
+		if on {
+			t.regs[loc.reg] = true
+		} else {
+			delete(t.regs, loc.reg)
+		}
+		return
+	}
+	for i := range loc.size {
+		if on {
+			t.mem[loc.addr+i] = true
+		} else {
+			delete(t.mem, loc.addr+i)
+		}
+	}
+}
+
+// operand is a resolved operand and how it is shown in taint events.
+type operand struct {
+	text string
+	loc  location
+}
+
+// String names the operand: a register, or memory with its address.
+func (o operand) String() string {
+	if o.loc.reg != "" {
+		return o.loc.reg
+	}
+	return fmt.Sprintf("%s=$%08X", o.text, o.loc.addr)
+}
+
+// flow is a movement of values performed by an instruction.
+type flow struct {
+	from []operand
+	to   []operand
+	// replace means the destinations only hold values from the sources
+	// afterwards, so clean sources clear them.
+	replace bool
+}
+
+// Instructions that read their operands without writing any of them.
+var taintCompares = map[string]bool{
+	"cmp": true, "cmpa": true, "cmpi": true, "cmpm": true, "tst": true, "btst": true, "chk": true,
+}
+
+// taintStep follows the values moved by the instruction at PC, before it runs.
+func (v *VM) taintStep() {
+	c := v.CPU
+	if uint64(c.PC)+2 > uint64(len(c.Mem)) {
+		return
+	}
+	inst := disassembler.DecodeAt(c.Mem, c.PC)
+	mn, _, _ := strings.Cut(inst.Mnemonic, ".")
+	size := operandSize(inst.Mnemonic)
+	if mn == "moveq" {
+		size = 4
+	}
+
+	var ops []operand
+	var pointers []string
+	ext := firstExtension(inst)
+	for _, text := range splitOperands(inst.Operands) {
+		// Immediates and branch targets keep an empty location, which is
+		// never tainted, so operands stay in place.
+		loc, used, _ := v.operandLocation(text, size, ext)
+		ext += used
+		ops = append(ops, operand{text: text, loc: loc})
+		for _, r := range loc.base {
+			if v.taint.regs[r] && !slices.Contains(pointers, r) {
+				pointers = append(pointers, r)
+			}
+		}
+	}
+
+	e := TaintEvent{Pointers: pointers}
+	if mn == "exg" && len(ops) == 2 {
+		a, b := ops[0], ops[1]
+		ta, tb := v.taint.tainted(a.loc), v.taint.tainted(b.loc)
+		v.taint.set(a.loc, tb)
+		v.taint.set(b.loc, ta)
+		if ta {
+			e.Reads = append(e.Reads, a.String())
+			e.Taints = append(e.Taints, b.String())
+		}
+		if tb {
+			e.Reads = append(e.Reads, b.String())
+			e.Taints = append(e.Taints, a.String())
+		}
+		if ta != tb {
+			if ta {
+				e.Clears = append(e.Clears, a.String())
+			} else {
+				e.Clears = append(e.Clears, b.String())
+			}
+		}
+	} else {
+		for _, f := range v.flows(inst, mn, size, ops) {
+			v.taint.apply(f, size, &e)
+		}
+	}
+
+	if len(e.Reads)+len(e.Pointers)+len(e.Taints)+len(e.Clears) == 0 {
+		return
+	}
+	if v.taint.out != nil {
+		e.Instruction = LiveInstruction{Instruction: inst, Notes: v.operandNotes(inst)}
+		fmt.Fprintln(v.taint.out, e)
+	}
+}
+
+// apply performs one flow, recording what it did in e.
+func (t *taint) apply(f flow, size uint32, e *TaintEvent) {
+	var dirty bool
+	for _, o := range f.from {
+		if t.tainted(o.loc) {
+			dirty = true
+			e.Reads = append(e.Reads, o.String())
+		}
+	}
+	for _, o := range f.to {
+		switch {
+		case dirty:
+			t.set(o.loc, true)
+			e.Taints = append(e.Taints, o.String())
+		case f.replace && t.tainted(o.loc):
+			// A byte or word written to a data register keeps the rest of it.
+			if o.loc.reg != "" && o.loc.reg[0] == 'd' && size < 4 {
+				continue
+			}
+			t.set(o.loc, false)
+			e.Clears = append(e.Clears, o.String())
+		}
+	}
+}
+
+// flows describes how an instruction moves values between its operands.
+func (v *VM) flows(inst *disassembler.Instruction, mn string, size uint32, ops []operand) []flow {
+	c := v.CPU
+	push := func(text string) operand {
+		return operand{text: text, loc: location{addr: c.A[7] - 4, size: 4}}
+	}
+	bases := func(o operand) []operand {
+		var list []operand
+		for _, r := range o.loc.base {
+			list = append(list, operand{text: r, loc: location{reg: r}})
+		}
+		return list
+	}
+
+	switch {
+	case mn == "movem":
+		return v.movemFlows(inst, size)
+	case mn == "jsr" || mn == "bsr":
+		return []flow{{to: []operand{push("-(sp)")}, replace: true}}
+	case mn == "link" && len(ops) == 1:
+		sp := operand{text: "sp", loc: location{reg: "a7"}}
+		return []flow{
+			{from: ops[:1], to: []operand{push("-(sp)")}, replace: true},
+			{from: []operand{sp}, to: ops[:1], replace: true},
+		}
+	case mn == "unlk" && len(ops) == 1:
+		saved := operand{text: "(" + ops[0].text + ")", loc: location{addr: *v.register(ops[0].loc.reg), size: 4}}
+		sp := operand{text: "sp", loc: location{reg: "a7"}}
+		return []flow{
+			{from: ops[:1], to: []operand{sp}, replace: true},
+			{from: []operand{saved}, to: ops[:1], replace: true},
+		}
+	case len(ops) == 0:
+		return nil
+	case mn == "lea" && len(ops) == 2:
+		return []flow{{from: bases(ops[0]), to: ops[1:], replace: true}}
+	case mn == "pea":
+		return []flow{{from: bases(ops[0]), to: []operand{push("-(sp)")}, replace: true}}
+	case mn == "jmp":
+		return nil
+	case taintCompares[mn]:
+		return []flow{{from: ops}}
+	case mn == "clr" || isScc(mn):
+		return []flow{{to: ops[len(ops)-1:], replace: true}}
+	case (mn == "move" || mn == "movea" || mn == "moveq") && len(ops) == 2:
+		return []flow{{from: ops[:1], to: ops[1:], replace: true}}
+	default:
+		// Everything else combines its operands into the last one.
+		return []flow{{from: ops, to: ops[len(ops)-1:]}}
+	}
+}
+
+// movemFlows moves each listed register to or from its slot in memory. The
+// slots run from the lowest address in the order d0-d7, a0-a7.
+func (v *VM) movemFlows(inst *disassembler.Instruction, size uint32) []flow {
+	ops := splitOperands(inst.Operands)
+	if len(ops) != 2 {
+		return nil
+	}
+	load := inst.Op&0x0400 != 0
+	list, ea := ops[0], ops[1]
+	if load {
+		list, ea = ops[1], ops[0]
+	}
+	regs := expandRegisterList(list)
+
+	var start uint32
+	if m := reIndirect.FindStringSubmatch(ea); m != nil && m[1] == "-" {
+		start = *v.register(m[2]) - size*uint32(len(regs))
+	} else {
+		loc, _, ok := v.operandLocation(ea, size, firstExtension(inst))
+		if !ok || loc.reg != "" {
+			return nil
+		}
+		start = loc.addr
+	}
+
+	flows := make([]flow, 0, len(regs))
+	for i, r := range regs {
+		reg := operand{text: r, loc: location{reg: r}}
+		slot := operand{text: ea, loc: location{addr: start + uint32(i)*size, size: size}}
+		if load {
+			flows = append(flows, flow{from: []operand{slot}, to: []operand{reg}, replace: true})
+		} else {
+			flows = append(flows, flow{from: []operand{reg}, to: []operand{slot}, replace: true})
+		}
+	}
+	return flows
+}
+
+// expandRegisterList turns a MOVEM list such as "d0-d3/a0/a6" into register
+// names in the order d0-d7, a0-a7.
+func expandRegisterList(list string) []string {
+	var mask uint16
+	for part := range strings.SplitSeq(list, "/") {
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			last = first
+		}
+		if !reRegister.MatchString(first) || !reRegister.MatchString(last) {
+			continue
+		}
+		lo, hi := regIndex(regName(first)), regIndex(regName(last))
+		for i := lo; i <= hi; i++ {
+			mask |= 1 << i
+		}
+	}
+
+	var regs []string
+	for i := range 16 {
+		if mask&(1<<i) != 0 {
+			regs = append(regs, fmt.Sprintf("%c%d", "da"[i/8], i%8))
+		}
+	}
+	return regs
+}
+
+// regIndex numbers d0-d7 as 0-7 and a0-a7 as 8-15.
+func regIndex(name string) int {
+	n := int(name[1] - '0')
+	if name[0] == 'a' {
+		n += 8
+	}
+	return n
+}
+
+// isScc reports whether mn is a Scc instruction, which writes without reading.
+func isScc(mn string) bool {
+	switch mn {
+	case "st", "sf", "shi", "sls", "scc", "scs", "sne", "seq",
+		"svc", "svs", "spl", "smi", "sge", "slt", "sgt", "sle":
+		return true
+	}
+	return false
+}
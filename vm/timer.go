@@ -0,0 +1,200 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Layout of the timer, as offsets from its base address.
+const (
+	TimerControl = 0x0 // Byte: the control bits below
+	TimerStatus  = 0x1 // Byte: TimerExpired; write it back to clear it
+	TimerLevel   = 0x2 // Byte: interrupt level 1-7, or 0 for none
+	TimerVector  = 0x3 // Byte: interrupt vector, or 0 for the level's autovector
+	TimerPeriod  = 0x4 // Long: clock cycles between expiries
+	TimerCount   = 0x8 // Long: cycles until the next expiry; read-only
+	// TimerSize is the size of the device block in bytes.
+	TimerSize = 0x10
+)
+
+// Bits of the TimerControl register.
+const (
+	// TimerEnable starts the timer counting down from TimerPeriod. Setting
+	// it restarts the count.
+	TimerEnable = 1 << 0
+	// TimerOneShot clears TimerEnable at the first expiry instead of
+	// reloading the period.
+	TimerOneShot = 1 << 1
+	// TimerInterrupt has the timer assert its level while TimerExpired is
+	// set.
+	TimerInterrupt = 1 << 7
+)
+
+// TimerExpired is set in TimerStatus each time the count reaches zero.
+const TimerExpired = 1 << 0
+
+// timer is a countdown timer driven by the CPU's clock cycles, attached to
+// the memory map as a Device.
+type timer struct {
+	base    uint32
+	c       *cpu.CPU
+	regs    [TimerSize]byte
+	left    uint64 // Cycles until the next expiry, while enabled
+	last    uint64 // CPU.Cycles when the count was last brought up to date
+	line    int    // Level asserted, or 0
+	expired uint64 // Expiries so far
+}
+
+// EnableTimer maps a programmable timer at base. Once the guest writes a
+// period and sets TimerEnable, the timer counts the CPU's clock cycles and
+// sets TimerExpired each time the period runs out, reloading it unless
+// TimerOneShot is set. With TimerInterrupt set it asserts the level in
+// TimerLevel, through TimerVector or the autovector, until the handler
+// clears TimerExpired by writing it to TimerStatus. The count is brought up
+// to date by Step before every instruction, so an expiry is seen at the
+// instruction boundary where the real timer would have interrupted. A CPU
+// stopped with nothing but the timer to wake it skips ahead to the expiry.
+func (v *VM) EnableTimer(base uint32) error {
+	if v.timer != nil {
+		return fmt.Errorf("a timer is already mapped at $%08X", v.timer.base)
+	}
+	t := &timer{base: base, c: v.CPU, last: v.CPU.Cycles}
+	if err := v.MemoryMap().MapDevice(base, TimerSize, "timer", t); err != nil {
+		return err
+	}
+	v.timer = t
+	return nil
+}
+
+// TimerExpiries returns how many times the timer has expired, for tests and
+// profiling, or 0 without a timer.
+func (v *VM) TimerExpiries() uint64 {
+	if v.timer == nil {
+		return 0
+	}
+	return v.timer.expired
+}
+
+// period returns the TimerPeriod register.
+func (t *timer) period() uint64 {
+	return uint64(binary.BigEndian.Uint32(t.regs[TimerPeriod:]))
+}
+
+// running reports whether the timer is counting.
+func (t *timer) running() bool {
+	return t.regs[TimerControl]&TimerEnable != 0 && t.period() != 0
+}
+
+// Read8 reads a register.
+func (t *timer) Read8(offset uint32) (uint8, error) {
+	if offset >= TimerCount && offset < TimerCount+4 {
+		var count [4]byte
+		t.advance()
+		if t.running() {
+			binary.BigEndian.PutUint32(count[:], uint32(t.left))
+		}
+		return count[offset-TimerCount], nil
+	}
+	return t.regs[offset], nil
+}
+
+// Write8 writes a register.
+func (t *timer) Write8(offset uint32, v uint8) error {
+	switch {
+	case offset == TimerControl:
+		was := t.running()
+		t.regs[TimerControl] = v
+		if t.running() && (!was || v&TimerEnable != 0) {
+			t.restart()
+		}
+	case offset == TimerStatus:
+		t.regs[TimerStatus] &^= v
+	case offset >= TimerCount:
+		return nil
+	default:
+		t.regs[offset] = v
+		if offset >= TimerPeriod && t.running() {
+			t.restart()
+		}
+	}
+	return t.sync()
+}
+
+// restart loads the period into the count.
+func (t *timer) restart() {
+	t.left, t.last = t.period(), t.c.Cycles
+}
+
+// advance counts down the cycles since the last update, recording any
+// expiries.
+func (t *timer) advance() {
+	elapsed := t.c.Cycles - t.last
+	t.last = t.c.Cycles
+	if !t.running() {
+		return
+	}
+	if elapsed < t.left {
+		t.left -= elapsed
+		return
+	}
+	t.regs[TimerStatus] |= TimerExpired
+	if t.regs[TimerControl]&TimerOneShot != 0 {
+		t.regs[TimerControl] &^= TimerEnable
+		t.expired++
+		return
+	}
+	p := t.period()
+	over := elapsed - t.left
+	t.expired += 1 + over/p
+	t.left = p - over%p
+}
+
+// sync sets the interrupt line to match the status.
+func (t *timer) sync() error {
+	level := int(t.regs[TimerLevel])
+	want := 0
+	if t.regs[TimerControl]&TimerInterrupt != 0 && t.regs[TimerStatus]&TimerExpired != 0 && level >= 1 && level <= 7 {
+		want = level
+	}
+	if t.line != 0 && t.line != want {
+		t.c.ClearInterrupt(t.line)
+	}
+	t.line = want
+	if want == 0 {
+		return nil
+	}
+	vector := int(t.regs[TimerVector])
+	if vector == 0 {
+		vector = cpu.Autovector
+	}
+	if err := t.c.RaiseInterrupt(want, vector); err != nil {
+		return fmt.Errorf("timer: %w", err)
+	}
+	return nil
+}
+
+// mayInterrupt reports whether the timer could still raise its interrupt.
+func (t *timer) mayInterrupt() bool {
+	level := t.regs[TimerLevel]
+	return t.running() && t.regs[TimerControl]&TimerInterrupt != 0 && level >= 1 && level <= 7
+}
+
+// timerMayInterrupt reports whether a timer is mapped and could still raise
+// its interrupt.
+func (v *VM) timerMayInterrupt() bool {
+	return v.timer != nil && v.timer.mayInterrupt()
+}
+
+// updateTimer brings the count up to date and sets the interrupt line.
+func (v *VM) updateTimer() error {
+	t, c := v.timer, v.CPU
+	if c.Stopped && c.PendingInterrupts() == 0 && t.mayInterrupt() && !v.othersMayWake(t) {
+		// Only the timer can wake the CPU, so skip the wait.
+		t.advance()
+		c.Cycles += t.left
+	}
+	t.advance()
+	return t.sync()
+}
@@ -0,0 +1,217 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/radix"
+)
+
+// A trace is the CPU state before each instruction, one StateCompact line per
+// instruction. Traces from other emulators can be compared once converted to
+// that form; ParseState only needs the PC, SR, D and A fields.
+
+// RecordTrace runs up to steps instructions, returning the state before each
+// one. It stops early when the CPU halts or waits with no interrupt to resume
+// it. An execution error ends the trace and is returned with it.
+func (v *VM) RecordTrace(steps int) ([]State, error) {
+	var trace []State
+	v.CPU.Running = true
+	for range steps {
+		if !v.CPU.Running || v.Idle() {
+			break
+		}
+		trace = append(trace, v.State())
+		if err := v.Step(); err != nil {
+			return trace, err
+		}
+	}
+	return trace, nil
+}
+
+// WriteTrace writes a trace in the compact state format.
+func WriteTrace(w io.Writer, trace []State) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range trace {
+		if err := s.writeCompact(bw, radix.Style{}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadTrace reads a trace written by WriteTrace or converted from another
+// emulator. Blank lines and lines starting with "#" or ";" are skipped.
+func ReadTrace(r io.Reader) ([]State, error) {
+	var trace []State
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		s, err := ParseState(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		trace = append(trace, s)
+	}
+	return trace, sc.Err()
+}
+
+// ParseState reads a line in the compact state format. Registers may also be
+// given one at a time, as D0=... or A7=..., and case does not matter.
+func ParseState(line string) (State, error) {
+	var s State
+	var seen bool
+	for _, field := range strings.Fields(line) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue // The flag string
+		}
+		key = strings.ToUpper(key)
+		switch {
+		case key == "PC":
+			v, err := parseTraceHex(value)
+			if err != nil {
+				return State{}, err
+			}
+			s.PC, seen = v, true
+		case key == "SR":
+			v, err := parseTraceHex(value)
+			if err != nil {
+				return State{}, err
+			}
+			s.SR = cpu.SR(v)
+		case key == "D" || key == "A":
+			regs := &s.D
+			if key == "A" {
+				regs = &s.A
+			}
+			list := strings.Split(value, ",")
+			if len(list) != 8 {
+				return State{}, fmt.Errorf("expected 8 registers in %s", field)
+			}
+			for i, r := range list {
+				v, err := parseTraceHex(r)
+				if err != nil {
+					return State{}, err
+				}
+				regs[i] = v
+			}
+		case len(key) == 2 && (key[0] == 'D' || key[0] == 'A') && key[1] >= '0' && key[1] <= '7':
+			v, err := parseTraceHex(value)
+			if err != nil {
+				return State{}, err
+			}
+			if key[0] == 'D' {
+				s.D[key[1]-'0'] = v
+			} else {
+				s.A[key[1]-'0'] = v
+			}
+		}
+	}
+	if !seen {
+		return State{}, fmt.Errorf("no PC in %q", line)
+	}
+	s.Flags = s.SR.FlagString()
+	return s, nil
+}
+
+// parseTraceHex reads a hex register value, with or without a "$" or "0x" prefix.
+func parseTraceHex(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	return uint32(v), nil
+}
+
+// Divergence is the first step at which two traces disagree.
+type Divergence struct {
+	// Step is the index of the differing state; the instruction before it
+	// produced the difference.
+	Step int
+	// A and B are the two states, and Fields names what differs, such as
+	// "PC" or "D3". When one trace ends first, the missing state is zero and
+	// Fields is ["end"].
+	A, B   State
+	Fields []string
+}
+
+// String describes the divergence on one line.
+func (d Divergence) String() string {
+	var diffs []string
+	for _, f := range d.Fields {
+		switch f {
+		case "end":
+			diffs = append(diffs, "one trace ends")
+		case "SR":
+			diffs = append(diffs, fmt.Sprintf("SR %04X %s / %04X %s", uint16(d.A.SR), d.A.SR.FlagString(),
+				uint16(d.B.SR), d.B.SR.FlagString()))
+		default:
+			diffs = append(diffs, fmt.Sprintf("%s %08X / %08X", f, d.A.field(f), d.B.field(f)))
+		}
+	}
+	return fmt.Sprintf("step %d: %s", d.Step, strings.Join(diffs, ", "))
+}
+
+// CompareTraces returns the first step where a and b differ in the PC, SR or
+// a data or address register, or nil if they agree. A trace that is a prefix
+// of the other diverges where it ends.
+func CompareTraces(a, b []State) *Divergence {
+	for i := range max(len(a), len(b)) {
+		if i >= len(a) || i >= len(b) {
+			d := &Divergence{Step: i, Fields: []string{"end"}}
+			if i < len(a) {
+				d.A = a[i]
+			} else {
+				d.B = b[i]
+			}
+			return d
+		}
+		if fields := diffStates(a[i], b[i]); len(fields) > 0 {
+			return &Divergence{Step: i, A: a[i], B: b[i], Fields: fields}
+		}
+	}
+	return nil
+}
+
+// diffStates names the registers that differ between a and b.
+func diffStates(a, b State) []string {
+	var fields []string
+	if a.PC != b.PC {
+		fields = append(fields, "PC")
+	}
+	if a.SR != b.SR {
+		fields = append(fields, "SR")
+	}
+	for i := range 8 {
+		if a.D[i] != b.D[i] {
+			fields = append(fields, fmt.Sprintf("D%d", i))
+		}
+	}
+	for i := range 8 {
+		if a.A[i] != b.A[i] {
+			fields = append(fields, fmt.Sprintf("A%d", i))
+		}
+	}
+	return fields
+}
+
+// field returns a register of s by the name diffStates uses.
+func (s State) field(name string) uint32 {
+	switch {
+	case name == "PC":
+		return s.PC
+	case name[0] == 'D':
+		return s.D[name[1]-'0']
+	default:
+		return s.A[name[1]-'0']
+	}
+}
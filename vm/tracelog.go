@@ -0,0 +1,316 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+)
+
+// TraceFormat selects how EnableTraceLog writes instructions.
+type TraceFormat int
+
+const (
+	// TraceText writes a listing line per instruction: its address, the
+	// instruction words, the disassembly and the registers it changed.
+	TraceText TraceFormat = iota
+	// TraceBinary writes compact records, for long traces. TraceLogReader
+	// reads them back.
+	TraceBinary
+)
+
+// ParseTraceFormat converts "text" or "binary" to a TraceFormat.
+func ParseTraceFormat(s string) (TraceFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "text":
+		return TraceText, nil
+	case "binary":
+		return TraceBinary, nil
+	default:
+		return TraceText, fmt.Errorf("unknown trace format: %s", s)
+	}
+}
+
+// TraceRange is a range of instruction addresses to log, from Start up to but
+// not including End.
+type TraceRange struct {
+	Start, End uint32
+}
+
+// TraceOptions configures a trace log.
+type TraceOptions struct {
+	// Format is the output format.
+	Format TraceFormat
+	// Ranges limits the log to instructions at these addresses. With none,
+	// every instruction is logged.
+	Ranges []TraceRange
+}
+
+// TraceRecord is one logged instruction.
+type TraceRecord struct {
+	// Addr is the address of the instruction and Code its words.
+	Addr uint32
+	Code []byte
+	// Changes lists the registers the instruction changed, with their values
+	// afterwards, in the order D0-D7, A0-A7, SR.
+	Changes []RegisterChange
+}
+
+// RegisterChange is a register's new value.
+type RegisterChange struct {
+	// Name is "D0" to "D7", "A0" to "A7" or "SR".
+	Name  string
+	Value uint32
+}
+
+// String formats the record as a TraceText line.
+func (r TraceRecord) String() string {
+	var words []string
+	for i := 0; i+1 < len(r.Code); i += 2 {
+		words = append(words, fmt.Sprintf("%02X%02X", r.Code[i], r.Code[i+1]))
+	}
+	mn, ops := "?", ""
+	if list := disassembler.DecodeRange(r.Code, r.Addr); len(list) > 0 {
+		mn, ops = list[0].Mnemonic, list[0].Operands
+	}
+	line := fmt.Sprintf("%08X  %-24s %-8s %s", r.Addr, strings.Join(words, " "), mn, ops)
+	if len(r.Changes) == 0 {
+		return line
+	}
+	changes := make([]string, len(r.Changes))
+	for i, c := range r.Changes {
+		width := 8
+		if c.Name == "SR" {
+			width = 4
+		}
+		changes[i] = fmt.Sprintf("%s=%0*X", c.Name, width, c.Value)
+	}
+	return fmt.Sprintf("%-72s ; %s", line, strings.Join(changes, " "))
+}
+
+// traceMagic starts a TraceBinary log. Each record follows as the address
+// (4 bytes), the instruction length and words, a mask of the changed
+// registers (4 bytes: bits 0-7 for D0-D7, 8-15 for A0-A7 and 16 for SR) and
+// the new value of each, 4 bytes apiece. Values are big-endian.
+const traceMagic = "M68TRC1\n"
+
+// traceRegisters is the register file compared before and after each
+// instruction, indexed like the bits of the record mask.
+type traceRegisters [17]uint32
+
+func registersOf(c *cpu.CPU) traceRegisters {
+	var r traceRegisters
+	copy(r[:8], c.D[:])
+	copy(r[8:16], c.A[:])
+	r[16] = uint32(c.SR)
+	return r
+}
+
+// registerName names bit i of the record mask.
+func registerName(i int) string {
+	switch {
+	case i < 8:
+		return fmt.Sprintf("D%d", i)
+	case i < 16:
+		return fmt.Sprintf("A%d", i-8)
+	}
+	return "SR"
+}
+
+// traceLog writes the instructions the CPU executes.
+type traceLog struct {
+	w       *bufio.Writer
+	text    io.Writer
+	opts    TraceOptions
+	err     error
+	logging bool
+	before  traceRegisters
+
+	prevBefore func(pc uint32, opcode uint16)
+	prevAfter  func(pc uint32, inst *cpu.DecodedInstruction)
+}
+
+// EnableTraceLog logs every instruction that completes to w, in the format
+// and address ranges of opts. It uses the CPU's execution hooks, calling any
+// that were already set, so it works however the CPU is run. Call
+// DisableTraceLog to flush the log.
+func (v *VM) EnableTraceLog(w io.Writer, opts TraceOptions) error {
+	if v.traceLog != nil {
+		if err := v.DisableTraceLog(); err != nil {
+			return err
+		}
+	}
+	t := &traceLog{w: bufio.NewWriter(w), opts: opts}
+	t.text = styleWriter{t.w, v}
+	if opts.Format == TraceBinary {
+		if _, err := t.w.WriteString(traceMagic); err != nil {
+			return err
+		}
+	}
+
+	c := v.CPU
+	t.prevBefore, t.prevAfter = c.OnBeforeExecute, c.OnAfterExecute
+	c.OnBeforeExecute = func(pc uint32, opcode uint16) {
+		if t.prevBefore != nil {
+			t.prevBefore(pc, opcode)
+		}
+		t.logging = t.covers(pc)
+		if t.logging {
+			t.before = registersOf(c)
+		}
+	}
+	c.OnAfterExecute = func(pc uint32, inst *cpu.DecodedInstruction) {
+		if t.logging && t.err == nil {
+			t.err = t.write(v.traceRecord(pc, t.before))
+		}
+		t.logging = false
+		if t.prevAfter != nil {
+			t.prevAfter(pc, inst)
+		}
+	}
+	v.traceLog = t
+	return nil
+}
+
+// DisableTraceLog stops logging, restores the hooks that were set before and
+// flushes the log. It returns the first error writing it.
+func (v *VM) DisableTraceLog() error {
+	t := v.traceLog
+	if t == nil {
+		return nil
+	}
+	v.CPU.OnBeforeExecute, v.CPU.OnAfterExecute = t.prevBefore, t.prevAfter
+	v.traceLog = nil
+	if err := t.w.Flush(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// covers reports whether the instruction at pc is logged.
+func (t *traceLog) covers(pc uint32) bool {
+	if len(t.opts.Ranges) == 0 {
+		return true
+	}
+	for _, r := range t.opts.Ranges {
+		if pc >= r.Start && pc < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// traceRecord builds the record of the instruction at pc, which ran with the
+// registers in before.
+func (v *VM) traceRecord(pc uint32, before traceRegisters) TraceRecord {
+	r := TraceRecord{Addr: pc}
+	var code []byte
+	for i := uint32(0); i < 10; i += 2 {
+		w, err := v.CPU.Bus.Read16((pc + i) & v.CPU.AddressMask)
+		if err != nil {
+			break
+		}
+		code = binary.BigEndian.AppendUint16(code, w)
+	}
+	n, err := disassembler.InstructionLength(code)
+	if err != nil {
+		n = min(2, len(code))
+	}
+	r.Code = code[:n]
+
+	after := registersOf(v.CPU)
+	for i := range after {
+		if after[i] != before[i] {
+			r.Changes = append(r.Changes, RegisterChange{Name: registerName(i), Value: after[i]})
+		}
+	}
+	return r
+}
+
+// write adds a record to the log.
+func (t *traceLog) write(r TraceRecord) error {
+	if t.opts.Format == TraceText {
+		_, err := io.WriteString(t.text, r.String()+"\n")
+		return err
+	}
+
+	buf := binary.BigEndian.AppendUint32(nil, r.Addr)
+	buf = append(buf, byte(len(r.Code)))
+	buf = append(buf, r.Code...)
+	var mask uint32
+	for _, c := range r.Changes {
+		mask |= 1 << registerIndex(c.Name)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, mask)
+	for _, c := range r.Changes {
+		buf = binary.BigEndian.AppendUint32(buf, c.Value)
+	}
+	_, err := t.w.Write(buf)
+	return err
+}
+
+// registerIndex is the mask bit of a register named by registerName.
+func registerIndex(name string) int {
+	switch name[0] {
+	case 'D':
+		return int(name[1] - '0')
+	case 'A':
+		return 8 + int(name[1]-'0')
+	}
+	return 16
+}
+
+// TraceLogReader reads a log written in the TraceBinary format.
+type TraceLogReader struct {
+	r *bufio.Reader
+}
+
+// NewTraceLogReader checks the header of a binary trace log and returns a
+// reader for its records.
+func NewTraceLogReader(r io.Reader) (*TraceLogReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return nil, errors.New("not a binary trace log")
+	}
+	return &TraceLogReader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one.
+func (tr *TraceLogReader) Next() (TraceRecord, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(tr.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return TraceRecord{}, fmt.Errorf("truncated trace record: %w", err)
+		}
+		return TraceRecord{}, err
+	}
+	r := TraceRecord{Addr: binary.BigEndian.Uint32(head[:]), Code: make([]byte, head[4])}
+	var mask [4]byte
+	if _, err := io.ReadFull(tr.r, r.Code); err != nil {
+		return TraceRecord{}, fmt.Errorf("truncated trace record: %w", err)
+	}
+	if _, err := io.ReadFull(tr.r, mask[:]); err != nil {
+		return TraceRecord{}, fmt.Errorf("truncated trace record: %w", err)
+	}
+	m := binary.BigEndian.Uint32(mask[:])
+	if m>>17 != 0 {
+		return TraceRecord{}, fmt.Errorf("invalid register mask %08X at $%08X", m, r.Addr)
+	}
+	for m != 0 {
+		i := bits.TrailingZeros32(m)
+		m &^= 1 << i
+		var value [4]byte
+		if _, err := io.ReadFull(tr.r, value[:]); err != nil {
+			return TraceRecord{}, fmt.Errorf("truncated trace record: %w", err)
+		}
+		r.Changes = append(r.Changes, RegisterChange{Name: registerName(i), Value: binary.BigEndian.Uint32(value[:])})
+	}
+	return r, nil
+}
@@ -0,0 +1,254 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Urethramancer/m68k/cpu"
+)
+
+// Layout of the UART, as offsets from its base address. All registers are
+// bytes.
+const (
+	// UARTData returns the received character when read, clearing
+	// UARTRxReady so the next one can arrive, and sends a character when
+	// written.
+	UARTData = 0x0
+	// UARTStatus holds the status bits below. It is read-only.
+	UARTStatus = 0x1
+	// UARTControl holds the control bits below.
+	UARTControl = 0x2
+	// UARTSize is the size of the device block in bytes.
+	UARTSize = 0x4
+)
+
+// Bits of the UARTStatus register.
+const (
+	UARTRxReady = 1 << 0 // A character is waiting in UARTData
+	UARTTxEmpty = 1 << 1 // UARTData can take a character to send; always set
+	UARTEOF     = 1 << 2 // The input has ended and no more characters will arrive
+)
+
+// Bits of the UARTControl register.
+const (
+	// UARTRxInterrupt has the UART assert its interrupt level while
+	// UARTRxReady or UARTEOF is set.
+	UARTRxInterrupt = 1 << 7
+)
+
+// uart is a serial port in the style of a 6850 ACIA, attached to the memory
+// map as a Device. It is the VM's serial console: its registers act when the
+// CPU touches them, so reading UARTData takes the character and writing it
+// sends one, and the guest needs no acknowledgement protocol.
+//
+// Polled I/O waits for a status bit before each transfer:
+//
+//	getc:	btst	#0,UART_STATUS(a0)	; UART_RX_READY
+//		beq.s	getc
+//		move.b	UART_DATA(a0),d0
+//	putc:	btst	#1,UART_STATUS(a0)	; UART_TX_EMPTY
+//		beq.s	putc
+//		move.b	d0,UART_DATA(a0)
+//
+// Interrupt-driven input sets UARTRxInterrupt in UARTControl, and the handler
+// reads UARTData, which drops the interrupt until the next character. Once
+// UARTEOF is set, the handler clears UARTRxInterrupt instead. See
+// examples/uart.asm and examples/echo.asm.
+type uart struct {
+	base  uint32
+	level int
+	cpu   *cpu.CPU
+	out   io.Writer
+
+	mu      sync.Mutex
+	changed *sync.Cond // Signalled when the receiver or control register changes
+	data    uint8
+	ready   bool
+	eof     bool
+	control uint8
+
+	monitor    *io.PipeWriter // Feeds the monitor sharing the line, if any
+	monitoring bool           // Input goes to the monitor, not the receiver
+}
+
+// EnableUART maps a UART at base in the memory map that reads in and writes
+// out, interrupting at level 1-7 with its autovector when enabled. in is read
+// from a goroutine, a character at a time as the guest takes them, so a
+// terminal can be used without blocking the VM. When the CPU is stopped
+// waiting for the UART's interrupt, Step blocks until the next character
+// arrives instead of spinning. The goroutine ends with the input.
+func (v *VM) EnableUART(base uint32, level int, in io.Reader, out io.Writer) error {
+	if level < 1 || level > 7 {
+		return fmt.Errorf("UART interrupt level %d is not 1-7", level)
+	}
+	if v.uart != nil {
+		return fmt.Errorf("a UART is already mapped at $%08X", v.uart.base)
+	}
+	u := &uart{base: base, level: level, cpu: v.CPU, out: out}
+	u.changed = sync.NewCond(&u.mu)
+	if err := v.MemoryMap().MapDevice(base, UARTSize, "UART", u); err != nil {
+		return err
+	}
+	v.uart = u
+	go u.receive(in)
+	return nil
+}
+
+// receive feeds in to the receiver a character at a time, or to the monitor
+// while it has the line.
+func (u *uart) receive(in io.Reader) {
+	br := bufio.NewReader(in)
+	for {
+		b, err := br.ReadByte()
+		u.mu.Lock()
+		if err != nil {
+			u.eof = true
+			u.update()
+			if u.monitor != nil {
+				u.monitor.Close()
+			}
+			u.mu.Unlock()
+			return
+		}
+		for u.ready && !u.monitoring {
+			u.changed.Wait()
+		}
+		if u.monitoring {
+			w := u.monitor
+			u.mu.Unlock()
+			w.Write([]byte{b})
+			continue
+		}
+		u.data, u.ready = b, true
+		u.update()
+		u.mu.Unlock()
+	}
+}
+
+// NewUARTMonitor returns a monitor on the UART's line, as on a single-board
+// computer: it reads commands from the UART's input and writes to its
+// output. While GO or T runs the program, the input goes to the receiver
+// instead, so the program and the monitor share the line. The monitor ends
+// with the input.
+func (v *VM) NewUARTMonitor() (*Monitor, error) {
+	u := v.uart
+	if u == nil {
+		return nil, errors.New("no UART is mapped")
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.monitor != nil {
+		return nil, errors.New("a monitor is already attached to the UART")
+	}
+	r, w := io.Pipe()
+	u.monitor, u.monitoring = w, true
+	if u.eof {
+		w.Close()
+	}
+	u.changed.Broadcast()
+	m := v.NewMonitor(r, u.out)
+	m.uart = u
+	return m, nil
+}
+
+// setMonitoring gives the input to the monitor, or back to the receiver.
+func (u *uart) setMonitoring(on bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.monitoring = on
+	u.changed.Broadcast()
+}
+
+// update sets the interrupt line to match the status and signals any waiters.
+// The caller holds the lock.
+func (u *uart) update() {
+	if u.control&UARTRxInterrupt != 0 && (u.ready || u.eof) {
+		u.cpu.RaiseInterrupt(u.level, cpu.Autovector)
+	} else {
+		u.cpu.ClearInterrupt(u.level)
+	}
+	u.changed.Broadcast()
+}
+
+// Read8 reads a register.
+func (u *uart) Read8(offset uint32) (uint8, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch offset {
+	case UARTData:
+		b := u.data
+		if u.ready {
+			u.ready = false
+			u.update()
+		}
+		return b, nil
+	case UARTStatus:
+		return u.status(), nil
+	case UARTControl:
+		return u.control, nil
+	}
+	return 0, nil
+}
+
+// Write8 writes a register.
+func (u *uart) Write8(offset uint32, v uint8) error {
+	switch offset {
+	case UARTData:
+		if _, err := u.out.Write([]byte{v}); err != nil {
+			return fmt.Errorf("UART output failed: %w", err)
+		}
+	case UARTControl:
+		u.mu.Lock()
+		u.control = v
+		u.update()
+		u.mu.Unlock()
+	}
+	return nil
+}
+
+// status returns the UARTStatus register. The caller holds the lock.
+func (u *uart) status() uint8 {
+	s := uint8(UARTTxEmpty)
+	if u.ready {
+		s |= UARTRxReady
+	}
+	if u.eof {
+		s |= UARTEOF
+	}
+	return s
+}
+
+// mayInterrupt reports whether the UART could still raise its interrupt.
+func (u *uart) mayInterrupt() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !u.eof && u.control&UARTRxInterrupt != 0
+}
+
+// uartMayInterrupt reports whether a UART is mapped and could still raise its
+// interrupt.
+func (v *VM) uartMayInterrupt() bool {
+	return v.uart != nil && v.uart.mayInterrupt()
+}
+
+// wait blocks while the receiver is empty, more input may come and the
+// interrupt is enabled, for a CPU stopped with nothing else to wake it. It
+// returns early once ctx is done, which wake signals.
+func (u *uart) wait(ctx context.Context) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for !u.ready && !u.eof && u.control&UARTRxInterrupt != 0 && ctx.Err() == nil {
+		u.changed.Wait()
+	}
+}
+
+// wake signals any waiter to check its conditions again.
+func (u *uart) wake() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.changed.Broadcast()
+}
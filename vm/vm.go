@@ -0,0 +1,227 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/Urethramancer/m68k/cpu"
+	"github.com/Urethramancer/m68k/disassembler"
+	"github.com/Urethramancer/m68k/radix"
+)
+
+// VM is a virtual machine wrapping a CPU and its memory, and any further CPUs
+// added with AddCPU.
+type VM struct {
+	// CPU is the processor running the loaded code, and the first of CPUs.
+	CPU *cpu.CPU
+	// Symbols names addresses for the monitor, which labels them in listings
+	// and accepts the names wherever it takes an address.
+	Symbols disassembler.Symbols
+	// Numbers is the style of the numbers in register dumps, the monitor and
+	// the taint log.
+	Numbers radix.Style
+
+	perfBase    uint32
+	perfEnabled bool
+
+	randomBase    uint32
+	randomEnabled bool
+	randomSeed    uint32
+	randomState   uint32
+
+	taint    *taint
+	traceLog *traceLog
+	uart     *uart
+	keyboard *keyboard
+	timer    *timer
+	audio    *Audio
+	rtc      *rtc
+	cores    []*cpu.CPU // CPUs added with AddCPU
+	fb       *Framebuffer
+	block    *block
+	runStats *runStats
+
+	memMap *MemoryMap
+
+	// runCtx is the context of the running Run, which ends device waits.
+	runCtx context.Context
+
+	started        time.Time
+	metricsEnabled bool
+	published      atomic.Pointer[Metrics]
+}
+
+// New creates a VM with memsize bytes of RAM and an instruction cache holding cachesize entries.
+func New(memsize, cachesize int) *VM {
+	return &VM{
+		CPU:     cpu.New(memsize, cachesize),
+		started: time.Now(),
+	}
+}
+
+// LoadCode copies code into memory at the given address. Anything past the
+// end of memory is dropped.
+func (v *VM) LoadCode(addr uint32, code []byte) {
+	if uint64(addr) < uint64(len(v.CPU.Mem)) {
+		copy(v.CPU.Mem[addr:], code)
+	}
+}
+
+// styleWriter rewrites the numbers in everything written through it in the
+// VM's current style.
+type styleWriter struct {
+	w io.Writer
+	v *VM
+}
+
+// Write applies the style to p, which should hold whole lines.
+func (sw styleWriter) Write(p []byte) (int, error) {
+	if sw.v.Numbers == (radix.Style{}) {
+		return sw.w.Write(p)
+	}
+	if _, err := io.WriteString(sw.w, sw.v.Numbers.Apply(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// RaiseInterrupt asserts interrupt level 1-7 for a device, supplying vector or
+// cpu.Autovector. It is safe to call while another goroutine runs the VM.
+func (v *VM) RaiseInterrupt(level, vector int) error {
+	return v.CPU.RaiseInterrupt(level, vector)
+}
+
+// ClearInterrupt deasserts interrupt level 1-7.
+func (v *VM) ClearInterrupt(level int) {
+	v.CPU.ClearInterrupt(level)
+}
+
+// OnTrap makes TRAP #n (0-15) run fn on the host instead of vectoring into
+// the program, for building system calls such as file or network access out
+// of Go code. fn sees the CPU as it is after the TRAP instruction, with the PC
+// on the next one, and takes its arguments from and returns its results in
+// the registers or memory. An error from fn fails the instruction. A nil fn
+// removes the handler, so the TRAP takes its exception again (or halts, for
+// TRAP #15).
+func (v *VM) OnTrap(n int, fn func(*cpu.CPU) error) error {
+	if n < 0 || n >= len(v.CPU.HostTraps) {
+		return fmt.Errorf("trap %d is not 0-%d", n, len(v.CPU.HostTraps)-1)
+	}
+	v.CPU.HostTraps[n] = fn
+	return nil
+}
+
+// OnLineTrap makes the line 1010 or line 1111 opcode run fn on the host
+// instead of its emulator exception, the way classic Mac OS and other
+// systems make their calls. fn sees the CPU with the PC just past the opcode,
+// reads and skips any words that follow, and returns its results in the
+// registers or memory. An error from fn fails the instruction. A nil fn
+// removes the handler. An opcode that is an instruction, such as MOVE16 or
+// one for an attached FPU, runs as usual.
+func (v *VM) OnLineTrap(opcode uint16, fn func(*cpu.CPU) error) error {
+	if opcode>>12 != 0xA && opcode>>12 != 0xF {
+		return fmt.Errorf("$%04X is not a line 1010 or line 1111 opcode", opcode)
+	}
+	if fn == nil {
+		delete(v.CPU.LineTraps, opcode)
+		return nil
+	}
+	if v.CPU.LineTraps == nil {
+		v.CPU.LineTraps = make(map[uint16]func(*cpu.CPU) error)
+	}
+	v.CPU.LineTraps[opcode] = fn
+	return nil
+}
+
+// Step executes a single instruction after refreshing any memory-mapped state.
+// With several CPUs, it runs on the one furthest behind, as described at
+// AddCPU.
+func (v *VM) Step() error {
+	if v.perfEnabled {
+		v.updatePerfCounters()
+	}
+	if v.randomEnabled {
+		v.updateRandom()
+	}
+	if v.timer != nil {
+		if err := v.updateTimer(); err != nil {
+			return err
+		}
+	}
+	if v.audio != nil {
+		if err := v.updateAudio(); err != nil {
+			return err
+		}
+	}
+	if v.fb != nil {
+		if err := v.updateFramebuffer(); err != nil {
+			return err
+		}
+	}
+	if n := v.nextCPU(); n > 0 {
+		return v.stepCore(n)
+	}
+	if v.uart != nil && v.CPU.Stopped && v.CPU.PendingInterrupts() == 0 && !v.othersMayWake(v.uart) {
+		// Nothing else can wake the CPU, so wait for the input.
+		v.uart.wait(v.waitContext())
+	}
+	if v.keyboard != nil && v.CPU.Stopped && v.CPU.PendingInterrupts() == 0 && !v.othersMayWake(v.keyboard) {
+		v.keyboard.wait(v.waitContext())
+	}
+	if v.taint != nil {
+		v.taintStep()
+	}
+	err := v.CPU.Execute()
+	if v.runStats != nil {
+		v.sampleStacks()
+	}
+	if v.metricsEnabled && v.CPU.Instructions%MetricsInterval == 0 {
+		v.UpdateMetrics()
+	}
+	return err
+}
+
+// Idle reports whether the CPU is stopped and nothing can resume it: no
+// interrupt is asserted, no device is waiting for input that could raise
+// one, and no other CPU is still at work.
+func (v *VM) Idle() bool {
+	return v.CPU.Idle() && !v.othersMayWake(nil)
+}
+
+// othersMayWake reports whether anything but except, the device asking (or
+// nil), could still wake a stopped CPU: a device that may raise its interrupt
+// or another CPU still at work. Devices check it before waiting for input or
+// skipping ahead on behalf of a stopped CPU.
+func (v *VM) othersMayWake(except any) bool {
+	devices := []struct {
+		dev any
+		may func() bool
+	}{
+		{v.uart, v.uartMayInterrupt},
+		{v.timer, v.timerMayInterrupt},
+		{v.keyboard, v.keyboardMayInterrupt},
+		{v.audio, v.audioMayInterrupt},
+	}
+	for _, d := range devices {
+		if d.dev != except && d.may() {
+			return true
+		}
+	}
+	return v.coresBusy()
+}
+
+// DumpCacheStats logs the instruction cache counters.
+func (v *VM) DumpCacheStats() {
+	s := v.CPU.CacheStats()
+	total := s.Hits + s.Misses
+	var ratio float64
+	if total > 0 {
+		ratio = float64(s.Hits) * 100 / float64(total)
+	}
+	log.Printf("Cache: %d/%d entries, %d hits, %d misses (%.1f%% hit rate), %d invalidations, %d evictions",
+		s.Entries, s.Capacity, s.Hits, s.Misses, ratio, s.Invalidations, s.Evictions)
+}